@@ -7,9 +7,11 @@ import (
 	"os"
 	"time"
 
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlalert"
 	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlinsert"
 	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlselect"
 	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlstreamaggr"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/buildinfo"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/envflag"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
@@ -45,6 +47,8 @@ func main() {
 	vlstorage.Init()
 	vlselect.Init()
 	vlinsert.Init()
+	vlstreamaggr.Init()
+	vlalert.Init()
 
 	go httpserver.Serve(listenAddrs, useProxyProtocol, requestHandler)
 	logger.Infof("started VictoriaLogs in %.3f seconds; see https://docs.victoriametrics.com/victorialogs/", time.Since(startTime).Seconds())
@@ -61,6 +65,8 @@ func main() {
 	}
 	logger.Infof("successfully shut down the webservice in %.3f seconds", time.Since(startTime).Seconds())
 
+	vlalert.Stop()
+	vlstreamaggr.Stop()
 	vlinsert.Stop()
 	vlselect.Stop()
 	vlstorage.Stop()
@@ -92,6 +98,15 @@ func requestHandler(w http.ResponseWriter, r *http.Request) bool {
 	if vlselect.RequestHandler(w, r) {
 		return true
 	}
+	if vlstorage.RequestHandler(w, r) {
+		return true
+	}
+	if vlstreamaggr.RequestHandler(w, r) {
+		return true
+	}
+	if vlalert.RequestHandler(w, r) {
+		return true
+	}
 	return false
 }
 
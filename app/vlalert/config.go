@@ -0,0 +1,226 @@
+package vlalert
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/envtemplate"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs/fscore"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promutils"
+)
+
+// Config is a configuration for a single alerting rule.
+//
+// See https://docs.victoriametrics.com/victorialogs/#alerting
+type Config struct {
+	// Name is the name of the rule.
+	//
+	// It is used as `alertname` label for the generated alerts.
+	Name string `yaml:"name"`
+
+	// Query is LogsQL query, which must end with `stats` pipe.
+	//
+	// Every result returned by the query with the applied Condition is an active alert.
+	// The alert is identified by the values of `by (...)` fields from the `stats` pipe.
+	Query string `yaml:"query"`
+
+	// Condition is LogsQL filter, which is applied to the Query results via `filter` pipe.
+	//
+	// For example, `errors:>100` selects results with the `errors` field bigger than 100.
+	Condition string `yaml:"condition"`
+
+	// Interval is the interval between rule evaluations.
+	Interval string `yaml:"interval"`
+
+	// Window is the duration of the sliding time window for the Query. By default it is equal to Interval.
+	//
+	// Every evaluation processes log entries on the [now-Window ... now) time range.
+	Window string `yaml:"window,omitempty"`
+
+	// For is an optional duration the alert must remain active before it becomes firing.
+	For string `yaml:"for,omitempty"`
+
+	// Tenant is an optional tenant in the form `accountID:projectID` to run the Query at. By default it is 0:0.
+	Tenant string `yaml:"tenant,omitempty"`
+
+	// Labels are optional labels to add to the generated alerts.
+	Labels map[string]string `yaml:"labels,omitempty"`
+
+	// Annotations are optional annotations to add to the generated alerts.
+	//
+	// Annotations may contain Go templates with `$labels` and `$values` variables, which contain alert labels and query results.
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+var ruleNameRegexp = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+func loadFromFile(path string) ([]*rule, error) {
+	data, err := fscore.ReadFileOrHTTP(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load alerting rules: %w", err)
+	}
+	data, err = envtemplate.ReplaceBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot expand environment variables: %w", err)
+	}
+	return loadFromData(data)
+}
+
+func loadFromData(data []byte) ([]*rule, error) {
+	var cfgs []*Config
+	if err := yaml.UnmarshalStrict(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("cannot parse alerting rules: %w", err)
+	}
+
+	rules := make([]*rule, 0, len(cfgs))
+	names := make(map[string]struct{}, len(cfgs))
+	for i, cfg := range cfgs {
+		r, err := newRule(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("cannot initialize rule #%d: %w", i+1, err)
+		}
+		if _, ok := names[r.name]; ok {
+			return nil, fmt.Errorf("duplicate rule name %q", r.name)
+		}
+		names[r.name] = struct{}{}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func newRule(cfg *Config) (*rule, error) {
+	if !ruleNameRegexp.MatchString(cfg.Name) {
+		return nil, fmt.Errorf("name %q must match %s", cfg.Name, ruleNameRegexp)
+	}
+
+	if cfg.Query == "" {
+		return nil, fmt.Errorf("missing query for the rule %q", cfg.Name)
+	}
+	q, err := logstorage.ParseQuery(cfg.Query)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse query for the rule %q: %w", cfg.Name, err)
+	}
+	labelFields, err := q.GetStatsByFields()
+	if err != nil {
+		return nil, fmt.Errorf("unsupported query for the rule %q: %w", cfg.Name, err)
+	}
+	for _, f := range labelFields {
+		if f == "_time" {
+			return nil, fmt.Errorf("the query for the rule %q cannot group results by _time; use window option instead", cfg.Name)
+		}
+	}
+
+	if cfg.Condition == "" {
+		return nil, fmt.Errorf("missing condition for the rule %q", cfg.Name)
+	}
+	// Apply the condition to the query results via `filter` pipe.
+	qStr := q.String() + " | filter " + cfg.Condition
+	q, err = logstorage.ParseQuery(qStr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse condition for the rule %q: %w", cfg.Name, err)
+	}
+
+	if cfg.Interval == "" {
+		return nil, fmt.Errorf("missing interval for the rule %q", cfg.Name)
+	}
+	interval, err := promutils.ParseDuration(cfg.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse interval for the rule %q: %w", cfg.Name, err)
+	}
+	if interval < time.Second {
+		return nil, fmt.Errorf("interval for the rule %q cannot be smaller than 1s; got %s", cfg.Name, cfg.Interval)
+	}
+
+	window := interval
+	if cfg.Window != "" {
+		window, err = promutils.ParseDuration(cfg.Window)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse window for the rule %q: %w", cfg.Name, err)
+		}
+		if window <= 0 {
+			return nil, fmt.Errorf("window for the rule %q must be positive; got %s", cfg.Name, cfg.Window)
+		}
+	}
+
+	var forDuration time.Duration
+	if cfg.For != "" {
+		forDuration, err = promutils.ParseDuration(cfg.For)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse for option for the rule %q: %w", cfg.Name, err)
+		}
+		if forDuration < 0 {
+			return nil, fmt.Errorf("for option for the rule %q cannot be negative; got %s", cfg.Name, cfg.For)
+		}
+	}
+
+	tenantID, err := logstorage.ParseTenantID(cfg.Tenant)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse tenant for the rule %q: %w", cfg.Name, err)
+	}
+
+	annotations := make([]annotationTemplate, 0, len(cfg.Annotations))
+	for name, text := range cfg.Annotations {
+		t, err := newAnnotationTemplate(name, text)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse annotation %q for the rule %q: %w", name, cfg.Name, err)
+		}
+		annotations = append(annotations, annotationTemplate{
+			name: name,
+			t:    t,
+		})
+	}
+	sort.Slice(annotations, func(i, j int) bool {
+		return annotations[i].name < annotations[j].name
+	})
+
+	r := &rule{
+		name:        cfg.Name,
+		q:           q,
+		labelFields: labelFields,
+		interval:    interval,
+		window:      window,
+		forDuration: forDuration,
+		tenantID:    tenantID,
+		labels:      cfg.Labels,
+		annotations: annotations,
+	}
+	r.init()
+	return r, nil
+}
+
+// annotationTemplate is a template for the alert annotation with the given name.
+type annotationTemplate struct {
+	name string
+	t    *template.Template
+}
+
+// annotationTemplateData is the data passed to annotationTemplate.
+type annotationTemplateData struct {
+	Labels map[string]string
+	Values map[string]string
+}
+
+func newAnnotationTemplate(name, text string) (*template.Template, error) {
+	// Define $labels and $values variables, so they could be used in the same way as in Prometheus templates.
+	text = "{{ $labels := .Labels }}{{ $values := .Values }}" + text
+	return template.New(name).Option("missingkey=zero").Parse(text)
+}
+
+func executeAnnotationTemplate(t *template.Template, labels, values map[string]string) (string, error) {
+	var sb strings.Builder
+	data := &annotationTemplateData{
+		Labels: labels,
+		Values: values,
+	}
+	if err := t.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,201 @@
+package vlalert
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+func TestLoadFromDataSuccess(t *testing.T) {
+	data := `
+- name: TooManyErrors
+  query: 'level:error | stats by (service) count() errors'
+  condition: 'errors:>100'
+  interval: 1m
+  window: 5m
+  for: 2m
+  tenant: "12:34"
+  labels:
+    severity: critical
+  annotations:
+    summary: 'too many errors for {{ $labels.service }}: {{ $values.errors }}'
+- name: NoLogs
+  query: '* | stats count() hits'
+  condition: 'hits:=0'
+  interval: 30s
+`
+	rules, err := loadFromData([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("unexpected number of rules; got %d; want 2", len(rules))
+	}
+
+	r := rules[0]
+	tenantID := logstorage.TenantID{
+		AccountID: 12,
+		ProjectID: 34,
+	}
+	if r.name != "TooManyErrors" || r.interval != time.Minute || r.window != 5*time.Minute || r.forDuration != 2*time.Minute || r.tenantID != tenantID {
+		t.Fatalf("unexpected rule #1: name=%q, interval=%s, window=%s, for=%s, tenant=%s", r.name, r.interval, r.window, r.forDuration, &r.tenantID)
+	}
+	if !reflect.DeepEqual(r.labelFields, []string{"service"}) {
+		t.Fatalf("unexpected labelFields for rule #1: %q", r.labelFields)
+	}
+	qExpected := `level:error | stats by (service) count(*) as errors | filter errors:>100`
+	if q := r.q.String(); q != qExpected {
+		t.Fatalf("unexpected query for rule #1\ngot\n%s\nwant\n%s", q, qExpected)
+	}
+	if len(r.annotations) != 1 || r.annotations[0].name != "summary" {
+		t.Fatalf("unexpected annotations for rule #1: %v", r.annotations)
+	}
+
+	r = rules[1]
+	if r.interval != 30*time.Second || r.window != 30*time.Second || r.forDuration != 0 || r.tenantID != (logstorage.TenantID{}) {
+		t.Fatalf("unexpected rule #2: interval=%s, window=%s, for=%s, tenant=%s", r.interval, r.window, r.forDuration, &r.tenantID)
+	}
+	if len(r.labelFields) != 0 {
+		t.Fatalf("unexpected labelFields for rule #2: %q", r.labelFields)
+	}
+}
+
+func TestLoadFromDataFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		_, err := loadFromData([]byte(data))
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// invalid yaml
+	f(`foo`)
+
+	// unknown field
+	f(`
+- name: foo
+  query: '* | stats count() hits'
+  condition: 'hits:>0'
+  interval: 1m
+  bar: baz
+`)
+
+	// invalid name
+	f(`
+- query: '* | stats count() hits'
+  condition: 'hits:>0'
+  interval: 1m
+`)
+	f(`
+- name: foo-bar
+  query: '* | stats count() hits'
+  condition: 'hits:>0'
+  interval: 1m
+`)
+
+	// duplicate names
+	f(`
+- name: foo
+  query: '* | stats count() hits'
+  condition: 'hits:>0'
+  interval: 1m
+- name: foo
+  query: '* | stats count() hits'
+  condition: 'hits:>10'
+  interval: 5m
+`)
+
+	// missing query
+	f(`
+- name: foo
+  condition: 'hits:>0'
+  interval: 1m
+`)
+
+	// query without stats pipe
+	f(`
+- name: foo
+  query: 'error | fields _msg'
+  condition: 'hits:>0'
+  interval: 1m
+`)
+
+	// query with _time grouping
+	f(`
+- name: foo
+  query: '* | stats by (_time:1m) count() hits'
+  condition: 'hits:>0'
+  interval: 1m
+`)
+
+	// missing condition
+	f(`
+- name: foo
+  query: '* | stats count() hits'
+  interval: 1m
+`)
+
+	// invalid condition
+	f(`
+- name: foo
+  query: '* | stats count() hits'
+  condition: 'hits:>0 |'
+  interval: 1m
+`)
+
+	// missing interval
+	f(`
+- name: foo
+  query: '* | stats count() hits'
+  condition: 'hits:>0'
+`)
+
+	// too small interval
+	f(`
+- name: foo
+  query: '* | stats count() hits'
+  condition: 'hits:>0'
+  interval: 100ms
+`)
+
+	// invalid window
+	f(`
+- name: foo
+  query: '* | stats count() hits'
+  condition: 'hits:>0'
+  interval: 1m
+  window: bar
+`)
+
+	// invalid for
+	f(`
+- name: foo
+  query: '* | stats count() hits'
+  condition: 'hits:>0'
+  interval: 1m
+  for: bar
+`)
+
+	// invalid tenant
+	f(`
+- name: foo
+  query: '* | stats count() hits'
+  condition: 'hits:>0'
+  interval: 1m
+  tenant: bar
+`)
+
+	// invalid annotation template
+	f(`
+- name: foo
+  query: '* | stats count() hits'
+  condition: 'hits:>0'
+  interval: 1m
+  annotations:
+    summary: '{{ $labels.foo '
+`)
+}
@@ -0,0 +1,176 @@
+package vlalert
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+var (
+	configPath = flag.String("alert.config", "", "Optional path to file with alerting rules for logs. "+
+		"The rules contain LogsQL stats queries, which are periodically evaluated over the sliding time window. "+
+		"Notifications for firing alerts are sent to -alert.alertmanagerURL and -alert.webhookURL; "+
+		"see https://docs.victoriametrics.com/victorialogs/#alerting")
+	alertmanagerURLs = flagutil.NewArrayString("alert.alertmanagerURL", "Optional Alertmanager URL to send alerts generated by -alert.config to. "+
+		"For example, http://alertmanager:9093")
+	webhookURLs = flagutil.NewArrayString("alert.webhookURL", "Optional webhook URL to send notifications about firing and resolved alerts "+
+		"generated by -alert.config to. Notifications are sent as JSON via POST requests")
+	notifyTimeout = flag.Duration("alert.notifyTimeout", 10*time.Second, "Timeout for sending notifications to -alert.alertmanagerURL and -alert.webhookURL")
+)
+
+var (
+	rules []*rule
+
+	rulesWG     sync.WaitGroup
+	rulesCtx    context.Context
+	rulesCancel func()
+)
+
+// Init initializes alerting rules from -alert.config.
+//
+// It must be called after vlstorage.Init().
+func Init() {
+	if *configPath == "" {
+		return
+	}
+
+	rs, err := loadFromFile(*configPath)
+	if err != nil {
+		logger.Fatalf("cannot load -alert.config=%q: %s", *configPath, err)
+	}
+	rules = rs
+
+	var ns []*notifier
+	for _, u := range *alertmanagerURLs {
+		ns = append(ns, newNotifier(notifierAlertmanager, u, *notifyTimeout))
+	}
+	for _, u := range *webhookURLs {
+		ns = append(ns, newNotifier(notifierWebhook, u, *notifyTimeout))
+	}
+	if len(ns) == 0 {
+		logger.Warnf("-alert.alertmanagerURL and -alert.webhookURL are missing, so alerts generated by -alert.config=%q are available only at /alert/rules", *configPath)
+	}
+
+	rulesCtx, rulesCancel = context.WithCancel(context.Background())
+	for _, r := range rules {
+		rulesWG.Add(1)
+		go func(r *rule) {
+			defer rulesWG.Done()
+			r.run(rulesCtx, ns)
+		}(r)
+	}
+	logger.Infof("started %d alerting rules from -alert.config=%q", len(rules), *configPath)
+}
+
+// Stop stops alerting rules.
+//
+// It must be called before vlstorage.Stop().
+func Stop() {
+	if rulesCancel == nil {
+		return
+	}
+	rulesCancel()
+	rulesWG.Wait()
+	rules = nil
+}
+
+// RequestHandler handles alerting requests for VictoriaLogs
+func RequestHandler(w http.ResponseWriter, r *http.Request) bool {
+	path := strings.ReplaceAll(r.URL.Path, "//", "/")
+
+	switch path {
+	case "/alert/rules":
+		alertRulesRequests.Inc()
+		processRules(w)
+		return true
+	default:
+		return false
+	}
+}
+
+var alertRulesRequests = metrics.NewCounter(`vl_http_requests_total{path="/alert/rules"}`)
+
+// ruleStatusJSON is JSON representation for the rule status returned from /alert/rules.
+type ruleStatusJSON struct {
+	Name           string            `json:"name"`
+	Query          string            `json:"query"`
+	Interval       string            `json:"interval"`
+	Window         string            `json:"window"`
+	For            string            `json:"for"`
+	Tenant         string            `json:"tenant"`
+	LastEvaluation string            `json:"last_evaluation,omitempty"`
+	LastError      string            `json:"last_error,omitempty"`
+	Alerts         []alertStatusJSON `json:"alerts"`
+}
+
+// alertStatusJSON is JSON representation for the active alert returned from /alert/rules.
+type alertStatusJSON struct {
+	State       string            `json:"state"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Values      map[string]string `json:"values,omitempty"`
+	ActiveAt    string            `json:"active_at"`
+}
+
+func processRules(w http.ResponseWriter) {
+	statuses := make([]ruleStatusJSON, len(rules))
+	for i, r := range rules {
+		rs := ruleStatusJSON{
+			Name:     r.name,
+			Query:    r.q.String(),
+			Interval: r.interval.String(),
+			Window:   r.window.String(),
+			For:      r.forDuration.String(),
+			Tenant:   fmt.Sprintf("%d:%d", r.tenantID.AccountID, r.tenantID.ProjectID),
+		}
+		r.mu.Lock()
+		if !r.lastEvaluation.IsZero() {
+			rs.LastEvaluation = formatTime(r.lastEvaluation)
+		}
+		rs.LastError = r.lastError
+		rs.Alerts = r.getAlertStatusesLocked()
+		r.mu.Unlock()
+		statuses[i] = rs
+	}
+
+	data, err := json.Marshal(map[string]any{
+		"rules": statuses,
+	})
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal alerting rules: %s", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+func (r *rule) getAlertStatusesLocked() []alertStatusJSON {
+	keys := make([]string, 0, len(r.alerts))
+	for key := range r.alerts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	statuses := make([]alertStatusJSON, len(keys))
+	for i, key := range keys {
+		a := r.alerts[key]
+		statuses[i] = alertStatusJSON{
+			State:       a.state,
+			Labels:      a.labels,
+			Annotations: a.annotations,
+			Values:      a.values,
+			ActiveAt:    formatTime(a.activeAt),
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,177 @@
+package vlalert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+const (
+	notifierAlertmanager = "alertmanager"
+	notifierWebhook      = "webhook"
+)
+
+// notifier sends notifications about firing and resolved alerts to the given url.
+type notifier struct {
+	// typ is either notifierAlertmanager or notifierWebhook.
+	typ string
+	url string
+
+	client *http.Client
+
+	requestsTotal *metrics.Counter
+	errorsTotal   *metrics.Counter
+}
+
+func newNotifier(typ, url string, timeout time.Duration) *notifier {
+	if typ == notifierAlertmanager {
+		url = strings.TrimSuffix(url, "/") + "/api/v2/alerts"
+	}
+	return &notifier{
+		typ: typ,
+		url: url,
+
+		client: &http.Client{
+			Timeout: timeout,
+		},
+
+		requestsTotal: metrics.GetOrCreateCounter(fmt.Sprintf(`vl_alert_notifications_total{type=%q}`, typ)),
+		errorsTotal:   metrics.GetOrCreateCounter(fmt.Sprintf(`vl_alert_notification_errors_total{type=%q}`, typ)),
+	}
+}
+
+// send sends notifications to n.
+//
+// alertTTL is the duration after which firing alerts expire at Alertmanager if they aren't re-sent.
+func (n *notifier) send(ctx context.Context, notifications []*notification, alertTTL time.Duration) error {
+	var data []byte
+	switch n.typ {
+	case notifierAlertmanager:
+		data = marshalAlertmanagerAlerts(notifications, alertTTL, time.Now())
+	case notifierWebhook:
+		data = marshalWebhookAlerts(notifications)
+	default:
+		logger.Panicf("BUG: unexpected notifier type=%q", n.typ)
+	}
+	if data == nil {
+		return nil
+	}
+
+	n.requestsTotal.Inc()
+	if err := n.post(ctx, data); err != nil {
+		n.errorsTotal.Inc()
+		return fmt.Errorf("cannot send notifications to %s: %w", n.typ, err)
+	}
+	return nil
+}
+
+func (n *notifier) post(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("cannot create request to %q: %w", n.url, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot send request to %q: %w", n.url, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code returned from %q: %d; expecting 2xx; response body: %q", n.url, resp.StatusCode, body)
+	}
+	return nil
+}
+
+// alertmanagerAlertJSON is JSON representation for the alert accepted by Alertmanager at /api/v2/alerts.
+type alertmanagerAlertJSON struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	StartsAt    string            `json:"startsAt"`
+	EndsAt      string            `json:"endsAt"`
+}
+
+// marshalAlertmanagerAlerts returns Alertmanager request body for the given notifications.
+//
+// nil is returned if there are no notifications.
+func marshalAlertmanagerAlerts(notifications []*notification, alertTTL time.Duration, now time.Time) []byte {
+	if len(notifications) == 0 {
+		return nil
+	}
+	alerts := make([]alertmanagerAlertJSON, len(notifications))
+	for i, n := range notifications {
+		endsAt := n.resolvedAt
+		if n.state == stateFiring {
+			endsAt = now.Add(alertTTL)
+		}
+		alerts[i] = alertmanagerAlertJSON{
+			Labels:      n.labels,
+			Annotations: n.annotations,
+			StartsAt:    formatTime(n.activeAt),
+			EndsAt:      formatTime(endsAt),
+		}
+	}
+	data, err := json.Marshal(alerts)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal alerts: %s", err)
+	}
+	return data
+}
+
+// webhookAlertJSON is JSON representation for the alert sent to webhooks.
+type webhookAlertJSON struct {
+	State       string            `json:"state"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Values      map[string]string `json:"values,omitempty"`
+	ActiveAt    string            `json:"active_at"`
+	ResolvedAt  string            `json:"resolved_at,omitempty"`
+}
+
+// marshalWebhookAlerts returns webhook request body for the notifications with the changed alert state.
+//
+// nil is returned if there are no such notifications.
+func marshalWebhookAlerts(notifications []*notification) []byte {
+	var alerts []webhookAlertJSON
+	for _, n := range notifications {
+		if !n.isChanged {
+			continue
+		}
+		a := webhookAlertJSON{
+			State:       n.state,
+			Labels:      n.labels,
+			Annotations: n.annotations,
+			Values:      n.values,
+			ActiveAt:    formatTime(n.activeAt),
+		}
+		if n.state == stateResolved {
+			a.ResolvedAt = formatTime(n.resolvedAt)
+		}
+		alerts = append(alerts, a)
+	}
+	if len(alerts) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(map[string]any{
+		"alerts": alerts,
+	})
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal alerts: %s", err)
+	}
+	return data
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,119 @@
+package vlalert
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestNotifications() []*notification {
+	activeAt := time.Unix(1717150800, 0)
+	return []*notification{
+		{
+			labels: map[string]string{
+				"alertname": "test",
+				"service":   "foo",
+			},
+			annotations: map[string]string{
+				"summary": "foo",
+			},
+			values: map[string]string{
+				"errors": "20",
+			},
+			state:    stateFiring,
+			activeAt: activeAt,
+		},
+		{
+			labels: map[string]string{
+				"alertname": "test",
+				"service":   "bar",
+			},
+			state:      stateResolved,
+			activeAt:   activeAt,
+			resolvedAt: activeAt.Add(5 * time.Minute),
+			isChanged:  true,
+		},
+	}
+}
+
+func TestMarshalAlertmanagerAlerts(t *testing.T) {
+	f := func(notifications []*notification, resultExpected string) {
+		t.Helper()
+
+		now := time.Unix(1717151100, 0)
+		result := string(marshalAlertmanagerAlerts(notifications, 4*time.Minute, now))
+		if result != resultExpected {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	f(nil, "")
+	f(newTestNotifications(), `[{"labels":{"alertname":"test","service":"foo"},"annotations":{"summary":"foo"},"startsAt":"2024-05-31T10:20:00Z","endsAt":"2024-05-31T10:29:00Z"},`+
+		`{"labels":{"alertname":"test","service":"bar"},"startsAt":"2024-05-31T10:20:00Z","endsAt":"2024-05-31T10:25:00Z"}]`)
+}
+
+func TestMarshalWebhookAlerts(t *testing.T) {
+	f := func(notifications []*notification, resultExpected string) {
+		t.Helper()
+
+		result := string(marshalWebhookAlerts(notifications))
+		if result != resultExpected {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	f(nil, "")
+
+	// notifications without state changes aren't sent
+	ns := newTestNotifications()
+	f(ns[:1], "")
+
+	f(ns, `{"alerts":[{"state":"resolved","labels":{"alertname":"test","service":"bar"},"active_at":"2024-05-31T10:20:00Z","resolved_at":"2024-05-31T10:25:00Z"}]}`)
+}
+
+func TestNotifierSend(t *testing.T) {
+	var path, body string
+	statusCode := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		path = r.URL.Path
+		body = string(data)
+		w.WriteHeader(statusCode)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	ns := newTestNotifications()
+
+	n := newNotifier(notifierAlertmanager, srv.URL+"/", time.Second)
+	if err := n.send(ctx, ns, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if path != "/api/v2/alerts" {
+		t.Fatalf("unexpected path; got %q; want %q", path, "/api/v2/alerts")
+	}
+	if body == "" {
+		t.Fatalf("expecting non-empty body")
+	}
+
+	n = newNotifier(notifierWebhook, srv.URL+"/hook", time.Second)
+	if err := n.send(ctx, ns, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if path != "/hook" {
+		t.Fatalf("unexpected path; got %q; want %q", path, "/hook")
+	}
+	bodyExpected := string(marshalWebhookAlerts(ns))
+	if body != bodyExpected {
+		t.Fatalf("unexpected body\ngot\n%s\nwant\n%s", body, bodyExpected)
+	}
+
+	// error response
+	statusCode = http.StatusBadGateway
+	if err := n.send(ctx, ns, time.Minute); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
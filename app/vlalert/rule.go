@@ -0,0 +1,323 @@
+package vlalert
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+const (
+	statePending  = "pending"
+	stateFiring   = "firing"
+	stateResolved = "resolved"
+)
+
+// rule periodically evaluates the query over the sliding time window and tracks the state of the generated alerts.
+type rule struct {
+	name string
+
+	// q is the rule query with the applied condition.
+	q *logstorage.Query
+
+	// labelFields contains `by (...)` fields from the `stats` pipe at q. They identify alerts generated by the rule.
+	labelFields []string
+
+	interval    time.Duration
+	window      time.Duration
+	forDuration time.Duration
+	tenantID    logstorage.TenantID
+
+	labels      map[string]string
+	annotations []annotationTemplate
+
+	evaluationsTotal *metrics.Counter
+	errorsTotal      *metrics.Counter
+	firedTotal       *metrics.Counter
+	resolvedTotal    *metrics.Counter
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// alerts contains active alerts keyed by the values of labelFields.
+	alerts map[string]*alert
+
+	// lastEvaluation is the time of the latest evaluation.
+	lastEvaluation time.Time
+
+	// lastError is the error for the latest evaluation.
+	lastError string
+}
+
+// alert is an active alert generated by the rule.
+type alert struct {
+	labels      map[string]string
+	annotations map[string]string
+	values      map[string]string
+
+	// state is either statePending or stateFiring.
+	state string
+
+	// activeAt is the time when the alert became active.
+	activeAt time.Time
+}
+
+// notification is a notification about firing or resolved alert.
+type notification struct {
+	labels      map[string]string
+	annotations map[string]string
+	values      map[string]string
+
+	// state is either stateFiring or stateResolved.
+	state string
+
+	activeAt   time.Time
+	resolvedAt time.Time
+
+	// isChanged is set to true if the alert state has been changed at the latest evaluation.
+	isChanged bool
+}
+
+func (r *rule) init() {
+	r.evaluationsTotal = metrics.GetOrCreateCounter(fmt.Sprintf(`vl_alert_evaluations_total{name=%q}`, r.name))
+	r.errorsTotal = metrics.GetOrCreateCounter(fmt.Sprintf(`vl_alert_evaluation_errors_total{name=%q}`, r.name))
+	r.firedTotal = metrics.GetOrCreateCounter(fmt.Sprintf(`vl_alerts_fired_total{name=%q}`, r.name))
+	r.resolvedTotal = metrics.GetOrCreateCounter(fmt.Sprintf(`vl_alerts_resolved_total{name=%q}`, r.name))
+	r.alerts = make(map[string]*alert)
+}
+
+// run evaluates r every r.interval until ctx is canceled and sends notifications for firing and resolved alerts to ns.
+func (r *rule) run(ctx context.Context, ns []*notifier) {
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+	for {
+		r.evalAndNotify(ctx, ns, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (r *rule) evalAndNotify(ctx context.Context, ns []*notifier, now time.Time) {
+	notifications, err := r.eval(ctx, now)
+
+	r.mu.Lock()
+	r.lastEvaluation = now
+	r.lastError = ""
+	if err != nil {
+		r.lastError = err.Error()
+	}
+	r.mu.Unlock()
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		r.errorsTotal.Inc()
+		logger.Errorf("cannot evaluate alerting rule %q: %s", r.name, err)
+	}
+
+	// Firing alerts must be re-sent to Alertmanager at every evaluation, so they don't expire there.
+	// Prometheus uses the same approach.
+	alertTTL := 4 * r.interval
+	for _, n := range ns {
+		if err := n.send(ctx, notifications, alertTTL); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Errorf("cannot send notifications for alerting rule %q: %s", r.name, err)
+		}
+	}
+}
+
+// eval runs r.q on the [now-r.window ... now) time range and updates the alerts for r according to the query results.
+//
+// It returns notifications for firing and resolved alerts.
+func (r *rule) eval(ctx context.Context, now time.Time) ([]*notification, error) {
+	r.evaluationsTotal.Inc()
+
+	q := r.q.Clone()
+	end := now.UnixNano()
+	q.AddTimeFilter(end-r.window.Nanoseconds(), end-1)
+
+	var rows [][]logstorage.Field
+	var rowsLock sync.Mutex
+	writeBlock := func(_ uint, timestamps []int64, columns []logstorage.BlockColumn) {
+		rowsLock.Lock()
+		defer rowsLock.Unlock()
+
+		for i := range timestamps {
+			fields := make([]logstorage.Field, len(columns))
+			for j, c := range columns {
+				// Clone the values, since they may be modified after writeBlock returns.
+				fields[j] = logstorage.Field{
+					Name:  strings.Clone(c.Name),
+					Value: strings.Clone(c.Values[i]),
+				}
+			}
+			rows = append(rows, fields)
+		}
+	}
+	if err := vlstorage.RunQuery(ctx, []logstorage.TenantID{r.tenantID}, q, writeBlock); err != nil {
+		return nil, fmt.Errorf("cannot execute query [%s]: %w", q, err)
+	}
+
+	return r.updateAlerts(rows, now)
+}
+
+// updateAlerts updates the alerts for r according to the given query results at the given time.
+//
+// Every row in rows is an active alert. Alerts missing in rows are resolved.
+// Active alerts stay in pending state during r.forDuration and then become firing.
+//
+// It returns notifications for all the firing alerts and for the alerts resolved at this call.
+func (r *rule) updateAlerts(rows [][]logstorage.Field, now time.Time) ([]*notification, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var firstErr error
+	seen := make(map[string]bool, len(rows))
+	for _, fields := range rows {
+		key := r.getAlertKey(fields)
+		labels, values := r.getLabelsAndValues(fields)
+		annotations, err := r.getAnnotations(labels, values)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+
+		a := r.alerts[key]
+		if a == nil {
+			a = &alert{
+				labels:   labels,
+				state:    statePending,
+				activeAt: now,
+			}
+			r.alerts[key] = a
+		}
+		a.annotations = annotations
+		a.values = values
+
+		isChanged := false
+		if a.state == statePending && now.Sub(a.activeAt) >= r.forDuration {
+			a.state = stateFiring
+			isChanged = true
+			r.firedTotal.Inc()
+		}
+		seen[key] = isChanged
+	}
+
+	keys := make([]string, 0, len(r.alerts))
+	for key := range r.alerts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var notifications []*notification
+	for _, key := range keys {
+		a := r.alerts[key]
+		isChanged, ok := seen[key]
+		if !ok {
+			delete(r.alerts, key)
+			if a.state == stateFiring {
+				r.resolvedTotal.Inc()
+				notifications = append(notifications, a.newNotification(stateResolved, now, true))
+			}
+			continue
+		}
+		if a.state == stateFiring {
+			notifications = append(notifications, a.newNotification(stateFiring, time.Time{}, isChanged))
+		}
+	}
+	return notifications, firstErr
+}
+
+func (a *alert) newNotification(state string, resolvedAt time.Time, isChanged bool) *notification {
+	return &notification{
+		labels:      a.labels,
+		annotations: a.annotations,
+		values:      a.values,
+		state:       state,
+		activeAt:    a.activeAt,
+		resolvedAt:  resolvedAt,
+		isChanged:   isChanged,
+	}
+}
+
+// getAlertKey returns the key for the alert generated from the given result fields.
+func (r *rule) getAlertKey(fields []logstorage.Field) string {
+	var b []byte
+	for _, name := range r.labelFields {
+		b = strconv.AppendQuote(b, getFieldValue(fields, name))
+		b = append(b, ',')
+	}
+	return string(b)
+}
+
+// getLabelsAndValues returns labels and values for the alert generated from the given result fields.
+//
+// Labels are obtained from `by (...)` fields of the query, from r.labels and from the rule name,
+// while values are obtained from the remaining fields.
+func (r *rule) getLabelsAndValues(fields []logstorage.Field) (map[string]string, map[string]string) {
+	labels := make(map[string]string, len(r.labelFields)+len(r.labels)+1)
+	values := make(map[string]string, len(fields))
+	for _, f := range fields {
+		if r.isLabelField(f.Name) {
+			labels[f.Name] = f.Value
+		} else {
+			values[f.Name] = f.Value
+		}
+	}
+	for k, v := range r.labels {
+		labels[k] = v
+	}
+	labels["alertname"] = r.name
+	return labels, values
+}
+
+func (r *rule) getAnnotations(labels, values map[string]string) (map[string]string, error) {
+	if len(r.annotations) == 0 {
+		return nil, nil
+	}
+	var firstErr error
+	annotations := make(map[string]string, len(r.annotations))
+	for _, at := range r.annotations {
+		s, err := executeAnnotationTemplate(at.t, labels, values)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("cannot execute template for annotation %q: %w", at.name, err)
+			}
+			s = err.Error()
+		}
+		annotations[at.name] = s
+	}
+	return annotations, firstErr
+}
+
+func (r *rule) isLabelField(name string) bool {
+	for _, f := range r.labelFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+func getFieldValue(fields []logstorage.Field, name string) string {
+	for _, f := range fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,153 @@
+package vlalert
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+func newTestRule(t *testing.T, forDuration string) *rule {
+	t.Helper()
+
+	r, err := newRule(&Config{
+		Name:      "test",
+		Query:     "level:error | stats by (service) count() errors",
+		Condition: "errors:>10",
+		Interval:  "1m",
+		For:       forDuration,
+		Labels: map[string]string{
+			"severity": "critical",
+		},
+		Annotations: map[string]string{
+			"summary": "{{ $values.errors }} errors at {{ $labels.service }}",
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot create rule: %s", err)
+	}
+	return r
+}
+
+func newTestRows(serviceErrors ...string) [][]logstorage.Field {
+	var rows [][]logstorage.Field
+	for i := 0; i < len(serviceErrors); i += 2 {
+		rows = append(rows, []logstorage.Field{
+			{Name: "service", Value: serviceErrors[i]},
+			{Name: "errors", Value: serviceErrors[i+1]},
+		})
+	}
+	return rows
+}
+
+func TestRuleUpdateAlerts(t *testing.T) {
+	r := newTestRule(t, "2m")
+
+	start := time.Unix(1717150800, 0)
+	f := func(rows [][]logstorage.Field, d time.Duration, notificationsExpected []*notification, pendingExpected int) {
+		t.Helper()
+
+		notifications, err := r.updateAlerts(rows, start.Add(d))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(notifications, notificationsExpected) {
+			t.Fatalf("unexpected notifications\ngot\n%v\nwant\n%v", notifications, notificationsExpected)
+		}
+		pending := 0
+		for _, a := range r.alerts {
+			if a.state == statePending {
+				pending++
+			}
+		}
+		if pending != pendingExpected {
+			t.Fatalf("unexpected number of pending alerts; got %d; want %d", pending, pendingExpected)
+		}
+	}
+
+	fooLabels := map[string]string{
+		"alertname": "test",
+		"service":   "foo",
+		"severity":  "critical",
+	}
+	barLabels := map[string]string{
+		"alertname": "test",
+		"service":   "bar",
+		"severity":  "critical",
+	}
+
+	// no alerts
+	f(nil, 0, nil, 0)
+
+	// new alerts are pending during the `for` duration
+	f(newTestRows("foo", "20"), 0, nil, 1)
+	f(newTestRows("foo", "25", "bar", "15"), time.Minute, nil, 2)
+
+	// the alert becomes firing after the `for` duration
+	f(newTestRows("foo", "30", "bar", "12"), 2*time.Minute, []*notification{
+		{
+			labels: fooLabels,
+			annotations: map[string]string{
+				"summary": "30 errors at foo",
+			},
+			values: map[string]string{
+				"errors": "30",
+			},
+			state:     stateFiring,
+			activeAt:  start,
+			isChanged: true,
+		},
+	}, 1)
+
+	// the firing alert is re-sent, while the pending alert disappears without notifications
+	f(newTestRows("foo", "40"), 3*time.Minute, []*notification{
+		{
+			labels: fooLabels,
+			annotations: map[string]string{
+				"summary": "40 errors at foo",
+			},
+			values: map[string]string{
+				"errors": "40",
+			},
+			state:    stateFiring,
+			activeAt: start,
+		},
+	}, 0)
+
+	// the firing alert is resolved
+	f(newTestRows("bar", "50"), 4*time.Minute, []*notification{
+		{
+			labels: fooLabels,
+			annotations: map[string]string{
+				"summary": "40 errors at foo",
+			},
+			values: map[string]string{
+				"errors": "40",
+			},
+			state:      stateResolved,
+			activeAt:   start,
+			resolvedAt: start.Add(4 * time.Minute),
+			isChanged:  true,
+		},
+	}, 1)
+
+	// the resolved alert isn't sent again
+	f(newTestRows("bar", "50"), 5*time.Minute, nil, 1)
+	if a := r.alerts[`"bar",`]; a == nil || !reflect.DeepEqual(a.labels, barLabels) || !a.activeAt.Equal(start.Add(4*time.Minute)) {
+		t.Fatalf("unexpected alert for bar service: %v", a)
+	}
+}
+
+func TestRuleUpdateAlertsWithoutFor(t *testing.T) {
+	r := newTestRule(t, "")
+
+	now := time.Unix(1717150800, 0)
+	notifications, err := r.updateAlerts(newTestRows("foo", "20"), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(notifications) != 1 || notifications[0].state != stateFiring || !notifications[0].isChanged {
+		t.Fatalf("expecting a single firing notification; got %v", notifications)
+	}
+}
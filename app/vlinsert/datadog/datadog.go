@@ -0,0 +1,233 @@
+package datadog
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/valyala/fastjson"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlinsert/insertutils"
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/common"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/writeconcurrencylimiter"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// RequestHandler processes Datadog insert requests
+func RequestHandler(path string, w http.ResponseWriter, r *http.Request) bool {
+	switch path {
+	case "/api/v1/validate":
+		// See https://docs.datadoghq.com/api/latest/authentication/#validate-api-key
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"valid":true}`)
+		return true
+	case "/api/v2/logs":
+		handleLogs(w, r)
+		return true
+	default:
+		return false
+	}
+}
+
+// See https://docs.datadoghq.com/api/latest/logs/#send-logs
+func handleLogs(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestsTotal.Inc()
+
+	cp, err := getCommonParams(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	if err := vlstorage.CanWriteData(cp.TenantID); err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	reader := r.Body
+	switch ce := r.Header.Get("Content-Encoding"); ce {
+	case "", "identity":
+	case "gzip":
+		zr, err := common.GetGzipReader(reader)
+		if err != nil {
+			errorsTotal.Inc()
+			httpserver.Errorf(w, r, "cannot read gzipped Datadog request: %s", err)
+			return
+		}
+		defer common.PutGzipReader(zr)
+		reader = zr
+	case "deflate":
+		zr, err := common.GetZlibReader(reader)
+		if err != nil {
+			errorsTotal.Inc()
+			httpserver.Errorf(w, r, "cannot read deflated Datadog request: %s", err)
+			return
+		}
+		defer common.PutZlibReader(zr)
+		reader = zr
+	default:
+		errorsTotal.Inc()
+		httpserver.Errorf(w, r, "unsupported Content-Encoding=%q; supported values: gzip, deflate", ce)
+		return
+	}
+
+	wcr := writeconcurrencylimiter.GetReader(reader)
+	data, err := io.ReadAll(wcr)
+	writeconcurrencylimiter.PutReader(wcr)
+	if err != nil {
+		errorsTotal.Inc()
+		httpserver.Errorf(w, r, "cannot read request body: %s", err)
+		return
+	}
+
+	lmp := cp.NewLogMessageProcessor()
+	n, err := parseLogsRequest(data, r.FormValue("ddtags"), lmp)
+	lmp.MustClose()
+	if err != nil {
+		errorsTotal.Inc()
+		httpserver.Errorf(w, r, "cannot parse Datadog logs request: %s", err)
+		return
+	}
+
+	rowsIngestedTotal.Add(n)
+
+	// Datadog agents expect 202 Accepted response with an empty JSON object.
+	// See https://docs.datadoghq.com/api/latest/logs/#send-logs
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "{}")
+
+	// update requestDuration only for successfully parsed requests
+	// There is no need in updating requestDuration for request errors,
+	// since their timings are usually much smaller than the timing for successful request parsing.
+	requestDuration.UpdateDuration(startTime)
+}
+
+var (
+	rowsIngestedTotal = metrics.NewCounter(`vl_rows_ingested_total{type="datadog"}`)
+
+	requestsTotal = metrics.NewCounter(`vl_http_requests_total{path="/insert/datadog/api/v2/logs"}`)
+	errorsTotal   = metrics.NewCounter(`vl_http_errors_total{path="/insert/datadog/api/v2/logs"}`)
+
+	requestDuration = metrics.NewHistogram(`vl_http_request_duration_seconds{path="/insert/datadog/api/v2/logs"}`)
+)
+
+// defaultStreamFields contains the stream fields for Datadog logs if _stream_fields query arg isn't set.
+//
+// See https://docs.datadoghq.com/logs/log_configuration/attributes_naming_convention/#reserved-attributes
+var defaultStreamFields = []string{"ddsource", "service", "hostname"}
+
+func getCommonParams(r *http.Request) (*insertutils.CommonParams, error) {
+	cp, err := insertutils.GetCommonParams(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(cp.StreamFields) == 0 {
+		cp.StreamFields = defaultStreamFields
+	}
+	return cp, nil
+}
+
+var parserPool fastjson.ParserPool
+
+// parseLogsRequest parses Datadog logs from data and sends them to lmp.
+//
+// data must contain either a JSON array of log entries or a single log entry.
+// ddtags contains comma-separated tags, which must be added to every log entry in addition to the tags from the `ddtags` field.
+// The `timestamp` field must contain Unix timestamp in milliseconds. The current time is used for log entries without `timestamp` field.
+//
+// It returns the number of parsed log entries.
+func parseLogsRequest(data []byte, ddtags string, lmp insertutils.LogMessageProcessor) (int, error) {
+	p := parserPool.Get()
+	defer parserPool.Put(p)
+	v, err := p.ParseBytes(data)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse JSON request body: %w", err)
+	}
+
+	var entries []*fastjson.Value
+	switch t := v.Type(); t {
+	case fastjson.TypeArray:
+		entries, _ = v.Array()
+	case fastjson.TypeObject:
+		entries = []*fastjson.Value{v}
+	default:
+		return 0, fmt.Errorf("request body must contain JSON array or JSON object; got %s", t)
+	}
+
+	jp := logstorage.GetJSONParser()
+	defer logstorage.PutJSONParser(jp)
+
+	var buf []byte
+	var fields []logstorage.Field
+	for i, entry := range entries {
+		if t := entry.Type(); t != fastjson.TypeObject {
+			return i, fmt.Errorf("unexpected log entry type at position %d; want JSON object; got %s", i, t)
+		}
+
+		// Re-use the JSONParser for flattening nested objects in the same way as for the other ingestion protocols.
+		buf = entry.MarshalTo(buf[:0])
+		if err := jp.ParseLogMessage(buf); err != nil {
+			return i, fmt.Errorf("cannot parse log entry at position %d: %w", i, err)
+		}
+
+		ts, err := insertutils.ExtractTimestampFromFields("timestamp", insertutils.TimeFormatUnixMs, jp.Fields)
+		if err != nil {
+			return i, fmt.Errorf("cannot parse timestamp for log entry at position %d: %w", i, err)
+		}
+
+		fields = append(fields[:0], jp.Fields...)
+		logstorage.RenameField(fields, "message", "_msg")
+		for j := range fields {
+			if fields[j].Name == "ddtags" {
+				fields = appendTagFields(fields, fields[j].Value)
+				// Clear the original field, since its contents is stored in the tag fields.
+				fields[j].Value = ""
+				break
+			}
+		}
+		fields = appendTagFields(fields, ddtags)
+
+		lmp.AddRow(ts, fields)
+	}
+	return len(entries), nil
+}
+
+// appendTagFields appends fields for comma-separated Datadog tags to dst.
+//
+// See https://docs.datadoghq.com/getting_started/tagging/#define-tags
+func appendTagFields(dst []logstorage.Field, tags string) []logstorage.Field {
+	for tags != "" {
+		tag := tags
+		if n := strings.IndexByte(tags, ','); n >= 0 {
+			tag = tags[:n]
+			tags = tags[n+1:]
+		} else {
+			tags = ""
+		}
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+
+		name, value := tag, "no_label_value"
+		if n := strings.IndexByte(tag, ':'); n >= 0 {
+			name, value = tag[:n], tag[n+1:]
+		}
+		dst = append(dst, logstorage.Field{
+			Name:  name,
+			Value: value,
+		})
+	}
+	return dst
+}
@@ -0,0 +1,61 @@
+package datadog
+
+import (
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlinsert/insertutils"
+)
+
+func TestParseLogsRequestSuccess(t *testing.T) {
+	f := func(data, ddtags string, timestampsExpected []int64, resultExpected string) {
+		t.Helper()
+
+		tlp := &insertutils.TestLogMessageProcessor{}
+		n, err := parseLogsRequest([]byte(data), ddtags, tlp)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := tlp.Verify(n, timestampsExpected, resultExpected); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// empty array
+	f(`[]`, "", nil, "")
+
+	// single log entry
+	f(`{"message":"foo","timestamp":1717150800123,"hostname":"host1","service":"app","ddsource":"nginx"}`, "",
+		[]int64{1717150800123000000},
+		`{"_msg":"foo","timestamp":"","hostname":"host1","service":"app","ddsource":"nginx"}`)
+
+	// multiple log entries with tags and nested attributes
+	f(`[
+{"message":"foo","timestamp":1717150800000,"ddtags":"env:prod, version:1.2,debug","status":"info"},
+{"message":"bar","timestamp":"1717150801000","attrs":{"user":{"id":123},"path":"/"}}
+]`, "region:eu,,team:x",
+		[]int64{1717150800000000000, 1717150801000000000},
+		`{"_msg":"foo","timestamp":"","ddtags":"","status":"info","env":"prod","version":"1.2","debug":"no_label_value","region":"eu","team":"x"}
+{"_msg":"bar","timestamp":"","attrs.user.id":"123","attrs.path":"/","region":"eu","team":"x"}`)
+}
+
+func TestParseLogsRequestFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		tlp := &insertutils.TestLogMessageProcessor{}
+		if _, err := parseLogsRequest([]byte(data), "", tlp); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// invalid JSON
+	f(``)
+	f(`[{"message":"foo"`)
+
+	// unexpected JSON types
+	f(`"foo"`)
+	f(`[{"message":"foo"},123]`)
+
+	// invalid timestamp
+	f(`[{"message":"foo","timestamp":"foobar"}]`)
+}
@@ -99,23 +99,23 @@ func RequestHandler(path string, w http.ResponseWriter, r *http.Request) bool {
 			httpserver.Errorf(w, r, "%s", err)
 			return true
 		}
-		if err := vlstorage.CanWriteData(); err != nil {
+		if err := vlstorage.CanWriteData(cp.TenantID); err != nil {
 			httpserver.Errorf(w, r, "%s", err)
 			return true
 		}
 		lmp := cp.NewLogMessageProcessor()
 		isGzip := r.Header.Get("Content-Encoding") == "gzip"
-		n, err := readBulkRequest(r.Body, isGzip, cp.TimeField, cp.MsgField, lmp)
+		items, err := readBulkRequest(r.Body, isGzip, cp.TimeField, cp.MsgField, lmp, nil)
 		lmp.MustClose()
 		if err != nil {
-			logger.Warnf("cannot decode log message #%d in /_bulk request: %s, stream fields: %s", n, err, cp.StreamFields)
+			httpserver.Errorf(w, r, "cannot decode log message #%d in /_bulk request: %s, stream fields: %s", len(items), err, cp.StreamFields)
 			return true
 		}
 
 		tookMs := time.Since(startTime).Milliseconds()
 		bw := bufferedwriter.Get(w)
 		defer bufferedwriter.Put(bw)
-		WriteBulkResponse(bw, n, tookMs)
+		WriteBulkResponse(bw, items, tookMs)
 		_ = bw.Flush()
 
 		// update bulkRequestDuration only for successfully parsed requests
@@ -132,16 +132,42 @@ func RequestHandler(path string, w http.ResponseWriter, r *http.Request) bool {
 var (
 	bulkRequestsTotal   = metrics.NewCounter(`vl_http_requests_total{path="/insert/elasticsearch/_bulk"}`)
 	rowsIngestedTotal   = metrics.NewCounter(`vl_rows_ingested_total{type="elasticsearch_bulk"}`)
+	rowsInvalidTotal    = metrics.NewCounter(`vl_rows_dropped_total{reason="elasticsearch_invalid_document"}`)
 	bulkRequestDuration = metrics.NewHistogram(`vl_http_request_duration_seconds{path="/insert/elasticsearch/_bulk"}`)
 )
 
-func readBulkRequest(r io.Reader, isGzip bool, timeField, msgField string, lmp insertutils.LogMessageProcessor) (int, error) {
+// bulkItem is the result for a single document in /_bulk request.
+//
+// See https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-bulk.html#bulk-api-response-body
+type bulkItem struct {
+	// action is either "create" or "index"
+	action string
+
+	// err contains the reason why the document has been rejected. It is empty for ingested documents.
+	err string
+}
+
+// bulkItemsHaveErrors returns true if some of items have been rejected.
+func bulkItemsHaveErrors(items []bulkItem) bool {
+	for _, item := range items {
+		if item.err != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// readBulkRequest reads /_bulk request from r, sends the parsed documents to lmp and appends the results for every document to dst.
+//
+// Invalid documents are reported via bulkItem.err in the returned items, while the error is returned only if r cannot be read
+// or if it contains invalid commands.
+func readBulkRequest(r io.Reader, isGzip bool, timeField, msgField string, lmp insertutils.LogMessageProcessor, dst []bulkItem) ([]bulkItem, error) {
 	// See https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-bulk.html
 
 	if isGzip {
 		zr, err := common.GetGzipReader(r)
 		if err != nil {
-			return 0, fmt.Errorf("cannot read gzipped _bulk request: %w", err)
+			return dst, fmt.Errorf("cannot read gzipped _bulk request: %w", err)
 		}
 		defer common.PutGzipReader(zr)
 		r = zr
@@ -160,11 +186,17 @@ func readBulkRequest(r io.Reader, isGzip bool, timeField, msgField string, lmp i
 	n := 0
 	nCheckpoint := 0
 	for {
-		ok, err := readBulkLine(sc, timeField, msgField, lmp)
+		item, ok, err := readBulkLine(sc, timeField, msgField, lmp)
 		wcr.DecConcurrency()
 		if err != nil || !ok {
 			rowsIngestedTotal.Add(n - nCheckpoint)
-			return n, err
+			return dst, err
+		}
+		dst = append(dst, item)
+		if item.err != "" {
+			rowsInvalidTotal.Inc()
+			invalidDocumentLogger.Warnf("cannot ingest document #%d from /_bulk request: %s", len(dst), item.err)
+			continue
 		}
 		n++
 		if batchSize := n - nCheckpoint; n >= 1000 {
@@ -174,9 +206,15 @@ func readBulkRequest(r io.Reader, isGzip bool, timeField, msgField string, lmp i
 	}
 }
 
+var invalidDocumentLogger = logger.WithThrottler("elasticsearch_invalid_document", 5*time.Second)
+
 var lineBufferPool bytesutil.ByteBufferPool
 
-func readBulkLine(sc *bufio.Scanner, timeField, msgField string, lmp insertutils.LogMessageProcessor) (bool, error) {
+// readBulkLine reads the command and the document from sc and sends the document to lmp.
+//
+// It returns false if sc has no more commands.
+func readBulkLine(sc *bufio.Scanner, timeField, msgField string, lmp insertutils.LogMessageProcessor) (bulkItem, bool, error) {
+	var item bulkItem
 	var line []byte
 
 	// Read the command, must be "create" or "index"
@@ -184,48 +222,55 @@ func readBulkLine(sc *bufio.Scanner, timeField, msgField string, lmp insertutils
 		if !sc.Scan() {
 			if err := sc.Err(); err != nil {
 				if errors.Is(err, bufio.ErrTooLong) {
-					return false, fmt.Errorf(`cannot read "create" or "index" command, since its size exceeds -insert.maxLineSizeBytes=%d`,
+					return item, false, fmt.Errorf(`cannot read "create" or "index" command, since its size exceeds -insert.maxLineSizeBytes=%d`,
 						insertutils.MaxLineSizeBytes.IntN())
 				}
-				return false, err
+				return item, false, err
 			}
-			return false, nil
+			return item, false, nil
 		}
 		line = sc.Bytes()
 	}
 	lineStr := bytesutil.ToUnsafeString(line)
-	if !strings.Contains(lineStr, `"create"`) && !strings.Contains(lineStr, `"index"`) {
-		return false, fmt.Errorf(`unexpected command %q; expecting "create" or "index"`, line)
+	switch {
+	case strings.Contains(lineStr, `"create"`):
+		item.action = "create"
+	case strings.Contains(lineStr, `"index"`):
+		item.action = "index"
+	default:
+		return item, false, fmt.Errorf(`unexpected command %q; expecting "create" or "index"`, line)
 	}
 
 	// Decode log message
 	if !sc.Scan() {
 		if err := sc.Err(); err != nil {
 			if errors.Is(err, bufio.ErrTooLong) {
-				return false, fmt.Errorf("cannot read log message, since its size exceeds -insert.maxLineSizeBytes=%d", insertutils.MaxLineSizeBytes.IntN())
+				return item, false, fmt.Errorf("cannot read log message, since its size exceeds -insert.maxLineSizeBytes=%d", insertutils.MaxLineSizeBytes.IntN())
 			}
-			return false, err
+			return item, false, err
 		}
-		return false, fmt.Errorf(`missing log message after the "create" or "index" command`)
+		return item, false, fmt.Errorf(`missing log message after the "create" or "index" command`)
 	}
 	line = sc.Bytes()
 	p := logstorage.GetJSONParser()
+	defer logstorage.PutJSONParser(p)
 	if err := p.ParseLogMessage(line); err != nil {
-		return false, fmt.Errorf("cannot parse json-encoded log entry: %w", err)
+		item.err = fmt.Sprintf("cannot parse json-encoded log entry: %s", err)
+		return item, true, nil
 	}
 
 	ts, err := extractTimestampFromFields(timeField, p.Fields)
 	if err != nil {
-		return false, fmt.Errorf("cannot parse timestamp: %w", err)
+		item.err = fmt.Sprintf("cannot parse timestamp: %s", err)
+		return item, true, nil
 	}
 	if ts == 0 {
 		ts = time.Now().UnixNano()
 	}
 	logstorage.RenameField(p.Fields, msgField, "_msg")
 	lmp.AddRow(ts, p.Fields)
-	logstorage.PutJSONParser(p)
 
-	return true, nil
+	return item, true, nil
 }
 
 func extractTimestampFromFields(timeField string, fields []logstorage.Field) (int64, error) {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlinsert/insertutils"
@@ -15,12 +16,12 @@ func TestReadBulkRequest_Failure(t *testing.T) {
 
 		tlp := &insertutils.TestLogMessageProcessor{}
 		r := bytes.NewBufferString(data)
-		rows, err := readBulkRequest(r, false, "_time", "_msg", tlp)
+		items, err := readBulkRequest(r, false, "_time", "_msg", tlp, nil)
 		if err == nil {
 			t.Fatalf("expecting non-empty error")
 		}
-		if rows != 0 {
-			t.Fatalf("unexpected non-zero rows=%d", rows)
+		if len(items) != 0 {
+			t.Fatalf("unexpected non-zero items=%d", len(items))
 		}
 	}
 	f("foobar")
@@ -28,8 +29,85 @@ func TestReadBulkRequest_Failure(t *testing.T) {
 	f(`{"create":{}}`)
 	f(`{"creat":{}}
 {}`)
+}
+
+func TestReadBulkRequest_InvalidDocuments(t *testing.T) {
+	f := func(data string, itemsExpected []bulkItem, rowsExpected int, timestampsExpected []int64, resultExpected string) {
+		t.Helper()
+
+		tlp := &insertutils.TestLogMessageProcessor{}
+		r := bytes.NewBufferString(data)
+		items, err := readBulkRequest(r, false, "@timestamp", "message", tlp, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(items, itemsExpected) {
+			t.Fatalf("unexpected items\ngot\n%+v\nwant\n%+v", items, itemsExpected)
+		}
+		if err := tlp.Verify(rowsExpected, timestampsExpected, resultExpected); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// invalid json
 	f(`{"create":{}}
-foobar`)
+foobar`, []bulkItem{
+		{
+			action: "create",
+			err:    "cannot parse json-encoded log entry: cannot parse json: cannot parse JSON: unexpected value found: \"foobar\"; unparsed tail: \"foobar\"",
+		},
+	}, 0, nil, "")
+
+	// invalid documents don't prevent from ingesting valid documents
+	f(`{"create":{}}
+{"@timestamp":"2023-06-06T04:48:11.735Z","message":"foo"}
+{"index":{}}
+{"@timestamp":"foobar","message":"bar"}
+{"index":{}}
+{"@timestamp":"2023-06-06T04:48:13.735Z","message":"baz"}
+`, []bulkItem{
+		{
+			action: "create",
+		},
+		{
+			action: "index",
+			err:    `cannot parse timestamp: cannot parse timestamp in milliseconds from "foobar": strconv.ParseInt: parsing "foobar": invalid syntax`,
+		},
+		{
+			action: "index",
+		},
+	}, 2, []int64{1686026891735000000, 1686026893735000000}, `{"@timestamp":"","_msg":"foo"}
+{"@timestamp":"","_msg":"baz"}`)
+}
+
+func TestWriteBulkResponse(t *testing.T) {
+	f := func(items []bulkItem, resultExpected string) {
+		t.Helper()
+
+		result := BulkResponse(items, 123)
+		if result != resultExpected {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	f(nil, `{"took":123,"errors":false,"items":[]}`)
+	f([]bulkItem{
+		{
+			action: "create",
+		},
+		{
+			action: "index",
+		},
+	}, `{"took":123,"errors":false,"items":[{"create":{"status":201}},{"index":{"status":201}}]}`)
+	f([]bulkItem{
+		{
+			action: "create",
+		},
+		{
+			action: "create",
+			err:    `cannot parse "foo"`,
+		},
+	}, `{"took":123,"errors":true,"items":[{"create":{"status":201}},{"create":{"status":400,"error":{"type":"document_parsing_exception","reason":"cannot parse \"foo\""}}}]}`)
 }
 
 func TestReadBulkRequest_Success(t *testing.T) {
@@ -40,12 +118,12 @@ func TestReadBulkRequest_Success(t *testing.T) {
 
 		// Read the request without compression
 		r := bytes.NewBufferString(data)
-		rows, err := readBulkRequest(r, false, timeField, msgField, tlp)
+		items, err := readBulkRequest(r, false, timeField, msgField, tlp, nil)
 		if err != nil {
 			t.Fatalf("unexpected error: %s", err)
 		}
-		if rows != rowsExpected {
-			t.Fatalf("unexpected rows read; got %d; want %d", rows, rowsExpected)
+		if len(items) != rowsExpected {
+			t.Fatalf("unexpected rows read; got %d; want %d", len(items), rowsExpected)
 		}
 		if err := tlp.Verify(rowsExpected, timestampsExpected, resultExpected); err != nil {
 			t.Fatal(err)
@@ -55,12 +133,12 @@ func TestReadBulkRequest_Success(t *testing.T) {
 		tlp = &insertutils.TestLogMessageProcessor{}
 		compressedData := compressData(data)
 		r = bytes.NewBufferString(compressedData)
-		rows, err = readBulkRequest(r, true, timeField, msgField, tlp)
+		items, err = readBulkRequest(r, true, timeField, msgField, tlp, nil)
 		if err != nil {
 			t.Fatalf("unexpected error: %s", err)
 		}
-		if rows != rowsExpected {
-			t.Fatalf("unexpected rows read; got %d; want %d", rows, rowsExpected)
+		if len(items) != rowsExpected {
+			t.Fatalf("unexpected rows read; got %d; want %d", len(items), rowsExpected)
 		}
 		if err := tlp.Verify(rowsExpected, timestampsExpected, resultExpected); err != nil {
 			t.Fatalf("verification failure after compression: %s", err)
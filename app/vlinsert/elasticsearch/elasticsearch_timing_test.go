@@ -39,9 +39,11 @@ func benchmarkReadBulkRequest(b *testing.B, isGzip bool) {
 	b.SetBytes(int64(len(data)))
 	b.RunParallel(func(pb *testing.PB) {
 		r := &bytes.Reader{}
+		var items []bulkItem
 		for pb.Next() {
 			r.Reset(dataBytes)
-			_, err := readBulkRequest(r, isGzip, timeField, msgField, blp)
+			var err error
+			items, err = readBulkRequest(r, isGzip, timeField, msgField, blp, items[:0])
 			if err != nil {
 				panic(fmt.Errorf("unexpected error: %w", err))
 			}
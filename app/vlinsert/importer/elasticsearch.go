@@ -0,0 +1,438 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlinsert/insertutils"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+var (
+	elasticsearchURL = flag.String("importer.elasticsearch.url", "", "Elasticsearch URL for -importer.source=elasticsearch. For example, http://elasticsearch:9200 . "+
+		"See https://docs.victoriametrics.com/victorialogs/data-ingestion/importer/#elasticsearch")
+	elasticsearchIndex = flag.String("importer.elasticsearch.index", "", "Elasticsearch index or index pattern to import logs from for -importer.source=elasticsearch. "+
+		"For example, -importer.elasticsearch.index='logs-*'")
+	elasticsearchQuery = flag.String("importer.elasticsearch.query", "", "Optional JSON-encoded Elasticsearch query for selecting documents to import for -importer.source=elasticsearch. "+
+		`For example, -importer.elasticsearch.query='{"term":{"service":"nginx"}}'. All the documents are imported by default`)
+	elasticsearchBatchSize     = flag.Int("importer.elasticsearch.batchSize", 1000, "The number of documents to read per each scroll request for -importer.source=elasticsearch")
+	elasticsearchScrollTimeout = flag.Duration("importer.elasticsearch.scrollTimeout", 5*time.Minute, "How long Elasticsearch must keep the scroll context "+
+		"between scroll requests for -importer.source=elasticsearch")
+	elasticsearchUsername  = flag.String("importer.elasticsearch.username", "", "Optional username for basic auth at -importer.elasticsearch.url")
+	elasticsearchPassword  = flagutil.NewPassword("importer.elasticsearch.password", "Optional password for basic auth at -importer.elasticsearch.url")
+	elasticsearchTimeField = flag.String("importer.elasticsearch.timeField", "@timestamp", "Document field with the log timestamp for -importer.source=elasticsearch. "+
+		"Documents are imported in the order of this field. Documents without this field are skipped")
+	elasticsearchTimeFormat = flag.String("importer.elasticsearch.timeFormat", insertutils.TimeFormatRFC3339, "The format of -importer.elasticsearch.timeField for -importer.source=elasticsearch. "+
+		"Supported values: rfc3339, unix_s, unix_ms, unix_us, unix_ns")
+	elasticsearchMsgField = flag.String("importer.elasticsearch.msgField", "message", "Document field with the log message for -importer.source=elasticsearch")
+)
+
+// elasticsearchRequestTimeout is the timeout for a single request to Elasticsearch.
+const elasticsearchRequestTimeout = time.Minute
+
+// elasticsearchCheckpoint is the import progress for -importer.source=elasticsearch.
+type elasticsearchCheckpoint struct {
+	// Index is the -importer.elasticsearch.index the checkpoint is created for.
+	Index string `json:"index"`
+
+	// Slices contains the import progress per each scroll slice.
+	Slices []elasticsearchSliceCheckpoint `json:"slices"`
+}
+
+// elasticsearchSliceCheckpoint is the import progress for a single scroll slice.
+//
+// Documents are imported in ascending order of -importer.elasticsearch.timeField.
+// So the import is resumed from the documents with LastTimestamp, while the documents with LastIDs are skipped.
+type elasticsearchSliceCheckpoint struct {
+	// Done is set to true after all the documents for the slice are imported.
+	Done bool `json:"done,omitempty"`
+
+	// LastTimestamp is the timestamp in nanoseconds for the last imported document.
+	LastTimestamp int64 `json:"lastTimestamp,omitempty"`
+
+	// LastIDs contains ids for the imported documents with LastTimestamp.
+	LastIDs []string `json:"lastIDs,omitempty"`
+}
+
+// elasticsearchConfig is the configuration for importing logs from Elasticsearch.
+type elasticsearchConfig struct {
+	url           string
+	index         string
+	query         json.RawMessage
+	batchSize     int
+	scrollTimeout time.Duration
+	username      string
+	password      string
+	timeField     string
+	timeFormat    string
+	msgField      string
+}
+
+func getElasticsearchConfig() (*elasticsearchConfig, error) {
+	if *elasticsearchURL == "" {
+		return nil, fmt.Errorf("missing -importer.elasticsearch.url")
+	}
+	if *elasticsearchIndex == "" {
+		return nil, fmt.Errorf("missing -importer.elasticsearch.index")
+	}
+	query := json.RawMessage(`{"match_all":{}}`)
+	if *elasticsearchQuery != "" {
+		if !json.Valid([]byte(*elasticsearchQuery)) {
+			return nil, fmt.Errorf("-importer.elasticsearch.query must contain valid JSON; got %q", *elasticsearchQuery)
+		}
+		query = json.RawMessage(*elasticsearchQuery)
+	}
+	if *elasticsearchBatchSize <= 0 {
+		return nil, fmt.Errorf("-importer.elasticsearch.batchSize must be positive; got %d", *elasticsearchBatchSize)
+	}
+	if *elasticsearchScrollTimeout < time.Second {
+		return nil, fmt.Errorf("-importer.elasticsearch.scrollTimeout cannot be smaller than 1s; got %s", *elasticsearchScrollTimeout)
+	}
+	if *elasticsearchTimeField == "" {
+		return nil, fmt.Errorf("-importer.elasticsearch.timeField cannot be empty")
+	}
+	if err := insertutils.ValidateTimeFormat(*elasticsearchTimeFormat); err != nil {
+		return nil, fmt.Errorf("invalid -importer.elasticsearch.timeFormat: %w", err)
+	}
+	ec := &elasticsearchConfig{
+		url:           strings.TrimSuffix(*elasticsearchURL, "/"),
+		index:         *elasticsearchIndex,
+		query:         query,
+		batchSize:     *elasticsearchBatchSize,
+		scrollTimeout: *elasticsearchScrollTimeout,
+		username:      *elasticsearchUsername,
+		password:      elasticsearchPassword.Get(),
+		timeField:     *elasticsearchTimeField,
+		timeFormat:    *elasticsearchTimeFormat,
+		msgField:      *elasticsearchMsgField,
+	}
+	return ec, nil
+}
+
+// newElasticsearchImporter returns importer for logs from -importer.elasticsearch.url.
+func newElasticsearchImporter(ic *importerConfig) (*importer, error) {
+	ec, err := getElasticsearchConfig()
+	if err != nil {
+		return nil, err
+	}
+	cpt, err := loadCheckpoint(ic.checkpointPath, sourceElasticsearch)
+	if err != nil {
+		return nil, err
+	}
+	imp := newImporter(ic, cpt)
+	return newElasticsearchImporterInternal(imp, ec)
+}
+
+func newElasticsearchImporterInternal(imp *importer, ec *elasticsearchConfig) (*importer, error) {
+	// Every worker reads a distinct slice of documents, so the number of slices must be preserved across restarts.
+	slicesCount := imp.ic.concurrency
+	if imp.cpt.data.Elasticsearch == nil {
+		imp.cpt.data.Elasticsearch = &elasticsearchCheckpoint{
+			Index:  ec.index,
+			Slices: make([]elasticsearchSliceCheckpoint, slicesCount),
+		}
+	}
+	ecpt := imp.cpt.data.Elasticsearch
+	if ecpt.Index != ec.index {
+		return nil, fmt.Errorf("the import progress at %q is created for -importer.elasticsearch.index=%q; remove this file or set distinct -importer.checkpointPath "+
+			"for importing logs from -importer.elasticsearch.index=%q", imp.cpt.path, ecpt.Index, ec.index)
+	}
+	if len(ecpt.Slices) != slicesCount {
+		return nil, fmt.Errorf("the import progress at %q is created for -importer.concurrency=%d; set the same -importer.concurrency for resuming the import",
+			imp.cpt.path, len(ecpt.Slices))
+	}
+
+	c := &http.Client{
+		Timeout: elasticsearchRequestTimeout,
+	}
+	for sliceID := 0; sliceID < slicesCount; sliceID++ {
+		s := &elasticsearchSlice{
+			imp: imp,
+			ec:  ec,
+			c:   c,
+
+			id:    sliceID,
+			count: slicesCount,
+		}
+		imp.workers = append(imp.workers, s.run)
+	}
+	return imp, nil
+}
+
+// elasticsearchSlice imports documents for a single scroll slice.
+//
+// See https://www.elastic.co/guide/en/elasticsearch/reference/current/paginate-search-results.html#slice-scroll
+type elasticsearchSlice struct {
+	imp *importer
+	ec  *elasticsearchConfig
+	c   *http.Client
+
+	// id is the slice id.
+	id int
+
+	// count is the total number of slices.
+	count int
+}
+
+// run imports documents for s until all the documents are imported or until ctx is canceled.
+//
+// The import is restarted from the last saved progress on errors.
+func (s *elasticsearchSlice) run(ctx context.Context) {
+	for {
+		scpt := s.getCheckpoint()
+		if scpt.Done {
+			return
+		}
+		err := s.importDocuments(ctx, &scpt)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			s.imp.cpt.update(func(data *checkpointData) {
+				data.Elasticsearch.Slices[s.id].Done = true
+			})
+			logger.Infof("importer: finished importing slice %d out of %d from -importer.elasticsearch.index=%q", s.id, s.count, s.ec.index)
+			return
+		}
+		s.imp.errorsTotal.Inc()
+		logger.Errorf("importer: cannot import slice %d out of %d from -importer.elasticsearch.index=%q: %s; retrying in %s",
+			s.id, s.count, s.ec.index, err, errorRetryInterval)
+		if !sleepCtx(ctx, errorRetryInterval) {
+			return
+		}
+	}
+}
+
+func (s *elasticsearchSlice) getCheckpoint() elasticsearchSliceCheckpoint {
+	var scpt elasticsearchSliceCheckpoint
+	s.imp.cpt.read(func(data *checkpointData) {
+		scpt = data.Elasticsearch.Slices[s.id]
+		scpt.LastIDs = append([]string{}, scpt.LastIDs...)
+	})
+	return scpt
+}
+
+// elasticsearchSearchResponse is the response for search and scroll requests.
+type elasticsearchSearchResponse struct {
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []elasticsearchHit `json:"hits"`
+	} `json:"hits"`
+}
+
+type elasticsearchHit struct {
+	ID     string          `json:"_id"`
+	Source json.RawMessage `json:"_source"`
+}
+
+// importDocuments imports documents for s starting from the progress at scpt.
+//
+// scpt is updated with the progress after every imported batch of documents.
+func (s *elasticsearchSlice) importDocuments(ctx context.Context, scpt *elasticsearchSliceCheckpoint) error {
+	reqBody, err := s.getSearchRequestBody(scpt)
+	if err != nil {
+		return err
+	}
+	scroll := fmt.Sprintf("%ds", int64(s.ec.scrollTimeout.Seconds()))
+	path := "/" + s.ec.index + "/_search?scroll=" + scroll
+	var resp elasticsearchSearchResponse
+	if err := s.doRequest(ctx, http.MethodPost, path, reqBody, &resp); err != nil {
+		return fmt.Errorf("cannot start scroll search: %w", err)
+	}
+
+	defer func() {
+		if resp.ScrollID != "" {
+			s.clearScroll(resp.ScrollID)
+		}
+	}()
+
+	for len(resp.Hits.Hits) > 0 {
+		if !s.imp.waitForStorage(ctx) {
+			return ctx.Err()
+		}
+		s.processHits(resp.Hits.Hits, scpt)
+
+		scrollReq := map[string]string{
+			"scroll":    scroll,
+			"scroll_id": resp.ScrollID,
+		}
+		reqBody, err := json.Marshal(scrollReq)
+		if err != nil {
+			logger.Panicf("BUG: cannot marshal scroll request: %s", err)
+		}
+		prevScrollID := resp.ScrollID
+		resp = elasticsearchSearchResponse{}
+		if err := s.doRequest(ctx, http.MethodPost, "/_search/scroll", reqBody, &resp); err != nil {
+			resp.ScrollID = prevScrollID
+			return fmt.Errorf("cannot continue scroll search: %w", err)
+		}
+		if resp.ScrollID == "" {
+			resp.ScrollID = prevScrollID
+		}
+	}
+	return nil
+}
+
+// getSearchRequestBody returns the body for the initial search request for the documents, which aren't imported yet according to scpt.
+func (s *elasticsearchSlice) getSearchRequestBody(scpt *elasticsearchSliceCheckpoint) ([]byte, error) {
+	filters := []any{
+		s.ec.query,
+		map[string]any{
+			"exists": map[string]any{
+				"field": s.ec.timeField,
+			},
+		},
+	}
+	if len(scpt.LastIDs) > 0 {
+		filters = append(filters, map[string]any{
+			"range": map[string]any{
+				s.ec.timeField: map[string]any{
+					"gte":    time.Unix(0, scpt.LastTimestamp).UTC().Format(time.RFC3339Nano),
+					"format": "strict_date_optional_time_nanos",
+				},
+			},
+		})
+	}
+	req := map[string]any{
+		"size": s.ec.batchSize,
+		"sort": []any{
+			map[string]any{
+				s.ec.timeField: "asc",
+			},
+		},
+		"query": map[string]any{
+			"bool": map[string]any{
+				"filter": filters,
+			},
+		},
+	}
+	if s.count > 1 {
+		req["slice"] = map[string]any{
+			"id":  s.id,
+			"max": s.count,
+		}
+	}
+	return json.Marshal(req)
+}
+
+// processHits writes logs from hits to the storage and updates the progress at scpt after that.
+//
+// The hits must be sorted by -importer.elasticsearch.timeField.
+func (s *elasticsearchSlice) processHits(hits []elasticsearchHit, scpt *elasticsearchSliceCheckpoint) {
+	skipIDs := make(map[string]struct{}, len(scpt.LastIDs))
+	for _, id := range scpt.LastIDs {
+		skipIDs[id] = struct{}{}
+	}
+
+	p := logstorage.GetJSONParser()
+	defer logstorage.PutJSONParser(p)
+
+	lmp := s.imp.newLogMessageProcessor(s.imp.ic.cp)
+	rowsImported := 0
+	for _, hit := range hits {
+		if err := p.ParseLogMessage(hit.Source); err != nil {
+			s.imp.invalidRowsTotal.Inc()
+			invalidDocumentsLogger.Warnf("importer: skipping document with _id=%q from -importer.elasticsearch.index=%q: cannot parse _source: %s", hit.ID, s.ec.index, err)
+			continue
+		}
+		ts, err := insertutils.ExtractTimestampFromFields(s.ec.timeField, s.ec.timeFormat, p.Fields)
+		if err != nil {
+			s.imp.invalidRowsTotal.Inc()
+			invalidDocumentsLogger.Warnf("importer: skipping document with _id=%q from -importer.elasticsearch.index=%q: %s", hit.ID, s.ec.index, err)
+			continue
+		}
+
+		switch {
+		case ts < scpt.LastTimestamp:
+			// The document is already imported, since the documents are sorted by timestamp.
+			continue
+		case ts == scpt.LastTimestamp:
+			if _, ok := skipIDs[hit.ID]; ok {
+				// The document is already imported before the restart.
+				continue
+			}
+			scpt.LastIDs = append(scpt.LastIDs, hit.ID)
+		default:
+			scpt.LastTimestamp = ts
+			scpt.LastIDs = append(scpt.LastIDs[:0], hit.ID)
+			clear(skipIDs)
+		}
+
+		logstorage.RenameField(p.Fields, s.ec.msgField, "_msg")
+		s.imp.ic.applyFieldsMapping(p.Fields)
+		lmp.AddRow(ts, p.Fields)
+		rowsImported++
+	}
+	lmp.MustClose()
+
+	s.imp.rowsImportedTotal.Add(rowsImported)
+	rowsIngestedTotal.Add(rowsImported)
+
+	lastTimestamp := scpt.LastTimestamp
+	lastIDs := append([]string{}, scpt.LastIDs...)
+	s.imp.cpt.update(func(data *checkpointData) {
+		sc := &data.Elasticsearch.Slices[s.id]
+		sc.LastTimestamp = lastTimestamp
+		sc.LastIDs = lastIDs
+	})
+}
+
+var invalidDocumentsLogger = logger.WithThrottler("importer_invalid_document", 5*time.Second)
+
+// clearScroll releases resources for the scroll with the given scrollID at Elasticsearch.
+func (s *elasticsearchSlice) clearScroll(scrollID string) {
+	req := map[string][]string{
+		"scroll_id": {scrollID},
+	}
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal clear scroll request: %s", err)
+	}
+	if err := s.doRequest(context.Background(), http.MethodDelete, "/_search/scroll", reqBody, nil); err != nil {
+		// The scroll is released by Elasticsearch after -importer.elasticsearch.scrollTimeout, so just log the error.
+		logger.Warnf("importer: cannot clear scroll at -importer.elasticsearch.url=%q: %s", s.ec.url, err)
+	}
+}
+
+// doRequest sends the request with the given method, path and body to Elasticsearch and decodes JSON response into dst if it isn't nil.
+func (s *elasticsearchSlice) doRequest(ctx context.Context, method, path string, body []byte, dst any) error {
+	reqURL := s.ec.url + path
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cannot create request to %q: %w", reqURL, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.ec.username != "" || s.ec.password != "" {
+		req.SetBasicAuth(s.ec.username, s.ec.password)
+	}
+	resp, err := s.c.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot send request to %q: %w", reqURL, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("cannot read response from %q: %w", reqURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code returned from %q: %d; want %d; response body: %q", reqURL, resp.StatusCode, http.StatusOK, data)
+	}
+	if dst == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return fmt.Errorf("cannot parse response from %q: %w", reqURL, err)
+	}
+	return nil
+}
@@ -0,0 +1,346 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlinsert/insertutils"
+)
+
+func TestElasticsearchImporter(t *testing.T) {
+	// Multiple documents with the same timestamp must be imported without duplicates and gaps across batches and restarts.
+	var docs []testElasticsearchDocument
+	for i := 0; i < 20; i++ {
+		docs = append(docs, testElasticsearchDocument{
+			id:        fmt.Sprintf("doc%02d", i),
+			timestamp: int64(1e9 * (i / 3)),
+			message:   fmt.Sprintf("message %d", i),
+		})
+	}
+
+	f := func(concurrency int, interruptAfterRequests int) {
+		t.Helper()
+
+		es := newTestElasticsearch(t, "logs-*", docs)
+		defer es.s.Close()
+
+		ic := &importerConfig{
+			source:         sourceElasticsearch,
+			concurrency:    concurrency,
+			checkpointPath: filepath.Join(t.TempDir(), "importer_checkpoint.json"),
+			cp:             &insertutils.CommonParams{},
+		}
+		ec := &elasticsearchConfig{
+			url:           es.s.URL,
+			index:         "logs-*",
+			query:         json.RawMessage(`{"match_all":{}}`),
+			batchSize:     testElasticsearchBatchSize,
+			scrollTimeout: time.Minute,
+			username:      "foo",
+			password:      "bar",
+			timeField:     "@timestamp",
+			timeFormat:    insertutils.TimeFormatRFC3339,
+			msgField:      "message",
+		}
+		var tc testCollector
+		runImport := func(ctx context.Context) {
+			t.Helper()
+
+			imp := newTestImporter(t, ic, &tc)
+			imp, err := newElasticsearchImporterInternal(imp, ec)
+			if err != nil {
+				t.Fatalf("cannot create importer: %s", err)
+			}
+			imp.run(ctx)
+		}
+
+		if interruptAfterRequests > 0 {
+			// Interrupt the import after the given number of scroll requests, so it must be resumed from the checkpoint.
+			ctx, cancel := context.WithCancel(context.Background())
+			es.onScroll = func(n int) bool {
+				if n < interruptAfterRequests {
+					return false
+				}
+				cancel()
+				return true
+			}
+			runImport(ctx)
+			cancel()
+			es.onScroll = nil
+
+			if n := len(tc.getRows()); n == 0 || n >= len(docs) {
+				t.Fatalf("unexpected number of rows imported before the interruption: %d; want (0..%d)", n, len(docs))
+			}
+		}
+		runImport(context.Background())
+
+		rows := tc.getRows()
+		var rowsExpected []string
+		for _, doc := range docs {
+			rowsExpected = append(rowsExpected, fmt.Sprintf(`%d [] {"@timestamp":"","_msg":%q}`, doc.timestamp, doc.message))
+		}
+		sort.Strings(rowsExpected)
+		if !reflect.DeepEqual(rows, rowsExpected) {
+			t.Fatalf("unexpected rows\ngot\n%s\nwant\n%s", strings.Join(rows, "\n"), strings.Join(rowsExpected, "\n"))
+		}
+
+		if n := es.getScrollsCount(); n != 0 {
+			t.Fatalf("unexpected number of scrolls left open: %d", n)
+		}
+
+		// All the documents are imported, so the import mustn't read them again.
+		tc = testCollector{}
+		runImport(context.Background())
+		if rows := tc.getRows(); len(rows) > 0 {
+			t.Fatalf("unexpected rows imported after the finished import: %q", rows)
+		}
+	}
+
+	// single slice
+	f(1, 0)
+
+	// multiple slices
+	f(3, 0)
+
+	// interrupted import
+	f(1, 2)
+	f(2, 3)
+}
+
+func TestElasticsearchImporterCheckpointMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "importer_checkpoint.json")
+	cpt, err := loadCheckpoint(path, sourceElasticsearch)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cpt.update(func(data *checkpointData) {
+		data.Elasticsearch = &elasticsearchCheckpoint{
+			Index:  "foo",
+			Slices: make([]elasticsearchSliceCheckpoint, 2),
+		}
+	})
+	cpt.mustSave()
+
+	f := func(index string, concurrency int) {
+		t.Helper()
+
+		ic := &importerConfig{
+			source:         sourceElasticsearch,
+			concurrency:    concurrency,
+			checkpointPath: path,
+			cp:             &insertutils.CommonParams{},
+		}
+		ec := &elasticsearchConfig{
+			index: index,
+		}
+		imp := newTestImporter(t, ic, &testCollector{})
+		if _, err := newElasticsearchImporterInternal(imp, ec); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// another index
+	f("bar", 2)
+
+	// another concurrency
+	f("foo", 3)
+}
+
+// testElasticsearchBatchSize is the number of documents returned per each scroll request by testElasticsearch.
+const testElasticsearchBatchSize = 4
+
+type testElasticsearchDocument struct {
+	id        string
+	timestamp int64
+	message   string
+}
+
+// testElasticsearch is a fake Elasticsearch, which supports sorted and sliced scroll search requests.
+type testElasticsearch struct {
+	t     *testing.T
+	s     *httptest.Server
+	index string
+	docs  []testElasticsearchDocument
+
+	// onScroll is called with the number of received scroll requests.
+	//
+	// The scroll request fails if onScroll returns true.
+	onScroll func(n int) bool
+
+	mu           sync.Mutex
+	scrolls      map[string][]testElasticsearchDocument
+	scrollsTotal int
+	scrollReqs   int
+}
+
+func newTestElasticsearch(t *testing.T, index string, docs []testElasticsearchDocument) *testElasticsearch {
+	es := &testElasticsearch{
+		t:       t,
+		index:   index,
+		docs:    docs,
+		scrolls: make(map[string][]testElasticsearchDocument),
+	}
+	es.s = httptest.NewServer(http.HandlerFunc(es.handler))
+	return es
+}
+
+func (es *testElasticsearch) getScrollsCount() int {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	return len(es.scrolls)
+}
+
+func (es *testElasticsearch) handler(w http.ResponseWriter, r *http.Request) {
+	if username, password, ok := r.BasicAuth(); !ok || username != "foo" || password != "bar" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/"+es.index+"/_search":
+		es.handleSearch(w, r)
+	case r.Method == http.MethodPost && r.URL.Path == "/_search/scroll":
+		es.handleScroll(w, r)
+	case r.Method == http.MethodDelete && r.URL.Path == "/_search/scroll":
+		var req struct {
+			ScrollID []string `json:"scroll_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			es.t.Errorf("cannot parse clear scroll request: %s", err)
+		}
+		es.mu.Lock()
+		for _, id := range req.ScrollID {
+			delete(es.scrolls, id)
+		}
+		es.mu.Unlock()
+		_, _ = w.Write([]byte(`{"succeeded":true}`))
+	default:
+		es.t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (es *testElasticsearch) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("scroll") == "" {
+		es.t.Errorf("missing scroll query arg")
+	}
+	var req struct {
+		Size  int `json:"size"`
+		Slice *struct {
+			ID  int `json:"id"`
+			Max int `json:"max"`
+		} `json:"slice"`
+		Query struct {
+			Bool struct {
+				Filter []struct {
+					Range map[string]struct {
+						Gte string `json:"gte"`
+					} `json:"range"`
+				} `json:"filter"`
+			} `json:"bool"`
+		} `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		es.t.Errorf("cannot parse search request: %s", err)
+	}
+
+	minTimestamp := int64(-1 << 63)
+	for _, f := range req.Query.Bool.Filter {
+		if rf, ok := f.Range["@timestamp"]; ok {
+			t, err := time.Parse(time.RFC3339Nano, rf.Gte)
+			if err != nil {
+				es.t.Errorf("cannot parse range filter: %s", err)
+			}
+			minTimestamp = t.UnixNano()
+		}
+	}
+
+	var docs []testElasticsearchDocument
+	for i, doc := range es.docs {
+		if req.Slice != nil && i%req.Slice.Max != req.Slice.ID {
+			continue
+		}
+		if doc.timestamp < minTimestamp {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	// Documents with the same timestamp are returned in arbitrary order.
+	sort.SliceStable(docs, func(i, j int) bool {
+		if docs[i].timestamp != docs[j].timestamp {
+			return docs[i].timestamp < docs[j].timestamp
+		}
+		return docs[i].id > docs[j].id
+	})
+
+	es.mu.Lock()
+	es.scrollsTotal++
+	scrollID := "scroll" + strconv.Itoa(es.scrollsTotal)
+	es.mu.Unlock()
+
+	es.writeScrollResponse(w, scrollID, docs, req.Size)
+}
+
+func (es *testElasticsearch) handleScroll(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ScrollID string `json:"scroll_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		es.t.Errorf("cannot parse scroll request: %s", err)
+	}
+
+	es.mu.Lock()
+	docs, ok := es.scrolls[req.ScrollID]
+	es.scrollReqs++
+	n := es.scrollReqs
+	onScroll := es.onScroll
+	es.mu.Unlock()
+
+	if onScroll != nil && onScroll(n) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	es.writeScrollResponse(w, req.ScrollID, docs, testElasticsearchBatchSize)
+}
+
+func (es *testElasticsearch) writeScrollResponse(w http.ResponseWriter, scrollID string, docs []testElasticsearchDocument, size int) {
+	n := min(size, len(docs))
+	es.mu.Lock()
+	es.scrolls[scrollID] = docs[n:]
+	es.mu.Unlock()
+
+	hits := make([]map[string]any, 0, n)
+	for _, doc := range docs[:n] {
+		hits = append(hits, map[string]any{
+			"_id": doc.id,
+			"_source": map[string]any{
+				"@timestamp": time.Unix(0, doc.timestamp).UTC().Format(time.RFC3339Nano),
+				"message":    doc.message,
+			},
+		})
+	}
+	resp := map[string]any{
+		"_scroll_id": scrollID,
+		"hits": map[string]any{
+			"hits": hits,
+		},
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		es.t.Errorf("cannot write response: %s", err)
+	}
+}
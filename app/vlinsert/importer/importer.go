@@ -0,0 +1,422 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlinsert/insertutils"
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timerpool"
+)
+
+var (
+	source = flag.String("importer.source", "", "Optional source to import logs from on startup. Supported values: loki, elasticsearch. "+
+		"See https://docs.victoriametrics.com/victorialogs/data-ingestion/importer/")
+	concurrency = flag.Int("importer.concurrency", 4, "The number of concurrent workers for importing logs from -importer.source. "+
+		"Workers read distinct Loki chunks or distinct Elasticsearch scroll slices")
+	checkpointPath = flag.String("importer.checkpointPath", "", "Path to file for the import progress from -importer.source. "+
+		"The import is resumed from this file after the restart. By default importer_checkpoint.json from -storageDataPath is used")
+	tenantID = flag.String("importer.tenantID", "", "TenantID for logs imported from -importer.source. "+
+		"See https://docs.victoriametrics.com/victorialogs/#multitenancy")
+	fieldsMapping = flag.String("importer.fieldsMapping", "", "Optional list of field renames for logs imported from -importer.source "+
+		"in the form src1=dst1;...;srcN=dstN. For example, -importer.fieldsMapping='log.level=level;kubernetes.pod.name=pod'")
+	streamFields = flag.String("importer.streamFields", "", "List of stream fields for logs imported from -importer.source. Multiple fields must be delimited by ';'. "+
+		"By default Loki labels are used as stream fields for logs imported from Loki. See https://docs.victoriametrics.com/victorialogs/keyconcepts/#stream-fields")
+	ignoreFields = flag.String("importer.ignoreFields", "", "List of log fields to ignore for logs imported from -importer.source. Multiple fields must be delimited by ';'")
+)
+
+// Supported values for -importer.source.
+const (
+	sourceLoki          = "loki"
+	sourceElasticsearch = "elasticsearch"
+)
+
+// checkpointSaveInterval is the interval for saving the import progress to -importer.checkpointPath.
+const checkpointSaveInterval = 5 * time.Second
+
+// importerConfig is the configuration for importing logs.
+type importerConfig struct {
+	source         string
+	concurrency    int
+	checkpointPath string
+
+	// fieldsMapping contains field renames from -importer.fieldsMapping.
+	fieldsMapping []fieldMapping
+
+	cp *insertutils.CommonParams
+}
+
+// fieldMapping is a single rename from -importer.fieldsMapping.
+type fieldMapping struct {
+	src string
+	dst string
+}
+
+// getImporterConfig returns the config for importing logs from -importer.source.
+//
+// nil is returned if -importer.source isn't set.
+func getImporterConfig() (*importerConfig, error) {
+	if *source == "" {
+		return nil, nil
+	}
+	switch *source {
+	case sourceLoki, sourceElasticsearch:
+	default:
+		return nil, fmt.Errorf("unsupported -importer.source=%q; supported values: %s, %s", *source, sourceLoki, sourceElasticsearch)
+	}
+	if *concurrency <= 0 {
+		return nil, fmt.Errorf("-importer.concurrency must be positive; got %d", *concurrency)
+	}
+	tid, err := logstorage.ParseTenantID(*tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse -importer.tenantID=%q: %w", *tenantID, err)
+	}
+	fms, err := parseFieldsMapping(*fieldsMapping)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse -importer.fieldsMapping=%q: %w", *fieldsMapping, err)
+	}
+
+	path := *checkpointPath
+	if path == "" {
+		path = filepath.Join(vlstorage.GetStorageDataPath(), "importer_checkpoint.json")
+	}
+
+	ic := &importerConfig{
+		source:         *source,
+		concurrency:    *concurrency,
+		checkpointPath: path,
+		fieldsMapping:  fms,
+
+		cp: &insertutils.CommonParams{
+			TenantID:     tid,
+			StreamFields: splitList(*streamFields),
+			IgnoreFields: splitList(*ignoreFields),
+		},
+	}
+	return ic, nil
+}
+
+func parseFieldsMapping(s string) ([]fieldMapping, error) {
+	var fms []fieldMapping
+	for _, item := range splitList(s) {
+		n := strings.IndexByte(item, '=')
+		if n < 0 {
+			return nil, fmt.Errorf("missing '=' in %q", item)
+		}
+		src := strings.TrimSpace(item[:n])
+		dst := strings.TrimSpace(item[n+1:])
+		if src == "" || dst == "" {
+			return nil, fmt.Errorf("source and destination field names cannot be empty in %q", item)
+		}
+		fms = append(fms, fieldMapping{
+			src: src,
+			dst: dst,
+		})
+	}
+	return fms, nil
+}
+
+// splitList splits ';'-delimited list s into items.
+func splitList(s string) []string {
+	var a []string
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			a = append(a, item)
+		}
+	}
+	return a
+}
+
+// applyFieldsMapping renames fields according to ic.fieldsMapping.
+func (ic *importerConfig) applyFieldsMapping(fields []logstorage.Field) {
+	for _, fm := range ic.fieldsMapping {
+		logstorage.RenameField(fields, fm.src, fm.dst)
+	}
+}
+
+// mapFieldName returns the name for the field with the given name according to ic.fieldsMapping.
+func (ic *importerConfig) mapFieldName(name string) string {
+	for _, fm := range ic.fieldsMapping {
+		if fm.src == name {
+			return fm.dst
+		}
+	}
+	return name
+}
+
+var (
+	importerWG     sync.WaitGroup
+	importerCancel func()
+)
+
+// MustInit starts importing logs from -importer.source.
+//
+// This function must be called after flag.Parse().
+//
+// MustStop() must be called in order to stop importing logs.
+func MustInit() {
+	if importerCancel != nil {
+		logger.Panicf("BUG: MustInit() called twice without MustStop() call")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	importerCancel = cancel
+
+	ic, err := getImporterConfig()
+	if err != nil {
+		logger.Fatalf("cannot initialize importer: %s", err)
+	}
+	if ic == nil {
+		return
+	}
+
+	var imp *importer
+	switch ic.source {
+	case sourceLoki:
+		imp, err = newLokiImporter(ic)
+	case sourceElasticsearch:
+		imp, err = newElasticsearchImporter(ic)
+	default:
+		logger.Panicf("BUG: unexpected source %q", ic.source)
+	}
+	if err != nil {
+		logger.Fatalf("cannot initialize importer for -importer.source=%q: %s", ic.source, err)
+	}
+
+	importerWG.Add(1)
+	go func() {
+		defer importerWG.Done()
+		imp.run(ctx)
+	}()
+}
+
+// MustStop stops importing logs from -importer.source.
+//
+// The import progress is saved to -importer.checkpointPath, so the import is resumed on the next start.
+func MustStop() {
+	importerCancel()
+	importerWG.Wait()
+	importerCancel = nil
+}
+
+// importer imports logs from the source with concurrent workers and saves the import progress to the checkpoint.
+type importer struct {
+	ic *importerConfig
+
+	// cpt is the import progress.
+	cpt *checkpoint
+
+	// workers contains functions for importing logs, which are executed concurrently.
+	//
+	// Every worker must return when ctx is canceled or when it has no more logs to import.
+	// Every worker must update cpt after the logs are written to the storage.
+	workers []func(ctx context.Context)
+
+	// canWriteData must return nil if the logs can be written to the storage.
+	canWriteData func() error
+
+	// newLogMessageProcessor must return LogMessageProcessor for writing logs with the given cp to the storage.
+	newLogMessageProcessor func(cp *insertutils.CommonParams) insertutils.LogMessageProcessor
+
+	rowsImportedTotal *metrics.Counter
+	invalidRowsTotal  *metrics.Counter
+	errorsTotal       *metrics.Counter
+}
+
+func newImporter(ic *importerConfig, cpt *checkpoint) *importer {
+	return &importer{
+		ic:  ic,
+		cpt: cpt,
+
+		canWriteData: func() error {
+			return vlstorage.CanWriteData(ic.cp.TenantID)
+		},
+		newLogMessageProcessor: func(cp *insertutils.CommonParams) insertutils.LogMessageProcessor {
+			return cp.NewLogMessageProcessor()
+		},
+
+		rowsImportedTotal: metrics.GetOrCreateCounter(fmt.Sprintf(`vl_importer_rows_imported_total{source=%q}`, ic.source)),
+		invalidRowsTotal:  metrics.GetOrCreateCounter(fmt.Sprintf(`vl_importer_invalid_rows_total{source=%q}`, ic.source)),
+		errorsTotal:       metrics.GetOrCreateCounter(fmt.Sprintf(`vl_importer_errors_total{source=%q}`, ic.source)),
+	}
+}
+
+// run runs imp workers until they are finished or until ctx is canceled.
+func (imp *importer) run(ctx context.Context) {
+	startTime := time.Now()
+	logger.Infof("importer: starting import from -importer.source=%q with %d workers; the progress is saved to %q", imp.ic.source, len(imp.workers), imp.cpt.path)
+
+	stopCh := make(chan struct{})
+	var saverWG sync.WaitGroup
+	saverWG.Add(1)
+	go func() {
+		defer saverWG.Done()
+		ticker := time.NewTicker(checkpointSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				imp.cpt.mustSave()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, worker := range imp.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker(ctx)
+		}()
+	}
+	wg.Wait()
+
+	close(stopCh)
+	saverWG.Wait()
+	imp.cpt.mustSave()
+
+	if ctx.Err() != nil {
+		logger.Infof("importer: import from -importer.source=%q is interrupted; it will be resumed from %q after the restart", imp.ic.source, imp.cpt.path)
+		return
+	}
+	logger.Infof("importer: finished import from -importer.source=%q in %.3f seconds; imported %d rows",
+		imp.ic.source, time.Since(startTime).Seconds(), imp.rowsImportedTotal.Get())
+}
+
+// waitForStorage waits until the storage is ready to accept new logs.
+//
+// false is returned if ctx is canceled.
+func (imp *importer) waitForStorage(ctx context.Context) bool {
+	for {
+		err := imp.canWriteData()
+		if err == nil {
+			return true
+		}
+		storageErrorLogger.Warnf("importer: pausing import from -importer.source=%q: %s", imp.ic.source, err)
+		if !sleepCtx(ctx, time.Second) {
+			return false
+		}
+	}
+}
+
+// sleepCtx sleeps for the given duration d.
+//
+// false is returned if ctx is canceled before d elapses.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := timerpool.Get(d)
+	defer timerpool.Put(t)
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+var storageErrorLogger = logger.WithThrottler("importer_storage_error", 5*time.Second)
+
+var rowsIngestedTotal = metrics.NewCounter(`vl_rows_ingested_total{type="importer"}`)
+
+// errorRetryInterval is the interval between retries after import errors.
+const errorRetryInterval = 5 * time.Second
+
+// checkpoint contains the import progress.
+//
+// It is saved to -importer.checkpointPath, so the import can be resumed after the restart.
+type checkpoint struct {
+	path string
+
+	mu sync.Mutex
+
+	// data contains the import progress.
+	data checkpointData
+
+	// modified is set to true when data is modified after the last save.
+	modified bool
+}
+
+// checkpointData is the import progress stored at -importer.checkpointPath.
+type checkpointData struct {
+	// Source is the -importer.source the checkpoint is created for.
+	Source string `json:"source"`
+
+	// Loki contains the progress for -importer.source=loki.
+	Loki *lokiCheckpoint `json:"loki,omitempty"`
+
+	// Elasticsearch contains the progress for -importer.source=elasticsearch.
+	Elasticsearch *elasticsearchCheckpoint `json:"elasticsearch,omitempty"`
+}
+
+// loadCheckpoint loads the import progress for the given source from the file at path.
+//
+// An empty checkpoint is returned if the file at path doesn't exist.
+func loadCheckpoint(path, source string) (*checkpoint, error) {
+	cpt := &checkpoint{
+		path: path,
+		data: checkpointData{
+			Source: source,
+		},
+	}
+	if !fs.IsPathExist(path) {
+		return cpt, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger.Panicf("FATAL: cannot read import progress: %s", err)
+	}
+	if err := json.Unmarshal(data, &cpt.data); err != nil {
+		return nil, fmt.Errorf("cannot parse import progress from %q: %w", path, err)
+	}
+	if cpt.data.Source != source {
+		return nil, fmt.Errorf("the import progress at %q is created for -importer.source=%q; remove this file or set distinct -importer.checkpointPath "+
+			"for importing logs from -importer.source=%q", path, cpt.data.Source, source)
+	}
+	return cpt, nil
+}
+
+// read calls f for reading the data for cpt.
+func (cpt *checkpoint) read(f func(data *checkpointData)) {
+	cpt.mu.Lock()
+	f(&cpt.data)
+	cpt.mu.Unlock()
+}
+
+// update calls f for updating the data for cpt.
+func (cpt *checkpoint) update(f func(data *checkpointData)) {
+	cpt.mu.Lock()
+	f(&cpt.data)
+	cpt.modified = true
+	cpt.mu.Unlock()
+}
+
+// mustSave saves cpt to cpt.path if it has been modified since the last save.
+func (cpt *checkpoint) mustSave() {
+	cpt.mu.Lock()
+	defer cpt.mu.Unlock()
+
+	if !cpt.modified {
+		return
+	}
+	data, err := json.Marshal(&cpt.data)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal import progress: %s", err)
+	}
+	fs.MustMkdirIfNotExist(filepath.Dir(cpt.path))
+	fs.MustWriteAtomic(cpt.path, data, true)
+	cpt.modified = false
+}
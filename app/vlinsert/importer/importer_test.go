@@ -0,0 +1,252 @@
+package importer
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlinsert/insertutils"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+func TestGetImporterConfigSuccess(t *testing.T) {
+	defer resetFlags()
+
+	// Disabled importer
+	ic, err := getImporterConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ic != nil {
+		t.Fatalf("expecting nil config when -importer.source isn't set; got %+v", ic)
+	}
+
+	*source = sourceLoki
+	*concurrency = 2
+	*checkpointPath = "/tmp/checkpoint.json"
+	*tenantID = "12:34"
+	*fieldsMapping = "log.level=level; service.name = service"
+	*streamFields = "service;host"
+	*ignoreFields = "password"
+
+	ic, err = getImporterConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	icExpected := &importerConfig{
+		source:         sourceLoki,
+		concurrency:    2,
+		checkpointPath: "/tmp/checkpoint.json",
+		fieldsMapping: []fieldMapping{
+			{
+				src: "log.level",
+				dst: "level",
+			},
+			{
+				src: "service.name",
+				dst: "service",
+			},
+		},
+		cp: &insertutils.CommonParams{
+			TenantID: logstorage.TenantID{
+				AccountID: 12,
+				ProjectID: 34,
+			},
+			StreamFields: []string{"service", "host"},
+			IgnoreFields: []string{"password"},
+		},
+	}
+	if !reflect.DeepEqual(ic, icExpected) {
+		t.Fatalf("unexpected config\ngot\n%+v\n%+v\nwant\n%+v\n%+v", ic, ic.cp, icExpected, icExpected.cp)
+	}
+}
+
+func TestGetImporterConfigFailure(t *testing.T) {
+	f := func(setFlags func()) {
+		t.Helper()
+		defer resetFlags()
+
+		*source = sourceElasticsearch
+		setFlags()
+
+		if _, err := getImporterConfig(); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// unsupported source
+	f(func() {
+		*source = "foo"
+	})
+
+	// invalid concurrency
+	f(func() {
+		*concurrency = 0
+	})
+
+	// invalid tenant
+	f(func() {
+		*tenantID = "foo"
+	})
+
+	// invalid fields mapping
+	f(func() {
+		*fieldsMapping = "foo"
+	})
+	f(func() {
+		*fieldsMapping = "foo="
+	})
+	f(func() {
+		*fieldsMapping = "=bar"
+	})
+}
+
+func TestImporterConfigFieldsMapping(t *testing.T) {
+	ic := &importerConfig{
+		fieldsMapping: []fieldMapping{
+			{
+				src: "log.level",
+				dst: "level",
+			},
+			{
+				src: "message",
+				dst: "_msg",
+			},
+		},
+	}
+
+	fields := []logstorage.Field{
+		{
+			Name:  "log.level",
+			Value: "error",
+		},
+		{
+			Name:  "message",
+			Value: "foo",
+		},
+		{
+			Name:  "host",
+			Value: "bar",
+		},
+	}
+	ic.applyFieldsMapping(fields)
+	resultExpected := `{"level":"error","_msg":"foo","host":"bar"}`
+	if result := string(logstorage.MarshalFieldsToJSON(nil, fields)); result != resultExpected {
+		t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	if name := ic.mapFieldName("log.level"); name != "level" {
+		t.Fatalf("unexpected mapped name; got %q; want %q", name, "level")
+	}
+	if name := ic.mapFieldName("host"); name != "host" {
+		t.Fatalf("unexpected mapped name; got %q; want %q", name, "host")
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint", "importer_checkpoint.json")
+
+	// Missing checkpoint file
+	cpt, err := loadCheckpoint(path, sourceLoki)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cpt.mustSave()
+	if fs.IsPathExist(path) {
+		t.Fatalf("unmodified checkpoint mustn't be saved")
+	}
+
+	// Modified checkpoint must be saved and loaded back
+	cpt.update(func(data *checkpointData) {
+		data.Loki = &lokiCheckpoint{
+			Chunks: []string{"fake/chunk1", "fake/chunk2"},
+		}
+	})
+	cpt.mustSave()
+	cpt, err = loadCheckpoint(path, sourceLoki)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	dataExpected := checkpointData{
+		Source: sourceLoki,
+		Loki: &lokiCheckpoint{
+			Chunks: []string{"fake/chunk1", "fake/chunk2"},
+		},
+	}
+	if !reflect.DeepEqual(cpt.data, dataExpected) {
+		t.Fatalf("unexpected checkpoint data\ngot\n%+v\nwant\n%+v", cpt.data, dataExpected)
+	}
+
+	// The checkpoint for another source mustn't be used
+	if _, err := loadCheckpoint(path, sourceElasticsearch); err == nil {
+		t.Fatalf("expecting non-nil error when loading the checkpoint for another source")
+	}
+}
+
+func resetFlags() {
+	*source = ""
+	*concurrency = 4
+	*checkpointPath = ""
+	*tenantID = ""
+	*fieldsMapping = ""
+	*streamFields = ""
+	*ignoreFields = ""
+}
+
+// newTestImporter returns importer, which writes imported logs to tc.
+func newTestImporter(t *testing.T, ic *importerConfig, tc *testCollector) *importer {
+	t.Helper()
+
+	cpt, err := loadCheckpoint(ic.checkpointPath, ic.source)
+	if err != nil {
+		t.Fatalf("cannot load checkpoint: %s", err)
+	}
+	imp := newImporter(ic, cpt)
+	imp.canWriteData = func() error {
+		return nil
+	}
+	imp.newLogMessageProcessor = func(cp *insertutils.CommonParams) insertutils.LogMessageProcessor {
+		return &testLogMessageProcessor{
+			tc: tc,
+			cp: cp,
+		}
+	}
+	return imp
+}
+
+// testCollector collects rows written by testLogMessageProcessor from concurrently running workers.
+type testCollector struct {
+	mu   sync.Mutex
+	rows []string
+}
+
+// getRows returns sorted rows collected by tc.
+func (tc *testCollector) getRows() []string {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	rows := append([]string{}, tc.rows...)
+	sort.Strings(rows)
+	return rows
+}
+
+type testLogMessageProcessor struct {
+	tc *testCollector
+	cp *insertutils.CommonParams
+}
+
+func (lmp *testLogMessageProcessor) AddRow(timestamp int64, fields []logstorage.Field) {
+	row := fmt.Sprintf("%d [%s] %s", timestamp, strings.Join(lmp.cp.StreamFields, ","), logstorage.MarshalFieldsToJSON(nil, fields))
+
+	lmp.tc.mu.Lock()
+	lmp.tc.rows = append(lmp.tc.rows, row)
+	lmp.tc.mu.Unlock()
+}
+
+func (lmp *testLogMessageProcessor) MustClose() {
+}
@@ -0,0 +1,159 @@
+package importer
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+var lokiChunksPath = flag.String("importer.loki.chunksPath", "", "Path to the directory with Loki chunks for -importer.source=loki. "+
+	"This is usually the chunks_directory from Loki filesystem storage config or a local copy of Loki chunks from object storage. "+
+	"See https://docs.victoriametrics.com/victorialogs/data-ingestion/importer/#loki")
+
+// lokiCheckpoint is the import progress for -importer.source=loki.
+type lokiCheckpoint struct {
+	// Chunks contains paths to the imported chunks relative to -importer.loki.chunksPath.
+	Chunks []string `json:"chunks"`
+}
+
+var lokiChunksImportedTotal = metrics.NewCounter(`vl_importer_loki_chunks_imported_total`)
+
+// newLokiImporter returns importer for Loki chunks from -importer.loki.chunksPath.
+func newLokiImporter(ic *importerConfig) (*importer, error) {
+	if *lokiChunksPath == "" {
+		return nil, fmt.Errorf("missing -importer.loki.chunksPath")
+	}
+	cpt, err := loadCheckpoint(ic.checkpointPath, sourceLoki)
+	if err != nil {
+		return nil, err
+	}
+	imp := newImporter(ic, cpt)
+	return newLokiImporterInternal(imp, *lokiChunksPath)
+}
+
+func newLokiImporterInternal(imp *importer, chunksPath string) (*importer, error) {
+	chunkPaths, err := getLokiChunkPaths(chunksPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip the chunks imported before the restart.
+	if imp.cpt.data.Loki == nil {
+		imp.cpt.data.Loki = &lokiCheckpoint{}
+	}
+	imported := make(map[string]struct{}, len(imp.cpt.data.Loki.Chunks))
+	for _, path := range imp.cpt.data.Loki.Chunks {
+		imported[path] = struct{}{}
+	}
+	var pending []string
+	for _, path := range chunkPaths {
+		if _, ok := imported[path]; !ok {
+			pending = append(pending, path)
+		}
+	}
+	logger.Infof("importer: found %d Loki chunks at -importer.loki.chunksPath=%q; %d chunks are already imported", len(chunkPaths), chunksPath, len(chunkPaths)-len(pending))
+
+	pendingCh := make(chan string, len(pending))
+	for _, path := range pending {
+		pendingCh <- path
+	}
+	close(pendingCh)
+
+	for i := 0; i < imp.ic.concurrency; i++ {
+		imp.workers = append(imp.workers, func(ctx context.Context) {
+			for path := range pendingCh {
+				if ctx.Err() != nil {
+					return
+				}
+				imp.importLokiChunk(ctx, chunksPath, path)
+			}
+		})
+	}
+	return imp, nil
+}
+
+// getLokiChunkPaths returns sorted paths for all the files at chunksPath relative to chunksPath.
+func getLokiChunkPaths(chunksPath string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(chunksPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		relPath, err := filepath.Rel(chunksPath, path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, filepath.ToSlash(relPath))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read Loki chunks from %q: %w", chunksPath, err)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// importLokiChunk imports the chunk at the given path relative to chunksPath.
+//
+// The chunk is marked as imported at imp.cpt after all its entries are written to the storage.
+// The chunk, which cannot be imported, isn't marked as imported, so it is imported again after the restart.
+func (imp *importer) importLokiChunk(ctx context.Context, chunksPath, path string) {
+	fullPath := filepath.Join(chunksPath, filepath.FromSlash(path))
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		imp.errorsTotal.Inc()
+		logger.Errorf("importer: cannot read Loki chunk: %s", err)
+		return
+	}
+	c, err := parseLokiChunk(data)
+	if err != nil {
+		imp.errorsTotal.Inc()
+		logger.Errorf("importer: cannot parse Loki chunk %q: %s", fullPath, err)
+		return
+	}
+
+	if !imp.waitForStorage(ctx) {
+		return
+	}
+
+	// Every Loki chunk contains logs for a single stream, so use chunk labels as stream fields if -importer.streamFields isn't set.
+	cp := *imp.ic.cp
+	if len(cp.StreamFields) == 0 {
+		cp.StreamFields = make([]string, 0, len(c.labels))
+		for _, label := range c.labels {
+			cp.StreamFields = append(cp.StreamFields, imp.ic.mapFieldName(label.Name))
+		}
+	}
+	lmp := imp.newLogMessageProcessor(&cp)
+	var fields []logstorage.Field
+	for _, e := range c.entries {
+		fields = append(fields[:0], c.labels...)
+		fields = append(fields, e.structuredMetadata...)
+		fields = append(fields, logstorage.Field{
+			Name:  "_msg",
+			Value: e.line,
+		})
+		imp.ic.applyFieldsMapping(fields)
+		lmp.AddRow(e.timestamp, fields)
+	}
+	lmp.MustClose()
+
+	imp.rowsImportedTotal.Add(len(c.entries))
+	rowsIngestedTotal.Add(len(c.entries))
+	lokiChunksImportedTotal.Inc()
+
+	imp.cpt.update(func(data *checkpointData) {
+		data.Loki.Chunks = append(data.Loki.Chunks, path)
+	})
+}
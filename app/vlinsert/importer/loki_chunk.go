@@ -0,0 +1,441 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/pierrec/lz4/v4"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+// Loki chunk formats.
+//
+// See https://github.com/grafana/loki/blob/main/pkg/chunkenc/memchunk.go
+const (
+	lokiChunkFormatV1 = 1
+	lokiChunkFormatV2 = 2
+	lokiChunkFormatV3 = 3
+	lokiChunkFormatV4 = 4
+)
+
+const lokiChunkMagicNumber = 0x012EE56A
+
+// Loki compression encodings for chunk blocks.
+//
+// See https://github.com/grafana/loki/blob/main/pkg/compression/encoding.go
+const (
+	lokiEncNone     = 0
+	lokiEncGZIP     = 1
+	lokiEncLZ4_64k  = 3
+	lokiEncSnappy   = 4
+	lokiEncLZ4_256k = 5
+	lokiEncLZ4_1M   = 6
+	lokiEncLZ4_4M   = 7
+	lokiEncFlate    = 8
+	lokiEncZstd     = 9
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// lokiChunk is a parsed Loki chunk.
+type lokiChunk struct {
+	// userID is Loki tenant for the chunk.
+	userID string
+
+	// labels contains stream labels for the chunk sorted by name.
+	labels []logstorage.Field
+
+	// entries contains log entries stored in the chunk.
+	entries []lokiEntry
+}
+
+// lokiEntry is a single log entry stored in Loki chunk.
+type lokiEntry struct {
+	timestamp int64
+	line      string
+
+	// structuredMetadata contains optional structured metadata for the entry. It is supported starting from Loki chunk format v4.
+	structuredMetadata []logstorage.Field
+}
+
+// lokiChunkHeader is the JSON-encoded header stored in front of the chunk data at Loki object storage.
+//
+// See https://github.com/grafana/loki/blob/main/pkg/storage/chunk/chunk.go
+type lokiChunkHeader struct {
+	UserID string            `json:"userID"`
+	Metric map[string]string `json:"metric"`
+}
+
+// parseLokiChunk parses Loki chunk stored at Loki object storage from data.
+//
+// The chunk consists of the snappy-compressed JSON header with chunk labels followed by the chunk data.
+func parseLokiChunk(data []byte) (*lokiChunk, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("too short chunk; got %d bytes; want at least 4 bytes", len(data))
+	}
+
+	// The header length includes the 4 bytes of the length itself.
+	headerLen := binary.BigEndian.Uint32(data)
+	if headerLen < 4 || uint64(headerLen)+4 > uint64(len(data)) {
+		return nil, fmt.Errorf("unexpected chunk header length: %d bytes; chunk size: %d bytes", headerLen, len(data))
+	}
+	var h lokiChunkHeader
+	if err := json.NewDecoder(snappy.NewReader(bytes.NewReader(data[4:headerLen]))).Decode(&h); err != nil {
+		return nil, fmt.Errorf("cannot decode chunk header: %w", err)
+	}
+
+	tail := data[headerLen:]
+	dataLen := binary.BigEndian.Uint32(tail)
+	tail = tail[4:]
+	if uint64(dataLen) > uint64(len(tail)) {
+		return nil, fmt.Errorf("unexpected chunk data length: %d bytes; the remaining chunk size: %d bytes", dataLen, len(tail))
+	}
+
+	c := &lokiChunk{
+		userID: h.UserID,
+	}
+	for name, value := range h.Metric {
+		if name == "__name__" {
+			// Loki sets __name__="logs" label for all the chunks.
+			continue
+		}
+		c.labels = append(c.labels, logstorage.Field{
+			Name:  name,
+			Value: value,
+		})
+	}
+	sort.Slice(c.labels, func(i, j int) bool {
+		return c.labels[i].Name < c.labels[j].Name
+	})
+
+	entries, err := parseLokiChunkData(tail[:dataLen])
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse chunk data for stream %s: %w", logstorage.RowFormatter(c.labels), err)
+	}
+	c.entries = entries
+	return c, nil
+}
+
+// lokiBlockMeta is the metadata for a single compressed block of Loki chunk.
+type lokiBlockMeta struct {
+	entriesCount uint64
+	offset       uint64
+	size         uint64
+}
+
+// parseLokiChunkData parses log entries from Loki chunk data.
+func parseLokiChunkData(data []byte) ([]lokiEntry, error) {
+	if len(data) < 5 {
+		return nil, fmt.Errorf("too short chunk data; got %d bytes; want at least 5 bytes", len(data))
+	}
+	if magic := binary.BigEndian.Uint32(data); magic != lokiChunkMagicNumber {
+		return nil, fmt.Errorf("unexpected magic number: 0x%08X; want 0x%08X", magic, lokiChunkMagicNumber)
+	}
+	format := data[4]
+	encoding := byte(lokiEncGZIP)
+	switch format {
+	case lokiChunkFormatV1:
+	case lokiChunkFormatV2, lokiChunkFormatV3, lokiChunkFormatV4:
+		if len(data) < 6 {
+			return nil, fmt.Errorf("missing block encoding")
+		}
+		encoding = data[5]
+	default:
+		return nil, fmt.Errorf("unsupported chunk format: %d; supported formats: v1, v2, v3, v4", format)
+	}
+	decompress, err := getLokiDecompressFunc(encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the offsets and the lengths of chunk sections stored at the end of the chunk.
+	var metasOffset, metasLen uint64
+	var symbols []string
+	if format >= lokiChunkFormatV4 {
+		if len(data) < 32 {
+			return nil, fmt.Errorf("too short chunk data for v4 format; got %d bytes; want at least 32 bytes", len(data))
+		}
+		metasLen, metasOffset = readLokiSectionLenAndOffset(data, 1)
+		symbolsLen, symbolsOffset := readLokiSectionLenAndOffset(data, 2)
+		if symbolsOffset > uint64(len(data)) || symbolsLen > uint64(len(data))-symbolsOffset {
+			return nil, fmt.Errorf("structured metadata section is out of chunk bounds; offset=%d, len=%d, chunk size=%d", symbolsOffset, symbolsLen, len(data))
+		}
+		symbols, err = parseLokiSymbols(data[symbolsOffset:symbolsOffset+symbolsLen], decompress)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse structured metadata section: %w", err)
+		}
+	} else {
+		if len(data) < 12 {
+			return nil, fmt.Errorf("too short chunk data; got %d bytes; want at least 12 bytes", len(data))
+		}
+		metasOffset = binary.BigEndian.Uint64(data[len(data)-8:])
+		if metasOffset > uint64(len(data)-12) {
+			return nil, fmt.Errorf("block metadata offset %d is out of chunk bounds; chunk size=%d", metasOffset, len(data))
+		}
+		// The block metadata is followed by 4 bytes of a checksum and 8 bytes of the block metadata offset.
+		metasLen = uint64(len(data)-12) - metasOffset
+	}
+	if metasOffset > uint64(len(data)) || metasLen+4 > uint64(len(data))-metasOffset {
+		return nil, fmt.Errorf("block metadata is out of chunk bounds; offset=%d, len=%d, chunk size=%d", metasOffset, metasLen, len(data))
+	}
+	metas := data[metasOffset : metasOffset+metasLen]
+	if crc := binary.BigEndian.Uint32(data[metasOffset+metasLen:]); crc != crc32.Checksum(metas, castagnoliTable) {
+		return nil, fmt.Errorf("checksum mismatch for block metadata")
+	}
+	bms, err := parseLokiBlockMetas(metas, format)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []lokiEntry
+	var buf []byte
+	for _, bm := range bms {
+		if bm.offset > uint64(len(data)) || bm.size+4 > uint64(len(data))-bm.offset {
+			return nil, fmt.Errorf("block is out of chunk bounds; offset=%d, size=%d, chunk size=%d", bm.offset, bm.size, len(data))
+		}
+		b := data[bm.offset : bm.offset+bm.size]
+		if crc := binary.BigEndian.Uint32(data[bm.offset+bm.size:]); crc != crc32.Checksum(b, castagnoliTable) {
+			return nil, fmt.Errorf("checksum mismatch for the block at offset %d", bm.offset)
+		}
+		buf, err = decompress(buf[:0], b)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress the block at offset %d: %w", bm.offset, err)
+		}
+		n := len(entries)
+		entries, err = parseLokiBlockEntries(entries, buf, format, symbols)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse entries for the block at offset %d: %w", bm.offset, err)
+		}
+		if uint64(len(entries)-n) != bm.entriesCount {
+			return nil, fmt.Errorf("unexpected number of entries in the block at offset %d; got %d; want %d", bm.offset, len(entries)-n, bm.entriesCount)
+		}
+	}
+	return entries, nil
+}
+
+// readLokiSectionLenAndOffset reads the length and the offset for the section with the given idx stored at the end of v4 chunk data.
+func readLokiSectionLenAndOffset(data []byte, idx int) (uint64, uint64) {
+	b := data[len(data)-idx*16:]
+	return binary.BigEndian.Uint64(b), binary.BigEndian.Uint64(b[8:])
+}
+
+func parseLokiBlockMetas(src []byte, format byte) ([]lokiBlockMeta, error) {
+	d := &lokiDecoder{
+		src: src,
+	}
+	blocksCount := d.uvarint()
+	if blocksCount > uint64(len(src)) {
+		return nil, fmt.Errorf("too many blocks in block metadata: %d", blocksCount)
+	}
+	bms := make([]lokiBlockMeta, blocksCount)
+	for i := range bms {
+		bm := &bms[i]
+		bm.entriesCount = d.uvarint()
+		_ = d.varint() // mint
+		_ = d.varint() // maxt
+		bm.offset = d.uvarint()
+		if format >= lokiChunkFormatV3 {
+			_ = d.uvarint() // uncompressed size
+		}
+		bm.size = d.uvarint()
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("cannot parse block metadata: %w", d.err)
+	}
+	return bms, nil
+}
+
+// parseLokiSymbols parses symbols for structured metadata from v4 chunk section src.
+//
+// The section contains the number of symbols followed by the compressed symbols and 4 bytes of a checksum.
+func parseLokiSymbols(src []byte, decompress lokiDecompressFunc) ([]string, error) {
+	if len(src) == 0 {
+		return nil, nil
+	}
+	if len(src) < 4 {
+		return nil, fmt.Errorf("too short section; got %d bytes; want at least 4 bytes", len(src))
+	}
+	b := src[:len(src)-4]
+	if crc := binary.BigEndian.Uint32(src[len(src)-4:]); crc != crc32.Checksum(b, castagnoliTable) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+	d := &lokiDecoder{
+		src: b,
+	}
+	symbolsCount := d.uvarint()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if symbolsCount == 0 {
+		return nil, nil
+	}
+	data, err := decompress(nil, d.src)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decompress symbols: %w", err)
+	}
+	d.src = data
+	symbols := make([]string, 0, min(symbolsCount, uint64(len(data))))
+	for i := uint64(0); i < symbolsCount; i++ {
+		symbols = append(symbols, d.string())
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("cannot parse symbols: %w", d.err)
+	}
+	return symbols, nil
+}
+
+// parseLokiBlockEntries appends log entries from the decompressed block src to dst and returns the result.
+func parseLokiBlockEntries(dst []lokiEntry, src []byte, format byte, symbols []string) ([]lokiEntry, error) {
+	d := &lokiDecoder{
+		src: src,
+	}
+	for len(d.src) > 0 && d.err == nil {
+		e := lokiEntry{
+			timestamp: d.varint(),
+			line:      d.string(),
+		}
+		if format >= lokiChunkFormatV4 {
+			// The structured metadata is stored as the section length followed by the number of name-value pairs
+			// and the pairs of symbol indexes for names and values.
+			sectionLen := d.uvarint()
+			if sectionLen > uint64(len(d.src)) {
+				return dst, fmt.Errorf("structured metadata length %d exceeds the remaining block size %d", sectionLen, len(d.src))
+			}
+			sd := &lokiDecoder{
+				src: d.src[:sectionLen],
+			}
+			d.src = d.src[sectionLen:]
+			pairsCount := sd.uvarint()
+			for i := uint64(0); i < pairsCount && sd.err == nil; i++ {
+				name := sd.symbol(symbols)
+				value := sd.symbol(symbols)
+				e.structuredMetadata = append(e.structuredMetadata, logstorage.Field{
+					Name:  name,
+					Value: value,
+				})
+			}
+			if sd.err != nil {
+				return dst, fmt.Errorf("cannot parse structured metadata: %w", sd.err)
+			}
+		}
+		dst = append(dst, e)
+	}
+	if d.err != nil {
+		return dst, d.err
+	}
+	return dst, nil
+}
+
+// lokiDecoder decodes values from src.
+//
+// The first decoding error is stored in err. All the subsequent decoding calls return zero values after the error.
+type lokiDecoder struct {
+	src []byte
+	err error
+}
+
+func (d *lokiDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	n, nSize := binary.Uvarint(d.src)
+	if nSize <= 0 {
+		d.err = fmt.Errorf("cannot read uvarint")
+		return 0
+	}
+	d.src = d.src[nSize:]
+	return n
+}
+
+func (d *lokiDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	n, nSize := binary.Varint(d.src)
+	if nSize <= 0 {
+		d.err = fmt.Errorf("cannot read varint")
+		return 0
+	}
+	d.src = d.src[nSize:]
+	return n
+}
+
+func (d *lokiDecoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if n > uint64(len(d.src)) {
+		d.err = fmt.Errorf("string length %d exceeds the remaining data size %d", n, len(d.src))
+		return ""
+	}
+	s := string(d.src[:n])
+	d.src = d.src[n:]
+	return s
+}
+
+func (d *lokiDecoder) symbol(symbols []string) string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if n >= uint64(len(symbols)) {
+		d.err = fmt.Errorf("symbol index %d exceeds the number of symbols %d", n, len(symbols))
+		return ""
+	}
+	return symbols[n]
+}
+
+// lokiDecompressFunc must append the decompressed src to dst and return the result.
+type lokiDecompressFunc func(dst, src []byte) ([]byte, error)
+
+func getLokiDecompressFunc(encoding byte) (lokiDecompressFunc, error) {
+	switch encoding {
+	case lokiEncNone:
+		return func(dst, src []byte) ([]byte, error) {
+			return append(dst, src...), nil
+		}, nil
+	case lokiEncGZIP:
+		return func(dst, src []byte) ([]byte, error) {
+			r, err := gzip.NewReader(bytes.NewReader(src))
+			if err != nil {
+				return dst, err
+			}
+			return readAllAppend(dst, r)
+		}, nil
+	case lokiEncSnappy:
+		return func(dst, src []byte) ([]byte, error) {
+			return readAllAppend(dst, snappy.NewReader(bytes.NewReader(src)))
+		}, nil
+	case lokiEncLZ4_64k, lokiEncLZ4_256k, lokiEncLZ4_1M, lokiEncLZ4_4M:
+		return func(dst, src []byte) ([]byte, error) {
+			return readAllAppend(dst, lz4.NewReader(bytes.NewReader(src)))
+		}, nil
+	case lokiEncFlate:
+		return func(dst, src []byte) ([]byte, error) {
+			return readAllAppend(dst, flate.NewReader(bytes.NewReader(src)))
+		}, nil
+	case lokiEncZstd:
+		return zstd.Decompress, nil
+	default:
+		return nil, fmt.Errorf("unsupported block encoding: %d", encoding)
+	}
+}
+
+func readAllAppend(dst []byte, r io.Reader) ([]byte, error) {
+	bb := bytes.NewBuffer(dst)
+	if _, err := bb.ReadFrom(r); err != nil {
+		return dst, err
+	}
+	return bb.Bytes(), nil
+}
@@ -0,0 +1,320 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/pierrec/lz4/v4"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+func TestParseLokiChunkSuccess(t *testing.T) {
+	f := func(format, encoding byte) {
+		t.Helper()
+
+		metric := map[string]string{
+			"__name__": "logs",
+			"job":      "nginx",
+			"host":     "foo",
+		}
+		blocks := [][]lokiEntry{
+			{
+				{
+					timestamp: 1700000000000000000,
+					line:      "GET /foo 200",
+				},
+				{
+					timestamp: 1700000000000000001,
+					line:      "GET /bar 404",
+				},
+			},
+			{
+				{
+					timestamp: 1700000001000000000,
+					line:      "POST /baz 500",
+				},
+			},
+		}
+		if format >= lokiChunkFormatV4 {
+			blocks[0][1].structuredMetadata = []logstorage.Field{
+				{
+					Name:  "trace_id",
+					Value: "abc",
+				},
+				{
+					Name:  "user",
+					Value: "bob",
+				},
+			}
+			blocks[1][0].structuredMetadata = []logstorage.Field{
+				{
+					Name:  "trace_id",
+					Value: "def",
+				},
+			}
+		}
+		data := marshalTestLokiChunk(t, "tenant1", metric, format, encoding, blocks)
+
+		c, err := parseLokiChunk(data)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		cExpected := &lokiChunk{
+			userID: "tenant1",
+			labels: []logstorage.Field{
+				{
+					Name:  "host",
+					Value: "foo",
+				},
+				{
+					Name:  "job",
+					Value: "nginx",
+				},
+			},
+		}
+		for _, entries := range blocks {
+			cExpected.entries = append(cExpected.entries, entries...)
+		}
+		if !reflect.DeepEqual(c, cExpected) {
+			t.Fatalf("unexpected chunk\ngot\n%+v\nwant\n%+v", c, cExpected)
+		}
+	}
+
+	// v2 format
+	f(lokiChunkFormatV2, lokiEncNone)
+	f(lokiChunkFormatV2, lokiEncGZIP)
+
+	// v3 format
+	f(lokiChunkFormatV3, lokiEncSnappy)
+	f(lokiChunkFormatV3, lokiEncLZ4_256k)
+
+	// v4 format with structured metadata
+	f(lokiChunkFormatV4, lokiEncNone)
+	f(lokiChunkFormatV4, lokiEncGZIP)
+	f(lokiChunkFormatV4, lokiEncSnappy)
+	f(lokiChunkFormatV4, lokiEncLZ4_4M)
+	f(lokiChunkFormatV4, lokiEncFlate)
+	f(lokiChunkFormatV4, lokiEncZstd)
+}
+
+func TestParseLokiChunkFailure(t *testing.T) {
+	f := func(data []byte) {
+		t.Helper()
+
+		if _, err := parseLokiChunk(data); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	blocks := [][]lokiEntry{
+		{
+			{
+				timestamp: 1700000000000000000,
+				line:      "foo bar",
+			},
+		},
+	}
+	metric := map[string]string{
+		"job": "foo",
+	}
+	// newChunk returns a valid chunk with the given format and encoding, which is then corrupted by the corrupt callback.
+	newChunk := func(format, encoding byte, corrupt func(data, chunkData []byte) []byte) []byte {
+		data := marshalTestLokiChunk(t, "", metric, format, encoding, blocks)
+		headerLen := binary.BigEndian.Uint32(data)
+		return corrupt(data, data[headerLen+4:])
+	}
+
+	// empty chunk
+	f(nil)
+	f([]byte("foo"))
+
+	// invalid header
+	f([]byte("\x00\x00\x00\x08foobar"))
+
+	// truncated chunk
+	f(newChunk(lokiChunkFormatV4, lokiEncNone, func(data, _ []byte) []byte {
+		return data[:len(data)-1]
+	}))
+	f(newChunk(lokiChunkFormatV3, lokiEncGZIP, func(data, _ []byte) []byte {
+		headerLen := binary.BigEndian.Uint32(data)
+		return data[:headerLen+10]
+	}))
+
+	// invalid magic number
+	f(newChunk(lokiChunkFormatV4, lokiEncNone, func(data, chunkData []byte) []byte {
+		chunkData[0]++
+		return data
+	}))
+
+	// unsupported format
+	f(newChunk(lokiChunkFormatV4, lokiEncNone, func(data, chunkData []byte) []byte {
+		chunkData[4] = 5
+		return data
+	}))
+
+	// unsupported encoding
+	f(newChunk(lokiChunkFormatV4, lokiEncNone, func(data, chunkData []byte) []byte {
+		chunkData[5] = 100
+		return data
+	}))
+
+	// block checksum mismatch
+	f(newChunk(lokiChunkFormatV4, lokiEncNone, func(data, chunkData []byte) []byte {
+		chunkData[6]++
+		return data
+	}))
+	f(newChunk(lokiChunkFormatV2, lokiEncNone, func(data, chunkData []byte) []byte {
+		chunkData[6]++
+		return data
+	}))
+}
+
+// marshalTestLokiChunk returns Loki chunk with the given blocks in the format stored at Loki object storage.
+func marshalTestLokiChunk(t *testing.T, userID string, metric map[string]string, format, encoding byte, blocks [][]lokiEntry) []byte {
+	t.Helper()
+
+	compress := func(src []byte) []byte {
+		t.Helper()
+
+		var bb bytes.Buffer
+		var w io.WriteCloser
+		switch encoding {
+		case lokiEncNone:
+			return append([]byte{}, src...)
+		case lokiEncGZIP:
+			w = gzip.NewWriter(&bb)
+		case lokiEncSnappy:
+			w = snappy.NewBufferedWriter(&bb)
+		case lokiEncLZ4_64k, lokiEncLZ4_256k, lokiEncLZ4_1M, lokiEncLZ4_4M:
+			w = lz4.NewWriter(&bb)
+		case lokiEncFlate:
+			fw, err := flate.NewWriter(&bb, flate.DefaultCompression)
+			if err != nil {
+				t.Fatalf("cannot create flate writer: %s", err)
+			}
+			w = fw
+		case lokiEncZstd:
+			return zstd.CompressLevel(nil, src, 1)
+		default:
+			t.Fatalf("unsupported encoding: %d", encoding)
+		}
+		if _, err := w.Write(src); err != nil {
+			t.Fatalf("cannot compress data: %s", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("cannot close compressor: %s", err)
+		}
+		return bb.Bytes()
+	}
+	appendCRC := func(dst, src []byte) []byte {
+		return binary.BigEndian.AppendUint32(dst, crc32.Checksum(src, castagnoliTable))
+	}
+
+	var symbols []string
+	symbolIdxs := make(map[string]uint64)
+	getSymbolIdx := func(s string) uint64 {
+		idx, ok := symbolIdxs[s]
+		if !ok {
+			idx = uint64(len(symbols))
+			symbols = append(symbols, s)
+			symbolIdxs[s] = idx
+		}
+		return idx
+	}
+
+	data := binary.BigEndian.AppendUint32(nil, lokiChunkMagicNumber)
+	data = append(data, format, encoding)
+
+	// Write blocks
+	metas := binary.AppendUvarint(nil, uint64(len(blocks)))
+	for _, entries := range blocks {
+		var b []byte
+		for _, e := range entries {
+			b = binary.AppendVarint(b, e.timestamp)
+			b = binary.AppendUvarint(b, uint64(len(e.line)))
+			b = append(b, e.line...)
+			if format >= lokiChunkFormatV4 {
+				sb := binary.AppendUvarint(nil, uint64(len(e.structuredMetadata)))
+				for _, f := range e.structuredMetadata {
+					sb = binary.AppendUvarint(sb, getSymbolIdx(f.Name))
+					sb = binary.AppendUvarint(sb, getSymbolIdx(f.Value))
+				}
+				b = binary.AppendUvarint(b, uint64(len(sb)))
+				b = append(b, sb...)
+			}
+		}
+		cb := compress(b)
+
+		metas = binary.AppendUvarint(metas, uint64(len(entries)))
+		metas = binary.AppendVarint(metas, entries[0].timestamp)
+		metas = binary.AppendVarint(metas, entries[len(entries)-1].timestamp)
+		metas = binary.AppendUvarint(metas, uint64(len(data)))
+		if format >= lokiChunkFormatV3 {
+			metas = binary.AppendUvarint(metas, uint64(len(b)))
+		}
+		metas = binary.AppendUvarint(metas, uint64(len(cb)))
+
+		data = append(data, cb...)
+		data = appendCRC(data, cb)
+	}
+
+	// Write structured metadata symbols
+	symbolsOffset := len(data)
+	if format >= lokiChunkFormatV4 {
+		var sb []byte
+		for _, s := range symbols {
+			sb = binary.AppendUvarint(sb, uint64(len(s)))
+			sb = append(sb, s...)
+		}
+		section := binary.AppendUvarint(nil, uint64(len(symbols)))
+		if len(symbols) > 0 {
+			section = append(section, compress(sb)...)
+		}
+		section = appendCRC(section, section)
+		data = append(data, section...)
+	}
+	symbolsLen := len(data) - symbolsOffset
+
+	// Write block metadata
+	metasOffset := len(data)
+	data = append(data, metas...)
+	data = appendCRC(data, metas)
+
+	// Write section offsets
+	if format >= lokiChunkFormatV4 {
+		data = binary.BigEndian.AppendUint64(data, uint64(symbolsLen))
+		data = binary.BigEndian.AppendUint64(data, uint64(symbolsOffset))
+		data = binary.BigEndian.AppendUint64(data, uint64(len(metas)))
+	}
+	data = binary.BigEndian.AppendUint64(data, uint64(metasOffset))
+
+	// Write the chunk header
+	var hb bytes.Buffer
+	sw := snappy.NewBufferedWriter(&hb)
+	h := &lokiChunkHeader{
+		UserID: userID,
+		Metric: metric,
+	}
+	if err := json.NewEncoder(sw).Encode(h); err != nil {
+		t.Fatalf("cannot encode chunk header: %s", err)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatalf("cannot close snappy writer: %s", err)
+	}
+
+	result := binary.BigEndian.AppendUint32(nil, uint32(4+hb.Len()))
+	result = append(result, hb.Bytes()...)
+	result = binary.BigEndian.AppendUint32(result, uint32(len(data)))
+	result = append(result, data...)
+	return result
+}
@@ -0,0 +1,142 @@
+package importer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlinsert/insertutils"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+func TestLokiImporter(t *testing.T) {
+	dir := t.TempDir()
+	chunksPath := filepath.Join(dir, "chunks")
+
+	writeChunk := func(path string, metric map[string]string, entries []lokiEntry) {
+		t.Helper()
+
+		data := marshalTestLokiChunk(t, "fake", metric, lokiChunkFormatV4, lokiEncSnappy, [][]lokiEntry{entries})
+		path = filepath.Join(chunksPath, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("cannot create directory: %s", err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("cannot write chunk: %s", err)
+		}
+	}
+
+	writeChunk("fake/a1", map[string]string{
+		"job":       "nginx",
+		"log.level": "info",
+	}, []lokiEntry{
+		{
+			timestamp: 1000,
+			line:      "foo",
+		},
+		{
+			timestamp: 2000,
+			line:      "bar",
+			structuredMetadata: []logstorage.Field{
+				{
+					Name:  "trace_id",
+					Value: "abc",
+				},
+			},
+		},
+	})
+	writeChunk("fake/b2", map[string]string{
+		"job": "app",
+	}, []lokiEntry{
+		{
+			timestamp: 3000,
+			line:      "baz",
+		},
+	})
+	writeChunk("other/c3", map[string]string{
+		"job": "db",
+	}, []lokiEntry{
+		{
+			timestamp: 4000,
+			line:      "qwe",
+		},
+	})
+
+	// The invalid chunk mustn't be marked as imported.
+	if err := os.WriteFile(filepath.Join(chunksPath, "invalid"), []byte("foobar"), 0644); err != nil {
+		t.Fatalf("cannot write invalid chunk: %s", err)
+	}
+
+	ic := &importerConfig{
+		source:         sourceLoki,
+		concurrency:    2,
+		checkpointPath: filepath.Join(dir, "importer_checkpoint.json"),
+		fieldsMapping: []fieldMapping{
+			{
+				src: "log.level",
+				dst: "level",
+			},
+		},
+		cp: &insertutils.CommonParams{},
+	}
+	runImport := func() []string {
+		t.Helper()
+
+		var tc testCollector
+		imp := newTestImporter(t, ic, &tc)
+		imp, err := newLokiImporterInternal(imp, chunksPath)
+		if err != nil {
+			t.Fatalf("cannot create importer: %s", err)
+		}
+		imp.run(context.Background())
+		return tc.getRows()
+	}
+
+	rows := runImport()
+	rowsExpected := []string{
+		`1000 [job,level] {"job":"nginx","level":"info","_msg":"foo"}`,
+		`2000 [job,level] {"job":"nginx","level":"info","trace_id":"abc","_msg":"bar"}`,
+		`3000 [job] {"job":"app","_msg":"baz"}`,
+		`4000 [job] {"job":"db","_msg":"qwe"}`,
+	}
+	if !reflect.DeepEqual(rows, rowsExpected) {
+		t.Fatalf("unexpected rows\ngot\n%q\nwant\n%q", rows, rowsExpected)
+	}
+
+	cpt, err := loadCheckpoint(ic.checkpointPath, sourceLoki)
+	if err != nil {
+		t.Fatalf("cannot load checkpoint: %s", err)
+	}
+	chunks := append([]string{}, cpt.data.Loki.Chunks...)
+	sort.Strings(chunks)
+	chunksExpected := []string{"fake/a1", "fake/b2", "other/c3"}
+	if !reflect.DeepEqual(chunks, chunksExpected) {
+		t.Fatalf("unexpected imported chunks\ngot\n%q\nwant\n%q", chunks, chunksExpected)
+	}
+
+	// The already imported chunks mustn't be imported again.
+	rows = runImport()
+	if len(rows) > 0 {
+		t.Fatalf("unexpected rows imported after the restart: %q", rows)
+	}
+
+	// Only new chunks must be imported after the restart.
+	writeChunk("other/d4", map[string]string{
+		"job": "db",
+	}, []lokiEntry{
+		{
+			timestamp: 5000,
+			line:      "new",
+		},
+	})
+	rows = runImport()
+	rowsExpected = []string{
+		`5000 [job] {"job":"db","_msg":"new"}`,
+	}
+	if !reflect.DeepEqual(rows, rowsExpected) {
+		t.Fatalf("unexpected rows after the restart\ngot\n%q\nwant\n%q", rows, rowsExpected)
+	}
+}
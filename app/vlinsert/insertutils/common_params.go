@@ -1,12 +1,15 @@
 package insertutils
 
 import (
+	"fmt"
 	"net/http"
+	"regexp"
 	"sync"
 	"time"
 
 	"github.com/VictoriaMetrics/metrics"
 
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlinsert/transform"
 	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlstorage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httputils"
@@ -21,10 +24,16 @@ import (
 type CommonParams struct {
 	TenantID     logstorage.TenantID
 	TimeField    string
+	TimeFormat   string
 	MsgField     string
 	StreamFields []string
 	IgnoreFields []string
 
+	// MultilineFirstLineRegexp is an optional regexp for the first line of multi-line log entries.
+	//
+	// -insert.multilineFirstLineRegexp is used if it isn't set.
+	MultilineFirstLineRegexp *regexp.Regexp
+
 	Debug           bool
 	DebugRequestURI string
 	DebugRemoteAddr string
@@ -44,6 +53,15 @@ func GetCommonParams(r *http.Request) (*CommonParams, error) {
 		timeField = tf
 	}
 
+	// Extract time format from _time_format query arg
+	var timeFormat = TimeFormatRFC3339
+	if tf := r.FormValue("_time_format"); tf != "" {
+		if err := ValidateTimeFormat(tf); err != nil {
+			return nil, err
+		}
+		timeFormat = tf
+	}
+
 	// Extract message field name from _msg_field query arg
 	var msgField = ""
 	if msgf := r.FormValue("_msg_field"); msgf != "" {
@@ -53,6 +71,16 @@ func GetCommonParams(r *http.Request) (*CommonParams, error) {
 	streamFields := httputils.GetArray(r, "_stream_fields")
 	ignoreFields := httputils.GetArray(r, "ignore_fields")
 
+	// Extract the regexp for the first line of multi-line log entries from _multiline_first_line_regexp query arg
+	var multilineFirstLineRegexp *regexp.Regexp
+	if s := r.FormValue("_multiline_first_line_regexp"); s != "" {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse _multiline_first_line_regexp=%q: %w", s, err)
+		}
+		multilineFirstLineRegexp = re
+	}
+
 	debug := httputils.GetBool(r, "debug")
 	debugRequestURI := ""
 	debugRemoteAddr := ""
@@ -62,11 +90,15 @@ func GetCommonParams(r *http.Request) (*CommonParams, error) {
 	}
 
 	cp := &CommonParams{
-		TenantID:        tenantID,
-		TimeField:       timeField,
-		MsgField:        msgField,
-		StreamFields:    streamFields,
-		IgnoreFields:    ignoreFields,
+		TenantID:     tenantID,
+		TimeField:    timeField,
+		TimeFormat:   timeFormat,
+		MsgField:     msgField,
+		StreamFields: streamFields,
+		IgnoreFields: ignoreFields,
+
+		MultilineFirstLineRegexp: multilineFirstLineRegexp,
+
 		Debug:           debug,
 		DebugRequestURI: debugRequestURI,
 		DebugRemoteAddr: debugRemoteAddr,
@@ -110,6 +142,12 @@ type logMessageProcessor struct {
 
 	cp *CommonParams
 	lr *logstorage.LogRows
+
+	// tctx is used for applying -transform.config rules to the added rows.
+	tctx transform.Ctx
+
+	// mm merges multi-line log entries if multi-line merging is enabled.
+	mm *multilineMerger
 }
 
 func (lmp *logMessageProcessor) initPeriodicFlush() {
@@ -120,7 +158,11 @@ func (lmp *logMessageProcessor) initPeriodicFlush() {
 		defer lmp.wg.Done()
 
 		d := timeutil.AddJitterToDuration(time.Second)
-		ticker := time.NewTicker(d)
+		tickerInterval := d
+		if lmp.mm != nil && lmp.mm.flushTimeout < tickerInterval {
+			tickerInterval = lmp.mm.flushTimeout
+		}
+		ticker := time.NewTicker(tickerInterval)
 		defer ticker.Stop()
 
 		for {
@@ -129,6 +171,9 @@ func (lmp *logMessageProcessor) initPeriodicFlush() {
 				return
 			case <-ticker.C:
 				lmp.mu.Lock()
+				if lmp.mm != nil {
+					lmp.mm.flushStale(time.Now())
+				}
 				if time.Since(lmp.lastFlushTime) >= d {
 					lmp.flushLocked()
 				}
@@ -143,6 +188,21 @@ func (lmp *logMessageProcessor) AddRow(timestamp int64, fields []logstorage.Fiel
 	lmp.mu.Lock()
 	defer lmp.mu.Unlock()
 
+	if lmp.mm != nil {
+		lmp.mm.add(timestamp, fields, time.Now())
+		return
+	}
+	lmp.addRowLocked(timestamp, fields)
+}
+
+// addRowLocked must be called under locked lmp.mu.
+func (lmp *logMessageProcessor) addRowLocked(timestamp int64, fields []logstorage.Field) {
+	fields, ok := lmp.tctx.Apply(timestamp, fields)
+	if !ok {
+		// The row has been dropped by -transform.config rules.
+		return
+	}
+
 	if len(fields) > *MaxFieldsPerLine {
 		rf := logstorage.RowFormatter(fields)
 		logger.Warnf("dropping log line with %d fields; it exceeds -insert.maxFieldsPerLine=%d; %s", len(fields), *MaxFieldsPerLine, rf)
@@ -175,9 +235,13 @@ func (lmp *logMessageProcessor) MustClose() {
 	close(lmp.stopCh)
 	lmp.wg.Wait()
 
+	if lmp.mm != nil {
+		lmp.mm.flushAll()
+	}
 	lmp.flushLocked()
 	logstorage.PutLogRows(lmp.lr)
 	lmp.lr = nil
+	lmp.tctx.Reset()
 }
 
 // NewLogMessageProcessor returns new LogMessageProcessor for the given cp.
@@ -191,6 +255,15 @@ func (cp *CommonParams) NewLogMessageProcessor() LogMessageProcessor {
 
 		stopCh: make(chan struct{}),
 	}
+
+	firstLineRe := cp.MultilineFirstLineRegexp
+	if firstLineRe == nil {
+		firstLineRe = multilineFirstLineRegexpGlobal
+	}
+	if firstLineRe != nil {
+		lmp.mm = newMultilineMerger(firstLineRe, cp.StreamFields, *multilineFlushTimeout, lmp.addRowLocked)
+	}
+
 	lmp.initPeriodicFlush()
 
 	return lmp
@@ -0,0 +1,229 @@
+package insertutils
+
+import (
+	"flag"
+	"regexp"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+var (
+	multilineFirstLineRegexp = flag.String("insert.multilineFirstLineRegexp", "", "Optional regexp for the first line of multi-line log entries such as stack traces. "+
+		"If set, then log entries with _msg field not matching the regexp are appended to the preceding log entry of the same log stream. "+
+		"The regexp can be overridden per request via _multiline_first_line_regexp query arg. "+
+		"See https://docs.victoriametrics.com/victorialogs/data-ingestion/#multiline-logs")
+	multilineFlushTimeout = flag.Duration("insert.multilineFlushTimeout", time.Second, "The maximum duration to wait for continuation lines "+
+		"of multi-line log entries before storing them; see -insert.multilineFirstLineRegexp")
+)
+
+var multilineFirstLineRegexpGlobal *regexp.Regexp
+
+// Init initializes insertutils.
+//
+// It must be called after flag.Parse().
+func Init() {
+	if *multilineFlushTimeout <= 0 {
+		logger.Fatalf("-insert.multilineFlushTimeout must be positive; got %s", *multilineFlushTimeout)
+	}
+	if *multilineFirstLineRegexp == "" {
+		return
+	}
+	re, err := regexp.Compile(*multilineFirstLineRegexp)
+	if err != nil {
+		logger.Fatalf("cannot parse -insert.multilineFirstLineRegexp=%q: %s", *multilineFirstLineRegexp, err)
+	}
+	multilineFirstLineRegexpGlobal = re
+}
+
+// multilineMerger merges continuation lines such as stack trace lines into the preceding log entry of the same log stream.
+//
+// A log entry is a continuation line if its _msg field doesn't match firstLineRe.
+type multilineMerger struct {
+	firstLineRe  *regexp.Regexp
+	streamFields []string
+	flushTimeout time.Duration
+
+	// addRow is called for every merged log entry.
+	addRow func(timestamp int64, fields []logstorage.Field)
+
+	// pending contains log entries waiting for continuation lines in the order of their arrival.
+	pending []*multilineEntry
+
+	// pendingByKey maps stream key to the corresponding entry in pending.
+	pendingByKey map[string]*multilineEntry
+
+	keyBuf []byte
+}
+
+func newMultilineMerger(firstLineRe *regexp.Regexp, streamFields []string, flushTimeout time.Duration, addRow func(timestamp int64, fields []logstorage.Field)) *multilineMerger {
+	return &multilineMerger{
+		firstLineRe:  firstLineRe,
+		streamFields: streamFields,
+		flushTimeout: flushTimeout,
+		addRow:       addRow,
+		pendingByKey: make(map[string]*multilineEntry),
+	}
+}
+
+// multilineEntry is a log entry waiting for continuation lines.
+type multilineEntry struct {
+	key string
+
+	timestamp int64
+	fields    []logstorage.Field
+
+	// buf holds field names and values for fields.
+	buf []byte
+
+	// msg holds the merged _msg field value.
+	msg []byte
+
+	// msgIdx is the index of _msg field at fields or -1 if the _msg field is missing.
+	msgIdx int
+
+	lastUpdate time.Time
+}
+
+func (e *multilineEntry) init(timestamp int64, fields []logstorage.Field, currentTime time.Time) {
+	buf := e.buf[:0]
+	for _, f := range fields {
+		buf = append(buf, f.Name...)
+		buf = append(buf, f.Value...)
+	}
+	e.buf = buf
+
+	// Refer field names and values at buf after it is filled, since buf may be re-allocated during appends above.
+	dst := e.fields[:0]
+	msgIdx := -1
+	n := 0
+	for _, f := range fields {
+		name := bytesutil.ToUnsafeString(buf[n : n+len(f.Name)])
+		n += len(f.Name)
+		value := bytesutil.ToUnsafeString(buf[n : n+len(f.Value)])
+		n += len(f.Value)
+		if f.Name == "_msg" {
+			msgIdx = len(dst)
+		}
+		dst = append(dst, logstorage.Field{
+			Name:  name,
+			Value: value,
+		})
+	}
+	e.fields = dst
+
+	e.msg = e.msg[:0]
+	if msgIdx >= 0 {
+		e.msg = append(e.msg, dst[msgIdx].Value...)
+	}
+	e.msgIdx = msgIdx
+
+	e.timestamp = timestamp
+	e.lastUpdate = currentTime
+}
+
+// add adds the log entry with the given timestamp and fields to mm.
+//
+// Log entries are passed to mm.addRow after all their continuation lines are received.
+func (mm *multilineMerger) add(timestamp int64, fields []logstorage.Field, currentTime time.Time) {
+	msg := getFieldValue(fields, "_msg")
+	mm.keyBuf = mm.marshalStreamKey(mm.keyBuf[:0], fields)
+	e := mm.pendingByKey[string(mm.keyBuf)]
+
+	if e != nil && !mm.firstLineRe.MatchString(msg) && len(e.msg)+1+len(msg) <= MaxLineSizeBytes.IntN() {
+		// Append the continuation line to the pending log entry.
+		if len(e.msg) > 0 || e.msgIdx >= 0 {
+			e.msg = append(e.msg, '\n')
+		}
+		e.msg = append(e.msg, msg...)
+		e.lastUpdate = currentTime
+		multilineMergedLinesTotal.Inc()
+		return
+	}
+
+	if e != nil {
+		mm.flushEntry(e)
+	} else {
+		e = &multilineEntry{
+			key: string(mm.keyBuf),
+		}
+		mm.pending = append(mm.pending, e)
+		mm.pendingByKey[e.key] = e
+	}
+	e.init(timestamp, fields, currentTime)
+}
+
+// flushStale passes log entries without continuation lines during mm.flushTimeout to mm.addRow.
+func (mm *multilineMerger) flushStale(currentTime time.Time) {
+	deadline := currentTime.Add(-mm.flushTimeout)
+	pending := mm.pending[:0]
+	for _, e := range mm.pending {
+		if e.lastUpdate.After(deadline) {
+			pending = append(pending, e)
+			continue
+		}
+		mm.flushEntry(e)
+		delete(mm.pendingByKey, e.key)
+	}
+	clear(mm.pending[len(pending):])
+	mm.pending = pending
+}
+
+// flushAll passes all the pending log entries to mm.addRow.
+func (mm *multilineMerger) flushAll() {
+	for _, e := range mm.pending {
+		mm.flushEntry(e)
+	}
+	clear(mm.pending)
+	mm.pending = mm.pending[:0]
+	clear(mm.pendingByKey)
+}
+
+func (mm *multilineMerger) flushEntry(e *multilineEntry) {
+	fields := e.fields
+	msg := bytesutil.ToUnsafeString(e.msg)
+	if e.msgIdx >= 0 {
+		fields[e.msgIdx].Value = msg
+	} else if msg != "" {
+		fields = append(fields, logstorage.Field{
+			Name:  "_msg",
+			Value: msg,
+		})
+	}
+	mm.addRow(e.timestamp, fields)
+}
+
+func (mm *multilineMerger) marshalStreamKey(dst []byte, fields []logstorage.Field) []byte {
+	if len(mm.streamFields) == 0 {
+		// All the log entries belong to the same stream.
+		return dst
+	}
+
+	st := logstorage.GetStreamTags()
+	for _, f := range fields {
+		for _, name := range mm.streamFields {
+			if f.Name == name {
+				st.Add(f.Name, f.Value)
+				break
+			}
+		}
+	}
+	dst = st.MarshalCanonical(dst)
+	logstorage.PutStreamTags(st)
+	return dst
+}
+
+func getFieldValue(fields []logstorage.Field, name string) string {
+	for _, f := range fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+var multilineMergedLinesTotal = metrics.NewCounter(`vl_multiline_merged_lines_total`)
@@ -0,0 +1,134 @@
+package insertutils
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+func TestMultilineMerger(t *testing.T) {
+	type row struct {
+		timestamp int64
+		fields    []logstorage.Field
+	}
+
+	f := func(streamFields []string, rows []row, rowsExpected int, timestampsExpected []int64, resultExpected string) {
+		t.Helper()
+
+		tlp := &TestLogMessageProcessor{}
+		mm := newMultilineMerger(regexp.MustCompile(`^\S`), streamFields, time.Second, tlp.AddRow)
+		currentTime := time.Unix(0, 0)
+		for _, r := range rows {
+			// Verify that the merger doesn't hold references to the passed fields
+			// by overwriting the underlying buffer after the add() call.
+			var buf []byte
+			for _, f := range r.fields {
+				buf = append(buf, f.Name...)
+				buf = append(buf, f.Value...)
+			}
+			fields := make([]logstorage.Field, len(r.fields))
+			n := 0
+			for i, f := range r.fields {
+				fields[i].Name = bytesutil.ToUnsafeString(buf[n : n+len(f.Name)])
+				n += len(f.Name)
+				fields[i].Value = bytesutil.ToUnsafeString(buf[n : n+len(f.Value)])
+				n += len(f.Value)
+			}
+			mm.add(r.timestamp, fields, currentTime)
+			for i := range buf {
+				buf[i] = 'x'
+			}
+		}
+		mm.flushAll()
+
+		if err := tlp.Verify(rowsExpected, timestampsExpected, resultExpected); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg := func(s string, extra ...string) []logstorage.Field {
+		fields := []logstorage.Field{{Name: "_msg", Value: s}}
+		for i := 0; i+1 < len(extra); i += 2 {
+			fields = append(fields, logstorage.Field{Name: extra[i], Value: extra[i+1]})
+		}
+		return fields
+	}
+
+	// no rows
+	f(nil, nil, 0, nil, ``)
+
+	// single-line entries
+	f(nil, []row{
+		{1, msg("foo")},
+		{2, msg("bar")},
+	}, 2, []int64{1, 2}, `{"_msg":"foo"}
+{"_msg":"bar"}`)
+
+	// stack trace
+	f(nil, []row{
+		{1, msg("Exception in thread main java.lang.NullPointerException", "level", "error")},
+		{2, msg("    at Foo.bar(Foo.java:10)", "level", "other")},
+		{3, msg("    at Foo.main(Foo.java:5)")},
+		{4, msg("next message", "level", "info")},
+	}, 2, []int64{1, 4}, `{"_msg":"Exception in thread main java.lang.NullPointerException\n    at Foo.bar(Foo.java:10)\n    at Foo.main(Foo.java:5)","level":"error"}
+{"_msg":"next message","level":"info"}`)
+
+	// continuation line without the preceding entry
+	f(nil, []row{
+		{1, msg("  orphan")},
+		{2, msg("  continuation")},
+		{3, msg("foo")},
+	}, 2, []int64{1, 3}, `{"_msg":"  orphan\n  continuation"}
+{"_msg":"foo"}`)
+
+	// entry without _msg field
+	f(nil, []row{
+		{1, []logstorage.Field{{Name: "foo", Value: "bar"}}},
+		{2, msg("  continuation")},
+	}, 1, []int64{1}, `{"foo":"bar","_msg":"  continuation"}`)
+
+	// interleaved streams
+	f([]string{"host"}, []row{
+		{1, msg("Traceback (most recent call last):", "host", "a")},
+		{2, msg("error on host b", "host", "b")},
+		{3, msg(`  File "main.py", line 1, in main`, "host", "a")},
+		{4, msg("  at b", "host", "b")},
+		{5, msg("ValueError: foo", "host", "a")},
+	}, 3, []int64{1, 5, 2}, `{"_msg":"Traceback (most recent call last):\n  File \"main.py\", line 1, in main","host":"a"}
+{"_msg":"ValueError: foo","host":"a"}
+{"_msg":"error on host b\n  at b","host":"b"}`)
+}
+
+func TestMultilineMergerFlushStale(t *testing.T) {
+	tlp := &TestLogMessageProcessor{}
+	mm := newMultilineMerger(regexp.MustCompile(`^\S`), []string{"host"}, time.Second, tlp.AddRow)
+
+	currentTime := time.Unix(0, 0)
+	mm.add(1, []logstorage.Field{{Name: "_msg", Value: "foo"}, {Name: "host", Value: "a"}}, currentTime)
+	mm.add(2, []logstorage.Field{{Name: "_msg", Value: "bar"}, {Name: "host", Value: "b"}}, currentTime.Add(500*time.Millisecond))
+	mm.add(3, []logstorage.Field{{Name: "_msg", Value: " baz"}, {Name: "host", Value: "b"}}, currentTime.Add(time.Second))
+
+	// The entry for host=a must be flushed, since it didn't receive continuation lines during the flush timeout.
+	mm.flushStale(currentTime.Add(time.Second))
+	if err := tlp.Verify(1, []int64{1}, `{"_msg":"foo","host":"a"}`); err != nil {
+		t.Fatal(err)
+	}
+	if len(mm.pending) != 1 || len(mm.pendingByKey) != 1 {
+		t.Fatalf("unexpected number of pending entries; got %d, %d; want 1", len(mm.pending), len(mm.pendingByKey))
+	}
+
+	// The continuation line after the flush starts new entry.
+	mm.add(4, []logstorage.Field{{Name: "_msg", Value: " qwe"}, {Name: "host", Value: "a"}}, currentTime.Add(time.Second))
+	mm.flushStale(currentTime.Add(2 * time.Second))
+	if err := tlp.Verify(3, []int64{1, 2, 4}, `{"_msg":"foo","host":"a"}
+{"_msg":"bar\n baz","host":"b"}
+{"_msg":" qwe","host":"a"}`); err != nil {
+		t.Fatal(err)
+	}
+	if len(mm.pending) != 0 || len(mm.pendingByKey) != 0 {
+		t.Fatalf("unexpected number of pending entries; got %d, %d; want 0", len(mm.pending), len(mm.pendingByKey))
+	}
+}
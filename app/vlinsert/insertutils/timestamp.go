@@ -2,8 +2,12 @@ package insertutils
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
 )
 
@@ -31,3 +35,107 @@ func ExtractTimestampRFC3339NanoFromFields(timeField string, fields []logstorage
 	}
 	return time.Now().UnixNano(), nil
 }
+
+// Supported values for _time_format query arg.
+//
+// See https://docs.victoriametrics.com/victorialogs/data-ingestion/#http-parameters
+const (
+	TimeFormatRFC3339 = "rfc3339"
+	TimeFormatUnixS   = "unix_s"
+	TimeFormatUnixMs  = "unix_ms"
+	TimeFormatUnixUs  = "unix_us"
+	TimeFormatUnixNs  = "unix_ns"
+)
+
+// ValidateTimeFormat returns an error if timeFormat isn't supported.
+func ValidateTimeFormat(timeFormat string) error {
+	switch timeFormat {
+	case TimeFormatRFC3339, TimeFormatUnixS, TimeFormatUnixMs, TimeFormatUnixUs, TimeFormatUnixNs:
+		return nil
+	default:
+		return fmt.Errorf("unsupported _time_format=%q; supported values: %s, %s, %s, %s, %s", timeFormat,
+			TimeFormatRFC3339, TimeFormatUnixS, TimeFormatUnixMs, TimeFormatUnixUs, TimeFormatUnixNs)
+	}
+}
+
+// ExtractTimestampFromFields extracts timestamp in nanoseconds from the field with the name timeField at fields according to timeFormat.
+//
+// timeFormat must be validated with ValidateTimeFormat. Unix timestamps may contain fractional part, e.g. 1718753840.123 for unix_s.
+//
+// The value for the timeField is set to empty string after returning from the function,
+// so it could be ignored during data ingestion.
+//
+// The current timestamp is returned if fields do not contain a field with timeField name or if the timeField value is empty or "0".
+func ExtractTimestampFromFields(timeField, timeFormat string, fields []logstorage.Field) (int64, error) {
+	unitNsecs := int64(0)
+	switch timeFormat {
+	case TimeFormatRFC3339:
+		return ExtractTimestampRFC3339NanoFromFields(timeField, fields)
+	case TimeFormatUnixS:
+		unitNsecs = 1e9
+	case TimeFormatUnixMs:
+		unitNsecs = 1e6
+	case TimeFormatUnixUs:
+		unitNsecs = 1e3
+	case TimeFormatUnixNs:
+		unitNsecs = 1
+	default:
+		logger.Panicf("BUG: unexpected timeFormat=%q", timeFormat)
+	}
+
+	for i := range fields {
+		f := &fields[i]
+		if f.Name != timeField {
+			continue
+		}
+		if f.Value == "" || f.Value == "0" {
+			return time.Now().UnixNano(), nil
+		}
+		nsecs, err := parseUnixTimestamp(f.Value, unitNsecs)
+		if err != nil {
+			return 0, fmt.Errorf("cannot unmarshal %s timestamp from %s=%q: %w", timeFormat, timeField, f.Value, err)
+		}
+		f.Value = ""
+		return nsecs, nil
+	}
+	return time.Now().UnixNano(), nil
+}
+
+// parseUnixTimestamp parses s as Unix timestamp with the optional fractional part in units of unitNsecs nanoseconds.
+//
+// The fractional part beyond nanosecond precision is ignored.
+func parseUnixTimestamp(s string, unitNsecs int64) (int64, error) {
+	intPart := s
+	fracPart := ""
+	if n := strings.IndexByte(s, '.'); n >= 0 {
+		intPart = s[:n]
+		fracPart = s[n+1:]
+		if fracPart == "" {
+			return 0, fmt.Errorf("missing fractional part after the dot")
+		}
+	}
+	isNegative := strings.HasPrefix(intPart, "-")
+
+	n, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt64/unitNsecs || n < math.MinInt64/unitNsecs {
+		return 0, fmt.Errorf("the timestamp is out of the supported range")
+	}
+	nsecs := n * unitNsecs
+
+	fracNsecs := int64(0)
+	for i := 0; i < len(fracPart); i++ {
+		c := fracPart[i]
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("unexpected char %q in the fractional part", c)
+		}
+		unitNsecs /= 10
+		fracNsecs += int64(c-'0') * unitNsecs
+	}
+	if isNegative {
+		return nsecs - fracNsecs, nil
+	}
+	return nsecs + fracNsecs, nil
+}
@@ -73,3 +73,80 @@ func TestExtractTimestampRFC3339NanoFromFields_Error(t *testing.T) {
 	f("2024-06-18")
 	f("2024-06-18T23:37")
 }
+
+func TestExtractTimestampFromFields_Success(t *testing.T) {
+	f := func(timeFormat, s string, nsecsExpected int64) {
+		t.Helper()
+
+		fields := []logstorage.Field{
+			{Name: "foo", Value: "bar"},
+			{Name: "time", Value: s},
+		}
+		nsecs, err := ExtractTimestampFromFields("time", timeFormat, fields)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if nsecs != nsecsExpected {
+			t.Fatalf("unexpected nsecs; got %d; want %d", nsecs, nsecsExpected)
+		}
+		if fields[1].Value != "" {
+			t.Fatalf("unexpected value for field time; got %q; want %q", fields[1].Value, "")
+		}
+	}
+
+	f(TimeFormatRFC3339, "2024-06-18T23:37:20Z", 1718753840000000000)
+
+	f(TimeFormatUnixS, "1718753840", 1718753840000000000)
+	f(TimeFormatUnixS, "1718753840.123", 1718753840123000000)
+	f(TimeFormatUnixS, "1718753840.1234567891", 1718753840123456789)
+	f(TimeFormatUnixS, "-1.5", -1500000000)
+
+	f(TimeFormatUnixMs, "1718753840123", 1718753840123000000)
+	f(TimeFormatUnixMs, "1718753840123.456", 1718753840123456000)
+
+	f(TimeFormatUnixUs, "1718753840123456", 1718753840123456000)
+
+	f(TimeFormatUnixNs, "1718753840123456789", 1718753840123456789)
+}
+
+func TestExtractTimestampFromFields_Error(t *testing.T) {
+	f := func(timeFormat, s string) {
+		t.Helper()
+
+		fields := []logstorage.Field{
+			{Name: "time", Value: s},
+		}
+		nsecs, err := ExtractTimestampFromFields("time", timeFormat, fields)
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if nsecs != 0 {
+			t.Fatalf("unexpected nsecs; got %d; want %d", nsecs, 0)
+		}
+	}
+
+	f(TimeFormatRFC3339, "1718753840")
+
+	f(TimeFormatUnixS, "foobar")
+	f(TimeFormatUnixS, "2024-06-18T23:37:20Z")
+	f(TimeFormatUnixS, "1718753840.")
+	f(TimeFormatUnixS, "1718753840.12a")
+	f(TimeFormatUnixS, "1e9")
+
+	// too big timestamp
+	f(TimeFormatUnixS, "9223372037")
+	f(TimeFormatUnixMs, "-9223372036855")
+}
+
+func TestValidateTimeFormat(t *testing.T) {
+	for _, tf := range []string{TimeFormatRFC3339, TimeFormatUnixS, TimeFormatUnixMs, TimeFormatUnixUs, TimeFormatUnixNs} {
+		if err := ValidateTimeFormat(tf); err != nil {
+			t.Fatalf("unexpected error for %q: %s", tf, err)
+		}
+	}
+	for _, tf := range []string{"", "foo", "unix", "RFC3339"} {
+		if err := ValidateTimeFormat(tf); err == nil {
+			t.Fatalf("expecting non-nil error for %q", tf)
+		}
+	}
+}
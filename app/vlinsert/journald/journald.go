@@ -0,0 +1,366 @@
+package journald
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlinsert/insertutils"
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/common"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/writeconcurrencylimiter"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var (
+	journaldTenantID = flag.String("journald.tenantID", "0:0", "TenantID for logs ingested via /insert/journald/upload if the request doesn't contain AccountID and ProjectID headers. "+
+		"See https://docs.victoriametrics.com/victorialogs/data-ingestion/journald/")
+	journaldStreamFields = flagutil.NewArrayString("journald.streamFields", "Journal fields to use as stream fields for logs ingested via /insert/journald/upload. "+
+		"By default _HOSTNAME, _SYSTEMD_UNIT and PRIORITY fields are used. See https://docs.victoriametrics.com/victorialogs/keyconcepts/#stream-fields")
+	journaldIgnoreFields = flagutil.NewArrayString("journald.ignoreFields", "Journal fields to ignore for logs ingested via /insert/journald/upload. "+
+		"See https://docs.victoriametrics.com/victorialogs/data-ingestion/journald/")
+	journaldIncludeEntryMetadata = flag.Bool("journald.includeEntryMetadata", false, "Whether to store journal entry metadata fields with __ prefix such as __CURSOR "+
+		"for logs ingested via /insert/journald/upload. See https://docs.victoriametrics.com/victorialogs/data-ingestion/journald/")
+)
+
+// defaultStreamFields contains stream fields for journald logs if -journald.streamFields isn't set.
+//
+// See https://www.freedesktop.org/software/systemd/man/latest/systemd.journal-fields.html
+var defaultStreamFields = []string{"_HOSTNAME", "_SYSTEMD_UNIT", "PRIORITY"}
+
+const journalContentType = "application/vnd.fdo.journal"
+
+// RequestHandler processes journald insert requests
+func RequestHandler(path string, w http.ResponseWriter, r *http.Request) bool {
+	switch path {
+	case "/upload":
+		handleUpload(w, r)
+		return true
+	default:
+		return false
+	}
+}
+
+// handleUpload processes requests from systemd-journal-upload.
+//
+// See https://www.freedesktop.org/software/systemd/man/latest/systemd-journal-upload.service.html
+func handleUpload(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestsTotal.Inc()
+
+	if ct := r.Header.Get("Content-Type"); ct != journalContentType {
+		errorsTotal.Inc()
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		fmt.Fprintf(w, "Content-Type: %s is required; got %q", journalContentType, ct)
+		return
+	}
+
+	cp, err := getCommonParams(r)
+	if err != nil {
+		errorsTotal.Inc()
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	if err := vlstorage.CanWriteData(cp.TenantID); err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	reader := r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := common.GetGzipReader(reader)
+		if err != nil {
+			errorsTotal.Inc()
+			httpserver.Errorf(w, r, "cannot read gzipped journald request: %s", err)
+			return
+		}
+		defer common.PutGzipReader(zr)
+		reader = zr
+	}
+
+	lmp := cp.NewLogMessageProcessor()
+	err = processStreamInternal(reader, *journaldIncludeEntryMetadata, lmp)
+	lmp.MustClose()
+	if err != nil {
+		errorsTotal.Inc()
+		httpserver.Errorf(w, r, "journald: %s", err)
+		return
+	}
+
+	// systemd-journal-remote responds with 202 Accepted after successful upload.
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "OK.\n")
+
+	// update requestDuration only for successfully parsed requests.
+	// There is no need in updating requestDuration for request errors,
+	// since their timings are usually much smaller than the timing for successful request parsing.
+	requestDuration.UpdateDuration(startTime)
+}
+
+func getCommonParams(r *http.Request) (*insertutils.CommonParams, error) {
+	cp, err := insertutils.GetCommonParams(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// systemd-journal-upload cannot send custom headers, so fall back to -journald.tenantID
+	// if the tenant isn't set via AccountID and ProjectID headers.
+	if cp.TenantID.AccountID == 0 && cp.TenantID.ProjectID == 0 {
+		tenantID, err := logstorage.ParseTenantID(*journaldTenantID)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse -journald.tenantID=%q: %w", *journaldTenantID, err)
+		}
+		cp.TenantID = tenantID
+	}
+
+	if len(cp.StreamFields) == 0 {
+		cp.StreamFields = *journaldStreamFields
+		if len(cp.StreamFields) == 0 {
+			cp.StreamFields = defaultStreamFields
+		}
+	}
+	if len(cp.IgnoreFields) == 0 {
+		cp.IgnoreFields = *journaldIgnoreFields
+	}
+	return cp, nil
+}
+
+// processStreamInternal reads journal entries in export format from r and sends them to lmp.
+//
+// See https://systemd.io/JOURNAL_EXPORT_FORMATS/#journal-export-format
+func processStreamInternal(r io.Reader, includeEntryMetadata bool, lmp insertutils.LogMessageProcessor) error {
+	wcr := writeconcurrencylimiter.GetReader(r)
+	defer writeconcurrencylimiter.PutReader(wcr)
+
+	br := getBufioReader(wcr)
+	defer putBufioReader(br)
+
+	e := getEntry()
+	defer putEntry(e)
+
+	for {
+		ok, err := e.read(br)
+		if err != nil {
+			return fmt.Errorf("cannot read journal entry #%d: %w", e.entriesRead+1, err)
+		}
+		if len(e.fields) > 0 {
+			if err := e.addRow(includeEntryMetadata, lmp); err != nil {
+				return fmt.Errorf("cannot process journal entry #%d: %w", e.entriesRead+1, err)
+			}
+			e.entriesRead++
+			rowsIngestedTotal.Inc()
+		}
+		wcr.DecConcurrency()
+		if !ok {
+			return nil
+		}
+	}
+}
+
+// entry holds a single journal entry.
+type entry struct {
+	// buf holds names and values for fields.
+	buf []byte
+
+	// fields contains offsets for field names and values at buf.
+	fields []fieldOffsets
+
+	// rowFields is used for passing entry fields to LogMessageProcessor.
+	rowFields []logstorage.Field
+
+	// entriesRead is the number of successfully processed entries.
+	entriesRead int
+}
+
+type fieldOffsets struct {
+	nameStart  int
+	nameEnd    int
+	valueStart int
+	valueEnd   int
+}
+
+func (e *entry) reset() {
+	e.buf = e.buf[:0]
+	e.fields = e.fields[:0]
+
+	clear(e.rowFields)
+	e.rowFields = e.rowFields[:0]
+
+	e.entriesRead = 0
+}
+
+// read reads the next entry from br into e.
+//
+// It returns false if br has no more entries.
+func (e *entry) read(br *bufio.Reader) (bool, error) {
+	e.buf = e.buf[:0]
+	e.fields = e.fields[:0]
+
+	for {
+		line, err := br.ReadSlice('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				if len(line) > 0 {
+					return false, fmt.Errorf("unexpected end of stream after %q", line)
+				}
+				return false, nil
+			}
+			if errors.Is(err, bufio.ErrBufferFull) {
+				return false, fmt.Errorf("too long journal field; it exceeds -insert.maxLineSizeBytes=%d", insertutils.MaxLineSizeBytes.IntN())
+			}
+			return false, err
+		}
+		line = line[:len(line)-1]
+		if len(line) == 0 {
+			// An empty line terminates the entry.
+			return true, nil
+		}
+
+		nameStart := len(e.buf)
+		if n := strings.IndexByte(bytesutil.ToUnsafeString(line), '='); n >= 0 {
+			// Text field in the form NAME=value
+			e.buf = append(e.buf, line...)
+			e.fields = append(e.fields, fieldOffsets{
+				nameStart:  nameStart,
+				nameEnd:    nameStart + n,
+				valueStart: nameStart + n + 1,
+				valueEnd:   len(e.buf),
+			})
+			continue
+		}
+
+		// Binary field in the form NAME\n<little-endian uint64 size><value>\n
+		e.buf = append(e.buf, line...)
+		valueStart := len(e.buf)
+		if err := e.readBinaryValue(br); err != nil {
+			return false, fmt.Errorf("cannot read binary value for field %q: %w", e.buf[nameStart:valueStart], err)
+		}
+		e.fields = append(e.fields, fieldOffsets{
+			nameStart:  nameStart,
+			nameEnd:    valueStart,
+			valueStart: valueStart,
+			valueEnd:   len(e.buf),
+		})
+	}
+}
+
+func (e *entry) readBinaryValue(br *bufio.Reader) error {
+	var sizeBuf [8]byte
+	if _, err := io.ReadFull(br, sizeBuf[:]); err != nil {
+		return fmt.Errorf("cannot read value size: %w", err)
+	}
+	size := binary.LittleEndian.Uint64(sizeBuf[:])
+	maxSize := uint64(insertutils.MaxLineSizeBytes.IntN())
+	if size > maxSize {
+		return fmt.Errorf("too big value size: %d bytes; it exceeds -insert.maxLineSizeBytes=%d", size, maxSize)
+	}
+
+	bufLen := len(e.buf)
+	e.buf = bytesutil.ResizeWithCopyMayOverallocate(e.buf, bufLen+int(size))
+	if _, err := io.ReadFull(br, e.buf[bufLen:]); err != nil {
+		return fmt.Errorf("cannot read value with size %d bytes: %w", size, err)
+	}
+
+	c, err := br.ReadByte()
+	if err != nil {
+		return fmt.Errorf("cannot read value terminator: %w", err)
+	}
+	if c != '\n' {
+		return fmt.Errorf("unexpected value terminator; got %q; want %q", c, '\n')
+	}
+	return nil
+}
+
+// addRow sends e to lmp.
+func (e *entry) addRow(includeEntryMetadata bool, lmp insertutils.LogMessageProcessor) error {
+	ts := int64(0)
+	fields := e.rowFields[:0]
+	for _, fo := range e.fields {
+		name := bytesutil.ToUnsafeString(e.buf[fo.nameStart:fo.nameEnd])
+		value := bytesutil.ToUnsafeString(e.buf[fo.valueStart:fo.valueEnd])
+		switch name {
+		case "__REALTIME_TIMESTAMP":
+			usecs, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("cannot parse __REALTIME_TIMESTAMP=%q: %w", value, err)
+			}
+			ts = usecs * 1e3
+			continue
+		case "MESSAGE":
+			name = "_msg"
+		}
+		if !includeEntryMetadata && strings.HasPrefix(name, "__") {
+			continue
+		}
+		fields = append(fields, logstorage.Field{
+			Name:  name,
+			Value: value,
+		})
+	}
+	e.rowFields = fields
+
+	if ts == 0 {
+		ts = time.Now().UnixNano()
+	}
+	lmp.AddRow(ts, fields)
+	return nil
+}
+
+func getEntry() *entry {
+	v := entryPool.Get()
+	if v == nil {
+		return &entry{}
+	}
+	return v.(*entry)
+}
+
+func putEntry(e *entry) {
+	e.reset()
+	entryPool.Put(e)
+}
+
+var entryPool sync.Pool
+
+func getBufioReader(r io.Reader) *bufio.Reader {
+	v := bufioReaderPool.Get()
+	if v == nil {
+		// Text fields are read line by line, so the buffer size limits the maximum text field size.
+		return bufio.NewReaderSize(r, insertutils.MaxLineSizeBytes.IntN())
+	}
+	br := v.(*bufio.Reader)
+	br.Reset(r)
+	return br
+}
+
+func putBufioReader(br *bufio.Reader) {
+	br.Reset(nil)
+	bufioReaderPool.Put(br)
+}
+
+var bufioReaderPool sync.Pool
+
+var (
+	rowsIngestedTotal = metrics.NewCounter(`vl_rows_ingested_total{type="journald"}`)
+
+	requestsTotal = metrics.NewCounter(`vl_http_requests_total{path="/insert/journald/upload"}`)
+	errorsTotal   = metrics.NewCounter(`vl_http_errors_total{path="/insert/journald/upload"}`)
+
+	requestDuration = metrics.NewHistogram(`vl_http_request_duration_seconds{path="/insert/journald/upload"}`)
+)
@@ -0,0 +1,97 @@
+package journald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlinsert/insertutils"
+)
+
+// binaryField returns journal field in binary export format.
+func binaryField(name, value string) string {
+	var b []byte
+	b = append(b, name...)
+	b = append(b, '\n')
+	b = binary.LittleEndian.AppendUint64(b, uint64(len(value)))
+	b = append(b, value...)
+	b = append(b, '\n')
+	return string(b)
+}
+
+func TestProcessStreamInternalSuccess(t *testing.T) {
+	f := func(data string, includeEntryMetadata bool, timestampsExpected []int64, resultExpected string) {
+		t.Helper()
+
+		tlp := &insertutils.TestLogMessageProcessor{}
+		if err := processStreamInternal(strings.NewReader(data), includeEntryMetadata, tlp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := tlp.Verify(len(timestampsExpected), timestampsExpected, resultExpected); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// empty stream
+	f("", false, nil, "")
+	f("\n\n", false, nil, "")
+
+	data := `__CURSOR=s=739ad463348b4ceca5a9e69c95a3c93f;i=4ece7
+__REALTIME_TIMESTAMP=1717150800123456
+__MONOTONIC_TIMESTAMP=1234567
+_BOOT_ID=6b1ff9e7b7f84c2a9a69c0e0a8f2c9a4
+_HOSTNAME=host1
+_SYSTEMD_UNIT=nginx.service
+PRIORITY=6
+MESSAGE=foo bar
+
+__REALTIME_TIMESTAMP=1717150801000000
+_HOSTNAME=host1
+` + binaryField("MESSAGE", "multi\nline=message") + `PRIORITY=3
+`
+
+	// entry metadata is dropped by default, while the last entry may miss the trailing empty line
+	f(data, false, []int64{1717150800123456000, 1717150801000000000},
+		`{"_BOOT_ID":"6b1ff9e7b7f84c2a9a69c0e0a8f2c9a4","_HOSTNAME":"host1","_SYSTEMD_UNIT":"nginx.service","PRIORITY":"6","_msg":"foo bar"}
+{"_HOSTNAME":"host1","_msg":"multi\nline=message","PRIORITY":"3"}`)
+
+	// entry metadata is stored if includeEntryMetadata is set
+	f(data+"\n", true, []int64{1717150800123456000, 1717150801000000000},
+		`{"__CURSOR":"s=739ad463348b4ceca5a9e69c95a3c93f;i=4ece7","__MONOTONIC_TIMESTAMP":"1234567","_BOOT_ID":"6b1ff9e7b7f84c2a9a69c0e0a8f2c9a4","_HOSTNAME":"host1","_SYSTEMD_UNIT":"nginx.service","PRIORITY":"6","_msg":"foo bar"}
+{"_HOSTNAME":"host1","_msg":"multi\nline=message","PRIORITY":"3"}`)
+
+	// empty field value
+	f("__REALTIME_TIMESTAMP=1717150800000000\nMESSAGE=\nFOO=bar\n\n", false, []int64{1717150800000000000}, `{"_msg":"","FOO":"bar"}`)
+}
+
+func TestProcessStreamInternalFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		tlp := &insertutils.TestLogMessageProcessor{}
+		if err := processStreamInternal(strings.NewReader(data), false, tlp); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// missing newline at the end of the stream
+	f("MESSAGE=foo")
+
+	// invalid timestamp
+	f("__REALTIME_TIMESTAMP=foo\nMESSAGE=foo\n\n")
+
+	// truncated binary field
+	f("MESSAGE\n")
+	f("MESSAGE\n\x10\x00\x00\x00\x00\x00\x00\x00foo")
+	f(strings.TrimSuffix(binaryField("MESSAGE", "foo"), "\n"))
+
+	// missing binary field terminator
+	f(strings.TrimSuffix(binaryField("MESSAGE", "foo"), "\n") + "x\n")
+
+	// too big binary field
+	f("MESSAGE\n\xff\xff\xff\xff\xff\xff\xff\xff")
+
+	// too long text field
+	f("MESSAGE=" + string(bytes.Repeat([]byte("x"), insertutils.MaxLineSizeBytes.IntN())) + "\n")
+}
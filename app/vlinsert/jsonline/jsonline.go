@@ -6,13 +6,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlinsert/insertutils"
 	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlstorage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
-	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/common"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/writeconcurrencylimiter"
@@ -36,7 +36,7 @@ func RequestHandler(w http.ResponseWriter, r *http.Request) {
 		httpserver.Errorf(w, r, "%s", err)
 		return
 	}
-	if err := vlstorage.CanWriteData(); err != nil {
+	if err := vlstorage.CanWriteData(cp.TenantID); err != nil {
 		httpserver.Errorf(w, r, "%s", err)
 		return
 	}
@@ -45,7 +45,8 @@ func RequestHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Header.Get("Content-Encoding") == "gzip" {
 		zr, err := common.GetGzipReader(reader)
 		if err != nil {
-			logger.Errorf("cannot read gzipped jsonline request: %s", err)
+			errorsTotal.Inc()
+			httpserver.Errorf(w, r, "cannot read gzipped jsonline request: %s", err)
 			return
 		}
 		defer common.PutGzipReader(zr)
@@ -53,11 +54,12 @@ func RequestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	lmp := cp.NewLogMessageProcessor()
-	err = processStreamInternal(reader, cp.TimeField, cp.MsgField, lmp)
+	err = processStreamInternal(reader, cp.TimeField, cp.TimeFormat, cp.MsgField, lmp)
 	lmp.MustClose()
 
 	if err != nil {
-		logger.Errorf("jsonline: %s", err)
+		errorsTotal.Inc()
+		httpserver.Errorf(w, r, "jsonline: %s", err)
 	} else {
 		// update requestDuration only for successfully parsed requests.
 		// There is no need in updating requestDuration for request errors,
@@ -66,7 +68,14 @@ func RequestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func processStreamInternal(r io.Reader, timeField, msgField string, lmp insertutils.LogMessageProcessor) error {
+// maxReportedLineErrors is the maximum number of per-line errors returned to the client.
+const maxReportedLineErrors = 10
+
+// processStreamInternal reads newline-delimited JSON log entries from r and sends them to lmp.
+//
+// Lines with invalid JSON or with invalid timestamp are skipped, while the remaining lines are ingested.
+// The returned error contains up to maxReportedLineErrors errors for the skipped lines.
+func processStreamInternal(r io.Reader, timeField, timeFormat, msgField string, lmp insertutils.LogMessageProcessor) error {
 	wcr := writeconcurrencylimiter.GetReader(r)
 	defer writeconcurrencylimiter.PutReader(wcr)
 
@@ -77,23 +86,52 @@ func processStreamInternal(r io.Reader, timeField, msgField string, lmp insertut
 	sc := bufio.NewScanner(wcr)
 	sc.Buffer(lb.B, len(lb.B))
 
-	n := 0
+	lineNum := 0
+	rowsIngested := 0
+	invalidLines := 0
+	var lineErrors []string
 	for {
-		ok, err := readLine(sc, timeField, msgField, lmp)
+		ok, err := readLine(sc, timeField, timeFormat, msgField, lmp, &lineNum)
 		wcr.DecConcurrency()
 		if err != nil {
-			errorsTotal.Inc()
-			return fmt.Errorf("cannot read line #%d in /jsonline request: %s", n, err)
+			var le *lineError
+			if !errors.As(err, &le) {
+				return fmt.Errorf("cannot read line #%d in /jsonline request: %w", lineNum, err)
+			}
+			invalidLines++
+			rowsInvalidTotal.Inc()
+			if len(lineErrors) < maxReportedLineErrors {
+				lineErrors = append(lineErrors, fmt.Sprintf("line #%d: %s", lineNum, le.err))
+			}
+			continue
 		}
 		if !ok {
-			return nil
+			break
 		}
-		n++
+		rowsIngested++
 		rowsIngestedTotal.Inc()
 	}
+
+	if invalidLines > 0 {
+		return fmt.Errorf("cannot parse %d lines in /jsonline request, while %d lines have been ingested; errors: %s",
+			invalidLines, rowsIngested, strings.Join(lineErrors, "; "))
+	}
+	return nil
 }
 
-func readLine(sc *bufio.Scanner, timeField, msgField string, lmp insertutils.LogMessageProcessor) (bool, error) {
+// lineError is an error for a single line, which doesn't prevent from processing the remaining lines.
+type lineError struct {
+	err error
+}
+
+func (le *lineError) Error() string {
+	return le.err.Error()
+}
+
+// readLine reads the next non-empty line from sc and sends it to lmp.
+//
+// It returns false if sc has no more lines. *lineNum is incremented per each read line, including empty lines.
+func readLine(sc *bufio.Scanner, timeField, timeFormat, msgField string, lmp insertutils.LogMessageProcessor, lineNum *int) (bool, error) {
 	var line []byte
 	for len(line) == 0 {
 		if !sc.Scan() {
@@ -105,20 +143,25 @@ func readLine(sc *bufio.Scanner, timeField, msgField string, lmp insertutils.Log
 			}
 			return false, nil
 		}
+		*lineNum++
 		line = sc.Bytes()
 	}
 
 	p := logstorage.GetJSONParser()
+	defer logstorage.PutJSONParser(p)
 	if err := p.ParseLogMessage(line); err != nil {
-		return false, fmt.Errorf("cannot parse json-encoded log entry: %w", err)
+		return false, &lineError{
+			err: fmt.Errorf("cannot parse json-encoded log entry: %w", err),
+		}
 	}
-	ts, err := insertutils.ExtractTimestampRFC3339NanoFromFields(timeField, p.Fields)
+	ts, err := insertutils.ExtractTimestampFromFields(timeField, timeFormat, p.Fields)
 	if err != nil {
-		return false, fmt.Errorf("cannot get timestamp: %w", err)
+		return false, &lineError{
+			err: fmt.Errorf("cannot get timestamp: %w", err),
+		}
 	}
 	logstorage.RenameField(p.Fields, msgField, "_msg")
 	lmp.AddRow(ts, p.Fields)
-	logstorage.PutJSONParser(p)
 
 	return true, nil
 }
@@ -127,6 +170,7 @@ var lineBufferPool bytesutil.ByteBufferPool
 
 var (
 	rowsIngestedTotal = metrics.NewCounter(`vl_rows_ingested_total{type="jsonline"}`)
+	rowsInvalidTotal  = metrics.NewCounter(`vl_rows_dropped_total{reason="jsonline_invalid_line"}`)
 
 	requestsTotal = metrics.NewCounter(`vl_http_requests_total{path="/insert/jsonline"}`)
 	errorsTotal   = metrics.NewCounter(`vl_http_errors_total{path="/insert/jsonline"}`)
@@ -13,7 +13,7 @@ func TestProcessStreamInternal_Success(t *testing.T) {
 
 		tlp := &insertutils.TestLogMessageProcessor{}
 		r := bytes.NewBufferString(data)
-		if err := processStreamInternal(r, timeField, msgField, tlp); err != nil {
+		if err := processStreamInternal(r, timeField, insertutils.TimeFormatRFC3339, msgField, tlp); err != nil {
 			t.Fatalf("unexpected error: %s", err)
 		}
 
@@ -36,20 +36,61 @@ func TestProcessStreamInternal_Success(t *testing.T) {
 	f(data, timeField, msgField, rowsExpected, timestampsExpected, resultExpected)
 }
 
+func TestProcessStreamInternal_TimeFormat(t *testing.T) {
+	f := func(timeFormat, timestamp string, timestampExpected int64) {
+		t.Helper()
+
+		tlp := &insertutils.TestLogMessageProcessor{}
+		r := bytes.NewBufferString(`{"ts":` + timestamp + `,"_msg":"foo"}`)
+		if err := processStreamInternal(r, "ts", timeFormat, "", tlp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := tlp.Verify(1, []int64{timestampExpected}, `{"ts":"","_msg":"foo"}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f(insertutils.TimeFormatRFC3339, `"2023-06-06T04:48:11.735Z"`, 1686026891735000000)
+	f(insertutils.TimeFormatUnixS, `1686026891.735`, 1686026891735000000)
+	f(insertutils.TimeFormatUnixMs, `1686026891735`, 1686026891735000000)
+	f(insertutils.TimeFormatUnixUs, `"1686026891735000"`, 1686026891735000000)
+	f(insertutils.TimeFormatUnixNs, `1686026891735000123`, 1686026891735000123)
+}
+
 func TestProcessStreamInternal_Failure(t *testing.T) {
-	f := func(data string) {
+	f := func(data string, rowsExpected int, timestampsExpected []int64, resultExpected, errExpected string) {
 		t.Helper()
 
 		tlp := &insertutils.TestLogMessageProcessor{}
 		r := bytes.NewBufferString(data)
-		if err := processStreamInternal(r, "time", "", tlp); err == nil {
+		err := processStreamInternal(r, "time", insertutils.TimeFormatRFC3339, "", tlp)
+		if err == nil {
 			t.Fatalf("expecting non-nil error")
 		}
+		if errStr := err.Error(); errStr != errExpected {
+			t.Fatalf("unexpected error\ngot\n%s\nwant\n%s", errStr, errExpected)
+		}
+		if err := tlp.Verify(rowsExpected, timestampsExpected, resultExpected); err != nil {
+			t.Fatal(err)
+		}
 	}
 
 	// invalid json
-	f("foobar")
+	f("foobar", 0, nil, "", `cannot parse 1 lines in /jsonline request, while 0 lines have been ingested; errors: `+
+		`line #1: cannot parse json-encoded log entry: cannot parse json: cannot parse JSON: unexpected value found: "foobar"; unparsed tail: "foobar"`)
 
 	// invalid timestamp field
-	f(`{"time":"foobar"}`)
+	f(`{"time":"foobar"}`, 0, nil, "", `cannot parse 1 lines in /jsonline request, while 0 lines have been ingested; errors: `+
+		`line #1: cannot get timestamp: cannot unmarshal rfc3339 timestamp from time="foobar"`)
+
+	// invalid lines don't prevent from ingesting valid lines
+	f(`{"time":"2023-06-06T04:48:11.735Z","_msg":"foo"}
+
+{"time":"bar","_msg":"bar"}
+{"time":"2023-06-06T04:48:12.735Z","_msg":"baz"}
+{"_msg":
+`, 2, []int64{1686026891735000000, 1686026892735000000}, `{"time":"","_msg":"foo"}
+{"time":"","_msg":"baz"}`, `cannot parse 2 lines in /jsonline request, while 2 lines have been ingested; errors: `+
+		`line #3: cannot get timestamp: cannot unmarshal rfc3339 timestamp from time="bar"; `+
+		`line #5: cannot parse json-encoded log entry: cannot parse json: cannot parse JSON: cannot parse object: cannot parse object value: cannot parse empty string; unparsed tail: ""`)
 }
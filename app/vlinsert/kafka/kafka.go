@@ -0,0 +1,395 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlinsert/insertutils"
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timerpool"
+)
+
+var (
+	consumerTopic = flagutil.NewArrayString("kafka.consumer.topic", "Kafka topic to read logs from. Every Kafka message must contain a JSON object with log fields. "+
+		"See https://docs.victoriametrics.com/victorialogs/data-ingestion/kafka/")
+	consumerBrokers = flagutil.NewArrayString("kafka.consumer.topic.brokers", "List of Kafka brokers to connect to for the corresponding -kafka.consumer.topic. "+
+		"Multiple brokers must be delimited by ';'. For example, -kafka.consumer.topic.brokers='host1:9092;host2:9092'")
+	consumerGroupID = flagutil.NewArrayString("kafka.consumer.topic.groupID", "Kafka consumer group for the corresponding -kafka.consumer.topic. "+
+		"Consumed offsets are committed to this group after the logs are written to the storage. By default victorialogs group is used")
+	consumerConcurrency = flagutil.NewArrayInt("kafka.consumer.topic.concurrency", 1, "The number of concurrent consumers for the corresponding -kafka.consumer.topic. "+
+		"Every consumer joins the consumer group, so it makes no sense in setting this value to bigger than the number of topic partitions")
+	consumerTenantID = flagutil.NewArrayString("kafka.consumer.topic.tenantID", "TenantID for logs read from the corresponding -kafka.consumer.topic. "+
+		"See https://docs.victoriametrics.com/victorialogs/#multitenancy")
+	consumerTimeField = flagutil.NewArrayString("kafka.consumer.topic.timeField", "Log field with the timestamp for logs read from the corresponding -kafka.consumer.topic. "+
+		"By default _time field is used. Kafka message timestamp is used if the log entry doesn't contain this field")
+	consumerTimeFormat = flagutil.NewArrayString("kafka.consumer.topic.timeFormat", "The format of the -kafka.consumer.topic.timeField for logs read from the corresponding -kafka.consumer.topic. "+
+		"Supported values: rfc3339, unix_s, unix_ms, unix_us, unix_ns. By default rfc3339 is used")
+	consumerMsgField = flagutil.NewArrayString("kafka.consumer.topic.msgField", "Log field with the log message for logs read from the corresponding -kafka.consumer.topic. "+
+		"By default _msg field is used")
+	consumerStreamFields = flagutil.NewArrayString("kafka.consumer.topic.streamFields", "List of stream fields for logs read from the corresponding -kafka.consumer.topic. "+
+		"Multiple fields must be delimited by ';'. See https://docs.victoriametrics.com/victorialogs/keyconcepts/#stream-fields")
+	consumerIgnoreFields = flagutil.NewArrayString("kafka.consumer.topic.ignoreFields", "List of log fields to ignore for logs read from the corresponding -kafka.consumer.topic. "+
+		"Multiple fields must be delimited by ';'")
+	consumerDeadLetterTopic = flagutil.NewArrayString("kafka.consumer.topic.deadLetterTopic", "Optional Kafka topic to send malformed messages from the corresponding -kafka.consumer.topic to. "+
+		"Malformed messages are dropped if this flag isn't set. See https://docs.victoriametrics.com/victorialogs/data-ingestion/kafka/#dead-letter-topic")
+)
+
+const defaultGroupID = "victorialogs"
+
+// topicConfig is the configuration for reading logs from a single Kafka topic.
+type topicConfig struct {
+	topic           string
+	brokers         []string
+	groupID         string
+	concurrency     int
+	deadLetterTopic string
+
+	cp *insertutils.CommonParams
+}
+
+// getTopicConfigs returns configs for reading from -kafka.consumer.topic.
+func getTopicConfigs() ([]*topicConfig, error) {
+	var tcs []*topicConfig
+	for argIdx, topic := range *consumerTopic {
+		if topic == "" {
+			return nil, fmt.Errorf("-kafka.consumer.topic cannot be empty")
+		}
+
+		brokers := splitList(consumerBrokers.GetOptionalArg(argIdx))
+		if len(brokers) == 0 {
+			return nil, fmt.Errorf("missing -kafka.consumer.topic.brokers for -kafka.consumer.topic=%q", topic)
+		}
+
+		groupID := consumerGroupID.GetOptionalArg(argIdx)
+		if groupID == "" {
+			groupID = defaultGroupID
+		}
+
+		concurrency := consumerConcurrency.GetOptionalArg(argIdx)
+		if concurrency <= 0 {
+			return nil, fmt.Errorf("-kafka.consumer.topic.concurrency for -kafka.consumer.topic=%q must be positive; got %d", topic, concurrency)
+		}
+
+		tenantIDStr := consumerTenantID.GetOptionalArg(argIdx)
+		tenantID, err := logstorage.ParseTenantID(tenantIDStr)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse -kafka.consumer.topic.tenantID=%q for -kafka.consumer.topic=%q: %w", tenantIDStr, topic, err)
+		}
+
+		timeField := consumerTimeField.GetOptionalArg(argIdx)
+		if timeField == "" {
+			timeField = "_time"
+		}
+		timeFormat := consumerTimeFormat.GetOptionalArg(argIdx)
+		if timeFormat == "" {
+			timeFormat = insertutils.TimeFormatRFC3339
+		}
+		if err := insertutils.ValidateTimeFormat(timeFormat); err != nil {
+			return nil, fmt.Errorf("invalid -kafka.consumer.topic.timeFormat for -kafka.consumer.topic=%q: %w", topic, err)
+		}
+
+		deadLetterTopic := consumerDeadLetterTopic.GetOptionalArg(argIdx)
+		if deadLetterTopic == topic {
+			return nil, fmt.Errorf("-kafka.consumer.topic.deadLetterTopic cannot be equal to -kafka.consumer.topic=%q", topic)
+		}
+
+		tcs = append(tcs, &topicConfig{
+			topic:           topic,
+			brokers:         brokers,
+			groupID:         groupID,
+			concurrency:     concurrency,
+			deadLetterTopic: deadLetterTopic,
+
+			cp: &insertutils.CommonParams{
+				TenantID:     tenantID,
+				TimeField:    timeField,
+				TimeFormat:   timeFormat,
+				MsgField:     consumerMsgField.GetOptionalArg(argIdx),
+				StreamFields: splitList(consumerStreamFields.GetOptionalArg(argIdx)),
+				IgnoreFields: splitList(consumerIgnoreFields.GetOptionalArg(argIdx)),
+			},
+		})
+	}
+	return tcs, nil
+}
+
+// splitList splits ';'-delimited list s into items.
+func splitList(s string) []string {
+	var a []string
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			a = append(a, item)
+		}
+	}
+	return a
+}
+
+var (
+	consumers   []*consumer
+	consumersWG sync.WaitGroup
+
+	consumersCtx    context.Context
+	consumersCancel func()
+)
+
+// MustInit starts reading logs from -kafka.consumer.topic.
+//
+// This function must be called after flag.Parse().
+//
+// MustStop() must be called in order to stop reading logs.
+func MustInit() {
+	if consumersCancel != nil {
+		logger.Panicf("BUG: MustInit() called twice without MustStop() call")
+	}
+	consumersCtx, consumersCancel = context.WithCancel(context.Background())
+
+	tcs, err := getTopicConfigs()
+	if err != nil {
+		logger.Fatalf("cannot initialize Kafka consumers: %s", err)
+	}
+	for _, tc := range tcs {
+		for i := 0; i < tc.concurrency; i++ {
+			c, err := newConsumer(tc)
+			if err != nil {
+				logger.Fatalf("cannot initialize Kafka consumer for -kafka.consumer.topic=%q: %s", tc.topic, err)
+			}
+			consumers = append(consumers, c)
+
+			consumersWG.Add(1)
+			go func() {
+				defer consumersWG.Done()
+				c.run(consumersCtx)
+			}()
+		}
+		logger.Infof("started %d consumers for -kafka.consumer.topic=%q at -kafka.consumer.topic.brokers=%q with -kafka.consumer.topic.groupID=%q",
+			tc.concurrency, tc.topic, tc.brokers, tc.groupID)
+	}
+}
+
+// MustStop stops reading logs from -kafka.consumer.topic.
+func MustStop() {
+	consumersCancel()
+	consumersWG.Wait()
+	for _, c := range consumers {
+		c.client.Close()
+	}
+	consumers = nil
+	consumersCancel = nil
+}
+
+// consumer reads logs from Kafka topic as a member of Kafka consumer group.
+type consumer struct {
+	tc     *topicConfig
+	client *kgo.Client
+
+	// canWriteData must return nil if the logs can be written to the storage.
+	canWriteData func() error
+
+	// newLogMessageProcessor must return LogMessageProcessor for writing logs to the storage.
+	newLogMessageProcessor func() insertutils.LogMessageProcessor
+
+	messagesTotal        *metrics.Counter
+	invalidMessagesTotal *metrics.Counter
+	fetchErrorsTotal     *metrics.Counter
+	commitErrorsTotal    *metrics.Counter
+	deadLetterErrors     *metrics.Counter
+}
+
+func newConsumer(tc *topicConfig) (*consumer, error) {
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(tc.brokers...),
+		kgo.ConsumerGroup(tc.groupID),
+		kgo.ConsumeTopics(tc.topic),
+		// Offsets are committed manually after the consumed logs are written to the storage.
+		kgo.DisableAutoCommit(),
+		// Prevent from losing partitions between PollFetches() and the commit of the polled records.
+		kgo.BlockRebalanceOnPoll(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return newConsumerInternal(tc, client), nil
+}
+
+func newConsumerInternal(tc *topicConfig, client *kgo.Client) *consumer {
+	return &consumer{
+		tc:     tc,
+		client: client,
+
+		canWriteData: func() error {
+			return vlstorage.CanWriteData(tc.cp.TenantID)
+		},
+		newLogMessageProcessor: tc.cp.NewLogMessageProcessor,
+
+		messagesTotal:        metrics.GetOrCreateCounter(fmt.Sprintf(`vl_kafka_consumer_messages_total{topic=%q}`, tc.topic)),
+		invalidMessagesTotal: metrics.GetOrCreateCounter(fmt.Sprintf(`vl_kafka_consumer_invalid_messages_total{topic=%q}`, tc.topic)),
+		fetchErrorsTotal:     metrics.GetOrCreateCounter(fmt.Sprintf(`vl_kafka_consumer_errors_total{topic=%q,type="fetch"}`, tc.topic)),
+		commitErrorsTotal:    metrics.GetOrCreateCounter(fmt.Sprintf(`vl_kafka_consumer_errors_total{topic=%q,type="commit"}`, tc.topic)),
+		deadLetterErrors:     metrics.GetOrCreateCounter(fmt.Sprintf(`vl_kafka_consumer_errors_total{topic=%q,type="dead_letter"}`, tc.topic)),
+	}
+}
+
+// run reads logs from c until ctx is canceled.
+func (c *consumer) run(ctx context.Context) {
+	for {
+		fetches := c.client.PollFetches(ctx)
+		if ctx.Err() != nil || fetches.IsClientClosed() {
+			return
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			c.fetchErrorsTotal.Inc()
+			logger.Errorf("kafka: cannot fetch messages from topic %q, partition %d: %s", topic, partition, err)
+		})
+		c.processFetchedRecords(ctx, fetches.Records())
+		c.client.AllowRebalance()
+	}
+}
+
+// processFetchedRecords writes logs from records to the storage and commits offsets for records.
+//
+// The offsets aren't committed if ctx is canceled before the logs are written to the storage,
+// so the records are read again after the restart.
+func (c *consumer) processFetchedRecords(ctx context.Context, records []*kgo.Record) {
+	if len(records) == 0 {
+		return
+	}
+
+	// Wait until the storage is ready to accept new logs.
+	for {
+		err := c.canWriteData()
+		if err == nil {
+			break
+		}
+		storageErrorLogger.Warnf("kafka: pausing reading from topic %q: %s", c.tc.topic, err)
+		t := timerpool.Get(time.Second)
+		select {
+		case <-ctx.Done():
+			timerpool.Put(t)
+			return
+		case <-t.C:
+			timerpool.Put(t)
+		}
+	}
+
+	lmp := c.newLogMessageProcessor()
+	deadLetters := c.processRecords(records, lmp)
+	lmp.MustClose()
+
+	if len(deadLetters) > 0 {
+		if err := c.client.ProduceSync(ctx, deadLetters...).FirstErr(); err != nil {
+			c.deadLetterErrors.Inc()
+			logger.Errorf("kafka: cannot send %d malformed messages from topic %q to -kafka.consumer.topic.deadLetterTopic=%q: %s",
+				len(deadLetters), c.tc.topic, c.tc.deadLetterTopic, err)
+		}
+	}
+
+	// CommitRecords commits the offset for the last record per each partition.
+	if err := c.client.CommitRecords(ctx, records...); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		c.commitErrorsTotal.Inc()
+		logger.Errorf("kafka: cannot commit offsets for topic %q to consumer group %q: %s", c.tc.topic, c.tc.groupID, err)
+	}
+}
+
+var (
+	storageErrorLogger    = logger.WithThrottler("kafka_storage_error", 5*time.Second)
+	invalidMessagesLogger = logger.WithThrottler("kafka_invalid_message", 5*time.Second)
+)
+
+// processRecords sends logs from records to lmp.
+//
+// It returns records for -kafka.consumer.topic.deadLetterTopic, which must be created for malformed records.
+func (c *consumer) processRecords(records []*kgo.Record, lmp insertutils.LogMessageProcessor) []*kgo.Record {
+	var deadLetters []*kgo.Record
+	n := 0
+	for _, r := range records {
+		if err := processMessage(r.Value, r.Timestamp, c.tc.cp, lmp); err != nil {
+			c.invalidMessagesTotal.Inc()
+			if c.tc.deadLetterTopic == "" {
+				invalidMessagesLogger.Warnf("kafka: dropping malformed message from topic %q, partition %d, offset %d: %s", r.Topic, r.Partition, r.Offset, err)
+				continue
+			}
+			deadLetters = append(deadLetters, newDeadLetterRecord(c.tc.deadLetterTopic, r, err))
+			continue
+		}
+		n++
+	}
+	c.messagesTotal.Add(len(records))
+	rowsIngestedTotal.Add(n)
+	return deadLetters
+}
+
+var rowsIngestedTotal = metrics.NewCounter(`vl_rows_ingested_total{type="kafka"}`)
+
+// Headers added to the messages sent to -kafka.consumer.topic.deadLetterTopic.
+const (
+	headerError           = "vl_error"
+	headerSourceTopic     = "vl_source_topic"
+	headerSourcePartition = "vl_source_partition"
+	headerSourceOffset    = "vl_source_offset"
+)
+
+// newDeadLetterRecord returns a copy of r for sending to deadLetterTopic with the headers describing the source of r and err.
+func newDeadLetterRecord(deadLetterTopic string, r *kgo.Record, err error) *kgo.Record {
+	headers := append([]kgo.RecordHeader{}, r.Headers...)
+	headers = append(headers,
+		kgo.RecordHeader{Key: headerError, Value: []byte(err.Error())},
+		kgo.RecordHeader{Key: headerSourceTopic, Value: []byte(r.Topic)},
+		kgo.RecordHeader{Key: headerSourcePartition, Value: strconv.AppendInt(nil, int64(r.Partition), 10)},
+		kgo.RecordHeader{Key: headerSourceOffset, Value: strconv.AppendInt(nil, r.Offset, 10)},
+	)
+	return &kgo.Record{
+		Topic:   deadLetterTopic,
+		Key:     r.Key,
+		Value:   r.Value,
+		Headers: headers,
+	}
+}
+
+// processMessage parses JSON-encoded log entry from data and sends it to lmp.
+//
+// messageTimestamp is used as the log entry timestamp if the log entry doesn't contain cp.TimeField.
+func processMessage(data []byte, messageTimestamp time.Time, cp *insertutils.CommonParams, lmp insertutils.LogMessageProcessor) error {
+	p := logstorage.GetJSONParser()
+	defer logstorage.PutJSONParser(p)
+
+	if err := p.ParseLogMessage(data); err != nil {
+		return fmt.Errorf("cannot parse json-encoded log entry: %w", err)
+	}
+
+	var ts int64
+	if hasField(p.Fields, cp.TimeField) || messageTimestamp.IsZero() {
+		nsecs, err := insertutils.ExtractTimestampFromFields(cp.TimeField, cp.TimeFormat, p.Fields)
+		if err != nil {
+			return fmt.Errorf("cannot get timestamp: %w", err)
+		}
+		ts = nsecs
+	} else {
+		ts = messageTimestamp.UnixNano()
+	}
+	logstorage.RenameField(p.Fields, cp.MsgField, "_msg")
+	lmp.AddRow(ts, p.Fields)
+	return nil
+}
+
+func hasField(fields []logstorage.Field, name string) bool {
+	for _, f := range fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
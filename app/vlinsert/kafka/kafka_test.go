@@ -0,0 +1,350 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlinsert/insertutils"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+func TestGetTopicConfigsSuccess(t *testing.T) {
+	defer resetFlags()
+
+	*consumerTopic = []string{"logs", "audit"}
+	*consumerBrokers = []string{"host1:9092; host2:9092", "host3:9092"}
+	*consumerGroupID = []string{"", "audit-group"}
+	*consumerTenantID = []string{"", "12:34"}
+	*consumerTimeField = []string{"ts"}
+	*consumerTimeFormat = []string{"unix_ms", ""}
+	*consumerMsgField = []string{"message"}
+	*consumerStreamFields = []string{"host;app", ""}
+	*consumerIgnoreFields = []string{"", "password"}
+	*consumerDeadLetterTopic = []string{"logs-dlq"}
+
+	tcs, err := getTopicConfigs()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tcsExpected := []*topicConfig{
+		{
+			topic:           "logs",
+			brokers:         []string{"host1:9092", "host2:9092"},
+			groupID:         defaultGroupID,
+			concurrency:     1,
+			deadLetterTopic: "logs-dlq",
+			cp: &insertutils.CommonParams{
+				TimeField:    "ts",
+				TimeFormat:   insertutils.TimeFormatUnixMs,
+				MsgField:     "message",
+				StreamFields: []string{"host", "app"},
+			},
+		},
+		{
+			topic:           "audit",
+			brokers:         []string{"host3:9092"},
+			groupID:         "audit-group",
+			concurrency:     1,
+			deadLetterTopic: "logs-dlq",
+			cp: &insertutils.CommonParams{
+				TenantID: logstorage.TenantID{
+					AccountID: 12,
+					ProjectID: 34,
+				},
+				TimeField:    "ts",
+				TimeFormat:   insertutils.TimeFormatRFC3339,
+				MsgField:     "message",
+				IgnoreFields: []string{"password"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(tcs, tcsExpected) {
+		t.Fatalf("unexpected configs\ngot\n%+v\n%+v\nwant\n%+v\n%+v", tcs[0], tcs[0].cp, tcsExpected[0], tcsExpected[0].cp)
+	}
+}
+
+func TestGetTopicConfigsFailure(t *testing.T) {
+	f := func(setFlags func()) {
+		t.Helper()
+		defer resetFlags()
+
+		*consumerTopic = []string{"logs"}
+		*consumerBrokers = []string{"localhost:9092"}
+		setFlags()
+
+		if _, err := getTopicConfigs(); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// empty topic
+	f(func() {
+		*consumerTopic = []string{""}
+	})
+
+	// missing brokers
+	f(func() {
+		*consumerBrokers = nil
+	})
+	f(func() {
+		*consumerBrokers = []string{" ; "}
+	})
+
+	// invalid tenant
+	f(func() {
+		*consumerTenantID = []string{"foo"}
+	})
+
+	// invalid time format
+	f(func() {
+		*consumerTimeFormat = []string{"foo"}
+	})
+
+	// dead letter topic equals to the topic
+	f(func() {
+		*consumerDeadLetterTopic = []string{"logs"}
+	})
+}
+
+func resetFlags() {
+	*consumerTopic = nil
+	*consumerBrokers = nil
+	*consumerGroupID = nil
+	*consumerTenantID = nil
+	*consumerTimeField = nil
+	*consumerTimeFormat = nil
+	*consumerMsgField = nil
+	*consumerStreamFields = nil
+	*consumerIgnoreFields = nil
+	*consumerDeadLetterTopic = nil
+}
+
+func newTestTopicConfig(brokers []string, deadLetterTopic string) *topicConfig {
+	return &topicConfig{
+		topic:           "logs",
+		brokers:         brokers,
+		groupID:         "test",
+		concurrency:     1,
+		deadLetterTopic: deadLetterTopic,
+		cp: &insertutils.CommonParams{
+			TimeField:  "ts",
+			TimeFormat: insertutils.TimeFormatRFC3339,
+			MsgField:   "message",
+		},
+	}
+}
+
+func TestProcessRecords(t *testing.T) {
+	f := func(deadLetterTopic string, records []*kgo.Record, deadLettersExpected []*kgo.Record, timestampsExpected []int64, resultExpected string) {
+		t.Helper()
+
+		c := newConsumerInternal(newTestTopicConfig(nil, deadLetterTopic), nil)
+		tlp := &insertutils.TestLogMessageProcessor{}
+		deadLetters := c.processRecords(records, tlp)
+		if !reflect.DeepEqual(deadLetters, deadLettersExpected) {
+			t.Fatalf("unexpected dead letters\ngot\n%+v\nwant\n%+v", deadLetters, deadLettersExpected)
+		}
+		if err := tlp.Verify(len(timestampsExpected), timestampsExpected, resultExpected); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messageTimestamp := time.Unix(1717150800, 0)
+	records := []*kgo.Record{
+		{
+			Topic:     "logs",
+			Partition: 1,
+			Offset:    10,
+			Value:     []byte(`{"ts":"2024-05-31T10:21:00Z","message":"foo","host":"a"}`),
+			Timestamp: messageTimestamp,
+		},
+		{
+			Topic:     "logs",
+			Partition: 1,
+			Offset:    11,
+			Key:       []byte("key"),
+			Value:     []byte(`foobar`),
+			Headers: []kgo.RecordHeader{
+				{Key: "foo", Value: []byte("bar")},
+			},
+			Timestamp: messageTimestamp,
+		},
+		{
+			Topic:     "logs",
+			Partition: 1,
+			Offset:    12,
+			Value:     []byte(`{"message":"bar"}`),
+			Timestamp: messageTimestamp,
+		},
+		{
+			Topic:     "logs",
+			Partition: 1,
+			Offset:    13,
+			Value:     []byte(`{"ts":"invalid","message":"baz"}`),
+			Timestamp: messageTimestamp,
+		},
+	}
+	timestampsExpected := []int64{1717150860000000000, 1717150800000000000}
+	resultExpected := `{"ts":"","_msg":"foo","host":"a"}
+{"_msg":"bar"}`
+
+	// malformed messages are dropped without dead letter topic
+	f("", records, nil, timestampsExpected, resultExpected)
+
+	// malformed messages are sent to dead letter topic
+	f("logs-dlq", records, []*kgo.Record{
+		{
+			Topic: "logs-dlq",
+			Key:   []byte("key"),
+			Value: []byte(`foobar`),
+			Headers: []kgo.RecordHeader{
+				{Key: "foo", Value: []byte("bar")},
+				{Key: headerError, Value: []byte(`cannot parse json-encoded log entry: cannot parse json: cannot parse JSON: unexpected value found: "foobar"; unparsed tail: "foobar"`)},
+				{Key: headerSourceTopic, Value: []byte("logs")},
+				{Key: headerSourcePartition, Value: []byte("1")},
+				{Key: headerSourceOffset, Value: []byte("11")},
+			},
+		},
+		{
+			Topic: "logs-dlq",
+			Value: []byte(`{"ts":"invalid","message":"baz"}`),
+			Headers: []kgo.RecordHeader{
+				{Key: headerError, Value: []byte(`cannot get timestamp: cannot unmarshal rfc3339 timestamp from ts="invalid"`)},
+				{Key: headerSourceTopic, Value: []byte("logs")},
+				{Key: headerSourcePartition, Value: []byte("1")},
+				{Key: headerSourceOffset, Value: []byte("13")},
+			},
+		},
+	}, timestampsExpected, resultExpected)
+}
+
+// syncLogMessageProcessor is LogMessageProcessor, which can be used from concurrently running goroutines.
+type syncLogMessageProcessor struct {
+	mu   sync.Mutex
+	rows []string
+}
+
+func (slp *syncLogMessageProcessor) AddRow(_ int64, fields []logstorage.Field) {
+	slp.mu.Lock()
+	slp.rows = append(slp.rows, string(logstorage.MarshalFieldsToJSON(nil, fields)))
+	slp.mu.Unlock()
+}
+
+func (slp *syncLogMessageProcessor) MustClose() {
+}
+
+func (slp *syncLogMessageProcessor) getRows() string {
+	slp.mu.Lock()
+	defer slp.mu.Unlock()
+	return strings.Join(slp.rows, "\n")
+}
+
+func TestConsumerRun(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "logs", "logs-dlq"))
+	if err != nil {
+		t.Fatalf("cannot start Kafka cluster: %s", err)
+	}
+	defer cluster.Close()
+	brokers := cluster.ListenAddrs()
+
+	producer, err := kgo.NewClient(kgo.SeedBrokers(brokers...))
+	if err != nil {
+		t.Fatalf("cannot create producer: %s", err)
+	}
+	defer producer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	produce := func(values ...string) {
+		t.Helper()
+		for _, v := range values {
+			if err := producer.ProduceSync(ctx, &kgo.Record{Topic: "logs", Value: []byte(v)}).FirstErr(); err != nil {
+				t.Fatalf("cannot produce message: %s", err)
+			}
+		}
+	}
+
+	tc := newTestTopicConfig(brokers, "logs-dlq")
+	slp := &syncLogMessageProcessor{}
+
+	// runConsumer runs consumer until the offsets up to offsetExpected are committed for logs topic.
+	runConsumer := func(offsetExpected int64) {
+		t.Helper()
+
+		c, err := newConsumer(tc)
+		if err != nil {
+			t.Fatalf("cannot create consumer: %s", err)
+		}
+		c.canWriteData = func() error {
+			return nil
+		}
+		c.newLogMessageProcessor = func() insertutils.LogMessageProcessor {
+			return slp
+		}
+
+		runCtx, runCancel := context.WithCancel(ctx)
+		doneCh := make(chan struct{})
+		go func() {
+			c.run(runCtx)
+			close(doneCh)
+		}()
+
+		for {
+			if c.client.CommittedOffsets()["logs"][0].Offset == offsetExpected {
+				break
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("timeout when waiting for committed offset %d; got %v", offsetExpected, c.client.CommittedOffsets())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		runCancel()
+		<-doneCh
+		c.client.Close()
+	}
+
+	produce(`{"message":"foo"}`, `foobar`, `{"message":"bar"}`)
+	runConsumer(3)
+	if rows := slp.getRows(); rows != `{"_msg":"foo"}`+"\n"+`{"_msg":"bar"}` {
+		t.Fatalf("unexpected rows after the first run:\n%s", rows)
+	}
+
+	// the second consumer in the same group must start from the committed offset
+	produce(`{"message":"baz"}`)
+	runConsumer(4)
+	if rows := slp.getRows(); rows != `{"_msg":"foo"}`+"\n"+`{"_msg":"bar"}`+"\n"+`{"_msg":"baz"}` {
+		t.Fatalf("unexpected rows after the second run:\n%s", rows)
+	}
+
+	// verify the malformed message is sent to dead letter topic
+	dlqConsumer, err := kgo.NewClient(kgo.SeedBrokers(brokers...), kgo.ConsumeTopics("logs-dlq"))
+	if err != nil {
+		t.Fatalf("cannot create dead letter topic consumer: %s", err)
+	}
+	defer dlqConsumer.Close()
+	fetches := dlqConsumer.PollFetches(ctx)
+	if err := fetches.Err(); err != nil {
+		t.Fatalf("cannot read dead letter topic: %s", err)
+	}
+	records := fetches.Records()
+	if len(records) != 1 {
+		t.Fatalf("unexpected number of messages in dead letter topic; got %d; want 1", len(records))
+	}
+	r := records[0]
+	headers := make(map[string]string)
+	for _, h := range r.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	if string(r.Value) != "foobar" || headers[headerSourceTopic] != "logs" || headers[headerSourceOffset] != "1" || headers[headerError] == "" {
+		t.Fatalf("unexpected message in dead letter topic: value=%q, headers=%s", r.Value, fmt.Sprint(headers))
+	}
+}
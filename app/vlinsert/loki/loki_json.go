@@ -48,7 +48,7 @@ func handleJSON(r *http.Request, w http.ResponseWriter) {
 		httpserver.Errorf(w, r, "cannot parse common params from request: %s", err)
 		return
 	}
-	if err := vlstorage.CanWriteData(); err != nil {
+	if err := vlstorage.CanWriteData(cp.TenantID); err != nil {
 		httpserver.Errorf(w, r, "%s", err)
 		return
 	}
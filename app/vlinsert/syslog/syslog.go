@@ -317,7 +317,7 @@ func serveTCP(ln net.Listener, tenantID logstorage.TenantID, compressMethod stri
 
 // processStream parses a stream of syslog messages from r and ingests them into vlstorage.
 func processStream(r io.Reader, compressMethod string, useLocalTimestamp bool, cp *insertutils.CommonParams) error {
-	if err := vlstorage.CanWriteData(); err != nil {
+	if err := vlstorage.CanWriteData(cp.TenantID); err != nil {
 		return err
 	}
 
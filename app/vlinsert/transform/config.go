@@ -0,0 +1,183 @@
+package transform
+
+import (
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/envtemplate"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs/fscore"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+// Config is a configuration for a single transformation rule.
+//
+// See https://docs.victoriametrics.com/victorialogs/data-ingestion/#transformation-rules
+type Config struct {
+	// Action is the action to apply to log entries. See the supported actions below.
+	Action string `yaml:"action"`
+
+	// If is an optional LogsQL filter. The rule is applied only to log entries matching the filter.
+	If string `yaml:"if,omitempty"`
+
+	// Fields is the list of fields to drop for `drop_fields` action.
+	Fields []string `yaml:"fields,omitempty"`
+
+	// Field is the source field for `rename_field` and `parse_json` actions.
+	Field string `yaml:"field,omitempty"`
+
+	// TargetField is the new field name for `rename_field` action.
+	TargetField string `yaml:"target_field,omitempty"`
+
+	// Prefix is an optional prefix for field names obtained by `parse_json` action.
+	Prefix string `yaml:"prefix,omitempty"`
+
+	// Values contains fields to set for `add_fields` action.
+	Values map[string]string `yaml:"values,omitempty"`
+}
+
+// Supported values for Config.Action.
+const (
+	actionDrop        = "drop"
+	actionDropFields  = "drop_fields"
+	actionRenameField = "rename_field"
+	actionParseJSON   = "parse_json"
+	actionAddFields   = "add_fields"
+)
+
+func loadFromFile(path string) ([]byte, []*rule, error) {
+	data, err := fscore.ReadFileOrHTTP(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot load transformation config: %w", err)
+	}
+	data, err = envtemplate.ReplaceBytes(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot expand environment variables: %w", err)
+	}
+	rules, err := loadFromData(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, rules, nil
+}
+
+func loadFromData(data []byte) ([]*rule, error) {
+	var cfgs []*Config
+	if err := yaml.UnmarshalStrict(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("cannot parse transformation config: %w", err)
+	}
+
+	rules := make([]*rule, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		r, err := newRule(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("cannot initialize rule #%d: %w", i+1, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func newRule(cfg *Config) (*rule, error) {
+	r := &rule{
+		action: cfg.Action,
+	}
+
+	if cfg.If != "" {
+		iff, err := logstorage.ParseRowFilter(cfg.If)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse `if` filter [%s]: %w", cfg.If, err)
+		}
+		r.iff = iff
+	}
+
+	switch cfg.Action {
+	case actionDrop:
+		if r.iff == nil {
+			return nil, fmt.Errorf("missing `if` filter for `%s` action", cfg.Action)
+		}
+		if err := checkUnusedOptions(cfg, false, false, false, false, false); err != nil {
+			return nil, err
+		}
+	case actionDropFields:
+		if len(cfg.Fields) == 0 {
+			return nil, fmt.Errorf("missing `fields` for `%s` action", cfg.Action)
+		}
+		if err := checkUnusedOptions(cfg, true, false, false, false, false); err != nil {
+			return nil, err
+		}
+		for _, f := range cfg.Fields {
+			r.fields = append(r.fields, getCanonicalFieldName(f))
+		}
+	case actionRenameField:
+		if cfg.Field == "" {
+			return nil, fmt.Errorf("missing `field` for `%s` action", cfg.Action)
+		}
+		if cfg.TargetField == "" {
+			return nil, fmt.Errorf("missing `target_field` for `%s` action", cfg.Action)
+		}
+		if err := checkUnusedOptions(cfg, false, true, true, false, false); err != nil {
+			return nil, err
+		}
+		r.field = getCanonicalFieldName(cfg.Field)
+		r.targetField = getCanonicalFieldName(cfg.TargetField)
+	case actionParseJSON:
+		if err := checkUnusedOptions(cfg, false, true, false, true, false); err != nil {
+			return nil, err
+		}
+		r.field = getCanonicalFieldName(cfg.Field)
+		r.prefix = cfg.Prefix
+	case actionAddFields:
+		if len(cfg.Values) == 0 {
+			return nil, fmt.Errorf("missing `values` for `%s` action", cfg.Action)
+		}
+		if err := checkUnusedOptions(cfg, false, false, false, false, true); err != nil {
+			return nil, err
+		}
+		for name, value := range cfg.Values {
+			r.values = append(r.values, logstorage.Field{
+				Name:  getCanonicalFieldName(name),
+				Value: value,
+			})
+		}
+		sort.Slice(r.values, func(i, j int) bool {
+			return r.values[i].Name < r.values[j].Name
+		})
+	case "":
+		return nil, fmt.Errorf("missing `action`; supported actions: %s, %s, %s, %s, %s",
+			actionDrop, actionDropFields, actionRenameField, actionParseJSON, actionAddFields)
+	default:
+		return nil, fmt.Errorf("unsupported action=%q; supported actions: %s, %s, %s, %s, %s", cfg.Action,
+			actionDrop, actionDropFields, actionRenameField, actionParseJSON, actionAddFields)
+	}
+
+	return r, nil
+}
+
+// checkUnusedOptions returns an error if cfg contains options, which aren't used by cfg.Action.
+func checkUnusedOptions(cfg *Config, useFields, useField, useTargetField, usePrefix, useValues bool) error {
+	if !useFields && len(cfg.Fields) > 0 {
+		return fmt.Errorf("`fields` cannot be used with `%s` action", cfg.Action)
+	}
+	if !useField && cfg.Field != "" {
+		return fmt.Errorf("`field` cannot be used with `%s` action", cfg.Action)
+	}
+	if !useTargetField && cfg.TargetField != "" {
+		return fmt.Errorf("`target_field` cannot be used with `%s` action", cfg.Action)
+	}
+	if !usePrefix && cfg.Prefix != "" {
+		return fmt.Errorf("`prefix` cannot be used with `%s` action", cfg.Action)
+	}
+	if !useValues && len(cfg.Values) > 0 {
+		return fmt.Errorf("`values` cannot be used with `%s` action", cfg.Action)
+	}
+	return nil
+}
+
+func getCanonicalFieldName(name string) string {
+	if name == "" {
+		return "_msg"
+	}
+	return name
+}
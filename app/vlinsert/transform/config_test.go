@@ -0,0 +1,97 @@
+package transform
+
+import (
+	"testing"
+)
+
+func TestLoadFromDataSuccess(t *testing.T) {
+	f := func(data string, rulesExpected int) {
+		t.Helper()
+
+		rules, err := loadFromData([]byte(data))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(rules) != rulesExpected {
+			t.Fatalf("unexpected number of rules; got %d; want %d", len(rules), rulesExpected)
+		}
+	}
+
+	f(``, 0)
+	f(`[]`, 0)
+	f(`
+- action: drop
+  if: 'level:debug'
+- action: drop_fields
+  fields: [password, token]
+- action: rename_field
+  field: log
+  target_field: _msg
+- action: parse_json
+- action: parse_json
+  field: payload
+  prefix: payload.
+  if: 'payload:*'
+- action: add_fields
+  values:
+    env: prod
+    region: eu
+`, 6)
+}
+
+func TestLoadFromDataFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		if _, err := loadFromData([]byte(data)); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// invalid yaml
+	f(`foo`)
+	f(`- action: drop_fields
+  fields: foo`)
+
+	// unknown option
+	f(`- action: drop_fields
+  fields: [foo]
+  foo: bar`)
+
+	// missing or unknown action
+	f(`- fields: [foo]`)
+	f(`- action: foo`)
+
+	// invalid if filter
+	f(`- action: drop
+  if: 'foo | limit 10'`)
+	f(`- action: drop
+  if: '_stream:{app="foo"}'`)
+
+	// missing required options
+	f(`- action: drop`)
+	f(`- action: drop_fields`)
+	f(`- action: rename_field
+  target_field: foo`)
+	f(`- action: rename_field
+  field: foo`)
+	f(`- action: add_fields`)
+
+	// unused options
+	f(`- action: drop
+  if: foo
+  fields: [foo]`)
+	f(`- action: drop_fields
+  fields: [foo]
+  field: bar`)
+	f(`- action: rename_field
+  field: foo
+  target_field: bar
+  prefix: baz`)
+	f(`- action: parse_json
+  target_field: foo`)
+	f(`- action: add_fields
+  values:
+    foo: bar
+  fields: [foo]`)
+}
@@ -0,0 +1,116 @@
+package transform
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+)
+
+var (
+	configPath = flag.String("transform.config", "", "Optional path to file with transformation rules, which are applied to all the ingested logs before storing them. "+
+		"The path can point either to local file or to http url. The config is reloaded on SIGHUP signal. "+
+		"See https://docs.victoriametrics.com/victorialogs/data-ingestion/#transformation-rules")
+	configCheckInterval = flag.Duration("transform.configCheckInterval", 0, "Interval for checking for changes in -transform.config. "+
+		"By default the checking is disabled. Send SIGHUP signal in order to force config check for changes")
+)
+
+var rulesGlobal atomic.Pointer[[]*rule]
+
+var (
+	reloaderWG     sync.WaitGroup
+	reloaderStopCh chan struct{}
+)
+
+// Init initializes transformation rules from -transform.config.
+//
+// It must be called after flag.Parse() and before the data ingestion is started.
+func Init() {
+	if *configPath == "" {
+		return
+	}
+
+	// Register SIGHUP handler for config re-read just before loading the config.
+	// This guarantees that the config will be re-read if the signal arrives during the loading.
+	sighupCh := procutil.NewSighupChan()
+
+	data, rs, err := loadFromFile(*configPath)
+	if err != nil {
+		logger.Fatalf("cannot load -transform.config=%q: %s", *configPath, err)
+	}
+	rulesGlobal.Store(&rs)
+	configSuccess.Set(1)
+	configTimestamp.Set(fasttime.UnixTimestamp())
+	logger.Infof("loaded %d transformation rules from -transform.config=%q", len(rs), *configPath)
+
+	reloaderStopCh = make(chan struct{})
+	reloaderWG.Add(1)
+	go func() {
+		defer reloaderWG.Done()
+		runConfigReloader(sighupCh, data)
+	}()
+}
+
+// Stop stops reloading transformation rules.
+func Stop() {
+	if reloaderStopCh == nil {
+		return
+	}
+	close(reloaderStopCh)
+	reloaderWG.Wait()
+	reloaderStopCh = nil
+
+	rulesGlobal.Store(nil)
+}
+
+func runConfigReloader(sighupCh <-chan os.Signal, data []byte) {
+	var tickerCh <-chan time.Time
+	if *configCheckInterval > 0 {
+		ticker := time.NewTicker(*configCheckInterval)
+		defer ticker.Stop()
+		tickerCh = ticker.C
+	}
+
+	for {
+		select {
+		case <-reloaderStopCh:
+			return
+		case <-sighupCh:
+			logger.Infof("received SIGHUP; reloading -transform.config=%q", *configPath)
+		case <-tickerCh:
+		}
+
+		configReloads.Inc()
+		dataNew, rs, err := loadFromFile(*configPath)
+		if err != nil {
+			configReloadErrors.Inc()
+			configSuccess.Set(0)
+			logger.Errorf("cannot reload -transform.config=%q: %s; continue using the previously loaded config", *configPath, err)
+			continue
+		}
+		configSuccess.Set(1)
+		if bytes.Equal(data, dataNew) {
+			// Nothing changed.
+			continue
+		}
+		data = dataNew
+		rulesGlobal.Store(&rs)
+		configTimestamp.Set(fasttime.UnixTimestamp())
+		logger.Infof("successfully reloaded %d transformation rules from -transform.config=%q", len(rs), *configPath)
+	}
+}
+
+var (
+	configReloads      = metrics.NewCounter(`vl_transform_config_reloads_total`)
+	configReloadErrors = metrics.NewCounter(`vl_transform_config_reloads_errors_total`)
+	configSuccess      = metrics.NewGauge(`vl_transform_config_last_reload_successful`, nil)
+	configTimestamp    = metrics.NewCounter(`vl_transform_config_last_reload_success_timestamp_seconds`)
+)
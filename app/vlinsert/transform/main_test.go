@@ -0,0 +1,88 @@
+package transform
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+func TestConfigReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transform.yml")
+	writeConfig := func(data string) {
+		// Write the config atomically, since the config reloader may read partially written config otherwise.
+		fs.MustWriteAtomic(path, []byte(data), true)
+	}
+
+	configPathOrig, configCheckIntervalOrig := *configPath, *configCheckInterval
+	defer func() {
+		*configPath, *configCheckInterval = configPathOrig, configCheckIntervalOrig
+	}()
+	*configPath = path
+	*configCheckInterval = 10 * time.Millisecond
+
+	writeConfig(`
+- action: add_fields
+  values:
+    env: prod
+`)
+	Init()
+	defer Stop()
+
+	apply := func() string {
+		var ctx Ctx
+		defer ctx.Reset()
+
+		fields, ok := ctx.Apply(0, []logstorage.Field{{Name: "_msg", Value: "foo"}})
+		if !ok {
+			return "<dropped>"
+		}
+		return string(logstorage.MarshalFieldsToJSON(nil, fields))
+	}
+	waitForResult := func(resultExpected string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			result := apply()
+			if result == resultExpected {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout when waiting for the result\ngot\n%s\nwant\n%s", result, resultExpected)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitForResult(`{"_msg":"foo","env":"prod"}`)
+
+	// The updated config must be applied
+	writeConfig(`
+- action: drop
+  if: foo
+`)
+	waitForResult(`<dropped>`)
+
+	// The previous config must be preserved on invalid config
+	reloadErrorsPrev := configReloadErrors.Get()
+	writeConfig(`foobar`)
+	deadline := time.Now().Add(5 * time.Second)
+	for configReloadErrors.Get() == reloadErrorsPrev {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout when waiting for the invalid config reload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if v := configSuccess.Get(); v != 0 {
+		t.Fatalf("unexpected vl_transform_config_last_reload_successful after the invalid config reload; got %v; want 0", v)
+	}
+	if result := apply(); result != `<dropped>` {
+		t.Fatalf("unexpected result after the invalid config reload\ngot\n%s\nwant\n%s", result, `<dropped>`)
+	}
+
+	// No rules are applied after Stop
+	Stop()
+	waitForResult(`{"_msg":"foo"}`)
+}
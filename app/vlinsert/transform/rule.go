@@ -0,0 +1,189 @@
+package transform
+
+import (
+	"slices"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+// rule is a single transformation rule.
+type rule struct {
+	action string
+
+	// iff is an optional filter for log entries the rule is applied to.
+	iff *logstorage.RowFilter
+
+	// fields contains field names for drop_fields action.
+	fields []string
+
+	// field is the source field for rename_field and parse_json actions.
+	field string
+
+	// targetField is the new field name for rename_field action.
+	targetField string
+
+	// prefix is the prefix for field names obtained by parse_json action.
+	prefix string
+
+	// values contains fields for add_fields action.
+	values []logstorage.Field
+}
+
+// Ctx holds the state for applying transformation rules to log entries.
+//
+// Ctx mustn't be used from concurrently running goroutines.
+type Ctx struct {
+	// fields holds the transformed fields.
+	fields []logstorage.Field
+
+	// buf holds field names generated by parse_json action.
+	buf []byte
+
+	// jps holds parsers for parse_json action, since the parsed fields are referred by fields.
+	jps []*logstorage.JSONParser
+
+	// jpsUsed is the number of jps used by the current Apply call.
+	jpsUsed int
+}
+
+// Reset resets ctx, so it could be re-used.
+func (ctx *Ctx) Reset() {
+	clear(ctx.fields)
+	ctx.fields = ctx.fields[:0]
+
+	ctx.buf = ctx.buf[:0]
+
+	for _, jp := range ctx.jps {
+		logstorage.PutJSONParser(jp)
+	}
+	clear(ctx.jps)
+	ctx.jps = ctx.jps[:0]
+	ctx.jpsUsed = 0
+}
+
+// Apply applies transformation rules from -transform.config to the log entry with the given timestamp and fields.
+//
+// It returns false if the log entry must be dropped.
+// The returned fields are valid until the next call to Apply or Reset, or until the given fields are modified.
+func (ctx *Ctx) Apply(timestamp int64, fields []logstorage.Field) ([]logstorage.Field, bool) {
+	rs := rulesGlobal.Load()
+	if rs == nil || len(*rs) == 0 {
+		// Fast path - nothing to apply.
+		return fields, true
+	}
+	return ctx.applyRules(*rs, timestamp, fields)
+}
+
+func (ctx *Ctx) applyRules(rs []*rule, timestamp int64, fields []logstorage.Field) ([]logstorage.Field, bool) {
+	ctx.buf = ctx.buf[:0]
+	ctx.jpsUsed = 0
+
+	// Copy fields, since they are modified below.
+	dst := append(ctx.fields[:0], fields...)
+	defer func() {
+		ctx.fields = dst
+	}()
+
+	for _, r := range rs {
+		if r.iff != nil && !r.iff.MatchRow(timestamp, dst) {
+			continue
+		}
+		switch r.action {
+		case actionDrop:
+			rowsDroppedTotal.Inc()
+			return nil, false
+		case actionDropFields:
+			dst = slices.DeleteFunc(dst, func(f logstorage.Field) bool {
+				return slices.Contains(r.fields, f.Name)
+			})
+		case actionRenameField:
+			dst = renameField(dst, r.field, r.targetField)
+		case actionParseJSON:
+			dst = ctx.parseJSON(dst, r.field, r.prefix)
+		case actionAddFields:
+			for _, f := range r.values {
+				dst = setField(dst, f.Name, f.Value)
+			}
+		default:
+			logger.Panicf("BUG: unexpected action=%q", r.action)
+		}
+	}
+	return dst, true
+}
+
+func (ctx *Ctx) parseJSON(dst []logstorage.Field, fieldName, prefix string) []logstorage.Field {
+	value := getFieldValue(dst, fieldName)
+	if value == "" {
+		return dst
+	}
+
+	if ctx.jpsUsed >= len(ctx.jps) {
+		ctx.jps = append(ctx.jps, logstorage.GetJSONParser())
+	}
+	jp := ctx.jps[ctx.jpsUsed]
+	ctx.jpsUsed++
+
+	if err := jp.ParseLogMessage(bytesutil.ToUnsafeBytes(value)); err != nil {
+		// Leave the log entry as is if the field doesn't contain JSON object.
+		parseJSONErrorsTotal.Inc()
+		return dst
+	}
+	for _, f := range jp.Fields {
+		name := f.Name
+		if prefix != "" {
+			// Previously generated names remain valid after ctx.buf re-allocation,
+			// since they refer the previous backing array.
+			bufLen := len(ctx.buf)
+			ctx.buf = append(ctx.buf, prefix...)
+			ctx.buf = append(ctx.buf, name...)
+			name = bytesutil.ToUnsafeString(ctx.buf[bufLen:])
+		}
+		dst = setField(dst, name, f.Value)
+	}
+	return dst
+}
+
+func getFieldValue(fields []logstorage.Field, name string) string {
+	for _, f := range fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// renameField renames srcName field to dstName field at dst. The existing dstName fields are removed.
+func renameField(dst []logstorage.Field, srcName, dstName string) []logstorage.Field {
+	if srcName == dstName || !slices.ContainsFunc(dst, func(f logstorage.Field) bool { return f.Name == srcName }) {
+		return dst
+	}
+	dst = slices.DeleteFunc(dst, func(f logstorage.Field) bool {
+		return f.Name == dstName
+	})
+	for i := range dst {
+		if dst[i].Name == srcName {
+			dst[i].Name = dstName
+		}
+	}
+	return dst
+}
+
+// setField sets the field with the given name to the given value at dst. The existing fields with the given name are removed.
+func setField(dst []logstorage.Field, name, value string) []logstorage.Field {
+	dst = slices.DeleteFunc(dst, func(f logstorage.Field) bool {
+		return f.Name == name
+	})
+	return append(dst, logstorage.Field{
+		Name:  name,
+		Value: value,
+	})
+}
+
+var (
+	rowsDroppedTotal     = metrics.NewCounter(`vl_rows_dropped_total{reason="transform"}`)
+	parseJSONErrorsTotal = metrics.NewCounter(`vl_transform_parse_json_errors_total`)
+)
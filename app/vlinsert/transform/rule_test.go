@@ -0,0 +1,143 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+func TestCtxApplyRules(t *testing.T) {
+	f := func(config string, fields []logstorage.Field, resultExpected string) {
+		t.Helper()
+
+		rules, err := loadFromData([]byte(config))
+		if err != nil {
+			t.Fatalf("cannot load rules: %s", err)
+		}
+
+		var ctx Ctx
+		defer ctx.Reset()
+
+		// Apply the rules twice in order to verify ctx re-use.
+		for i := 0; i < 2; i++ {
+			fieldsCopy := append([]logstorage.Field{}, fields...)
+			dst, ok := ctx.applyRules(rules, 1717150800000000000, fieldsCopy)
+			result := "<dropped>"
+			if ok {
+				result = string(logstorage.MarshalFieldsToJSON(nil, dst))
+			}
+			if result != resultExpected {
+				t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+			}
+			if string(logstorage.MarshalFieldsToJSON(nil, fieldsCopy)) != string(logstorage.MarshalFieldsToJSON(nil, fields)) {
+				t.Fatalf("the original fields mustn't be modified")
+			}
+		}
+	}
+
+	fields := []logstorage.Field{
+		{Name: "_msg", Value: `{"level":"error","user":{"id":"123"},"msg":"cannot open file"}`},
+		{Name: "host", Value: "host1"},
+		{Name: "password", Value: "secret"},
+	}
+
+	// no rules
+	f(``, fields, `{"_msg":"{\"level\":\"error\",\"user\":{\"id\":\"123\"},\"msg\":\"cannot open file\"}","host":"host1","password":"secret"}`)
+
+	// drop rows
+	f(`
+- action: drop
+  if: 'host:host1'
+`, fields, `<dropped>`)
+	f(`
+- action: drop
+  if: 'host:host2'
+`, fields, `{"_msg":"{\"level\":\"error\",\"user\":{\"id\":\"123\"},\"msg\":\"cannot open file\"}","host":"host1","password":"secret"}`)
+
+	// drop fields
+	f(`
+- action: drop_fields
+  fields: [password, missing]
+`, fields, `{"_msg":"{\"level\":\"error\",\"user\":{\"id\":\"123\"},\"msg\":\"cannot open file\"}","host":"host1"}`)
+
+	// rename field
+	f(`
+- action: rename_field
+  field: host
+  target_field: hostname
+- action: rename_field
+  field: missing
+  target_field: password
+`, fields, `{"_msg":"{\"level\":\"error\",\"user\":{\"id\":\"123\"},\"msg\":\"cannot open file\"}","hostname":"host1","password":"secret"}`)
+
+	// rename field to the existing field
+	f(`
+- action: rename_field
+  field: host
+  target_field: password
+`, fields, `{"_msg":"{\"level\":\"error\",\"user\":{\"id\":\"123\"},\"msg\":\"cannot open file\"}","password":"host1"}`)
+
+	// parse JSON message, then replace the message with the parsed msg field and drop rows with debug level
+	f(`
+- action: parse_json
+- action: rename_field
+  field: msg
+  target_field: _msg
+- action: drop
+  if: 'level:debug'
+- action: drop_fields
+  fields: [password]
+`, fields, `{"host":"host1","level":"error","user.id":"123","_msg":"cannot open file"}`)
+
+	// parse JSON with prefix
+	f(`
+- action: parse_json
+  field: _msg
+  prefix: json.
+- action: drop_fields
+  fields: [_msg, password]
+`, fields, `{"host":"host1","json.level":"error","json.user.id":"123","json.msg":"cannot open file"}`)
+
+	// parse JSON with multiple rules
+	f(`
+- action: parse_json
+  prefix: a.
+- action: parse_json
+  prefix: b.
+- action: drop_fields
+  fields: [_msg, password, host]
+`, fields, `{"a.level":"error","a.user.id":"123","a.msg":"cannot open file","b.level":"error","b.user.id":"123","b.msg":"cannot open file"}`)
+
+	// parse JSON from non-JSON field leaves the row as is
+	f(`
+- action: parse_json
+  field: host
+- action: parse_json
+  field: missing
+`, fields, `{"_msg":"{\"level\":\"error\",\"user\":{\"id\":\"123\"},\"msg\":\"cannot open file\"}","host":"host1","password":"secret"}`)
+
+	// add fields
+	f(`
+- action: add_fields
+  values:
+    host: host2
+    env: prod
+- action: drop_fields
+  fields: [_msg, password]
+`, fields, `{"env":"prod","host":"host2"}`)
+
+	// rules with if filters
+	f(`
+- action: add_fields
+  if: 'host:host2'
+  values:
+    env: dev
+- action: add_fields
+  if: 'host:host1'
+  values:
+    env: prod
+- action: drop_fields
+  if: 'env:prod'
+  fields: [_msg, password]
+`, fields, `{"host":"host1","env":"prod"}`)
+}
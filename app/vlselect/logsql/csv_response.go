@@ -0,0 +1,202 @@
+package logsql
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+// csvResponseWriter writes query results in CSV or TSV format.
+//
+// The header contains the explicitly passed column names. Otherwise it is derived from the columns of the first written block.
+// Columns missing in the header are skipped, while header columns missing in blocks are written as empty values.
+//
+// csvResponseWriter implements queryResponseWriter interface.
+type csvResponseWriter struct {
+	// delimiter is the delimiter between values in a row - ',' for CSV and '\t' for TSV.
+	delimiter byte
+
+	mu sync.Mutex
+
+	// columnNames contains column names for the header.
+	//
+	// It is nil until the header is written if column names aren't passed to newCSVResponseWriter.
+	columnNames []string
+
+	headerWritten bool
+}
+
+// newCSVResponseWriter returns a writer, which uses the given delimiter between values - ',' for CSV and '\t' for TSV.
+//
+// If columnNames is nil, then the header is derived from the written columns.
+func newCSVResponseWriter(delimiter byte, columnNames []string) *csvResponseWriter {
+	return &csvResponseWriter{
+		delimiter:   delimiter,
+		columnNames: columnNames,
+	}
+}
+
+// contentType implements queryResponseWriter interface.
+func (cw *csvResponseWriter) contentType() string {
+	if cw.delimiter == '\t' {
+		return "text/tab-separated-values; charset=utf-8"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// initHeader writes the header to bw if it isn't written yet.
+//
+// The given columnNames are used for the header if column names weren't passed to newCSVResponseWriter.
+// It returns column names from the header.
+func (cw *csvResponseWriter) initHeader(bw *bufferedWriter, columnNames []string) []string {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	if !cw.headerWritten {
+		if cw.columnNames == nil {
+			cw.columnNames = append([]string{}, columnNames...)
+		}
+		cw.headerWritten = true
+
+		bb := blockResultPool.Get()
+		for i, name := range cw.columnNames {
+			if i > 0 {
+				bb.B = append(bb.B, cw.delimiter)
+			}
+			bb.B = cw.marshalValue(bb.B, name)
+		}
+		bb.B = append(bb.B, '\n')
+		bw.WriteIgnoreErrors(bb.B)
+		blockResultPool.Put(bb)
+	}
+	return cw.columnNames
+}
+
+// writeBlock implements queryResponseWriter interface.
+func (cw *csvResponseWriter) writeBlock(bw *bufferedWriter, columns []logstorage.BlockColumn, rowsCount int) {
+	columnNames := make([]string, len(columns))
+	for i := range columns {
+		columnNames[i] = columns[i].Name
+	}
+	columnNames = cw.initHeader(bw, columnNames)
+
+	// Map header columns to block columns.
+	valuesByColumn := make([][]string, len(columnNames))
+	for i, name := range columnNames {
+		for j := range columns {
+			if columns[j].Name == name {
+				valuesByColumn[i] = columns[j].Values
+				break
+			}
+		}
+	}
+
+	bb := blockResultPool.Get()
+	for rowIdx := 0; rowIdx < rowsCount; rowIdx++ {
+		for i, values := range valuesByColumn {
+			if i > 0 {
+				bb.B = append(bb.B, cw.delimiter)
+			}
+			if values != nil {
+				bb.B = cw.marshalValue(bb.B, values[rowIdx])
+			}
+		}
+		bb.B = append(bb.B, '\n')
+	}
+	bw.WriteIgnoreErrors(bb.B)
+	blockResultPool.Put(bb)
+}
+
+// writeRows implements queryResponseWriter interface.
+//
+// The header contains all the field names seen in rows in the order of their first appearance.
+func (cw *csvResponseWriter) writeRows(bw *bufferedWriter, rows []row) {
+	var columnNames []string
+	seen := make(map[string]struct{})
+	for i := range rows {
+		for _, f := range rows[i].fields {
+			if _, ok := seen[f.Name]; !ok {
+				seen[f.Name] = struct{}{}
+				columnNames = append(columnNames, f.Name)
+			}
+		}
+	}
+	if len(columnNames) == 0 {
+		return
+	}
+	columnNames = cw.initHeader(bw, columnNames)
+
+	bb := blockResultPool.Get()
+	for i := range rows {
+		fields := rows[i].fields
+		for j, name := range columnNames {
+			if j > 0 {
+				bb.B = append(bb.B, cw.delimiter)
+			}
+			for _, f := range fields {
+				if f.Name == name {
+					bb.B = cw.marshalValue(bb.B, f.Value)
+					break
+				}
+			}
+		}
+		bb.B = append(bb.B, '\n')
+	}
+	bw.WriteIgnoreErrors(bb.B)
+	blockResultPool.Put(bb)
+}
+
+// close implements queryResponseWriter interface.
+func (cw *csvResponseWriter) close(_ *bufferedWriter) error {
+	return nil
+}
+
+func (cw *csvResponseWriter) marshalValue(dst []byte, v string) []byte {
+	if cw.delimiter == '\t' {
+		return marshalTSVValue(dst, v)
+	}
+	return marshalCSVValue(dst, v)
+}
+
+// marshalCSVValue appends v to dst according to RFC 4180.
+//
+// The value is enclosed into double quotes if it contains commas, double quotes or line breaks.
+func marshalCSVValue(dst []byte, v string) []byte {
+	if !strings.ContainsAny(v, ",\"\r\n") {
+		return append(dst, v...)
+	}
+	dst = append(dst, '"')
+	for {
+		n := strings.IndexByte(v, '"')
+		if n < 0 {
+			break
+		}
+		dst = append(dst, v[:n+1]...)
+		dst = append(dst, '"')
+		v = v[n+1:]
+	}
+	dst = append(dst, v...)
+	return append(dst, '"')
+}
+
+// marshalTSVValue appends v to dst with escaped tabs, line breaks and backslashes.
+//
+// See https://en.wikipedia.org/wiki/Tab-separated_values#Conventions_for_lossless_conversion_to_TSV
+func marshalTSVValue(dst []byte, v string) []byte {
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; c {
+		case '\t':
+			dst = append(dst, `\t`...)
+		case '\n':
+			dst = append(dst, `\n`...)
+		case '\r':
+			dst = append(dst, `\r`...)
+		case '\\':
+			dst = append(dst, `\\`...)
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
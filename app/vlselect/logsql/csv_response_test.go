@@ -0,0 +1,136 @@
+package logsql
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+func TestMarshalCSVValue(t *testing.T) {
+	f := func(v, resultExpected string) {
+		t.Helper()
+
+		result := marshalCSVValue(nil, v)
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected result for %q\ngot\n%s\nwant\n%s", v, result, resultExpected)
+		}
+	}
+
+	f(``, ``)
+	f(`foo`, `foo`)
+	f(`foo bar`, `foo bar`)
+	f("foo\tbar", "foo\tbar")
+	f(`foo,bar`, `"foo,bar"`)
+	f(`"foo"`, `"""foo"""`)
+	f(`a "b" c`, `"a ""b"" c"`)
+	f("foo\nbar", "\"foo\nbar\"")
+	f("foo\r\nbar", "\"foo\r\nbar\"")
+}
+
+func TestMarshalTSVValue(t *testing.T) {
+	f := func(v, resultExpected string) {
+		t.Helper()
+
+		result := marshalTSVValue(nil, v)
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected result for %q\ngot\n%s\nwant\n%s", v, result, resultExpected)
+		}
+	}
+
+	f(``, ``)
+	f(`foo`, `foo`)
+	f(`foo,"bar"`, `foo,"bar"`)
+	f("foo\tbar", `foo\tbar`)
+	f("foo\r\nbar", `foo\r\nbar`)
+	f(`foo\bar`, `foo\\bar`)
+}
+
+func TestCSVResponseWriterWriteBlock(t *testing.T) {
+	f := func(format string, columnNames []string, blocks [][]logstorage.BlockColumn, resultExpected string) {
+		t.Helper()
+
+		rw, err := newQueryResponseWriter(format, columnNames)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		var buf bytes.Buffer
+		bw := getBufferedWriter(&buf)
+		for _, columns := range blocks {
+			rw.writeBlock(bw, columns, len(columns[0].Values))
+		}
+		if err := rw.close(bw); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		bw.FlushIgnoreErrors()
+		putBufferedWriter(bw)
+
+		if result := buf.String(); result != resultExpected {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	blocks := [][]logstorage.BlockColumn{
+		{
+			{Name: "_time", Values: []string{"2024-05-31T10:20:30Z", "2024-05-31T10:20:31Z"}},
+			{Name: "_msg", Values: []string{"foo, bar", "tab\there"}},
+			{Name: "level", Values: []string{"info", ""}},
+		},
+		{
+			{Name: "level", Values: []string{"error"}},
+			{Name: "_time", Values: []string{"2024-05-31T10:20:32Z"}},
+			{Name: "extra", Values: []string{"skipped"}},
+		},
+	}
+
+	f("csv", nil, blocks, `_time,_msg,level
+2024-05-31T10:20:30Z,"foo, bar",info
+2024-05-31T10:20:31Z,tab	here,
+2024-05-31T10:20:32Z,,error
+`)
+	f("tsv", nil, blocks, "_time\t_msg\tlevel\n"+
+		"2024-05-31T10:20:30Z\tfoo, bar\tinfo\n"+
+		"2024-05-31T10:20:31Z\ttab\\there\t\n"+
+		"2024-05-31T10:20:32Z\t\terror\n")
+
+	// explicit column names
+	f("csv", []string{"level", "extra", "_time"}, blocks, `level,extra,_time
+info,,2024-05-31T10:20:30Z
+,,2024-05-31T10:20:31Z
+error,skipped,2024-05-31T10:20:32Z
+`)
+}
+
+func TestCSVResponseWriterWriteRows(t *testing.T) {
+	cw := newCSVResponseWriter(',', nil)
+
+	rows := []row{
+		{
+			fields: []logstorage.Field{
+				{Name: "_time", Value: "2024-05-31T10:20:30Z"},
+				{Name: "_msg", Value: `say "hi"`},
+			},
+		},
+		{
+			fields: []logstorage.Field{
+				{Name: "_time", Value: "2024-05-31T10:20:31Z"},
+				{Name: "host", Value: "host1"},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	bw := getBufferedWriter(&buf)
+	cw.writeRows(bw, rows)
+	bw.FlushIgnoreErrors()
+	putBufferedWriter(bw)
+
+	resultExpected := `_time,_msg,host
+2024-05-31T10:20:30Z,"say ""hi""",
+2024-05-31T10:20:31Z,,host1
+`
+	if result := buf.String(); result != resultExpected {
+		t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
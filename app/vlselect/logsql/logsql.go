@@ -2,6 +2,8 @@ package logsql
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"math"
 	"net/http"
@@ -15,13 +17,34 @@ import (
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlstorage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httputils"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promutils"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/querytracer"
 )
 
+var maxMemoryPerQuery = flagutil.NewBytes("search.maxMemoryPerQuery", 0, "The maximum memory, which can be used by pipes such as stats, sort and uniq "+
+	"during a single query execution. The query fails if it needs more memory. By default 30% of the allowed memory is used (see -memory.allowedPercent and -memory.allowedBytes). "+
+	"It can be lowered on a per-query basis via 'max_memory' query arg")
+
+var (
+	maxScannedRowsPerQuery = flag.Int("search.maxScannedRowsPerQuery", 0, "The maximum number of rows, which can be scanned during a single query execution. "+
+		"The query fails if it needs to scan more rows. This protects from accidental heavy queries such as '*' over long time ranges. "+
+		"There is no limit by default. It can be lowered on a per-query basis via 'max_scanned_rows' query arg; "+
+		"see https://docs.victoriametrics.com/victorialogs/querying/#query-scan-limits")
+	maxScannedBytesPerQuery = flagutil.NewBytes("search.maxScannedBytesPerQuery", 0, "The maximum number of compressed bytes, which can be read "+
+		"from the storage during a single query execution. The query fails if it needs to read more bytes. "+
+		"There is no limit by default. It can be lowered on a per-query basis via 'max_scanned_bytes' query arg; "+
+		"see https://docs.victoriametrics.com/victorialogs/querying/#query-scan-limits")
+)
+
+var dedupReplicas = flag.Bool("search.dedupReplicas", false, "Whether to deduplicate logs with identical _stream_id, _time and _msg returned from -storageNode addresses. "+
+	"This is needed if the same logs are stored at multiple storage nodes. It can be overridden on a per-query basis via 'dedup_replicas' query arg; "+
+	"see https://docs.victoriametrics.com/victorialogs/querying/#deduplication-of-replicas")
+
 // ProcessHitsRequest handles /select/logsql/hits request.
 //
 // See https://docs.victoriametrics.com/victorialogs/querying/#querying-hits-stats
@@ -44,6 +67,7 @@ func ProcessHitsRequest(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	}
 	if step <= 0 {
 		httpserver.Errorf(w, r, "'step' must be bigger than zero")
+		return
 	}
 
 	// Obtain offset
@@ -254,6 +278,124 @@ func ProcessFieldValuesRequest(ctx context.Context, w http.ResponseWriter, r *ht
 	WriteValuesWithHitsJSON(w, values)
 }
 
+// ProcessFacetsRequest processes /select/logsql/facets request.
+//
+// See https://docs.victoriametrics.com/victorialogs/querying/#querying-facets
+func ProcessFacetsRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	q, tenantIDs, err := parseCommonArgs(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	// Obtain the fields to return facets for. Facets for all the fields are returned if fields are missing.
+	fields := r.Form["field"]
+
+	// Obtain limits
+	limit, err := getPositiveIntArg(r, "limit")
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	maxValuesPerField, err := getPositiveIntArg(r, "max_values_per_field")
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	maxValueLen, err := getPositiveIntArg(r, "max_value_len")
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	keepConstFields := httputils.GetBool(r, "keep_const_fields")
+
+	// Prepare the query for facets calculation.
+	q.AddFacetsPipe(fields, uint64(limit), uint64(maxValuesPerField), uint64(maxValueLen), keepConstFields)
+	q.Optimize()
+
+	var mLock sync.Mutex
+	m := make(map[string][]logstorage.ValueWithHits)
+	writeBlock := func(_ uint, _ []int64, columns []logstorage.BlockColumn) {
+		if len(columns) == 0 || len(columns[0].Values) == 0 {
+			return
+		}
+		if len(columns) != 3 {
+			logger.Panicf("BUG: expecting 3 columns; got %d columns", len(columns))
+		}
+
+		fieldNames := columns[0].Values
+		fieldValues := columns[1].Values
+		hitsValues := columns[2].Values
+
+		mLock.Lock()
+		for i := range fieldNames {
+			hits, err := strconv.ParseUint(hitsValues[i], 10, 64)
+			if err != nil {
+				logger.Panicf("BUG: cannot parse hits=%q: %s", hitsValues[i], err)
+			}
+			fieldName := strings.Clone(fieldNames[i])
+			m[fieldName] = append(m[fieldName], logstorage.ValueWithHits{
+				Value: strings.Clone(fieldValues[i]),
+				Hits:  hits,
+			})
+		}
+		mLock.Unlock()
+	}
+
+	// Execute the query
+	if err := vlstorage.RunQuery(ctx, tenantIDs, q, writeBlock); err != nil {
+		httpserver.Errorf(w, r, "cannot execute query [%s]: %s", q, err)
+		return
+	}
+
+	facets := make([]facetsField, 0, len(m))
+	for fieldName, values := range m {
+		facets = append(facets, facetsField{
+			name:   fieldName,
+			values: values,
+		})
+	}
+	sortFacetsFields(facets)
+
+	// Write response
+	w.Header().Set("Content-Type", "application/json")
+	WriteFacetsJSON(w, facets)
+}
+
+// facetsField contains the most frequent values for the given field returned from /select/logsql/facets.
+type facetsField struct {
+	name   string
+	values []logstorage.ValueWithHits
+}
+
+// sortFacetsFields sorts facets by field name, while values for every field are sorted by hits in descending order.
+func sortFacetsFields(facets []facetsField) {
+	sort.Slice(facets, func(i, j int) bool {
+		return facets[i].name < facets[j].name
+	})
+	for _, ff := range facets {
+		values := ff.values
+		sort.Slice(values, func(i, j int) bool {
+			a, b := &values[i], &values[j]
+			if a.Hits != b.Hits {
+				return a.Hits > b.Hits
+			}
+			return a.Value < b.Value
+		})
+	}
+}
+
+func getPositiveIntArg(r *http.Request, argName string) (int, error) {
+	n, err := httputils.GetInt(r, argName)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("%q arg cannot be negative; got %d", argName, n)
+	}
+	return n, nil
+}
+
 // ProcessStreamFieldNamesRequest processes /select/logsql/stream_field_names request.
 //
 // See https://docs.victoriametrics.com/victorialogs/querying/#querying-stream-field-names
@@ -379,6 +521,92 @@ func ProcessStreamsRequest(ctx context.Context, w http.ResponseWriter, r *http.R
 	WriteValuesWithHitsJSON(w, streams)
 }
 
+// ProcessQueryPlanRequest processes /select/logsql/query_plan request.
+//
+// See https://docs.victoriametrics.com/victorialogs/querying/#querying-query-plan
+func ProcessQueryPlanRequest(_ context.Context, w http.ResponseWriter, r *http.Request) {
+	q, _, err := parseCommonArgs(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	// Obtain the plan for the optimized query without executing it
+	q.Optimize()
+	qp := q.GetQueryPlan()
+
+	// Write results
+	w.Header().Set("Content-Type", "application/json")
+	WriteQueryPlanJSON(w, qp)
+}
+
+// ProcessCompleteRequest handles /select/logsql/complete request.
+//
+// It returns suggestions for the token at the given cursor position in the (possibly incomplete) query.
+//
+// See https://docs.victoriametrics.com/victorialogs/querying/#query-auto-completion
+func ProcessCompleteRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	// Extract tenantID
+	tenantID, err := logstorage.GetTenantIDFromRequest(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot obtain tenanID: %s", err)
+		return
+	}
+	tenantIDs := []logstorage.TenantID{tenantID}
+
+	// Parse query and cursor args. The query isn't parsed, since it may be incomplete.
+	qStr := r.FormValue("query")
+	cursorPos := len(qStr)
+	if r.FormValue("cursor") != "" {
+		n, err := httputils.GetInt(r, "cursor")
+		if err != nil {
+			httpserver.Errorf(w, r, "%s", err)
+			return
+		}
+		cursorPos = n
+	}
+
+	// Parse optional start and end args. Field names are obtained for the last hour by default.
+	end, okEnd, err := getTimeNsec(r, "end")
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	if !okEnd {
+		end = time.Now().UnixNano()
+	}
+	start, okStart, err := getTimeNsec(r, "start")
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	if !okStart {
+		start = end - time.Hour.Nanoseconds()
+	}
+
+	// Obtain known field names
+	q, err := logstorage.ParseQuery("*")
+	if err != nil {
+		logger.Panicf("BUG: cannot parse query [*]: %s", err)
+	}
+	q.AddTimeFilter(start, end)
+	fieldNamesWithHits, err := vlstorage.GetFieldNames(ctx, tenantIDs, q)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot obtain field names: %s", err)
+		return
+	}
+	fieldNames := make([]string, len(fieldNamesWithHits))
+	for i, v := range fieldNamesWithHits {
+		fieldNames[i] = v.Value
+	}
+
+	cs := logstorage.Complete(qStr, cursorPos, fieldNames)
+
+	// Write results
+	w.Header().Set("Content-Type", "application/json")
+	WriteCompletionsJSON(w, cs)
+}
+
 // ProcessLiveTailRequest processes live tailing request to /select/logsq/tail
 func ProcessLiveTailRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	liveTailRequests.Inc()
@@ -391,6 +619,7 @@ func ProcessLiveTailRequest(ctx context.Context, w http.ResponseWriter, r *http.
 	}
 	if !q.CanLiveTail() {
 		httpserver.Errorf(w, r, "the query [%s] cannot be used in live tailing; see https://docs.victoriametrics.com/victorialogs/querying/#live-tailing for details", q)
+		return
 	}
 	q.Optimize()
 
@@ -401,6 +630,23 @@ func ProcessLiveTailRequest(ctx context.Context, w http.ResponseWriter, r *http.
 	}
 	refreshInterval := time.Millisecond * time.Duration(refreshIntervalMsecs)
 
+	// start_offset allows returning logs ingested before the live tailing has been started,
+	// e.g. after reconnecting to the server.
+	startOffsetMsecs, err := httputils.GetDuration(r, "start_offset", tailOffsetNsecs/1e6)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	startOffset := startOffsetMsecs * 1e6
+
+	// offset is the time window for logs ingested with delays.
+	offsetMsecs, err := httputils.GetDuration(r, "offset", tailOffsetNsecs/1e6)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	offset := offsetMsecs * 1e6
+
 	ctxWithCancel, cancel := context.WithCancel(ctx)
 	tp := newTailProcessor(cancel)
 
@@ -408,15 +654,14 @@ func ProcessLiveTailRequest(ctx context.Context, w http.ResponseWriter, r *http.
 	defer ticker.Stop()
 
 	end := time.Now().UnixNano()
+	start := end - startOffset
 	doneCh := ctxWithCancel.Done()
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		logger.Panicf("BUG: it is expected that http.ResponseWriter (%T) supports http.Flusher interface", w)
 	}
+	w.Header().Set("Content-Type", "application/stream+json")
 	for {
-		start := end - tailOffsetNsecs
-		end = time.Now().UnixNano()
-
 		qCopy := q.Clone()
 		qCopy.AddTimeFilter(start, end)
 		if err := vlstorage.RunQuery(ctxWithCancel, tenantIDs, qCopy, tp.writeBlock); err != nil {
@@ -437,6 +682,8 @@ func ProcessLiveTailRequest(ctx context.Context, w http.ResponseWriter, r *http.
 		case <-doneCh:
 			return
 		case <-ticker.C:
+			start = end - offset
+			end = time.Now().UnixNano()
 		}
 	}
 }
@@ -564,11 +811,15 @@ func (tp *tailProcessor) getTailRows() ([][]logstorage.Field, error) {
 //
 // See https://docs.victoriametrics.com/victorialogs/querying/#http-api
 func ProcessQueryRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	qt := querytracer.New(httputils.GetBool(r, "trace"), "/select/logsql/query: query=%s", r.FormValue("query"))
+
 	q, tenantIDs, err := parseCommonArgs(r)
 	if err != nil {
 		httpserver.Errorf(w, r, "%s", err)
 		return
 	}
+	qt.Printf("parse query args")
+	q.SetTracer(qt)
 
 	// Parse limit query arg
 	limit, err := httputils.GetInt(r, "limit")
@@ -577,12 +828,32 @@ func ProcessQueryRequest(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	// Parse format query arg
+	var rw queryResponseWriter
+	if format := r.FormValue("format"); format != "" && format != "json" {
+		// Write columns in the order they are listed in the `fields` pipe if the query ends with it.
+		columnNames, _ := q.GetFieldsPipeFields()
+		rw, err = newQueryResponseWriter(format, columnNames)
+		if err != nil {
+			httpserver.Errorf(w, r, "%s", err)
+			return
+		}
+		if qt.Enabled() {
+			httpserver.Errorf(w, r, "trace query arg is supported only for the default JSON response format; got format=%q", format)
+			return
+		}
+	}
+
 	bw := getBufferedWriter(w)
 	defer func() {
 		bw.FlushIgnoreErrors()
 		putBufferedWriter(bw)
 	}()
-	w.Header().Set("Content-Type", "application/stream+json")
+	if rw != nil {
+		w.Header().Set("Content-Type", rw.contentType())
+	} else {
+		w.Header().Set("Content-Type", "application/stream+json")
+	}
 
 	if limit > 0 {
 		if q.CanReturnLastNResults() {
@@ -591,6 +862,13 @@ func ProcessQueryRequest(ctx context.Context, w http.ResponseWriter, r *http.Req
 				httpserver.Errorf(w, r, "%s", err)
 				return
 			}
+			if rw != nil {
+				rw.writeRows(bw, rows)
+				if err := rw.close(bw); err != nil {
+					httpserver.Errorf(w, r, "%s", err)
+				}
+				return
+			}
 			bb := blockResultPool.Get()
 			b := bb.B
 			for i := range rows {
@@ -600,6 +878,7 @@ func ProcessQueryRequest(ctx context.Context, w http.ResponseWriter, r *http.Req
 			}
 			bb.B = b
 			blockResultPool.Put(bb)
+			writeQueryTrace(bw, qt)
 			return
 		}
 
@@ -611,6 +890,10 @@ func ProcessQueryRequest(ctx context.Context, w http.ResponseWriter, r *http.Req
 		if len(columns) == 0 || len(columns[0].Values) == 0 {
 			return
 		}
+		if rw != nil {
+			rw.writeBlock(bw, columns, len(timestamps))
+			return
+		}
 
 		bb := blockResultPool.Get()
 		for i := range timestamps {
@@ -624,6 +907,24 @@ func ProcessQueryRequest(ctx context.Context, w http.ResponseWriter, r *http.Req
 		httpserver.Errorf(w, r, "cannot execute query [%s]: %s", q, err)
 		return
 	}
+	if rw != nil {
+		if err := rw.close(bw); err != nil {
+			httpserver.Errorf(w, r, "%s", err)
+		}
+		return
+	}
+	writeQueryTrace(bw, qt)
+}
+
+// writeQueryTrace finishes qt and writes it to bw as the last line of the JSON lines response if the query tracing is enabled.
+//
+// See https://docs.victoriametrics.com/victorialogs/querying/#query-tracing
+func writeQueryTrace(bw *bufferedWriter, qt *querytracer.Tracer) {
+	if !qt.Enabled() {
+		return
+	}
+	qt.Done()
+	bw.WriteIgnoreErrors([]byte(`{"trace":` + qt.ToJSON() + "}\n"))
 }
 
 var blockResultPool bytesutil.ByteBufferPool
@@ -732,6 +1033,10 @@ func parseCommonArgs(r *http.Request) (*logstorage.Query, []logstorage.TenantID,
 	qStr := r.FormValue("query")
 	q, err := logstorage.ParseQuery(qStr)
 	if err != nil {
+		var pe *logstorage.ParseError
+		if errors.As(err, &pe) {
+			return nil, nil, fmt.Errorf("cannot parse query [%s] at position %d: %s\n%s", qStr, pe.Pos, pe, pe.Snippet())
+		}
 		return nil, nil, fmt.Errorf("cannot parse query [%s]: %s", qStr, err)
 	}
 
@@ -754,9 +1059,98 @@ func parseCommonArgs(r *http.Request) (*logstorage.Query, []logstorage.TenantID,
 		q.AddTimeFilter(start, end)
 	}
 
+	// Parse optional max_memory arg
+	maxMemory, err := getMaxQueryMemory(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	q.SetMaxMemory(maxMemory)
+
+	// Parse optional max_scanned_rows and max_scanned_bytes args
+	maxScannedRows, maxScannedBytes, err := getMaxQueryScan(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	q.SetMaxScannedRows(maxScannedRows)
+	q.SetMaxScannedBytes(maxScannedBytes)
+
+	// Parse optional dedup_replicas arg
+	dedup, err := getDedupReplicas(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	q.SetDedupReplicas(dedup)
+
 	return q, tenantIDs, nil
 }
 
+// getDedupReplicas returns whether to deduplicate logs returned from -storageNode replicas for the query from r.
+//
+// The -search.dedupReplicas is used if the 'dedup_replicas' query arg is missing.
+func getDedupReplicas(r *http.Request) (bool, error) {
+	s := r.FormValue("dedup_replicas")
+	if s == "" {
+		return *dedupReplicas, nil
+	}
+	dedup, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("cannot parse dedup_replicas=%q: %w", s, err)
+	}
+	return dedup, nil
+}
+
+// getMaxQueryScan returns the maximum number of scanned rows and the maximum number of read bytes for the query from r.
+//
+// Zero limit means there is no limit.
+func getMaxQueryScan(r *http.Request) (uint64, uint64, error) {
+	maxRows, err := httputils.GetInt(r, "max_scanned_rows")
+	if err != nil {
+		return 0, 0, err
+	}
+	var maxBytes int64
+	if s := r.FormValue("max_scanned_bytes"); s != "" {
+		maxBytes, err = flagutil.ParseBytes(s)
+		if err != nil {
+			return 0, 0, fmt.Errorf("cannot parse max_scanned_bytes=%q: %w", s, err)
+		}
+	}
+
+	// The per-query limits cannot exceed -search.maxScannedRowsPerQuery and -search.maxScannedBytesPerQuery
+	return getScanLimit(int64(*maxScannedRowsPerQuery), int64(maxRows)), getScanLimit(maxScannedBytesPerQuery.N, maxBytes), nil
+}
+
+func getScanLimit(maxLimit, n int64) uint64 {
+	if maxLimit < 0 {
+		maxLimit = 0
+	}
+	if n <= 0 || (maxLimit > 0 && n > maxLimit) {
+		n = maxLimit
+	}
+	return uint64(n)
+}
+
+// getMaxQueryMemory returns the maximum memory in bytes for the query pipes from r.
+func getMaxQueryMemory(r *http.Request) (int64, error) {
+	maxMemory := maxMemoryPerQuery.N
+	if maxMemory <= 0 {
+		maxMemory = logstorage.GetDefaultMaxQueryMemory()
+	}
+
+	s := r.FormValue("max_memory")
+	if s == "" {
+		return maxMemory, nil
+	}
+	n, err := flagutil.ParseBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse max_memory=%q: %w", s, err)
+	}
+	if n <= 0 || n > maxMemory {
+		// The per-query limit cannot exceed -search.maxMemoryPerQuery
+		n = maxMemory
+	}
+	return n, nil
+}
+
 func getTimeNsec(r *http.Request, argName string) (int64, bool, error) {
 	s := r.FormValue(argName)
 	if s == "" {
@@ -150,3 +150,225 @@ func valueWithHitsJSON(v logstorage.ValueWithHits) string {
 	return qs422016
 //line app/vlselect/logsql/logsql.qtpl:30
 }
+
+// FacetsJSON generates JSON from the given facets.
+
+//line app/vlselect/logsql/logsql.qtpl:33
+func StreamFacetsJSON(qw422016 *qt422016.Writer, facets []facetsField) {
+//line app/vlselect/logsql/logsql.qtpl:33
+	qw422016.N().S(`{"facets":[`)
+//line app/vlselect/logsql/logsql.qtpl:36
+	for i, ff := range facets {
+//line app/vlselect/logsql/logsql.qtpl:37
+		if i > 0 {
+//line app/vlselect/logsql/logsql.qtpl:37
+			qw422016.N().S(`,`)
+//line app/vlselect/logsql/logsql.qtpl:37
+		}
+//line app/vlselect/logsql/logsql.qtpl:37
+		qw422016.N().S(`{"field_name":`)
+//line app/vlselect/logsql/logsql.qtpl:39
+		qw422016.N().Q(ff.name)
+//line app/vlselect/logsql/logsql.qtpl:39
+		qw422016.N().S(`,"values":`)
+//line app/vlselect/logsql/logsql.qtpl:40
+		streamvaluesWithHitsJSONArray(qw422016, ff.values)
+//line app/vlselect/logsql/logsql.qtpl:40
+		qw422016.N().S(`}`)
+//line app/vlselect/logsql/logsql.qtpl:42
+	}
+//line app/vlselect/logsql/logsql.qtpl:42
+	qw422016.N().S(`]}`)
+//line app/vlselect/logsql/logsql.qtpl:45
+}
+
+//line app/vlselect/logsql/logsql.qtpl:45
+func WriteFacetsJSON(qq422016 qtio422016.Writer, facets []facetsField) {
+//line app/vlselect/logsql/logsql.qtpl:45
+	qw422016 := qt422016.AcquireWriter(qq422016)
+//line app/vlselect/logsql/logsql.qtpl:45
+	StreamFacetsJSON(qw422016, facets)
+//line app/vlselect/logsql/logsql.qtpl:45
+	qt422016.ReleaseWriter(qw422016)
+//line app/vlselect/logsql/logsql.qtpl:45
+}
+
+//line app/vlselect/logsql/logsql.qtpl:45
+func FacetsJSON(facets []facetsField) string {
+//line app/vlselect/logsql/logsql.qtpl:45
+	qb422016 := qt422016.AcquireByteBuffer()
+//line app/vlselect/logsql/logsql.qtpl:45
+	WriteFacetsJSON(qb422016, facets)
+//line app/vlselect/logsql/logsql.qtpl:45
+	qs422016 := string(qb422016.B)
+//line app/vlselect/logsql/logsql.qtpl:45
+	qt422016.ReleaseByteBuffer(qb422016)
+//line app/vlselect/logsql/logsql.qtpl:45
+	return qs422016
+//line app/vlselect/logsql/logsql.qtpl:45
+}
+
+// QueryPlanJSON generates JSON from the given qp.
+
+//line app/vlselect/logsql/logsql.qtpl:48
+func StreamQueryPlanJSON(qw422016 *qt422016.Writer, qp *logstorage.QueryPlan) {
+//line app/vlselect/logsql/logsql.qtpl:48
+	qw422016.N().S(`{"filter":`)
+//line app/vlselect/logsql/logsql.qtpl:50
+	qw422016.N().Q(qp.Filter)
+//line app/vlselect/logsql/logsql.qtpl:50
+	qw422016.N().S(`,"pipes":`)
+//line app/vlselect/logsql/logsql.qtpl:51
+	streamstringsJSONArray(qw422016, qp.Pipes)
+//line app/vlselect/logsql/logsql.qtpl:51
+	qw422016.N().S(`,"needed_fields":`)
+//line app/vlselect/logsql/logsql.qtpl:52
+	streamstringsJSONArray(qw422016, qp.NeededFields)
+//line app/vlselect/logsql/logsql.qtpl:52
+	qw422016.N().S(`,"unneeded_fields":`)
+//line app/vlselect/logsql/logsql.qtpl:53
+	streamstringsJSONArray(qw422016, qp.UnneededFields)
+//line app/vlselect/logsql/logsql.qtpl:53
+	qw422016.N().S(`}`)
+//line app/vlselect/logsql/logsql.qtpl:55
+}
+
+//line app/vlselect/logsql/logsql.qtpl:55
+func WriteQueryPlanJSON(qq422016 qtio422016.Writer, qp *logstorage.QueryPlan) {
+//line app/vlselect/logsql/logsql.qtpl:55
+	qw422016 := qt422016.AcquireWriter(qq422016)
+//line app/vlselect/logsql/logsql.qtpl:55
+	StreamQueryPlanJSON(qw422016, qp)
+//line app/vlselect/logsql/logsql.qtpl:55
+	qt422016.ReleaseWriter(qw422016)
+//line app/vlselect/logsql/logsql.qtpl:55
+}
+
+//line app/vlselect/logsql/logsql.qtpl:55
+func QueryPlanJSON(qp *logstorage.QueryPlan) string {
+//line app/vlselect/logsql/logsql.qtpl:55
+	qb422016 := qt422016.AcquireByteBuffer()
+//line app/vlselect/logsql/logsql.qtpl:55
+	WriteQueryPlanJSON(qb422016, qp)
+//line app/vlselect/logsql/logsql.qtpl:55
+	qs422016 := string(qb422016.B)
+//line app/vlselect/logsql/logsql.qtpl:55
+	qt422016.ReleaseByteBuffer(qb422016)
+//line app/vlselect/logsql/logsql.qtpl:55
+	return qs422016
+//line app/vlselect/logsql/logsql.qtpl:55
+}
+
+// CompletionsJSON generates JSON from the given cs.
+
+//line app/vlselect/logsql/logsql.qtpl:58
+func StreamCompletionsJSON(qw422016 *qt422016.Writer, cs *logstorage.Completions) {
+//line app/vlselect/logsql/logsql.qtpl:58
+	qw422016.N().S(`{"start":`)
+//line app/vlselect/logsql/logsql.qtpl:60
+	qw422016.N().D(cs.Start)
+//line app/vlselect/logsql/logsql.qtpl:60
+	qw422016.N().S(`,"end":`)
+//line app/vlselect/logsql/logsql.qtpl:61
+	qw422016.N().D(cs.End)
+//line app/vlselect/logsql/logsql.qtpl:61
+	qw422016.N().S(`,"suggestions":[`)
+//line app/vlselect/logsql/logsql.qtpl:63
+	for i, s := range cs.Suggestions {
+//line app/vlselect/logsql/logsql.qtpl:64
+		if i > 0 {
+//line app/vlselect/logsql/logsql.qtpl:64
+			qw422016.N().S(`,`)
+//line app/vlselect/logsql/logsql.qtpl:64
+		}
+//line app/vlselect/logsql/logsql.qtpl:64
+		qw422016.N().S(`{"value":`)
+//line app/vlselect/logsql/logsql.qtpl:66
+		qw422016.N().Q(s.Value)
+//line app/vlselect/logsql/logsql.qtpl:66
+		qw422016.N().S(`,"kind":`)
+//line app/vlselect/logsql/logsql.qtpl:67
+		qw422016.N().Q(s.Kind)
+//line app/vlselect/logsql/logsql.qtpl:67
+		qw422016.N().S(`}`)
+//line app/vlselect/logsql/logsql.qtpl:69
+	}
+//line app/vlselect/logsql/logsql.qtpl:69
+	qw422016.N().S(`]}`)
+//line app/vlselect/logsql/logsql.qtpl:72
+}
+
+//line app/vlselect/logsql/logsql.qtpl:72
+func WriteCompletionsJSON(qq422016 qtio422016.Writer, cs *logstorage.Completions) {
+//line app/vlselect/logsql/logsql.qtpl:72
+	qw422016 := qt422016.AcquireWriter(qq422016)
+//line app/vlselect/logsql/logsql.qtpl:72
+	StreamCompletionsJSON(qw422016, cs)
+//line app/vlselect/logsql/logsql.qtpl:72
+	qt422016.ReleaseWriter(qw422016)
+//line app/vlselect/logsql/logsql.qtpl:72
+}
+
+//line app/vlselect/logsql/logsql.qtpl:72
+func CompletionsJSON(cs *logstorage.Completions) string {
+//line app/vlselect/logsql/logsql.qtpl:72
+	qb422016 := qt422016.AcquireByteBuffer()
+//line app/vlselect/logsql/logsql.qtpl:72
+	WriteCompletionsJSON(qb422016, cs)
+//line app/vlselect/logsql/logsql.qtpl:72
+	qs422016 := string(qb422016.B)
+//line app/vlselect/logsql/logsql.qtpl:72
+	qt422016.ReleaseByteBuffer(qb422016)
+//line app/vlselect/logsql/logsql.qtpl:72
+	return qs422016
+//line app/vlselect/logsql/logsql.qtpl:72
+}
+
+//line app/vlselect/logsql/logsql.qtpl:74
+func streamstringsJSONArray(qw422016 *qt422016.Writer, a []string) {
+//line app/vlselect/logsql/logsql.qtpl:74
+	qw422016.N().S(`[`)
+//line app/vlselect/logsql/logsql.qtpl:76
+	if len(a) > 0 {
+//line app/vlselect/logsql/logsql.qtpl:77
+		qw422016.N().Q(a[0])
+//line app/vlselect/logsql/logsql.qtpl:78
+		for _, s := range a[1:] {
+//line app/vlselect/logsql/logsql.qtpl:78
+			qw422016.N().S(`,`)
+//line app/vlselect/logsql/logsql.qtpl:79
+			qw422016.N().Q(s)
+//line app/vlselect/logsql/logsql.qtpl:80
+		}
+//line app/vlselect/logsql/logsql.qtpl:81
+	}
+//line app/vlselect/logsql/logsql.qtpl:81
+	qw422016.N().S(`]`)
+//line app/vlselect/logsql/logsql.qtpl:83
+}
+
+//line app/vlselect/logsql/logsql.qtpl:83
+func writestringsJSONArray(qq422016 qtio422016.Writer, a []string) {
+//line app/vlselect/logsql/logsql.qtpl:83
+	qw422016 := qt422016.AcquireWriter(qq422016)
+//line app/vlselect/logsql/logsql.qtpl:83
+	streamstringsJSONArray(qw422016, a)
+//line app/vlselect/logsql/logsql.qtpl:83
+	qt422016.ReleaseWriter(qw422016)
+//line app/vlselect/logsql/logsql.qtpl:83
+}
+
+//line app/vlselect/logsql/logsql.qtpl:83
+func stringsJSONArray(a []string) string {
+//line app/vlselect/logsql/logsql.qtpl:83
+	qb422016 := qt422016.AcquireByteBuffer()
+//line app/vlselect/logsql/logsql.qtpl:83
+	writestringsJSONArray(qb422016, a)
+//line app/vlselect/logsql/logsql.qtpl:83
+	qs422016 := string(qb422016.B)
+//line app/vlselect/logsql/logsql.qtpl:83
+	qt422016.ReleaseByteBuffer(qb422016)
+//line app/vlselect/logsql/logsql.qtpl:83
+	return qs422016
+//line app/vlselect/logsql/logsql.qtpl:83
+}
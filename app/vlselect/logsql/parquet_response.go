@@ -0,0 +1,245 @@
+package logsql
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/slicesutil"
+)
+
+// parquetMaxRowsPerRowGroup is the maximum number of rows per every row group in the returned Parquet file.
+//
+// Rows are buffered in memory until the row group is written to the response, so this value must be limited.
+const parquetMaxRowsPerRowGroup = 64 * 1024
+
+// parquetResponseWriter writes query results in Parquet format.
+//
+// The set of columns is obtained in the same way as for csvResponseWriter, since it cannot change after the first row group is written.
+// The _time column is stored as a timestamp with nanosecond precision, while the rest of columns are stored as strings.
+//
+// parquetResponseWriter implements queryResponseWriter interface.
+type parquetResponseWriter struct {
+	mu sync.Mutex
+
+	// columnNames contains column names for the schema.
+	//
+	// It is nil until the schema is initialized if column names aren't passed to newParquetResponseWriter.
+	columnNames []string
+
+	// w is nil until the schema is initialized.
+	w *parquet.Writer
+
+	// columnIdxs contains the index of the Parquet column for every column at columnNames.
+	columnIdxs []int
+
+	rowsBuf   []parquet.Row
+	valuesBuf []parquet.Value
+
+	err error
+}
+
+// newParquetResponseWriter returns a writer for Parquet format.
+//
+// If columnNames is nil, then the schema is derived from the written columns.
+func newParquetResponseWriter(columnNames []string) *parquetResponseWriter {
+	return &parquetResponseWriter{
+		columnNames: columnNames,
+	}
+}
+
+// contentType implements queryResponseWriter interface.
+func (pw *parquetResponseWriter) contentType() string {
+	return "application/vnd.apache.parquet"
+}
+
+// initWriterLocked initializes the Parquet writer if it isn't initialized yet.
+//
+// The given columnNames are used for the schema if column names weren't passed to newParquetResponseWriter.
+func (pw *parquetResponseWriter) initWriterLocked(bw *bufferedWriter, columnNames []string) {
+	if pw.w != nil {
+		return
+	}
+
+	if pw.columnNames == nil {
+		pw.columnNames = append([]string{}, columnNames...)
+	}
+
+	g := make(parquet.Group, len(pw.columnNames))
+	for _, name := range pw.columnNames {
+		if name == "_time" {
+			g[name] = parquet.Optional(parquet.Timestamp(parquet.Nanosecond))
+		} else {
+			g[name] = parquet.Optional(parquet.String())
+		}
+	}
+	schema := parquet.NewSchema("logs", g)
+
+	// Parquet columns are sorted by name in the schema, so they may be located in different order than pw.columnNames.
+	columnIdxs := make([]int, len(pw.columnNames))
+	for i, name := range pw.columnNames {
+		leaf, ok := schema.Lookup(name)
+		if !ok {
+			logger.Panicf("BUG: cannot find column %q in the Parquet schema", name)
+		}
+		columnIdxs[i] = leaf.ColumnIndex
+	}
+	pw.columnIdxs = columnIdxs
+
+	pw.w = parquet.NewWriter(&parquetOutput{bw: bw}, schema, parquet.Compression(&zstd.Codec{}), parquet.MaxRowsPerRowGroup(parquetMaxRowsPerRowGroup))
+}
+
+// writeBlock implements queryResponseWriter interface.
+func (pw *parquetResponseWriter) writeBlock(bw *bufferedWriter, columns []logstorage.BlockColumn, rowsCount int) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	columnNames := make([]string, len(columns))
+	for i := range columns {
+		columnNames[i] = columns[i].Name
+	}
+	pw.initWriterLocked(bw, columnNames)
+
+	// Map schema columns to block columns.
+	valuesByColumn := make([][]string, len(pw.columnNames))
+	for i, name := range pw.columnNames {
+		for j := range columns {
+			if columns[j].Name == name {
+				valuesByColumn[i] = columns[j].Values
+				break
+			}
+		}
+	}
+
+	rows := pw.rowsBuf[:0]
+	values := pw.valuesBuf[:0]
+	for rowIdx := 0; rowIdx < rowsCount; rowIdx++ {
+		valuesLen := len(values)
+		values = slicesutil.SetLength(values, valuesLen+len(pw.columnNames))
+		row := values[valuesLen:]
+		for i, columnValues := range valuesByColumn {
+			v := ""
+			if columnValues != nil {
+				v = columnValues[rowIdx]
+			}
+			pw.setValue(row, i, v)
+		}
+		rows = append(rows, row)
+	}
+	pw.writeRowsLocked(rows)
+
+	clear(rows)
+	pw.rowsBuf = rows[:0]
+	clear(values)
+	pw.valuesBuf = values[:0]
+}
+
+// writeRows implements queryResponseWriter interface.
+//
+// The schema contains all the field names seen in rows in the order of their first appearance.
+func (pw *parquetResponseWriter) writeRows(bw *bufferedWriter, rows []row) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	var columnNames []string
+	seen := make(map[string]struct{})
+	for i := range rows {
+		for _, f := range rows[i].fields {
+			if _, ok := seen[f.Name]; !ok {
+				seen[f.Name] = struct{}{}
+				columnNames = append(columnNames, f.Name)
+			}
+		}
+	}
+	pw.initWriterLocked(bw, columnNames)
+
+	prs := pw.rowsBuf[:0]
+	values := pw.valuesBuf[:0]
+	for i := range rows {
+		fields := rows[i].fields
+		valuesLen := len(values)
+		values = slicesutil.SetLength(values, valuesLen+len(pw.columnNames))
+		pr := values[valuesLen:]
+		for j, name := range pw.columnNames {
+			v := ""
+			for _, f := range fields {
+				if f.Name == name {
+					v = f.Value
+					break
+				}
+			}
+			pw.setValue(pr, j, v)
+		}
+		prs = append(prs, pr)
+	}
+	pw.writeRowsLocked(prs)
+
+	clear(prs)
+	pw.rowsBuf = prs[:0]
+	clear(values)
+	pw.valuesBuf = values[:0]
+}
+
+// setValue sets the value v for the column with the given index at pw.columnNames in the row.
+//
+// Empty values are stored as nulls, since VictoriaLogs doesn't distinguish between empty and missing fields.
+func (pw *parquetResponseWriter) setValue(row parquet.Row, i int, v string) {
+	columnIdx := pw.columnIdxs[i]
+	if v == "" {
+		row[columnIdx] = parquet.Value{}.Level(0, 0, columnIdx)
+		return
+	}
+	if pw.columnNames[i] == "_time" {
+		nsecs, ok := logstorage.TryParseTimestampRFC3339Nano(v)
+		if !ok {
+			// Store unparsable timestamps as nulls, since the column type cannot be changed.
+			row[columnIdx] = parquet.Value{}.Level(0, 0, columnIdx)
+			return
+		}
+		row[columnIdx] = parquet.Int64Value(nsecs).Level(0, 1, columnIdx)
+		return
+	}
+	row[columnIdx] = parquet.ByteArrayValue(bytesutil.ToUnsafeBytes(v)).Level(0, 1, columnIdx)
+}
+
+func (pw *parquetResponseWriter) writeRowsLocked(rows []parquet.Row) {
+	if pw.err != nil || len(rows) == 0 {
+		return
+	}
+	if _, err := pw.w.WriteRows(rows); err != nil {
+		pw.err = fmt.Errorf("cannot write rows in Parquet format: %w", err)
+	}
+}
+
+// close implements queryResponseWriter interface.
+func (pw *parquetResponseWriter) close(bw *bufferedWriter) error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	// Write valid Parquet file even if there are no results.
+	pw.initWriterLocked(bw, nil)
+
+	if pw.err != nil {
+		return pw.err
+	}
+	if err := pw.w.Close(); err != nil {
+		return fmt.Errorf("cannot finish writing Parquet file: %w", err)
+	}
+	return nil
+}
+
+// parquetOutput writes Parquet data to bw.
+type parquetOutput struct {
+	bw *bufferedWriter
+}
+
+// Write implements io.Writer interface.
+func (po *parquetOutput) Write(p []byte) (int, error) {
+	po.bw.WriteIgnoreErrors(p)
+	return len(p), nil
+}
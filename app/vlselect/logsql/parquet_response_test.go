@@ -0,0 +1,175 @@
+package logsql
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+func TestParquetResponseWriterWriteBlock(t *testing.T) {
+	f := func(columnNames []string, blocks [][]logstorage.BlockColumn, resultExpected string) {
+		t.Helper()
+
+		rw, err := newQueryResponseWriter("parquet", columnNames)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		var buf bytes.Buffer
+		bw := getBufferedWriter(&buf)
+		for _, columns := range blocks {
+			// Copy block values to a temporary buffer and overwrite it after writeBlock call
+			// in order to verify that the writer doesn't hold references to the written values.
+			var b []byte
+			columnsCopy := make([]logstorage.BlockColumn, len(columns))
+			for i, c := range columns {
+				values := make([]string, len(c.Values))
+				for j, v := range c.Values {
+					bLen := len(b)
+					b = append(b, v...)
+					values[j] = bytesutil.ToUnsafeString(b[bLen:])
+				}
+				columnsCopy[i] = logstorage.BlockColumn{
+					Name:   c.Name,
+					Values: values,
+				}
+			}
+			rw.writeBlock(bw, columnsCopy, len(columns[0].Values))
+			for i := range b {
+				b[i] = 'x'
+			}
+		}
+		if err := rw.close(bw); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		bw.FlushIgnoreErrors()
+		putBufferedWriter(bw)
+
+		result, err := readParquetRows(buf.Bytes())
+		if err != nil {
+			t.Fatalf("cannot read the written Parquet file: %s", err)
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	blocks := [][]logstorage.BlockColumn{
+		{
+			{Name: "_time", Values: []string{"2024-05-31T10:20:30Z", "2024-05-31T10:20:31.123456789Z"}},
+			{Name: "_msg", Values: []string{"foo, bar", "baz"}},
+			{Name: "level", Values: []string{"info", ""}},
+		},
+		{
+			{Name: "level", Values: []string{"error"}},
+			{Name: "_time", Values: []string{"invalid timestamp"}},
+			{Name: "extra", Values: []string{"skipped"}},
+		},
+	}
+
+	// The schema is derived from the first block
+	f(nil, blocks, `_msg,_time,level
+"foo, bar",2024-05-31T10:20:30Z,info
+baz,2024-05-31T10:20:31.123456789Z,<null>
+<null>,<null>,error`)
+
+	// explicit column names
+	f([]string{"level", "extra"}, blocks, `extra,level
+<null>,info
+<null>,<null>
+skipped,error`)
+
+	// no blocks
+	f([]string{"foo"}, nil, `foo`)
+}
+
+func TestParquetResponseWriterWriteRows(t *testing.T) {
+	rw := newParquetResponseWriter(nil)
+
+	rows := []row{
+		{
+			fields: []logstorage.Field{
+				{Name: "_time", Value: "2024-05-31T10:20:30Z"},
+				{Name: "_msg", Value: "foo"},
+			},
+		},
+		{
+			fields: []logstorage.Field{
+				{Name: "_time", Value: "2024-05-31T10:20:31Z"},
+				{Name: "host", Value: "host1"},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	bw := getBufferedWriter(&buf)
+	rw.writeRows(bw, rows)
+	if err := rw.close(bw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	bw.FlushIgnoreErrors()
+	putBufferedWriter(bw)
+
+	result, err := readParquetRows(buf.Bytes())
+	if err != nil {
+		t.Fatalf("cannot read the written Parquet file: %s", err)
+	}
+	resultExpected := `_msg,_time,host
+foo,2024-05-31T10:20:30Z,<null>
+<null>,2024-05-31T10:20:31Z,host1`
+	if result != resultExpected {
+		t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+// readParquetRows returns the header and rows from the Parquet file at data formatted as CSV.
+func readParquetRows(data []byte) (string, error) {
+	pf, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+
+	fields := pf.Schema().Fields()
+	var header []string
+	for _, f := range fields {
+		header = append(header, f.Name())
+	}
+	lines := []string{strings.Join(header, ",")}
+
+	r := parquet.NewReader(pf)
+	defer r.Close()
+
+	rows := make([]parquet.Row, 1)
+	for {
+		n, err := r.ReadRows(rows)
+		if n > 0 {
+			var a []string
+			for i, v := range rows[0] {
+				switch {
+				case v.IsNull():
+					a = append(a, "<null>")
+				case fields[i].Name() == "_time":
+					a = append(a, time.Unix(0, v.Int64()).UTC().Format(time.RFC3339Nano))
+				default:
+					a = append(a, string(marshalCSVValue(nil, v.String())))
+				}
+			}
+			lines = append(lines, strings.Join(a, ","))
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return strings.Join(lines, "\n"), nil
+			}
+			return "", fmt.Errorf("cannot read rows: %w", err)
+		}
+	}
+}
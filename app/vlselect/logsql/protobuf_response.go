@@ -0,0 +1,154 @@
+package logsql
+
+import (
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logsqlpb"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/slicesutil"
+)
+
+// protobufResponseWriter writes query results as a stream of protobuf-encoded blocks.
+//
+// Every written block is self-contained, so the set of columns may differ between blocks.
+// If column names are passed to newProtobufResponseWriter, then every block contains exactly these columns.
+// See lib/logsqlpb for details.
+//
+// protobufResponseWriter implements queryResponseWriter interface.
+type protobufResponseWriter struct {
+	columnNames []string
+}
+
+// newProtobufResponseWriter returns a writer for protobuf format.
+//
+// If columnNames is nil, then the written blocks contain all the result columns.
+func newProtobufResponseWriter(columnNames []string) *protobufResponseWriter {
+	return &protobufResponseWriter{
+		columnNames: columnNames,
+	}
+}
+
+// contentType implements queryResponseWriter interface.
+func (pw *protobufResponseWriter) contentType() string {
+	return "application/x-protobuf"
+}
+
+// writeBlock implements queryResponseWriter interface.
+func (pw *protobufResponseWriter) writeBlock(bw *bufferedWriter, columns []logstorage.BlockColumn, rowsCount int) {
+	pb := getProtobufBlock()
+	pb.b.RowsCount = rowsCount
+	if pw.columnNames == nil {
+		for i := range columns {
+			pb.b.AddColumn(columns[i].Name, columns[i].Values)
+		}
+	} else {
+		for _, name := range pw.columnNames {
+			values := pb.getEmptyValues(rowsCount)
+			for i := range columns {
+				if columns[i].Name == name {
+					values = columns[i].Values
+					break
+				}
+			}
+			pb.b.AddColumn(name, values)
+		}
+	}
+	pb.write(bw)
+	putProtobufBlock(pb)
+}
+
+// writeRows implements queryResponseWriter interface.
+//
+// The rows are written in a single block, which contains all the field names seen in rows in the order of their first appearance.
+func (pw *protobufResponseWriter) writeRows(bw *bufferedWriter, rows []row) {
+	if len(rows) == 0 {
+		return
+	}
+
+	columnNames := pw.columnNames
+	if columnNames == nil {
+		seen := make(map[string]struct{})
+		for i := range rows {
+			for _, f := range rows[i].fields {
+				if _, ok := seen[f.Name]; !ok {
+					seen[f.Name] = struct{}{}
+					columnNames = append(columnNames, f.Name)
+				}
+			}
+		}
+	}
+
+	pb := getProtobufBlock()
+	pb.b.RowsCount = len(rows)
+	valuesBuf := pb.valuesBuf[:0]
+	for _, name := range columnNames {
+		valuesBufLen := len(valuesBuf)
+		for i := range rows {
+			v := ""
+			for _, f := range rows[i].fields {
+				if f.Name == name {
+					v = f.Value
+					break
+				}
+			}
+			valuesBuf = append(valuesBuf, v)
+		}
+		pb.b.AddColumn(name, valuesBuf[valuesBufLen:])
+	}
+	pb.valuesBuf = valuesBuf
+	pb.write(bw)
+	putProtobufBlock(pb)
+}
+
+// close implements queryResponseWriter interface.
+func (pw *protobufResponseWriter) close(bw *bufferedWriter) error {
+	bw.WriteIgnoreErrors(logsqlpb.MarshalEndFrame(nil))
+	return nil
+}
+
+type protobufBlock struct {
+	b logsqlpb.Block
+
+	// valuesBuf holds values for columns added to b.
+	valuesBuf []string
+
+	// emptyValues contains empty values for columns missing in the written block.
+	emptyValues []string
+
+	buf []byte
+}
+
+func (pb *protobufBlock) reset() {
+	pb.b.Reset()
+
+	clear(pb.valuesBuf)
+	pb.valuesBuf = pb.valuesBuf[:0]
+
+	pb.buf = pb.buf[:0]
+}
+
+func (pb *protobufBlock) getEmptyValues(rowsCount int) []string {
+	pb.emptyValues = slicesutil.SetLength(pb.emptyValues, rowsCount)
+	return pb.emptyValues
+}
+
+// write writes pb.b to bw in a single call, so frames from concurrently running goroutines do not interleave.
+func (pb *protobufBlock) write(bw *bufferedWriter) {
+	pb.buf = logsqlpb.MarshalFrame(pb.buf[:0], &pb.b)
+	bw.WriteIgnoreErrors(pb.buf)
+}
+
+func getProtobufBlock() *protobufBlock {
+	v := protobufBlockPool.Get()
+	if v == nil {
+		return &protobufBlock{}
+	}
+	return v.(*protobufBlock)
+}
+
+func putProtobufBlock(pb *protobufBlock) {
+	pb.reset()
+	protobufBlockPool.Put(pb)
+}
+
+var protobufBlockPool sync.Pool
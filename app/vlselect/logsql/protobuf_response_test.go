@@ -0,0 +1,134 @@
+package logsql
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logsqlpb"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+func TestProtobufResponseWriterWriteBlock(t *testing.T) {
+	f := func(columnNames []string, blocks [][]logstorage.BlockColumn, resultExpected [][]string) {
+		t.Helper()
+
+		rw, err := newQueryResponseWriter("protobuf", columnNames)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		var buf bytes.Buffer
+		bw := getBufferedWriter(&buf)
+		for _, columns := range blocks {
+			rw.writeBlock(bw, columns, len(columns[0].Values))
+		}
+		if err := rw.close(bw); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		bw.FlushIgnoreErrors()
+		putBufferedWriter(bw)
+
+		result, err := readProtobufRows(buf.Bytes())
+		if err != nil {
+			t.Fatalf("cannot read the written blocks: %s", err)
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result\ngot\n%q\nwant\n%q", result, resultExpected)
+		}
+	}
+
+	blocks := [][]logstorage.BlockColumn{
+		{
+			{Name: "_time", Values: []string{"2024-05-31T10:20:30Z", "2024-05-31T10:20:31Z"}},
+			{Name: "_msg", Values: []string{"foo", "bar"}},
+			{Name: "duration", Values: []string{"12", "34"}},
+		},
+		{
+			{Name: "level", Values: []string{"error"}},
+			{Name: "_time", Values: []string{"2024-05-31T10:20:32Z"}},
+		},
+	}
+
+	// every block contains its own columns
+	f(nil, blocks, [][]string{
+		{"_time=timestamp:2024-05-31T10:20:30Z", "_msg=string:foo", "duration=int64:12"},
+		{"_time=timestamp:2024-05-31T10:20:31Z", "_msg=string:bar", "duration=int64:34"},
+		{"level=string:error", "_time=timestamp:2024-05-31T10:20:32Z"},
+	})
+
+	// explicit column names
+	f([]string{"level", "duration"}, blocks, [][]string{
+		{"level=string:", "duration=int64:12"},
+		{"level=string:", "duration=int64:34"},
+		{"level=string:error", "duration=string:"},
+	})
+
+	// no blocks
+	f(nil, nil, nil)
+}
+
+func TestProtobufResponseWriterWriteRows(t *testing.T) {
+	rw := newProtobufResponseWriter(nil)
+
+	rows := []row{
+		{
+			fields: []logstorage.Field{
+				{Name: "_time", Value: "2024-05-31T10:20:30Z"},
+				{Name: "_msg", Value: "foo"},
+			},
+		},
+		{
+			fields: []logstorage.Field{
+				{Name: "_time", Value: "2024-05-31T10:20:31Z"},
+				{Name: "host", Value: "host1"},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	bw := getBufferedWriter(&buf)
+	rw.writeRows(bw, rows)
+	if err := rw.close(bw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	bw.FlushIgnoreErrors()
+	putBufferedWriter(bw)
+
+	result, err := readProtobufRows(buf.Bytes())
+	if err != nil {
+		t.Fatalf("cannot read the written blocks: %s", err)
+	}
+	resultExpected := [][]string{
+		{"_time=timestamp:2024-05-31T10:20:30Z", "_msg=string:foo", "host=string:"},
+		{"_time=timestamp:2024-05-31T10:20:31Z", "_msg=string:", "host=string:host1"},
+	}
+	if !reflect.DeepEqual(result, resultExpected) {
+		t.Fatalf("unexpected result\ngot\n%q\nwant\n%q", result, resultExpected)
+	}
+}
+
+// readProtobufRows returns rows from the protobuf stream at data in the form `name=type:value`.
+func readProtobufRows(data []byte) ([][]string, error) {
+	var rows [][]string
+	r := logsqlpb.NewReader(bytes.NewReader(data))
+	for {
+		b, err := r.ReadBlock()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return rows, nil
+			}
+			return nil, err
+		}
+		for i := 0; i < b.RowsCount; i++ {
+			var row []string
+			for j := range b.Columns {
+				c := &b.Columns[j]
+				row = append(row, c.Name+"="+c.Type.String()+":"+c.Value(i))
+			}
+			rows = append(rows, row)
+		}
+	}
+}
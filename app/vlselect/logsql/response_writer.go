@@ -0,0 +1,42 @@
+package logsql
+
+import (
+	"fmt"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+// queryResponseWriter writes /select/logsql/query results in the format specified via `format` query arg.
+//
+// All the methods of queryResponseWriter may be called from concurrently running goroutines.
+type queryResponseWriter interface {
+	// contentType must return Content-Type header value for the response.
+	contentType() string
+
+	// writeBlock must write rowsCount rows from the given columns to bw.
+	writeBlock(bw *bufferedWriter, columns []logstorage.BlockColumn, rowsCount int)
+
+	// writeRows must write the given rows to bw.
+	writeRows(bw *bufferedWriter, rows []row)
+
+	// close must write the remaining data to bw after all the results are written.
+	close(bw *bufferedWriter) error
+}
+
+// newQueryResponseWriter returns queryResponseWriter for the given format.
+//
+// If columnNames is nil, then the written columns are derived from the results.
+func newQueryResponseWriter(format string, columnNames []string) (queryResponseWriter, error) {
+	switch format {
+	case "csv":
+		return newCSVResponseWriter(',', columnNames), nil
+	case "tsv":
+		return newCSVResponseWriter('\t', columnNames), nil
+	case "parquet":
+		return newParquetResponseWriter(columnNames), nil
+	case "protobuf":
+		return newProtobufResponseWriter(columnNames), nil
+	default:
+		return nil, fmt.Errorf("unsupported format=%q; supported values: json, csv, tsv, parquet, protobuf", format)
+	}
+}
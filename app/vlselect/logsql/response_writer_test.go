@@ -0,0 +1,23 @@
+package logsql
+
+import (
+	"testing"
+)
+
+func TestNewQueryResponseWriterFailure(t *testing.T) {
+	f := func(format string) {
+		t.Helper()
+
+		rw, err := newQueryResponseWriter(format, nil)
+		if err == nil {
+			t.Fatalf("expecting non-nil error for format=%q", format)
+		}
+		if rw != nil {
+			t.Fatalf("expecting nil writer for format=%q", format)
+		}
+	}
+
+	f("xml")
+	f("CSV")
+	f("parquet ")
+}
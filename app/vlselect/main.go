@@ -3,6 +3,7 @@ package vlselect
 import (
 	"context"
 	"embed"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
@@ -21,8 +22,14 @@ var (
 	maxConcurrentRequests = flag.Int("search.maxConcurrentRequests", getDefaultMaxConcurrentRequests(), "The maximum number of concurrent search requests. "+
 		"It shouldn't be high, since a single request can saturate all the CPU cores, while many concurrently executed requests may require high amounts of memory. "+
 		"See also -search.maxQueueDuration")
+	maxConcurrentBackgroundRequests = flag.Int("search.maxConcurrentBackgroundRequests", 0, "The maximum number of concurrent search requests with background priority "+
+		"such as exports of query results. This prevents from starvation of interactive search requests by heavy background requests. "+
+		"By default it is set to the half of -search.maxConcurrentRequests; see https://docs.victoriametrics.com/victorialogs/querying/#query-priorities")
+	maxQueuedRequests = flag.Int("search.maxQueuedRequests", 0, "The maximum number of search requests, which may wait for execution "+
+		"when -search.maxConcurrentRequests limit is reached. Additional requests are rejected with '503 Service Unavailable' status code. "+
+		"By default it is set to 10*-search.maxConcurrentRequests; see also -search.maxQueueDuration")
 	maxQueueDuration = flag.Duration("search.maxQueueDuration", 10*time.Second, "The maximum time the search request waits for execution when -search.maxConcurrentRequests "+
-		"limit is reached; see also -search.maxQueryDuration")
+		"limit is reached; see also -search.maxQueuedRequests and -search.maxQueryDuration")
 	maxQueryDuration = flag.Duration("search.maxQueryDuration", time.Second*30, "The maximum duration for query execution. It can be overridden on a per-query basis via 'timeout' query arg")
 )
 
@@ -42,24 +49,43 @@ func getDefaultMaxConcurrentRequests() int {
 
 // Init initializes vlselect
 func Init() {
-	concurrencyLimitCh = make(chan struct{}, *maxConcurrentRequests)
+	maxBackgroundConcurrency := *maxConcurrentBackgroundRequests
+	if maxBackgroundConcurrency <= 0 {
+		maxBackgroundConcurrency = max(*maxConcurrentRequests/2, 1)
+	}
+	maxQueueSize := *maxQueuedRequests
+	if maxQueueSize <= 0 {
+		maxQueueSize = 10 * *maxConcurrentRequests
+	}
+	scheduler = newQueryScheduler(*maxConcurrentRequests, maxBackgroundConcurrency, maxQueueSize)
 }
 
 // Stop stops vlselect
 func Stop() {
 }
 
-var concurrencyLimitCh chan struct{}
+// scheduler limits the number of concurrently executed search requests.
+var scheduler *queryScheduler
 
 var (
-	concurrencyLimitReached = metrics.NewCounter(`vl_concurrent_select_limit_reached_total`)
-	concurrencyLimitTimeout = metrics.NewCounter(`vl_concurrent_select_limit_timeout_total`)
+	concurrencyLimitReached   = metrics.NewCounter(`vl_concurrent_select_limit_reached_total`)
+	concurrencyLimitTimeout   = metrics.NewCounter(`vl_concurrent_select_limit_timeout_total`)
+	concurrencyLimitQueueFull = metrics.NewCounter(`vl_concurrent_select_queue_full_total`)
 
 	_ = metrics.NewGauge(`vl_concurrent_select_capacity`, func() float64 {
-		return float64(cap(concurrencyLimitCh))
+		return float64(scheduler.maxConcurrency)
 	})
 	_ = metrics.NewGauge(`vl_concurrent_select_current`, func() float64 {
-		return float64(len(concurrencyLimitCh))
+		return float64(scheduler.getConcurrency())
+	})
+	_ = metrics.NewGauge(`vl_concurrent_select_queue_capacity`, func() float64 {
+		return float64(scheduler.maxQueueSize)
+	})
+	_ = metrics.NewGauge(`vl_concurrent_select_queued{priority="interactive"}`, func() float64 {
+		return float64(scheduler.getQueued(queryPriorityInteractive))
+	})
+	_ = metrics.NewGauge(`vl_concurrent_select_queued{priority="background"}`, func() float64 {
+		return float64(scheduler.getQueued(queryPriorityBackground))
 	})
 )
 
@@ -105,38 +131,54 @@ func RequestHandler(w http.ResponseWriter, r *http.Request) bool {
 	ctxWithTimeout, cancel := context.WithTimeout(ctx, d)
 	defer cancel()
 
-	stopCh := ctxWithTimeout.Done()
-	select {
-	case concurrencyLimitCh <- struct{}{}:
-		defer func() { <-concurrencyLimitCh }()
-	default:
-		// Sleep for a while until giving up. This should resolve short bursts in requests.
+	priority, err := getQueryPriority(r, path)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return true
+	}
+	if !scheduler.tryAcquire(priority) {
+		// Wait for a while until giving up. This should resolve short bursts in requests.
 		concurrencyLimitReached.Inc()
-		select {
-		case concurrencyLimitCh <- struct{}{}:
-			defer func() { <-concurrencyLimitCh }()
-		case <-stopCh:
-			switch ctxWithTimeout.Err() {
-			case context.Canceled:
-				remoteAddr := httpserver.GetQuotedRemoteAddr(r)
-				requestURI := httpserver.GetRequestURI(r)
-				logger.Infof("client has canceled the pending request after %.3f seconds: remoteAddr=%s, requestURI: %q",
-					time.Since(startTime).Seconds(), remoteAddr, requestURI)
-			case context.DeadlineExceeded:
-				concurrencyLimitTimeout.Inc()
-				err := &httpserver.ErrorWithStatusCode{
-					Err: fmt.Errorf("couldn't start executing the request in %.3f seconds, since -search.maxConcurrentRequests=%d concurrent requests "+
-						"are executed. Possible solutions: to reduce query load; to add more compute resources to the server; "+
-						"to increase -search.maxQueueDuration=%s; to increase -search.maxQueryDuration=%s; to increase -search.maxConcurrentRequests; "+
-						"to pass bigger value to 'timeout' query arg",
-						d.Seconds(), *maxConcurrentRequests, maxQueueDuration, maxQueryDuration),
-					StatusCode: http.StatusServiceUnavailable,
-				}
-				httpserver.Errorf(w, r, "%s", err)
+		queueDuration := *maxQueueDuration
+		if queueDuration > d {
+			queueDuration = d
+		}
+		err := scheduler.acquire(ctxWithTimeout.Done(), queueDuration, priority)
+		switch {
+		case err == nil:
+			// The request can be executed.
+		case errors.Is(err, errQueueCanceled) && errors.Is(ctxWithTimeout.Err(), context.Canceled):
+			remoteAddr := httpserver.GetQuotedRemoteAddr(r)
+			requestURI := httpserver.GetRequestURI(r)
+			logger.Infof("client has canceled the pending request after %.3f seconds: remoteAddr=%s, requestURI: %q",
+				time.Since(startTime).Seconds(), remoteAddr, requestURI)
+			return true
+		case errors.Is(err, errQueueFull):
+			concurrencyLimitQueueFull.Inc()
+			err := &httpserver.ErrorWithStatusCode{
+				Err: fmt.Errorf("couldn't start executing the request, since -search.maxConcurrentRequests=%d concurrent requests are executed "+
+					"and %d requests are waiting for execution. Possible solutions: to reduce query load; "+
+					"to add more compute resources to the server; to increase -search.maxQueuedRequests; to increase -search.maxConcurrentRequests",
+					scheduler.maxConcurrency, scheduler.maxQueueSize),
+				StatusCode: http.StatusServiceUnavailable,
+			}
+			httpserver.Errorf(w, r, "%s", err)
+			return true
+		default:
+			concurrencyLimitTimeout.Inc()
+			err := &httpserver.ErrorWithStatusCode{
+				Err: fmt.Errorf("couldn't start executing the request with priority=%s in %.3f seconds, since -search.maxConcurrentRequests=%d concurrent requests "+
+					"are executed. Possible solutions: to reduce query load; to add more compute resources to the server; "+
+					"to increase -search.maxQueueDuration=%s; to increase -search.maxQueryDuration=%s; to increase -search.maxConcurrentRequests; "+
+					"to pass bigger value to 'timeout' query arg",
+					priority, queueDuration.Seconds(), *maxConcurrentRequests, maxQueueDuration, maxQueryDuration),
+				StatusCode: http.StatusServiceUnavailable,
 			}
+			httpserver.Errorf(w, r, "%s", err)
 			return true
 		}
 	}
+	defer scheduler.release(priority)
 
 	if path == "/select/logsql/tail" {
 		logsqlTailRequests.Inc()
@@ -151,7 +193,7 @@ func RequestHandler(w http.ResponseWriter, r *http.Request) bool {
 		return false
 	}
 
-	err := ctxWithTimeout.Err()
+	err = ctxWithTimeout.Err()
 	switch err {
 	case nil:
 		// nothing to do
@@ -177,6 +219,14 @@ func RequestHandler(w http.ResponseWriter, r *http.Request) bool {
 func processSelectRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, path string) bool {
 	httpserver.EnableCORS(w, r)
 	switch path {
+	case "/select/logsql/complete":
+		logsqlCompleteRequests.Inc()
+		logsql.ProcessCompleteRequest(ctx, w, r)
+		return true
+	case "/select/logsql/facets":
+		logsqlFacetsRequests.Inc()
+		logsql.ProcessFacetsRequest(ctx, w, r)
+		return true
 	case "/select/logsql/field_names":
 		logsqlFieldNamesRequests.Inc()
 		logsql.ProcessFieldNamesRequest(ctx, w, r)
@@ -193,6 +243,10 @@ func processSelectRequest(ctx context.Context, w http.ResponseWriter, r *http.Re
 		logsqlQueryRequests.Inc()
 		logsql.ProcessQueryRequest(ctx, w, r)
 		return true
+	case "/select/logsql/query_plan":
+		logsqlQueryPlanRequests.Inc()
+		logsql.ProcessQueryPlanRequest(ctx, w, r)
+		return true
 	case "/select/logsql/stream_field_names":
 		logsqlStreamFieldNamesRequests.Inc()
 		logsql.ProcessStreamFieldNamesRequest(ctx, w, r)
@@ -228,10 +282,13 @@ func getMaxQueryDuration(r *http.Request) time.Duration {
 }
 
 var (
+	logsqlCompleteRequests          = metrics.NewCounter(`vl_http_requests_total{path="/select/logsql/complete"}`)
+	logsqlFacetsRequests            = metrics.NewCounter(`vl_http_requests_total{path="/select/logsql/facets"}`)
 	logsqlFieldNamesRequests        = metrics.NewCounter(`vl_http_requests_total{path="/select/logsql/field_names"}`)
 	logsqlFieldValuesRequests       = metrics.NewCounter(`vl_http_requests_total{path="/select/logsql/field_values"}`)
 	logsqlHitsRequests              = metrics.NewCounter(`vl_http_requests_total{path="/select/logsql/hits"}`)
 	logsqlQueryRequests             = metrics.NewCounter(`vl_http_requests_total{path="/select/logsql/query"}`)
+	logsqlQueryPlanRequests         = metrics.NewCounter(`vl_http_requests_total{path="/select/logsql/query_plan"}`)
 	logsqlStreamFieldNamesRequests  = metrics.NewCounter(`vl_http_requests_total{path="/select/logsql/stream_field_names"}`)
 	logsqlStreamFieldValuesRequests = metrics.NewCounter(`vl_http_requests_total{path="/select/logsql/stream_field_values"}`)
 	logsqlStreamIDsRequests         = metrics.NewCounter(`vl_http_requests_total{path="/select/logsql/stream_ids"}`)
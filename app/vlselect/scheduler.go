@@ -0,0 +1,258 @@
+package vlselect
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// queryPriority is the priority class for the search request.
+type queryPriority int
+
+const (
+	// queryPriorityInteractive is the priority for interactive search requests such as requests from Web UI and dashboards.
+	queryPriorityInteractive = queryPriority(iota)
+
+	// queryPriorityBackground is the priority for heavy search requests such as exports, which may take a lot of time.
+	//
+	// Such requests are executed only when there are no pending interactive requests,
+	// and their concurrency is limited, so they cannot occupy all the slots for interactive requests.
+	queryPriorityBackground
+
+	queryPrioritiesCount
+)
+
+func (qp queryPriority) String() string {
+	switch qp {
+	case queryPriorityInteractive:
+		return "interactive"
+	case queryPriorityBackground:
+		return "background"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(qp))
+	}
+}
+
+// getQueryPriority returns the priority for the search request r at the given path.
+//
+// The priority can be set explicitly via `priority` query arg. Otherwise exports of query results
+// via /select/logsql/query with non-JSON format are treated as background requests.
+func getQueryPriority(r *http.Request, path string) (queryPriority, error) {
+	switch s := r.FormValue("priority"); s {
+	case "":
+		if format := r.FormValue("format"); path == "/select/logsql/query" && format != "" && format != "json" {
+			return queryPriorityBackground, nil
+		}
+		return queryPriorityInteractive, nil
+	case "interactive":
+		return queryPriorityInteractive, nil
+	case "background":
+		return queryPriorityBackground, nil
+	default:
+		return 0, fmt.Errorf("unsupported priority=%q; supported values: interactive, background", s)
+	}
+}
+
+var (
+	// errQueueFull is returned from queryScheduler.acquire when the queue for pending requests is full.
+	errQueueFull = errors.New("the queue for pending requests is full")
+
+	// errQueueTimeout is returned from queryScheduler.acquire when the request couldn't start in the given time.
+	errQueueTimeout = errors.New("the request couldn't start in the given time")
+
+	// errQueueCanceled is returned from queryScheduler.acquire when the pending request is canceled.
+	errQueueCanceled = errors.New("the pending request has been canceled")
+)
+
+// queryScheduler limits the number of concurrently executed search requests.
+//
+// Requests, which cannot be started immediately, are put into a bounded queue. Pending requests
+// with higher priority are started before pending requests with lower priority, while pending requests
+// with the same priority are started in FIFO order.
+type queryScheduler struct {
+	// maxConcurrency is the maximum number of concurrently executed requests.
+	maxConcurrency int
+
+	// maxBackgroundConcurrency is the maximum number of concurrently executed requests with queryPriorityBackground.
+	maxBackgroundConcurrency int
+
+	// maxQueueSize is the maximum number of pending requests.
+	maxQueueSize int
+
+	mu sync.Mutex
+
+	// concurrency is the number of currently executed requests per every priority.
+	concurrency [queryPrioritiesCount]int
+
+	// queues contains pending requests per every priority.
+	queues [queryPrioritiesCount][]*queryWaiter
+}
+
+// queryWaiter is a pending request at queryScheduler.
+type queryWaiter struct {
+	// readyCh is closed when the request is allowed to start.
+	readyCh chan struct{}
+}
+
+func newQueryScheduler(maxConcurrency, maxBackgroundConcurrency, maxQueueSize int) *queryScheduler {
+	if maxConcurrency <= 0 {
+		maxConcurrency = 1
+	}
+	if maxBackgroundConcurrency <= 0 || maxBackgroundConcurrency > maxConcurrency {
+		maxBackgroundConcurrency = maxConcurrency
+	}
+	return &queryScheduler{
+		maxConcurrency:           maxConcurrency,
+		maxBackgroundConcurrency: maxBackgroundConcurrency,
+		maxQueueSize:             maxQueueSize,
+	}
+}
+
+// tryAcquire tries acquiring a slot for the request with the given priority without waiting.
+//
+// If true is returned, then release must be called with the same priority when the request is finished.
+func (qs *queryScheduler) tryAcquire(priority queryPriority) bool {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	if qs.hasPendingLocked(priority) || !qs.canStartLocked(priority) {
+		return false
+	}
+	qs.concurrency[priority]++
+	return true
+}
+
+// acquire waits until a slot for the request with the given priority becomes available.
+//
+// It returns errQueueFull if the queue is full, errQueueTimeout if the slot isn't available during the timeout
+// and errQueueCanceled if stopCh is closed.
+// If nil is returned, then release must be called with the same priority when the request is finished.
+func (qs *queryScheduler) acquire(stopCh <-chan struct{}, timeout time.Duration, priority queryPriority) error {
+	qs.mu.Lock()
+	if !qs.hasPendingLocked(priority) && qs.canStartLocked(priority) {
+		qs.concurrency[priority]++
+		qs.mu.Unlock()
+		return nil
+	}
+	if qs.queuedLocked() >= qs.maxQueueSize {
+		qs.mu.Unlock()
+		return errQueueFull
+	}
+	qw := &queryWaiter{
+		readyCh: make(chan struct{}),
+	}
+	qs.queues[priority] = append(qs.queues[priority], qw)
+	qs.mu.Unlock()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	var err error
+	select {
+	case <-qw.readyCh:
+		return nil
+	case <-t.C:
+		err = errQueueTimeout
+	case <-stopCh:
+		err = errQueueCanceled
+	}
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	q := qs.queues[priority]
+	for i, qwPending := range q {
+		if qwPending == qw {
+			qs.queues[priority] = append(q[:i], q[i+1:]...)
+			return err
+		}
+	}
+
+	// The request has been allowed to start concurrently with the timeout or the cancellation.
+	// Pass the slot to the next pending request.
+	qs.releaseLocked(priority)
+	return err
+}
+
+// release releases the slot obtained via tryAcquire or acquire for the request with the given priority.
+func (qs *queryScheduler) release(priority queryPriority) {
+	qs.mu.Lock()
+	qs.releaseLocked(priority)
+	qs.mu.Unlock()
+}
+
+func (qs *queryScheduler) releaseLocked(priority queryPriority) {
+	qs.concurrency[priority]--
+	if qs.concurrency[priority] < 0 {
+		logger.Panicf("BUG: negative concurrency for priority=%s", priority)
+	}
+
+	// Start pending requests in the order of their priority.
+	for i := range qs.queues {
+		p := queryPriority(i)
+		for len(qs.queues[p]) > 0 && qs.canStartLocked(p) {
+			qw := qs.queues[p][0]
+			qs.queues[p] = qs.queues[p][1:]
+			qs.concurrency[p]++
+			close(qw.readyCh)
+		}
+	}
+}
+
+// hasPendingLocked returns true if there are pending requests, which must start before the request with the given priority.
+func (qs *queryScheduler) hasPendingLocked(priority queryPriority) bool {
+	for p := queryPriority(0); p <= priority; p++ {
+		if len(qs.queues[p]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (qs *queryScheduler) canStartLocked(priority queryPriority) bool {
+	n := 0
+	for _, c := range qs.concurrency {
+		n += c
+	}
+	if n >= qs.maxConcurrency {
+		return false
+	}
+	if priority == queryPriorityBackground {
+		if qs.concurrency[priority] >= qs.maxBackgroundConcurrency || len(qs.queues[queryPriorityInteractive]) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (qs *queryScheduler) queuedLocked() int {
+	n := 0
+	for _, q := range qs.queues {
+		n += len(q)
+	}
+	return n
+}
+
+// getConcurrency returns the number of currently executed requests.
+func (qs *queryScheduler) getConcurrency() int {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	n := 0
+	for _, c := range qs.concurrency {
+		n += c
+	}
+	return n
+}
+
+// getQueued returns the number of pending requests with the given priority.
+func (qs *queryScheduler) getQueued(priority queryPriority) int {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	return len(qs.queues[priority])
+}
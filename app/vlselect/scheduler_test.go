@@ -0,0 +1,174 @@
+package vlselect
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGetQueryPriority(t *testing.T) {
+	f := func(path, args string, priorityExpected queryPriority) {
+		t.Helper()
+
+		r := newTestRequest(t, path, args)
+		priority, err := getQueryPriority(r, path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if priority != priorityExpected {
+			t.Fatalf("unexpected priority for %s?%s; got %s; want %s", path, args, priority, priorityExpected)
+		}
+	}
+
+	f("/select/logsql/query", "query=error", queryPriorityInteractive)
+	f("/select/logsql/query", "query=error&format=json", queryPriorityInteractive)
+	f("/select/logsql/query", "query=error&format=csv", queryPriorityBackground)
+	f("/select/logsql/query", "query=error&format=parquet&priority=interactive", queryPriorityInteractive)
+	f("/select/logsql/hits", "query=error&format=csv", queryPriorityInteractive)
+	f("/select/logsql/hits", "query=error&priority=background", queryPriorityBackground)
+
+	// invalid priority
+	r := newTestRequest(t, "/select/logsql/query", "priority=foo")
+	if _, err := getQueryPriority(r, "/select/logsql/query"); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
+
+func newTestRequest(t *testing.T, path, args string) *http.Request {
+	t.Helper()
+
+	r, err := http.NewRequest(http.MethodGet, path+"?"+args, nil)
+	if err != nil {
+		t.Fatalf("cannot create request: %s", err)
+	}
+	return r
+}
+
+func TestQuerySchedulerConcurrencyLimit(t *testing.T) {
+	qs := newQueryScheduler(2, 1, 10)
+
+	if !qs.tryAcquire(queryPriorityInteractive) {
+		t.Fatalf("cannot acquire the first slot")
+	}
+	if !qs.tryAcquire(queryPriorityBackground) {
+		t.Fatalf("cannot acquire the second slot")
+	}
+	if qs.tryAcquire(queryPriorityInteractive) {
+		t.Fatalf("unexpected slot acquired above the concurrency limit")
+	}
+	if n := qs.getConcurrency(); n != 2 {
+		t.Fatalf("unexpected concurrency; got %d; want 2", n)
+	}
+
+	qs.release(queryPriorityBackground)
+
+	// The background concurrency limit must leave slots for interactive requests
+	if !qs.tryAcquire(queryPriorityBackground) {
+		t.Fatalf("cannot acquire the slot for background request")
+	}
+	qs.release(queryPriorityInteractive)
+	if qs.tryAcquire(queryPriorityBackground) {
+		t.Fatalf("unexpected slot acquired above the background concurrency limit")
+	}
+	if !qs.tryAcquire(queryPriorityInteractive) {
+		t.Fatalf("cannot acquire the slot for interactive request")
+	}
+
+	qs.release(queryPriorityInteractive)
+	qs.release(queryPriorityBackground)
+	if n := qs.getConcurrency(); n != 0 {
+		t.Fatalf("unexpected concurrency; got %d; want 0", n)
+	}
+}
+
+func TestQuerySchedulerPriorities(t *testing.T) {
+	qs := newQueryScheduler(1, 1, 10)
+
+	if !qs.tryAcquire(queryPriorityInteractive) {
+		t.Fatalf("cannot acquire the slot")
+	}
+
+	// Start pending background request at first and then pending interactive request.
+	startedCh := make(chan queryPriority, 2)
+	acquire := func(priority queryPriority) {
+		if err := qs.acquire(nil, time.Minute, priority); err != nil {
+			panic(err)
+		}
+		startedCh <- priority
+	}
+	go acquire(queryPriorityBackground)
+	waitForQueued(t, qs, queryPriorityBackground, 1)
+	go acquire(queryPriorityInteractive)
+	waitForQueued(t, qs, queryPriorityInteractive, 1)
+
+	// The interactive request must start before the background request.
+	qs.release(queryPriorityInteractive)
+	if p := <-startedCh; p != queryPriorityInteractive {
+		t.Fatalf("unexpected priority for the started request; got %s; want %s", p, queryPriorityInteractive)
+	}
+	qs.release(queryPriorityInteractive)
+	if p := <-startedCh; p != queryPriorityBackground {
+		t.Fatalf("unexpected priority for the started request; got %s; want %s", p, queryPriorityBackground)
+	}
+	qs.release(queryPriorityBackground)
+
+	if n := qs.getConcurrency(); n != 0 {
+		t.Fatalf("unexpected concurrency; got %d; want 0", n)
+	}
+}
+
+func TestQuerySchedulerQueueLimits(t *testing.T) {
+	qs := newQueryScheduler(1, 1, 1)
+
+	if err := qs.acquire(nil, time.Second, queryPriorityInteractive); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The pending request must be rejected after the timeout
+	if err := qs.acquire(nil, 10*time.Millisecond, queryPriorityInteractive); !errors.Is(err, errQueueTimeout) {
+		t.Fatalf("unexpected error; got %v; want %v", err, errQueueTimeout)
+	}
+
+	// The canceled pending request must be removed from the queue
+	stopCh := make(chan struct{})
+	close(stopCh)
+	if err := qs.acquire(stopCh, time.Minute, queryPriorityInteractive); !errors.Is(err, errQueueCanceled) {
+		t.Fatalf("unexpected error; got %v; want %v", err, errQueueCanceled)
+	}
+	if n := qs.getQueued(queryPriorityInteractive); n != 0 {
+		t.Fatalf("unexpected number of queued requests; got %d; want 0", n)
+	}
+
+	// The request must be rejected when the queue is full
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- qs.acquire(nil, time.Minute, queryPriorityBackground)
+	}()
+	waitForQueued(t, qs, queryPriorityBackground, 1)
+	if err := qs.acquire(nil, time.Minute, queryPriorityInteractive); !errors.Is(err, errQueueFull) {
+		t.Fatalf("unexpected error; got %v; want %v", err, errQueueFull)
+	}
+
+	qs.release(queryPriorityInteractive)
+	if err := <-doneCh; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	qs.release(queryPriorityBackground)
+
+	if n := qs.getConcurrency(); n != 0 {
+		t.Fatalf("unexpected concurrency; got %d; want 0", n)
+	}
+}
+
+func waitForQueued(t *testing.T, qs *queryScheduler, priority queryPriority, nExpected int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for qs.getQueued(priority) != nExpected {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout when waiting for %d queued requests with priority=%s", nExpected, priority)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,62 @@
+package vlstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+var (
+	activeQueriesRequests = metrics.NewCounter(`vl_http_requests_total{path="/storage/active_queries"}`)
+	cancelQueryRequests   = metrics.NewCounter(`vl_http_requests_total{path="/storage/cancel_query"}`)
+	cancelQueryErrors     = metrics.NewCounter(`vl_http_request_errors_total{path="/storage/cancel_query"}`)
+)
+
+// processActiveQueries writes queries executed at the moment to w.
+func processActiveQueries(w http.ResponseWriter) {
+	aqs := strg.GetActiveQueries()
+	data, err := json.Marshal(map[string]any{
+		"queries": aqs,
+	})
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal active queries: %s", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// processCancelQuery cancels the active query with the id from the `query_id` query arg.
+func processCancelQuery(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("unsupported method %q; use POST", r.Method),
+			StatusCode: http.StatusMethodNotAllowed,
+		}
+	}
+
+	queryIDStr := r.FormValue("query_id")
+	if queryIDStr == "" {
+		return fmt.Errorf("missing `query_id` query arg")
+	}
+	queryID, err := strconv.ParseUint(queryIDStr, 10, 64)
+	if err != nil {
+		return fmt.Errorf("cannot parse `query_id` query arg %q: %w", queryIDStr, err)
+	}
+
+	if !strg.CancelQuery(queryID) {
+		return &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("cannot find active query with query_id=%d; the query may be already finished", queryID),
+			StatusCode: http.StatusNotFound,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"query_id":"%d"}`, queryID)
+	return nil
+}
@@ -0,0 +1,68 @@
+package vlstorage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+var (
+	deleteRunTaskRequests     = metrics.NewCounter(`vl_http_requests_total{path="/delete/run_task"}`)
+	deleteRunTaskErrors       = metrics.NewCounter(`vl_http_request_errors_total{path="/delete/run_task"}`)
+	deleteActiveTasksRequests = metrics.NewCounter(`vl_http_requests_total{path="/delete/active_tasks"}`)
+)
+
+// processDeleteRunTask starts deleting logs matching the `filter` query arg for the tenant from the request headers.
+func processDeleteRunTask(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("unsupported method %q; use POST", r.Method),
+			StatusCode: http.StatusMethodNotAllowed,
+		}
+	}
+
+	tenantID, err := logstorage.GetTenantIDFromRequest(r)
+	if err != nil {
+		return fmt.Errorf("cannot obtain tenantID: %w", err)
+	}
+
+	fStr := r.FormValue("filter")
+	if fStr == "" {
+		return fmt.Errorf("missing `filter` query arg")
+	}
+	q, err := logstorage.ParseQuery(fStr)
+	if err != nil {
+		var pe *logstorage.ParseError
+		if errors.As(err, &pe) {
+			return fmt.Errorf("cannot parse filter [%s] at position %d: %s\n%s", fStr, pe.Pos, pe, pe.Snippet())
+		}
+		return fmt.Errorf("cannot parse filter [%s]: %s", fStr, err)
+	}
+
+	taskID, err := strg.DeleteRows([]logstorage.TenantID{tenantID}, q)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"task_id":"%d"}`, taskID)
+	return nil
+}
+
+// processDeleteActiveTasks writes active delete tasks to w.
+func processDeleteActiveTasks(w http.ResponseWriter) {
+	tasks := strg.GetDeleteTasks()
+	data, err := json.Marshal(tasks)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal delete tasks: %s", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,52 @@
+package vlstorage
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+var (
+	forceMergeRequests = metrics.NewCounter(`vl_http_requests_total{path="/storage/force_merge"}`)
+	forceMergeErrors   = metrics.NewCounter(`vl_http_request_errors_total{path="/storage/force_merge"}`)
+	activeForceMerges  = metrics.NewCounter(`vl_active_force_merges`)
+)
+
+// processForceMerge starts forced merge in background for partitions with names starting with the `partition_prefix` query arg.
+func processForceMerge(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("unsupported method %q; use POST", r.Method),
+			StatusCode: http.StatusMethodNotAllowed,
+		}
+	}
+	partitionNamePrefix := r.FormValue("partition_prefix")
+	if len(partitionNamePrefix) > len("YYYYMMDD") {
+		return fmt.Errorf("too long `partition_prefix` query arg %q; it must contain up to 8 digits in the form YYYYMMDD", partitionNamePrefix)
+	}
+	for _, c := range partitionNamePrefix {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("unexpected `partition_prefix` query arg %q; it must contain only digits in the form YYYYMMDD", partitionNamePrefix)
+		}
+	}
+
+	s := strg
+	go func() {
+		activeForceMerges.Inc()
+		defer activeForceMerges.Dec()
+
+		logger.Infof("forced merge for partition_prefix=%q has been started", partitionNamePrefix)
+		startTime := time.Now()
+		s.ForceMergePartitions(partitionNamePrefix)
+		logger.Infof("forced merge for partition_prefix=%q has been finished in %.3f seconds", partitionNamePrefix, time.Since(startTime).Seconds())
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status":"ok"}`)
+	return nil
+}
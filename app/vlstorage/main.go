@@ -2,10 +2,13 @@ package vlstorage
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/VictoriaMetrics/metrics"
@@ -23,6 +26,9 @@ var (
 		"see https://docs.victoriametrics.com/victorialogs/#retention ; see also -retention.maxDiskSpaceUsageBytes")
 	maxDiskSpaceUsageBytes = flagutil.NewBytes("retention.maxDiskSpaceUsageBytes", 0, "The maximum disk space usage at -storageDataPath before older per-day "+
 		"partitions are automatically dropped; see https://docs.victoriametrics.com/victorialogs/#retention-by-disk-space-usage ; see also -retentionPeriod")
+	retentionFilters = flagutil.NewArrayString("retention.filter", "Optional retention override for log streams in the form `[accountID:projectID:]{stream_filter}:retention`, "+
+		"for example `{env=\"prod\"}:90d`. The retention from the first matching filter is applied to the log stream; -retentionPeriod is applied to log streams without matching filters. "+
+		"Log entries outside the retention for their log stream are deleted during background merges; see https://docs.victoriametrics.com/victorialogs/#retention-filters")
 	futureRetention = flagutil.NewDuration("futureRetention", "2d", "Log entries with timestamps bigger than now+futureRetention are rejected during data ingestion; "+
 		"see https://docs.victoriametrics.com/victorialogs/#retention")
 	storageDataPath = flag.String("storageDataPath", "victoria-logs-data", "Path to directory where to store VictoriaLogs data; "+
@@ -37,8 +43,162 @@ var (
 		"see https://docs.victoriametrics.com/victorialogs/data-ingestion/ ; see also -logNewStreams")
 	minFreeDiskSpaceBytes = flagutil.NewBytes("storage.minFreeDiskSpaceBytes", 10e6, "The minimum free disk space at -storageDataPath after which "+
 		"the storage stops accepting new data")
+	bloomFilterBitsPerToken = flag.Int("storage.bloomFilterBitsPerToken", logstorage.DefaultBloomFilterBitsPerToken, "The number of bits per each token "+
+		"in bloom filters for newly created data blocks. Bigger values reduce the number of false positives during full-text search at the cost of higher disk space usage. "+
+		"Supported values are in the range [4..32]; see https://docs.victoriametrics.com/victorialogs/#storage")
+	tenantMaxRowsPerSecond = flag.Int("tenant.maxRowsPerSecond", 0, "The maximum number of log entries every tenant can ingest per second. "+
+		"Log entries exceeding the limit are rejected; see https://docs.victoriametrics.com/victorialogs/#tenant-limits ; see also -tenant.limitsOverride")
+	tenantMaxBytesPerSecond = flagutil.NewBytes("tenant.maxBytesPerSecond", 0, "The maximum uncompressed size of log entries every tenant can ingest per second. "+
+		"Log entries exceeding the limit are rejected; see https://docs.victoriametrics.com/victorialogs/#tenant-limits ; see also -tenant.limitsOverride")
+	tenantMaxRetainedBytes = flagutil.NewBytes("tenant.maxRetainedBytes", 0, "The maximum compressed size of log entries, which can be stored for every tenant. "+
+		"New log entries for tenants exceeding the limit are rejected; see https://docs.victoriametrics.com/victorialogs/#tenant-limits ; see also -tenant.limitsOverride")
+	tenantLimitsOverrides = flagutil.NewArrayString("tenant.limitsOverride", "Optional limits override for the given tenant in the form `accountID:projectID:{name=value,...,name=value}`, "+
+		"where the name can be rows_per_second, bytes_per_second or retained_bytes. For example, `12:0:{rows_per_second=10000,retained_bytes=100GiB}`. "+
+		"Limits missing in the override are obtained from -tenant.maxRowsPerSecond, -tenant.maxBytesPerSecond and -tenant.maxRetainedBytes; "+
+		"see https://docs.victoriametrics.com/victorialogs/#tenant-limits")
+	useZSTDDicts = flag.Bool("storage.useZSTDDicts", false, "Whether to train per-day ZSTD dictionaries for compressing string values. "+
+		"This may improve compression ratio for small repetitive values such as user agents and request paths. "+
+		"The trained dictionaries are used for the corresponding days even if this flag is disabled later; see https://docs.victoriametrics.com/victorialogs/#storage")
+	disableCache = flag.Bool("search.disableCache", false, "Whether to disable the cache for query results; "+
+		"see https://docs.victoriametrics.com/victorialogs/querying/#query-results-cache")
+	resultsCacheSize = flagutil.NewBytes("search.resultsCacheSize", 0, "The maximum size of the cache for query results. "+
+		"By default 5% of the allowed memory is used (see -memory.allowedPercent and -memory.allowedBytes); "+
+		"see https://docs.victoriametrics.com/victorialogs/querying/#query-results-cache")
+	cacheTimestampOffset = flag.Duration("search.cacheTimestampOffset", 5*time.Minute, "The offset from the current time for logs, which aren't put into the cache for query results, "+
+		"since they may change because of delayed ingestion; see https://docs.victoriametrics.com/victorialogs/querying/#query-results-cache")
+	persistResultsCache = flag.Bool("search.persistResultsCache", false, "Whether to save the cache for query results to -storageDataPath on graceful shutdown "+
+		"and to load it on startup; see https://docs.victoriametrics.com/victorialogs/querying/#query-results-cache")
+	mergeConcurrency = flag.Int("storage.mergeConcurrency", 0, "The maximum number of concurrent background merges for small parts and for big parts at -storageDataPath. "+
+		"By default the number of available CPU cores is used. Smaller values reduce CPU and disk IO usage during background merges at the cost of bigger number of parts; "+
+		"see https://docs.victoriametrics.com/victorialogs/#forced-merge")
+	maxPartSize = flagutil.NewBytes("storage.maxPartSize", logstorage.DefaultMaxPartSize, "The maximum compressed size of parts created by background and forced merges. "+
+		"Smaller values reduce disk space and time needed for a single merge at the cost of bigger number of parts; "+
+		"see https://docs.victoriametrics.com/victorialogs/#forced-merge")
+	logSlowQueryDuration = flag.Duration("search.logSlowQueryDuration", 5*time.Second, "Log queries with execution time exceeding this value. Zero disables slow query logging; "+
+		"see https://docs.victoriametrics.com/victorialogs/querying/#slow-query-log")
+	spillDir = flag.String("search.spillDir", "", "Path to directory for temporary files with the state of stats and sort pipes, which doesn't fit -search.maxMemoryPerQuery. "+
+		"By default <-storageDataPath>/tmp/spill is used; see https://docs.victoriametrics.com/victorialogs/querying/")
 )
 
+var (
+	deleteAuthKey = flagutil.NewPassword("deleteAuthKey", "authKey for logs' deletion via /delete/run_task and for listing active delete tasks via /delete/active_tasks. "+
+		"It overrides -httpAuth.*; see https://docs.victoriametrics.com/victorialogs/#deleting-logs")
+	tenantStatsAuthKey = flagutil.NewPassword("tenantStatsAuthKey", "authKey for obtaining per-tenant storage usage stats via /storage/tenant_stats. "+
+		"It overrides -httpAuth.*; see https://docs.victoriametrics.com/victorialogs/#tenant-stats")
+	activeQueriesAuthKey = flagutil.NewPassword("activeQueriesAuthKey", "authKey for listing active queries via /storage/active_queries and for canceling them via /storage/cancel_query. "+
+		"It overrides -httpAuth.*; see https://docs.victoriametrics.com/victorialogs/querying/#active-queries")
+	partitionsAuthKey = flagutil.NewPassword("partitionsAuthKey", "authKey for detaching and attaching partitions via /storage/partitions/detach and /storage/partitions/attach "+
+		"and for listing detached partitions via /storage/partitions/list_detached. "+
+		"It overrides -httpAuth.*; see https://docs.victoriametrics.com/victorialogs/#partitions-attach-and-detach")
+	forceMergeAuthKey = flagutil.NewPassword("forceMergeAuthKey", "authKey for starting forced merge via /storage/force_merge. "+
+		"It overrides -httpAuth.*; see https://docs.victoriametrics.com/victorialogs/#forced-merge")
+)
+
+// RequestHandler handles storage-related requests for VictoriaLogs
+func RequestHandler(w http.ResponseWriter, r *http.Request) bool {
+	path := strings.ReplaceAll(r.URL.Path, "//", "/")
+
+	if strings.HasPrefix(path, "/snapshot/") {
+		return processSnapshotRequest(w, r, path)
+	}
+
+	switch path {
+	case "/delete/run_task":
+		if !httpserver.CheckAuthFlag(w, r, deleteAuthKey) {
+			return true
+		}
+		deleteRunTaskRequests.Inc()
+		if err := processDeleteRunTask(w, r); err != nil {
+			deleteRunTaskErrors.Inc()
+			httpserver.Errorf(w, r, "%s", err)
+		}
+		return true
+	case "/delete/active_tasks":
+		if !httpserver.CheckAuthFlag(w, r, deleteAuthKey) {
+			return true
+		}
+		deleteActiveTasksRequests.Inc()
+		processDeleteActiveTasks(w)
+		return true
+	case "/storage/tenant_stats":
+		if !httpserver.CheckAuthFlag(w, r, tenantStatsAuthKey) {
+			return true
+		}
+		tenantStatsRequests.Inc()
+		processTenantStats(w)
+		return true
+	case "/storage/active_queries":
+		if !httpserver.CheckAuthFlag(w, r, activeQueriesAuthKey) {
+			return true
+		}
+		activeQueriesRequests.Inc()
+		processActiveQueries(w)
+		return true
+	case "/storage/cancel_query":
+		if !httpserver.CheckAuthFlag(w, r, activeQueriesAuthKey) {
+			return true
+		}
+		cancelQueryRequests.Inc()
+		if err := processCancelQuery(w, r); err != nil {
+			cancelQueryErrors.Inc()
+			httpserver.Errorf(w, r, "%s", err)
+		}
+		return true
+	case "/storage/partitions/detach":
+		if !httpserver.CheckAuthFlag(w, r, partitionsAuthKey) {
+			return true
+		}
+		partitionsDetachRequests.Inc()
+		if err := processPartitionsDetach(w, r); err != nil {
+			partitionsDetachErrors.Inc()
+			httpserver.Errorf(w, r, "%s", err)
+		}
+		return true
+	case "/storage/partitions/attach":
+		if !httpserver.CheckAuthFlag(w, r, partitionsAuthKey) {
+			return true
+		}
+		partitionsAttachRequests.Inc()
+		if err := processPartitionsAttach(w, r); err != nil {
+			partitionsAttachErrors.Inc()
+			httpserver.Errorf(w, r, "%s", err)
+		}
+		return true
+	case "/storage/partitions/list_detached":
+		if !httpserver.CheckAuthFlag(w, r, partitionsAuthKey) {
+			return true
+		}
+		partitionsListDetachedRequests.Inc()
+		if err := processPartitionsListDetached(w); err != nil {
+			partitionsListDetachedErrors.Inc()
+			httpserver.Errorf(w, r, "%s", err)
+		}
+		return true
+	case "/storage/force_merge":
+		if !httpserver.CheckAuthFlag(w, r, forceMergeAuthKey) {
+			return true
+		}
+		forceMergeRequests.Inc()
+		if err := processForceMerge(w, r); err != nil {
+			forceMergeErrors.Inc()
+			httpserver.Errorf(w, r, "%s", err)
+		}
+		return true
+	case "/internal/select/query":
+		if !httpserver.CheckAuthFlag(w, r, internalSelectAuthKey) {
+			return true
+		}
+		internalSelectQueryRequests.Inc()
+		if err := processInternalSelectQuery(w, r); err != nil {
+			internalSelectQueryErrors.Inc()
+			httpserver.Errorf(w, r, "%s", err)
+		}
+		return true
+	default:
+		return false
+	}
+}
+
 // Init initializes vlstorage.
 //
 // Stop must be called when vlstorage is no longer needed
@@ -50,14 +210,79 @@ func Init() {
 	if retentionPeriod.Duration() < 24*time.Hour {
 		logger.Fatalf("-retentionPeriod cannot be smaller than a day; got %s", retentionPeriod)
 	}
+	if n := *bloomFilterBitsPerToken; n < logstorage.MinBloomFilterBitsPerToken || n > logstorage.MaxBloomFilterBitsPerToken {
+		logger.Fatalf("-storage.bloomFilterBitsPerToken must be in the range [%d..%d]; got %d",
+			logstorage.MinBloomFilterBitsPerToken, logstorage.MaxBloomFilterBitsPerToken, n)
+	}
+	logstorage.SetBloomFilterBitsPerToken(*bloomFilterBitsPerToken)
+	if *mergeConcurrency < 0 {
+		logger.Fatalf("-storage.mergeConcurrency cannot be negative; got %d", *mergeConcurrency)
+	}
+	if *mergeConcurrency > 0 {
+		logstorage.SetMergeConcurrency(*mergeConcurrency)
+	}
+	if maxPartSize.N <= 0 {
+		logger.Fatalf("-storage.maxPartSize must be positive; got %d", maxPartSize.N)
+	}
+	logstorage.SetMaxPartSize(uint64(maxPartSize.N))
+	spillPath := *spillDir
+	if spillPath == "" {
+		spillPath = filepath.Join(*storageDataPath, "tmp", "spill")
+	}
+	logstorage.SetSpillDir(spillPath)
+	var rfs []*logstorage.RetentionFilter
+	for _, s := range *retentionFilters {
+		rf, err := logstorage.ParseRetentionFilter(s)
+		if err != nil {
+			logger.Fatalf("cannot parse -retention.filter=%q: %s", s, err)
+		}
+		rfs = append(rfs, rf)
+	}
+	if *tenantMaxRowsPerSecond < 0 {
+		logger.Fatalf("-tenant.maxRowsPerSecond cannot be negative; got %d", *tenantMaxRowsPerSecond)
+	}
+	if tenantMaxBytesPerSecond.N < 0 {
+		logger.Fatalf("-tenant.maxBytesPerSecond cannot be negative; got %d", tenantMaxBytesPerSecond.N)
+	}
+	if tenantMaxRetainedBytes.N < 0 {
+		logger.Fatalf("-tenant.maxRetainedBytes cannot be negative; got %d", tenantMaxRetainedBytes.N)
+	}
+	tl := logstorage.TenantLimits{
+		MaxRowsPerSecond:  uint64(*tenantMaxRowsPerSecond),
+		MaxBytesPerSecond: uint64(tenantMaxBytesPerSecond.N),
+		MaxRetainedBytes:  uint64(tenantMaxRetainedBytes.N),
+	}
+	var tlos []*logstorage.TenantLimitsOverride
+	for _, s := range *tenantLimitsOverrides {
+		tlo, err := logstorage.ParseTenantLimitsOverride(s, &tl)
+		if err != nil {
+			logger.Fatalf("cannot parse -tenant.limitsOverride=%q: %s", s, err)
+		}
+		tlos = append(tlos, tlo)
+	}
+	objectStorage = newObjectStorage()
 	cfg := &logstorage.StorageConfig{
 		Retention:              retentionPeriod.Duration(),
+		RetentionFilters:       rfs,
 		MaxDiskSpaceUsageBytes: maxDiskSpaceUsageBytes.N,
 		FlushInterval:          *inmemoryDataFlushInterval,
 		FutureRetention:        futureRetention.Duration(),
 		LogNewStreams:          *logNewStreams,
 		LogIngestedRows:        *logIngestedRows,
+		LogSlowQueryDuration:   *logSlowQueryDuration,
 		MinFreeDiskSpaceBytes:  minFreeDiskSpaceBytes.N,
+		UseZSTDDicts:           *useZSTDDicts,
+		TenantLimits:           tl,
+		TenantLimitsOverrides:  tlos,
+
+		DisableQueryResultsCache:         *disableCache,
+		QueryResultsCacheSizeBytes:       resultsCacheSize.N,
+		QueryResultsCacheTimestampOffset: *cacheTimestampOffset,
+		PersistQueryResultsCache:         *persistResultsCache,
+
+		ObjectStorage:               objectStorage,
+		ObjectStorageMinAge:         objectStorageMinAge.Duration(),
+		ObjectStorageCacheSizeBytes: objectStorageCacheSize.N,
 	}
 	logger.Infof("opening storage at -storageDataPath=%s", *storageDataPath)
 	startTime := time.Now()
@@ -76,6 +301,10 @@ func Init() {
 		writeStorageMetrics(w, strg)
 	})
 	metrics.RegisterSet(storageMetrics)
+
+	initStaleSnapshotsRemover(strg)
+
+	initStorageNodes()
 }
 
 // Stop stops vlstorage.
@@ -83,15 +312,22 @@ func Stop() {
 	metrics.UnregisterSet(storageMetrics, true)
 	storageMetrics = nil
 
+	stopStaleSnapshotsRemover()
+
 	strg.MustClose()
 	strg = nil
+
+	if objectStorage != nil {
+		objectStorage.MustStop()
+		objectStorage = nil
+	}
 }
 
 var strg *logstorage.Storage
 var storageMetrics *metrics.Set
 
-// CanWriteData returns non-nil error if it cannot write data to vlstorage.
-func CanWriteData() error {
+// CanWriteData returns non-nil error if it cannot write data for the given tenantID to vlstorage.
+func CanWriteData(tenantID logstorage.TenantID) error {
 	if strg.IsReadOnly() {
 		return &httpserver.ErrorWithStatusCode{
 			Err: fmt.Errorf("cannot add rows into storage in read-only mode; the storage can be in read-only mode "+
@@ -99,9 +335,20 @@ func CanWriteData() error {
 			StatusCode: http.StatusTooManyRequests,
 		}
 	}
+	if err := strg.CheckTenantLimits(tenantID); err != nil {
+		return &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("cannot add rows into storage: %w", err),
+			StatusCode: http.StatusTooManyRequests,
+		}
+	}
 	return nil
 }
 
+// GetStorageDataPath returns -storageDataPath.
+func GetStorageDataPath() string {
+	return *storageDataPath
+}
+
 // MustAddRows adds lr to vlstorage
 //
 // It is advised to call CanWriteData() before calling MustAddRows()
@@ -110,46 +357,88 @@ func MustAddRows(lr *logstorage.LogRows) {
 }
 
 // RunQuery runs the given q and calls writeBlock for the returned data blocks
+//
+// The query is executed at -storageNode addresses if they are set.
 func RunQuery(ctx context.Context, tenantIDs []logstorage.TenantID, q *logstorage.Query, writeBlock logstorage.WriteBlockFunc) error {
-	return strg.RunQuery(ctx, tenantIDs, q, writeBlock)
+	if len(storageNodes) > 0 {
+		err := logstorage.RunFederatedQuery(ctx, storageNodes, tenantIDs, q, writeBlock)
+		return convertQueryError(err)
+	}
+	err := strg.RunQuery(ctx, tenantIDs, q, writeBlock)
+	return convertQueryError(err)
 }
 
 // GetFieldNames executes q and returns field names seen in results.
 func GetFieldNames(ctx context.Context, tenantIDs []logstorage.TenantID, q *logstorage.Query) ([]logstorage.ValueWithHits, error) {
-	return strg.GetFieldNames(ctx, tenantIDs, q)
+	if err := checkLocalStorageQuery("field names"); err != nil {
+		return nil, err
+	}
+	results, err := strg.GetFieldNames(ctx, tenantIDs, q)
+	return results, convertQueryError(err)
 }
 
 // GetFieldValues executes q and returns unique values for the fieldName seen in results.
 //
 // If limit > 0, then up to limit unique values are returned.
 func GetFieldValues(ctx context.Context, tenantIDs []logstorage.TenantID, q *logstorage.Query, fieldName string, limit uint64) ([]logstorage.ValueWithHits, error) {
-	return strg.GetFieldValues(ctx, tenantIDs, q, fieldName, limit)
+	if err := checkLocalStorageQuery("field values"); err != nil {
+		return nil, err
+	}
+	results, err := strg.GetFieldValues(ctx, tenantIDs, q, fieldName, limit)
+	return results, convertQueryError(err)
 }
 
 // GetStreamFieldNames executes q and returns stream field names seen in results.
 func GetStreamFieldNames(ctx context.Context, tenantIDs []logstorage.TenantID, q *logstorage.Query) ([]logstorage.ValueWithHits, error) {
-	return strg.GetStreamFieldNames(ctx, tenantIDs, q)
+	if err := checkLocalStorageQuery("stream field names"); err != nil {
+		return nil, err
+	}
+	results, err := strg.GetStreamFieldNames(ctx, tenantIDs, q)
+	return results, convertQueryError(err)
 }
 
 // GetStreamFieldValues executes q and returns stream field values for the given fieldName seen in results.
 //
 // If limit > 0, then up to limit unique stream field values are returned.
 func GetStreamFieldValues(ctx context.Context, tenantIDs []logstorage.TenantID, q *logstorage.Query, fieldName string, limit uint64) ([]logstorage.ValueWithHits, error) {
-	return strg.GetStreamFieldValues(ctx, tenantIDs, q, fieldName, limit)
+	if err := checkLocalStorageQuery("stream field values"); err != nil {
+		return nil, err
+	}
+	results, err := strg.GetStreamFieldValues(ctx, tenantIDs, q, fieldName, limit)
+	return results, convertQueryError(err)
 }
 
 // GetStreams executes q and returns streams seen in query results.
 //
 // If limit > 0, then up to limit unique streams are returned.
 func GetStreams(ctx context.Context, tenantIDs []logstorage.TenantID, q *logstorage.Query, limit uint64) ([]logstorage.ValueWithHits, error) {
-	return strg.GetStreams(ctx, tenantIDs, q, limit)
+	if err := checkLocalStorageQuery("streams"); err != nil {
+		return nil, err
+	}
+	results, err := strg.GetStreams(ctx, tenantIDs, q, limit)
+	return results, convertQueryError(err)
 }
 
 // GetStreamIDs executes q and returns streamIDs seen in query results.
 //
 // If limit > 0, then up to limit unique streamIDs are returned.
 func GetStreamIDs(ctx context.Context, tenantIDs []logstorage.TenantID, q *logstorage.Query, limit uint64) ([]logstorage.ValueWithHits, error) {
-	return strg.GetStreamIDs(ctx, tenantIDs, q, limit)
+	if err := checkLocalStorageQuery("stream ids"); err != nil {
+		return nil, err
+	}
+	results, err := strg.GetStreamIDs(ctx, tenantIDs, q, limit)
+	return results, convertQueryError(err)
+}
+
+// convertQueryError returns an error with http.StatusServiceUnavailable status code if the query execution exceeded the timeout.
+func convertQueryError(err error) error {
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return &httpserver.ErrorWithStatusCode{
+		Err:        err,
+		StatusCode: http.StatusServiceUnavailable,
+	}
 }
 
 func writeStorageMetrics(w io.Writer, strg *logstorage.Storage) {
@@ -179,12 +468,25 @@ func writeStorageMetrics(w io.Writer, strg *logstorage.Storage) {
 	metrics.WriteGaugeUint64(w, `vl_storage_parts{type="storage/inmemory"}`, ss.InmemoryParts)
 	metrics.WriteGaugeUint64(w, `vl_storage_parts{type="storage/small"}`, ss.SmallParts)
 	metrics.WriteGaugeUint64(w, `vl_storage_parts{type="storage/big"}`, ss.BigParts)
+	metrics.WriteGaugeUint64(w, `vl_storage_parts{type="storage/object_storage"}`, ss.ObjectStorageParts)
 
 	metrics.WriteGaugeUint64(w, `vl_storage_blocks{type="storage/inmemory"}`, ss.InmemoryBlocks)
 	metrics.WriteGaugeUint64(w, `vl_storage_blocks{type="storage/small"}`, ss.SmallPartBlocks)
 	metrics.WriteGaugeUint64(w, `vl_storage_blocks{type="storage/big"}`, ss.BigPartBlocks)
 
 	metrics.WriteGaugeUint64(w, `vl_partitions`, ss.PartitionsCount)
+	metrics.WriteGaugeUint64(w, `vl_delete_tasks`, ss.DeleteTasksCount)
+
+	metrics.WriteCounterUint64(w, `vl_cache_requests_total{type="query_results"}`, ss.QueryResultsCacheRequests)
+	metrics.WriteCounterUint64(w, `vl_cache_misses_total{type="query_results"}`, ss.QueryResultsCacheMisses)
+	metrics.WriteGaugeUint64(w, `vl_cache_entries{type="query_results"}`, ss.QueryResultsCacheEntries)
+	metrics.WriteGaugeUint64(w, `vl_cache_size_bytes{type="query_results"}`, ss.QueryResultsCacheSizeBytes)
+	metrics.WriteGaugeUint64(w, `vl_cache_size_max_bytes{type="query_results"}`, ss.QueryResultsCacheMaxSizeBytes)
+	metrics.WriteCounterUint64(w, `vl_cache_requests_total{type="object_storage"}`, ss.ObjectStorageCacheRequests)
+	metrics.WriteCounterUint64(w, `vl_cache_misses_total{type="object_storage"}`, ss.ObjectStorageCacheMisses)
+	metrics.WriteGaugeUint64(w, `vl_cache_entries{type="object_storage"}`, ss.ObjectStorageCacheEntries)
+	metrics.WriteGaugeUint64(w, `vl_cache_size_bytes{type="object_storage"}`, ss.ObjectStorageCacheSizeBytes)
+	metrics.WriteGaugeUint64(w, `vl_cache_size_max_bytes{type="object_storage"}`, ss.ObjectStorageCacheMaxSizeBytes)
 	metrics.WriteCounterUint64(w, `vl_streams_created_total`, ss.StreamsCreatedTotal)
 
 	metrics.WriteGaugeUint64(w, `vl_indexdb_rows`, ss.IndexdbItemsCount)
@@ -197,11 +499,37 @@ func writeStorageMetrics(w io.Writer, strg *logstorage.Storage) {
 	metrics.WriteGaugeUint64(w, `vl_compressed_data_size_bytes{type="storage/inmemory"}`, ss.CompressedInmemorySize)
 	metrics.WriteGaugeUint64(w, `vl_compressed_data_size_bytes{type="storage/small"}`, ss.CompressedSmallPartSize)
 	metrics.WriteGaugeUint64(w, `vl_compressed_data_size_bytes{type="storage/big"}`, ss.CompressedBigPartSize)
+	metrics.WriteGaugeUint64(w, `vl_compressed_data_size_bytes{type="storage/object_storage"}`, ss.CompressedObjectStoragePartSize)
 
 	metrics.WriteGaugeUint64(w, `vl_uncompressed_data_size_bytes{type="storage/inmemory"}`, ss.UncompressedInmemorySize)
 	metrics.WriteGaugeUint64(w, `vl_uncompressed_data_size_bytes{type="storage/small"}`, ss.UncompressedSmallPartSize)
 	metrics.WriteGaugeUint64(w, `vl_uncompressed_data_size_bytes{type="storage/big"}`, ss.UncompressedBigPartSize)
 
+	metrics.WriteCounterUint64(w, `vl_object_storage_uploaded_bytes_total`, ss.ObjectStorageUploadedBytes)
+	metrics.WriteCounterUint64(w, `vl_object_storage_downloaded_bytes_total`, ss.ObjectStorageDownloadedBytes)
+
 	metrics.WriteCounterUint64(w, `vl_rows_dropped_total{reason="too_big_timestamp"}`, ss.RowsDroppedTooBigTimestamp)
 	metrics.WriteCounterUint64(w, `vl_rows_dropped_total{reason="too_small_timestamp"}`, ss.RowsDroppedTooSmallTimestamp)
+	metrics.WriteCounterUint64(w, `vl_rows_dropped_total{reason="tenant_limits"}`, ss.RowsDroppedTenantLimits)
+
+	metrics.WriteGaugeUint64(w, `vl_active_queries`, ss.ActiveQueries)
+	metrics.WriteCounterUint64(w, `vl_queries_aborted_total{reason="scan_limits"}`, ss.QueriesAbortedScanLimits)
+	metrics.WriteCounterUint64(w, `vl_queries_aborted_total{reason="memory_limit"}`, ss.QueriesAbortedMemoryLimit)
+	metrics.WriteCounterUint64(w, `vl_queries_aborted_total{reason="timeout"}`, ss.QueriesAbortedTimeout)
+	metrics.WriteCounterUint64(w, `vl_queries_aborted_total{reason="canceled"}`, ss.QueriesAbortedCanceled)
+	metrics.WriteCounterUint64(w, `vl_stats_pipe_groups_total`, ss.StatsPipeGroups)
+	metrics.WriteCounterUint64(w, `vl_slow_queries_total`, ss.SlowQueries)
+
+	writeTenantStatsMetrics(w, strg)
+	writePipeMetrics(w, strg)
+}
+
+func writePipeMetrics(w io.Writer, strg *logstorage.Storage) {
+	for _, pm := range strg.GetPipeMetrics() {
+		labels := fmt.Sprintf(`pipe=%q`, pm.Name)
+		metrics.WriteCounterUint64(w, fmt.Sprintf(`vl_pipe_input_rows_total{%s}`, labels), pm.InputRows)
+		metrics.WriteCounterUint64(w, fmt.Sprintf(`vl_pipe_output_rows_total{%s}`, labels), pm.OutputRows)
+		metrics.WriteCounterUint64(w, fmt.Sprintf(`vl_pipe_flushes_total{%s}`, labels), pm.Flushes)
+		metrics.WriteCounterFloat64(w, fmt.Sprintf(`vl_pipe_flush_duration_seconds_total{%s}`, labels), pm.FlushDurationSeconds)
+	}
 }
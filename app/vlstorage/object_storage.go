@@ -0,0 +1,102 @@
+package vlstorage
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/backup/common"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/backup/fsremote"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/backup/s3remote"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+var (
+	objectStorageURL = flag.String("storage.objectStorage.url", "", "Optional URL of object storage for moving data of old per-day partitions to. "+
+		"Supported schemes: s3://bucket/dir for S3-compatible object storage and fs:///abs/path for local filesystem. "+
+		"See https://docs.victoriametrics.com/victorialogs/#object-storage-tiering")
+	objectStorageMinAge = flagutil.NewDuration("storage.objectStorage.minAge", "7d", "Data for per-day partitions older than -storage.objectStorage.minAge "+
+		"is moved to -storage.objectStorage.url; see https://docs.victoriametrics.com/victorialogs/#object-storage-tiering")
+	objectStorageCacheSize = flagutil.NewBytes("storage.objectStorage.cacheSize", 1024*1024*1024, "The maximum size of the local cache at -storageDataPath "+
+		"for data blocks read from -storage.objectStorage.url; see https://docs.victoriametrics.com/victorialogs/#object-storage-tiering")
+
+	objectStorageCredsFilePath = flag.String("storage.objectStorage.credsFilePath", "", "Path to file with S3 credentials for -storage.objectStorage.url. "+
+		"Credentials are loaded from default locations if not set. See https://docs.aws.amazon.com/general/latest/gr/aws-security-credentials.html")
+	objectStorageConfigFilePath = flag.String("storage.objectStorage.configFilePath", "", "Path to file with S3 configs for -storage.objectStorage.url. "+
+		"Configs are loaded from default location if not set. See https://docs.aws.amazon.com/general/latest/gr/aws-security-credentials.html")
+	objectStorageConfigProfile = flag.String("storage.objectStorage.configProfile", "", "Profile name for S3 configs for -storage.objectStorage.url. "+
+		"If no set, the value of the environment variable will be loaded (AWS_PROFILE or AWS_DEFAULT_PROFILE), or if both not set, DefaultSharedConfigProfile is used")
+	objectStorageCustomS3Endpoint = flag.String("storage.objectStorage.customS3Endpoint", "", "Custom S3 endpoint for use with S3-compatible storages (e.g. MinIO) "+
+		"for -storage.objectStorage.url. S3 is used if not set")
+	objectStorageS3ForcePathStyle        = flag.Bool("storage.objectStorage.s3ForcePathStyle", true, "Prefixing endpoint with bucket name when set false, true by default")
+	objectStorageS3TLSInsecureSkipVerify = flag.Bool("storage.objectStorage.s3TLSInsecureSkipVerify", false, "Whether to skip TLS verification when connecting to "+
+		"the S3 endpoint for -storage.objectStorage.url")
+)
+
+// objectStorage is the object storage for -storage.objectStorage.url. It is nil if -storage.objectStorage.url isn't set.
+var objectStorage common.RemoteFS
+
+// newObjectStorage returns object storage for -storage.objectStorage.url.
+//
+// nil is returned if -storage.objectStorage.url isn't set.
+// The returned object storage must be stopped with MustStop when it is no longer needed.
+func newObjectStorage() common.RemoteFS {
+	if *objectStorageURL == "" {
+		return nil
+	}
+	if objectStorageMinAge.Duration() <= 0 {
+		logger.Fatalf("-storage.objectStorage.minAge must be positive; got %s", objectStorageMinAge)
+	}
+	if objectStorageCacheSize.N <= 0 {
+		logger.Fatalf("-storage.objectStorage.cacheSize must be positive; got %d", objectStorageCacheSize.N)
+	}
+	remoteFS, err := newRemoteFS(*objectStorageURL)
+	if err != nil {
+		logger.Fatalf("cannot initialize -storage.objectStorage.url=%q: %s", *objectStorageURL, err)
+	}
+	return remoteFS
+}
+
+func newRemoteFS(url string) (common.RemoteFS, error) {
+	n := strings.Index(url, "://")
+	if n < 0 {
+		return nil, fmt.Errorf("missing scheme; supported schemes: `s3://`, `fs://`")
+	}
+	scheme := url[:n]
+	dir := url[n+len("://"):]
+	switch scheme {
+	case "fs":
+		if !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("dir must be absolute; got %q", dir)
+		}
+		fs := &fsremote.FS{
+			Dir: filepath.Clean(dir),
+		}
+		return fs, nil
+	case "s3":
+		n := strings.Index(dir, "/")
+		if n < 0 {
+			return nil, fmt.Errorf("missing directory on the s3 bucket %q", dir)
+		}
+		bucket := dir[:n]
+		dir = dir[n:]
+		fs := &s3remote.FS{
+			CredsFilePath:         *objectStorageCredsFilePath,
+			ConfigFilePath:        *objectStorageConfigFilePath,
+			CustomEndpoint:        *objectStorageCustomS3Endpoint,
+			TLSInsecureSkipVerify: *objectStorageS3TLSInsecureSkipVerify,
+			S3ForcePathStyle:      *objectStorageS3ForcePathStyle,
+			ProfileName:           *objectStorageConfigProfile,
+			Bucket:                bucket,
+			Dir:                   dir,
+		}
+		if err := fs.Init(); err != nil {
+			return nil, fmt.Errorf("cannot initialize connection to s3: %w", err)
+		}
+		return fs, nil
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q; supported schemes: `s3://`, `fs://`", scheme)
+	}
+}
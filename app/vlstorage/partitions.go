@@ -0,0 +1,78 @@
+package vlstorage
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/stringsutil"
+)
+
+var (
+	partitionsDetachRequests       = metrics.NewCounter(`vl_http_requests_total{path="/storage/partitions/detach"}`)
+	partitionsDetachErrors         = metrics.NewCounter(`vl_http_request_errors_total{path="/storage/partitions/detach"}`)
+	partitionsAttachRequests       = metrics.NewCounter(`vl_http_requests_total{path="/storage/partitions/attach"}`)
+	partitionsAttachErrors         = metrics.NewCounter(`vl_http_request_errors_total{path="/storage/partitions/attach"}`)
+	partitionsListDetachedRequests = metrics.NewCounter(`vl_http_requests_total{path="/storage/partitions/list_detached"}`)
+	partitionsListDetachedErrors   = metrics.NewCounter(`vl_http_request_errors_total{path="/storage/partitions/list_detached"}`)
+)
+
+// processPartitionsDetach detaches the partition with the name from the `name` query arg.
+func processPartitionsDetach(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("unsupported method %q; use POST", r.Method),
+			StatusCode: http.StatusMethodNotAllowed,
+		}
+	}
+	name := r.FormValue("name")
+	if name == "" {
+		return fmt.Errorf("missing `name` query arg")
+	}
+	if err := strg.DetachPartition(name); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status":"ok"}`)
+	return nil
+}
+
+// processPartitionsAttach attaches the detached partition with the name from the `name` query arg.
+func processPartitionsAttach(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("unsupported method %q; use POST", r.Method),
+			StatusCode: http.StatusMethodNotAllowed,
+		}
+	}
+	name := r.FormValue("name")
+	if name == "" {
+		return fmt.Errorf("missing `name` query arg")
+	}
+	if err := strg.AttachPartition(name); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status":"ok"}`)
+	return nil
+}
+
+// processPartitionsListDetached writes the names of the detached partitions to w.
+func processPartitionsListDetached(w http.ResponseWriter) error {
+	names, err := strg.ListDetachedPartitions()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status":"ok","partitions":[`)
+	for i, name := range names {
+		if i > 0 {
+			fmt.Fprintf(w, ",")
+		}
+		fmt.Fprintf(w, "%s", stringsutil.JSONString(name))
+	}
+	fmt.Fprintf(w, `]}`)
+	return nil
+}
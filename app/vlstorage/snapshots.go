@@ -0,0 +1,159 @@
+package vlstorage
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/stringsutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timeutil"
+)
+
+var (
+	snapshotAuthKey = flagutil.NewPassword("snapshotAuthKey", "authKey, which must be passed in query string to /snapshot* pages. "+
+		"It overrides -httpAuth.*; see https://docs.victoriametrics.com/victorialogs/#backup-and-restore")
+	snapshotsMaxAge = flagutil.NewDuration("snapshotsMaxAge", "0", "Automatically delete snapshots older than -snapshotsMaxAge if it is set to non-zero duration. "+
+		"Make sure that backup process has enough time to finish the backup before the corresponding snapshot is automatically deleted")
+)
+
+var (
+	snapshotsCreateTotal       = metrics.NewCounter(`vl_http_requests_total{path="/snapshot/create"}`)
+	snapshotsCreateErrorsTotal = metrics.NewCounter(`vl_http_request_errors_total{path="/snapshot/create"}`)
+
+	snapshotsListTotal       = metrics.NewCounter(`vl_http_requests_total{path="/snapshot/list"}`)
+	snapshotsListErrorsTotal = metrics.NewCounter(`vl_http_request_errors_total{path="/snapshot/list"}`)
+
+	snapshotsDeleteTotal       = metrics.NewCounter(`vl_http_requests_total{path="/snapshot/delete"}`)
+	snapshotsDeleteErrorsTotal = metrics.NewCounter(`vl_http_request_errors_total{path="/snapshot/delete"}`)
+
+	snapshotsDeleteAllTotal       = metrics.NewCounter(`vl_http_requests_total{path="/snapshot/delete_all"}`)
+	snapshotsDeleteAllErrorsTotal = metrics.NewCounter(`vl_http_request_errors_total{path="/snapshot/delete_all"}`)
+)
+
+// processSnapshotRequest handles /snapshot/* requests.
+//
+// The responses are compatible with vmstorage, so vmbackup can be used for making backups of VictoriaLogs data.
+// See https://docs.victoriametrics.com/vmbackup/
+func processSnapshotRequest(w http.ResponseWriter, r *http.Request, path string) bool {
+	if !httpserver.CheckAuthFlag(w, r, snapshotAuthKey) {
+		return true
+	}
+	path = path[len("/snapshot"):]
+
+	switch path {
+	case "/create":
+		snapshotsCreateTotal.Inc()
+		w.Header().Set("Content-Type", "application/json")
+		snapshotName, err := strg.CreateSnapshot()
+		if err != nil {
+			err = fmt.Errorf("cannot create snapshot: %w", err)
+			jsonResponseError(w, err)
+			snapshotsCreateErrorsTotal.Inc()
+			return true
+		}
+		fmt.Fprintf(w, `{"status":"ok","snapshot":%s}`, stringsutil.JSONString(snapshotName))
+		return true
+	case "/list":
+		snapshotsListTotal.Inc()
+		w.Header().Set("Content-Type", "application/json")
+		snapshots, err := strg.ListSnapshots()
+		if err != nil {
+			err = fmt.Errorf("cannot list snapshots: %w", err)
+			jsonResponseError(w, err)
+			snapshotsListErrorsTotal.Inc()
+			return true
+		}
+		fmt.Fprintf(w, `{"status":"ok","snapshots":[`)
+		for i, snapshotName := range snapshots {
+			if i > 0 {
+				fmt.Fprintf(w, ",")
+			}
+			fmt.Fprintf(w, "\n%s", stringsutil.JSONString(snapshotName))
+		}
+		fmt.Fprintf(w, `]}`)
+		return true
+	case "/delete":
+		snapshotsDeleteTotal.Inc()
+		w.Header().Set("Content-Type", "application/json")
+		snapshotName := r.FormValue("snapshot")
+		if err := strg.DeleteSnapshot(snapshotName); err != nil {
+			err = fmt.Errorf("cannot delete snapshot %q: %w", snapshotName, err)
+			jsonResponseError(w, err)
+			snapshotsDeleteErrorsTotal.Inc()
+			return true
+		}
+		fmt.Fprintf(w, `{"status":"ok"}`)
+		return true
+	case "/delete_all":
+		snapshotsDeleteAllTotal.Inc()
+		w.Header().Set("Content-Type", "application/json")
+		snapshots, err := strg.ListSnapshots()
+		if err != nil {
+			err = fmt.Errorf("cannot list snapshots: %w", err)
+			jsonResponseError(w, err)
+			snapshotsDeleteAllErrorsTotal.Inc()
+			return true
+		}
+		for _, snapshotName := range snapshots {
+			if err := strg.DeleteSnapshot(snapshotName); err != nil {
+				err = fmt.Errorf("cannot delete snapshot %q: %w", snapshotName, err)
+				jsonResponseError(w, err)
+				snapshotsDeleteAllErrorsTotal.Inc()
+				return true
+			}
+		}
+		fmt.Fprintf(w, `{"status":"ok"}`)
+		return true
+	default:
+		return false
+	}
+}
+
+func jsonResponseError(w http.ResponseWriter, err error) {
+	logger.Errorf("%s", err)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, `{"status":"error","msg":%s}`, stringsutil.JSONString(err.Error()))
+}
+
+func initStaleSnapshotsRemover(strg *logstorage.Storage) {
+	staleSnapshotsRemoverCh = make(chan struct{})
+	if snapshotsMaxAge.Duration() <= 0 {
+		return
+	}
+	snapshotsMaxAgeDur := snapshotsMaxAge.Duration()
+	staleSnapshotsRemoverWG.Add(1)
+	go func() {
+		defer staleSnapshotsRemoverWG.Done()
+		d := timeutil.AddJitterToDuration(time.Second * 11)
+		t := time.NewTicker(d)
+		defer t.Stop()
+		for {
+			select {
+			case <-staleSnapshotsRemoverCh:
+				return
+			case <-t.C:
+			}
+			if err := strg.DeleteStaleSnapshots(snapshotsMaxAgeDur); err != nil {
+				// Use logger.Errorf instead of logger.Fatalf in the hope the error is temporary.
+				logger.Errorf("cannot delete stale snapshots: %s", err)
+			}
+		}
+	}()
+}
+
+func stopStaleSnapshotsRemover() {
+	close(staleSnapshotsRemoverCh)
+	staleSnapshotsRemoverWG.Wait()
+}
+
+var (
+	staleSnapshotsRemoverCh chan struct{}
+	staleSnapshotsRemoverWG sync.WaitGroup
+)
@@ -0,0 +1,158 @@
+package vlstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+var (
+	storageNodeAddrs = flagutil.NewArrayString("storageNode", "Optional addresses of VictoriaLogs storage nodes in the form host:port or http://host:port. "+
+		"If set, queries are executed at the given storage nodes and their results are merged instead of querying the local storage; "+
+		"see https://docs.victoriametrics.com/victorialogs/querying/#query-federation")
+	storageNodeAuthKey = flagutil.NewPassword("storageNode.authKey", "authKey to send to -storageNode addresses. It must match -internalSelectAuthKey at the storage nodes; "+
+		"see https://docs.victoriametrics.com/victorialogs/querying/#query-federation")
+	internalSelectAuthKey = flagutil.NewPassword("internalSelectAuthKey", "authKey for executing queries from other VictoriaLogs instances via /internal/select/query. "+
+		"It overrides -httpAuth.*; see https://docs.victoriametrics.com/victorialogs/querying/#query-federation")
+)
+
+var (
+	internalSelectQueryRequests = metrics.NewCounter(`vl_http_requests_total{path="/internal/select/query"}`)
+	internalSelectQueryErrors   = metrics.NewCounter(`vl_http_request_errors_total{path="/internal/select/query"}`)
+)
+
+// maxInternalSelectRequestSize is the maximum size of the request body at /internal/select/query.
+const maxInternalSelectRequestSize = 64 * 1024 * 1024
+
+// storageNodes contains storage nodes from -storageNode.
+//
+// It is initialized by initStorageNodes.
+var storageNodes []logstorage.RemoteStorageNode
+
+func initStorageNodes() {
+	storageNodes = nil
+	for _, addr := range *storageNodeAddrs {
+		if addr == "" {
+			logger.Fatalf("-storageNode cannot be empty")
+		}
+		storageNodes = append(storageNodes, newStorageNode(addr))
+	}
+}
+
+// checkLocalStorageQuery returns an error if the query for the given kind of results cannot be executed, since -storageNode is set.
+//
+// Only queries executed via RunQuery are sent to -storageNode addresses, while other queries can be executed only at the local storage.
+func checkLocalStorageQuery(kind string) error {
+	if len(storageNodes) == 0 {
+		return nil
+	}
+	return &httpserver.ErrorWithStatusCode{
+		Err:        fmt.Errorf("cannot obtain %s when -storageNode is set; use /select/logsql/query with the corresponding pipe instead; see https://docs.victoriametrics.com/victorialogs/querying/#query-federation", kind),
+		StatusCode: http.StatusNotImplemented,
+	}
+}
+
+// storageNode executes queries at the remote VictoriaLogs storage node via /internal/select/query.
+type storageNode struct {
+	// addr is the address of the storage node from -storageNode.
+	addr string
+
+	// queryURL is the url for sending queries to the storage node.
+	queryURL string
+
+	client *http.Client
+
+	requestsTotal *metrics.Counter
+	errorsTotal   *metrics.Counter
+}
+
+func newStorageNode(addr string) *storageNode {
+	baseURL := addr
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "http://" + baseURL
+	}
+	queryURL := strings.TrimSuffix(baseURL, "/") + "/internal/select/query"
+	if authKey := storageNodeAuthKey.Get(); authKey != "" {
+		queryURL += "?authKey=" + url.QueryEscape(authKey)
+	}
+	return &storageNode{
+		addr:     addr,
+		queryURL: queryURL,
+
+		// Do not set the timeout for the client, since the query duration is limited by the context passed to RunRemoteQuery.
+		client: &http.Client{},
+
+		requestsTotal: metrics.NewCounter(fmt.Sprintf(`vl_storage_node_requests_total{addr=%q}`, addr)),
+		errorsTotal:   metrics.NewCounter(fmt.Sprintf(`vl_storage_node_request_errors_total{addr=%q}`, addr)),
+	}
+}
+
+// String implements logstorage.RemoteStorageNode interface.
+func (sn *storageNode) String() string {
+	return sn.addr
+}
+
+// RunRemoteQuery implements logstorage.RemoteStorageNode interface.
+func (sn *storageNode) RunRemoteQuery(ctx context.Context, reqData []byte) (io.ReadCloser, error) {
+	sn.requestsTotal.Inc()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sn.queryURL, bytes.NewReader(reqData))
+	if err != nil {
+		logger.Panicf("BUG: cannot create request to -storageNode=%q: %s", sn.addr, err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := sn.client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			// Do not count requests canceled by the caller as errors.
+			sn.errorsTotal.Inc()
+		}
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			// Do not expose -storageNode.authKey from the request url in the error message.
+			err = ue.Err
+		}
+		return nil, fmt.Errorf("cannot send request to /internal/select/query: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		_ = resp.Body.Close()
+		sn.errorsTotal.Inc()
+		return nil, fmt.Errorf("unexpected status code returned from /internal/select/query: %d; response body: %q", resp.StatusCode, body)
+	}
+	return resp.Body, nil
+}
+
+// processInternalSelectQuery executes the query sent by logstorage.RunFederatedQuery from another VictoriaLogs instance.
+func processInternalSelectQuery(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("unsupported method %q; use POST", r.Method),
+			StatusCode: http.StatusMethodNotAllowed,
+		}
+	}
+
+	reqData, err := io.ReadAll(io.LimitReader(r.Body, maxInternalSelectRequestSize+1))
+	if err != nil {
+		return fmt.Errorf("cannot read request body: %w", err)
+	}
+	if len(reqData) > maxInternalSelectRequestSize {
+		return fmt.Errorf("too big request body; it mustn't exceed %d bytes", maxInternalSelectRequestSize)
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	return strg.RunRemoteQuery(r.Context(), reqData, w)
+}
@@ -0,0 +1,86 @@
+package vlstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+var tenantStatsRequests = metrics.NewCounter(`vl_http_requests_total{path="/storage/tenant_stats"}`)
+
+// tenantStatsJSON is JSON representation for logstorage.TenantStats returned from /storage/tenant_stats.
+type tenantStatsJSON struct {
+	AccountID             uint32 `json:"account_id"`
+	ProjectID             uint32 `json:"project_id"`
+	IngestedRows          uint64 `json:"ingested_rows"`
+	IngestedBytes         uint64 `json:"ingested_bytes"`
+	StoredRows            uint64 `json:"stored_rows"`
+	StoredBytes           uint64 `json:"stored_bytes"`
+	StoredCompressedBytes uint64 `json:"stored_compressed_bytes"`
+
+	Rejected []tenantRejectedStatsJSON `json:"rejected,omitempty"`
+}
+
+// tenantRejectedStatsJSON is JSON representation for logstorage.TenantRejectedStats returned from /storage/tenant_stats.
+type tenantRejectedStatsJSON struct {
+	Reason   string `json:"reason"`
+	Requests uint64 `json:"requests"`
+	Rows     uint64 `json:"rows"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+// processTenantStats writes per-tenant storage usage stats to w.
+func processTenantStats(w http.ResponseWriter) {
+	tss := strg.GetTenantStats()
+	tenants := make([]tenantStatsJSON, len(tss))
+	for i, ts := range tss {
+		tenants[i] = tenantStatsJSON{
+			AccountID:             ts.TenantID.AccountID,
+			ProjectID:             ts.TenantID.ProjectID,
+			IngestedRows:          ts.IngestedRows,
+			IngestedBytes:         ts.IngestedBytes,
+			StoredRows:            ts.RowsCount,
+			StoredBytes:           ts.UncompressedSizeBytes,
+			StoredCompressedBytes: ts.CompressedSizeBytes,
+		}
+		for _, rts := range ts.Rejected {
+			tenants[i].Rejected = append(tenants[i].Rejected, tenantRejectedStatsJSON{
+				Reason:   rts.Reason,
+				Requests: rts.Requests,
+				Rows:     rts.Rows,
+				Bytes:    rts.Bytes,
+			})
+		}
+	}
+	data, err := json.Marshal(map[string]any{
+		"tenants": tenants,
+	})
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal tenant stats: %s", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+func writeTenantStatsMetrics(w io.Writer, strg *logstorage.Storage) {
+	for _, ts := range strg.GetTenantStats() {
+		labels := fmt.Sprintf(`accountID="%d",projectID="%d"`, ts.TenantID.AccountID, ts.TenantID.ProjectID)
+		metrics.WriteCounterUint64(w, fmt.Sprintf(`vl_tenant_ingested_rows_total{%s}`, labels), ts.IngestedRows)
+		metrics.WriteCounterUint64(w, fmt.Sprintf(`vl_tenant_ingested_bytes_total{%s}`, labels), ts.IngestedBytes)
+		metrics.WriteGaugeUint64(w, fmt.Sprintf(`vl_tenant_storage_rows{%s}`, labels), ts.RowsCount)
+		metrics.WriteGaugeUint64(w, fmt.Sprintf(`vl_tenant_uncompressed_data_size_bytes{%s}`, labels), ts.UncompressedSizeBytes)
+		metrics.WriteGaugeUint64(w, fmt.Sprintf(`vl_tenant_compressed_data_size_bytes{%s}`, labels), ts.CompressedSizeBytes)
+		for _, rts := range ts.Rejected {
+			rejectedLabels := fmt.Sprintf(`%s,reason=%q`, labels, rts.Reason)
+			metrics.WriteCounterUint64(w, fmt.Sprintf(`vl_tenant_rejected_requests_total{%s}`, rejectedLabels), rts.Requests)
+			metrics.WriteCounterUint64(w, fmt.Sprintf(`vl_tenant_rejected_rows_total{%s}`, rejectedLabels), rts.Rows)
+			metrics.WriteCounterUint64(w, fmt.Sprintf(`vl_tenant_rejected_bytes_total{%s}`, rejectedLabels), rts.Bytes)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package vlstreamaggr
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/envtemplate"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs/fscore"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promutils"
+)
+
+// Config is a configuration for a single stream aggregation rule.
+//
+// See https://docs.victoriametrics.com/victorialogs/#stream-aggregation
+type Config struct {
+	// Name is the name of the rule.
+	//
+	// It is used as a prefix for metric names and as `_aggr_rule` field value for the generated log entries.
+	Name string `yaml:"name"`
+
+	// Query is LogsQL query, which must end with `stats` pipe.
+	Query string `yaml:"query"`
+
+	// Interval is the interval between query executions.
+	//
+	// Every execution processes log entries with timestamps on the [start ... start+Interval) time range.
+	Interval string `yaml:"interval"`
+
+	// Delay is an optional delay for the query execution after the end of the processed time range.
+	//
+	// It allows processing log entries, which are ingested with some delay. By default it is 30s.
+	Delay string `yaml:"delay,omitempty"`
+
+	// Tenant is an optional tenant in the form `accountID:projectID` to run the Query at. By default it is 0:0.
+	Tenant string `yaml:"tenant,omitempty"`
+
+	// Output is an optional output for the aggregates. It can be `logs` or `metrics`. By default it is `logs`.
+	Output string `yaml:"output,omitempty"`
+
+	// OutputTenant is an optional tenant in the form `accountID:projectID` for the generated log entries. By default it is Tenant.
+	OutputTenant string `yaml:"output_tenant,omitempty"`
+}
+
+const (
+	outputLogs    = "logs"
+	outputMetrics = "metrics"
+)
+
+const defaultDelay = 30 * time.Second
+
+var ruleNameRegexp = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+func loadFromFile(path string) ([]*rule, error) {
+	data, err := fscore.ReadFileOrHTTP(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load stream aggregation config: %w", err)
+	}
+	data, err = envtemplate.ReplaceBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot expand environment variables: %w", err)
+	}
+	return loadFromData(data)
+}
+
+func loadFromData(data []byte) ([]*rule, error) {
+	var cfgs []*Config
+	if err := yaml.UnmarshalStrict(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("cannot parse stream aggregation config: %w", err)
+	}
+
+	rules := make([]*rule, 0, len(cfgs))
+	names := make(map[string]struct{}, len(cfgs))
+	for i, cfg := range cfgs {
+		r, err := newRule(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("cannot initialize rule #%d: %w", i+1, err)
+		}
+		if _, ok := names[r.name]; ok {
+			return nil, fmt.Errorf("duplicate rule name %q", r.name)
+		}
+		names[r.name] = struct{}{}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func newRule(cfg *Config) (*rule, error) {
+	if !ruleNameRegexp.MatchString(cfg.Name) {
+		return nil, fmt.Errorf("name %q must match %s", cfg.Name, ruleNameRegexp)
+	}
+
+	if cfg.Query == "" {
+		return nil, fmt.Errorf("missing query for the rule %q", cfg.Name)
+	}
+	q, err := logstorage.ParseQuery(cfg.Query)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse query for the rule %q: %w", cfg.Name, err)
+	}
+	byFields, err := q.GetStatsByFields()
+	if err != nil {
+		return nil, fmt.Errorf("unsupported query for the rule %q: %w", cfg.Name, err)
+	}
+
+	if cfg.Interval == "" {
+		return nil, fmt.Errorf("missing interval for the rule %q", cfg.Name)
+	}
+	interval, err := promutils.ParseDuration(cfg.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse interval for the rule %q: %w", cfg.Name, err)
+	}
+	if interval < time.Second {
+		return nil, fmt.Errorf("interval for the rule %q cannot be smaller than 1s; got %s", cfg.Name, cfg.Interval)
+	}
+
+	delay := defaultDelay
+	if cfg.Delay != "" {
+		delay, err = promutils.ParseDuration(cfg.Delay)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse delay for the rule %q: %w", cfg.Name, err)
+		}
+		if delay < 0 {
+			return nil, fmt.Errorf("delay for the rule %q cannot be negative; got %s", cfg.Name, cfg.Delay)
+		}
+	}
+
+	tenantID, err := logstorage.ParseTenantID(cfg.Tenant)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse tenant for the rule %q: %w", cfg.Name, err)
+	}
+
+	output := cfg.Output
+	switch output {
+	case "":
+		output = outputLogs
+	case outputLogs, outputMetrics:
+	default:
+		return nil, fmt.Errorf("unsupported output %q for the rule %q; supported values: %s, %s", output, cfg.Name, outputLogs, outputMetrics)
+	}
+
+	outputTenantID := tenantID
+	if cfg.OutputTenant != "" {
+		if output != outputLogs {
+			return nil, fmt.Errorf("output_tenant for the rule %q can be set only for output: %s", cfg.Name, outputLogs)
+		}
+		outputTenantID, err = logstorage.ParseTenantID(cfg.OutputTenant)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse output_tenant for the rule %q: %w", cfg.Name, err)
+		}
+	}
+
+	return newRuleInternal(cfg.Name, q, byFields, interval, delay, tenantID, output, outputTenantID), nil
+}
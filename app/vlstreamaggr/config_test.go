@@ -0,0 +1,172 @@
+package vlstreamaggr
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+func TestLoadFromDataSuccess(t *testing.T) {
+	data := `
+- name: errors_by_service
+  query: 'level:error | stats by (_time:1m, service) count() errors'
+  interval: 5m
+- name: requests
+  query: '* | stats by (host) count() hits, avg(duration) avg_duration'
+  interval: 30s
+  delay: 1m
+  tenant: "12:34"
+  output: metrics
+- name: summary
+  query: '* | stats count() hits'
+  interval: 1h
+  tenant: "1:0"
+  output_tenant: "2:0"
+`
+	rules, err := loadFromData([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("unexpected number of rules; got %d; want 3", len(rules))
+	}
+
+	r := rules[0]
+	if r.name != "errors_by_service" || r.interval != 5*time.Minute || r.delay != defaultDelay || r.output != outputLogs {
+		t.Fatalf("unexpected rule #1: name=%q, interval=%s, delay=%s, output=%q", r.name, r.interval, r.delay, r.output)
+	}
+	if !reflect.DeepEqual(r.byFields, []string{"_time", "service"}) {
+		t.Fatalf("unexpected byFields for rule #1: %q", r.byFields)
+	}
+	if !reflect.DeepEqual(r.streamFields, []string{"_aggr_rule", "service"}) {
+		t.Fatalf("unexpected streamFields for rule #1: %q", r.streamFields)
+	}
+
+	r = rules[1]
+	tenantID := logstorage.TenantID{
+		AccountID: 12,
+		ProjectID: 34,
+	}
+	if r.interval != 30*time.Second || r.delay != time.Minute || r.tenantID != tenantID || r.output != outputMetrics {
+		t.Fatalf("unexpected rule #2: interval=%s, delay=%s, tenant=%s, output=%q", r.interval, r.delay, &r.tenantID, r.output)
+	}
+
+	r = rules[2]
+	outputTenantID := logstorage.TenantID{
+		AccountID: 2,
+	}
+	if r.outputTenantID != outputTenantID {
+		t.Fatalf("unexpected output tenant for rule #3; got %s; want %s", &r.outputTenantID, &outputTenantID)
+	}
+}
+
+func TestLoadFromDataFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		_, err := loadFromData([]byte(data))
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// invalid yaml
+	f(`foo`)
+
+	// unknown field
+	f(`
+- name: foo
+  query: '* | stats count()'
+  interval: 1m
+  bar: baz
+`)
+
+	// invalid name
+	f(`
+- query: '* | stats count()'
+  interval: 1m
+`)
+	f(`
+- name: foo-bar
+  query: '* | stats count()'
+  interval: 1m
+`)
+
+	// duplicate names
+	f(`
+- name: foo
+  query: '* | stats count()'
+  interval: 1m
+- name: foo
+  query: '* | stats count()'
+  interval: 5m
+`)
+
+	// missing query
+	f(`
+- name: foo
+  interval: 1m
+`)
+
+	// invalid query
+	f(`
+- name: foo
+  query: 'foo |'
+  interval: 1m
+`)
+
+	// query without stats pipe
+	f(`
+- name: foo
+  query: 'error | fields _msg'
+  interval: 1m
+`)
+
+	// missing interval
+	f(`
+- name: foo
+  query: '* | stats count()'
+`)
+
+	// too small interval
+	f(`
+- name: foo
+  query: '* | stats count()'
+  interval: 100ms
+`)
+
+	// invalid delay
+	f(`
+- name: foo
+  query: '* | stats count()'
+  interval: 1m
+  delay: bar
+`)
+
+	// invalid tenant
+	f(`
+- name: foo
+  query: '* | stats count()'
+  interval: 1m
+  tenant: bar
+`)
+
+	// invalid output
+	f(`
+- name: foo
+  query: '* | stats count()'
+  interval: 1m
+  output: bar
+`)
+
+	// output_tenant for metrics output
+	f(`
+- name: foo
+  query: '* | stats count()'
+  interval: 1m
+  output: metrics
+  output_tenant: "1:0"
+`)
+}
@@ -0,0 +1,136 @@
+package vlstreamaggr
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+var configPath = flag.String("streamAggr.config", "", "Optional path to file with stream aggregation config for logs. "+
+	"The config contains LogsQL stats queries, which are periodically executed over the freshly ingested logs. "+
+	"The results are stored as log entries or are exposed as metrics at /streamaggr/metrics; "+
+	"see https://docs.victoriametrics.com/victorialogs/#stream-aggregation")
+
+var (
+	rules []*rule
+
+	rulesWG     sync.WaitGroup
+	rulesCtx    context.Context
+	rulesCancel func()
+)
+
+// Init initializes stream aggregation rules from -streamAggr.config.
+//
+// It must be called after vlstorage.Init().
+func Init() {
+	if *configPath == "" {
+		return
+	}
+
+	rs, err := loadFromFile(*configPath)
+	if err != nil {
+		logger.Fatalf("cannot load -streamAggr.config=%q: %s", *configPath, err)
+	}
+	rules = rs
+
+	rulesCtx, rulesCancel = context.WithCancel(context.Background())
+	for _, r := range rules {
+		rulesWG.Add(1)
+		go func(r *rule) {
+			defer rulesWG.Done()
+			r.run(rulesCtx)
+		}(r)
+	}
+	logger.Infof("started %d stream aggregation rules from -streamAggr.config=%q", len(rules), *configPath)
+}
+
+// Stop stops stream aggregation rules.
+//
+// It must be called before vlstorage.Stop().
+func Stop() {
+	if rulesCancel == nil {
+		return
+	}
+	rulesCancel()
+	rulesWG.Wait()
+	rules = nil
+}
+
+// RequestHandler handles stream aggregation requests for VictoriaLogs
+func RequestHandler(w http.ResponseWriter, r *http.Request) bool {
+	path := strings.ReplaceAll(r.URL.Path, "//", "/")
+
+	switch path {
+	case "/streamaggr/metrics":
+		streamAggrMetricsRequests.Inc()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, r := range rules {
+			r.mu.Lock()
+			_, _ = w.Write(r.samples)
+			r.mu.Unlock()
+		}
+		return true
+	case "/streamaggr/rules":
+		streamAggrRulesRequests.Inc()
+		processRules(w)
+		return true
+	default:
+		return false
+	}
+}
+
+var (
+	streamAggrMetricsRequests = metrics.NewCounter(`vl_http_requests_total{path="/streamaggr/metrics"}`)
+	streamAggrRulesRequests   = metrics.NewCounter(`vl_http_requests_total{path="/streamaggr/rules"}`)
+)
+
+// ruleStatusJSON is JSON representation for the rule status returned from /streamaggr/rules.
+type ruleStatusJSON struct {
+	Name          string `json:"name"`
+	Query         string `json:"query"`
+	Interval      string `json:"interval"`
+	Delay         string `json:"delay"`
+	Tenant        string `json:"tenant"`
+	Output        string `json:"output"`
+	LastWindowEnd string `json:"last_window_end,omitempty"`
+	LastError     string `json:"last_error,omitempty"`
+}
+
+func processRules(w http.ResponseWriter) {
+	statuses := make([]ruleStatusJSON, len(rules))
+	for i, r := range rules {
+		rs := ruleStatusJSON{
+			Name:     r.name,
+			Query:    r.q.String(),
+			Interval: r.interval.String(),
+			Delay:    r.delay.String(),
+			Tenant:   fmt.Sprintf("%d:%d", r.tenantID.AccountID, r.tenantID.ProjectID),
+			Output:   r.output,
+		}
+		r.mu.Lock()
+		if r.lastWindowEnd > 0 {
+			rs.LastWindowEnd = time.Unix(0, r.lastWindowEnd).UTC().Format(time.RFC3339)
+		}
+		rs.LastError = r.lastError
+		r.mu.Unlock()
+		statuses[i] = rs
+	}
+
+	data, err := json.Marshal(map[string]any{
+		"rules": statuses,
+	})
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal stream aggregation rules: %s", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,335 @@
+package vlstreamaggr
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vlstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timerpool"
+)
+
+// rule periodically runs the stats query and writes the results to the configured output.
+type rule struct {
+	name     string
+	q        *logstorage.Query
+	byFields []string
+	interval time.Duration
+	delay    time.Duration
+	tenantID logstorage.TenantID
+
+	output         string
+	outputTenantID logstorage.TenantID
+
+	// streamFields contains stream fields for the generated log entries.
+	streamFields []string
+
+	runsTotal       *metrics.Counter
+	errorsTotal     *metrics.Counter
+	outputRowsTotal *metrics.Counter
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// lastWindowEnd is the end of the latest processed time range in Unix nanoseconds.
+	lastWindowEnd int64
+
+	// lastError is the error for the latest query execution.
+	lastError string
+
+	// samples contains the latest samples in Prometheus text exposition format for output=metrics.
+	samples []byte
+}
+
+func newRuleInternal(name string, q *logstorage.Query, byFields []string, interval, delay time.Duration, tenantID logstorage.TenantID,
+	output string, outputTenantID logstorage.TenantID) *rule {
+
+	streamFields := []string{"_aggr_rule"}
+	for _, f := range byFields {
+		if f != "_time" {
+			streamFields = append(streamFields, f)
+		}
+	}
+
+	return &rule{
+		name:     name,
+		q:        q,
+		byFields: byFields,
+		interval: interval,
+		delay:    delay,
+		tenantID: tenantID,
+
+		output:         output,
+		outputTenantID: outputTenantID,
+
+		streamFields: streamFields,
+
+		runsTotal:       metrics.GetOrCreateCounter(fmt.Sprintf(`vl_streamaggr_runs_total{name=%q}`, name)),
+		errorsTotal:     metrics.GetOrCreateCounter(fmt.Sprintf(`vl_streamaggr_errors_total{name=%q}`, name)),
+		outputRowsTotal: metrics.GetOrCreateCounter(fmt.Sprintf(`vl_streamaggr_output_rows_total{name=%q}`, name)),
+	}
+}
+
+// run processes consecutive time ranges with the r.interval duration until ctx is canceled.
+//
+// The processing starts from the time range following the current time, so the data ingested before the start isn't processed.
+func (r *rule) run(ctx context.Context) {
+	interval := r.interval.Nanoseconds()
+	windowEnd := alignTimestamp(time.Now().UnixNano()-r.delay.Nanoseconds(), interval)
+	for {
+		windowStart := windowEnd
+		windowEnd = windowStart + interval
+
+		d := time.Until(time.Unix(0, windowEnd).Add(r.delay))
+		t := timerpool.Get(d)
+		select {
+		case <-ctx.Done():
+			timerpool.Put(t)
+			return
+		case <-t.C:
+			timerpool.Put(t)
+		}
+
+		err := r.processWindow(ctx, windowStart, windowEnd)
+		r.mu.Lock()
+		r.lastWindowEnd = windowEnd
+		r.lastError = ""
+		if err != nil {
+			r.lastError = err.Error()
+		}
+		r.mu.Unlock()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			r.errorsTotal.Inc()
+			logger.Errorf("cannot process stream aggregation rule %q on the time range [%s, %s): %s",
+				r.name, time.Unix(0, windowStart).UTC().Format(time.RFC3339), time.Unix(0, windowEnd).UTC().Format(time.RFC3339), err)
+		}
+	}
+}
+
+// alignTimestamp returns ts rounded down to multiple of interval.
+func alignTimestamp(ts, interval int64) int64 {
+	return ts - ts%interval
+}
+
+// processWindow runs r.q on the [start ... end) time range and writes the results to the configured output.
+func (r *rule) processWindow(ctx context.Context, start, end int64) error {
+	r.runsTotal.Inc()
+
+	q := r.q.Clone()
+	q.AddTimeFilter(start, end-1)
+
+	var rows [][]logstorage.Field
+	var rowsLock sync.Mutex
+	writeBlock := func(_ uint, timestamps []int64, columns []logstorage.BlockColumn) {
+		rowsLock.Lock()
+		defer rowsLock.Unlock()
+
+		for i := range timestamps {
+			fields := make([]logstorage.Field, len(columns))
+			for j, c := range columns {
+				// Clone the values, since they may be modified after writeBlock returns.
+				fields[j] = logstorage.Field{
+					Name:  strings.Clone(c.Name),
+					Value: strings.Clone(c.Values[i]),
+				}
+			}
+			rows = append(rows, fields)
+		}
+	}
+	if err := vlstorage.RunQuery(ctx, []logstorage.TenantID{r.tenantID}, q, writeBlock); err != nil {
+		return fmt.Errorf("cannot execute query [%s]: %w", q, err)
+	}
+
+	switch r.output {
+	case outputLogs:
+		return r.writeLogs(rows, start)
+	case outputMetrics:
+		r.writeMetrics(rows, start)
+		return nil
+	default:
+		logger.Panicf("BUG: unexpected output=%q", r.output)
+		return nil
+	}
+}
+
+// writeLogs writes rows as log entries to the storage.
+//
+// The timestamp for every log entry is obtained from the _time field if it is present in rows. Otherwise defaultTimestamp is used.
+func (r *rule) writeLogs(rows [][]logstorage.Field, defaultTimestamp int64) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	if err := vlstorage.CanWriteData(r.outputTenantID); err != nil {
+		return err
+	}
+
+	lr := logstorage.GetLogRows(r.streamFields, nil)
+	defer logstorage.PutLogRows(lr)
+
+	var fieldsBuf []logstorage.Field
+	for _, fields := range rows {
+		fieldsBuf = r.getLogFields(fieldsBuf[:0], fields)
+		timestamp := getTimestamp(fields, defaultTimestamp)
+		lr.MustAdd(r.outputTenantID, timestamp, fieldsBuf)
+	}
+	vlstorage.MustAddRows(lr)
+	r.outputRowsTotal.Add(len(rows))
+	return nil
+}
+
+// getLogFields appends fields for the log entry generated from the given result fields to dst and returns the result.
+func (r *rule) getLogFields(dst, fields []logstorage.Field) []logstorage.Field {
+	hasMsg := false
+	for _, f := range fields {
+		switch f.Name {
+		case "_time":
+			// The _time field is used as the log entry timestamp.
+			continue
+		case "_msg":
+			hasMsg = true
+		}
+		dst = append(dst, f)
+	}
+	dst = append(dst, logstorage.Field{
+		Name:  "_aggr_rule",
+		Value: r.name,
+	})
+	if !hasMsg {
+		dst = append(dst, logstorage.Field{
+			Name:  "_msg",
+			Value: r.name,
+		})
+	}
+	return dst
+}
+
+// writeMetrics replaces the exposed samples for r with the samples generated from rows.
+//
+// Every numeric stats result is converted to a sample with the `<rule_name>_<result_name>` metric name
+// and with the labels obtained from `by (...)` fields except of _time.
+func (r *rule) writeMetrics(rows [][]logstorage.Field, defaultTimestamp int64) {
+	var b []byte
+	n := 0
+	for _, fields := range rows {
+		timestampMsecs := getTimestamp(fields, defaultTimestamp) / 1e6
+		for _, f := range fields {
+			if r.isByField(f.Name) {
+				continue
+			}
+			v, err := strconv.ParseFloat(f.Value, 64)
+			if err != nil || math.IsNaN(v) {
+				// Skip non-numeric results such as uniq_values().
+				continue
+			}
+			b = r.marshalSample(b, fields, f.Name, v, timestampMsecs)
+			n++
+		}
+	}
+
+	r.mu.Lock()
+	r.samples = b
+	r.mu.Unlock()
+
+	r.outputRowsTotal.Add(n)
+}
+
+func (r *rule) marshalSample(dst []byte, fields []logstorage.Field, resultName string, v float64, timestampMsecs int64) []byte {
+	dst = append(dst, r.name...)
+	dst = append(dst, '_')
+	dst = appendSanitizedName(dst, resultName)
+
+	labelsCount := 0
+	for _, f := range fields {
+		if f.Name == "_time" || !r.isByField(f.Name) {
+			continue
+		}
+		if labelsCount == 0 {
+			dst = append(dst, '{')
+		} else {
+			dst = append(dst, ',')
+		}
+		dst = appendSanitizedName(dst, f.Name)
+		dst = append(dst, `="`...)
+		dst = appendEscapedLabelValue(dst, f.Value)
+		dst = append(dst, '"')
+		labelsCount++
+	}
+	if labelsCount > 0 {
+		dst = append(dst, '}')
+	}
+
+	dst = append(dst, ' ')
+	dst = strconv.AppendFloat(dst, v, 'g', -1, 64)
+	dst = append(dst, ' ')
+	dst = strconv.AppendInt(dst, timestampMsecs, 10)
+	dst = append(dst, '\n')
+	return dst
+}
+
+func (r *rule) isByField(name string) bool {
+	for _, f := range r.byFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// getTimestamp returns the timestamp from the _time field in fields.
+//
+// defaultTimestamp is returned if fields have no valid _time field.
+func getTimestamp(fields []logstorage.Field, defaultTimestamp int64) int64 {
+	for _, f := range fields {
+		if f.Name != "_time" {
+			continue
+		}
+		if ts, ok := logstorage.TryParseTimestampRFC3339Nano(f.Value); ok {
+			return ts
+		}
+		break
+	}
+	return defaultTimestamp
+}
+
+// appendSanitizedName appends s to dst with chars unsupported in Prometheus metric and label names replaced with '_'.
+func appendSanitizedName(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		isAlpha := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+		isDigit := c >= '0' && c <= '9'
+		if isAlpha || isDigit && i > 0 {
+			dst = append(dst, c)
+		} else {
+			dst = append(dst, '_')
+		}
+	}
+	return dst
+}
+
+// appendEscapedLabelValue appends s to dst with escaping according to Prometheus text exposition format.
+func appendEscapedLabelValue(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			dst = append(dst, `\\`...)
+		case '"':
+			dst = append(dst, `\"`...)
+		case '\n':
+			dst = append(dst, `\n`...)
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,135 @@
+package vlstreamaggr
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logstorage"
+)
+
+func newTestRule(t *testing.T, query string) *rule {
+	t.Helper()
+
+	q, err := logstorage.ParseQuery(query)
+	if err != nil {
+		t.Fatalf("cannot parse [%s]: %s", query, err)
+	}
+	byFields, err := q.GetStatsByFields()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return newRuleInternal("test", q, byFields, 60e9, 0, logstorage.TenantID{}, outputMetrics, logstorage.TenantID{})
+}
+
+func TestRuleWriteMetrics(t *testing.T) {
+	f := func(query string, rows [][]logstorage.Field, resultExpected string) {
+		t.Helper()
+
+		r := newTestRule(t, query)
+		r.writeMetrics(rows, 1717150800000000000)
+		result := string(r.samples)
+		if result != resultExpected {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	// without by(...) fields
+	f(`* | stats count() hits`, [][]logstorage.Field{
+		{
+			{Name: "hits", Value: "123"},
+		},
+	}, "test_hits 123 1717150800000\n")
+
+	// with _time bucket
+	f(`* | stats by (_time:1m, service) count() hits, avg(duration) "avg duration"`, [][]logstorage.Field{
+		{
+			{Name: "_time", Value: "2024-05-31T10:21:00Z"},
+			{Name: "service", Value: `foo"bar`},
+			{Name: "hits", Value: "10"},
+			{Name: "avg duration", Value: "0.5"},
+		},
+		{
+			{Name: "_time", Value: "2024-05-31T10:22:00Z"},
+			{Name: "service", Value: "baz"},
+			{Name: "hits", Value: "3"},
+			{Name: "avg duration", Value: "NaN"},
+		},
+	}, `test_hits{service="foo\"bar"} 10 1717150860000
+test_avg_duration{service="foo\"bar"} 0.5 1717150860000
+test_hits{service="baz"} 3 1717150920000
+`)
+
+	// non-numeric results are skipped
+	f(`* | stats by (level) count_uniq(host) hosts, uniq_values(host) host_values`, [][]logstorage.Field{
+		{
+			{Name: "level", Value: "error"},
+			{Name: "hosts", Value: "2"},
+			{Name: "host_values", Value: `["a","b"]`},
+		},
+	}, "test_hosts{level=\"error\"} 2 1717150800000\n")
+}
+
+func TestRuleGetLogFields(t *testing.T) {
+	f := func(query string, fields, resultExpected []logstorage.Field) {
+		t.Helper()
+
+		r := newTestRule(t, query)
+		result := r.getLogFields(nil, fields)
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result\ngot\n%v\nwant\n%v", result, resultExpected)
+		}
+	}
+
+	f(`* | stats by (_time:1m, service) count() hits`, []logstorage.Field{
+		{Name: "_time", Value: "2024-05-31T10:21:00Z"},
+		{Name: "service", Value: "foo"},
+		{Name: "hits", Value: "10"},
+	}, []logstorage.Field{
+		{Name: "service", Value: "foo"},
+		{Name: "hits", Value: "10"},
+		{Name: "_aggr_rule", Value: "test"},
+		{Name: "_msg", Value: "test"},
+	})
+
+	// _msg from the results is preserved
+	f(`* | stats by (_msg) count() hits`, []logstorage.Field{
+		{Name: "_msg", Value: "error"},
+		{Name: "hits", Value: "10"},
+	}, []logstorage.Field{
+		{Name: "_msg", Value: "error"},
+		{Name: "hits", Value: "10"},
+		{Name: "_aggr_rule", Value: "test"},
+	})
+}
+
+func TestGetTimestamp(t *testing.T) {
+	f := func(fields []logstorage.Field, resultExpected int64) {
+		t.Helper()
+
+		result := getTimestamp(fields, 123)
+		if result != resultExpected {
+			t.Fatalf("unexpected result; got %d; want %d", result, resultExpected)
+		}
+	}
+
+	f(nil, 123)
+	f([]logstorage.Field{{Name: "hits", Value: "10"}}, 123)
+	f([]logstorage.Field{{Name: "_time", Value: "foo"}}, 123)
+	f([]logstorage.Field{{Name: "_time", Value: "2024-05-31T10:20:00Z"}}, 1717150800000000000)
+}
+
+func TestAlignTimestamp(t *testing.T) {
+	f := func(ts, interval, resultExpected int64) {
+		t.Helper()
+
+		result := alignTimestamp(ts, interval)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for alignTimestamp(%d, %d); got %d; want %d", ts, interval, result, resultExpected)
+		}
+	}
+
+	f(0, 60, 0)
+	f(59, 60, 0)
+	f(60, 60, 60)
+	f(1717150830456789123, 60e9, 1717150800000000000)
+}
@@ -0,0 +1,105 @@
+package zstd
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Dict is a ZSTD dictionary.
+//
+// The dictionary improves compression ratio for small data blocks with the content, which is frequently seen across blocks.
+//
+// Dict is safe for concurrent use.
+type Dict struct {
+	id   uint32
+	data []byte
+
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// BuildDict builds ZSTD dictionary with the given id.
+//
+// content must contain byte sequences, which are frequently seen in the data to compress.
+// The most frequently seen sequences must be put at the end of content.
+//
+// samples must contain typical data blocks, which are going to be compressed with the dictionary.
+func BuildDict(id uint32, content []byte, samples [][]byte) (d *Dict, err error) {
+	defer func() {
+		// zstd.BuildDict panics with division by zero if samples contain no literals, e.g. when all the samples are fully covered by content.
+		if r := recover(); r != nil {
+			d = nil
+			err = fmt.Errorf("cannot build ZSTD dictionary: %v", r)
+		}
+	}()
+
+	data, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       id,
+		Contents: samples,
+		History:  content,
+		Offsets:  [3]int{1, 4, 8},
+		Level:    zstd.SpeedFastest,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot build ZSTD dictionary: %w", err)
+	}
+	return NewDict(data)
+}
+
+// NewDict returns ZSTD dictionary for the given data.
+//
+// data must be obtained via Dict.Data call.
+func NewDict(data []byte) (*Dict, error) {
+	info, err := zstd.InspectDictionary(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse ZSTD dictionary: %w", err)
+	}
+	if info.ID() == 0 {
+		return nil, fmt.Errorf("ZSTD dictionary id cannot be zero")
+	}
+
+	encoder, err := zstd.NewWriter(nil,
+		zstd.WithEncoderCRC(false), // Disable CRC for performance reasons.
+		zstd.WithEncoderLevel(zstd.SpeedFastest),
+		zstd.WithEncoderDict(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create ZSTD writer for the dictionary: %w", err)
+	}
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderDicts(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create ZSTD reader for the dictionary: %w", err)
+	}
+
+	d := &Dict{
+		id:      info.ID(),
+		data:    append([]byte{}, data...),
+		encoder: encoder,
+		decoder: decoder,
+	}
+	return d, nil
+}
+
+// ID returns the id of d.
+func (d *Dict) ID() uint32 {
+	return d.id
+}
+
+// Data returns marshaled d.
+//
+// The returned data can be passed to NewDict for obtaining the dictionary.
+func (d *Dict) Data() []byte {
+	return d.data
+}
+
+// Compress appends src compressed with d to dst and returns the result.
+func (d *Dict) Compress(dst, src []byte) []byte {
+	return d.encoder.EncodeAll(src, dst)
+}
+
+// Decompress appends src decompressed with d to dst and returns the result.
+//
+// src must be obtained via Compress call for the dictionary with the same ID.
+func (d *Dict) Decompress(dst, src []byte) ([]byte, error) {
+	return d.decoder.DecodeAll(src, dst)
+}
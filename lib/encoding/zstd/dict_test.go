@@ -0,0 +1,99 @@
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestDict(t *testing.T) {
+	var content []byte
+	var samples [][]byte
+	for i := 0; i < 100; i++ {
+		sample := []byte(fmt.Sprintf("Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%d.0.0.0 Safari/537.36", i))
+		samples = append(samples, sample)
+		if i < 10 {
+			content = append(content, sample...)
+		}
+	}
+
+	d, err := BuildDict(123456, content, samples)
+	if err != nil {
+		t.Fatalf("unexpected error when building dictionary: %s", err)
+	}
+	if id := d.ID(); id != 123456 {
+		t.Fatalf("unexpected dictionary id; got %d; want %d", id, 123456)
+	}
+
+	// Re-create the dictionary from its data
+	d2, err := NewDict(d.Data())
+	if err != nil {
+		t.Fatalf("unexpected error when creating dictionary from data: %s", err)
+	}
+	if d2.ID() != d.ID() {
+		t.Fatalf("unexpected dictionary id; got %d; want %d", d2.ID(), d.ID())
+	}
+
+	f := func(src []byte) {
+		t.Helper()
+
+		compressed := d.Compress(nil, src)
+		result, err := d2.Decompress(nil, compressed)
+		if err != nil {
+			t.Fatalf("unexpected error when decompressing %q: %s", src, err)
+		}
+		if !bytes.Equal(result, src) {
+			t.Fatalf("unexpected decompressed data; got %q; want %q", result, src)
+		}
+
+		// The data compressed with the dictionary cannot be decompressed without it
+		if _, err := Decompress(nil, compressed); err == nil {
+			t.Fatalf("expecting non-nil error when decompressing data without the dictionary")
+		}
+	}
+
+	f([]byte("a"))
+	f([]byte("Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/135.0.0.0 Safari/537.36"))
+	f(bytes.Repeat([]byte("foobar"), 1000))
+
+	// Verify that the dictionary improves compression ratio for small data
+	src := []byte("Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/135.0.0.0 Safari/537.36")
+	compressedWithDict := d.Compress(nil, src)
+	compressed := CompressLevel(nil, src, 1)
+	if len(compressedWithDict) >= len(compressed) {
+		t.Fatalf("dictionary must improve compression ratio; got %d bytes with dictionary vs %d bytes without dictionary", len(compressedWithDict), len(compressed))
+	}
+}
+
+func TestBuildDictFailure(t *testing.T) {
+	f := func(content []byte, samples [][]byte) {
+		t.Helper()
+
+		if _, err := BuildDict(123456, content, samples); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// too small content
+	f([]byte("foo"), [][]byte{[]byte("foobar")})
+
+	// missing samples
+	f([]byte("foobarbaz"), nil)
+
+	// samples without literals
+	content := []byte("GET /api/v1/users HTTP/1.1")
+	f(content, [][]byte{content, content})
+}
+
+func TestNewDictFailure(t *testing.T) {
+	f := func(data []byte) {
+		t.Helper()
+
+		if _, err := NewDict(data); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	f(nil)
+	f([]byte("foobar"))
+}
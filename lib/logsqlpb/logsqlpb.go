@@ -0,0 +1,402 @@
+// Package logsqlpb provides types and helpers for the protobuf-based streaming format
+// returned by /select/logsql/query?format=protobuf in VictoriaLogs.
+//
+// See https://docs.victoriametrics.com/victorialogs/querying/#querying-logs
+package logsqlpb
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/VictoriaMetrics/easyproto"
+)
+
+// ColumnType is the type of values stored in Column.
+type ColumnType uint64
+
+const (
+	// ColumnTypeString is the type for arbitrary string values stored in Column.StringValues.
+	ColumnTypeString ColumnType = 0
+
+	// ColumnTypeInt64 is the type for integer values stored in Column.Int64Values.
+	ColumnTypeInt64 ColumnType = 1
+
+	// ColumnTypeFloat64 is the type for floating-point values stored in Column.Float64Values.
+	ColumnTypeFloat64 ColumnType = 2
+
+	// ColumnTypeTimestamp is the type for RFC3339 timestamps stored as Unix nanoseconds in Column.Int64Values.
+	ColumnTypeTimestamp ColumnType = 3
+)
+
+// String returns string representation for t.
+func (t ColumnType) String() string {
+	switch t {
+	case ColumnTypeString:
+		return "string"
+	case ColumnTypeInt64:
+		return "int64"
+	case ColumnTypeFloat64:
+		return "float64"
+	case ColumnTypeTimestamp:
+		return "timestamp"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint64(t))
+	}
+}
+
+// Block is a block of query results returned from /select/logsql/query?format=protobuf.
+type Block struct {
+	// RowsCount is the number of rows in the block.
+	RowsCount int
+
+	// Columns contains columns for the block. Every column contains RowsCount values.
+	Columns []Column
+}
+
+// Column is a column of values in Block.
+type Column struct {
+	// Name is the name of the column.
+	Name string
+
+	// Type is the type of values in the column.
+	Type ColumnType
+
+	// IsConst is set to true if all the values in the column are equal.
+	//
+	// In this case the column contains only a single value.
+	IsConst bool
+
+	// StringValues contains values for ColumnTypeString.
+	StringValues []string
+
+	// Int64Values contains values for ColumnTypeInt64 and ColumnTypeTimestamp.
+	Int64Values []int64
+
+	// Float64Values contains values for ColumnTypeFloat64.
+	Float64Values []float64
+}
+
+// Reset resets b for subsequent re-use.
+func (b *Block) Reset() {
+	b.RowsCount = 0
+
+	cs := b.Columns
+	for i := range cs {
+		cs[i].reset()
+	}
+	b.Columns = cs[:0]
+}
+
+func (c *Column) reset() {
+	c.Name = ""
+	c.Type = ColumnTypeString
+	c.IsConst = false
+
+	clear(c.StringValues)
+	c.StringValues = c.StringValues[:0]
+	c.Int64Values = c.Int64Values[:0]
+	c.Float64Values = c.Float64Values[:0]
+}
+
+// AddColumn adds a column with the given name and values to b.
+//
+// The most specific type, which allows restoring the original values, is detected for the column.
+// len(values) must be equal to b.RowsCount. b refers to values until Reset call.
+func (b *Block) AddColumn(name string, values []string) {
+	if len(values) != b.RowsCount {
+		panic(fmt.Errorf("BUG: unexpected number of values for column %q; got %d; want %d", name, len(values), b.RowsCount))
+	}
+
+	cs := b.Columns
+	if cap(cs) > len(cs) {
+		cs = cs[:len(cs)+1]
+	} else {
+		cs = append(cs, Column{})
+	}
+	b.Columns = cs
+
+	c := &cs[len(cs)-1]
+	c.Name = name
+	c.setValues(values)
+}
+
+// GetColumn returns the column with the given name from b.
+//
+// nil is returned if b doesn't contain the column with the given name.
+func (b *Block) GetColumn(name string) *Column {
+	cs := b.Columns
+	for i := range cs {
+		if cs[i].Name == name {
+			return &cs[i]
+		}
+	}
+	return nil
+}
+
+func (c *Column) setValues(values []string) {
+	if len(values) > 1 && isConstValues(values) {
+		c.IsConst = true
+		values = values[:1]
+	}
+	if len(values) > 0 {
+		if c.trySetInt64Values(values) || c.trySetFloat64Values(values) || c.trySetTimestampValues(values) {
+			return
+		}
+	}
+	c.Type = ColumnTypeString
+	c.StringValues = append(c.StringValues[:0], values...)
+}
+
+func (c *Column) trySetInt64Values(values []string) bool {
+	var buf [20]byte
+	a := c.Int64Values[:0]
+	for _, v := range values {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || string(strconv.AppendInt(buf[:0], n, 10)) != v {
+			return false
+		}
+		a = append(a, n)
+	}
+	c.Type = ColumnTypeInt64
+	c.Int64Values = a
+	return true
+}
+
+func (c *Column) trySetFloat64Values(values []string) bool {
+	var buf [32]byte
+	a := c.Float64Values[:0]
+	for _, v := range values {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || string(strconv.AppendFloat(buf[:0], f, 'g', -1, 64)) != v {
+			return false
+		}
+		a = append(a, f)
+	}
+	c.Type = ColumnTypeFloat64
+	c.Float64Values = a
+	return true
+}
+
+func (c *Column) trySetTimestampValues(values []string) bool {
+	var buf [64]byte
+	a := c.Int64Values[:0]
+	for _, v := range values {
+		if len(v) < len("2006-01-02T15:04:05Z") || v[len(v)-1] != 'Z' {
+			// Fast path - the value cannot be a timestamp in UTC.
+			return false
+		}
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return false
+		}
+		// Verify the roundtrip via Unix nanoseconds, since they cannot represent timestamps outside years 1678..2262.
+		nsecs := t.UnixNano()
+		if string(time.Unix(0, nsecs).UTC().AppendFormat(buf[:0], time.RFC3339Nano)) != v {
+			return false
+		}
+		a = append(a, nsecs)
+	}
+	c.Type = ColumnTypeTimestamp
+	c.Int64Values = a
+	return true
+}
+
+func isConstValues(values []string) bool {
+	v := values[0]
+	for _, s := range values[1:] {
+		if s != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Value returns the value at the given rowIdx in c in the original string representation.
+func (c *Column) Value(rowIdx int) string {
+	if c.IsConst {
+		rowIdx = 0
+	}
+	switch c.Type {
+	case ColumnTypeInt64:
+		return strconv.FormatInt(c.Int64Values[rowIdx], 10)
+	case ColumnTypeFloat64:
+		return strconv.FormatFloat(c.Float64Values[rowIdx], 'g', -1, 64)
+	case ColumnTypeTimestamp:
+		return time.Unix(0, c.Int64Values[rowIdx]).UTC().Format(time.RFC3339Nano)
+	default:
+		return c.StringValues[rowIdx]
+	}
+}
+
+var mp easyproto.MarshalerPool
+
+// MarshalProtobuf marshals b to protobuf message, appends it to dst and returns the result.
+func (b *Block) MarshalProtobuf(dst []byte) []byte {
+	m := mp.Get()
+	b.marshalProtobuf(m.MessageMarshaler())
+	dst = m.Marshal(dst)
+	mp.Put(m)
+	return dst
+}
+
+func (b *Block) marshalProtobuf(mm *easyproto.MessageMarshaler) {
+	mm.AppendUint64(1, uint64(b.RowsCount))
+	for i := range b.Columns {
+		b.Columns[i].marshalProtobuf(mm.AppendMessage(2))
+	}
+}
+
+func (c *Column) marshalProtobuf(mm *easyproto.MessageMarshaler) {
+	mm.AppendString(1, c.Name)
+	mm.AppendUint64(2, uint64(c.Type))
+	mm.AppendBool(3, c.IsConst)
+	switch c.Type {
+	case ColumnTypeInt64, ColumnTypeTimestamp:
+		mm.AppendSint64s(5, c.Int64Values)
+	case ColumnTypeFloat64:
+		mm.AppendDoubles(6, c.Float64Values)
+	default:
+		for _, v := range c.StringValues {
+			mm.AppendString(4, v)
+		}
+	}
+}
+
+// UnmarshalProtobuf unmarshals b from protobuf message at src.
+//
+// b remains valid until src is modified.
+func (b *Block) UnmarshalProtobuf(src []byte) error {
+	b.Reset()
+	if err := b.unmarshalProtobuf(src); err != nil {
+		return err
+	}
+	for i := range b.Columns {
+		if err := b.Columns[i].validate(b.RowsCount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Block) unmarshalProtobuf(src []byte) error {
+	// message Block {
+	//   uint64 rows_count = 1;
+	//   repeated Column columns = 2;
+	// }
+	var err error
+	var fc easyproto.FieldContext
+	for len(src) > 0 {
+		src, err = fc.NextField(src)
+		if err != nil {
+			return fmt.Errorf("cannot read next field in Block: %w", err)
+		}
+		switch fc.FieldNum {
+		case 1:
+			rowsCount, ok := fc.Uint64()
+			if !ok {
+				return fmt.Errorf("cannot read rows_count")
+			}
+			b.RowsCount = int(rowsCount)
+		case 2:
+			data, ok := fc.MessageData()
+			if !ok {
+				return fmt.Errorf("cannot read Column data")
+			}
+			cs := b.Columns
+			if cap(cs) > len(cs) {
+				cs = cs[:len(cs)+1]
+			} else {
+				cs = append(cs, Column{})
+			}
+			b.Columns = cs
+			c := &cs[len(cs)-1]
+			if err := c.unmarshalProtobuf(data); err != nil {
+				return fmt.Errorf("cannot unmarshal Column: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Column) unmarshalProtobuf(src []byte) error {
+	// message Column {
+	//   string name = 1;
+	//   ColumnType type = 2;
+	//   bool is_const = 3;
+	//   repeated string string_values = 4;
+	//   repeated sint64 int64_values = 5;
+	//   repeated double float64_values = 6;
+	// }
+	var err error
+	var fc easyproto.FieldContext
+	for len(src) > 0 {
+		src, err = fc.NextField(src)
+		if err != nil {
+			return fmt.Errorf("cannot read next field in Column: %w", err)
+		}
+		switch fc.FieldNum {
+		case 1:
+			name, ok := fc.String()
+			if !ok {
+				return fmt.Errorf("cannot read name")
+			}
+			c.Name = name
+		case 2:
+			t, ok := fc.Uint64()
+			if !ok {
+				return fmt.Errorf("cannot read type")
+			}
+			c.Type = ColumnType(t)
+		case 3:
+			isConst, ok := fc.Bool()
+			if !ok {
+				return fmt.Errorf("cannot read is_const")
+			}
+			c.IsConst = isConst
+		case 4:
+			v, ok := fc.String()
+			if !ok {
+				return fmt.Errorf("cannot read string_values")
+			}
+			c.StringValues = append(c.StringValues, v)
+		case 5:
+			a, ok := fc.UnpackSint64s(c.Int64Values)
+			if !ok {
+				return fmt.Errorf("cannot read int64_values")
+			}
+			c.Int64Values = a
+		case 6:
+			a, ok := fc.UnpackDoubles(c.Float64Values)
+			if !ok {
+				return fmt.Errorf("cannot read float64_values")
+			}
+			c.Float64Values = a
+		}
+	}
+	return nil
+}
+
+// validate verifies that c contains the expected number of values, so Value calls cannot panic.
+func (c *Column) validate(rowsCount int) error {
+	valuesLen := 0
+	switch c.Type {
+	case ColumnTypeString:
+		valuesLen = len(c.StringValues)
+	case ColumnTypeInt64, ColumnTypeTimestamp:
+		valuesLen = len(c.Int64Values)
+	case ColumnTypeFloat64:
+		valuesLen = len(c.Float64Values)
+	default:
+		return fmt.Errorf("unsupported type=%d for column %q", uint64(c.Type), c.Name)
+	}
+
+	valuesLenExpected := rowsCount
+	if c.IsConst {
+		valuesLenExpected = 1
+	}
+	if valuesLen != valuesLenExpected {
+		return fmt.Errorf("unexpected number of values for %s column %q; got %d; want %d", c.Type, c.Name, valuesLen, valuesLenExpected)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package logsqlpb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBlockAddColumn(t *testing.T) {
+	f := func(values []string, typeExpected ColumnType, isConstExpected bool) {
+		t.Helper()
+
+		var b Block
+		b.RowsCount = len(values)
+		b.AddColumn("foo", values)
+
+		c := b.GetColumn("foo")
+		if c == nil {
+			t.Fatalf("cannot find the added column")
+		}
+		if c.Type != typeExpected {
+			t.Fatalf("unexpected type for %q; got %s; want %s", values, c.Type, typeExpected)
+		}
+		if c.IsConst != isConstExpected {
+			t.Fatalf("unexpected IsConst for %q; got %v; want %v", values, c.IsConst, isConstExpected)
+		}
+		for i, v := range values {
+			if s := c.Value(i); s != v {
+				t.Fatalf("unexpected value at row %d; got %q; want %q", i, s, v)
+			}
+		}
+	}
+
+	// empty column
+	f(nil, ColumnTypeString, false)
+
+	// const column
+	f([]string{"", "", ""}, ColumnTypeString, true)
+	f([]string{"123", "123"}, ColumnTypeInt64, true)
+	f([]string{"foo"}, ColumnTypeString, false)
+
+	// int64 values
+	f([]string{"0", "-12", "9223372036854775807"}, ColumnTypeInt64, false)
+
+	// float64 values
+	f([]string{"1", "1.5", "-0.25", "1e+100"}, ColumnTypeFloat64, false)
+
+	// timestamps
+	f([]string{"2024-05-31T10:20:30Z", "2024-05-31T10:20:31.123456789Z"}, ColumnTypeTimestamp, false)
+
+	// values, which cannot be restored from the parsed representation
+	f([]string{"1", "007"}, ColumnTypeString, false)
+	f([]string{"+1", "2"}, ColumnTypeString, false)
+	f([]string{"1.50", "2"}, ColumnTypeString, false)
+	f([]string{"18446744073709551615", "12"}, ColumnTypeString, false)
+	f([]string{"0.000001"}, ColumnTypeString, false)
+	f([]string{"2024-05-31T10:20:30.100Z"}, ColumnTypeString, false)
+	f([]string{"2024-05-31T10:20:30+02:00"}, ColumnTypeString, false)
+	f([]string{"0001-01-01T00:00:00Z"}, ColumnTypeString, false)
+	f([]string{"123", "foo"}, ColumnTypeString, false)
+}
+
+func TestBlockMarshalUnmarshalProtobuf(t *testing.T) {
+	var b Block
+	b.RowsCount = 3
+	b.AddColumn("_time", []string{"2024-05-31T10:20:30Z", "2024-05-31T10:20:31Z", "2024-05-31T10:20:32.5Z"})
+	b.AddColumn("_msg", []string{"foo", "", "bar baz"})
+	b.AddColumn("n", []string{"1", "-2", "3"})
+	b.AddColumn("f", []string{"1.5", "2", "NaN"})
+	b.AddColumn("host", []string{"host1", "host1", "host1"})
+	b.AddColumn("empty", []string{"", "", ""})
+
+	data := b.MarshalProtobuf(nil)
+
+	var b2 Block
+	if err := b2.UnmarshalProtobuf(data); err != nil {
+		t.Fatalf("cannot unmarshal block: %s", err)
+	}
+	if !reflect.DeepEqual(getBlockRows(&b), getBlockRows(&b2)) {
+		t.Fatalf("unexpected rows after unmarshaling\ngot\n%q\nwant\n%q", getBlockRows(&b2), getBlockRows(&b))
+	}
+	for i := range b.Columns {
+		c, c2 := &b.Columns[i], &b2.Columns[i]
+		if c.Type != c2.Type || c.IsConst != c2.IsConst {
+			t.Fatalf("unexpected column %q after unmarshaling; got type=%s, isConst=%v; want type=%s, isConst=%v", c.Name, c2.Type, c2.IsConst, c.Type, c.IsConst)
+		}
+	}
+
+	// Verify that the block can be re-used for unmarshaling other data.
+	b.Reset()
+	b.RowsCount = 1
+	b.AddColumn("x", []string{"y"})
+	if err := b2.UnmarshalProtobuf(b.MarshalProtobuf(nil)); err != nil {
+		t.Fatalf("cannot unmarshal block: %s", err)
+	}
+	rowsExpected := [][]string{{"x=y"}}
+	if rows := getBlockRows(&b2); !reflect.DeepEqual(rows, rowsExpected) {
+		t.Fatalf("unexpected rows\ngot\n%q\nwant\n%q", rows, rowsExpected)
+	}
+}
+
+func TestBlockUnmarshalProtobufFailure(t *testing.T) {
+	f := func(b *Block) {
+		t.Helper()
+
+		data := b.MarshalProtobuf(nil)
+		var b2 Block
+		if err := b2.UnmarshalProtobuf(data); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// missing values
+	f(&Block{
+		RowsCount: 2,
+		Columns: []Column{{
+			Name:         "foo",
+			StringValues: []string{"bar"},
+		}},
+	})
+
+	// too many values for const column
+	f(&Block{
+		RowsCount: 2,
+		Columns: []Column{{
+			Name:        "foo",
+			Type:        ColumnTypeInt64,
+			IsConst:     true,
+			Int64Values: []int64{1, 2},
+		}},
+	})
+
+	// unsupported type
+	f(&Block{
+		RowsCount: 1,
+		Columns: []Column{{
+			Name:         "foo",
+			Type:         123,
+			StringValues: []string{"bar"},
+		}},
+	})
+
+	// invalid data
+	var b Block
+	if err := b.UnmarshalProtobuf([]byte("foobar")); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
+
+func getBlockRows(b *Block) [][]string {
+	var rows [][]string
+	for i := 0; i < b.RowsCount; i++ {
+		var row []string
+		for j := range b.Columns {
+			c := &b.Columns[j]
+			row = append(row, c.Name+"="+c.Value(i))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
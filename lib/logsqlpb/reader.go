@@ -0,0 +1,122 @@
+package logsqlpb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxFrameSize is the maximum size of a single frame, which can be read by Reader.
+//
+// It protects from excess memory usage when reading invalid data.
+const maxFrameSize = 1 << 30
+
+// MarshalFrame appends b as a frame to dst and returns the result.
+//
+// The frame consists of varint-encoded length of the protobuf message for b followed by the message itself.
+// b must contain at least a single row, since zero-length frame marks the end of the stream - see MarshalEndFrame.
+func MarshalFrame(dst []byte, b *Block) []byte {
+	if b.RowsCount == 0 {
+		panic(fmt.Errorf("BUG: the block must contain at least a single row"))
+	}
+
+	dstLen := len(dst)
+	dst = b.MarshalProtobuf(dst)
+	msgLen := len(dst) - dstLen
+
+	// Move the marshaled message in order to put its length in front of it.
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(msgLen))
+	dst = append(dst, buf[:n]...)
+	copy(dst[dstLen+n:], dst[dstLen:dstLen+msgLen])
+	copy(dst[dstLen:], buf[:n])
+	return dst
+}
+
+// MarshalEndFrame appends the frame, which marks the end of the stream, to dst and returns the result.
+//
+// The end frame allows detecting truncated responses on the client side.
+func MarshalEndFrame(dst []byte) []byte {
+	return append(dst, 0)
+}
+
+// Reader reads blocks from the stream returned by /select/logsql/query?format=protobuf.
+//
+// Usage:
+//
+//	r := logsqlpb.NewReader(resp.Body)
+//	for {
+//		b, err := r.ReadBlock()
+//		if err != nil {
+//			if errors.Is(err, io.EOF) {
+//				break
+//			}
+//			return err
+//		}
+//		for i := 0; i < b.RowsCount; i++ {
+//			for j := range b.Columns {
+//				c := &b.Columns[j]
+//				fmt.Printf("%s=%q ", c.Name, c.Value(i))
+//			}
+//			fmt.Printf("\n")
+//		}
+//	}
+type Reader struct {
+	br *bufio.Reader
+
+	buf []byte
+	b   Block
+
+	finished bool
+}
+
+// NewReader returns a Reader for reading blocks from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		br: bufio.NewReader(r),
+	}
+}
+
+// ReadBlock reads the next block from the stream.
+//
+// The returned block is valid until the next ReadBlock call.
+//
+// io.EOF is returned after all the blocks are read. io.ErrUnexpectedEOF is returned if the stream is truncated.
+func (r *Reader) ReadBlock() (*Block, error) {
+	if r.finished {
+		return nil, io.EOF
+	}
+
+	size, err := binary.ReadUvarint(r.br)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("cannot read frame size: %w", err)
+	}
+	if size == 0 {
+		r.finished = true
+		return nil, io.EOF
+	}
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("too big frame size: %d bytes; mustn't exceed %d bytes", size, maxFrameSize)
+	}
+
+	if uint64(cap(r.buf)) < size {
+		r.buf = make([]byte, size)
+	}
+	r.buf = r.buf[:size]
+	if _, err := io.ReadFull(r.br, r.buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("cannot read frame with %d bytes: %w", size, err)
+	}
+
+	if err := r.b.UnmarshalProtobuf(r.buf); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal block: %w", err)
+	}
+	return &r.b, nil
+}
@@ -0,0 +1,70 @@
+package logsqlpb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestReaderReadBlock(t *testing.T) {
+	var data []byte
+
+	var b Block
+	b.RowsCount = 2
+	b.AddColumn("_msg", []string{"foo", "bar"})
+	b.AddColumn("n", []string{"1", "2"})
+	data = MarshalFrame(data, &b)
+
+	b.Reset()
+	b.RowsCount = 1
+	b.AddColumn("host", []string{"host1"})
+	data = MarshalFrame(data, &b)
+
+	data = MarshalEndFrame(data)
+
+	readBlocks := func(data []byte) ([][]string, error) {
+		var rows [][]string
+		r := NewReader(bytes.NewReader(data))
+		for {
+			b, err := r.ReadBlock()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return rows, nil
+				}
+				return rows, err
+			}
+			rows = append(rows, getBlockRows(b)...)
+		}
+	}
+
+	rows, err := readBlocks(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	rowsExpected := [][]string{
+		{"_msg=foo", "n=1"},
+		{"_msg=bar", "n=2"},
+		{"host=host1"},
+	}
+	if !reflect.DeepEqual(rows, rowsExpected) {
+		t.Fatalf("unexpected rows\ngot\n%q\nwant\n%q", rows, rowsExpected)
+	}
+
+	// Truncated stream must result in io.ErrUnexpectedEOF for every truncation point.
+	for n := 0; n < len(data); n++ {
+		if _, err := readBlocks(data[:n]); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("expecting io.ErrUnexpectedEOF for the stream truncated to %d bytes; got %v", n, err)
+		}
+	}
+
+	// Data after the end frame must be ignored.
+	rows, err = readBlocks(append(data, "foobar"...))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(rows, rowsExpected) {
+		t.Fatalf("unexpected rows\ngot\n%q\nwant\n%q", rows, rowsExpected)
+	}
+}
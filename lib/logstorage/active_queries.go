@@ -0,0 +1,127 @@
+package logstorage
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ActiveQuery contains information about a query executed by the storage at the moment.
+//
+// ActiveQuery may be obtained via Storage.GetActiveQueries().
+type ActiveQuery struct {
+	// QueryID is the unique id of the query. It can be passed to Storage.CancelQuery().
+	QueryID uint64 `json:"query_id,string"`
+
+	// TenantIDs is the list of tenants the query is executed for.
+	TenantIDs []TenantID `json:"tenant_ids"`
+
+	// Query is the executed LogsQL query.
+	Query string `json:"query"`
+
+	// StartTime is the query start time in Unix nanoseconds.
+	StartTime int64 `json:"start_time"`
+
+	// RowsScanned is the number of rows scanned by the query so far.
+	RowsScanned uint64 `json:"rows_scanned"`
+
+	// BytesRead is the number of compressed bytes read from the storage by the query so far.
+	BytesRead uint64 `json:"bytes_read"`
+}
+
+// activeQuery is a query executed by runQuery at the moment.
+type activeQuery struct {
+	queryID   uint64
+	tenantIDs []TenantID
+	q         *Query
+	startTime time.Time
+
+	// qs contains the stats for the query execution. It is updated while the query is executed.
+	qs *queryStats
+
+	// cancel stops the query execution.
+	cancel func()
+
+	// canceled is set to true if the query has been canceled via Storage.CancelQuery().
+	canceled atomic.Bool
+}
+
+// registerActiveQuery registers q as an active query at s.
+//
+// The returned query must be unregistered via s.unregisterActiveQuery() when it is finished.
+func (s *Storage) registerActiveQuery(tenantIDs []TenantID, q *Query, qs *queryStats, cancel func()) *activeQuery {
+	aq := &activeQuery{
+		queryID:   s.activeQueriesLatestID.Add(1),
+		tenantIDs: tenantIDs,
+		q:         q,
+		startTime: time.Now(),
+		qs:        qs,
+		cancel:    cancel,
+	}
+
+	s.activeQueriesLock.Lock()
+	s.activeQueries[aq.queryID] = aq
+	s.activeQueriesLock.Unlock()
+
+	return aq
+}
+
+func (s *Storage) unregisterActiveQuery(aq *activeQuery) {
+	s.activeQueriesLock.Lock()
+	delete(s.activeQueries, aq.queryID)
+	s.activeQueriesLock.Unlock()
+}
+
+func (s *Storage) getActiveQueriesCount() int {
+	s.activeQueriesLock.Lock()
+	n := len(s.activeQueries)
+	s.activeQueriesLock.Unlock()
+
+	return n
+}
+
+// GetActiveQueries returns queries executed by s at the moment.
+//
+// Subqueries such as in(...) subqueries and union pipe subqueries are returned as separate queries.
+// The returned queries are sorted by start time.
+func (s *Storage) GetActiveQueries() []ActiveQuery {
+	s.activeQueriesLock.Lock()
+	aqs := make([]*activeQuery, 0, len(s.activeQueries))
+	for _, aq := range s.activeQueries {
+		aqs = append(aqs, aq)
+	}
+	s.activeQueriesLock.Unlock()
+
+	sort.Slice(aqs, func(i, j int) bool {
+		return aqs[i].queryID < aqs[j].queryID
+	})
+
+	result := make([]ActiveQuery, len(aqs))
+	for i, aq := range aqs {
+		result[i] = ActiveQuery{
+			QueryID:     aq.queryID,
+			TenantIDs:   aq.tenantIDs,
+			Query:       aq.q.String(),
+			StartTime:   aq.startTime.UnixNano(),
+			RowsScanned: aq.qs.rowsScanned.Load(),
+			BytesRead:   aq.qs.bytesRead.Load(),
+		}
+	}
+	return result
+}
+
+// CancelQuery cancels the active query with the given queryID.
+//
+// The canceled query returns an error. false is returned if there is no active query with the given queryID.
+func (s *Storage) CancelQuery(queryID uint64) bool {
+	s.activeQueriesLock.Lock()
+	aq := s.activeQueries[queryID]
+	s.activeQueriesLock.Unlock()
+
+	if aq == nil {
+		return false
+	}
+	aq.canceled.Store(true)
+	aq.cancel()
+	return true
+}
@@ -0,0 +1,115 @@
+package logstorage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestStorageActiveQueries(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	sc := &StorageConfig{
+		Retention: 24 * time.Hour,
+	}
+	s := MustOpenStorage(path, sc)
+
+	if aqs := s.GetActiveQueries(); len(aqs) != 0 {
+		t.Fatalf("unexpected active queries for idle storage: %v", aqs)
+	}
+	if s.CancelQuery(123) {
+		t.Fatalf("expecting false when canceling missing query")
+	}
+
+	tenantID := TenantID{
+		AccountID: 1,
+		ProjectID: 2,
+	}
+	baseTimestamp := time.Now().UnixNano() - 3600*1e9
+	lr := GetLogRows([]string{"host"}, nil)
+	for i := 0; i < 1000; i++ {
+		fields := []Field{
+			{
+				Name:  "host",
+				Value: fmt.Sprintf("host-%d", i%5),
+			},
+			{
+				Name:  "_msg",
+				Value: fmt.Sprintf("message #%d", i),
+			},
+		}
+		lr.MustAdd(tenantID, baseTimestamp+int64(i)*1e6, fields)
+	}
+	s.MustAddRows(lr)
+	PutLogRows(lr)
+	s.debugFlush()
+
+	q := mustParseQuery(`message | fields _msg`)
+
+	// Verify the query is listed as active and cancel it on the first returned block.
+	var aqs []ActiveQuery
+	var canceled bool
+	var mu sync.Mutex
+	writeBlock := func(_ uint, _ []int64, _ []BlockColumn) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if aqs != nil {
+			return
+		}
+		aqs = s.GetActiveQueries()
+		if len(aqs) == 1 {
+			canceled = s.CancelQuery(aqs[0].QueryID)
+		}
+	}
+	err := s.RunQuery(context.Background(), []TenantID{tenantID}, q, writeBlock)
+	if len(aqs) != 1 {
+		t.Fatalf("unexpected number of active queries; got %d; want 1", len(aqs))
+	}
+	aq := aqs[0]
+	if aq.Query != q.String() {
+		t.Fatalf("unexpected active query; got %q; want %q", aq.Query, q)
+	}
+	if len(aq.TenantIDs) != 1 || aq.TenantIDs[0] != tenantID {
+		t.Fatalf("unexpected tenants for the active query; got %v; want [%v]", aq.TenantIDs, tenantID)
+	}
+	if aq.StartTime <= 0 || aq.StartTime > time.Now().UnixNano() {
+		t.Fatalf("unexpected start time for the active query: %d", aq.StartTime)
+	}
+	if aq.RowsScanned == 0 {
+		t.Fatalf("expecting non-zero number of scanned rows for the active query")
+	}
+	if !canceled {
+		t.Fatalf("cannot cancel the active query")
+	}
+	if err == nil {
+		t.Fatalf("expecting non-nil error for the canceled query")
+	}
+	if !strings.Contains(err.Error(), "canceled") {
+		t.Fatalf("unexpected error for the canceled query: %s", err)
+	}
+
+	if aqs := s.GetActiveQueries(); len(aqs) != 0 {
+		t.Fatalf("unexpected active queries after the query is finished: %v", aqs)
+	}
+	if s.CancelQuery(aq.QueryID) {
+		t.Fatalf("expecting false when canceling finished query")
+	}
+
+	var ss StorageStats
+	s.UpdateStats(&ss)
+	if ss.QueriesAbortedCanceled != 1 {
+		t.Fatalf("unexpected number of canceled queries; got %d; want 1", ss.QueriesAbortedCanceled)
+	}
+
+	s.MustClose()
+
+	fs.MustRemoveAll(path)
+}
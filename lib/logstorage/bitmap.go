@@ -98,6 +98,17 @@ func (bm *bitmap) areAllBitsSet() bool {
 	return true
 }
 
+func (bm *bitmap) and(x *bitmap) {
+	if bm.bitsLen != x.bitsLen {
+		logger.Panicf("BUG: cannot merge bitmaps with distinct lengths; %d vs %d", bm.bitsLen, x.bitsLen)
+	}
+	a := bm.a
+	b := x.a
+	for i := range a {
+		a[i] &= b[i]
+	}
+}
+
 func (bm *bitmap) andNot(x *bitmap) {
 	if bm.bitsLen != x.bitsLen {
 		logger.Panicf("BUG: cannot merge bitmaps with distinct lengths; %d vs %d", bm.bitsLen, x.bitsLen)
@@ -120,6 +131,12 @@ func (bm *bitmap) or(x *bitmap) {
 	}
 }
 
+func (bm *bitmap) setBit(i int) {
+	wordIdx := uint(i) / 64
+	wordOffset := uint(i) % 64
+	bm.a[wordIdx] |= 1 << wordOffset
+}
+
 func (bm *bitmap) isSetBit(i int) bool {
 	wordIdx := uint(i) / 64
 	wordOffset := uint(i) % 64
@@ -168,14 +185,10 @@ func (bm *bitmap) forEachSetBitReadonly(f func(idx int)) {
 	a := bm.a
 	bitsLen := bm.bitsLen
 	for i, word := range a {
-		if word == 0 {
-			continue
-		}
-		for j := 0; j < 64; j++ {
-			mask := uint64(1) << j
-			if (word & mask) == 0 {
-				continue
-			}
+		// Visit only the set bits in the word.
+		for word != 0 {
+			j := bits.TrailingZeros64(word)
+			word &= word - 1
 			idx := i*64 + j
 			if idx >= bitsLen {
 				return
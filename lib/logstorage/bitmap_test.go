@@ -109,3 +109,38 @@ func TestBitmap(t *testing.T) {
 		putBitmap(bm)
 	}
 }
+
+func TestBitmapSetBitAnd(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		bm1 := getBitmap(i)
+		bm2 := getBitmap(i)
+		for j := 0; j < i; j++ {
+			if j%2 == 0 {
+				bm1.setBit(j)
+			}
+			if j%3 == 0 {
+				bm2.setBit(j)
+			}
+		}
+		for j := 0; j < i; j++ {
+			if bm1.isSetBit(j) != (j%2 == 0) {
+				t.Fatalf("unexpected bit #%d at bitmap with %d bits", j, i)
+			}
+		}
+
+		bm1.and(bm2)
+		n := 0
+		bm1.forEachSetBitReadonly(func(idx int) {
+			if idx%6 != 0 {
+				t.Fatalf("unexpected set bit #%d at bitmap with %d bits", idx, i)
+			}
+			n++
+		})
+		if nExpected := (i + 5) / 6; n != nExpected {
+			t.Fatalf("unexpected number of set bits at bitmap with %d bits; got %d; want %d", i, n, nExpected)
+		}
+
+		putBitmap(bm1)
+		putBitmap(bm2)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/slicesutil"
 )
@@ -170,7 +171,12 @@ func (c *column) mustWriteToNoArena(ch *columnHeader, sw *streamWriters) {
 	defer longTermBufPool.Put(bb)
 
 	// marshal values
-	bb.B = marshalStringsBlock(bb.B[:0], ve.values)
+	var zd *zstd.Dict
+	if ch.valueType == valueTypeString {
+		// Other value types are stored in binary form, so they do not benefit from ZSTD dictionary trained on string values.
+		zd = sw.zstdDict
+	}
+	bb.B = marshalStringsBlock(bb.B[:0], ve.values, zd)
 	putValuesEncoder(ve)
 	ch.valuesSize = uint64(len(bb.B))
 	if ch.valuesSize > maxValuesBlockSize {
@@ -420,7 +426,7 @@ func (b *block) InitFromBlockData(bd *blockData, sbu *stringsBlockUnmarshaler, v
 		cd := &cds[i]
 		c := &cs[i]
 		c.name = sbu.copyString(cd.name)
-		c.values, err = sbu.unmarshal(c.values[:0], cd.valuesData, uint64(rowsCount))
+		c.values, err = sbu.unmarshal(c.values[:0], cd.valuesData, uint64(rowsCount), bd.zstdDict)
 		if err != nil {
 			return fmt.Errorf("cannot unmarshal column %d: %w", i, err)
 		}
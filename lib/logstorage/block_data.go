@@ -3,6 +3,7 @@ package logstorage
 import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/slicesutil"
 )
@@ -30,6 +31,9 @@ type blockData struct {
 
 	// constColumns contains data for const columns across the block
 	constColumns []Field
+
+	// zstdDict is an optional ZSTD dictionary used for compressing string values in columnsData.
+	zstdDict *zstd.Dict
 }
 
 // reset resets bd for subsequent re-use
@@ -50,6 +54,8 @@ func (bd *blockData) reset() {
 		ccs[i].Reset()
 	}
 	bd.constColumns = ccs[:0]
+
+	bd.zstdDict = nil
 }
 
 func (bd *blockData) resizeColumnsData(columnsDataLen int) []columnData {
@@ -76,6 +82,8 @@ func (bd *blockData) copyFrom(a *arena, src *blockData) {
 	bd.columnsData = cds
 
 	bd.constColumns = appendFields(a, bd.constColumns[:0], src.constColumns)
+
+	bd.zstdDict = src.zstdDict
 }
 
 // unmarshalRows appends unmarshaled from bd log entries to dst.
@@ -98,6 +106,10 @@ func (bd *blockData) mustWriteTo(bh *blockHeader, sw *streamWriters) {
 	// - all the blocks in the same part use the same encoding
 	// - the block encoding version can be put in metadata file for the part (aka metadataFilename)
 
+	if bd.zstdDict != nil && (sw.zstdDict == nil || sw.zstdDict.ID() != bd.zstdDict.ID()) {
+		logger.Panicf("BUG: the block compressed with ZSTD dictionary id=%d cannot be written to the part without this dictionary", bd.zstdDict.ID())
+	}
+
 	bh.reset()
 
 	bh.streamID = bd.streamID
@@ -172,6 +184,8 @@ func (bd *blockData) mustReadFrom(a *arena, bh *blockHeader, sr *streamReaders)
 	bd.constColumns = appendFields(a, bd.constColumns[:0], csh.constColumns)
 	putColumnsHeader(csh)
 	putArena(cshA)
+
+	bd.zstdDict = sr.zstdDict
 }
 
 // timestampsData contains the encoded timestamps data.
@@ -5,6 +5,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -44,6 +45,12 @@ type blockResult struct {
 
 	fvecs []filteredValuesEncodedCreator
 	svecs []searchValuesEncodedCreator
+	jvecs []jsonPathValuesEncodedCreator
+
+	// bs is the block search the br was initialized from via mustInit().
+	//
+	// It is nil for blocks generated by pipes.
+	bs *blockSearch
 }
 
 func (br *blockResult) reset() {
@@ -70,8 +77,35 @@ func (br *blockResult) reset() {
 
 	clear(br.svecs)
 	br.svecs = br.svecs[:0]
+
+	clear(br.jvecs)
+	br.jvecs = br.jvecs[:0]
+
+	br.bs = nil
+}
+
+// getBlockResult returns an empty blockResult from the pool.
+//
+// Return the blockResult to the pool via putBlockResult() when it is no longer needed.
+// This allows avoiding memory allocations when a temporary blockResult is needed outside per-worker shards of pipe processors.
+func getBlockResult() *blockResult {
+	v := blockResultPool.Get()
+	if v == nil {
+		return &blockResult{}
+	}
+	return v.(*blockResult)
+}
+
+// putBlockResult returns br to the pool.
+//
+// br cannot be used after returning it to the pool.
+func putBlockResult(br *blockResult) {
+	br.reset()
+	blockResultPool.Put(br)
 }
 
+var blockResultPool sync.Pool
+
 // clone returns a clone of br, which owns its own data.
 func (br *blockResult) clone() *blockResult {
 	brNew := &blockResult{}
@@ -107,7 +141,7 @@ func (br *blockResult) clone() *blockResult {
 
 	// do not clone br.csEmpty - it will be populated by the caller via getColumnByName().
 
-	// do not clone br.fvecs and br.svecs, since they may point to external data.
+	// do not clone br.fvecs, br.svecs and br.jvecs, since they may point to external data.
 
 	return brNew
 }
@@ -256,19 +290,19 @@ func (br *blockResult) addResultColumn(rc *resultColumn) {
 //
 // The initialized columns are valid until bs and bm are changed.
 func (br *blockResult) initAllColumns(bs *blockSearch, bm *bitmap) {
-	unneededColumnNames := bs.bsw.so.unneededColumnNames
+	unneededColumns := bs.bsw.so.unneededColumns
 
-	if !slices.Contains(unneededColumnNames, "_time") {
+	if !unneededColumns.contains("_time") {
 		// Add _time column
 		br.addTimeColumn()
 	}
 
-	if !slices.Contains(unneededColumnNames, "_stream_id") {
+	if !unneededColumns.contains("_stream_id") {
 		// Add _stream_id column
 		br.addStreamIDColumn(bs)
 	}
 
-	if !slices.Contains(unneededColumnNames, "_stream") {
+	if !unneededColumns.contains("_stream") {
 		// Add _stream column
 		if !br.addStreamColumn(bs) {
 			// Skip the current block, since the associated stream tags are missing
@@ -277,7 +311,7 @@ func (br *blockResult) initAllColumns(bs *blockSearch, bm *bitmap) {
 		}
 	}
 
-	if !slices.Contains(unneededColumnNames, "_msg") {
+	if !unneededColumns.contains("_msg") {
 		// Add _msg column
 		v := bs.csh.getConstColumnValue("_msg")
 		if v != "" {
@@ -294,7 +328,7 @@ func (br *blockResult) initAllColumns(bs *blockSearch, bm *bitmap) {
 		if isMsgFieldName(cc.Name) {
 			continue
 		}
-		if !slices.Contains(unneededColumnNames, cc.Name) {
+		if !unneededColumns.contains(cc.Name) {
 			br.addConstColumn(cc.Name, cc.Value)
 		}
 	}
@@ -306,7 +340,7 @@ func (br *blockResult) initAllColumns(bs *blockSearch, bm *bitmap) {
 		if isMsgFieldName(ch.name) {
 			continue
 		}
-		if !slices.Contains(unneededColumnNames, ch.name) {
+		if !unneededColumns.contains(ch.name) {
 			br.addColumn(bs, bm, ch)
 		}
 	}
@@ -318,7 +352,18 @@ func (br *blockResult) initAllColumns(bs *blockSearch, bm *bitmap) {
 //
 // The initialized columns are valid until bs and bm are changed.
 func (br *blockResult) initRequestedColumns(bs *blockSearch, bm *bitmap) {
+	hasWildcards := false
 	for _, columnName := range bs.bsw.so.neededColumnNames {
+		if isWildcardFieldName(columnName) {
+			if !br.addWildcardColumns(bs, bm, columnName) {
+				// Skip the current block, since the associated stream tags are missing.
+				br.reset()
+				return
+			}
+			hasWildcards = true
+			continue
+		}
+
 		switch columnName {
 		case "_stream_id":
 			br.addStreamIDColumn(bs)
@@ -336,18 +381,59 @@ func (br *blockResult) initRequestedColumns(bs *blockSearch, bm *bitmap) {
 				br.addConstColumn(columnName, v)
 			} else if ch := bs.csh.getColumnHeader(columnName); ch != nil {
 				br.addColumn(bs, bm, ch)
+			} else if src, ok := bs.csh.getJSONPathSource(columnName); ok {
+				br.addJSONPathColumn(bs, bm, columnName, &src)
 			} else {
 				br.addConstColumn(columnName, "")
 			}
 		}
 	}
 
-	br.csInitFast()
+	if hasWildcards {
+		// The columns matching wildcards may be also requested explicitly, so remove the duplicate columns.
+		br.csInit()
+	} else {
+		br.csInitFast()
+	}
+}
+
+// addWildcardColumns adds all the columns matching the given wildcard in the form `prefix*` to br.
+//
+// It returns false if the block must be skipped, since the associated stream tags are missing.
+func (br *blockResult) addWildcardColumns(bs *blockSearch, bm *bitmap, wildcard string) bool {
+	if matchFieldName(wildcard, "_time") {
+		br.addTimeColumn()
+	}
+	if matchFieldName(wildcard, "_stream_id") {
+		br.addStreamIDColumn(bs)
+	}
+	if matchFieldName(wildcard, "_stream") {
+		if !br.addStreamColumn(bs) {
+			return false
+		}
+	}
+
+	for _, cc := range bs.csh.constColumns {
+		name := getCanonicalColumnName(cc.Name)
+		if matchFieldName(wildcard, name) {
+			br.addConstColumn(name, cc.Value)
+		}
+	}
+	chs := bs.csh.columnHeaders
+	for i := range chs {
+		ch := &chs[i]
+		if matchFieldName(wildcard, getCanonicalColumnName(ch.name)) {
+			br.addColumn(bs, bm, ch)
+		}
+	}
+	return true
 }
 
 func (br *blockResult) mustInit(bs *blockSearch, bm *bitmap) {
 	br.reset()
 
+	br.bs = bs
+
 	if bm.isZero() {
 		// Nothing to initialize for zero matching log entries in the block.
 		return
@@ -355,7 +441,7 @@ func (br *blockResult) mustInit(bs *blockSearch, bm *bitmap) {
 
 	// Initialize timestamps, since they are required for all the further work with br.
 	so := bs.bsw.so
-	if !so.needAllColumns && !slices.Contains(so.neededColumnNames, "_time") || so.needAllColumns && slices.Contains(so.unneededColumnNames, "_time") {
+	if !so.needAllColumns && !matchAnyFieldName(so.neededColumnNames, "_time") || so.needAllColumns && so.unneededColumns.contains("_time") {
 		// The fastest path - _time column wasn't requested, so it is enough to initialize br.timestamps with zeroes.
 		rowsLen := bm.onesCount()
 		br.timestamps = fastnum.AppendInt64Zeros(br.timestamps[:0], rowsLen)
@@ -653,7 +739,7 @@ func (br *blockResult) getBucketedTimestampValues(bf *byStatsField) []string {
 			} else if bf.bucketSizeStr == "year" {
 				timestamp = truncateTimestampToYear(timestamp)
 			} else {
-				timestamp -= timestamp % bucketSizeInt
+				timestamp = truncateInt64(timestamp, bucketSizeInt)
 			}
 			timestamp += bucketOffsetInt
 
@@ -990,13 +1076,13 @@ func (br *blockResult) getBucketedFloat64Values(valuesEncoded []string, bf *bySt
 			f -= bf.bucketOffset
 
 			// emulate f % bucketSize for float64 values
-			fP10 := int64(f * p10)
-			fP10 -= fP10 % bucketSizeP10
+			fP10 := int64(math.Floor(f * p10))
+			fP10 = truncateInt64(fP10, bucketSizeP10)
 			f = float64(fP10) / p10
 
 			f += bf.bucketOffset
 
-			if fPrev == f {
+			if i > 0 && fPrev == f {
 				valuesBuf = append(valuesBuf, s)
 				continue
 			}
@@ -1116,12 +1202,11 @@ func (br *blockResult) getBucketedTimestampISO8601Values(valuesEncoded []string,
 			} else if bf.bucketSizeStr == "year" {
 				timestamp = truncateTimestampToYear(timestamp)
 			} else {
-				timestamp -= timestamp % bucketSizeInt
+				timestamp = truncateInt64(timestamp, bucketSizeInt)
 			}
-			timestamp -= timestamp % bucketSizeInt
 			timestamp += bucketOffsetInt
 
-			if timestampPrev == timestamp {
+			if i > 0 && timestampPrev == timestamp {
 				valuesBuf = append(valuesBuf, s)
 				continue
 			}
@@ -1167,8 +1252,8 @@ func (br *blockResult) getBucketedValue(s string, bf *byStatsField) string {
 		// emulate f % bucketSize for float64 values
 		_, e := decimal.FromFloat(bucketSize)
 		p10 := math.Pow10(int(-e))
-		fP10 := int64(f * p10)
-		fP10 -= fP10 % int64(bucketSize*p10)
+		fP10 := int64(math.Floor(f * p10))
+		fP10 = truncateInt64(fP10, int64(bucketSize*p10))
 		f = float64(fP10) / p10
 
 		f += bf.bucketOffset
@@ -1195,7 +1280,7 @@ func (br *blockResult) getBucketedValue(s string, bf *byStatsField) string {
 		} else if bf.bucketSizeStr == "year" {
 			timestamp = truncateTimestampToYear(timestamp)
 		} else {
-			timestamp -= timestamp % bucketSizeInt
+			timestamp = truncateInt64(timestamp, bucketSizeInt)
 		}
 		timestamp += bucketOffset
 
@@ -1232,7 +1317,7 @@ func (br *blockResult) getBucketedValue(s string, bf *byStatsField) string {
 		bucketOffset := int64(bf.bucketOffset)
 
 		nsecs -= bucketOffset
-		nsecs -= nsecs % bucketSizeInt
+		nsecs = truncateInt64(nsecs, bucketSizeInt)
 		nsecs += bucketOffset
 
 		buf := br.a.b
@@ -1254,8 +1339,13 @@ func (br *blockResult) copyColumns(srcColumnNames, dstColumnNames []string) {
 }
 
 func (br *blockResult) copySingleColumn(srcName, dstName string) {
-	found := false
 	cs := br.getColumns()
+	if srcName == dstName && getBlockResultColumnIdxByName(cs, srcName) >= 0 {
+		// Fast path - nothing to copy.
+		return
+	}
+
+	found := false
 	csBufLen := len(br.csBuf)
 	for _, c := range cs {
 		if c.name != dstName {
@@ -1283,8 +1373,13 @@ func (br *blockResult) renameColumns(srcColumnNames, dstColumnNames []string) {
 }
 
 func (br *blockResult) renameSingleColumn(srcName, dstName string) {
-	found := false
 	cs := br.getColumns()
+	if srcName == dstName && getBlockResultColumnIdxByName(cs, srcName) >= 0 {
+		// Fast path - nothing to rename.
+		return
+	}
+
+	found := false
 	csBufLen := len(br.csBuf)
 	for _, c := range cs {
 		if c.name == srcName {
@@ -1304,15 +1399,24 @@ func (br *blockResult) renameSingleColumn(srcName, dstName string) {
 }
 
 // deleteColumns deletes columns with the given columnNames.
+//
+// columnNames may contain wildcards in the form `prefix*`.
 func (br *blockResult) deleteColumns(columnNames []string) {
 	if len(columnNames) == 0 {
 		return
 	}
 
 	cs := br.getColumns()
+	if !slices.ContainsFunc(cs, func(c *blockResultColumn) bool {
+		return matchAnyFieldName(columnNames, c.name)
+	}) {
+		// Fast path - nothing to delete.
+		return
+	}
+
 	csBufLen := len(br.csBuf)
 	for _, c := range cs {
-		if !slices.Contains(columnNames, c.name) {
+		if !matchAnyFieldName(columnNames, c.name) {
 			br.csBuf = append(br.csBuf, *c)
 		}
 	}
@@ -1646,6 +1750,50 @@ func (c *blockResultColumn) getValues(br *blockResult) []string {
 	return c.values
 }
 
+// forEachDictValue calls f for every value from c.dictValues, which is referenced by at least a single row in br.
+//
+// c.dictValues may contain values for the rows, which didn't match the query filters, so they must be skipped.
+func (c *blockResultColumn) forEachDictValue(br *blockResult, f func(v string)) {
+	if c.valueType != valueTypeDict {
+		logger.Panicf("BUG: unexpected column valueType=%d; want %d", c.valueType, valueTypeDict)
+	}
+
+	if svec, ok := c.valuesEncodedCreator.(*searchValuesEncodedCreator); ok && svec.bm.areAllBitsSet() {
+		// Fast path - all the rows in the block are selected, so every dict value is referenced by at least a single row.
+		// There is no need in reading encoded values.
+		for _, v := range c.dictValues {
+			f(v)
+		}
+		return
+	}
+
+	c.forEachDictValueWithHits(br, func(v string, _ uint64) {
+		f(v)
+	})
+}
+
+// forEachDictValueWithHits calls f for every value from c.dictValues, which is referenced by at least a single row in br,
+// together with the number of rows referencing this value.
+func (c *blockResultColumn) forEachDictValueWithHits(br *blockResult, f func(v string, hits uint64)) {
+	if c.valueType != valueTypeDict {
+		logger.Panicf("BUG: unexpected column valueType=%d; want %d", c.valueType, valueTypeDict)
+	}
+
+	a := encoding.GetUint64s(len(c.dictValues))
+	hits := a.A
+	clear(hits)
+	for _, v := range c.getValuesEncoded(br) {
+		idx := unmarshalUint8(v)
+		hits[idx]++
+	}
+	for i, v := range c.dictValues {
+		if h := hits[i]; h > 0 {
+			f(v, h)
+		}
+	}
+	encoding.PutUint64s(a)
+}
+
 // getValuesEncoded returns encoded values for the given column.
 //
 // The returned values are valid until br.reset() is called.
@@ -1886,6 +2034,17 @@ func (rc *resultColumn) addValue(v string) {
 	rc.values = append(rc.values, v)
 }
 
+// truncateInt64 truncates n to the multiple of bucketSize towards negative infinity.
+//
+// This guarantees that negative values, such as timestamps before 1970 or negative numbers, are put into the correct bucket.
+func truncateInt64(n, bucketSize int64) int64 {
+	r := n % bucketSize
+	if r < 0 {
+		r += bucketSize
+	}
+	return n - r
+}
+
 func truncateTimestampToMonth(timestamp int64) int64 {
 	t := time.Unix(0, timestamp).UTC()
 	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).UnixNano()
@@ -1896,15 +2055,19 @@ func truncateTimestampToYear(timestamp int64) int64 {
 	return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, time.UTC).UnixNano()
 }
 
+// getEmptyStrings returns rowsCount empty strings.
+//
+// The returned strings are shared among all the callers, so they mustn't be modified.
 func getEmptyStrings(rowsCount int) []string {
 	p := emptyStrings.Load()
-	if p == nil {
+	if p == nil || cap(*p) < rowsCount {
+		// Store the bigger slice, so the next calls with the same rowsCount do not allocate memory.
 		values := make([]string, rowsCount)
 		emptyStrings.Store(&values)
 		return values
 	}
 	values := *p
-	return slicesutil.SetLength(values, rowsCount)
+	return values[:rowsCount]
 }
 
 var emptyStrings atomic.Pointer[[]string]
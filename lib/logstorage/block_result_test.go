@@ -0,0 +1,46 @@
+package logstorage
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+func TestBlockResultGetBucketedFloat64Values(t *testing.T) {
+	f := func(bucketStr string, values []float64, resultExpected []string) {
+		t.Helper()
+
+		lex := newLexer("(x:" + bucketStr + ")")
+		bfs, err := parseByStatsFields(lex)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing bucket %q: %s", bucketStr, err)
+		}
+
+		valuesEncoded := make([]string, len(values))
+		for i, v := range values {
+			valuesEncoded[i] = string(encoding.MarshalUint64(nil, math.Float64bits(v)))
+		}
+		c := &blockResultColumn{
+			name:          "x",
+			valueType:     valueTypeFloat64,
+			valuesEncoded: valuesEncoded,
+		}
+
+		var br blockResult
+		br.timestamps = make([]int64, len(values))
+		result := br.newValuesBucketedForColumn(c, bfs[0])
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result for bucket %q; got %q; want %q", bucketStr, result, resultExpected)
+		}
+	}
+
+	// the first value falls into zero bucket
+	f("1", []float64{0.5, 1.5, 0.25}, []string{"0", "1", "0"})
+
+	// negative values must be truncated towards negative infinity
+	f("1", []float64{-0.5, -1.5, 2.5}, []string{"-1", "-2", "2"})
+	f("0.5", []float64{-0.25, 0.75}, []string{"-0.5", "0.5"})
+	f("10 offset 5", []float64{-1, 4, 5, 14}, []string{"-5", "-5", "5", "5"})
+}
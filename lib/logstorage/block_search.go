@@ -152,21 +152,16 @@ func (bs *blockSearch) partPath() string {
 }
 
 func (bs *blockSearch) search(bsw *blockSearchWork, bm *bitmap) {
-	bs.reset()
-
-	bs.bsw = bsw
+	bs.searchRows(bsw, bm)
 
-	bs.csh.initFromBlockHeader(&bs.a, bsw.p, &bsw.bh)
-
-	// search rows matching the given filter
-	bm.init(int(bsw.bh.rowsCount))
-	bm.setBits()
-	bs.bsw.so.filter.applyToBlockSearch(bs, bm)
+	qs := bsw.so.qs
 
 	if bm.isZero() {
 		// The filter doesn't match any logs in the current block.
+		qs.updateBlockStats(int(bsw.bh.rowsCount), 0)
 		return
 	}
+	qs.updateBlockStats(int(bsw.bh.rowsCount), bm.onesCount())
 
 	bs.br.mustInit(bs, bm)
 
@@ -178,6 +173,21 @@ func (bs *blockSearch) search(bsw *blockSearchWork, bm *bitmap) {
 	}
 }
 
+// searchRows sets bits at bm for rows matching bsw.so.filter in the block referred by bsw.
+func (bs *blockSearch) searchRows(bsw *blockSearchWork, bm *bitmap) {
+	bs.reset()
+
+	bs.bsw = bsw
+
+	bs.csh.initFromBlockHeader(&bs.a, bsw.p, &bsw.bh)
+	bsw.so.qs.addBytesRead(bsw.bh.columnsHeaderSize)
+
+	// search rows matching the given filter
+	bm.init(int(bsw.bh.rowsCount))
+	bm.setBits()
+	bs.bsw.so.filter.applyToBlockSearch(bs, bm)
+}
+
 func (csh *columnsHeader) initFromBlockHeader(a *arena, p *part, bh *blockHeader) {
 	bb := longTermBufPool.Get()
 	columnsHeaderSize := bh.columnsHeaderSize
@@ -216,6 +226,7 @@ func (bs *blockSearch) getBloomFilterForColumn(ch *columnHeader) *bloomFilter {
 	}
 	bb.B = bytesutil.ResizeNoCopyMayOverallocate(bb.B, int(bloomFilterSize))
 	bloomFilterFile.MustReadAt(bb.B, int64(ch.bloomFilterOffset))
+	bs.bsw.so.qs.addBytesRead(bloomFilterSize)
 	bf = getBloomFilter()
 	if err := bf.unmarshal(bb.B); err != nil {
 		logger.Panicf("FATAL: %s: cannot unmarshal bloom filter: %s", bs.partPath(), err)
@@ -252,14 +263,22 @@ func (bs *blockSearch) getValuesForColumn(ch *columnHeader) []string {
 	}
 	bb.B = bytesutil.ResizeNoCopyMayOverallocate(bb.B, int(valuesSize))
 	valuesFile.MustReadAt(bb.B, int64(ch.valuesOffset))
+	bs.bsw.so.qs.addBytesRead(valuesSize)
 
 	values = getStringBucket()
 	var err error
-	values.a, err = bs.sbu.unmarshal(values.a[:0], bb.B, bs.bsw.bh.rowsCount)
+	values.a, err = bs.sbu.unmarshal(values.a[:0], bb.B, bs.bsw.bh.rowsCount, p.zstdDict)
 	longTermBufPool.Put(bb)
 	if err != nil {
 		logger.Panicf("FATAL: %s: cannot unmarshal column %q: %s", bs.partPath(), ch.name, err)
 	}
+	if qs := bs.bsw.so.qs; qs.isExecStatsEnabled() {
+		n := 0
+		for _, v := range values.a {
+			n += len(v)
+		}
+		qs.addBytesDecompressed(uint64(n))
+	}
 
 	if bs.valuesCache == nil {
 		bs.valuesCache = make(map[string]*stringBucket)
@@ -287,6 +306,7 @@ func (bs *blockSearch) getTimestamps() []int64 {
 	}
 	bb.B = bytesutil.ResizeNoCopyMayOverallocate(bb.B, int(blockSize))
 	p.timestampsFile.MustReadAt(bb.B, int64(th.blockOffset))
+	bs.bsw.so.qs.addBytesRead(blockSize)
 
 	rowsCount := int(bs.bsw.bh.rowsCount)
 	timestamps = encoding.GetInt64s(rowsCount)
@@ -296,6 +316,7 @@ func (bs *blockSearch) getTimestamps() []int64 {
 	if err != nil {
 		logger.Panicf("FATAL: %s: cannot unmarshal timestamps: %s", bs.partPath(), err)
 	}
+	bs.bsw.so.qs.addBytesDecompressed(uint64(len(timestamps.A)) * 8)
 	bs.timestampsCache = timestamps
 	return timestamps.A
 }
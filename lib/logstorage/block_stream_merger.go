@@ -11,16 +11,22 @@ import (
 
 // mustMergeBlockStreams merges bsrs to bsw and updates ph accordingly.
 //
+// Log entries outside the retention for their log streams according to srm are dropped during the merge.
+// srm may be nil if there are no retention filters.
+//
+// Log entries matching blockStreamReader.deleteSO filters are dropped during the merge.
+//
 // Finalize() is guaranteed to be called on bsrs and bsw before returning from the func.
-func mustMergeBlockStreams(ph *partHeader, bsw *blockStreamWriter, bsrs []*blockStreamReader, stopCh <-chan struct{}) {
+func mustMergeBlockStreams(ph *partHeader, bsw *blockStreamWriter, bsrs []*blockStreamReader, srm *streamRetentionMatcher, stopCh <-chan struct{}) {
 	bsm := getBlockStreamMerger()
-	bsm.mustInit(bsw, bsrs)
+	bsm.mustInit(bsw, bsrs, srm)
 	for len(bsm.readersHeap) > 0 {
 		if needStop(stopCh) {
 			break
 		}
 		bsr := bsm.readersHeap[0]
-		bsm.mustWriteBlock(&bsr.blockData, bsw)
+		deletedRows := bsm.getDeletedRows(bsr)
+		bsm.mustWriteBlock(&bsr.blockData, deletedRows, bsw)
 		if bsr.NextBlock() {
 			heap.Fix(&bsm.readersHeap, 0)
 		} else {
@@ -46,6 +52,20 @@ type blockStreamMerger struct {
 	// readersHeap contains a heap of readers to read blocks to merge.
 	readersHeap blockStreamReadersHeap
 
+	// srm is used for obtaining the minimum allowed timestamps for log streams according to retention filters.
+	//
+	// It may be nil if there are no retention filters.
+	srm *streamRetentionMatcher
+
+	// mayDropRows is set to true if log entries may be dropped during the merge because of srm or delete filters at bsrs.
+	mayDropRows bool
+
+	// bsWork is used for searching log entries to delete at the current block.
+	bsWork blockSearchWork
+
+	// deletedRows contains log entries to delete at the current block.
+	deletedRows bitmap
+
 	// streamID is the stream ID for the pending data.
 	streamID streamID
 
@@ -88,6 +108,11 @@ func (bsm *blockStreamMerger) reset() {
 	}
 	bsm.readersHeap = rhs[:0]
 
+	bsm.srm = nil
+	bsm.mayDropRows = false
+	bsm.bsWork.reset()
+	bsm.deletedRows.reset()
+
 	bsm.streamID.reset()
 	bsm.resetRows()
 }
@@ -111,14 +136,19 @@ func (bsm *blockStreamMerger) resetRows() {
 	bsm.uniqueFields = 0
 }
 
-func (bsm *blockStreamMerger) mustInit(bsw *blockStreamWriter, bsrs []*blockStreamReader) {
+func (bsm *blockStreamMerger) mustInit(bsw *blockStreamWriter, bsrs []*blockStreamReader, srm *streamRetentionMatcher) {
 	bsm.reset()
 
 	bsm.bsw = bsw
 	bsm.bsrs = bsrs
+	bsm.srm = srm
+	bsm.mayDropRows = srm != nil
 
 	rsh := bsm.readersHeap[:0]
 	for _, bsr := range bsrs {
+		if bsr.deleteSO != nil {
+			bsm.mayDropRows = true
+		}
 		if bsr.NextBlock() {
 			rsh = append(rsh, bsr)
 		}
@@ -127,10 +157,59 @@ func (bsm *blockStreamMerger) mustInit(bsw *blockStreamWriter, bsrs []*blockStre
 	heap.Init(&bsm.readersHeap)
 }
 
+// getDeletedRows returns log entries to delete from the current block at bsr.
+//
+// nil is returned if there is no need to delete log entries from the current block.
+func (bsm *blockStreamMerger) getDeletedRows(bsr *blockStreamReader) *bitmap {
+	if bsr.deleteSO == nil {
+		return nil
+	}
+
+	bsw := &bsm.bsWork
+	bsw.p = bsr.p
+	bsw.so = bsr.deleteSO
+	bsw.bh.copyFrom(&bsr.blockHeaders[bsr.nextBlockIdx-1])
+
+	bs := getBlockSearch()
+	bs.searchRows(bsw, &bsm.deletedRows)
+	putBlockSearch(bs)
+
+	if bsm.deletedRows.isZero() {
+		return nil
+	}
+	return &bsm.deletedRows
+}
+
 // mustWriteBlock writes bd to bsm
-func (bsm *blockStreamMerger) mustWriteBlock(bd *blockData, bsw *blockStreamWriter) {
+//
+// Log entries with set bits at deletedRows are dropped. deletedRows may be nil if there is no need to drop log entries.
+func (bsm *blockStreamMerger) mustWriteBlock(bd *blockData, deletedRows *bitmap, bsw *blockStreamWriter) {
 	bsm.checkNextBlock(bd)
+
+	minTimestamp := bsm.srm.getMinTimestamp(&bd.streamID)
+	if bd.timestampsData.maxTimestamp < minTimestamp {
+		// Fast path - all the log entries in bd are outside the retention for the log stream. Drop them.
+		return
+	}
+	if deletedRows != nil && deletedRows.areAllBitsSet() {
+		// Fast path - all the log entries in bd must be deleted. Drop them.
+		return
+	}
+
 	uniqueFields := len(bd.columnsData) + len(bd.constColumns)
+	if bd.timestampsData.minTimestamp < minTimestamp || deletedRows != nil || bsm.hasNewerRows(bd) {
+		// Slow path - some log entries in bd are outside the retention for the log stream or they must be deleted,
+		// or the current log entries are newer than bd because of the dropped log entries.
+		// Merge the remaining log entries from bd with the current log entries, so the written blocks remain sorted by timestamp.
+		if !bd.streamID.equal(&bsm.streamID) {
+			bsm.mustFlushRows()
+			bsm.streamID = bd.streamID
+		}
+		bsm.mustMergeRows(bd, minTimestamp, deletedRows)
+		bsm.uniqueFields += uniqueFields
+		return
+	}
+
 	switch {
 	case !bd.streamID.equal(&bsm.streamID):
 		// The bd contains another streamID.
@@ -169,11 +248,21 @@ func (bsm *blockStreamMerger) mustWriteBlock(bd *blockData, bsw *blockStreamWrit
 	default:
 		// The bd contains the same streamID and it isn't full,
 		// so it must be merged with the current log entries.
-		bsm.mustMergeRows(bd)
+		bsm.mustMergeRows(bd, minTimestamp, nil)
 		bsm.uniqueFields += uniqueFields
 	}
 }
 
+// hasNewerRows returns true if the current log entries belong to bd.streamID and contain only log entries newer than bd.
+//
+// This is possible only if log entries outside the retention have been dropped from the current log entries.
+func (bsm *blockStreamMerger) hasNewerRows(bd *blockData) bool {
+	if len(bsm.rows.timestamps) == 0 || !bd.streamID.equal(&bsm.streamID) {
+		return false
+	}
+	return bsm.rows.timestamps[0] > bd.timestampsData.minTimestamp
+}
+
 // checkNextBlock checks whether the bd can be written next after the current data.
 func (bsm *blockStreamMerger) checkNextBlock(bd *blockData) {
 	if len(bsm.rows.timestamps) > 0 && bsm.bd.rowsCount > 0 {
@@ -190,6 +279,11 @@ func (bsm *blockStreamMerger) checkNextBlock(bd *blockData) {
 	if bd.rowsCount == 0 {
 		return
 	}
+	if bsm.mayDropRows {
+		// The current log entries may have bigger minTimestamp than the next block,
+		// since log entries outside the retention or deleted log entries may be dropped from the current log entries.
+		return
+	}
 	nextMinTimestamp := bd.timestampsData.minTimestamp
 	if len(bsm.rows.timestamps) == 0 {
 		if bsm.bd.rowsCount == 0 {
@@ -219,17 +313,19 @@ func (bsm *blockStreamMerger) ReadersPaths() string {
 }
 
 // mustMergeRows merges the current log entries inside bsm with bd log entries.
-func (bsm *blockStreamMerger) mustMergeRows(bd *blockData) {
+//
+// bd log entries with timestamps smaller than minTimestamp and log entries with set bits at deletedRows are dropped.
+func (bsm *blockStreamMerger) mustMergeRows(bd *blockData, minTimestamp int64, deletedRows *bitmap) {
 	if bsm.bd.rowsCount > 0 {
 		// Unmarshal log entries from bsm.bd
-		bsm.mustUnmarshalRows(&bsm.bd)
+		bsm.mustUnmarshalRows(&bsm.bd, minTimestamp, nil)
 		bsm.bd.reset()
 		bsm.a.reset()
 	}
 
 	// Unmarshal log entries from bd
 	rowsLen := len(bsm.rows.timestamps)
-	bsm.mustUnmarshalRows(bd)
+	bsm.mustUnmarshalRows(bd, minTimestamp, deletedRows)
 
 	// Merge unmarshaled log entries
 	timestamps := bsm.rows.timestamps
@@ -243,7 +339,10 @@ func (bsm *blockStreamMerger) mustMergeRows(bd *blockData) {
 	}
 }
 
-func (bsm *blockStreamMerger) mustUnmarshalRows(bd *blockData) {
+// mustUnmarshalRows appends log entries from bd to bsm.rows.
+//
+// Log entries with timestamps smaller than minTimestamp and log entries with set bits at deletedRows are dropped.
+func (bsm *blockStreamMerger) mustUnmarshalRows(bd *blockData, minTimestamp int64, deletedRows *bitmap) {
 	rowsLen := len(bsm.rows.timestamps)
 	if bsm.sbu == nil {
 		bsm.sbu = getStringsBlockUnmarshaler()
@@ -254,18 +353,54 @@ func (bsm *blockStreamMerger) mustUnmarshalRows(bd *blockData) {
 	if err := bd.unmarshalRows(&bsm.rows, bsm.sbu, bsm.vd); err != nil {
 		logger.Panicf("FATAL: cannot merge %s: cannot unmarshal log entries from blockData: %s", bsm.ReadersPaths(), err)
 	}
+	if deletedRows != nil {
+		bsm.rows.dropRows(rowsLen, deletedRows)
+	}
+	if bd.timestampsData.minTimestamp < minTimestamp {
+		bsm.rows.dropRowsBefore(rowsLen, minTimestamp)
+	}
 	bsm.uncompressedRowsSizeBytes += uncompressedRowsSizeBytes(bsm.rows.rows[rowsLen:])
 }
 
 func (bsm *blockStreamMerger) mustFlushRows() {
 	if len(bsm.rows.timestamps) == 0 {
 		bsm.bsw.MustWriteBlockData(&bsm.bd)
-	} else {
+	} else if bsm.uniqueFields < maxColumnsPerBlock {
 		bsm.bsw.MustWriteRows(&bsm.streamID, bsm.rows.timestamps, bsm.rows.rows)
+	} else {
+		// The log entries may contain too many unique fields after merging blocks with dropped log entries.
+		bsm.mustWriteRowsWithLimitedColumns()
 	}
 	bsm.resetRows()
 }
 
+// mustWriteRowsWithLimitedColumns writes the current log entries to bsm.bsw in blocks with up to maxColumnsPerBlock columns.
+func (bsm *blockStreamMerger) mustWriteRowsWithLimitedColumns() {
+	timestamps := bsm.rows.timestamps
+	rows := bsm.rows.rows
+
+	m := getFieldsSet()
+	start := 0
+	for i, fields := range rows {
+		newFields := 0
+		for _, f := range fields {
+			if _, ok := m[f.Name]; !ok {
+				newFields++
+			}
+		}
+		if len(m)+newFields > maxColumnsPerBlock {
+			bsm.bsw.MustWriteRows(&bsm.streamID, timestamps[start:i], rows[start:i])
+			start = i
+			clear(m)
+		}
+		for _, f := range fields {
+			m[f.Name] = struct{}{}
+		}
+	}
+	bsm.bsw.MustWriteRows(&bsm.streamID, timestamps[start:], rows[start:])
+	putFieldsSet(m)
+}
+
 func getBlockStreamMerger() *blockStreamMerger {
 	v := blockStreamMergerPool.Get()
 	if v == nil {
@@ -4,6 +4,7 @@ import (
 	"path/filepath"
 	"sync"
 
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/filestream"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
@@ -57,6 +58,9 @@ type streamReaders struct {
 	fieldBloomFilterReader   readerWithStats
 	messageValuesReader      readerWithStats
 	messageBloomFilterReader readerWithStats
+
+	// zstdDict is an optional ZSTD dictionary used for compressing string values in the part.
+	zstdDict *zstd.Dict
 }
 
 func (sr *streamReaders) reset() {
@@ -68,10 +72,11 @@ func (sr *streamReaders) reset() {
 	sr.fieldBloomFilterReader.reset()
 	sr.messageValuesReader.reset()
 	sr.messageBloomFilterReader.reset()
+	sr.zstdDict = nil
 }
 
 func (sr *streamReaders) init(metaindexReader, indexReader, columnsHeaderReader, timestampsReader, fieldValuesReader, fieldBloomFilterReader,
-	messageValuesReader, messageBloomFilterReader filestream.ReadCloser,
+	messageValuesReader, messageBloomFilterReader filestream.ReadCloser, zstdDict *zstd.Dict,
 ) {
 	sr.metaindexReader.init(metaindexReader)
 	sr.indexReader.init(indexReader)
@@ -81,6 +86,7 @@ func (sr *streamReaders) init(metaindexReader, indexReader, columnsHeaderReader,
 	sr.fieldBloomFilterReader.init(fieldBloomFilterReader)
 	sr.messageValuesReader.init(messageValuesReader)
 	sr.messageBloomFilterReader.init(messageBloomFilterReader)
+	sr.zstdDict = zstdDict
 }
 
 func (sr *streamReaders) totalBytesRead() uint64 {
@@ -147,6 +153,14 @@ type blockStreamReader struct {
 
 	// minTimestampLast is the minimum timestamp for the previously read block
 	minTimestampLast int64
+
+	// p is the part for the read blocks. It is used for searching log entries to delete with deleteSO.
+	p *part
+
+	// deleteSO contains search options with the filter for log entries to delete from the read blocks during the merge.
+	//
+	// It is nil if there is no need to delete log entries from the read blocks.
+	deleteSO *searchOptions
 }
 
 // reset resets bsr, so it can be re-used
@@ -180,6 +194,9 @@ func (bsr *blockStreamReader) reset() {
 
 	bsr.sidLast.reset()
 	bsr.minTimestampLast = 0
+
+	bsr.p = nil
+	bsr.deleteSO = nil
 }
 
 // Path returns part path for bsr (e.g. file path, url or in-memory reference)
@@ -189,10 +206,13 @@ func (bsr *blockStreamReader) Path() string {
 }
 
 // MustInitFromInmemoryPart initializes bsr from mp.
-func (bsr *blockStreamReader) MustInitFromInmemoryPart(mp *inmemoryPart) {
+//
+// zstdDict must contain ZSTD dictionary used for compressing string values at mp if mp.ph.ZSTDDictID isn't zero.
+func (bsr *blockStreamReader) MustInitFromInmemoryPart(mp *inmemoryPart, zstdDict *zstd.Dict) {
 	bsr.reset()
 
 	bsr.ph = mp.ph
+	mustCheckZSTDDict(&bsr.ph, zstdDict, "inmemory part")
 
 	// Initialize streamReaders
 	metaindexReader := mp.metaindex.NewReader()
@@ -205,14 +225,16 @@ func (bsr *blockStreamReader) MustInitFromInmemoryPart(mp *inmemoryPart) {
 	messageBloomFilterReader := mp.messageBloomFilter.NewReader()
 
 	bsr.streamReaders.init(metaindexReader, indexReader, columnsHeaderReader, timestampsReader,
-		fieldValuesReader, fieldBloomFilterReader, messageValuesReader, messageBloomFilterReader)
+		fieldValuesReader, fieldBloomFilterReader, messageValuesReader, messageBloomFilterReader, zstdDict)
 
 	// Read metaindex data
 	bsr.indexBlockHeaders = mustReadIndexBlockHeaders(bsr.indexBlockHeaders[:0], &bsr.streamReaders.metaindexReader)
 }
 
 // MustInitFromFilePart initializes bsr from file part at the given path.
-func (bsr *blockStreamReader) MustInitFromFilePart(path string) {
+//
+// zstdDict must contain ZSTD dictionary used for compressing string values at the part if the part has non-zero ZSTDDictID.
+func (bsr *blockStreamReader) MustInitFromFilePart(path string, zstdDict *zstd.Dict) {
 	bsr.reset()
 
 	// Files in the part are always read without OS cache pollution,
@@ -229,6 +251,7 @@ func (bsr *blockStreamReader) MustInitFromFilePart(path string) {
 	messageBloomFilterPath := filepath.Join(path, messageBloomFilename)
 
 	bsr.ph.mustReadMetadata(path)
+	mustCheckZSTDDict(&bsr.ph, zstdDict, path)
 
 	// Open data readers
 	metaindexReader := filestream.MustOpen(metaindexPath, nocache)
@@ -242,7 +265,35 @@ func (bsr *blockStreamReader) MustInitFromFilePart(path string) {
 
 	// Initialize streamReaders
 	bsr.streamReaders.init(metaindexReader, indexReader, columnsHeaderReader, timestampsReader,
-		fieldValuesReader, fieldBloomFilterReader, messageValuesReader, messageBloomFilterReader)
+		fieldValuesReader, fieldBloomFilterReader, messageValuesReader, messageBloomFilterReader, zstdDict)
+
+	// Read metaindex data
+	bsr.indexBlockHeaders = mustReadIndexBlockHeaders(bsr.indexBlockHeaders[:0], &bsr.streamReaders.metaindexReader)
+}
+
+// MustInitFromObjectStoragePart initializes bsr from the part p with data files stored at object storage.
+func (bsr *blockStreamReader) MustInitFromObjectStoragePart(p *part) {
+	bsr.reset()
+
+	path := p.path
+	bsr.ph.mustReadMetadata(path)
+	mustCheckZSTDDict(&bsr.ph, p.zstdDict, path)
+
+	// Open data readers. The metaindex file is stored locally.
+	obs := p.pt.s.objectStorage
+	osfs := p.objectStorageFiles
+	metaindexReader := filestream.MustOpen(filepath.Join(path, metaindexFilename), true)
+	indexReader := obs.newStreamReader(getObjectStorageFile(osfs, path, indexFilename))
+	columnsHeaderReader := obs.newStreamReader(getObjectStorageFile(osfs, path, columnsHeaderFilename))
+	timestampsReader := obs.newStreamReader(getObjectStorageFile(osfs, path, timestampsFilename))
+	fieldValuesReader := obs.newStreamReader(getObjectStorageFile(osfs, path, fieldValuesFilename))
+	fieldBloomFilterReader := obs.newStreamReader(getObjectStorageFile(osfs, path, fieldBloomFilename))
+	messageValuesReader := obs.newStreamReader(getObjectStorageFile(osfs, path, messageValuesFilename))
+	messageBloomFilterReader := obs.newStreamReader(getObjectStorageFile(osfs, path, messageBloomFilename))
+
+	// Initialize streamReaders
+	bsr.streamReaders.init(metaindexReader, indexReader, columnsHeaderReader, timestampsReader,
+		fieldValuesReader, fieldBloomFilterReader, messageValuesReader, messageBloomFilterReader, p.zstdDict)
 
 	// Read metaindex data
 	bsr.indexBlockHeaders = mustReadIndexBlockHeaders(bsr.indexBlockHeaders[:0], &bsr.streamReaders.metaindexReader)
@@ -6,6 +6,7 @@ import (
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/filestream"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
@@ -52,6 +53,12 @@ type streamWriters struct {
 	fieldBloomFilterWriter   writerWithStats
 	messageValuesWriter      writerWithStats
 	messageBloomFilterWriter writerWithStats
+	fieldsIndexWriter        writerWithStats
+
+	// zstdDict is an optional ZSTD dictionary for compressing string values.
+	//
+	// It is shared among all the parts in the partition.
+	zstdDict *zstd.Dict
 }
 
 func (sw *streamWriters) reset() {
@@ -63,10 +70,12 @@ func (sw *streamWriters) reset() {
 	sw.fieldBloomFilterWriter.reset()
 	sw.messageValuesWriter.reset()
 	sw.messageBloomFilterWriter.reset()
+	sw.fieldsIndexWriter.reset()
+	sw.zstdDict = nil
 }
 
 func (sw *streamWriters) init(metaindexWriter, indexWriter, columnsHeaderWriter, timestampsWriter, fieldValuesWriter, fieldBloomFilterWriter,
-	messageValuesWriter, messageBloomFilterWriter filestream.WriteCloser,
+	messageValuesWriter, messageBloomFilterWriter, fieldsIndexWriter filestream.WriteCloser, zstdDict *zstd.Dict,
 ) {
 	sw.metaindexWriter.init(metaindexWriter)
 	sw.indexWriter.init(indexWriter)
@@ -76,6 +85,8 @@ func (sw *streamWriters) init(metaindexWriter, indexWriter, columnsHeaderWriter,
 	sw.fieldBloomFilterWriter.init(fieldBloomFilterWriter)
 	sw.messageValuesWriter.init(messageValuesWriter)
 	sw.messageBloomFilterWriter.init(messageBloomFilterWriter)
+	sw.fieldsIndexWriter.init(fieldsIndexWriter)
+	sw.zstdDict = zstdDict
 }
 
 func (sw *streamWriters) totalBytesWritten() uint64 {
@@ -88,6 +99,9 @@ func (sw *streamWriters) totalBytesWritten() uint64 {
 	n += sw.fieldBloomFilterWriter.bytesWritten
 	n += sw.messageValuesWriter.bytesWritten
 	n += sw.messageBloomFilterWriter.bytesWritten
+
+	// Do not count fieldsIndexWriter, since the fields index isn't read by blockStreamReader,
+	// and it is missing in parts created by older releases.
 	return n
 }
 
@@ -100,6 +114,7 @@ func (sw *streamWriters) MustClose() {
 	sw.fieldBloomFilterWriter.MustClose()
 	sw.messageValuesWriter.MustClose()
 	sw.messageBloomFilterWriter.MustClose()
+	sw.fieldsIndexWriter.MustClose()
 }
 
 // blockStreamWriter is used for writing blocks into the underlying storage in streaming manner.
@@ -148,6 +163,9 @@ type blockStreamWriter struct {
 
 	// indexBlockHeader is used for marshaling the data to metaindexData
 	indexBlockHeader indexBlockHeader
+
+	// fieldsIndexBuilder builds the fields index for the written blocks, which is written to fieldsIndexFilename
+	fieldsIndexBuilder partFieldsIndexBuilder
 }
 
 // reset resets bsw for subsequent re-use.
@@ -175,18 +193,23 @@ func (bsw *blockStreamWriter) reset() {
 	}
 
 	bsw.indexBlockHeader.reset()
+	bsw.fieldsIndexBuilder.reset()
 }
 
 // MustInitForInmemoryPart initializes bsw from mp
-func (bsw *blockStreamWriter) MustInitForInmemoryPart(mp *inmemoryPart) {
+//
+// zstdDict is an optional ZSTD dictionary for compressing string values.
+func (bsw *blockStreamWriter) MustInitForInmemoryPart(mp *inmemoryPart, zstdDict *zstd.Dict) {
 	bsw.reset()
-	bsw.streamWriters.init(&mp.metaindex, &mp.index, &mp.columnsHeader, &mp.timestamps, &mp.fieldValues, &mp.fieldBloomFilter, &mp.messageValues, &mp.messageBloomFilter)
+	bsw.streamWriters.init(&mp.metaindex, &mp.index, &mp.columnsHeader, &mp.timestamps, &mp.fieldValues, &mp.fieldBloomFilter, &mp.messageValues, &mp.messageBloomFilter, &mp.fieldsIndex, zstdDict)
 }
 
 // MustInitForFilePart initializes bsw for writing data to file part located at path.
 //
 // if nocache is true, then the written data doesn't go to OS page cache.
-func (bsw *blockStreamWriter) MustInitForFilePart(path string, nocache bool) {
+//
+// zstdDict is an optional ZSTD dictionary for compressing string values.
+func (bsw *blockStreamWriter) MustInitForFilePart(path string, nocache bool, zstdDict *zstd.Dict) {
 	bsw.reset()
 
 	fs.MustMkdirFailIfExist(path)
@@ -199,9 +222,11 @@ func (bsw *blockStreamWriter) MustInitForFilePart(path string, nocache bool) {
 	fieldBloomFilterPath := filepath.Join(path, fieldBloomFilename)
 	messageValuesPath := filepath.Join(path, messageValuesFilename)
 	messageBloomFilterPath := filepath.Join(path, messageBloomFilename)
+	fieldsIndexPath := filepath.Join(path, fieldsIndexFilename)
 
-	// Always cache metaindex file, since it it re-read immediately after part creation
+	// Always cache metaindex and fields index files, since they are re-read immediately after part creation
 	metaindexWriter := filestream.MustCreate(metaindexPath, false)
+	fieldsIndexWriter := filestream.MustCreate(fieldsIndexPath, false)
 
 	indexWriter := filestream.MustCreate(indexPath, nocache)
 	columnsHeaderWriter := filestream.MustCreate(columnsHeaderPath, nocache)
@@ -212,7 +237,7 @@ func (bsw *blockStreamWriter) MustInitForFilePart(path string, nocache bool) {
 	messageBloomFilterWriter := filestream.MustCreate(messageBloomFilterPath, nocache)
 
 	bsw.streamWriters.init(metaindexWriter, indexWriter, columnsHeaderWriter, timestampsWriter,
-		fieldValuesWriter, fieldBloomFilterWriter, messageValuesWriter, messageBloomFilterWriter)
+		fieldValuesWriter, fieldBloomFilterWriter, messageValuesWriter, messageBloomFilterWriter, fieldsIndexWriter, zstdDict)
 }
 
 // MustWriteRows writes timestamps with rows under the given sid to bsw.
@@ -265,10 +290,25 @@ func (bsw *blockStreamWriter) mustWriteBlockInternal(sid *streamID, b *block, bd
 	bsw.sidLast = *sid
 
 	bh := getBlockHeader()
+	fib := &bsw.fieldsIndexBuilder
 	if b != nil {
 		b.mustWriteTo(sid, bh, &bsw.streamWriters)
+		fib.addBlock(sid, bh.rowsCount)
+		for i := range b.columns {
+			fib.addColumn(b.columns[i].name)
+		}
+		for _, cc := range b.constColumns {
+			fib.addConstColumn(cc.Name, cc.Value)
+		}
 	} else {
 		bd.mustWriteTo(bh, &bsw.streamWriters)
+		fib.addBlock(sid, bh.rowsCount)
+		for i := range bd.columnsData {
+			fib.addColumn(bd.columnsData[i].name)
+		}
+		for _, cc := range bd.constColumns {
+			fib.addConstColumn(cc.Name, cc.Value)
+		}
 	}
 	th := &bh.timestampsHeader
 	if bsw.globalRowsCount == 0 || th.minTimestamp < bsw.globalMinTimestamp {
@@ -318,6 +358,10 @@ func (bsw *blockStreamWriter) mustFlushIndexBlock(data []byte) {
 //
 // bsw can be re-used after calling Finalize().
 func (bsw *blockStreamWriter) Finalize(ph *partHeader) {
+	ph.FormatVersion = partFormatLatestVersion
+	if zd := bsw.streamWriters.zstdDict; zd != nil {
+		ph.ZSTDDictID = zd.ID()
+	}
 	ph.UncompressedSizeBytes = bsw.globalUncompressedSizeBytes
 	ph.RowsCount = bsw.globalRowsCount
 	ph.BlocksCount = bsw.globalBlocksCount
@@ -334,6 +378,14 @@ func (bsw *blockStreamWriter) Finalize(ph *partHeader) {
 		longTermBufPool.Put(bb)
 	}
 
+	// Write fields index
+	bb = longTermBufPool.Get()
+	bb.B = bsw.fieldsIndexBuilder.finish(bb.B[:0])
+	bsw.streamWriters.fieldsIndexWriter.MustWrite(bb.B)
+	if len(bb.B) < 1024*1024 {
+		longTermBufPool.Put(bb)
+	}
+
 	ph.CompressedSizeBytes = bsw.streamWriters.totalBytesWritten()
 
 	bsw.streamWriters.MustClose()
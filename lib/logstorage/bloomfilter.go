@@ -3,20 +3,51 @@ package logstorage
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/cespare/xxhash/v2"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/slicesutil"
 )
 
 // bloomFilterHashesCount is the number of different hashes to use for bloom filter.
 const bloomFilterHashesCount = 6
 
-// bloomFilterBitsPerItem is the number of bits to use per each token.
-const bloomFilterBitsPerItem = 16
+// DefaultBloomFilterBitsPerToken is the default number of bits to use per each token in bloom filters.
+const DefaultBloomFilterBitsPerToken = 16
+
+// MinBloomFilterBitsPerToken and MaxBloomFilterBitsPerToken are the limits for the value passed to SetBloomFilterBitsPerToken.
+//
+// The upper limit guarantees that the bloom filter size doesn't exceed maxBloomFilterBlockSize for blocks with up to maxUncompressedBlockSize bytes.
+const (
+	MinBloomFilterBitsPerToken = 4
+	MaxBloomFilterBitsPerToken = 32
+)
+
+// bloomFilterBitsPerItem is the number of bits to use per each token in newly created bloom filters.
+//
+// Bloom filters at the existing parts remain readable after the change of this value,
+// since the bloom filter size is derived from the stored bloom filter data.
+var bloomFilterBitsPerItem atomic.Int64
+
+func init() {
+	bloomFilterBitsPerItem.Store(DefaultBloomFilterBitsPerToken)
+}
+
+// SetBloomFilterBitsPerToken sets the number of bits to use per each token in bloom filters for newly created parts.
+//
+// Bigger values reduce the false positive rate for bloom filters at the cost of bigger disk space usage.
+// The change affects only new parts. The existing parts get the updated bloom filters after they are merged.
+func SetBloomFilterBitsPerToken(n int) {
+	if n < MinBloomFilterBitsPerToken || n > MaxBloomFilterBitsPerToken {
+		logger.Panicf("BUG: bloom filter bits per token must be in the range [%d..%d]; got %d", MinBloomFilterBitsPerToken, MaxBloomFilterBitsPerToken, n)
+	}
+	bloomFilterBitsPerItem.Store(int64(n))
+}
 
 // bloomFilterMarshal appends marshaled bloom filter for tokens to dst and returns the result.
 func bloomFilterMarshal(dst []byte, tokens []string) []byte {
@@ -63,7 +94,7 @@ func (bf *bloomFilter) unmarshal(src []byte) error {
 
 // mustInit initializes bf with the given tokens
 func (bf *bloomFilter) mustInit(tokens []string) {
-	bitsCount := len(tokens) * bloomFilterBitsPerItem
+	bitsCount := len(tokens) * int(bloomFilterBitsPerItem.Load())
 	wordsCount := (bitsCount + 63) / 64
 	bits := slicesutil.SetLength(bf.bits, wordsCount)
 	bloomFilterAdd(bits, tokens)
@@ -82,3 +82,38 @@ func TestBloomFilterFalsePositive(t *testing.T) {
 		t.Fatalf("too high false positive rate; got %.4f; want %.4f max", p, maxFalsePositive)
 	}
 }
+
+func TestBloomFilterBitsPerToken(t *testing.T) {
+	defer SetBloomFilterBitsPerToken(DefaultBloomFilterBitsPerToken)
+
+	tokens := make([]string, 1000)
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("token_%d", i)
+	}
+
+	f := func(bitsPerToken int) {
+		t.Helper()
+
+		SetBloomFilterBitsPerToken(bitsPerToken)
+		data := bloomFilterMarshal(nil, tokens)
+		sizeExpected := 8 * ((len(tokens)*bitsPerToken + 63) / 64)
+		if len(data) != sizeExpected {
+			t.Fatalf("unexpected bloom filter size for %d bits per token; got %d bytes; want %d bytes", bitsPerToken, len(data), sizeExpected)
+		}
+
+		// The bloom filter must be readable regardless of the current bits per token setting.
+		SetBloomFilterBitsPerToken(DefaultBloomFilterBitsPerToken)
+		bf := getBloomFilter()
+		defer putBloomFilter(bf)
+		if err := bf.unmarshal(data); err != nil {
+			t.Fatalf("unexpected error when unmarshaling bloom filter: %s", err)
+		}
+		if !bf.containsAll(tokens) {
+			t.Fatalf("bloom filter with %d bits per token must contain all the added tokens", bitsPerToken)
+		}
+	}
+
+	f(MinBloomFilterBitsPerToken)
+	f(DefaultBloomFilterBitsPerToken)
+	f(MaxBloomFilterBitsPerToken)
+}
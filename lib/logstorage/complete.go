@@ -0,0 +1,238 @@
+package logstorage
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Completions contains suggestions for the token at the cursor position in the query.
+//
+// See Complete.
+type Completions struct {
+	// Start is the byte offset of the token at the query, which must be replaced with the selected suggestion.
+	Start int
+
+	// End is the byte offset of the end of the token at the query, which must be replaced with the selected suggestion.
+	End int
+
+	// Suggestions contains the suggested tokens.
+	Suggestions []Suggestion
+}
+
+// Suggestion is a suggested token returned from Complete.
+type Suggestion struct {
+	// Value is the suggested token.
+	Value string
+
+	// Kind is the kind of the suggested token - "pipe", "stats_func" or "field".
+	Kind string
+}
+
+// Complete returns suggestions for the token at cursorPos byte offset in the query qStr.
+//
+// fieldNames must contain known field names, which can be suggested in places where field names are expected.
+func Complete(qStr string, cursorPos int, fieldNames []string) *Completions {
+	cursorPos = min(max(cursorPos, 0), len(qStr))
+	s := qStr[:cursorPos]
+
+	tokens := getCompleteTokens(s)
+
+	// Determine the prefix for the token, which is being typed at cursorPos.
+	prefix := ""
+	if len(tokens) > 0 && isCompletePrefixToken(tokens[len(tokens)-1]) && !isCompleteWhitespace(s) {
+		// The last token is being typed if it ends at cursorPos.
+		if token := tokens[len(tokens)-1]; strings.HasSuffix(s, token) {
+			prefix = token
+			tokens = tokens[:len(tokens)-1]
+		}
+	}
+
+	cs := &Completions{
+		Start: cursorPos - len(prefix),
+		End:   cursorPos,
+	}
+
+	switch getCompleteContext(tokens) {
+	case completeContextPipe:
+		// Iterate over the registered parsers, so pipes registered via RegisterPipe are suggested too.
+		for name := range getPipeParsers() {
+			cs.addSuggestion(prefix, name, "pipe")
+		}
+	case completeContextStatsFunc:
+		for name := range getStatsFuncParsers() {
+			cs.addSuggestion(prefix, name, "stats_func")
+		}
+	case completeContextField:
+		for _, name := range fieldNames {
+			cs.addSuggestion(prefix, quoteTokenIfNeeded(name), "field")
+		}
+	}
+
+	slices.SortFunc(cs.Suggestions, func(a, b Suggestion) int {
+		return strings.Compare(a.Value, b.Value)
+	})
+	cs.Suggestions = slices.Compact(cs.Suggestions)
+
+	return cs
+}
+
+func (cs *Completions) addSuggestion(prefix, value, kind string) {
+	if !strings.HasPrefix(strings.ToLower(value), strings.ToLower(prefix)) {
+		return
+	}
+	cs.Suggestions = append(cs.Suggestions, Suggestion{
+		Value: value,
+		Kind:  kind,
+	})
+}
+
+// getCompleteTokens returns raw tokens for s.
+//
+// Adjacent tokens without whitespace between them are merged into a single compound token such as 'stream-id',
+// the same way as the parser does this for field names and phrases.
+func getCompleteTokens(s string) []string {
+	var tokens []string
+	lex := newLexer(s)
+	for !lex.isEnd() {
+		token := lex.rawToken
+		if n := len(tokens); n > 0 && !lex.isSkippedSpace && !isCompleteStopToken(tokens[n-1]) && !isCompleteStopToken(token) {
+			tokens[n-1] += token
+		} else {
+			tokens = append(tokens, token)
+		}
+		lex.nextToken()
+	}
+	return tokens
+}
+
+// isCompleteStopToken returns true if the token cannot be a part of compound token.
+func isCompleteStopToken(token string) bool {
+	return len(token) == 1 && strings.Contains(",()[]|!:=~<>", token)
+}
+
+// isCompletePrefixToken returns true if the token can be completed.
+func isCompletePrefixToken(token string) bool {
+	r, _ := utf8.DecodeRuneInString(token)
+	return isTokenRune(r) || r == '.'
+}
+
+type completeContext int
+
+const (
+	completeContextNone completeContext = iota
+	completeContextPipe
+	completeContextStatsFunc
+	completeContextField
+)
+
+// completeFrame contains the parser state for a single query at the query.
+//
+// Nested queries are located inside 'in(...)' filters and inside 'join' and 'union' pipes.
+type completeFrame struct {
+	// pipeName is the name of the current pipe. It is empty for the query filter.
+	pipeName string
+
+	// expectPipeName is set to true if the next token must be pipe name.
+	expectPipeName bool
+
+	// parenOwners contains tokens in front of the opened parens for the current pipe.
+	parenOwners []string
+
+	// lastClosedParenOwner is the token in front of the last closed paren.
+	lastClosedParenOwner string
+}
+
+// getCompleteContext returns the context for the token following the given tokens.
+func getCompleteContext(tokens []string) completeContext {
+	frames := []*completeFrame{{}}
+	prevToken := ""
+	for _, token := range tokens {
+		frame := frames[len(frames)-1]
+		tokenLower := strings.ToLower(token)
+
+		switch {
+		case frame.expectPipeName:
+			frame.pipeName = tokenLower
+			frame.expectPipeName = false
+		case token == "|" && len(frame.parenOwners) == 0:
+			*frame = completeFrame{
+				expectPipeName: true,
+			}
+		case token == "(":
+			if prevToken == "in" || prevToken == "union" || frame.pipeName == "join" && prevToken == ")" && len(frame.parenOwners) == 0 {
+				// The start of the nested query
+				frames = append(frames, &completeFrame{})
+			} else {
+				frame.parenOwners = append(frame.parenOwners, prevToken)
+			}
+		case token == ")":
+			if len(frame.parenOwners) == 0 {
+				// The end of the nested query
+				if len(frames) > 1 {
+					frames = frames[:len(frames)-1]
+				}
+			} else {
+				frame.lastClosedParenOwner = frame.parenOwners[len(frame.parenOwners)-1]
+				frame.parenOwners = frame.parenOwners[:len(frame.parenOwners)-1]
+			}
+		}
+		prevToken = tokenLower
+	}
+
+	frame := frames[len(frames)-1]
+	if frame.expectPipeName {
+		return completeContextPipe
+	}
+
+	switch frame.pipeName {
+	case "", "filter", "where":
+		if prevToken == ":" || strings.HasSuffix(prevToken, "=") || strings.HasSuffix(prevToken, "~") {
+			// field value is expected
+			return completeContextNone
+		}
+		return completeContextField
+	case "stats":
+		if len(frame.parenOwners) > 0 {
+			// stats function args, 'by (...)' fields or 'if (...)' filter
+			return completeContextField
+		}
+		switch prevToken {
+		case "stats", ",":
+			return completeContextStatsFunc
+		case ")":
+			if frame.lastClosedParenOwner == "by" || frame.lastClosedParenOwner == "stats" {
+				return completeContextStatsFunc
+			}
+		}
+		return completeContextNone
+	default:
+		if len(frame.parenOwners) > 0 {
+			return completeContextField
+		}
+		if prevToken == "from" || prevToken == "by" || prevToken == "," && isFieldsListPipe(frame.pipeName) {
+			return completeContextField
+		}
+		if prevToken == frame.pipeName && isFieldsListPipe(frame.pipeName) {
+			return completeContextField
+		}
+		return completeContextNone
+	}
+}
+
+// isFieldsListPipe returns true if the pipe with the given name accepts a list of field names.
+func isFieldsListPipe(pipeName string) bool {
+	switch pipeName {
+	case "copy", "cp", "delete", "del", "rm", "drop", "field_values", "fields", "keep", "rename", "mv", "unroll":
+		return true
+	default:
+		return false
+	}
+}
+
+// isCompleteWhitespace returns true if s ends with whitespace.
+func isCompleteWhitespace(s string) bool {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return unicode.IsSpace(r)
+}
@@ -0,0 +1,74 @@
+package logstorage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	fieldNames := []string{"_msg", "_time", "host", "host.name", "stream-id", "user"}
+
+	f := func(qStr string, cursorPos, startExpected int, kindExpected string, valuesExpected []string) {
+		t.Helper()
+
+		cs := Complete(qStr, cursorPos, fieldNames)
+		if cs.Start != startExpected {
+			t.Fatalf("unexpected start for %q at %d; got %d; want %d", qStr, cursorPos, cs.Start, startExpected)
+		}
+		if cs.End != cursorPos {
+			t.Fatalf("unexpected end for %q at %d; got %d; want %d", qStr, cursorPos, cs.End, cursorPos)
+		}
+
+		var values []string
+		for _, s := range cs.Suggestions {
+			if s.Kind != kindExpected {
+				t.Fatalf("unexpected kind for suggestion %q at %q; got %q; want %q", s.Value, qStr, s.Kind, kindExpected)
+			}
+			values = append(values, s.Value)
+		}
+		if !reflect.DeepEqual(values, valuesExpected) {
+			t.Fatalf("unexpected suggestions for %q at %d\ngot\n%q\nwant\n%q", qStr, cursorPos, values, valuesExpected)
+		}
+	}
+
+	// field names at the query filter
+	f(``, 0, 0, "field", []string{"_msg", "_time", "host", "host.name", "stream-id", "user"})
+	f(`ho`, 2, 0, "field", []string{"host", "host.name"})
+	f(`stream-i`, 8, 0, "field", []string{"stream-id"})
+	f(`error AND us`, 12, 10, "field", []string{"user"})
+	f(`(error or ho`, 12, 10, "field", []string{"host", "host.name"})
+	f(`host:`, 5, 5, "", nil)
+	f(`host:=`, 6, 6, "", nil)
+
+	// pipe names
+	f(`* | so`, 6, 4, "pipe", []string{"sort"})
+	f(`* | SO`, 6, 4, "pipe", []string{"sort"})
+	f(`* | stats count() | uni`, 23, 20, "pipe", []string{"union", "uniq"})
+	f(`* | ffoo`, 8, 4, "pipe", nil)
+
+	// the cursor in the middle of the query
+	f(`* | so | limit 10`, 6, 4, "pipe", []string{"sort"})
+
+	// stats functions
+	f(`* | stats co`, 12, 10, "stats_func", []string{"count", "count_empty", "count_uniq", "count_uniq_hash"})
+	f(`* | stats by (host) cou`, 23, 20, "stats_func", []string{"count", "count_empty", "count_uniq", "count_uniq_hash"})
+	f(`* | stats count(), cou`, 22, 19, "stats_func", []string{"count", "count_empty", "count_uniq", "count_uniq_hash"})
+	f(`* | stats count() `, 18, 18, "", nil)
+
+	// field names at pipes
+	f(`* | stats by (ho`, 16, 14, "field", []string{"host", "host.name"})
+	f(`* | stats count(us`, 18, 16, "field", []string{"user"})
+	f(`* | fields ho`, 13, 11, "field", []string{"host", "host.name"})
+	f(`* | fields host, us`, 19, 17, "field", []string{"user"})
+	f(`* | sort by (ho`, 15, 13, "field", []string{"host", "host.name"})
+	f(`* | unpack_json from _m`, 23, 21, "field", []string{"_msg"})
+	f(`* | filter us`, 13, 11, "field", []string{"user"})
+	f(`* | limit 1`, 11, 10, "", nil)
+
+	// nested queries
+	f(`user:in(* | fi`, 14, 12, "pipe", []string{"field_names", "field_values", "fields", "filter", "first"})
+	f(`user:in(* | fields user) ho`, 27, 25, "field", []string{"host", "host.name"})
+	f(`* | union (* | so`, 17, 15, "pipe", []string{"sort"})
+	f(`* | join by (user) (ho`, 22, 20, "field", []string{"host", "host.name"})
+	f(`* | join by (user) (*) | so`, 27, 25, "pipe", []string{"sort"})
+}
@@ -17,11 +17,29 @@ import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/memory"
 )
 
-// The maximum size of big part.
+// DefaultMaxPartSize is the default maximum size of big part.
 //
 // This number limits the maximum time required for building big part.
 // This time shouldn't exceed a few days.
-const maxBigPartSize = 1e12
+const DefaultMaxPartSize = 1e12
+
+// maxPartSize is the maximum size of parts created by merges.
+var maxPartSize atomic.Uint64
+
+func init() {
+	maxPartSize.Store(DefaultMaxPartSize)
+}
+
+// SetMaxPartSize sets the maximum compressed size in bytes for parts created by background and forced merges.
+//
+// Smaller values reduce the disk space and the time needed for a single merge at the cost of bigger number of parts per partition.
+// The existing parts bigger than n aren't merged anymore.
+func SetMaxPartSize(n uint64) {
+	if n == 0 {
+		logger.Panicf("BUG: the maximum part size must be positive")
+	}
+	maxPartSize.Store(n)
+}
 
 // The maximum number of inmemory parts in the partition.
 //
@@ -61,6 +79,12 @@ type datadb struct {
 	bigPartMergesTotal  atomic.Uint64
 	bigPartActiveMerges atomic.Int64
 
+	// lateRowsAdded is the number of rows with timestamps older than the current time minus the query results cache timestamp offset,
+	// which were added to the datadb since it has been opened.
+	//
+	// It is used for invalidating the cached stats states for time buckets at partitions, which continue receiving new rows.
+	lateRowsAdded atomic.Uint64
+
 	// pt is the partition the datadb belongs to
 	pt *partition
 
@@ -129,9 +153,15 @@ func (pw *partWrapper) decRef() {
 	}
 
 	deletePath := ""
+	var osfs []objectStorageFile
+	var obs *objectStorage
 	if pw.mp == nil {
 		if pw.mustDrop.Load() {
 			deletePath = pw.p.path
+			if pw.p.isObjectStoragePart() {
+				osfs = pw.p.objectStorageFiles
+				obs = pw.p.pt.s.objectStorage
+			}
 		}
 	} else {
 		putInmemoryPart(pw.mp)
@@ -143,6 +173,9 @@ func (pw *partWrapper) decRef() {
 
 	if deletePath != "" {
 		fs.MustRemoveAll(deletePath)
+		if len(osfs) > 0 {
+			obs.deleteFiles(osfs)
+		}
 	}
 }
 
@@ -213,6 +246,17 @@ var (
 	bigPartsConcurrencyCh      = make(chan struct{}, cgroup.AvailableCPUs())
 )
 
+// SetMergeConcurrency sets the maximum number of concurrent background merges for small parts and for big parts.
+//
+// By default the number of available CPU cores is used. This function must be called before MustOpenStorage.
+func SetMergeConcurrency(n int) {
+	if n <= 0 {
+		logger.Panicf("BUG: the merge concurrency must be positive; got %d", n)
+	}
+	smallPartsConcurrencyCh = make(chan struct{}, n)
+	bigPartsConcurrencyCh = make(chan struct{}, n)
+}
+
 func (ddb *datadb) startSmallPartsMergers() {
 	ddb.partsLock.Lock()
 	for i := 0; i < cap(smallPartsConcurrencyCh); i++ {
@@ -425,13 +469,168 @@ func (ddb *datadb) bigPartsMerger() {
 	}
 }
 
+// startForceMerge starts merging all the file parts at ddb into a single part in background.
+//
+// This is used for applying retention filters to the partitions, which have no other reasons for background merges.
+func (ddb *datadb) startForceMerge() {
+	ddb.startFilePartsMerge(func(_ *partWrapper) bool {
+		return true
+	})
+}
+
+// mustForceMerge merges all the file parts at ddb into the minimum number of parts with sizes up to the maximum part size.
+//
+// In-memory parts are flushed to disk before the merge, so all the logs ingested before the call are merged.
+// The function waits until background merges for file parts are finished. Parts stored at object storage aren't merged.
+// The merge is stopped when stopCh is closed.
+func (ddb *datadb) mustForceMerge(stopCh <-chan struct{}) {
+	ddb.mustFlushInmemoryPartsToFiles(true)
+
+	pws := ddb.getFilePartsForForceMerge(stopCh)
+	if len(pws) == 0 {
+		// Nothing to merge
+		return
+	}
+
+	maxOutBytes := ddb.getMaxBigPartSize()
+	pwsGroups := getPartsGroupsForForceMerge(pws, maxOutBytes)
+	for i, pwsGroup := range pwsGroups {
+		if needStop(stopCh) {
+			for _, pwsRemaining := range pwsGroups[i:] {
+				ddb.releasePartsToMerge(pwsRemaining)
+			}
+			return
+		}
+		if len(pwsGroup) == 1 && (len(pwsGroups) > 1 || getCompressedSize(pwsGroup) > maxOutBytes) {
+			// There is no need in re-writing a single part, which cannot be merged with other parts because of the maximum part size.
+			// The only part in the partition is merged anyway in order to apply retention filters and delete tasks to it.
+			ddb.releasePartsToMerge(pwsGroup)
+			continue
+		}
+		bigPartsConcurrencyCh <- struct{}{}
+		ddb.mustMergeParts(pwsGroup, false)
+		<-bigPartsConcurrencyCh
+	}
+}
+
+// getFilePartsForForceMerge returns all the file parts at ddb except of parts stored at object storage.
+//
+// The returned parts are marked as being in merge. The function waits until background merges for file parts are finished,
+// so the returned parts include the parts created by these merges. nil is returned if stopCh is closed while waiting.
+func (ddb *datadb) getFilePartsForForceMerge(stopCh <-chan struct{}) []*partWrapper {
+	var pws []*partWrapper
+	m := make(map[*partWrapper]struct{})
+	for {
+		hasActiveMerges := false
+		ddb.partsLock.Lock()
+		for _, src := range [][]*partWrapper{ddb.smallParts, ddb.bigParts} {
+			for _, pw := range src {
+				if _, ok := m[pw]; ok || pw.p.isObjectStoragePart() {
+					continue
+				}
+				if pw.isInMerge {
+					hasActiveMerges = true
+					continue
+				}
+				pw.isInMerge = true
+				m[pw] = struct{}{}
+				pws = append(pws, pw)
+			}
+		}
+		ddb.partsLock.Unlock()
+
+		if !hasActiveMerges {
+			return pws
+		}
+
+		select {
+		case <-stopCh:
+			ddb.releasePartsToMerge(pws)
+			return nil
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// getPartsGroupsForForceMerge splits pws into groups of parts with the summary size up to maxOutBytes.
+//
+// Parts are sorted by size, so smaller parts are merged together. Parts bigger than maxOutBytes are put into distinct groups.
+func getPartsGroupsForForceMerge(pws []*partWrapper, maxOutBytes uint64) [][]*partWrapper {
+	sortPartsForOptimalMerge(pws)
+
+	var pwsGroups [][]*partWrapper
+	var pwsGroup []*partWrapper
+	groupSize := uint64(0)
+	for _, pw := range pws {
+		partSize := pw.p.ph.CompressedSizeBytes
+		if len(pwsGroup) > 0 && groupSize+partSize > maxOutBytes {
+			pwsGroups = append(pwsGroups, pwsGroup)
+			pwsGroup = nil
+			groupSize = 0
+		}
+		pwsGroup = append(pwsGroup, pw)
+		groupSize += partSize
+	}
+	if len(pwsGroup) > 0 {
+		pwsGroups = append(pwsGroups, pwsGroup)
+	}
+	return pwsGroups
+}
+
+// startMergeForDeleteTasks starts merging file parts at ddb, which miss delete tasks with ids up to seq, into a single part in background.
+//
+// This is used for physical deletion of log entries matching delete tasks. See Storage.DeleteRows.
+func (ddb *datadb) startMergeForDeleteTasks(seq uint64) {
+	ddb.startFilePartsMerge(func(pw *partWrapper) bool {
+		return pw.p.ph.DeleteTaskSeq < seq
+	})
+}
+
+// startFilePartsMerge starts merging file parts at ddb, which match needMerge, into a single part in background.
+func (ddb *datadb) startFilePartsMerge(needMerge func(pw *partWrapper) bool) {
+	ddb.partsLock.Lock()
+	defer ddb.partsLock.Unlock()
+
+	if needStop(ddb.stopCh) {
+		return
+	}
+
+	var pws []*partWrapper
+	for _, pw := range ddb.smallParts {
+		if !pw.isInMerge && needMerge(pw) {
+			pws = append(pws, pw)
+		}
+	}
+	for _, pw := range ddb.bigParts {
+		if !pw.isInMerge && needMerge(pw) {
+			pws = append(pws, pw)
+		}
+	}
+	if len(pws) == 0 {
+		// Nothing to merge
+		return
+	}
+	for _, pw := range pws {
+		pw.isInMerge = true
+	}
+
+	ddb.wg.Add(1)
+	go func() {
+		bigPartsConcurrencyCh <- struct{}{}
+		ddb.mustMergeParts(pws, false)
+		<-bigPartsConcurrencyCh
+		ddb.wg.Done()
+	}()
+}
+
 // getPartsToMergeLocked returns optimal parts to merge from pws.
 //
 // The summary size of the returned parts must be smaller than maxOutBytes.
 func getPartsToMergeLocked(pws []*partWrapper, maxOutBytes uint64) []*partWrapper {
 	pwsRemaining := make([]*partWrapper, 0, len(pws))
 	for _, pw := range pws {
-		if !pw.isInMerge {
+		// Parts stored at object storage are merged only for applying retention filters and delete tasks.
+		if !pw.isInMerge && !pw.p.isObjectStoragePart() {
 			pwsRemaining = append(pwsRemaining, pw)
 		}
 	}
@@ -513,7 +712,10 @@ func (ddb *datadb) mustMergeParts(pws []*partWrapper, isFinal bool) {
 	mergeIdx := ddb.nextMergeIdx()
 	dstPartPath := ddb.getDstPartPath(dstPartType, mergeIdx)
 
-	if isFinal && len(pws) == 1 && pws[0].mp != nil {
+	// Obtain delete tasks, which must be applied to the source parts during the merge.
+	dts, deleteTaskSeq := ddb.pt.s.getDeleteTasks()
+
+	if isFinal && len(pws) == 1 && pws[0].mp != nil && pws[0].p.ph.DeleteTaskSeq >= deleteTaskSeq {
 		// Fast path: flush a single in-memory part to disk.
 		mp := pws[0].mp
 		mp.MustStoreToDisk(dstPartPath)
@@ -523,7 +725,11 @@ func (ddb *datadb) mustMergeParts(pws []*partWrapper, isFinal bool) {
 	}
 
 	// Prepare blockStreamReaders for source parts.
-	bsrs := mustOpenBlockStreamReaders(pws)
+	bsrs := mustOpenBlockStreamReaders(ddb.pt, pws, dts)
+
+	// The source parts may contain blocks compressed with the ZSTD dictionary for the partition.
+	// These blocks are copied to the destination part as is, so the destination part must use the same dictionary.
+	zd := ddb.pt.getZSTDDict()
 
 	// Prepare BlockStreamWriter for destination part.
 	srcSize := uint64(0)
@@ -538,10 +744,10 @@ func (ddb *datadb) mustMergeParts(pws []*partWrapper, isFinal bool) {
 	var mpNew *inmemoryPart
 	if dstPartType == partInmemory {
 		mpNew = getInmemoryPart()
-		bsw.MustInitForInmemoryPart(mpNew)
+		bsw.MustInitForInmemoryPart(mpNew, zd)
 	} else {
 		nocache := dstPartType == partBig
-		bsw.MustInitForFilePart(dstPartPath, nocache)
+		bsw.MustInitForFilePart(dstPartPath, nocache, zd)
 	}
 
 	// Merge source parts to destination part.
@@ -551,12 +757,16 @@ func (ddb *datadb) mustMergeParts(pws []*partWrapper, isFinal bool) {
 		// The final merge shouldn't be stopped even if ddb.stopCh is closed.
 		stopCh = nil
 	}
-	mustMergeBlockStreams(&ph, bsw, bsrs, stopCh)
+	srm := ddb.pt.newStreamRetentionMatcher()
+	mustMergeBlockStreams(&ph, bsw, bsrs, srm, stopCh)
 	putBlockStreamWriter(bsw)
 	for _, bsr := range bsrs {
 		putBlockStreamReader(bsr)
 	}
 
+	// All the delete tasks up to deleteTaskSeq have been applied to the merged part.
+	ph.DeleteTaskSeq = deleteTaskSeq
+
 	// Persist partHeader for destination part after the merge.
 	if mpNew != nil {
 		mpNew.ph = ph
@@ -664,21 +874,41 @@ func (ddb *datadb) mustAddRows(lr *LogRows) {
 		return
 	}
 
+	// Obtain the latest delete task id before creating the part, so the delete tasks created after that are applied to the part.
+	deleteTaskSeq := ddb.pt.s.deleteTasksLatestSeq.Load()
+
 	inmemoryPartsConcurrencyCh <- struct{}{}
 	mp := getInmemoryPart()
-	mp.mustInitFromRows(lr)
+	mp.mustInitFromRows(lr, ddb.pt.getZSTDDict())
+	mp.ph.DeleteTaskSeq = deleteTaskSeq
 	p := mustOpenInmemoryPart(ddb.pt, mp)
 	<-inmemoryPartsConcurrencyCh
 
 	flushDeadline := time.Now().Add(ddb.flushInterval)
 	pw := newPartWrapper(p, mp, flushDeadline)
 
+	lateRowsCount := getLateRowsCount(lr.timestamps, ddb.pt.s.getMaxCacheableTimestamp())
+
 	ddb.partsLock.Lock()
 	ddb.inmemoryParts = append(ddb.inmemoryParts, pw)
 	ddb.startInmemoryPartsMergerLocked()
+
+	// Update lateRowsAdded after the part becomes visible for search, so the cached states for the time buckets
+	// computed before the update are invalidated.
+	ddb.lateRowsAdded.Add(lateRowsCount)
 	ddb.partsLock.Unlock()
 }
 
+func getLateRowsCount(timestamps []int64, maxCacheableTimestamp int64) uint64 {
+	n := uint64(0)
+	for _, ts := range timestamps {
+		if ts <= maxCacheableTimestamp {
+			n++
+		}
+	}
+	return n
+}
+
 // DatadbStats contains various stats for datadb.
 type DatadbStats struct {
 	// InmemoryMergesTotal is the number of inmemory merges performed in the given datadb.
@@ -743,6 +973,16 @@ type DatadbStats struct {
 
 	// UncompressedBigPartSize is the size of uncompressed big data stored on disk.
 	UncompressedBigPartSize uint64
+
+	// ObjectStorageParts is the number of file-based parts with data stored at object storage.
+	//
+	// These parts are included in SmallParts and BigParts.
+	ObjectStorageParts uint64
+
+	// CompressedObjectStoragePartSize is the size of compressed data stored at object storage.
+	//
+	// It is included in CompressedSmallPartSize and CompressedBigPartSize.
+	CompressedObjectStoragePartSize uint64
 }
 
 func (s *DatadbStats) reset() {
@@ -785,9 +1025,26 @@ func (ddb *datadb) updateStats(s *DatadbStats) {
 	s.UncompressedSmallPartSize += getUncompressedSize(ddb.smallParts)
 	s.UncompressedBigPartSize += getUncompressedSize(ddb.bigParts)
 
+	for _, pws := range [][]*partWrapper{ddb.smallParts, ddb.bigParts} {
+		for _, pw := range pws {
+			if pw.p.isObjectStoragePart() {
+				s.ObjectStorageParts++
+				s.CompressedObjectStoragePartSize += pw.p.ph.CompressedSizeBytes
+			}
+		}
+	}
+
 	ddb.partsLock.Unlock()
 }
 
+// getRowsCount returns the number of rows available for search at ddb.
+func (ddb *datadb) getRowsCount() uint64 {
+	ddb.partsLock.Lock()
+	n := getRowsCount(ddb.inmemoryParts) + getRowsCount(ddb.smallParts) + getRowsCount(ddb.bigParts)
+	ddb.partsLock.Unlock()
+	return n
+}
+
 // debugFlush() makes sure that the recently ingested data is availalbe for search.
 func (ddb *datadb) debugFlush() {
 	// Nothing to do, since all the ingested data is available for search via ddb.inmemoryParts.
@@ -870,20 +1127,49 @@ func removeParts(pws []*partWrapper, partsToRemove map[*partWrapper]struct{}) ([
 	return dst, len(pws) - len(dst)
 }
 
-func mustOpenBlockStreamReaders(pws []*partWrapper) []*blockStreamReader {
+// mustOpenBlockStreamReaders opens blockStreamReaders for pws.
+//
+// The returned readers are set up for deleting log entries matching dts, which weren't applied to pws yet.
+func mustOpenBlockStreamReaders(pt *partition, pws []*partWrapper, dts []*deleteTask) []*blockStreamReader {
 	bsrs := make([]*blockStreamReader, 0, len(pws))
 	for _, pw := range pws {
 		bsr := getBlockStreamReader()
 		if pw.mp != nil {
-			bsr.MustInitFromInmemoryPart(pw.mp)
+			bsr.MustInitFromInmemoryPart(pw.mp, pw.p.zstdDict)
+		} else if pw.p.isObjectStoragePart() {
+			bsr.MustInitFromObjectStoragePart(pw.p)
 		} else {
-			bsr.MustInitFromFilePart(pw.p.path)
+			bsr.MustInitFromFilePart(pw.p.path, pw.p.zstdDict)
+		}
+		if fd := newFilterDeleteTasks(pt, dts, pw.p.ph.DeleteTaskSeq); fd != nil {
+			bsr.p = pw.p
+			bsr.deleteSO = &searchOptions{
+				filter: fd,
+			}
 		}
 		bsrs = append(bsrs, bsr)
 	}
 	return bsrs
 }
 
+// getMinDeleteTaskSeq returns the minimum DeleteTaskSeq across all the parts at ddb.
+//
+// math.MaxUint64 is returned if ddb has no parts.
+func (ddb *datadb) getMinDeleteTaskSeq() uint64 {
+	ddb.partsLock.Lock()
+	defer ddb.partsLock.Unlock()
+
+	minSeq := uint64(math.MaxUint64)
+	for _, pws := range [][]*partWrapper{ddb.inmemoryParts, ddb.smallParts, ddb.bigParts} {
+		for _, pw := range pws {
+			if seq := pw.p.ph.DeleteTaskSeq; seq < minSeq {
+				minSeq = seq
+			}
+		}
+	}
+	return minSeq
+}
+
 func newPartWrapper(p *part, mp *inmemoryPart, flushDeadline time.Time) *partWrapper {
 	pw := &partWrapper{
 		p:  p,
@@ -960,8 +1246,8 @@ func (ddb *datadb) getMaxSmallPartSize() uint64 {
 
 func getMaxOutBytes(path string) uint64 {
 	n := availableDiskSpace(path)
-	if n > maxBigPartSize {
-		n = maxBigPartSize
+	if maxSize := maxPartSize.Load(); n > maxSize {
+		n = maxSize
 	}
 	return n
 }
@@ -2,6 +2,7 @@ package logstorage
 
 import (
 	"math/rand"
+	"reflect"
 	"testing"
 )
 
@@ -89,3 +90,36 @@ func newTestPartWrappersForSizes(sizes []uint64) []*partWrapper {
 	}
 	return pws
 }
+
+func TestGetPartsGroupsForForceMerge(t *testing.T) {
+	f := func(sizes []uint64, maxOutBytes uint64, groupSizesExpected [][]uint64) {
+		t.Helper()
+
+		pws := newTestPartWrappersForSizes(sizes)
+		pwsGroups := getPartsGroupsForForceMerge(pws, maxOutBytes)
+		var groupSizes [][]uint64
+		for _, pwsGroup := range pwsGroups {
+			groupSizes = append(groupSizes, newTestSizesFromPartWrappers(pwsGroup))
+		}
+		if !reflect.DeepEqual(groupSizes, groupSizesExpected) {
+			t.Fatalf("unexpected groups\ngot\n%v\nwant\n%v", groupSizes, groupSizesExpected)
+		}
+	}
+
+	// no parts
+	f(nil, 100, nil)
+
+	// a single part
+	f([]uint64{10}, 100, [][]uint64{{10}})
+
+	// all the parts fit a single group
+	f([]uint64{30, 10, 20}, 100, [][]uint64{{10, 20, 30}})
+	f([]uint64{50, 50}, 100, [][]uint64{{50, 50}})
+
+	// parts are split into multiple groups
+	f([]uint64{30, 10, 40, 20, 50}, 60, [][]uint64{{10, 20, 30}, {40}, {50}})
+	f([]uint64{5, 5, 5, 5, 5}, 10, [][]uint64{{5, 5}, {5, 5}, {5}})
+
+	// parts bigger than maxOutBytes
+	f([]uint64{200, 10, 300}, 100, [][]uint64{{10}, {200}, {300}})
+}
@@ -0,0 +1,364 @@
+package logstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timeutil"
+)
+
+// DeleteTask is a task for deleting log entries matching the given Filter for the given TenantIDs.
+//
+// See https://docs.victoriametrics.com/victorialogs/#deleting-logs
+type DeleteTask struct {
+	// TaskID is the unique id of the task.
+	//
+	// Tasks with bigger ids are created later.
+	TaskID uint64 `json:"task_id,string"`
+
+	// TenantIDs is the list of tenants to delete log entries from.
+	TenantIDs []TenantID `json:"tenant_ids"`
+
+	// Filter is LogsQL filter for log entries to delete.
+	Filter string `json:"filter"`
+
+	// StartTime is the task creation time in Unix nanoseconds.
+	//
+	// Relative time filters such as `_time:1h` in the Filter are applied relative to the StartTime.
+	StartTime int64 `json:"start_time"`
+}
+
+// deleteTask is DeleteTask with the parsed filter.
+type deleteTask struct {
+	task DeleteTask
+
+	// f is the filter for log entries to delete.
+	f filter
+}
+
+// hasTenantID returns true if dt must be applied to the given tenantID.
+func (dt *deleteTask) hasTenantID(tenantID *TenantID) bool {
+	for i := range dt.task.TenantIDs {
+		if dt.task.TenantIDs[i].equal(tenantID) {
+			return true
+		}
+	}
+	return false
+}
+
+func tenantIDsString(tenantIDs []TenantID) string {
+	a := make([]string, len(tenantIDs))
+	for i := range tenantIDs {
+		a[i] = tenantIDs[i].String()
+	}
+	return "[" + strings.Join(a, ",") + "]"
+}
+
+// deleteTasksFileData is the contents of deleteTasksFilename.
+type deleteTasksFileData struct {
+	// Tasks contains active delete tasks.
+	Tasks []DeleteTask `json:"tasks"`
+
+	// LatestTaskID is the id of the latest created delete task.
+	//
+	// It is persisted in order to guarantee that the ids for new tasks are bigger than DeleteTaskSeq at every part.
+	LatestTaskID uint64 `json:"latest_task_id,string"`
+}
+
+// DeleteRows starts deleting log entries matching q for the given tenantIDs and returns the id of the created delete task.
+//
+// The matching log entries become invisible to queries immediately after the call,
+// while they are physically deleted from the storage during background merges.
+//
+// q must contain only filters without pipes.
+func (s *Storage) DeleteRows(tenantIDs []TenantID, q *Query) (uint64, error) {
+	if len(q.pipes) > 0 {
+		return 0, fmt.Errorf("the query for deleting logs mustn't contain pipes; got [%s]", q)
+	}
+	if hasFilterInWithQueryForFilter(q.f) {
+		return 0, fmt.Errorf("the query for deleting logs mustn't contain subqueries; got [%s]", q)
+	}
+	if len(tenantIDs) == 0 {
+		return 0, fmt.Errorf("missing tenants for deleting logs")
+	}
+
+	tenantIDs = append([]TenantID{}, tenantIDs...)
+	slices.SortFunc(tenantIDs, func(a, b TenantID) int {
+		if a.less(&b) {
+			return -1
+		}
+		if b.less(&a) {
+			return 1
+		}
+		return 0
+	})
+	tenantIDs = slices.CompactFunc(tenantIDs, func(a, b TenantID) bool {
+		return a.equal(&b)
+	})
+
+	startTime := time.Now().UnixNano()
+
+	s.deleteTasksLock.Lock()
+	taskID := s.deleteTasksLatestSeq.Load() + 1
+	if taskID < uint64(startTime) {
+		// Use the current time as the task id, so the ids remain increasing even if deleteTasksFilename is lost.
+		taskID = uint64(startTime)
+	}
+	dt := &deleteTask{
+		task: DeleteTask{
+			TaskID:    taskID,
+			TenantIDs: tenantIDs,
+			Filter:    q.String(),
+			StartTime: startTime,
+		},
+		f: q.f,
+	}
+	s.deleteTasks = append(s.deleteTasks, dt)
+	s.deleteTasksLatestSeq.Store(taskID)
+	s.mustSaveDeleteTasksLocked()
+	s.deleteTasksLock.Unlock()
+
+	logger.Infof("started delete task %d for tenants %s with filter [%s]", taskID, tenantIDsString(tenantIDs), dt.task.Filter)
+
+	s.startMergesForDeleteTasks()
+
+	return taskID, nil
+}
+
+// GetDeleteTasks returns active delete tasks at s.
+//
+// The task is active until the matching log entries are physically deleted from the storage.
+func (s *Storage) GetDeleteTasks() []DeleteTask {
+	s.deleteTasksLock.Lock()
+	defer s.deleteTasksLock.Unlock()
+
+	tasks := make([]DeleteTask, len(s.deleteTasks))
+	for i, dt := range s.deleteTasks {
+		tasks[i] = dt.task
+	}
+	return tasks
+}
+
+// getDeleteTasks returns active delete tasks and the id of the latest created delete task.
+//
+// The returned tasks are sorted by task id and they mustn't be modified by the caller.
+func (s *Storage) getDeleteTasks() ([]*deleteTask, uint64) {
+	s.deleteTasksLock.Lock()
+	defer s.deleteTasksLock.Unlock()
+
+	return s.deleteTasks, s.deleteTasksLatestSeq.Load()
+}
+
+func (s *Storage) mustSaveDeleteTasksLocked() {
+	fd := deleteTasksFileData{
+		Tasks:        make([]DeleteTask, len(s.deleteTasks)),
+		LatestTaskID: s.deleteTasksLatestSeq.Load(),
+	}
+	for i, dt := range s.deleteTasks {
+		fd.Tasks[i] = dt.task
+	}
+	data, err := json.Marshal(&fd)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal delete tasks: %s", err)
+	}
+	path := filepath.Join(s.path, deleteTasksFilename)
+	fs.MustWriteAtomic(path, data, true)
+}
+
+// mustLoadDeleteTasks loads delete tasks from the given path.
+//
+// It returns the loaded tasks and the id of the latest created delete task.
+func mustLoadDeleteTasks(path string) ([]*deleteTask, uint64) {
+	if !fs.IsPathExist(path) {
+		return nil, 0
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger.Panicf("FATAL: cannot read %q: %s", path, err)
+	}
+	var fd deleteTasksFileData
+	if err := json.Unmarshal(data, &fd); err != nil {
+		logger.Panicf("FATAL: cannot parse %q: %s", path, err)
+	}
+
+	dts := make([]*deleteTask, 0, len(fd.Tasks))
+	for _, task := range fd.Tasks {
+		q, err := parseQueryAtTimestamp(task.Filter, task.StartTime)
+		if err != nil {
+			logger.Panicf("FATAL: cannot parse filter for the delete task %d at %q: %s", task.TaskID, path, err)
+		}
+		if task.TaskID > fd.LatestTaskID {
+			logger.Panicf("FATAL: the delete task id=%d at %q cannot exceed latest_task_id=%d", task.TaskID, path, fd.LatestTaskID)
+		}
+		dts = append(dts, &deleteTask{
+			task: task,
+			f:    q.f,
+		})
+	}
+	slices.SortFunc(dts, func(a, b *deleteTask) int {
+		if a.task.TaskID < b.task.TaskID {
+			return -1
+		}
+		if a.task.TaskID > b.task.TaskID {
+			return 1
+		}
+		return 0
+	})
+	return dts, fd.LatestTaskID
+}
+
+func (s *Storage) runDeleteTasksWatcher() {
+	s.wg.Add(1)
+	go func() {
+		s.watchDeleteTasks()
+		s.wg.Done()
+	}()
+}
+
+func (s *Storage) watchDeleteTasks() {
+	d := timeutil.AddJitterToDuration(time.Minute)
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		s.startMergesForDeleteTasks()
+		s.removeFinishedDeleteTasks()
+	}
+}
+
+// startMergesForDeleteTasks starts background merges for file parts, which contain log entries matching active delete tasks.
+//
+// In-memory parts are merged into file parts with the applied delete tasks when they are flushed to disk.
+func (s *Storage) startMergesForDeleteTasks() {
+	dts, seq := s.getDeleteTasks()
+	if len(dts) == 0 {
+		return
+	}
+
+	s.partitionsLock.Lock()
+	ptws := append([]*partitionWrapper{}, s.partitions...)
+	for _, ptw := range ptws {
+		ptw.incRef()
+	}
+	s.partitionsLock.Unlock()
+
+	for _, ptw := range ptws {
+		ptw.pt.ddb.startMergeForDeleteTasks(seq)
+		ptw.decRef()
+	}
+}
+
+// removeFinishedDeleteTasks removes delete tasks, which have been applied to all the parts at s.
+func (s *Storage) removeFinishedDeleteTasks() {
+	dts, _ := s.getDeleteTasks()
+	if len(dts) == 0 {
+		return
+	}
+
+	minSeq := uint64(math.MaxUint64)
+	s.partitionsLock.Lock()
+	for _, ptw := range s.partitions {
+		seq := ptw.pt.ddb.getMinDeleteTaskSeq()
+		if seq < minSeq {
+			minSeq = seq
+		}
+	}
+	s.partitionsLock.Unlock()
+
+	s.deleteTasksLock.Lock()
+	n := 0
+	for n < len(s.deleteTasks) && s.deleteTasks[n].task.TaskID <= minSeq {
+		task := &s.deleteTasks[n].task
+		logger.Infof("finished delete task %d for tenants %s with filter [%s]", task.TaskID, tenantIDsString(task.TenantIDs), task.Filter)
+		n++
+	}
+	if n > 0 {
+		// Create new slice instead of modifying the existing one, since it may be in use by getDeleteTasks() callers.
+		s.deleteTasks = append([]*deleteTask{}, s.deleteTasks[n:]...)
+		s.mustSaveDeleteTasksLocked()
+	}
+	s.deleteTasksLock.Unlock()
+}
+
+// filterDeleteTasks matches log entries, which must be deleted by delete tasks.
+//
+// It is used only internally for skipping the deleted log entries during search and merge.
+type filterDeleteTasks struct {
+	tasks []*deleteTask
+}
+
+// newFilterDeleteTasks returns filter for log entries at pt, which must be deleted by dts with ids bigger than seq.
+//
+// nil is returned if there are no such tasks.
+func newFilterDeleteTasks(pt *partition, dts []*deleteTask, seq uint64) filter {
+	var tasks []*deleteTask
+	for _, dt := range dts {
+		if dt.task.TaskID <= seq {
+			continue
+		}
+		f := dt.f
+		if hasStreamFilters(f) {
+			f = initStreamFilters(dt.task.TenantIDs, pt.idb, f)
+		}
+		tasks = append(tasks, &deleteTask{
+			task: dt.task,
+			f:    f,
+		})
+	}
+	if len(tasks) == 0 {
+		return nil
+	}
+	return &filterDeleteTasks{
+		tasks: tasks,
+	}
+}
+
+func (fd *filterDeleteTasks) String() string {
+	return fmt.Sprintf("delete_tasks(%d)", len(fd.tasks))
+}
+
+func (fd *filterDeleteTasks) updateNeededFields(neededFields *fieldsSet) {
+	for _, dt := range fd.tasks {
+		dt.f.updateNeededFields(neededFields)
+	}
+}
+
+func (fd *filterDeleteTasks) applyToBlockResult(_ *blockResult, _ *bitmap) {
+	logger.Panicf("BUG: filterDeleteTasks cannot be applied to blockResult")
+}
+
+func (fd *filterDeleteTasks) applyToBlockSearch(bs *blockSearch, bm *bitmap) {
+	tenantID := &bs.bsw.bh.streamID.tenantID
+
+	bmResult := getBitmap(bm.bitsLen)
+	bmTmp := getBitmap(bm.bitsLen)
+	for _, dt := range fd.tasks {
+		if !dt.hasTenantID(tenantID) {
+			continue
+		}
+		bmTmp.copyFrom(bm)
+		bmTmp.andNot(bmResult)
+		if bmTmp.isZero() {
+			// All the rows are already matched by the previous tasks.
+			break
+		}
+		dt.f.applyToBlockSearch(bs, bmTmp)
+		bmResult.or(bmTmp)
+	}
+	putBitmap(bmTmp)
+	bm.copyFrom(bmResult)
+	putBitmap(bmResult)
+}
@@ -0,0 +1,195 @@
+package logstorage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestStorageDeleteRows(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	sc := &StorageConfig{
+		Retention: 24 * time.Hour,
+	}
+	s := MustOpenStorage(path, sc)
+
+	tenantID1 := TenantID{
+		AccountID: 1,
+	}
+	tenantID2 := TenantID{
+		AccountID: 2,
+	}
+	baseTimestamp := time.Now().UnixNano() - 3600*1e9
+	const rowsPerTenant = 1000
+	const usersCount = 10
+
+	// Add log entries in multiple batches in order to verify deletion across multiple parts
+	for _, tenantID := range []TenantID{tenantID1, tenantID2} {
+		for batch := 0; batch < 2; batch++ {
+			lr := GetLogRows([]string{"host"}, nil)
+			for i := batch * rowsPerTenant / 2; i < (batch+1)*rowsPerTenant/2; i++ {
+				fields := []Field{
+					{
+						Name:  "host",
+						Value: fmt.Sprintf("host-%d", i%3),
+					},
+					{
+						Name:  "user_id",
+						Value: fmt.Sprintf("%d", i%usersCount),
+					},
+					{
+						Name:  "_msg",
+						Value: fmt.Sprintf("message #%d", i),
+					},
+				}
+				lr.MustAdd(tenantID, baseTimestamp+int64(i)*1e6, fields)
+			}
+			s.MustAddRows(lr)
+			PutLogRows(lr)
+		}
+	}
+
+	// Re-open the storage in order to flush in-memory parts to disk.
+	s.MustClose()
+	s = MustOpenStorage(path, sc)
+
+	getRowsCount := func(tenantID TenantID, qStr string) int {
+		t.Helper()
+
+		q := mustParseQuery(qStr + " | count()")
+		var results []string
+		var resultsLock sync.Mutex
+		writeBlock := func(_ uint, _ []int64, columns []BlockColumn) {
+			resultsLock.Lock()
+			results = append(results, columns[0].Values...)
+			resultsLock.Unlock()
+		}
+		if err := s.RunQuery(context.Background(), []TenantID{tenantID}, q, writeBlock); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("unexpected number of results for [%s]; got %d; want 1", qStr, len(results))
+		}
+		var n int
+		if _, err := fmt.Sscanf(results[0], "%d", &n); err != nil {
+			t.Fatalf("cannot parse rows count %q: %s", results[0], err)
+		}
+		return n
+	}
+	getStoredRowsCount := func() uint64 {
+		var ss StorageStats
+		s.UpdateStats(&ss)
+		return ss.RowsCount()
+	}
+	verifyData := func() {
+		t.Helper()
+
+		if n := getRowsCount(tenantID1, "*"); n != rowsPerTenant-rowsPerTenant/usersCount {
+			t.Fatalf("unexpected number of log entries for tenant1; got %d; want %d", n, rowsPerTenant-rowsPerTenant/usersCount)
+		}
+		if n := getRowsCount(tenantID1, "user_id:3"); n != 0 {
+			t.Fatalf("unexpected number of deleted log entries for tenant1; got %d; want 0", n)
+		}
+		if n := getRowsCount(tenantID1, "user_id:4"); n != rowsPerTenant/usersCount {
+			t.Fatalf("unexpected number of log entries for user_id=4 at tenant1; got %d; want %d", n, rowsPerTenant/usersCount)
+		}
+
+		// Log entries for other tenants must remain untouched
+		if n := getRowsCount(tenantID2, "*"); n != rowsPerTenant {
+			t.Fatalf("unexpected number of log entries for tenant2; got %d; want %d", n, rowsPerTenant)
+		}
+		if n := getRowsCount(tenantID2, "user_id:3"); n != rowsPerTenant/usersCount {
+			t.Fatalf("unexpected number of log entries for user_id=3 at tenant2; got %d; want %d", n, rowsPerTenant/usersCount)
+		}
+	}
+
+	if n := getRowsCount(tenantID1, "user_id:3"); n != rowsPerTenant/usersCount {
+		t.Fatalf("unexpected number of log entries for user_id=3 before the deletion; got %d; want %d", n, rowsPerTenant/usersCount)
+	}
+
+	// Invalid delete queries
+	for _, qStr := range []string{"user_id:3 | limit 10", "user_id:in(* | fields user_id)"} {
+		if _, err := s.DeleteRows([]TenantID{tenantID1}, mustParseQuery(qStr)); err == nil {
+			t.Fatalf("expecting non-nil error for [%s]", qStr)
+		}
+	}
+
+	taskID, err := s.DeleteRows([]TenantID{tenantID1}, mustParseQuery("user_id:3"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The deleted log entries must become invisible immediately
+	verifyData()
+
+	// The delete task must survive storage restart
+	s.MustClose()
+	s = MustOpenStorage(path, sc)
+	verifyData()
+
+	tasks := s.GetDeleteTasks()
+	if len(tasks) != 1 {
+		t.Fatalf("unexpected number of delete tasks; got %d; want 1", len(tasks))
+	}
+	if tasks[0].TaskID != taskID {
+		t.Fatalf("unexpected task id; got %d; want %d", tasks[0].TaskID, taskID)
+	}
+	if tasks[0].Filter != "user_id:3" {
+		t.Fatalf("unexpected task filter; got %q; want %q", tasks[0].Filter, "user_id:3")
+	}
+
+	// Do not verify the number of stored log entries before the merge, since background merges for the delete task
+	// are started by DeleteRows() and MustOpenStorage(), so the deleted log entries may be already removed at this point.
+
+	// Wait until the deleted log entries are physically removed
+	s.startMergesForDeleteTasks()
+	storedRowsCountExpected := uint64(2*rowsPerTenant - rowsPerTenant/usersCount)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		s.removeFinishedDeleteTasks()
+		if len(s.GetDeleteTasks()) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout when waiting for the delete task completion; stored log entries: %d", getStoredRowsCount())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := getStoredRowsCount(); n != storedRowsCountExpected {
+		t.Fatalf("unexpected number of stored log entries after the merge; got %d; want %d", n, storedRowsCountExpected)
+	}
+	verifyData()
+
+	// Verify the data after re-opening the storage
+	s.MustClose()
+	s = MustOpenStorage(path, sc)
+	if n := getStoredRowsCount(); n != storedRowsCountExpected {
+		t.Fatalf("unexpected number of stored log entries after re-opening the storage; got %d; want %d", n, storedRowsCountExpected)
+	}
+	verifyData()
+	if tasks := s.GetDeleteTasks(); len(tasks) != 0 {
+		t.Fatalf("unexpected delete tasks after re-opening the storage: %v", tasks)
+	}
+
+	// New delete tasks must have bigger ids
+	taskIDNew, err := s.DeleteRows([]TenantID{tenantID2}, mustParseQuery("user_id:3"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if taskIDNew <= taskID {
+		t.Fatalf("the new task id=%d must be bigger than the previous task id=%d", taskIDNew, taskID)
+	}
+	if n := getRowsCount(tenantID2, "user_id:3"); n != 0 {
+		t.Fatalf("unexpected number of deleted log entries for tenant2; got %d; want 0", n)
+	}
+	s.MustClose()
+
+	fs.MustRemoveAll(path)
+}
@@ -6,12 +6,15 @@ import (
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
 )
 
 // marshalStringsBlock marshals a and appends the result to dst.
 //
+// If zd isn't nil, then it is used for compressing the strings.
+//
 // The marshaled strings block can be unmarshaled with stringsBlockUnmarshaler.
-func marshalStringsBlock(dst []byte, a []string) []byte {
+func marshalStringsBlock(dst []byte, a []string, zd *zstd.Dict) []byte {
 	// Encode string lengths
 	u64s := encoding.GetUint64s(len(a))
 	aLens := u64s.A[:0]
@@ -29,7 +32,7 @@ func marshalStringsBlock(dst []byte, a []string) []byte {
 		b = append(b, s...)
 	}
 	bb.B = b
-	dst = marshalBytesBlock(dst, bb.B)
+	dst = marshalBytesBlockWithDict(dst, bb.B, zd)
 	bbPool.Put(bb)
 
 	return dst
@@ -65,8 +68,10 @@ func (sbu *stringsBlockUnmarshaler) appendFields(dst, src []Field) []Field {
 
 // unmarshal unmarshals itemsCount strings from src, appends them to dst and returns the result.
 //
+// zd must contain the dictionary passed to marshalStringsBlock. It may be nil if src has been marshaled without the dictionary.
+//
 // The returned strings are valid until sbu.reset() call.
-func (sbu *stringsBlockUnmarshaler) unmarshal(dst []string, src []byte, itemsCount uint64) ([]string, error) {
+func (sbu *stringsBlockUnmarshaler) unmarshal(dst []string, src []byte, itemsCount uint64, zd *zstd.Dict) ([]string, error) {
 	u64s := encoding.GetUint64s(0)
 	defer encoding.PutUint64s(u64s)
 
@@ -82,7 +87,7 @@ func (sbu *stringsBlockUnmarshaler) unmarshal(dst []string, src []byte, itemsCou
 
 	// Read bytes block into sbu.data
 	dataLen := len(sbu.data)
-	sbu.data, tail, err = unmarshalBytesBlock(sbu.data, src)
+	sbu.data, tail, err = unmarshalBytesBlockWithDict(sbu.data, src, zd)
 	if err != nil {
 		return dst, fmt.Errorf("cannot unmarshal bytes block with strings: %w", err)
 	}
@@ -229,8 +234,9 @@ func unmarshalUint64Items(dst []uint64, src []byte, itemsCount uint64) ([]uint64
 }
 
 const (
-	marshalBytesTypePlain = 0
-	marshalBytesTypeZSTD  = 1
+	marshalBytesTypePlain    = 0
+	marshalBytesTypeZSTD     = 1
+	marshalBytesTypeZSTDDict = 2
 )
 
 func marshalBytesBlock(dst, src []byte) []byte {
@@ -251,7 +257,38 @@ func marshalBytesBlock(dst, src []byte) []byte {
 	return dst
 }
 
+// marshalBytesBlockWithDict marshals src compressed with zd and appends the result to dst.
+//
+// zd may be nil. In this case src is marshaled with marshalBytesBlock.
+func marshalBytesBlockWithDict(dst, src []byte, zd *zstd.Dict) []byte {
+	if zd == nil || len(src) == 0 {
+		return marshalBytesBlock(dst, src)
+	}
+
+	bb := bbPool.Get()
+	bb.B = zd.Compress(bb.B[:0], src)
+	if len(src) < 128 && len(bb.B) >= len(src) {
+		// The dictionary doesn't help - marshal the block in plain without compression
+		bbPool.Put(bb)
+		return marshalBytesBlock(dst, src)
+	}
+
+	// Compress the block even if it is small, since the dictionary usually improves compression ratio for small blocks.
+	dst = append(dst, marshalBytesTypeZSTDDict)
+	dst = encoding.MarshalVarUint64(dst, uint64(len(bb.B)))
+	dst = append(dst, bb.B...)
+	bbPool.Put(bb)
+	return dst
+}
+
 func unmarshalBytesBlock(dst, src []byte) ([]byte, []byte, error) {
+	return unmarshalBytesBlockWithDict(dst, src, nil)
+}
+
+// unmarshalBytesBlockWithDict unmarshals the block marshaled with marshalBytesBlockWithDict from src and appends it to dst.
+//
+// zd must contain the dictionary passed to marshalBytesBlockWithDict.
+func unmarshalBytesBlockWithDict(dst, src []byte, zd *zstd.Dict) ([]byte, []byte, error) {
 	if len(src) < 1 {
 		return dst, src, fmt.Errorf("cannot unmarshal block type from empty src")
 	}
@@ -275,7 +312,7 @@ func unmarshalBytesBlock(dst, src []byte) ([]byte, []byte, error) {
 		dst = append(dst, src[:blockLen]...)
 		src = src[blockLen:]
 		return dst, src, nil
-	case marshalBytesTypeZSTD:
+	case marshalBytesTypeZSTD, marshalBytesTypeZSTDDict:
 		// Compressed block
 
 		// Read block length
@@ -293,8 +330,17 @@ func unmarshalBytesBlock(dst, src []byte) ([]byte, []byte, error) {
 		// Decompress the block
 		bb := bbPool.Get()
 		var err error
-		bb.B, err = encoding.DecompressZSTD(bb.B[:0], compressedBlock)
+		if blockType == marshalBytesTypeZSTDDict {
+			if zd == nil {
+				bbPool.Put(bb)
+				return dst, src, fmt.Errorf("missing ZSTD dictionary for decompressing the block")
+			}
+			bb.B, err = zd.Decompress(bb.B[:0], compressedBlock)
+		} else {
+			bb.B, err = encoding.DecompressZSTD(bb.B[:0], compressedBlock)
+		}
 		if err != nil {
+			bbPool.Put(bb)
 			return dst, src, fmt.Errorf("cannot decompress block: %w", err)
 		}
 
@@ -303,7 +349,7 @@ func unmarshalBytesBlock(dst, src []byte) ([]byte, []byte, error) {
 		bbPool.Put(bb)
 		return dst, src, nil
 	default:
-		return dst, src, fmt.Errorf("unexpected block type: %d; supported types: 0, 1", blockType)
+		return dst, src, fmt.Errorf("unexpected block type: %d; supported types: 0, 1, 2", blockType)
 	}
 }
 
@@ -6,6 +6,8 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
 )
 
 func isAlmostEqual(a, b int) bool {
@@ -21,12 +23,12 @@ func TestMarshalUnmarshalStringsBlock(t *testing.T) {
 		if logs != "" {
 			a = strings.Split(logs, "\n")
 		}
-		data := marshalStringsBlock(nil, a)
+		data := marshalStringsBlock(nil, a, nil)
 		if !isAlmostEqual(len(data), blockLenExpected) {
 			t.Fatalf("unexpected block length; got %d; want %d; block=%q", len(data), blockLenExpected, data)
 		}
 		sbu := getStringsBlockUnmarshaler()
-		values, err := sbu.unmarshal(nil, data, uint64(len(a)))
+		values, err := sbu.unmarshal(nil, data, uint64(len(a)), nil)
 		if err != nil {
 			t.Fatalf("cannot unmarshal strings block: %s", err)
 		}
@@ -91,3 +93,68 @@ Apr 28 13:48:01 localhost kernel: [36020.499859] CPU2: Package temperature/speed
 	}
 	f(lines, 766)
 }
+
+func TestMarshalUnmarshalStringsBlockWithZSTDDict(t *testing.T) {
+	var content []byte
+	var samples [][]byte
+	for i := 0; i < 100; i++ {
+		sample := fmt.Sprintf("GET /api/v1/users/%d HTTP/1.1", i)
+		samples = append(samples, []byte(sample))
+		if i%10 == 0 {
+			content = append(content, sample...)
+		}
+	}
+	zd, err := zstd.BuildDict(1<<20, content, samples)
+	if err != nil {
+		t.Fatalf("cannot build ZSTD dictionary: %s", err)
+	}
+
+	f := func(a []string, blockTypeExpected byte) {
+		t.Helper()
+
+		data := marshalStringsBlock(nil, a, zd)
+
+		// Skip string lengths block in order to obtain the type of the block with strings
+		sbu := getStringsBlockUnmarshaler()
+		defer putStringsBlockUnmarshaler(sbu)
+		_, tail, err := unmarshalUint64Block(nil, data, uint64(len(a)))
+		if err != nil {
+			t.Fatalf("cannot unmarshal string lengths: %s", err)
+		}
+		if blockType := tail[0]; blockType != blockTypeExpected {
+			t.Fatalf("unexpected block type; got %d; want %d", blockType, blockTypeExpected)
+		}
+
+		values, err := sbu.unmarshal(nil, data, uint64(len(a)), zd)
+		if err != nil {
+			t.Fatalf("cannot unmarshal strings block: %s", err)
+		}
+		if !reflect.DeepEqual(values, a) {
+			t.Fatalf("unexpected strings after unmarshaling;\ngot\n%q\nwant\n%q", values, a)
+		}
+
+		if blockTypeExpected == marshalBytesTypeZSTDDict {
+			// The block cannot be unmarshaled without the dictionary
+			if _, err := sbu.unmarshal(nil, data, uint64(len(a)), nil); err == nil {
+				t.Fatalf("expecting non-nil error when unmarshaling the block without ZSTD dictionary")
+			}
+		}
+	}
+
+	// empty strings are stored in plain
+	f([]string{""}, marshalBytesTypePlain)
+
+	// small strings are compressed with the dictionary
+	f([]string{"GET /api/v1/users/42 HTTP/1.1"}, marshalBytesTypeZSTDDict)
+	f([]string{"GET /api/v1/users/1 HTTP/1.1", "GET /api/v1/users/2 HTTP/1.1"}, marshalBytesTypeZSTDDict)
+
+	// small strings, which do not benefit from the dictionary, are stored in plain
+	f([]string{"x"}, marshalBytesTypePlain)
+
+	// big strings are compressed with the dictionary
+	var a []string
+	for i := 0; i < 1000; i++ {
+		a = append(a, fmt.Sprintf("GET /api/v1/users/%d HTTP/1.1", i))
+	}
+	f(a, marshalBytesTypeZSTDDict)
+}
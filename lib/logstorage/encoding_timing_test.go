@@ -14,14 +14,14 @@ func BenchmarkMarshalStringsBlock(b *testing.B) {
 	b.RunParallel(func(pb *testing.PB) {
 		var buf []byte
 		for pb.Next() {
-			buf = marshalStringsBlock(buf[:0], block)
+			buf = marshalStringsBlock(buf[:0], block, nil)
 		}
 	})
 }
 
 func BenchmarkStringsBlockUnmarshaler_Unmarshal(b *testing.B) {
 	block := strings.Split(benchLogs, "\n")
-	data := marshalStringsBlock(nil, block)
+	data := marshalStringsBlock(nil, block, nil)
 
 	b.SetBytes(int64(len(benchLogs)))
 	b.ReportAllocs()
@@ -30,7 +30,7 @@ func BenchmarkStringsBlockUnmarshaler_Unmarshal(b *testing.B) {
 		var values []string
 		for pb.Next() {
 			var err error
-			values, err = sbu.unmarshal(values[:0], data, uint64(len(block)))
+			values, err = sbu.unmarshal(values[:0], data, uint64(len(block)), nil)
 			if err != nil {
 				panic(fmt.Errorf("unexpected error: %w", err))
 			}
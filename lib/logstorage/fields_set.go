@@ -5,89 +5,349 @@ import (
 	"strings"
 )
 
-type fieldsSet map[string]struct{}
+// fieldsSet is a set of field names.
+//
+// The set may also contain wildcards, which match all the fields with the given prefix.
+// Wildcards must be added via addPatterns(), while all the other methods treat field names literally,
+// so fields with names ending with `*` are supported.
+type fieldsSet struct {
+	// fields contains field names.
+	//
+	// The value is false for fields removed from the set while matching some wildcard.
+	// The special "*" field means that the set contains all the fields.
+	fields map[string]bool
 
-func newFieldsSet() fieldsSet {
-	return fieldsSet(map[string]struct{}{})
+	// wildcards contains wildcard rules.
+	//
+	// A field, which is missing in fields, belongs to the set if the rule with the longest prefix matching the field
+	// has include=true.
+	wildcards []fieldsSetWildcard
 }
 
-func (fs fieldsSet) reset() {
-	clear(fs)
+type fieldsSetWildcard struct {
+	prefix  string
+	include bool
 }
 
-func (fs fieldsSet) String() string {
+func newFieldsSet() *fieldsSet {
+	return &fieldsSet{
+		fields: make(map[string]bool),
+	}
+}
+
+func (fs *fieldsSet) reset() {
+	clear(fs.fields)
+	fs.wildcards = fs.wildcards[:0]
+}
+
+func (fs *fieldsSet) String() string {
 	a := fs.getAll()
+	for f, ok := range fs.fields {
+		if !ok {
+			a = append(a, "-"+f)
+		}
+	}
+	for _, w := range fs.wildcards {
+		if !w.include {
+			a = append(a, "-"+w.prefix+"*")
+		}
+	}
+	sort.Strings(a)
 	return "[" + strings.Join(a, ",") + "]"
 }
 
-func (fs fieldsSet) clone() fieldsSet {
+func (fs *fieldsSet) clone() *fieldsSet {
 	fsNew := newFieldsSet()
-	for _, f := range fs.getAll() {
-		fsNew.add(f)
+	for f, ok := range fs.fields {
+		fsNew.fields[f] = ok
 	}
+	fsNew.wildcards = append(fsNew.wildcards, fs.wildcards...)
 	return fsNew
 }
 
-func (fs fieldsSet) isEmpty() bool {
-	return len(fs) == 0
+func (fs *fieldsSet) isEmpty() bool {
+	for _, ok := range fs.fields {
+		if ok {
+			return false
+		}
+	}
+	for _, w := range fs.wildcards {
+		if w.include {
+			return false
+		}
+	}
+	return true
+}
+
+// getAll returns all the fields and wildcards in the form `prefix*` from fs.
+//
+// Fields removed from fs while matching some wildcard are ignored, so the returned list may match more fields than fs contains.
+// Use getAllStrict if the returned list mustn't match fields outside fs.
+func (fs *fieldsSet) getAll() []string {
+	return fs.getAllInternal(false)
+}
+
+// getAllStrict returns all the fields and wildcards in the form `prefix*` from fs.
+//
+// Wildcards with some fields removed from them are skipped, so the returned list matches only fields from fs.
+func (fs *fieldsSet) getAllStrict() []string {
+	return fs.getAllInternal(true)
 }
 
-func (fs fieldsSet) getAll() []string {
-	a := make([]string, 0, len(fs))
-	for f := range fs {
+func (fs *fieldsSet) getAllInternal(strict bool) []string {
+	a := make([]string, 0, len(fs.fields)+len(fs.wildcards))
+	for f, ok := range fs.fields {
+		if !ok {
+			continue
+		}
+		if strict && f != "*" && isWildcardFieldName(f) {
+			// The field name ending with `*` would be treated as a wildcard by the caller.
+			continue
+		}
 		a = append(a, f)
 	}
+	for _, w := range fs.wildcards {
+		if !w.include {
+			continue
+		}
+		if strict && fs.hasExclusionsUnder(w.prefix) {
+			continue
+		}
+		a = append(a, w.prefix+"*")
+	}
 	sort.Strings(a)
 	return a
 }
 
-func (fs fieldsSet) addFields(fields []string) {
+// hasExclusionsUnder returns true if fs contains removed fields or wildcards starting with the given prefix.
+func (fs *fieldsSet) hasExclusionsUnder(prefix string) bool {
+	for f, ok := range fs.fields {
+		if !ok && strings.HasPrefix(f, prefix) {
+			return true
+		}
+	}
+	for _, w := range fs.wildcards {
+		if !w.include && strings.HasPrefix(w.prefix, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// getFieldsWithoutPrefix returns the set of fields from fs starting with the given prefix, with the prefix removed.
+func (fs *fieldsSet) getFieldsWithoutPrefix(prefix string) *fieldsSet {
+	result := newFieldsSet()
+	if fs.fields["*"] {
+		result.fields["*"] = true
+		return result
+	}
+
+	if fs.matchWildcards(prefix) {
+		// All the fields starting with the prefix are included unless the rules below exclude them.
+		result.wildcards = append(result.wildcards, fieldsSetWildcard{
+			include: true,
+		})
+	}
+	for f, ok := range fs.fields {
+		if !strings.HasPrefix(f, prefix) {
+			continue
+		}
+		f = f[len(prefix):]
+		if f == "" {
+			f = "_msg"
+		}
+		result.fields[f] = ok
+	}
+	for _, w := range fs.wildcards {
+		if len(w.prefix) > len(prefix) && strings.HasPrefix(w.prefix, prefix) {
+			result.wildcards = append(result.wildcards, fieldsSetWildcard{
+				prefix:  w.prefix[len(prefix):],
+				include: w.include,
+			})
+		}
+	}
+	return result
+}
+
+func (fs *fieldsSet) addFields(fields []string) {
 	for _, f := range fields {
 		fs.add(f)
 	}
 }
 
-func (fs fieldsSet) removeFields(fields []string) {
+func (fs *fieldsSet) removeFields(fields []string) {
 	for _, f := range fields {
 		fs.remove(f)
 	}
 }
 
-func (fs fieldsSet) contains(field string) bool {
+// addPatterns adds the given patterns to fs.
+//
+// Patterns may contain wildcards in the form `prefix*`.
+func (fs *fieldsSet) addPatterns(patterns []string) {
+	for _, p := range patterns {
+		if isWildcardFieldName(p) {
+			fs.addWildcard(p[:len(p)-1])
+		} else {
+			fs.add(p)
+		}
+	}
+}
+
+// removePatterns removes the given patterns from fs.
+//
+// Patterns may contain wildcards in the form `prefix*`.
+func (fs *fieldsSet) removePatterns(patterns []string) {
+	for _, p := range patterns {
+		if isWildcardFieldName(p) {
+			fs.removeWildcard(p[:len(p)-1])
+		} else {
+			fs.remove(p)
+		}
+	}
+}
+
+func (fs *fieldsSet) contains(field string) bool {
 	if field == "" {
 		field = "_msg"
 	}
-	_, ok := fs[field]
-	if !ok {
-		_, ok = fs["*"]
+	if ok, found := fs.fields[field]; found {
+		return ok
+	}
+	if fs.fields["*"] {
+		return true
+	}
+	if len(fs.wildcards) == 0 {
+		// Fast path - there are no wildcards.
+		return false
+	}
+	return fs.matchWildcards(field)
+}
+
+// matchWildcards returns true if the wildcard rule with the longest prefix matching the field includes the field.
+func (fs *fieldsSet) matchWildcards(field string) bool {
+	prefixLen := -1
+	include := false
+	for _, w := range fs.wildcards {
+		if len(w.prefix) > prefixLen && strings.HasPrefix(field, w.prefix) {
+			prefixLen = len(w.prefix)
+			include = w.include
+		}
 	}
-	return ok
+	return include
 }
 
-func (fs fieldsSet) remove(field string) {
+func (fs *fieldsSet) remove(field string) {
 	if field == "*" {
 		fs.reset()
 		return
 	}
-	if !fs.contains("*") {
-		if field == "" {
-			field = "_msg"
-		}
-		delete(fs, field)
+	if fs.fields["*"] {
+		return
+	}
+	if field == "" {
+		field = "_msg"
+	}
+	if fs.matchWildcards(field) {
+		// Keep the wildcard and exclude only the given field from it.
+		fs.fields[field] = false
+	} else {
+		delete(fs.fields, field)
 	}
 }
 
-func (fs fieldsSet) add(field string) {
-	if fs.contains("*") {
+func (fs *fieldsSet) add(field string) {
+	if fs.fields["*"] {
 		return
 	}
 	if field == "*" {
 		fs.reset()
-		fs["*"] = struct{}{}
+		fs.fields["*"] = true
 		return
 	}
 	if field == "" {
 		field = "_msg"
 	}
-	fs[field] = struct{}{}
+	if fs.matchWildcards(field) {
+		// The field is already covered by a wildcard.
+		delete(fs.fields, field)
+	} else {
+		fs.fields[field] = true
+	}
+}
+
+// addWildcard adds all the fields starting with the given prefix to fs.
+func (fs *fieldsSet) addWildcard(prefix string) {
+	if prefix == "" {
+		fs.add("*")
+		return
+	}
+	if fs.fields["*"] {
+		return
+	}
+	fs.setWildcard(prefix, true)
+}
+
+// removeWildcard removes all the fields starting with the given prefix from fs.
+func (fs *fieldsSet) removeWildcard(prefix string) {
+	if prefix == "" {
+		fs.reset()
+		return
+	}
+	if fs.fields["*"] {
+		return
+	}
+	fs.setWildcard(prefix, false)
+}
+
+func (fs *fieldsSet) setWildcard(prefix string, include bool) {
+	// Drop the rules for fields and wildcards starting with the prefix, since the new rule overrides them.
+	for f := range fs.fields {
+		if strings.HasPrefix(f, prefix) {
+			delete(fs.fields, f)
+		}
+	}
+	wildcards := fs.wildcards[:0]
+	for _, w := range fs.wildcards {
+		if !strings.HasPrefix(w.prefix, prefix) {
+			wildcards = append(wildcards, w)
+		}
+	}
+	fs.wildcards = wildcards
+
+	if fs.matchWildcards(prefix) == include {
+		// The remaining rules already cover the prefix.
+		return
+	}
+	fs.wildcards = append(fs.wildcards, fieldsSetWildcard{
+		prefix:  prefix,
+		include: include,
+	})
+}
+
+// isWildcardFieldName returns true if the field is a wildcard in the form `prefix*`.
+func isWildcardFieldName(field string) bool {
+	return strings.HasSuffix(field, "*")
+}
+
+// matchFieldName returns true if the field matches the given pattern.
+//
+// The pattern may end with `*`. In this case it matches all the fields starting with the pattern prefix.
+func matchFieldName(pattern, field string) bool {
+	if !isWildcardFieldName(pattern) {
+		return pattern == field
+	}
+	return strings.HasPrefix(field, pattern[:len(pattern)-1])
+}
+
+// matchAnyFieldName returns true if the field matches at least a single pattern from patterns.
+//
+// See matchFieldName for details.
+func matchAnyFieldName(patterns []string, field string) bool {
+	for _, pattern := range patterns {
+		if matchFieldName(pattern, field) {
+			return true
+		}
+	}
+	return false
 }
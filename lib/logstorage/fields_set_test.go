@@ -106,3 +106,123 @@ func TestFieldsSet(t *testing.T) {
 		t.Fatalf("fs must contain foo")
 	}
 }
+
+func TestFieldsSetWildcard(t *testing.T) {
+	fs := newFieldsSet()
+
+	fs.addFields([]string{"foo", "foo.bar", "baz"})
+	fs.addPatterns([]string{"foo.*"})
+	if s := fs.String(); s != "[baz,foo,foo.*]" {
+		t.Fatalf("unexpected String() result; got %s; want %s", s, "[baz,foo,foo.*]")
+	}
+	if !fs.contains("foo.bar") || !fs.contains("foo.baz") || !fs.contains("foo.") {
+		t.Fatalf("fs must contain fields matching foo.*")
+	}
+	if fs.contains("fo*") || fs.contains("foobar") || fs.contains("abc") || fs.contains("*") {
+		t.Fatalf("fs mustn't contain fields outside foo.*")
+	}
+
+	// adding a wider wildcard must remove the covered fields
+	fs.addPatterns([]string{"f*"})
+	if s := fs.String(); s != "[baz,f*]" {
+		t.Fatalf("unexpected String() result; got %s; want %s", s, "[baz,f*]")
+	}
+
+	// removing the field matching the wildcard must remove only this field
+	fs.remove("foo.bar")
+	if fs.contains("foo.bar") {
+		t.Fatalf("fs mustn't contain foo.bar")
+	}
+	if !fs.contains("foo") || !fs.contains("foo.baz") {
+		t.Fatalf("fs must contain foo and foo.baz")
+	}
+	if s := fs.String(); s != "[-foo.bar,baz,f*]" {
+		t.Fatalf("unexpected String() result; got %s; want %s", s, "[-foo.bar,baz,f*]")
+	}
+	if a := fs.getAll(); !reflect.DeepEqual(a, []string{"baz", "f*"}) {
+		t.Fatalf("unexpected result from getAll(); got %q; want %q", a, []string{"baz", "f*"})
+	}
+	if a := fs.getAllStrict(); !reflect.DeepEqual(a, []string{"baz"}) {
+		t.Fatalf("unexpected result from getAllStrict(); got %q; want %q", a, []string{"baz"})
+	}
+
+	// removing the wildcard must remove all the matching fields
+	fs.addPatterns([]string{"a.b", "a.c", "a.d*", "ab"})
+	fs.removePatterns([]string{"a.*"})
+	if s := fs.String(); s != "[-foo.bar,ab,baz,f*]" {
+		t.Fatalf("unexpected String() result; got %s; want %s", s, "[-foo.bar,ab,baz,f*]")
+	}
+	if fs.contains("a.b") || fs.contains("a.dx") {
+		t.Fatalf("fs mustn't contain fields matching a.*")
+	}
+}
+
+func TestFieldsSetLiteralStar(t *testing.T) {
+	fs := newFieldsSet()
+
+	// field names ending with `*` must be treated literally by add and remove
+	fs.add("foo*")
+	if !fs.contains("foo*") {
+		t.Fatalf("fs must contain foo*")
+	}
+	if fs.contains("foo") || fs.contains("foobar") {
+		t.Fatalf("fs mustn't contain foo and foobar")
+	}
+	if a := fs.getAll(); !reflect.DeepEqual(a, []string{"foo*"}) {
+		t.Fatalf("unexpected result from getAll(); got %q; want %q", a, []string{"foo*"})
+	}
+	if a := fs.getAllStrict(); len(a) != 0 {
+		t.Fatalf("unexpected result from getAllStrict(); got %q; want empty result", a)
+	}
+
+	fs.addPatterns([]string{"bar*"})
+	fs.remove("bar*")
+	if fs.contains("bar*") {
+		t.Fatalf("fs mustn't contain bar*")
+	}
+	if !fs.contains("barx") {
+		t.Fatalf("fs must contain barx")
+	}
+	fs.remove("foo*")
+	if fs.contains("foo*") {
+		t.Fatalf("fs mustn't contain foo*")
+	}
+}
+
+func TestFieldsSetGetFieldsWithoutPrefix(t *testing.T) {
+	f := func(patterns, removed []string, prefix, resultExpected string) {
+		t.Helper()
+
+		fs := newFieldsSet()
+		fs.addPatterns(patterns)
+		fs.removeFields(removed)
+		result := fs.getFieldsWithoutPrefix(prefix).String()
+		if result != resultExpected {
+			t.Fatalf("unexpected result for getFieldsWithoutPrefix(%q); got %s; want %s", prefix, result, resultExpected)
+		}
+	}
+
+	f(nil, nil, "foo.", "[]")
+	f([]string{"*"}, nil, "foo.", "[*]")
+	f([]string{"foo.a", "foo.b*", "bar"}, []string{"foo.b.c"}, "foo.", "[-b.c,a,b*]")
+	f([]string{"foo.", "foo.a"}, nil, "foo.", "[_msg,a]")
+	f([]string{"f*"}, []string{"foo.a"}, "foo.", "[*,-a]")
+}
+
+func TestMatchAnyFieldName(t *testing.T) {
+	f := func(patterns []string, field string, resultExpected bool) {
+		t.Helper()
+		result := matchAnyFieldName(patterns, field)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for matchAnyFieldName(%q, %q); got %v; want %v", patterns, field, result, resultExpected)
+		}
+	}
+
+	f(nil, "foo", false)
+	f([]string{"foo"}, "foo", true)
+	f([]string{"foo"}, "foobar", false)
+	f([]string{"bar", "foo*"}, "foobar", true)
+	f([]string{"bar", "foo*"}, "foo", true)
+	f([]string{"bar", "foo*"}, "fo", false)
+	f([]string{"*"}, "foo", true)
+}
@@ -9,14 +9,29 @@ const (
 	fieldBloomFilename    = "field_bloom.bin"
 	messageValuesFilename = "message_values.bin"
 	messageBloomFilename  = "message_bloom.bin"
+	fieldsIndexFilename   = "fields_index.bin"
 
 	metadataFilename = "metadata.json"
 	partsFilename    = "parts.json"
 
-	streamIDCacheFilename = "stream_id.bin"
+	zstdDictFilename = "zstd_dict.bin"
+
+	streamIDCacheFilename     = "stream_id.bin"
+	queryResultsCacheFilename = "query_results.bin"
+
+	deleteTasksFilename = "delete_tasks.json"
+
+	checksumsFilename       = "checksums.json"
+	verifyChecksumsFilename = "verify_checksums"
+
+	objectStorageFilesFilename = "object_storage_files.json"
 
 	indexdbDirname    = "indexdb"
 	datadbDirname     = "datadb"
 	cacheDirname      = "cache"
 	partitionsDirname = "partitions"
+	snapshotsDirname  = "snapshots"
+	detachedDirname   = "detached"
+
+	objectStorageCacheDirname = "object_storage"
 )
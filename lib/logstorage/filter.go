@@ -6,7 +6,7 @@ type filter interface {
 	String() string
 
 	// udpdateNeededFields must update neededFields with fields needed for the filter
-	updateNeededFields(neededFields fieldsSet)
+	updateNeededFields(neededFields *fieldsSet)
 
 	// applyToBlockSearch must update bm according to the filter applied to the given bs block
 	applyToBlockSearch(bs *blockSearch, bm *bitmap)
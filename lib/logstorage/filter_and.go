@@ -35,7 +35,7 @@ func (fa *filterAnd) String() string {
 	return strings.Join(a, " ")
 }
 
-func (fa *filterAnd) updateNeededFields(neededFields fieldsSet) {
+func (fa *filterAnd) updateNeededFields(neededFields *fieldsSet) {
 	for _, f := range fa.filters {
 		f.updateNeededFields(neededFields)
 	}
@@ -90,6 +90,10 @@ func (fa *filterAnd) matchBloomFilters(bs *blockSearch) bool {
 
 		ch := bs.csh.getColumnHeader(fieldName)
 		if ch == nil {
+			if bs.csh.hasJSONPathSource(fieldName) {
+				// The field may be stored inside JSON objects, which cannot be checked via bloom filter.
+				continue
+			}
 			return false
 		}
 
@@ -134,6 +138,10 @@ func (fa *filterAnd) initByFieldTokens() {
 	}
 
 	for _, f := range fa.filters {
+		if fj, ok := f.(*filterJSONPath); ok {
+			// Use tokens from the wrapped filter. They are verified against bloom filter only if the block contains the given field.
+			f = fj.f
+		}
 		switch t := f.(type) {
 		case *filterExact:
 			tokens := t.getTokens()
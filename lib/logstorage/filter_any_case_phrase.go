@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"unicode"
 	"unicode/utf8"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
@@ -29,13 +30,16 @@ type filterAnyCasePhrase struct {
 
 	tokensUppercaseOnce sync.Once
 	tokensUppercase     []string
+
+	caseInvariantTokensOnce sync.Once
+	caseInvariantTokens     []string
 }
 
 func (fp *filterAnyCasePhrase) String() string {
 	return fmt.Sprintf("%si(%s)", quoteFieldNameIfNeeded(fp.fieldName), quoteTokenIfNeeded(fp.phrase))
 }
 
-func (fp *filterAnyCasePhrase) updateNeededFields(neededFields fieldsSet) {
+func (fp *filterAnyCasePhrase) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add(fp.fieldName)
 }
 
@@ -48,6 +52,15 @@ func (fp *filterAnyCasePhrase) initTokens() {
 	fp.tokens = tokenizeStrings(nil, []string{fp.phrase})
 }
 
+func (fp *filterAnyCasePhrase) getCaseInvariantTokens() []string {
+	fp.caseInvariantTokensOnce.Do(fp.initCaseInvariantTokens)
+	return fp.caseInvariantTokens
+}
+
+func (fp *filterAnyCasePhrase) initCaseInvariantTokens() {
+	fp.caseInvariantTokens = getCaseInvariantTokens(fp.getTokens())
+}
+
 func (fp *filterAnyCasePhrase) getTokensUppercase() []string {
 	fp.tokensUppercaseOnce.Do(fp.initTokensUppercase)
 	return fp.tokensUppercase
@@ -113,7 +126,8 @@ func (fp *filterAnyCasePhrase) applyToBlockSearch(bs *blockSearch, bm *bitmap) {
 
 	switch ch.valueType {
 	case valueTypeString:
-		matchStringByAnyCasePhrase(bs, ch, bm, phraseLowercase)
+		caseInvariantTokens := fp.getCaseInvariantTokens()
+		matchStringByAnyCasePhrase(bs, ch, bm, phraseLowercase, caseInvariantTokens)
 	case valueTypeDict:
 		matchValuesDictByAnyCasePhrase(bs, ch, bm, phraseLowercase)
 	case valueTypeUint8:
@@ -150,7 +164,11 @@ func matchValuesDictByAnyCasePhrase(bs *blockSearch, ch *columnHeader, bm *bitma
 	bbPool.Put(bb)
 }
 
-func matchStringByAnyCasePhrase(bs *blockSearch, ch *columnHeader, bm *bitmap, phraseLowercase string) {
+func matchStringByAnyCasePhrase(bs *blockSearch, ch *columnHeader, bm *bitmap, phraseLowercase string, caseInvariantTokens []string) {
+	if !matchBloomFilterAllTokens(bs, ch, caseInvariantTokens) {
+		bm.resetBits()
+		return
+	}
 	visitValues(bs, ch, bm, func(v string) bool {
 		return matchAnyCasePhrase(v, phraseLowercase)
 	})
@@ -180,6 +198,29 @@ func matchAnyCasePhrase(s, phraseLowercase string) bool {
 	return ok
 }
 
+// getCaseInvariantTokens returns tokens, which consist only of chars without upper and lower case variants - digits, '_', etc.
+//
+// Such tokens are stored in bloom filters in the same form for any case of the original value,
+// so they can be used for skipping blocks by case-insensitive filters.
+func getCaseInvariantTokens(tokens []string) []string {
+	var result []string
+	for _, token := range tokens {
+		if isCaseInvariantToken(token) {
+			result = append(result, token)
+		}
+	}
+	return result
+}
+
+func isCaseInvariantToken(s string) bool {
+	for _, r := range s {
+		if unicode.SimpleFold(r) != r {
+			return false
+		}
+	}
+	return true
+}
+
 func isASCIILowercase(s string) bool {
 	for i := 0; i < len(s); i++ {
 		c := s[i]
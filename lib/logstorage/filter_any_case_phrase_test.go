@@ -1,6 +1,7 @@
 package logstorage
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -40,6 +41,24 @@ func TestMatchAnyCasePhrase(t *testing.T) {
 	f("Тест", "ест", false)
 }
 
+func TestGetCaseInvariantTokens(t *testing.T) {
+	f := func(tokens, resultExpected []string) {
+		t.Helper()
+
+		result := getCaseInvariantTokens(tokens)
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result for %q; got %q; want %q", tokens, result, resultExpected)
+		}
+	}
+
+	f(nil, nil)
+	f([]string{"foo", "Bar", "ТЕСТ"}, nil)
+	f([]string{"foo", "123", "a1", "_", "1_2", "中文"}, []string{"123", "_", "1_2", "中文"})
+
+	// The Kelvin sign is folded to 'k'
+	f([]string{"\u212a"}, nil)
+}
+
 func TestFilterAnyCasePhrase(t *testing.T) {
 	t.Parallel()
 
@@ -332,6 +351,12 @@ func TestFilterAnyCasePhrase(t *testing.T) {
 		}
 		testFilterMatchForColumns(t, columns, pf, "foo", []int{9})
 
+		pf = &filterAnyCasePhrase{
+			fieldName: "foo",
+			phrase:    "A !!,23",
+		}
+		testFilterMatchForColumns(t, columns, pf, "foo", []int{9})
+
 		// mismatch
 		pf = &filterAnyCasePhrase{
 			fieldName: "foo",
@@ -339,6 +364,12 @@ func TestFilterAnyCasePhrase(t *testing.T) {
 		}
 		testFilterMatchForColumns(t, columns, pf, "foo", nil)
 
+		pf = &filterAnyCasePhrase{
+			fieldName: "foo",
+			phrase:    "A !!,24",
+		}
+		testFilterMatchForColumns(t, columns, pf, "foo", nil)
+
 		pf = &filterAnyCasePhrase{
 			fieldName: "foo",
 			phrase:    "",
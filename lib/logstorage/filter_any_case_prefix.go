@@ -30,6 +30,9 @@ type filterAnyCasePrefix struct {
 
 	tokensUppercaseOnce sync.Once
 	tokensUppercase     []string
+
+	caseInvariantTokensOnce sync.Once
+	caseInvariantTokens     []string
 }
 
 func (fp *filterAnyCasePrefix) String() string {
@@ -39,7 +42,7 @@ func (fp *filterAnyCasePrefix) String() string {
 	return fmt.Sprintf("%si(%s*)", quoteFieldNameIfNeeded(fp.fieldName), quoteTokenIfNeeded(fp.prefix))
 }
 
-func (fp *filterAnyCasePrefix) updateNeededFields(neededFields fieldsSet) {
+func (fp *filterAnyCasePrefix) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add(fp.fieldName)
 }
 
@@ -52,6 +55,15 @@ func (fp *filterAnyCasePrefix) initTokens() {
 	fp.tokens = getTokensSkipLast(fp.prefix)
 }
 
+func (fp *filterAnyCasePrefix) getCaseInvariantTokens() []string {
+	fp.caseInvariantTokensOnce.Do(fp.initCaseInvariantTokens)
+	return fp.caseInvariantTokens
+}
+
+func (fp *filterAnyCasePrefix) initCaseInvariantTokens() {
+	fp.caseInvariantTokens = getCaseInvariantTokens(fp.getTokens())
+}
+
 func (fp *filterAnyCasePrefix) getTokensUppercase() []string {
 	fp.tokensUppercaseOnce.Do(fp.initTokensUppercase)
 	return fp.tokensUppercase
@@ -114,7 +126,8 @@ func (fp *filterAnyCasePrefix) applyToBlockSearch(bs *blockSearch, bm *bitmap) {
 
 	switch ch.valueType {
 	case valueTypeString:
-		matchStringByAnyCasePrefix(bs, ch, bm, prefixLowercase)
+		caseInvariantTokens := fp.getCaseInvariantTokens()
+		matchStringByAnyCasePrefix(bs, ch, bm, prefixLowercase, caseInvariantTokens)
 	case valueTypeDict:
 		matchValuesDictByAnyCasePrefix(bs, ch, bm, prefixLowercase)
 	case valueTypeUint8:
@@ -151,7 +164,11 @@ func matchValuesDictByAnyCasePrefix(bs *blockSearch, ch *columnHeader, bm *bitma
 	bbPool.Put(bb)
 }
 
-func matchStringByAnyCasePrefix(bs *blockSearch, ch *columnHeader, bm *bitmap, prefixLowercase string) {
+func matchStringByAnyCasePrefix(bs *blockSearch, ch *columnHeader, bm *bitmap, prefixLowercase string, caseInvariantTokens []string) {
+	if !matchBloomFilterAllTokens(bs, ch, caseInvariantTokens) {
+		bm.resetBits()
+		return
+	}
 	visitValues(bs, ch, bm, func(v string) bool {
 		return matchAnyCasePrefix(v, prefixLowercase)
 	})
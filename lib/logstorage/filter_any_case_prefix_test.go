@@ -362,6 +362,12 @@ func TestFilterAnyCasePrefix(t *testing.T) {
 		}
 		testFilterMatchForColumns(t, columns, fp, "foo", []int{9})
 
+		fp = &filterAnyCasePrefix{
+			fieldName: "foo",
+			prefix:    "A !!,23.(",
+		}
+		testFilterMatchForColumns(t, columns, fp, "foo", []int{9})
+
 		// mismatch
 		fp = &filterAnyCasePrefix{
 			fieldName: "foo",
@@ -369,6 +375,12 @@ func TestFilterAnyCasePrefix(t *testing.T) {
 		}
 		testFilterMatchForColumns(t, columns, fp, "foo", nil)
 
+		fp = &filterAnyCasePrefix{
+			fieldName: "foo",
+			prefix:    "A !!,24.(",
+		}
+		testFilterMatchForColumns(t, columns, fp, "foo", nil)
+
 		fp = &filterAnyCasePrefix{
 			fieldName: "foo",
 			prefix:    "qwe rty abc",
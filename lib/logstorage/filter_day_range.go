@@ -25,7 +25,7 @@ func (fr *filterDayRange) String() string {
 	return "_time:day_range" + fr.stringRepr
 }
 
-func (fr *filterDayRange) updateNeededFields(neededFields fieldsSet) {
+func (fr *filterDayRange) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add("_time")
 }
 
@@ -24,7 +24,7 @@ func (fe *filterExact) String() string {
 	return fmt.Sprintf("%s=%s", quoteFieldNameIfNeeded(fe.fieldName), quoteTokenIfNeeded(fe.value))
 }
 
-func (fe *filterExact) updateNeededFields(neededFields fieldsSet) {
+func (fe *filterExact) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add(fe.fieldName)
 }
 
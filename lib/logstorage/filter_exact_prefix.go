@@ -23,7 +23,7 @@ func (fep *filterExactPrefix) String() string {
 	return fmt.Sprintf("%s=%s*", quoteFieldNameIfNeeded(fep.fieldName), quoteTokenIfNeeded(fep.prefix))
 }
 
-func (fep *filterExactPrefix) updateNeededFields(neededFields fieldsSet) {
+func (fep *filterExactPrefix) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add(fep.fieldName)
 }
 
@@ -72,7 +72,7 @@ func (fi *filterIn) String() string {
 	return fmt.Sprintf("%sin(%s)", quoteFieldNameIfNeeded(fi.fieldName), args)
 }
 
-func (fi *filterIn) updateNeededFields(neededFields fieldsSet) {
+func (fi *filterIn) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add(fi.fieldName)
 }
 
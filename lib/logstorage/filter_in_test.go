@@ -1,8 +1,10 @@
 package logstorage
 
 import (
+	"fmt"
 	"reflect"
 	"slices"
+	"strings"
 	"testing"
 )
 
@@ -713,6 +715,78 @@ func TestFilterIn(t *testing.T) {
 		}
 		testFilterMatchForColumns(t, columns, fi, "_msg", nil)
 	})
+
+	t.Run("many-values", func(t *testing.T) {
+		t.Parallel()
+
+		columns := []column{
+			{
+				name: "user_id",
+				values: []string{
+					"user-1",
+					"user-20000",
+					"user-123456",
+					"user-42",
+					"admin",
+				},
+			},
+			{
+				name: "port",
+				values: []string{
+					"80",
+					"443",
+					"8080",
+					"65535",
+					"12",
+				},
+			},
+		}
+
+		// The number of values exceeds maxTokenSetsToInit
+		values := make([]string, 0, 20_000)
+		ports := make([]string, 0, 20_000)
+		for i := 0; i < 20_000; i++ {
+			values = append(values, fmt.Sprintf("user-%d", i))
+			ports = append(ports, fmt.Sprintf("%d", 20_000+i))
+		}
+
+		// match
+		fi := &filterIn{
+			fieldName: "user_id",
+			values:    values,
+		}
+		testFilterMatchForColumns(t, columns, fi, "user_id", []int{0, 3})
+
+		// match the filter with duplicate values; they must be deduplicated at parse time
+		valuesWithDuplicates := make([]string, 0, 2*len(values))
+		valuesWithDuplicates = append(valuesWithDuplicates, values...)
+		valuesWithDuplicates = append(valuesWithDuplicates, values...)
+		f, err := parseFilter(newLexer(fmt.Sprintf("user_id:in(%s)", strings.Join(valuesWithDuplicates, ","))))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		fi, ok := f.(*filterIn)
+		if !ok {
+			t.Fatalf("unexpected filter type; got %T; want *filterIn", f)
+		}
+		if !reflect.DeepEqual(fi.values, values) {
+			t.Fatalf("unexpected deduplicated values; got %d values; want %d values", len(fi.values), len(values))
+		}
+		testFilterMatchForColumns(t, columns, fi, "user_id", []int{0, 3})
+
+		fi = &filterIn{
+			fieldName: "port",
+			values:    append(ports, "443", "12"),
+		}
+		testFilterMatchForColumns(t, columns, fi, "port", []int{1, 4})
+
+		// mismatch
+		fi = &filterIn{
+			fieldName: "port",
+			values:    ports,
+		}
+		testFilterMatchForColumns(t, columns, fi, "port", nil)
+	})
 }
 
 func TestGetCommonTokensAndTokenSets(t *testing.T) {
@@ -21,7 +21,7 @@ func (fr *filterIPv4Range) String() string {
 	return fmt.Sprintf("%sipv4_range(%s, %s)", quoteFieldNameIfNeeded(fr.fieldName), minValue, maxValue)
 }
 
-func (fr *filterIPv4Range) updateNeededFields(neededFields fieldsSet) {
+func (fr *filterIPv4Range) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add(fr.fieldName)
 }
 
@@ -80,6 +80,14 @@ func (fr *filterIPv4Range) applyToBlockResult(br *blockResult, bm *bitmap) {
 	case valueTypeFloat64:
 		bm.resetBits()
 	case valueTypeIPv4:
+		if c.minValue > uint64(maxValue) || c.maxValue < uint64(minValue) {
+			bm.resetBits()
+			return
+		}
+		if c.minValue >= uint64(minValue) && c.maxValue <= uint64(maxValue) {
+			// Fast path - all the values in the block match the range.
+			return
+		}
 		valuesEncoded := c.getValuesEncoded(br)
 		bm.forEachSetBit(func(idx int) bool {
 			ip := unmarshalIPv4(valuesEncoded[idx])
@@ -174,6 +182,10 @@ func matchIPv4ByRange(bs *blockSearch, ch *columnHeader, bm *bitmap, minValue, m
 		bm.resetBits()
 		return
 	}
+	if ch.minValue >= uint64(minValue) && ch.maxValue <= uint64(maxValue) {
+		// Fast path - all the values in the block match the range.
+		return
+	}
 
 	visitValues(bs, ch, bm, func(v string) bool {
 		if len(v) != 4 {
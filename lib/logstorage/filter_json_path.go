@@ -0,0 +1,118 @@
+package logstorage
+
+import (
+	"strings"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fastnum"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// filterJSONPath applies f to the field in the form `obj.nested.key`.
+//
+// If the block doesn't contain the field with the given name, then f is applied to the value extracted
+// from JSON objects stored in `obj.nested` or `obj` field.
+//
+// filterJSONPath is created by initJSONPathFilters() at search time, so it isn't visible in LogsQL query.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#json-field-paths
+type filterJSONPath struct {
+	fieldName string
+	f         filter
+}
+
+func (fj *filterJSONPath) String() string {
+	return fj.f.String()
+}
+
+func (fj *filterJSONPath) updateNeededFields(neededFields *fieldsSet) {
+	fj.f.updateNeededFields(neededFields)
+}
+
+func (fj *filterJSONPath) applyToBlockResult(br *blockResult, bm *bitmap) {
+	fj.f.applyToBlockResult(br, bm)
+}
+
+func (fj *filterJSONPath) applyToBlockSearch(bs *blockSearch, bm *bitmap) {
+	fieldName := fj.fieldName
+
+	if bs.csh.getConstColumnValue(fieldName) != "" || bs.csh.getColumnHeader(fieldName) != nil {
+		// Fast path - the block contains the field with the given name.
+		fj.f.applyToBlockSearch(bs, bm)
+		return
+	}
+	src, ok := bs.csh.getJSONPathSource(fieldName)
+	if !ok {
+		// Fast path - the block doesn't contain JSON objects with the given field.
+		fj.f.applyToBlockSearch(bs, bm)
+		return
+	}
+	if bm.isZero() {
+		return
+	}
+
+	// Slow path - extract the field values from JSON objects for the rows selected by bm
+	// and apply the filter to them.
+	br := getBlockResult()
+	br.timestamps = fastnum.AppendInt64Zeros(br.timestamps[:0], bm.onesCount())
+	br.addJSONPathColumn(bs, bm, fieldName, &src)
+
+	bmResult := getBitmap(len(br.timestamps))
+	bmResult.setBits()
+	fj.f.applyToBlockResult(br, bmResult)
+
+	rowIdx := 0
+	bm.forEachSetBit(func(_ int) bool {
+		ok := bmResult.isSetBit(rowIdx)
+		rowIdx++
+		return ok
+	})
+
+	putBitmap(bmResult)
+	putBlockResult(br)
+}
+
+// hasJSONPathFilters returns true if f contains filters on fields in the form `obj.nested.key`.
+func hasJSONPathFilters(f filter) bool {
+	return visitFilter(f, isJSONPathFilter)
+}
+
+// initJSONPathFilters wraps filters on fields in the form `obj.nested.key` at f into filterJSONPath.
+func initJSONPathFilters(f filter) filter {
+	copyFunc := func(f filter) (filter, error) {
+		fj := &filterJSONPath{
+			fieldName: getJSONPathFilterFieldName(f),
+			f:         f,
+		}
+		return fj, nil
+	}
+	f, err := copyFilter(f, isJSONPathFilter, copyFunc)
+	if err != nil {
+		logger.Panicf("BUG: unexpected error: %s", err)
+	}
+	return f
+}
+
+func isJSONPathFilter(f filter) bool {
+	return getJSONPathFilterFieldName(f) != ""
+}
+
+// getJSONPathFilterFieldName returns the field name in the form `obj.nested.key` for the filter f.
+//
+// An empty string is returned if f doesn't filter a single field with such a name.
+func getJSONPathFilterFieldName(f filter) string {
+	if _, ok := f.(*filterJSONPath); ok {
+		return ""
+	}
+
+	fs := newFieldsSet()
+	f.updateNeededFields(fs)
+	fields := fs.getAll()
+	if len(fields) != 1 {
+		return ""
+	}
+	fieldName := fields[0]
+	if isWildcardFieldName(fieldName) || !strings.Contains(fieldName, ".") {
+		return ""
+	}
+	return fieldName
+}
@@ -0,0 +1,213 @@
+package logstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestFilterJSONPath(t *testing.T) {
+	t.Parallel()
+
+	t.Run("json-column", func(t *testing.T) {
+		t.Parallel()
+
+		columns := []column{
+			{
+				name: "obj",
+				values: []string{
+					`{"level":"error","req":{"path":"/foo","duration":123}}`,
+					`{"level":"info","req":{"path":"/bar","duration":45}}`,
+					`{"level":"error","req.path":"/bar"}`,
+					`foo bar`,
+					`{"level":"warn"}`,
+					``,
+				},
+			},
+		}
+
+		// match
+		f := &filterPhrase{
+			fieldName: "obj.level",
+			phrase:    "error",
+		}
+		testFilterMatchForColumns(t, columns, f, "obj", []int{0, 2})
+
+		f = &filterPhrase{
+			fieldName: "obj.req.path",
+			phrase:    "bar",
+		}
+		testFilterMatchForColumns(t, columns, f, "obj", []int{1, 2})
+
+		fr := &filterRange{
+			fieldName: "obj.req.duration",
+			minValue:  100,
+			maxValue:  200,
+		}
+		testFilterMatchForColumns(t, columns, fr, "obj", []int{0})
+
+		fp := &filterExactPrefix{
+			fieldName: "obj.req",
+			prefix:    `{"path":"/`,
+		}
+		testFilterMatchForColumns(t, columns, fp, "obj", []int{0, 1})
+
+		f = &filterPhrase{
+			fieldName: "obj.level",
+			phrase:    "",
+		}
+		testFilterMatchForColumns(t, columns, f, "obj", []int{3, 5})
+
+		fa := &filterAnd{
+			filters: []filter{
+				&filterPhrase{
+					fieldName: "obj.level",
+					phrase:    "error",
+				},
+				&filterNot{
+					f: &filterPhrase{
+						fieldName: "obj.req.path",
+						phrase:    "foo",
+					},
+				},
+			},
+		}
+		testFilterMatchForColumns(t, columns, fa, "obj", []int{2})
+
+		fo := &filterOr{
+			filters: []filter{
+				&filterPhrase{
+					fieldName: "obj.level",
+					phrase:    "warn",
+				},
+				&filterPhrase{
+					fieldName: "obj.req.path",
+					phrase:    "foo",
+				},
+			},
+		}
+		testFilterMatchForColumns(t, columns, fo, "obj", []int{0, 4})
+
+		// mismatch
+		f = &filterPhrase{
+			fieldName: "obj.level",
+			phrase:    "debug",
+		}
+		testFilterMatchForColumns(t, columns, f, "obj", nil)
+
+		f = &filterPhrase{
+			fieldName: "obj.missing",
+			phrase:    "error",
+		}
+		testFilterMatchForColumns(t, columns, f, "obj", nil)
+
+		f = &filterPhrase{
+			fieldName: "other.level",
+			phrase:    "error",
+		}
+		testFilterMatchForColumns(t, columns, f, "obj", nil)
+	})
+
+	t.Run("const-json-column", func(t *testing.T) {
+		t.Parallel()
+
+		columns := []column{
+			{
+				name: "obj",
+				values: []string{
+					`{"level":"error"}`,
+					`{"level":"error"}`,
+				},
+			},
+		}
+
+		f := &filterPhrase{
+			fieldName: "obj.level",
+			phrase:    "error",
+		}
+		testFilterMatchForColumns(t, columns, f, "obj", []int{0, 1})
+
+		f = &filterPhrase{
+			fieldName: "obj.level",
+			phrase:    "info",
+		}
+		testFilterMatchForColumns(t, columns, f, "obj", nil)
+	})
+
+	t.Run("existing-field", func(t *testing.T) {
+		t.Parallel()
+
+		// The existing field must be preferred over the value inside JSON object.
+		columns := []column{
+			{
+				name: "obj",
+				values: []string{
+					`{"level":"error"}`,
+					`{"level":"error"}`,
+					`{"level":"info"}`,
+				},
+			},
+			{
+				name: "obj.level",
+				values: []string{
+					"info",
+					"warn",
+					"error",
+				},
+			},
+		}
+
+		f := &filterPhrase{
+			fieldName: "obj.level",
+			phrase:    "error",
+		}
+		testFilterMatchForColumns(t, columns, f, "obj", []int{2})
+	})
+}
+
+func TestFilterJSONPathColumnValues(t *testing.T) {
+	t.Parallel()
+
+	columns := []column{
+		{
+			name: "obj",
+			values: []string{
+				`{"level":"error","req":{"path":"/foo","duration":123}}`,
+				`{"level":"info","req":{"path":"/bar","duration":45}}`,
+				`{"level":"error","req.path":"/bar"}`,
+				`foo bar`,
+			},
+		},
+	}
+
+	f := func(neededColumnName string, expectedValues []string) {
+		t.Helper()
+
+		storagePath := t.Name()
+		cfg := &StorageConfig{
+			Retention: time.Duration(100 * 365 * nsecsPerDay),
+		}
+		s := MustOpenStorage(storagePath, cfg)
+
+		tenantID := TenantID{
+			AccountID: 123,
+			ProjectID: 456,
+		}
+		generateRowsFromColumns(s, tenantID, columns)
+
+		expectedTimestamps := make([]int64, len(expectedValues))
+		for i := range expectedValues {
+			expectedTimestamps[i] = int64(i) * 1e9
+		}
+		testFilterMatchForStorage(t, s, tenantID, &filterNoop{}, neededColumnName, expectedValues, expectedTimestamps)
+
+		s.MustClose()
+		fs.MustRemoveAll(storagePath)
+	}
+
+	f("obj.level", []string{"error", "info", "error", ""})
+	f("obj.req.path", []string{"/foo", "/bar", "/bar", ""})
+	f("obj.req", []string{`{"path":"/foo","duration":123}`, `{"path":"/bar","duration":45}`, "", ""})
+	f("obj.missing", []string{"", "", "", ""})
+}
@@ -21,7 +21,7 @@ func (fr *filterLenRange) String() string {
 	return quoteFieldNameIfNeeded(fr.fieldName) + "len_range" + fr.stringRepr
 }
 
-func (fr *filterLenRange) updateNeededFields(neededFields fieldsSet) {
+func (fr *filterLenRange) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add(fr.fieldName)
 }
 
@@ -16,7 +16,7 @@ func (fn *filterNot) String() string {
 	return "!" + s
 }
 
-func (fn *filterNot) updateNeededFields(neededFields fieldsSet) {
+func (fn *filterNot) updateNeededFields(neededFields *fieldsSet) {
 	fn.f.updateNeededFields(neededFields)
 }
 
@@ -25,7 +25,7 @@ func (fo *filterOr) String() string {
 	return strings.Join(a, " or ")
 }
 
-func (fo *filterOr) updateNeededFields(neededFields fieldsSet) {
+func (fo *filterOr) updateNeededFields(neededFields *fieldsSet) {
 	for _, f := range fo.filters {
 		f.updateNeededFields(neededFields)
 	}
@@ -103,6 +103,10 @@ func (fo *filterOr) matchBloomFilters(bs *blockSearch) bool {
 
 		ch := bs.csh.getColumnHeader(fieldName)
 		if ch == nil {
+			if bs.csh.hasJSONPathSource(fieldName) {
+				// The field may be stored inside JSON objects, which cannot be checked via bloom filter.
+				return true
+			}
 			continue
 		}
 
@@ -142,6 +146,10 @@ func (fo *filterOr) initByFieldTokens() {
 	}
 
 	for _, f := range fo.filters {
+		if fj, ok := f.(*filterJSONPath); ok {
+			// Use tokens from the wrapped filter. They are verified against bloom filter only if the block contains the given field.
+			f = fj.f
+		}
 		switch t := f.(type) {
 		case *filterExact:
 			tokens := t.getTokens()
@@ -32,7 +32,7 @@ func (fp *filterPhrase) String() string {
 	return quoteFieldNameIfNeeded(fp.fieldName) + quoteTokenIfNeeded(fp.phrase)
 }
 
-func (fp *filterPhrase) updateNeededFields(neededFields fieldsSet) {
+func (fp *filterPhrase) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add(fp.fieldName)
 }
 
@@ -30,7 +30,7 @@ func (fp *filterPrefix) String() string {
 	return fmt.Sprintf("%s%s*", quoteFieldNameIfNeeded(fp.fieldName), quoteTokenIfNeeded(fp.prefix))
 }
 
-func (fp *filterPrefix) updateNeededFields(neededFields fieldsSet) {
+func (fp *filterPrefix) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add(fp.fieldName)
 }
 
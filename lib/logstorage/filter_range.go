@@ -22,7 +22,7 @@ func (fr *filterRange) String() string {
 	return quoteFieldNameIfNeeded(fr.fieldName) + fr.stringRepr
 }
 
-func (fr *filterRange) updateNeededFields(neededFields fieldsSet) {
+func (fr *filterRange) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add(fr.fieldName)
 }
 
@@ -80,6 +80,10 @@ func (fr *filterRange) applyToBlockResult(br *blockResult, bm *bitmap) {
 			bm.resetBits()
 			return
 		}
+		if minValueUint <= c.minValue && maxValueUint >= c.maxValue {
+			// Fast path - all the values in the block match the range.
+			return
+		}
 		valuesEncoded := c.getValuesEncoded(br)
 		bm.forEachSetBit(func(idx int) bool {
 			v := valuesEncoded[idx]
@@ -92,6 +96,10 @@ func (fr *filterRange) applyToBlockResult(br *blockResult, bm *bitmap) {
 			bm.resetBits()
 			return
 		}
+		if minValueUint <= c.minValue && maxValueUint >= c.maxValue {
+			// Fast path - all the values in the block match the range.
+			return
+		}
 		valuesEncoded := c.getValuesEncoded(br)
 		bm.forEachSetBit(func(idx int) bool {
 			v := valuesEncoded[idx]
@@ -104,6 +112,10 @@ func (fr *filterRange) applyToBlockResult(br *blockResult, bm *bitmap) {
 			bm.resetBits()
 			return
 		}
+		if minValueUint <= c.minValue && maxValueUint >= c.maxValue {
+			// Fast path - all the values in the block match the range.
+			return
+		}
 		valuesEncoded := c.getValuesEncoded(br)
 		bm.forEachSetBit(func(idx int) bool {
 			v := valuesEncoded[idx]
@@ -116,6 +128,10 @@ func (fr *filterRange) applyToBlockResult(br *blockResult, bm *bitmap) {
 			bm.resetBits()
 			return
 		}
+		if minValueUint <= c.minValue && maxValueUint >= c.maxValue {
+			// Fast path - all the values in the block match the range.
+			return
+		}
 		valuesEncoded := c.getValuesEncoded(br)
 		bm.forEachSetBit(func(idx int) bool {
 			v := valuesEncoded[idx]
@@ -127,6 +143,10 @@ func (fr *filterRange) applyToBlockResult(br *blockResult, bm *bitmap) {
 			bm.resetBits()
 			return
 		}
+		if minValue <= math.Float64frombits(c.minValue) && maxValue >= math.Float64frombits(c.maxValue) {
+			// Fast path - all the values in the block match the range.
+			return
+		}
 		valuesEncoded := c.getValuesEncoded(br)
 		bm.forEachSetBit(func(idx int) bool {
 			v := valuesEncoded[idx]
@@ -139,6 +159,10 @@ func (fr *filterRange) applyToBlockResult(br *blockResult, bm *bitmap) {
 			bm.resetBits()
 			return
 		}
+		if uint64(minValueUint32) <= c.minValue && uint64(maxValueUint32) >= c.maxValue {
+			// Fast path - all the values in the block match the range.
+			return
+		}
 		valuesEncoded := c.getValuesEncoded(br)
 		bm.forEachSetBit(func(idx int) bool {
 			v := valuesEncoded[idx]
@@ -151,6 +175,10 @@ func (fr *filterRange) applyToBlockResult(br *blockResult, bm *bitmap) {
 			bm.resetBits()
 			return
 		}
+		if minValueInt <= int64(c.minValue) && maxValueInt >= int64(c.maxValue) {
+			// Fast path - all the values in the block match the range.
+			return
+		}
 		valuesEncoded := c.getValuesEncoded(br)
 		bm.forEachSetBit(func(idx int) bool {
 			v := valuesEncoded[idx]
@@ -218,6 +246,10 @@ func matchFloat64ByRange(bs *blockSearch, ch *columnHeader, bm *bitmap, minValue
 		bm.resetBits()
 		return
 	}
+	if minValue <= math.Float64frombits(ch.minValue) && maxValue >= math.Float64frombits(ch.maxValue) {
+		// Fast path - all the values in the block match the range.
+		return
+	}
 
 	visitValues(bs, ch, bm, func(v string) bool {
 		if len(v) != 8 {
@@ -253,6 +285,10 @@ func matchUint8ByRange(bs *blockSearch, ch *columnHeader, bm *bitmap, minValue,
 		bm.resetBits()
 		return
 	}
+	if minValueUint <= ch.minValue && maxValueUint >= ch.maxValue {
+		// Fast path - all the values in the block match the range.
+		return
+	}
 	bb := bbPool.Get()
 	visitValues(bs, ch, bm, func(v string) bool {
 		if len(v) != 1 {
@@ -270,6 +306,10 @@ func matchUint16ByRange(bs *blockSearch, ch *columnHeader, bm *bitmap, minValue,
 		bm.resetBits()
 		return
 	}
+	if minValueUint <= ch.minValue && maxValueUint >= ch.maxValue {
+		// Fast path - all the values in the block match the range.
+		return
+	}
 	bb := bbPool.Get()
 	visitValues(bs, ch, bm, func(v string) bool {
 		if len(v) != 2 {
@@ -287,6 +327,10 @@ func matchUint32ByRange(bs *blockSearch, ch *columnHeader, bm *bitmap, minValue,
 		bm.resetBits()
 		return
 	}
+	if minValueUint <= ch.minValue && maxValueUint >= ch.maxValue {
+		// Fast path - all the values in the block match the range.
+		return
+	}
 	bb := bbPool.Get()
 	visitValues(bs, ch, bm, func(v string) bool {
 		if len(v) != 4 {
@@ -304,6 +348,10 @@ func matchUint64ByRange(bs *blockSearch, ch *columnHeader, bm *bitmap, minValue,
 		bm.resetBits()
 		return
 	}
+	if minValueUint <= ch.minValue && maxValueUint >= ch.maxValue {
+		// Fast path - all the values in the block match the range.
+		return
+	}
 	bb := bbPool.Get()
 	visitValues(bs, ch, bm, func(v string) bool {
 		if len(v) != 8 {
@@ -321,6 +369,10 @@ func matchTimestampISO8601ByRange(bs *blockSearch, ch *columnHeader, bm *bitmap,
 		bm.resetBits()
 		return
 	}
+	if minValueInt <= int64(ch.minValue) && maxValueInt >= int64(ch.maxValue) {
+		// Fast path - all the values in the block match the range.
+		return
+	}
 	bb := bbPool.Get()
 	visitValues(bs, ch, bm, func(v string) bool {
 		if len(v) != 8 {
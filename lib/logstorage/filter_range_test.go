@@ -1,6 +1,7 @@
 package logstorage
 
 import (
+	"math"
 	"testing"
 )
 
@@ -261,6 +262,14 @@ func TestFilterRange(t *testing.T) {
 		}
 		testFilterMatchForColumns(t, columns, fr, "foo", []int{3, 4, 6, 7})
 
+		// the range covers all the values in the block
+		fr = &filterRange{
+			fieldName: "foo",
+			minValue:  0,
+			maxValue:  123,
+		}
+		testFilterMatchForColumns(t, columns, fr, "foo", []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+
 		// mismatch
 		fr = &filterRange{
 			fieldName: "foo",
@@ -544,6 +553,14 @@ func TestFilterRange(t *testing.T) {
 		}
 		testFilterMatchForColumns(t, columns, fr, "foo", []int{5})
 
+		// the range covers all the values in the block
+		fr = &filterRange{
+			fieldName: "foo",
+			minValue:  -334,
+			maxValue:  123456.78901,
+		}
+		testFilterMatchForColumns(t, columns, fr, "foo", []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+
 		// mismatch
 		fr = &filterRange{
 			fieldName: "foo",
@@ -596,6 +613,14 @@ func TestFilterRange(t *testing.T) {
 			maxValue:  100,
 		}
 		testFilterMatchForColumns(t, columns, fr, "foo", []int{1})
+
+		// the range covers all the values in the block
+		fr = &filterRange{
+			fieldName: "foo",
+			minValue:  0,
+			maxValue:  math.MaxUint32,
+		}
+		testFilterMatchForColumns(t, columns, fr, "foo", []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11})
 	})
 
 	t.Run("timestamp-iso8601", func(t *testing.T) {
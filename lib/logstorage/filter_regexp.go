@@ -24,7 +24,7 @@ func (fr *filterRegexp) String() string {
 	return fmt.Sprintf("%s~%s", quoteFieldNameIfNeeded(fr.fieldName), quoteTokenIfNeeded(fr.re.String()))
 }
 
-func (fr *filterRegexp) updateNeededFields(neededFields fieldsSet) {
+func (fr *filterRegexp) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add(fr.fieldName)
 }
 
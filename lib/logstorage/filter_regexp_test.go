@@ -125,12 +125,24 @@ func TestFilterRegexp(t *testing.T) {
 		}
 		testFilterMatchForColumns(t, columns, fr, "foo", []int{0, 6, 8})
 
+		fr = &filterRegexp{
+			fieldName: "foo",
+			re:        mustCompileRegex("(a (127[.]0)+).+dfff"),
+		}
+		testFilterMatchForColumns(t, columns, fr, "foo", []int{7})
+
 		// mismatch
 		fr = &filterRegexp{
 			fieldName: "foo",
 			re:        mustCompileRegex("qwe.+rty|^$"),
 		}
 		testFilterMatchForColumns(t, columns, fr, "foo", nil)
+
+		fr = &filterRegexp{
+			fieldName: "foo",
+			re:        mustCompileRegex("(a (128[.]0)+).+dfff"),
+		}
+		testFilterMatchForColumns(t, columns, fr, "foo", nil)
 	})
 
 	t.Run("uint8", func(t *testing.T) {
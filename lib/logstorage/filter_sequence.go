@@ -31,7 +31,7 @@ func (fs *filterSequence) String() string {
 	return fmt.Sprintf("%sseq(%s)", quoteFieldNameIfNeeded(fs.fieldName), strings.Join(a, ","))
 }
 
-func (fs *filterSequence) updateNeededFields(neededFields fieldsSet) {
+func (fs *filterSequence) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add(fs.fieldName)
 }
 
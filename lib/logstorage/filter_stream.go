@@ -25,7 +25,7 @@ func (fs *filterStream) String() string {
 	return "_stream:" + fs.f.String()
 }
 
-func (fs *filterStream) updateNeededFields(neededFields fieldsSet) {
+func (fs *filterStream) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add("_stream")
 }
 
@@ -41,7 +41,7 @@ func (fs *filterStreamID) String() string {
 	return "_stream_id:in(" + strings.Join(a, ",") + ")"
 }
 
-func (fs *filterStreamID) updateNeededFields(neededFields fieldsSet) {
+func (fs *filterStreamID) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add("_stream_id")
 }
 
@@ -24,7 +24,7 @@ func (fr *filterStringRange) String() string {
 	return quoteFieldNameIfNeeded(fr.fieldName) + fr.stringRepr
 }
 
-func (fr *filterStringRange) updateNeededFields(neededFields fieldsSet) {
+func (fr *filterStringRange) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add(fr.fieldName)
 }
 
@@ -22,7 +22,7 @@ func (ft *filterTime) String() string {
 	return "_time:" + ft.stringRepr
 }
 
-func (ft *filterTime) updateNeededFields(neededFields fieldsSet) {
+func (ft *filterTime) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add("_time")
 }
 
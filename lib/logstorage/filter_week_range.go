@@ -27,7 +27,7 @@ func (fr *filterWeekRange) String() string {
 	return "_time:week_range" + fr.stringRepr
 }
 
-func (fr *filterWeekRange) updateNeededFields(neededFields fieldsSet) {
+func (fr *filterWeekRange) updateNeededFields(neededFields *fieldsSet) {
 	neededFields.add("_time")
 }
 
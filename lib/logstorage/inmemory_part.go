@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
 )
 
@@ -22,6 +23,7 @@ type inmemoryPart struct {
 	fieldBloomFilter   bytesutil.ByteBuffer
 	messageValues      bytesutil.ByteBuffer
 	messageBloomFilter bytesutil.ByteBuffer
+	fieldsIndex        bytesutil.ByteBuffer
 }
 
 // reset resets mp, so it can be re-used
@@ -36,10 +38,13 @@ func (mp *inmemoryPart) reset() {
 	mp.fieldBloomFilter.Reset()
 	mp.messageValues.Reset()
 	mp.messageBloomFilter.Reset()
+	mp.fieldsIndex.Reset()
 }
 
 // mustInitFromRows initializes mp from lr.
-func (mp *inmemoryPart) mustInitFromRows(lr *LogRows) {
+//
+// zstdDict is an optional ZSTD dictionary for compressing string values.
+func (mp *inmemoryPart) mustInitFromRows(lr *LogRows, zstdDict *zstd.Dict) {
 	mp.reset()
 
 	if len(lr.timestamps) == 0 {
@@ -49,7 +54,7 @@ func (mp *inmemoryPart) mustInitFromRows(lr *LogRows) {
 	sort.Sort(lr)
 
 	bsw := getBlockStreamWriter()
-	bsw.MustInitForInmemoryPart(mp)
+	bsw.MustInitForInmemoryPart(mp, zstdDict)
 	trs := getTmpRows()
 	var sidPrev *streamID
 	uncompressedBlockSizeBytes := uint64(0)
@@ -91,6 +96,7 @@ func (mp *inmemoryPart) MustStoreToDisk(path string) {
 	fieldBloomFilterPath := filepath.Join(path, fieldBloomFilename)
 	messageValuesPath := filepath.Join(path, messageValuesFilename)
 	messageBloomFilterPath := filepath.Join(path, messageBloomFilename)
+	fieldsIndexPath := filepath.Join(path, fieldsIndexFilename)
 
 	fs.MustWriteSync(metaindexPath, mp.metaindex.B)
 	fs.MustWriteSync(indexPath, mp.index.B)
@@ -100,6 +106,7 @@ func (mp *inmemoryPart) MustStoreToDisk(path string) {
 	fs.MustWriteSync(fieldBloomFilterPath, mp.fieldBloomFilter.B)
 	fs.MustWriteSync(messageValuesPath, mp.messageValues.B)
 	fs.MustWriteSync(messageBloomFilterPath, mp.messageBloomFilter.B)
+	fs.MustWriteSync(fieldsIndexPath, mp.fieldsIndex.B)
 
 	mp.ph.mustWriteMetadata(path)
 
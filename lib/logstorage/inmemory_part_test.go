@@ -35,7 +35,7 @@ func TestInmemoryPartMustInitFromRows(t *testing.T) {
 
 		// Create inmemory part from lr
 		mp := getInmemoryPart()
-		mp.mustInitFromRows(lr)
+		mp.mustInitFromRows(lr, nil)
 
 		// Check mp.ph
 		ph := &mp.ph
@@ -128,11 +128,11 @@ func TestInmemoryPartInitFromBlockStreamReaders(t *testing.T) {
 		var bsrs []*blockStreamReader
 		for _, lr := range lrs {
 			mp := getInmemoryPart()
-			mp.mustInitFromRows(lr)
+			mp.mustInitFromRows(lr, nil)
 			mpsSrc = append(mpsSrc, mp)
 
 			bsr := getBlockStreamReader()
-			bsr.MustInitFromInmemoryPart(mp)
+			bsr.MustInitFromInmemoryPart(mp, nil)
 			bsrs = append(bsrs, bsr)
 		}
 		defer func() {
@@ -147,8 +147,8 @@ func TestInmemoryPartInitFromBlockStreamReaders(t *testing.T) {
 		// Merge data from bsrs into mpDst
 		mpDst := getInmemoryPart()
 		bsw := getBlockStreamWriter()
-		bsw.MustInitForInmemoryPart(mpDst)
-		mustMergeBlockStreams(&mpDst.ph, bsw, bsrs, nil)
+		bsw.MustInitForInmemoryPart(mpDst, nil)
+		mustMergeBlockStreams(&mpDst.ph, bsw, bsrs, nil, nil)
 		putBlockStreamWriter(bsw)
 
 		// Check mpDst.ph stats
@@ -325,7 +325,7 @@ func (mp *inmemoryPart) readLogRows(sbu *stringsBlockUnmarshaler, vd *valuesDeco
 	lr := GetLogRows(nil, nil)
 	bsr := getBlockStreamReader()
 	defer putBlockStreamReader(bsr)
-	bsr.MustInitFromInmemoryPart(mp)
+	bsr.MustInitFromInmemoryPart(mp, nil)
 	var tmp rows
 	for bsr.NextBlock() {
 		bd := &bsr.blockData
@@ -24,7 +24,7 @@ func benchmarkInmemoryPartMustInitFromRows(b *testing.B, streams, rowsPerStream
 		lr := newTestLogRows(streams, rowsPerStream, 0)
 		mp := getInmemoryPart()
 		for pb.Next() {
-			mp.mustInitFromRows(lr)
+			mp.mustInitFromRows(lr, nil)
 			if mp.ph.RowsCount != uint64(len(lr.timestamps)) {
 				panic(fmt.Errorf("unexpecte number of entries in the output stream; got %d; want %d", mp.ph.RowsCount, len(lr.timestamps)))
 			}
@@ -0,0 +1,204 @@
+package logstorage
+
+import (
+	"strings"
+
+	"github.com/valyala/fastjson"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// jsonPathSource references the column with JSON objects, which contains values for the field in the form `obj.nested.key`.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#json-field-paths
+type jsonPathSource struct {
+	// ch is the header for the column with JSON objects.
+	//
+	// It is nil if the column is const.
+	ch *columnHeader
+
+	// constValue is the value for the const column with JSON object if ch is nil.
+	constValue string
+
+	// path is the path to the value inside JSON objects, such as `nested.key`.
+	path string
+}
+
+// getJSONPathSource returns the source column with JSON objects for the field with the given name in the form `obj.nested.key`.
+//
+// The column with the longest name matching the prefix of the field name is returned.
+// For example, `obj.nested` column is preferred over `obj` column for `obj.nested.key` field.
+//
+// It returns false if csh has no columns with JSON objects for the given field.
+// It must be called only if csh doesn't contain the column with the given name.
+func (csh *columnsHeader) getJSONPathSource(name string) (jsonPathSource, bool) {
+	for n := strings.LastIndexByte(name, '.'); n > 0; n = strings.LastIndexByte(name[:n], '.') {
+		prefix := name[:n]
+		path := name[n+1:]
+		if path == "" {
+			continue
+		}
+		if v := csh.getConstColumnValue(prefix); v != "" {
+			src := jsonPathSource{
+				constValue: v,
+				path:       path,
+			}
+			return src, true
+		}
+		if ch := csh.getColumnHeader(prefix); ch != nil {
+			src := jsonPathSource{
+				ch:   ch,
+				path: path,
+			}
+			return src, true
+		}
+	}
+	return jsonPathSource{}, false
+}
+
+// hasJSONPathSource returns true if csh contains the source column with JSON objects for the field with the given name.
+//
+// It must be called only if csh doesn't contain the column with the given name.
+func (csh *columnsHeader) hasJSONPathSource(name string) bool {
+	_, ok := csh.getJSONPathSource(name)
+	return ok
+}
+
+// addJSONPathColumn adds the column with the given name to br. Values for the column are extracted from JSON objects at src.
+//
+// Only the requested path is extracted from JSON objects. The extraction is performed on the first access to column values.
+//
+// The added column is valid until bs, bm or src is changed.
+func (br *blockResult) addJSONPathColumn(bs *blockSearch, bm *bitmap, name string, src *jsonPathSource) {
+	if src.ch == nil {
+		// Fast path - extract the value from const column only once.
+		p := jspp.Get()
+		bb := bbPool.Get()
+		bb.B = appendJSONPathValue(bb.B[:0], p, src.constValue, src.path)
+		br.addConstColumn(name, bytesutil.ToUnsafeString(bb.B))
+		bbPool.Put(bb)
+		jspp.Put(p)
+		return
+	}
+
+	switch src.ch.valueType {
+	case valueTypeString, valueTypeDict:
+		// Only string values may contain JSON objects.
+	default:
+		br.addConstColumn(name, "")
+		return
+	}
+
+	br.csBuf = append(br.csBuf, blockResultColumn{
+		name:      br.a.copyString(name),
+		valueType: valueTypeString,
+	})
+	c := &br.csBuf[len(br.csBuf)-1]
+
+	br.jvecs = append(br.jvecs, jsonPathValuesEncodedCreator{
+		bs:   bs,
+		bm:   bm,
+		ch:   src.ch,
+		path: src.path,
+	})
+	c.valuesEncodedCreator = &br.jvecs[len(br.jvecs)-1]
+	br.csInitialized = false
+}
+
+type jsonPathValuesEncodedCreator struct {
+	bs   *blockSearch
+	bm   *bitmap
+	ch   *columnHeader
+	path string
+}
+
+func (jvec *jsonPathValuesEncodedCreator) newValuesEncoded(br *blockResult) []string {
+	bs := jvec.bs
+	ch := jvec.ch
+
+	p := jspp.Get()
+	bb := bbPool.Get()
+
+	valuesBufLen := len(br.valuesBuf)
+	addValue := func(v string) {
+		bb.B = appendJSONPathValue(bb.B[:0], p, v, jvec.path)
+		br.addValue(bytesutil.ToUnsafeString(bb.B))
+	}
+	switch ch.valueType {
+	case valueTypeString:
+		visitValuesReadonly(bs, ch, jvec.bm, addValue)
+	case valueTypeDict:
+		dictValues := ch.valuesDict.values
+		visitValuesReadonly(bs, ch, jvec.bm, func(v string) {
+			dictIdx := unmarshalUint8(v)
+			if int(dictIdx) >= len(dictValues) {
+				logger.Panicf("FATAL: %s: too big dict index for column %q: %d; should be smaller than %d", bs.partPath(), ch.name, dictIdx, len(dictValues))
+			}
+			addValue(dictValues[dictIdx])
+		})
+	default:
+		logger.Panicf("BUG: unexpected valueType=%d for column %q with JSON objects", ch.valueType, ch.name)
+	}
+
+	bbPool.Put(bb)
+	jspp.Put(p)
+
+	return br.valuesBuf[valuesBufLen:]
+}
+
+// appendJSONPathValue appends the value for the given path inside JSON object s to dst and returns the result.
+//
+// Nothing is appended if s isn't a JSON object or if it doesn't contain the given path.
+// String values are appended without quotes, while other values are appended in JSON representation.
+func appendJSONPathValue(dst []byte, p *fastjson.Parser, s, path string) []byte {
+	if !strings.HasPrefix(s, "{") {
+		// Fast path - s cannot contain JSON object.
+		return dst
+	}
+	v, err := p.Parse(s)
+	if err != nil {
+		return dst
+	}
+	v = getJSONValueByPath(v, path)
+	if v == nil {
+		return dst
+	}
+	switch v.Type() {
+	case fastjson.TypeNull:
+		return dst
+	case fastjson.TypeString:
+		return append(dst, v.GetStringBytes()...)
+	default:
+		return v.MarshalTo(dst)
+	}
+}
+
+// getJSONValueByPath returns the value for the given path in the form `nested.key` inside v.
+//
+// JSON keys may contain dots, so the path `nested.key` matches both {"nested":{"key":...}} and {"nested.key":...}
+// in the same way as nested JSON objects are flattened during data ingestion.
+//
+// nil is returned if v doesn't contain the given path.
+func getJSONValueByPath(v *fastjson.Value, path string) *fastjson.Value {
+	if v.Type() != fastjson.TypeObject {
+		return nil
+	}
+	o := v.GetObject()
+	if vResult := o.Get(path); vResult != nil {
+		return vResult
+	}
+	for n := strings.IndexByte(path, '.'); n >= 0; {
+		if vNested := o.Get(path[:n]); vNested != nil {
+			if vResult := getJSONValueByPath(vNested, path[n+1:]); vResult != nil {
+				return vResult
+			}
+		}
+		nNext := strings.IndexByte(path[n+1:], '.')
+		if nNext < 0 {
+			break
+		}
+		n += nNext + 1
+	}
+	return nil
+}
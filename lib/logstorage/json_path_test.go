@@ -0,0 +1,49 @@
+package logstorage
+
+import (
+	"testing"
+)
+
+func TestAppendJSONPathValue(t *testing.T) {
+	f := func(s, path, resultExpected string) {
+		t.Helper()
+
+		p := jspp.Get()
+		defer jspp.Put(p)
+
+		result := appendJSONPathValue(nil, p, s, path)
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected result for path %q at %q; got %q; want %q", path, s, result, resultExpected)
+		}
+	}
+
+	// invalid JSON
+	f("", "foo", "")
+	f("foo bar", "foo", "")
+	f(`{"foo":"bar"`, "foo", "")
+	f(`["foo"]`, "foo", "")
+
+	// missing path
+	f(`{}`, "foo", "")
+	f(`{"foo":"bar"}`, "bar", "")
+	f(`{"foo":"bar"}`, "foo.bar", "")
+	f(`{"foo":{"bar":"baz"}}`, "foo.baz", "")
+	f(`{"foo":null}`, "foo", "")
+
+	// string values
+	f(`{"foo":"bar"}`, "foo", "bar")
+	f(`{"foo":"a \"b\"\nc"}`, "foo", "a \"b\"\nc")
+	f(`{"foo":{"bar":{"baz":"x"}}}`, "foo.bar.baz", "x")
+
+	// non-string values
+	f(`{"foo":123}`, "foo", "123")
+	f(`{"foo":true}`, "foo", "true")
+	f(`{"foo":[1,"2",{"a":3}]}`, "foo", `[1,"2",{"a":3}]`)
+	f(`{"foo":{"bar":"baz","x":1}}`, "foo", `{"bar":"baz","x":1}`)
+
+	// keys with dots
+	f(`{"foo.bar":"baz"}`, "foo.bar", "baz")
+	f(`{"foo":{"bar.baz":"x"}}`, "foo.bar.baz", "x")
+	f(`{"foo.bar":{"baz":"x"}}`, "foo.bar.baz", "x")
+	f(`{"foo":{"x":1},"foo.bar":"baz"}`, "foo.bar", "baz")
+}
@@ -0,0 +1,151 @@
+package logstorage
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/memory"
+)
+
+// memoryBudget is the memory budget shared among all the pipes of a single query.
+//
+// Pipes with state (stats, sort, uniq, top, etc.) borrow memory from the budget in chunks of stateSizeBudgetChunk bytes
+// via pipeMemoryBudget.
+type memoryBudget struct {
+	// maxSize is the maximum memory in bytes, which can be used by the pipes of a single query.
+	maxSize int64
+
+	// remaining is the remaining memory in bytes at the budget.
+	//
+	// It becomes negative when the pipes need more than maxSize bytes of memory.
+	remaining atomic.Int64
+
+	// exceeded is set to true if some pipe couldn't borrow memory from the budget.
+	exceeded atomic.Bool
+
+	// exceededBy is the pipe, which exceeded the budget first.
+	exceededBy atomic.Pointer[pipeMemoryBudget]
+}
+
+// newMemoryBudget returns new memory budget with the given maxSize in bytes.
+//
+// The default memory budget is returned if maxSize <= 0.
+func newMemoryBudget(maxSize int64) *memoryBudget {
+	if maxSize <= 0 {
+		maxSize = GetDefaultMaxQueryMemory()
+	}
+	mb := &memoryBudget{
+		maxSize: maxSize,
+	}
+	mb.remaining.Store(maxSize)
+	return mb
+}
+
+// isExceeded returns true if some pipe couldn't borrow memory from mb.
+func (mb *memoryBudget) isExceeded() bool {
+	return mb.exceeded.Load()
+}
+
+// newPipeMemoryBudget returns new pipeMemoryBudget for borrowing memory from mb by the pipe p.
+func (mb *memoryBudget) newPipeMemoryBudget(p fmt.Stringer) *pipeMemoryBudget {
+	return &pipeMemoryBudget{
+		mb: mb,
+		p:  p,
+	}
+}
+
+// pipeMemoryBudget is the part of memoryBudget borrowed by a single pipe processor.
+//
+// The borrowed memory must be returned to memoryBudget via release() when the pipe processor is flushed,
+// so it can be re-used by the subsequent pipes of the query.
+type pipeMemoryBudget struct {
+	mb *memoryBudget
+
+	// p is the pipe, which borrows the memory.
+	p fmt.Stringer
+
+	// borrowed is the memory in bytes borrowed from mb.
+	borrowed atomic.Int64
+
+	// exceeded is set to true if the pipe couldn't borrow memory from mb.
+	exceeded atomic.Bool
+}
+
+// reserve borrows n bytes from the budget without checking the memory limit.
+//
+// It is used for the initial per-shard state size budget.
+func (pmb *pipeMemoryBudget) reserve(n int64) {
+	pmb.mb.remaining.Add(-n)
+	pmb.borrowed.Add(n)
+}
+
+// borrow borrows n bytes from the budget.
+//
+// It returns false if the memory limit is exceeded. The pipe must stop processing data in this case,
+// and it must return pmb.limitError() at flush.
+func (pmb *pipeMemoryBudget) borrow(n int64) bool {
+	remaining := pmb.mb.remaining.Add(-n)
+	pmb.borrowed.Add(n)
+	if remaining >= 0 {
+		return true
+	}
+	if remaining+n >= 0 {
+		// This pipe exceeded the memory limit first, so it is blamed in the error message.
+		pmb.mb.exceededBy.CompareAndSwap(nil, pmb)
+	}
+	pmb.exceeded.Store(true)
+	pmb.mb.exceeded.Store(true)
+	return false
+}
+
+// tryBorrow borrows n bytes from the budget if it has enough memory.
+//
+// It returns false without borrowing the memory otherwise. It is used by pipes, which can spill their state to disk.
+func (pmb *pipeMemoryBudget) tryBorrow(n int64) bool {
+	if pmb.mb.remaining.Add(-n) < 0 {
+		pmb.mb.remaining.Add(n)
+		return false
+	}
+	pmb.borrowed.Add(n)
+	return true
+}
+
+// giveBack returns n bytes borrowed by the pipe to the budget.
+func (pmb *pipeMemoryBudget) giveBack(n int64) {
+	pmb.borrowed.Add(-n)
+	pmb.mb.remaining.Add(n)
+}
+
+// release returns all the memory borrowed by the pipe to the budget.
+func (pmb *pipeMemoryBudget) release() {
+	n := pmb.borrowed.Swap(0)
+	pmb.mb.remaining.Add(n)
+}
+
+// isExceeded returns true if the pipe couldn't borrow memory from the budget.
+func (pmb *pipeMemoryBudget) isExceeded() bool {
+	return pmb.exceeded.Load()
+}
+
+// culprit returns the pipeMemoryBudget for the pipe, which exceeded the memory limit first.
+//
+// pmb is returned if the pipe cannot be determined.
+func (pmb *pipeMemoryBudget) culprit() *pipeMemoryBudget {
+	if p := pmb.mb.exceededBy.Load(); p != nil {
+		return p
+	}
+	return pmb
+}
+
+// limitError returns the error for the exceeded memory limit.
+//
+// The error refers the pipe, which exceeded the memory limit first, since other pipes of the query
+// fail to borrow memory after that too.
+func (pmb *pipeMemoryBudget) limitError() error {
+	return fmt.Errorf("cannot calculate [%s], since it requires more than %dMB of memory", pmb.culprit().p, pmb.mb.maxSize/(1<<20))
+}
+
+// GetDefaultMaxQueryMemory returns the default memory limit in bytes for the pipes of a single query.
+func GetDefaultMaxQueryMemory() int64 {
+	return int64(float64(memory.Allowed()) * 0.3)
+}
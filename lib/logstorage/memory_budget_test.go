@@ -0,0 +1,222 @@
+package logstorage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMemoryBudgetSharedAmongPipes(t *testing.T) {
+	var rows [][]Field
+	prefix := strings.Repeat("x", 100)
+	for i := 0; i < 20_000; i++ {
+		rows = append(rows, []Field{
+			{"a", fmt.Sprintf("%s-%d", prefix, i)},
+		})
+	}
+	pipeStrs := []string{"uniq by (a)", "uniq by (a)"}
+
+	f := func(maxSize int64, isShared, resultExpected bool) {
+		t.Helper()
+
+		var mbs []*memoryBudget
+		mbShared := newMemoryBudget(maxSize)
+		for range pipeStrs {
+			if isShared {
+				mbs = append(mbs, mbShared)
+			} else {
+				mbs = append(mbs, newMemoryBudget(maxSize))
+			}
+		}
+
+		err := runTestPipesWithMemoryBudgets(t, pipeStrs, mbs, rows)
+		if resultExpected {
+			if err != nil {
+				t.Fatalf("unexpected error for maxSize=%d, isShared=%v: %s", maxSize, isShared, err)
+			}
+		} else if err == nil {
+			t.Fatalf("expecting non-nil error for maxSize=%d, isShared=%v", maxSize, isShared)
+		}
+	}
+
+	// The default budget is enough for both pipes
+	f(0, true, true)
+
+	// The budget is enough for every pipe individually, but not for both pipes at once
+	f(5<<20, false, true)
+	f(5<<20, true, false)
+
+	// The budget isn't enough even for a single pipe
+	f(2<<20, false, false)
+}
+
+func TestMemoryBudgetReleasedAtFlush(t *testing.T) {
+	var rows [][]Field
+	prefix := strings.Repeat("x", 100)
+	for i := 0; i < 20_000; i++ {
+		rows = append(rows, []Field{
+			{"a", fmt.Sprintf("%s-%d", prefix, i)},
+		})
+	}
+
+	// Only two pipes hold the state at any time, since the first pipe returns its budget after the flush,
+	// before the third pipe receives rows.
+	maxSize := int64(7 << 20)
+	pipeStrs := []string{"uniq by (a)", "uniq by (a)", "uniq by (a)"}
+	mb := newMemoryBudget(maxSize)
+	mbs := []*memoryBudget{mb, mb, mb}
+	if err := runTestPipesWithMemoryBudgets(t, pipeStrs, mbs, rows); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n := mb.remaining.Load(); n != maxSize {
+		t.Fatalf("unexpected remaining budget after the flush; got %d; want %d", n, maxSize)
+	}
+	if mb.isExceeded() {
+		t.Fatalf("the budget mustn't be exceeded")
+	}
+}
+
+func TestPipeStatsMergeShardsParallelMemoryBudget(t *testing.T) {
+	var rows [][]Field
+	for i := 0; i < 10_000; i++ {
+		rows = append(rows, []Field{
+			{"a", fmt.Sprintf("value-%d", i)},
+		})
+	}
+
+	f := func(exhaustBudget bool) error {
+		t.Helper()
+
+		lex := newLexer("stats by (a) count() as rows")
+		p, err := parsePipe(lex)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		workersCount := 3
+		ppTest := newTestPipeProcessor()
+		pp := p.newPipeProcessor(workersCount, make(chan struct{}), func() {}, ppTest, newMemoryBudget(0))
+		psp := pp.(*pipeStatsProcessor)
+
+		brw := newTestBlockResultWriter(workersCount, pp)
+		for _, row := range rows {
+			brw.writeRow(row)
+		}
+		brw.flush()
+
+		if exhaustBudget {
+			// Leave no memory for merging the shard states.
+			psp.pmb.mb.remaining.Store(0)
+		}
+		if err := pp.flush(); err != nil {
+			return err
+		}
+		if len(ppTest.resultRows) != len(rows) {
+			t.Fatalf("unexpected number of groups; got %d; want %d", len(ppTest.resultRows), len(rows))
+		}
+		if psp.pmb.borrowed.Load() != 0 {
+			t.Fatalf("the memory borrowed by the pipe must be released at flush")
+		}
+		return nil
+	}
+
+	if err := f(false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	err := f(true)
+	if err == nil {
+		t.Fatalf("expecting non-nil error when merging shard states without memory")
+	}
+	if s := "cannot calculate [stats by (a) count(*) as rows]"; !strings.Contains(err.Error(), s) {
+		t.Fatalf("unexpected error; got %q; want it containing %q", err, s)
+	}
+}
+
+func TestPipeMemoryBudget(t *testing.T) {
+	mb := newMemoryBudget(3 << 20)
+	pmb1 := mb.newPipeMemoryBudget(testStringer("pipe1"))
+	pmb2 := mb.newPipeMemoryBudget(testStringer("pipe2"))
+	pmb3 := mb.newPipeMemoryBudget(testStringer("pipe3"))
+
+	pmb1.reserve(1 << 20)
+	if !pmb1.borrow(1 << 20) {
+		t.Fatalf("pipe1 must borrow the memory")
+	}
+
+	// tryBorrow mustn't borrow the memory if the budget isn't enough
+	if pmb3.tryBorrow(2 << 20) {
+		t.Fatalf("pipe3 mustn't borrow the memory")
+	}
+	if pmb3.isExceeded() || mb.isExceeded() {
+		t.Fatalf("the budget mustn't be exceeded by tryBorrow")
+	}
+	if !pmb3.tryBorrow(1 << 20) {
+		t.Fatalf("pipe3 must borrow the memory")
+	}
+	pmb3.giveBack(1 << 20)
+
+	// pipe2 exceeds the budget first, so pipe1 failing to borrow memory after that must blame pipe2
+	if pmb2.borrow(2 << 20) {
+		t.Fatalf("pipe2 mustn't borrow the memory")
+	}
+	if pmb1.borrow(1 << 20) {
+		t.Fatalf("pipe1 mustn't borrow the memory")
+	}
+	if !pmb1.isExceeded() || !pmb2.isExceeded() || pmb3.isExceeded() || !mb.isExceeded() {
+		t.Fatalf("unexpected exceeded state; pipe1=%v, pipe2=%v, pipe3=%v", pmb1.isExceeded(), pmb2.isExceeded(), pmb3.isExceeded())
+	}
+	errExpected := "cannot calculate [pipe2], since it requires more than 3MB of memory"
+	for _, pmb := range []*pipeMemoryBudget{pmb1, pmb2} {
+		if err := pmb.limitError(); err.Error() != errExpected {
+			t.Fatalf("unexpected error for [%s]; got %q; want %q", pmb.p, err, errExpected)
+		}
+	}
+
+	// All the borrowed memory must be returned after the release
+	pmb1.release()
+	pmb2.release()
+	pmb3.release()
+	if n := mb.remaining.Load(); n != 3<<20 {
+		t.Fatalf("unexpected remaining budget; got %d; want %d", n, 3<<20)
+	}
+}
+
+type testStringer string
+
+func (s testStringer) String() string {
+	return string(s)
+}
+
+func runTestPipesWithMemoryBudgets(t *testing.T, pipeStrs []string, mbs []*memoryBudget, rows [][]Field) error {
+	t.Helper()
+
+	workersCount := 1
+	stopCh := make(chan struct{})
+	cancel := func() {}
+
+	var pp pipeProcessor = newTestPipeProcessor()
+	pps := make([]pipeProcessor, len(pipeStrs))
+	for i := len(pipeStrs) - 1; i >= 0; i-- {
+		lex := newLexer(pipeStrs[i])
+		p, err := parsePipe(lex)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", pipeStrs[i], err)
+		}
+		pp = p.newPipeProcessor(workersCount, stopCh, cancel, pp, mbs[i])
+		pps[i] = pp
+	}
+
+	brw := newTestBlockResultWriter(workersCount, pp)
+	for _, row := range rows {
+		brw.writeRow(row)
+	}
+	brw.flush()
+
+	var errFlush error
+	for _, pp := range pps {
+		if err := pp.flush(); err != nil && errFlush == nil {
+			errFlush = err
+		}
+	}
+	return errFlush
+}
@@ -0,0 +1,554 @@
+package logstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/backup/common"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timeutil"
+)
+
+// objectStorageBlockSize is the size of blocks the part files are split into when they are moved to object storage.
+//
+// Every block is stored as a separate object. Blocks are downloaded on demand and are cached at objectStorageCache.
+const objectStorageBlockSize = 4 * 1024 * 1024
+
+// objectStoragePartFilenames contains the names of part files, which are moved to object storage.
+//
+// The remaining part files are small and they are read only when the part is opened, so they are kept locally.
+var objectStoragePartFilenames = []string{
+	indexFilename,
+	columnsHeaderFilename,
+	timestampsFilename,
+	fieldValuesFilename,
+	fieldBloomFilename,
+	messageValuesFilename,
+	messageBloomFilename,
+}
+
+// objectStorage stores data files for parts of old partitions at object storage such as S3.
+//
+// Such parts are read on demand via objectStorageCache. They don't take part in background merges,
+// except of merges for applying retention filters and delete tasks.
+type objectStorage struct {
+	uploadedBytes   atomic.Uint64
+	downloadedBytes atomic.Uint64
+
+	// fs is the object storage
+	fs common.RemoteFS
+
+	// minAge is the minimum age for partitions to be moved to fs
+	minAge time.Duration
+
+	// cache is the local cache for blocks downloaded from fs
+	cache *objectStorageCache
+
+	// pendingDownloads contains blocks, which are downloaded at the moment.
+	//
+	// It is used for preventing from concurrent downloads of the same block.
+	pendingDownloads map[string]*objectStoragePendingDownload
+
+	// pendingDownloadsLock protects pendingDownloads.
+	pendingDownloadsLock sync.Mutex
+}
+
+type objectStoragePendingDownload struct {
+	wg   sync.WaitGroup
+	data []byte
+}
+
+func mustOpenObjectStorage(remoteFS common.RemoteFS, minAge time.Duration, cachePath string, cacheMaxSize uint64) *objectStorage {
+	return &objectStorage{
+		fs:               remoteFS,
+		minAge:           minAge,
+		cache:            mustOpenObjectStorageCache(cachePath, cacheMaxSize),
+		pendingDownloads: make(map[string]*objectStoragePendingDownload),
+	}
+}
+
+// objectStorageFile describes the part file stored at object storage.
+type objectStorageFile struct {
+	// Name is the name of the file in the part directory.
+	Name string
+
+	// Path is the path to the file at object storage. It uses / as directory delimiter.
+	Path string
+
+	// Size is the file size in bytes.
+	Size uint64
+}
+
+// getBlocks returns blocks for f at object storage.
+func (f *objectStorageFile) getBlocks() []common.Part {
+	var blocks []common.Part
+	for offset := uint64(0); offset < f.Size; offset += objectStorageBlockSize {
+		blocks = append(blocks, f.getBlock(offset))
+	}
+	return blocks
+}
+
+// getBlock returns the block for f starting at the given offset.
+func (f *objectStorageFile) getBlock(offset uint64) common.Part {
+	return common.Part{
+		Path:     f.Path,
+		FileSize: f.Size,
+		Offset:   offset,
+		Size:     min(objectStorageBlockSize, f.Size-offset),
+	}
+}
+
+// getCacheName returns the name for the block of f starting at the given offset at objectStorageCache.
+func (f *objectStorageFile) getCacheName(offset uint64) string {
+	return fmt.Sprintf("%s_%016X", strings.ReplaceAll(f.Path, "/", "_"), offset)
+}
+
+func mustWriteObjectStorageFiles(partPath string, osfs []objectStorageFile) {
+	data, err := json.Marshal(osfs)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal object storage files: %s", err)
+	}
+	osfsPath := filepath.Join(partPath, objectStorageFilesFilename)
+	fs.MustWriteAtomic(osfsPath, data, false)
+}
+
+// mustReadObjectStorageFiles reads the list of files stored at object storage for the part at partPath.
+//
+// nil is returned if the part is stored locally.
+func mustReadObjectStorageFiles(partPath string) []objectStorageFile {
+	osfsPath := filepath.Join(partPath, objectStorageFilesFilename)
+	if !fs.IsPathExist(osfsPath) {
+		return nil
+	}
+	data, err := os.ReadFile(osfsPath)
+	if err != nil {
+		logger.Panicf("FATAL: cannot read %q: %s", osfsPath, err)
+	}
+	var osfs []objectStorageFile
+	if err := json.Unmarshal(data, &osfs); err != nil {
+		logger.Panicf("FATAL: cannot parse %q: %s", osfsPath, err)
+	}
+	return osfs
+}
+
+// mustReadPartitionObjectStorageFiles returns files stored at object storage for all the parts of the partition at ptPath.
+func mustReadPartitionObjectStorageFiles(ptPath string) []objectStorageFile {
+	datadbPath := filepath.Join(ptPath, datadbDirname)
+	var osfs []objectStorageFile
+	for _, de := range fs.MustReadDir(datadbPath) {
+		if !fs.IsDirOrSymlink(de) {
+			continue
+		}
+		partPath := filepath.Join(datadbPath, de.Name())
+		osfs = append(osfs, mustReadObjectStorageFiles(partPath)...)
+	}
+	return osfs
+}
+
+func getObjectStorageFile(osfs []objectStorageFile, partPath, name string) *objectStorageFile {
+	for i := range osfs {
+		if osfs[i].Name == name {
+			return &osfs[i]
+		}
+	}
+	logger.Panicf("FATAL: missing %q file in %q", name, filepath.Join(partPath, objectStorageFilesFilename))
+	return nil
+}
+
+// uploadPartFiles uploads data files for the part at srcPartPath to the dstDir at obs.
+//
+// nil is returned if stopCh is closed during the upload. Already uploaded files are deleted in this case.
+func (obs *objectStorage) uploadPartFiles(srcPartPath, dstDir string, stopCh <-chan struct{}) ([]objectStorageFile, error) {
+	osfs := make([]objectStorageFile, 0, len(objectStoragePartFilenames))
+	for _, name := range objectStoragePartFilenames {
+		srcPath := filepath.Join(srcPartPath, name)
+		f := objectStorageFile{
+			Name: name,
+			Path: dstDir + "/" + name,
+			Size: fs.MustFileSize(srcPath),
+		}
+		ok, err := obs.uploadFile(srcPath, &f, stopCh)
+		if err != nil || !ok {
+			obs.deleteFiles(osfs)
+			return nil, err
+		}
+		osfs = append(osfs, f)
+	}
+	return osfs, nil
+}
+
+// uploadFile uploads the file at srcPath to f at obs.
+//
+// false is returned if stopCh is closed during the upload. Already uploaded blocks for f are deleted in this case and on error.
+func (obs *objectStorage) uploadFile(srcPath string, f *objectStorageFile, stopCh <-chan struct{}) (bool, error) {
+	r, err := os.Open(srcPath)
+	if err != nil {
+		return false, fmt.Errorf("cannot open %q: %w", srcPath, err)
+	}
+	defer fs.MustClose(r)
+
+	blocks := f.getBlocks()
+	for i := range blocks {
+		block := &blocks[i]
+		if needStop(stopCh) {
+			obs.deleteBlocks(blocks[:i])
+			return false, nil
+		}
+		sr := io.NewSectionReader(r, int64(block.Offset), int64(block.Size))
+		if err := obs.fs.UploadPart(*block, sr); err != nil {
+			obs.deleteBlocks(blocks[:i])
+			return false, fmt.Errorf("cannot upload %s from %q to %s: %w", block, srcPath, obs.fs, err)
+		}
+		obs.uploadedBytes.Add(block.Size)
+	}
+	return true, nil
+}
+
+// deleteFiles deletes osfs from obs.
+func (obs *objectStorage) deleteFiles(osfs []objectStorageFile) {
+	for i := range osfs {
+		obs.deleteBlocks(osfs[i].getBlocks())
+	}
+	if err := obs.fs.RemoveEmptyDirs(); err != nil {
+		logger.Errorf("cannot remove empty directories at %s: %s", obs.fs, err)
+	}
+}
+
+// deleteBlocks deletes blocks from obs.
+//
+// Errors are logged, since the deleted blocks aren't referred by parts anymore.
+func (obs *objectStorage) deleteBlocks(blocks []common.Part) {
+	for i := range blocks {
+		block := &blocks[i]
+		if err := obs.fs.DeletePart(*block); err != nil {
+			logger.Errorf("cannot delete %s from %s: %s", block, obs.fs, err)
+		}
+	}
+}
+
+// mustReadAt reads len(dst) bytes at the offset off from f.
+func (obs *objectStorage) mustReadAt(f *objectStorageFile, dst []byte, off int64) {
+	if off < 0 || uint64(off)+uint64(len(dst)) > f.Size {
+		logger.Panicf("BUG: cannot read %d bytes at offset %d from %q at %s with size %d bytes", len(dst), off, f.Path, obs.fs, f.Size)
+	}
+	for len(dst) > 0 {
+		offInBlock := uint64(off) % objectStorageBlockSize
+		blockOffset := uint64(off) - offInBlock
+		n := min(uint64(len(dst)), objectStorageBlockSize-offInBlock)
+
+		cacheName := f.getCacheName(blockOffset)
+		if !obs.cache.readAt(cacheName, dst[:n], int64(offInBlock)) {
+			data := obs.mustDownloadBlock(f, blockOffset, true)
+			copy(dst[:n], data[offInBlock:])
+		}
+
+		dst = dst[n:]
+		off += int64(n)
+	}
+}
+
+// mustDownloadBlock downloads the block of f starting at blockOffset.
+//
+// The downloaded block is stored in obs.cache if putToCache is set.
+func (obs *objectStorage) mustDownloadBlock(f *objectStorageFile, blockOffset uint64, putToCache bool) []byte {
+	cacheName := f.getCacheName(blockOffset)
+
+	obs.pendingDownloadsLock.Lock()
+	pd := obs.pendingDownloads[cacheName]
+	if pd != nil {
+		obs.pendingDownloadsLock.Unlock()
+		// Wait until the concurrent goroutine downloads the block.
+		pd.wg.Wait()
+		return pd.data
+	}
+	pd = &objectStoragePendingDownload{}
+	pd.wg.Add(1)
+	obs.pendingDownloads[cacheName] = pd
+	obs.pendingDownloadsLock.Unlock()
+
+	block := f.getBlock(blockOffset)
+	var bb bytesutil.ByteBuffer
+	bb.B = make([]byte, 0, block.Size)
+	if err := obs.fs.DownloadPart(block, &bb); err != nil {
+		logger.Panicf("FATAL: cannot download %s from %s: %s", &block, obs.fs, err)
+	}
+	obs.downloadedBytes.Add(block.Size)
+	pd.data = bb.B
+	if putToCache {
+		obs.cache.put(cacheName, pd.data)
+	}
+
+	obs.pendingDownloadsLock.Lock()
+	delete(obs.pendingDownloads, cacheName)
+	obs.pendingDownloadsLock.Unlock()
+	pd.wg.Done()
+
+	return pd.data
+}
+
+func (obs *objectStorage) updateStats(ss *StorageStats) {
+	ss.ObjectStorageUploadedBytes += obs.uploadedBytes.Load()
+	ss.ObjectStorageDownloadedBytes += obs.downloadedBytes.Load()
+	obs.cache.updateStats(ss)
+}
+
+// objectStorageReaderAt implements fs.MustReadAtCloser for the file stored at object storage.
+type objectStorageReaderAt struct {
+	obs *objectStorage
+	f   *objectStorageFile
+}
+
+func (obs *objectStorage) newReaderAt(f *objectStorageFile) *objectStorageReaderAt {
+	return &objectStorageReaderAt{
+		obs: obs,
+		f:   f,
+	}
+}
+
+// Path returns path to r.
+func (r *objectStorageReaderAt) Path() string {
+	return fmt.Sprintf("%s/%s", r.obs.fs, r.f.Path)
+}
+
+// MustReadAt reads len(p) bytes at the offset off from r.
+func (r *objectStorageReaderAt) MustReadAt(p []byte, off int64) {
+	r.obs.mustReadAt(r.f, p, off)
+}
+
+// MustClose closes r.
+func (r *objectStorageReaderAt) MustClose() {
+	r.f = nil
+	r.obs = nil
+}
+
+// objectStorageStreamReader implements filestream.ReadCloser for sequential reading of the file stored at object storage.
+//
+// It is used for merging parts stored at object storage. The downloaded blocks aren't put into objectStorageCache,
+// since they are read only once during the merge.
+type objectStorageStreamReader struct {
+	obs *objectStorage
+	f   *objectStorageFile
+
+	// offset is the offset for the next Read call
+	offset uint64
+
+	// block contains the block data starting at blockOffset
+	block       []byte
+	blockOffset uint64
+
+	// buf is a buffer for reading blocks from objectStorageCache.
+	//
+	// It is used instead of block, since block may refer to the data shared with concurrent downloaders.
+	buf []byte
+}
+
+func (obs *objectStorage) newStreamReader(f *objectStorageFile) *objectStorageStreamReader {
+	return &objectStorageStreamReader{
+		obs: obs,
+		f:   f,
+	}
+}
+
+// Path returns path to r.
+func (r *objectStorageStreamReader) Path() string {
+	return fmt.Sprintf("%s/%s", r.obs.fs, r.f.Path)
+}
+
+// Read reads up to len(p) bytes from r.
+func (r *objectStorageStreamReader) Read(p []byte) (int, error) {
+	if r.offset >= r.f.Size {
+		return 0, io.EOF
+	}
+	if r.offset < r.blockOffset || r.offset >= r.blockOffset+uint64(len(r.block)) {
+		blockOffset := r.offset - r.offset%objectStorageBlockSize
+		block := r.f.getBlock(blockOffset)
+		cacheName := r.f.getCacheName(blockOffset)
+		r.buf = bytesutil.ResizeNoCopyNoOverallocate(r.buf, int(block.Size))
+		if r.obs.cache.readAt(cacheName, r.buf, 0) {
+			r.block = r.buf
+		} else {
+			r.block = r.obs.mustDownloadBlock(r.f, blockOffset, false)
+		}
+		r.blockOffset = blockOffset
+	}
+	n := copy(p, r.block[r.offset-r.blockOffset:])
+	r.offset += uint64(n)
+	return n, nil
+}
+
+// MustClose closes r.
+func (r *objectStorageStreamReader) MustClose() {
+	r.block = nil
+	r.buf = nil
+	r.f = nil
+	r.obs = nil
+}
+
+// isObjectStoragePart returns true if the data files for p are stored at object storage.
+func (p *part) isObjectStoragePart() bool {
+	return len(p.objectStorageFiles) > 0
+}
+
+// mustMovePartsToObjectStorage moves file parts at ddb to object storage.
+//
+// Local file parts are merged into a single part before moving it to object storage,
+// since parts stored at object storage don't take part in background merges.
+func (ddb *datadb) mustMovePartsToObjectStorage(stopCh <-chan struct{}) {
+	pws := ddb.getLocalFilePartsForObjectStorage()
+	if len(pws) > 1 {
+		bigPartsConcurrencyCh <- struct{}{}
+		ddb.mustMergeParts(pws, false)
+		<-bigPartsConcurrencyCh
+
+		pws = ddb.getLocalFilePartsForObjectStorage()
+		if len(pws) > 1 {
+			// New parts have been added to ddb during the merge. Move them on the next run.
+			ddb.releasePartsToMerge(pws)
+			return
+		}
+	}
+	if len(pws) == 0 || needStop(stopCh) {
+		ddb.releasePartsToMerge(pws)
+		return
+	}
+	ddb.mustMovePartToObjectStorage(pws[0], stopCh)
+}
+
+// getLocalFilePartsForObjectStorage returns local file parts, which can be moved to object storage.
+//
+// The returned parts are marked as in merge. nil is returned if some of local file parts are already in merge.
+func (ddb *datadb) getLocalFilePartsForObjectStorage() []*partWrapper {
+	ddb.partsLock.Lock()
+	defer ddb.partsLock.Unlock()
+
+	var pws []*partWrapper
+	for _, pw := range ddb.smallParts {
+		if !pw.p.isObjectStoragePart() {
+			pws = append(pws, pw)
+		}
+	}
+	for _, pw := range ddb.bigParts {
+		if !pw.p.isObjectStoragePart() {
+			pws = append(pws, pw)
+		}
+	}
+	for _, pw := range pws {
+		if pw.isInMerge {
+			return nil
+		}
+	}
+	for _, pw := range pws {
+		pw.isInMerge = true
+	}
+	return pws
+}
+
+// mustMovePartToObjectStorage moves the data files of the part at pw to object storage.
+//
+// The part at pw must have isInMerge field set to true. The isInMerge field is set to false before returning from the function.
+func (ddb *datadb) mustMovePartToObjectStorage(pw *partWrapper, stopCh <-chan struct{}) {
+	pws := []*partWrapper{pw}
+	assertIsInMerge(pws)
+	defer ddb.releasePartsToMerge(pws)
+
+	startTime := time.Now()
+	obs := ddb.pt.s.objectStorage
+	srcPartPath := pw.p.path
+	compressedSize := pw.p.ph.CompressedSizeBytes
+
+	mergeIdx := ddb.nextMergeIdx()
+	dstPartType := ddb.getDstPartType(pws, true)
+	dstPartPath := ddb.getDstPartPath(dstPartType, mergeIdx)
+	dstDir := ddb.pt.name + "/" + filepath.Base(dstPartPath)
+
+	osfs, err := obs.uploadPartFiles(srcPartPath, dstDir, stopCh)
+	if err != nil {
+		// Use logger.Errorf instead of logger.Panicf in the hope the error is temporary. The part will be moved on the next run.
+		logger.Errorf("cannot move part %q to object storage: %s", srcPartPath, err)
+		return
+	}
+	if osfs == nil {
+		// stopCh has been closed.
+		return
+	}
+
+	// Create the part directory with the files, which are needed for opening the part, and with the list of files stored at object storage.
+	fs.MustMkdirFailIfExist(dstPartPath)
+	fs.MustCopyFile(filepath.Join(srcPartPath, metadataFilename), filepath.Join(dstPartPath, metadataFilename))
+	fs.MustCopyFile(filepath.Join(srcPartPath, metaindexFilename), filepath.Join(dstPartPath, metaindexFilename))
+	if fieldsIndexPath := filepath.Join(srcPartPath, fieldsIndexFilename); fs.IsPathExist(fieldsIndexPath) {
+		fs.MustCopyFile(fieldsIndexPath, filepath.Join(dstPartPath, fieldsIndexFilename))
+	}
+	mustWriteObjectStorageFiles(dstPartPath, osfs)
+	fs.MustSyncPath(dstPartPath)
+
+	// Atomically swap the local part with the part stored at object storage.
+	pwNew := ddb.openCreatedPart(&pw.p.ph, pws, nil, dstPartPath)
+	ddb.swapSrcWithDstParts(pws, pwNew, dstPartType)
+
+	logger.Infof("moved part %q with %d bytes to %s in %.3f seconds", srcPartPath, compressedSize, obs.fs, time.Since(startTime).Seconds())
+}
+
+func (s *Storage) runObjectStorageWatcher() {
+	if s.objectStorage == nil {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		s.watchObjectStorage()
+		s.wg.Done()
+	}()
+}
+
+func (s *Storage) watchObjectStorage() {
+	d := timeutil.AddJitterToDuration(time.Minute)
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		s.movePartitionsToObjectStorage()
+
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// movePartitionsToObjectStorage moves data for partitions older than s.objectStorage.minAge to object storage.
+func (s *Storage) movePartitionsToObjectStorage() {
+	maxDay := s.getMaxObjectStorageDay()
+
+	var ptws []*partitionWrapper
+	s.partitionsLock.Lock()
+	for _, ptw := range s.partitions {
+		if ptw.day > maxDay {
+			// s.partitions are sorted by day
+			break
+		}
+		ptw.incRef()
+		ptws = append(ptws, ptw)
+	}
+	s.partitionsLock.Unlock()
+
+	for _, ptw := range ptws {
+		if !needStop(s.stopCh) {
+			ptw.pt.ddb.mustMovePartsToObjectStorage(s.stopCh)
+		}
+		ptw.decRef()
+	}
+}
+
+// getMaxObjectStorageDay returns the maximum day for partitions, which must be moved to object storage.
+func (s *Storage) getMaxObjectStorageDay() int64 {
+	return time.Now().UTC().Add(-s.objectStorage.minAge).UnixNano()/nsecPerDay - 1
+}
@@ -0,0 +1,209 @@
+package logstorage
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// objectStorageCache is a local on-disk cache for data blocks read from object storage.
+//
+// Every cached block is stored in a separate file at the cache directory.
+// The least recently used blocks are deleted when the total size of the cached blocks exceeds the maximum size.
+type objectStorageCache struct {
+	requests atomic.Uint64
+	misses   atomic.Uint64
+
+	// path is the path to the cache directory.
+	path string
+
+	// maxSize is the maximum size of the cached blocks in bytes.
+	maxSize uint64
+
+	// mu protects size, entries and lru.
+	mu sync.Mutex
+
+	// size is the total size of the cached blocks in bytes.
+	size uint64
+
+	// entries contains lru elements for the cached blocks keyed by the name of the file with the block data.
+	entries map[string]*list.Element
+
+	// lru contains objectStorageCacheEntry items ordered by the last access time. The most recently used items are at the front.
+	lru list.List
+}
+
+type objectStorageCacheEntry struct {
+	// name is the name of the file with the block data at objectStorageCache.path
+	name string
+
+	// size is the size of the block data
+	size uint64
+}
+
+// mustOpenObjectStorageCache opens the cache at the given path with the given maxSize in bytes.
+//
+// Blocks cached before the restart are preserved if they fit maxSize.
+func mustOpenObjectStorageCache(path string, maxSize uint64) *objectStorageCache {
+	fs.MustMkdirIfNotExist(path)
+
+	type cachedFile struct {
+		name    string
+		size    uint64
+		modTime time.Time
+	}
+	var cfs []cachedFile
+	for _, de := range fs.MustReadDir(path) {
+		name := de.Name()
+		filePath := filepath.Join(path, name)
+		if de.IsDir() || fs.IsTemporaryFileName(name) {
+			// Remove unexpected directories and temporary files, which may be left after unclean shutdown.
+			fs.MustRemoveAll(filePath)
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			logger.Panicf("FATAL: cannot obtain information about %q: %s", filePath, err)
+		}
+		cfs = append(cfs, cachedFile{
+			name:    name,
+			size:    uint64(fi.Size()),
+			modTime: fi.ModTime(),
+		})
+	}
+	sort.Slice(cfs, func(i, j int) bool {
+		return cfs[i].modTime.After(cfs[j].modTime)
+	})
+
+	c := &objectStorageCache{
+		path:    path,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element, len(cfs)),
+	}
+	c.mu.Lock()
+	for _, cf := range cfs {
+		c.addLocked(cf.name, cf.size, false)
+	}
+	c.evictLocked()
+	c.mu.Unlock()
+
+	return c
+}
+
+// readAt reads len(dst) bytes at the offset off from the cached block with the given name.
+//
+// false is returned if the block is missing in the cache.
+func (c *objectStorageCache) readAt(name string, dst []byte, off int64) bool {
+	c.requests.Add(1)
+
+	c.mu.Lock()
+	e, ok := c.entries[name]
+	if ok {
+		c.lru.MoveToFront(e)
+	}
+	c.mu.Unlock()
+
+	if ok && c.tryReadAt(name, dst, off) {
+		return true
+	}
+	c.misses.Add(1)
+	return false
+}
+
+func (c *objectStorageCache) tryReadAt(name string, dst []byte, off int64) bool {
+	filePath := filepath.Join(c.path, name)
+	f, err := os.Open(filePath)
+	if err != nil {
+		// The block has been concurrently evicted from the cache.
+		return false
+	}
+	n, err := f.ReadAt(dst, off)
+	fs.MustClose(f)
+	if err != nil || n != len(dst) {
+		logger.Errorf("cannot read %d bytes at offset %d from the cached object storage block %q; removing it from the cache; error: %v", len(dst), off, filePath, err)
+		c.remove(name)
+		return false
+	}
+	return true
+}
+
+// put stores block data with the given name in the cache.
+func (c *objectStorageCache) put(name string, data []byte) {
+	if uint64(len(data)) > c.maxSize {
+		// The block doesn't fit the cache.
+		return
+	}
+
+	c.mu.Lock()
+	_, ok := c.entries[name]
+	c.mu.Unlock()
+	if ok {
+		// The block has been already cached by concurrent goroutine.
+		return
+	}
+
+	filePath := filepath.Join(c.path, name)
+	fs.MustWriteAtomic(filePath, data, true)
+
+	c.mu.Lock()
+	c.addLocked(name, uint64(len(data)), true)
+	c.evictLocked()
+	c.mu.Unlock()
+}
+
+func (c *objectStorageCache) addLocked(name string, size uint64, toFront bool) {
+	if _, ok := c.entries[name]; ok {
+		return
+	}
+	e := &objectStorageCacheEntry{
+		name: name,
+		size: size,
+	}
+	if toFront {
+		c.entries[name] = c.lru.PushFront(e)
+	} else {
+		c.entries[name] = c.lru.PushBack(e)
+	}
+	c.size += size
+}
+
+func (c *objectStorageCache) remove(name string) {
+	c.mu.Lock()
+	if e, ok := c.entries[name]; ok {
+		c.removeLocked(e)
+	}
+	c.mu.Unlock()
+}
+
+func (c *objectStorageCache) evictLocked() {
+	for c.size > c.maxSize {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+func (c *objectStorageCache) removeLocked(e *list.Element) {
+	ce := c.lru.Remove(e).(*objectStorageCacheEntry)
+	delete(c.entries, ce.name)
+	c.size -= ce.size
+	fs.MustRemoveAll(filepath.Join(c.path, ce.name))
+}
+
+// updateStats updates ss with the stats for c.
+func (c *objectStorageCache) updateStats(ss *StorageStats) {
+	ss.ObjectStorageCacheRequests += c.requests.Load()
+	ss.ObjectStorageCacheMisses += c.misses.Load()
+
+	c.mu.Lock()
+	ss.ObjectStorageCacheEntries += uint64(len(c.entries))
+	ss.ObjectStorageCacheSizeBytes += c.size
+	c.mu.Unlock()
+
+	ss.ObjectStorageCacheMaxSizeBytes += c.maxSize
+}
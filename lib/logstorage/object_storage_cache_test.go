@@ -0,0 +1,129 @@
+package logstorage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestObjectStorageCache(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	c := mustOpenObjectStorageCache(path, 100)
+
+	f := func(name string, offset int64, size int, resultExpected string) {
+		t.Helper()
+
+		dst := make([]byte, size)
+		ok := c.readAt(name, dst, offset)
+		if resultExpected == "" {
+			if ok {
+				t.Fatalf("unexpected block %q found in the cache", name)
+			}
+			return
+		}
+		if !ok {
+			t.Fatalf("cannot find block %q in the cache", name)
+		}
+		if string(dst) != resultExpected {
+			t.Fatalf("unexpected data read from block %q at offset %d; got %q; want %q", name, offset, dst, resultExpected)
+		}
+	}
+
+	data := func(ch byte, n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = ch + byte(i%10)
+		}
+		return b
+	}
+
+	verifyStats := func(entriesExpected, sizeExpected uint64) {
+		t.Helper()
+
+		var ss StorageStats
+		c.updateStats(&ss)
+		if ss.ObjectStorageCacheEntries != entriesExpected {
+			t.Fatalf("unexpected number of cache entries; got %d; want %d", ss.ObjectStorageCacheEntries, entriesExpected)
+		}
+		if ss.ObjectStorageCacheSizeBytes != sizeExpected {
+			t.Fatalf("unexpected cache size; got %d; want %d", ss.ObjectStorageCacheSizeBytes, sizeExpected)
+		}
+		if ss.ObjectStorageCacheMaxSizeBytes != 100 {
+			t.Fatalf("unexpected max cache size; got %d; want 100", ss.ObjectStorageCacheMaxSizeBytes)
+		}
+	}
+
+	// Missing block
+	f("a", 0, 1, "")
+	verifyStats(0, 0)
+
+	// Put blocks into the cache and read them
+	c.put("a", data('a', 40))
+	c.put("b", data('0', 40))
+	f("a", 0, 3, "abc")
+	f("a", 12, 5, "cdefg")
+	f("b", 38, 2, "89")
+	verifyStats(2, 80)
+
+	// Repeated put for the same block must be ignored
+	c.put("a", data('a', 40))
+	verifyStats(2, 80)
+
+	// Too big block mustn't be cached
+	c.put("big", data('a', 101))
+	f("big", 0, 1, "")
+	verifyStats(2, 80)
+
+	// The least recently used block must be evicted
+	f("a", 0, 1, "a")
+	c.put("c", data('0', 30))
+	f("b", 0, 1, "")
+	f("a", 1, 2, "bc")
+	f("c", 0, 4, "0123")
+	verifyStats(2, 70)
+	if fs.IsPathExist(filepath.Join(path, "b")) {
+		t.Fatalf("the file for the evicted block must be removed")
+	}
+
+	var ss StorageStats
+	c.updateStats(&ss)
+	if ss.ObjectStorageCacheRequests != 9 {
+		t.Fatalf("unexpected number of cache requests; got %d; want 9", ss.ObjectStorageCacheRequests)
+	}
+	if ss.ObjectStorageCacheMisses != 3 {
+		t.Fatalf("unexpected number of cache misses; got %d; want 3", ss.ObjectStorageCacheMisses)
+	}
+
+	// The cached blocks must be preserved after the reopen, while temporary files must be removed
+	tmpPath := filepath.Join(path, "d.tmp.1")
+	if err := os.WriteFile(tmpPath, []byte("foo"), 0644); err != nil {
+		t.Fatalf("cannot create temporary file: %s", err)
+	}
+	c = mustOpenObjectStorageCache(path, 100)
+	f("a", 0, 3, "abc")
+	f("c", 0, 4, "0123")
+	verifyStats(2, 70)
+	if fs.IsPathExist(tmpPath) {
+		t.Fatalf("temporary file must be removed on cache open")
+	}
+
+	// The least recently modified blocks must be evicted on reopen with smaller max size
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(path, "a"), now, now.Add(-time.Hour)); err != nil {
+		t.Fatalf("cannot change modification time: %s", err)
+	}
+	c = mustOpenObjectStorageCache(path, 50)
+	f("a", 0, 1, "")
+	f("c", 0, 1, "0")
+	if fs.IsPathExist(filepath.Join(path, "a")) {
+		t.Fatalf("the file for the evicted block must be removed")
+	}
+
+	fs.MustRemoveAll(path)
+}
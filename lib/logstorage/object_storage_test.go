@@ -0,0 +1,268 @@
+package logstorage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/backup/fsremote"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestStorageObjectStorage(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	objectStoragePath, err := filepath.Abs(filepath.Join(path, "object-storage"))
+	if err != nil {
+		t.Fatalf("cannot obtain absolute path: %s", err)
+	}
+	objectStorageFS := &fsremote.FS{
+		Dir: objectStoragePath,
+	}
+	sc := &StorageConfig{
+		Retention:                   30 * 24 * time.Hour,
+		ObjectStorage:               objectStorageFS,
+		ObjectStorageMinAge:         24 * time.Hour,
+		ObjectStorageCacheSizeBytes: 1 << 20,
+
+		// Disable the query results cache, so queries read data from object storage.
+		DisableQueryResultsCache: true,
+	}
+	storagePath := filepath.Join(path, "storage")
+	s := MustOpenStorage(storagePath, sc)
+
+	tenantID := TenantID{
+		AccountID: 1,
+		ProjectID: 2,
+	}
+	const rowsPerBatch = 1000
+	oldTimestamp := time.Now().UnixNano() - 5*nsecPerDay
+	newTimestamp := time.Now().UnixNano() - 3600*1e9
+	addRows := func(baseTimestamp int64) {
+		lr := GetLogRows([]string{"host"}, nil)
+		for i := 0; i < rowsPerBatch; i++ {
+			fields := []Field{
+				{
+					Name:  "host",
+					Value: fmt.Sprintf("host-%d", i%5),
+				},
+				{
+					Name:  "_msg",
+					Value: fmt.Sprintf("message #%d", i),
+				},
+			}
+			lr.MustAdd(tenantID, baseTimestamp+int64(i)*1e6, fields)
+		}
+		s.MustAddRows(lr)
+		PutLogRows(lr)
+	}
+
+	flushToFiles := func() {
+		s.partitionsLock.Lock()
+		for _, ptw := range s.partitions {
+			ptw.pt.ddb.mustFlushInmemoryPartsToFiles(true)
+		}
+		s.partitionsLock.Unlock()
+	}
+
+	getResults := func(qStr string) []string {
+		t.Helper()
+
+		var rows []string
+		var rowsLock sync.Mutex
+		writeBlock := func(_ uint, _ []int64, columns []BlockColumn) {
+			rowsLock.Lock()
+			defer rowsLock.Unlock()
+
+			for _, c := range columns {
+				rows = append(rows, c.Values...)
+			}
+		}
+		q := mustParseQuery(qStr)
+		if err := s.RunQuery(context.Background(), []TenantID{tenantID}, q, writeBlock); err != nil {
+			t.Fatalf("unexpected error for [%s]: %s", qStr, err)
+		}
+		return rows
+	}
+
+	getStats := func() *StorageStats {
+		var ss StorageStats
+		s.UpdateStats(&ss)
+		return &ss
+	}
+
+	// moveToObjectStorage moves the old partition to object storage and verifies that only the part for the new partition is left locally.
+	//
+	// The move is retried, since it is skipped if the old parts are merged in background at the moment.
+	moveToObjectStorage := func() {
+		t.Helper()
+
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			s.movePartitionsToObjectStorage()
+			ss := getStats()
+			if ss.ObjectStorageParts > 0 && ss.SmallParts+ss.BigParts-ss.ObjectStorageParts == 1 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout when moving the old partition to object storage; objectStorageParts: %d; fileParts: %d",
+					ss.ObjectStorageParts, ss.SmallParts+ss.BigParts)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Add two batches of old rows and a single batch of new rows, so the old partition has two local parts.
+	addRows(oldTimestamp)
+	flushToFiles()
+	addRows(oldTimestamp + 3600*1e9)
+	addRows(newTimestamp)
+	flushToFiles()
+
+	queries := []string{
+		`* | stats by (host) count() hits | sort by (host)`,
+		`"message #42" | stats count() hits, min(_time) min_time, max(_time) max_time`,
+		`_time:2d | stats count() hits`,
+		`* | stats count_uniq(_msg) msgs`,
+	}
+	resultsExpected := make([][]string, len(queries))
+	for i, qStr := range queries {
+		resultsExpected[i] = getResults(qStr)
+	}
+	verifyResults := func() {
+		t.Helper()
+
+		for i, qStr := range queries {
+			results := getResults(qStr)
+			if !reflect.DeepEqual(results, resultsExpected[i]) {
+				t.Fatalf("unexpected results for [%s]\ngot\n%q\nwant\n%q", qStr, results, resultsExpected[i])
+			}
+		}
+	}
+
+	// Move the old partition to object storage. Local parts must be merged into a single part before that.
+	// The background watcher may move some of the old parts to object storage concurrently,
+	// so verify only that the old partition has no local parts after the move.
+	moveToObjectStorage()
+	ss := getStats()
+	if ss.ObjectStorageUploadedBytes == 0 {
+		t.Fatalf("expecting non-zero bytes uploaded to object storage")
+	}
+	objectStorageFilesCount := getRegularFilesCount(t, objectStoragePath)
+	if objectStorageFilesCount == 0 {
+		t.Fatalf("expecting non-zero number of files at object storage")
+	}
+
+	// The part at object storage must be readable
+	verifyResults()
+	ss = getStats()
+	if ss.ObjectStorageDownloadedBytes == 0 {
+		t.Fatalf("expecting non-zero bytes downloaded from object storage")
+	}
+	if ss.ObjectStorageCacheEntries == 0 {
+		t.Fatalf("expecting non-zero number of cached blocks")
+	}
+
+	// The repeated queries must be served from the cache
+	downloadedBytes := ss.ObjectStorageDownloadedBytes
+	verifyResults()
+	ss = getStats()
+	if ss.ObjectStorageDownloadedBytes != downloadedBytes {
+		t.Fatalf("unexpected download from object storage for cached data; downloaded %d bytes; want %d bytes", ss.ObjectStorageDownloadedBytes, downloadedBytes)
+	}
+
+	// Repeated moving must be no-op
+	s.movePartitionsToObjectStorage()
+	if n := getRegularFilesCount(t, objectStoragePath); n != objectStorageFilesCount {
+		t.Fatalf("unexpected number of files at object storage after repeated moving; got %d; want %d", n, objectStorageFilesCount)
+	}
+
+	// The part at object storage must be readable after the restart
+	s.MustClose()
+	s = MustOpenStorage(storagePath, sc)
+	verifyResults()
+
+	// Late rows for the old partition must be moved to object storage as a separate part
+	addRows(oldTimestamp + 2*3600*1e9)
+	flushToFiles()
+	moveToObjectStorage()
+	ss = getStats()
+	if ss.RowsCount() != 4*rowsPerBatch {
+		t.Fatalf("unexpected number of rows; got %d; want %d", ss.RowsCount(), 4*rowsPerBatch)
+	}
+
+	// Delete tasks must be applied to parts at object storage
+	if _, err := s.DeleteRows([]TenantID{tenantID}, mustParseQuery("host:host-3")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s.startMergesForDeleteTasks()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		s.removeFinishedDeleteTasks()
+		if len(s.GetDeleteTasks()) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout when waiting for the delete task completion")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rowsCountExpected := uint64(4*rowsPerBatch - 4*rowsPerBatch/5)
+	if n := getStats().RowsCount(); n != rowsCountExpected {
+		t.Fatalf("unexpected number of rows after the deletion; got %d; want %d", n, rowsCountExpected)
+	}
+
+	// The merged part must be moved to object storage again, while the replaced parts must be deleted from object storage
+	moveToObjectStorage()
+	ss = getStats()
+	if n := getRegularFilesCount(t, objectStoragePath); n == 0 {
+		t.Fatalf("expecting non-zero number of files at object storage after the deletion")
+	}
+
+	// Dropping the partition must delete its data from object storage
+	s.partitionsLock.Lock()
+	ptw := s.partitions[0]
+	s.partitions = s.partitions[1:]
+	s.partitionsLock.Unlock()
+	ptw.mustDrop.Store(true)
+	ptw.decRef()
+	if n := getRegularFilesCount(t, objectStoragePath); n != 0 {
+		t.Fatalf("unexpected number of files at object storage after dropping the partition; got %d; want 0", n)
+	}
+	if n := getStats().ObjectStorageParts; n != 0 {
+		t.Fatalf("unexpected number of parts at object storage after dropping the partition; got %d; want 0", n)
+	}
+
+	s.MustClose()
+
+	fs.MustRemoveAll(path)
+}
+
+func getRegularFilesCount(t *testing.T, dir string) int {
+	t.Helper()
+
+	n := 0
+	err := filepath.WalkDir(dir, func(_ string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot walk %q: %s", dir, err)
+	}
+	return n
+}
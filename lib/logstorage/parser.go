@@ -3,6 +3,7 @@ package logstorage
 import (
 	"fmt"
 	"math"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promutils"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/querytracer"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/regexutil"
 )
 
@@ -37,6 +39,9 @@ type lexer struct {
 
 	// currentTimestamp is the current timestamp in nanoseconds
 	currentTimestamp int64
+
+	// isCurrentTimestampUsed is set to true if currentTimestamp has been used for parsing relative time such as `_time:5m`.
+	isCurrentTimestampUsed bool
 }
 
 type lexerState struct {
@@ -96,6 +101,11 @@ func (lex *lexer) isKeyword(keywords ...string) bool {
 	return false
 }
 
+// tokenPos returns the byte offset of the current token at lex.sOrig.
+func (lex *lexer) tokenPos() int {
+	return len(lex.sOrig) - len(lex.s) - len(lex.rawToken)
+}
+
 func (lex *lexer) context() string {
 	tail := lex.sOrig
 	tail = tail[:len(tail)-len(lex.s)]
@@ -221,6 +231,37 @@ type Query struct {
 	f filter
 
 	pipes []pipe
+
+	// maxMemory is the maximum memory in bytes, which can be used by pipes at the query.
+	//
+	// The default limit is used if maxMemory is zero.
+	maxMemory int64
+
+	// maxScannedRows is the maximum number of rows, which can be scanned by the query.
+	//
+	// There is no limit if maxScannedRows is zero.
+	maxScannedRows uint64
+
+	// maxScannedBytes is the maximum number of compressed bytes, which can be read from the storage by the query.
+	//
+	// There is no limit if maxScannedBytes is zero.
+	maxScannedBytes uint64
+
+	// dedupReplicas is set to true if the duplicate logs returned from replicas must be removed by RunFederatedQuery.
+	dedupReplicas bool
+
+	// hasRelativeTime is set to true if the query contains time relative to the current time such as `_time:5m`.
+	//
+	// The string representation of such queries doesn't identify the selected time range, so their results cannot be cached.
+	hasRelativeTime bool
+
+	// timestamp is the timestamp in nanoseconds the query has been parsed at.
+	//
+	// It is used for parsing the query at remote storage nodes, so relative time filters select the same time range there.
+	timestamp int64
+
+	// qt is an optional tracer for the query execution.
+	qt *querytracer.Tracer
 }
 
 // String returns string representation for q.
@@ -234,6 +275,42 @@ func (q *Query) String() string {
 	return s
 }
 
+// QueryPlan contains the execution plan for the query.
+type QueryPlan struct {
+	// Filter is the string representation of the query filter.
+	Filter string
+
+	// Pipes contains string representations of the query pipes.
+	Pipes []string
+
+	// NeededFields contains names of the fields, which are read from the storage.
+	//
+	// It contains "*" if all the fields except of UnneededFields are read from the storage.
+	NeededFields []string
+
+	// UnneededFields contains names of the fields, which aren't read from the storage if NeededFields contains "*".
+	UnneededFields []string
+}
+
+// GetQueryPlan returns the execution plan for q.
+//
+// Call q.Optimize() before calling GetQueryPlan() in order to obtain the plan for the optimized query.
+func (q *Query) GetQueryPlan() *QueryPlan {
+	pipes := make([]string, len(q.pipes))
+	for i, p := range q.pipes {
+		pipes[i] = p.String()
+	}
+
+	neededFields, unneededFields := q.getNeededColumns()
+
+	return &QueryPlan{
+		Filter:         q.f.String(),
+		Pipes:          pipes,
+		NeededFields:   neededFields,
+		UnneededFields: unneededFields,
+	}
+}
+
 // CanLiveTail returns true if q can be used in live tailing
 func (q *Query) CanLiveTail() bool {
 	for _, p := range q.pipes {
@@ -326,6 +403,52 @@ func (q *Query) AddCountByTimePipe(step, off int64, fields []string) {
 	}
 }
 
+// GetStatsByFields returns `by (...)` fields from the last `stats` pipe at q.
+//
+// An error is returned if q doesn't end with `stats` pipe.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#stats-pipe
+func (q *Query) GetStatsByFields() ([]string, error) {
+	if len(q.pipes) == 0 {
+		return nil, fmt.Errorf("missing `stats` pipe at the end of query [%s]", q)
+	}
+	ps, ok := q.pipes[len(q.pipes)-1].(*pipeStats)
+	if !ok {
+		return nil, fmt.Errorf("the last pipe must be `stats`; got [%s]", q.pipes[len(q.pipes)-1])
+	}
+	fields := make([]string, len(ps.byFields))
+	for i, bf := range ps.byFields {
+		fields[i] = bf.name
+	}
+	return fields, nil
+}
+
+// GetFieldsPipeFields returns fields from the last `fields` pipe at q in the order they are listed in the pipe.
+//
+// The `fields` pipe may be followed by pipes, which do not change the set of fields, such as `sort`, `limit` and `offset`.
+// An error is returned if the set of fields returned by q cannot be determined from the `fields` pipe.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#fields-pipe
+func (q *Query) GetFieldsPipeFields() ([]string, error) {
+	for i := len(q.pipes) - 1; i >= 0; i-- {
+		switch t := q.pipes[i].(type) {
+		case *pipeFields:
+			if t.containsStar || len(t.excludeFields) > 0 {
+				return nil, fmt.Errorf("the `fields` pipe must contain explicit list of fields; got [%s]", t)
+			}
+			return append([]string{}, t.fields...), nil
+		case *pipeSort:
+			if t.rankName != "" {
+				return nil, fmt.Errorf("unexpected `sort` pipe with rank after the `fields` pipe: [%s]", t)
+			}
+		case *pipeLimit, *pipeOffset:
+		default:
+			return nil, fmt.Errorf("unexpected pipe after the `fields` pipe: [%s]", t)
+		}
+	}
+	return nil, fmt.Errorf("missing `fields` pipe at query [%s]", q)
+}
+
 // Clone returns a copy of q.
 func (q *Query) Clone() *Query {
 	qStr := q.String()
@@ -333,9 +456,63 @@ func (q *Query) Clone() *Query {
 	if err != nil {
 		logger.Panicf("BUG: cannot parse %q: %s", qStr, err)
 	}
+	qCopy.maxMemory = q.maxMemory
+	qCopy.maxScannedRows = q.maxScannedRows
+	qCopy.maxScannedBytes = q.maxScannedBytes
+	qCopy.dedupReplicas = q.dedupReplicas
+	qCopy.qt = q.qt
 	return qCopy
 }
 
+// cloneWithPipes returns a shallow copy of q with the given pipes.
+//
+// The returned query shares the filter with q and preserves all the query settings such as limits, timestamp and tracer.
+func (q *Query) cloneWithPipes(pipes []pipe) *Query {
+	qCopy := *q
+	qCopy.pipes = pipes
+	return &qCopy
+}
+
+// SetMaxMemory sets the maximum memory in bytes, which can be used by pipes at q.
+//
+// The default limit is used if maxMemory <= 0.
+func (q *Query) SetMaxMemory(maxMemory int64) {
+	if maxMemory < 0 {
+		maxMemory = 0
+	}
+	q.maxMemory = maxMemory
+}
+
+// SetMaxScannedRows sets the maximum number of rows, which can be scanned by q.
+//
+// The query fails if it needs to scan more rows. There is no limit if maxScannedRows is zero.
+func (q *Query) SetMaxScannedRows(maxScannedRows uint64) {
+	q.maxScannedRows = maxScannedRows
+}
+
+// SetMaxScannedBytes sets the maximum number of compressed bytes, which can be read from the storage by q.
+//
+// The query fails if it needs to read more bytes. There is no limit if maxScannedBytes is zero.
+func (q *Query) SetMaxScannedBytes(maxScannedBytes uint64) {
+	q.maxScannedBytes = maxScannedBytes
+}
+
+// SetDedupReplicas enables or disables the deduplication of logs returned from storage nodes by RunFederatedQuery.
+//
+// It must be enabled if the same logs are stored at multiple storage nodes (replicas).
+// See RunFederatedQuery for details.
+func (q *Query) SetDedupReplicas(dedupReplicas bool) {
+	q.dedupReplicas = dedupReplicas
+}
+
+// SetTracer sets the tracer for q execution.
+//
+// The trace contains the duration and the number of processed rows for the storage search and for every pipe at q.
+// Queries with the same tracer cannot be executed concurrently.
+func (q *Query) SetTracer(qt *querytracer.Tracer) {
+	q.qt = qt
+}
+
 // CanReturnLastNResults returns true if time range filter at q can be adjusted for returning the last N results.
 func (q *Query) CanReturnLastNResults() bool {
 	for _, p := range q.pipes {
@@ -354,20 +531,42 @@ func (q *Query) CanReturnLastNResults() bool {
 }
 
 // GetFilterTimeRange returns filter time range for the given q.
+//
+// The returned time range covers all the `_time` filters at q, including `_time` filters at every branch of `or` filters.
 func (q *Query) GetFilterTimeRange() (int64, int64) {
-	switch t := q.f.(type) {
+	return getFilterTimeRange(q.f)
+}
+
+func getFilterTimeRange(f filter) (int64, int64) {
+	switch t := f.(type) {
 	case *filterAnd:
 		minTimestamp := int64(math.MinInt64)
 		maxTimestamp := int64(math.MaxInt64)
 		for _, filter := range t.filters {
-			ft, ok := filter.(*filterTime)
-			if ok {
-				if ft.minTimestamp > minTimestamp {
-					minTimestamp = ft.minTimestamp
-				}
-				if ft.maxTimestamp < maxTimestamp {
-					maxTimestamp = ft.maxTimestamp
-				}
+			tMin, tMax := getFilterTimeRange(filter)
+			if tMin > minTimestamp {
+				minTimestamp = tMin
+			}
+			if tMax < maxTimestamp {
+				maxTimestamp = tMax
+			}
+		}
+		return minTimestamp, maxTimestamp
+	case *filterOr:
+		// The time range must cover time ranges for all the 'or' branches.
+		minTimestamp := int64(math.MaxInt64)
+		maxTimestamp := int64(math.MinInt64)
+		for _, filter := range t.filters {
+			tMin, tMax := getFilterTimeRange(filter)
+			if tMin > tMax {
+				// The branch cannot match any logs
+				continue
+			}
+			if tMin < minTimestamp {
+				minTimestamp = tMin
+			}
+			if tMax > maxTimestamp {
+				maxTimestamp = tMax
 			}
 		}
 		return minTimestamp, maxTimestamp
@@ -409,11 +608,39 @@ func (q *Query) AddPipeLimit(n uint64) {
 	})
 }
 
+// AddFacetsPipe adds `| fields field1, ..., fieldN | facets limit max_values_per_field maxValuesPerField max_value_len maxValueLen` pipes to q.
+//
+// If fields is empty, then facets are calculated for all the fields. Zero limit, maxValuesPerField and maxValueLen mean default values.
+func (q *Query) AddFacetsPipe(fields []string, limit, maxValuesPerField, maxValueLen uint64, keepConstFields bool) {
+	if len(fields) > 0 && !slices.Contains(fields, "*") {
+		q.pipes = append(q.pipes, &pipeFields{
+			fields: fields,
+		})
+	}
+
+	pf := &pipeFacets{
+		limit:             pipeFacetsDefaultLimit,
+		maxValuesPerField: pipeFacetsDefaultMaxValuesPerField,
+		maxValueLen:       pipeFacetsDefaultMaxValueLen,
+		keepConstFields:   keepConstFields,
+	}
+	if limit > 0 {
+		pf.limit = limit
+	}
+	if maxValuesPerField > 0 {
+		pf.maxValuesPerField = maxValuesPerField
+	}
+	if maxValueLen > 0 {
+		pf.maxValueLen = maxValueLen
+	}
+	q.pipes = append(q.pipes, pf)
+}
+
 // Optimize tries optimizing the query.
 func (q *Query) Optimize() {
-	q.pipes = optimizeSortOffsetPipes(q.pipes)
-	q.pipes = optimizeSortLimitPipes(q.pipes)
+	q.pipes = optimizeSortOffsetLimitPipes(q.pipes)
 	q.pipes = optimizeUniqLimitPipes(q.pipes)
+	q.pipes = optimizePushDownFilterPipes(q.pipes)
 	q.pipes = optimizeFilterPipes(q.pipes)
 
 	// Merge `q | filter ...` into q.
@@ -433,6 +660,14 @@ func (q *Query) Optimize() {
 		}
 	}
 
+	// Optimize `q | unpack_logfmt ...` by unpacking only the fields needed by the subsequent pipes.
+	for i, p := range q.pipes {
+		pu, ok := p.(*pipeUnpackLogfmt)
+		if ok {
+			pu.initNeededOutFields(q.pipes[i+1:])
+		}
+	}
+
 	// Substitute '*' prefixFilter with filterNoop in order to avoid reading _msg data.
 	q.f = removeStarFilters(q.f)
 
@@ -461,72 +696,122 @@ func removeStarFilters(f filter) filter {
 	return f
 }
 
-func optimizeSortOffsetPipes(pipes []pipe) []pipe {
-	// Merge 'sort ... | offset ...' into 'sort ... offset ...'
+func optimizeSortOffsetLimitPipes(pipes []pipe) []pipe {
+	// Merge 'sort ... | offset ... | limit ...' into 'sort ... offset ... limit ...',
+	// so the sort pipe keeps only offset+limit rows instead of sorting all the rows.
 	i := 1
 	for i < len(pipes) {
-		po, ok := pipes[i].(*pipeOffset)
+		ps, ok := pipes[i-1].(*pipeSort)
 		if !ok {
 			i++
 			continue
 		}
-		ps, ok := pipes[i-1].(*pipeSort)
-		if !ok {
+		switch t := pipes[i].(type) {
+		case *pipeOffset:
+			if !ps.mergeOffset(t.offset) {
+				i++
+				continue
+			}
+		case *pipeLimit:
+			if len(t.byFields) > 0 || !ps.mergeLimit(t.limit) {
+				i++
+				continue
+			}
+		default:
 			i++
 			continue
 		}
-		if ps.offset == 0 && ps.limit == 0 {
-			ps.offset = po.offset
-		}
 		pipes = append(pipes[:i], pipes[i+1:]...)
 	}
 	return pipes
 }
 
-func optimizeSortLimitPipes(pipes []pipe) []pipe {
-	// Merge 'sort ... | limit ...' into 'sort ... limit ...'
+func optimizeUniqLimitPipes(pipes []pipe) []pipe {
+	// Merge 'uniq ... | limit ...' into 'uniq ... limit ...'
 	i := 1
 	for i < len(pipes) {
 		pl, ok := pipes[i].(*pipeLimit)
-		if !ok {
+		if !ok || len(pl.byFields) > 0 {
 			i++
 			continue
 		}
-		ps, ok := pipes[i-1].(*pipeSort)
+		pu, ok := pipes[i-1].(*pipeUniq)
 		if !ok {
 			i++
 			continue
 		}
-		if ps.limit == 0 || pl.limit < ps.limit {
-			ps.limit = pl.limit
+		if pu.limit == 0 || pl.limit < pu.limit {
+			pu.limit = pl.limit
 		}
 		pipes = append(pipes[:i], pipes[i+1:]...)
 	}
 	return pipes
 }
 
-func optimizeUniqLimitPipes(pipes []pipe) []pipe {
-	// Merge 'uniq ... | limit ...' into 'uniq ... limit ...'
-	i := 1
-	for i < len(pipes) {
-		pl, ok := pipes[i].(*pipeLimit)
-		if !ok {
-			i++
-			continue
-		}
-		pu, ok := pipes[i-1].(*pipeUniq)
+func optimizePushDownFilterPipes(pipes []pipe) []pipe {
+	// Move `| filter ...` pipes in front of the preceding pipes, which do not change the set of rows
+	// and the fields referred by the filter. This allows merging the filter into the query filter,
+	// so non-matching blocks are skipped at the storage level.
+	for i := 1; i < len(pipes); i++ {
+		pf, ok := pipes[i].(*pipeFilter)
 		if !ok {
-			i++
 			continue
 		}
-		if pu.limit == 0 || pl.limit < pu.limit {
-			pu.limit = pl.limit
+		for j := i; j > 0 && canPushDownFilter(pipes[j-1], pf.f); j-- {
+			pipes[j-1], pipes[j] = pipes[j], pipes[j-1]
 		}
-		pipes = append(pipes[:i], pipes[i+1:]...)
 	}
 	return pipes
 }
 
+// canPushDownFilter returns true if the filter f can be applied before the pipe p without changing query results.
+func canPushDownFilter(p pipe, f filter) bool {
+	neededFields := newFieldsSet()
+	f.updateNeededFields(neededFields)
+	filterFields := neededFields.getAll()
+
+	switch t := p.(type) {
+	case *pipeFields:
+		if len(t.excludeFields) > 0 {
+			return !containsAnyField(t.excludeFields, filterFields)
+		}
+		if t.containsStar {
+			return true
+		}
+		return containsAllFields(t.fields, filterFields)
+	case *pipeDelete:
+		return !containsAnyField(t.fields, filterFields)
+	case *pipeSort:
+		return t.offset == 0 && t.limit == 0 && t.rankName == ""
+	default:
+		return false
+	}
+}
+
+// containsAnyField returns true if at least a single field from fields matches the given set of field names, which may contain wildcards.
+func containsAnyField(set, fields []string) bool {
+	fs := newFieldsSet()
+	fs.addPatterns(set)
+	for _, f := range fields {
+		if fs.contains(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAllFields returns true if all the fields match the given set of field names, which may contain wildcards.
+func containsAllFields(set, fields []string) bool {
+	fs := newFieldsSet()
+	fs.addFields(set)
+	for _, f := range fields {
+		if !fs.contains(f) {
+			return false
+		}
+	}
+	return true
+}
+
 func optimizeFilterPipes(pipes []pipe) []pipe {
 	// Merge multiple `| filter ...` pipes into a single `filter ...` pipe
 	i := 1
@@ -570,39 +855,119 @@ func mergeFiltersAnd(f1, f2 filter) filter {
 }
 
 func (q *Query) getNeededColumns() ([]string, []string) {
+	neededFields, unneededFields := getNeededFieldsForPipes(q.pipes)
+	return neededFields.getAll(), unneededFields.getAllStrict()
+}
+
+// getNeededFieldsForPipes returns needed and unneeded fields at the input of the given pipes.
+func getNeededFieldsForPipes(pipes []pipe) (*fieldsSet, *fieldsSet) {
 	neededFields := newFieldsSet()
 	neededFields.add("*")
 	unneededFields := newFieldsSet()
 
-	pipes := q.pipes
 	for i := len(pipes) - 1; i >= 0; i-- {
 		pipes[i].updateNeededFields(neededFields, unneededFields)
 	}
 
-	return neededFields.getAll(), unneededFields.getAll()
+	return neededFields, unneededFields
 }
 
 // ParseQuery parses s.
+//
+// The returned error is *ParseError if s cannot be parsed.
 func ParseQuery(s string) (*Query, error) {
+	return parseQueryAtTimestamp(s, time.Now().UnixNano())
+}
+
+// parseQueryAtTimestamp parses s in the context of the given timestamp in nanoseconds.
+//
+// The timestamp is used for relative time filters such as `_time:5m`.
+func parseQueryAtTimestamp(s string, timestamp int64) (*Query, error) {
 	lex := newLexer(s)
+	lex.currentTimestamp = timestamp
 
 	// Verify the first token doesn't match pipe names.
 	firstToken := strings.ToLower(lex.rawToken)
-	if _, ok := pipeNames[firstToken]; ok {
-		return nil, fmt.Errorf("the query [%s] cannot start with pipe - it must start with madatory filter; see https://docs.victoriametrics.com/victorialogs/logsql/#query-syntax; "+
+	if isPipeName(firstToken) {
+		err := fmt.Errorf("the query [%s] cannot start with pipe - it must start with madatory filter; see https://docs.victoriametrics.com/victorialogs/logsql/#query-syntax; "+
 			"if the filter isn't missing, then please put the first word of the filter into quotes: %q", s, firstToken)
+		return nil, newParseError(lex, err)
 	}
 
 	q, err := parseQuery(lex)
 	if err != nil {
-		return nil, err
+		return nil, newParseError(lex, err)
 	}
 	if !lex.isEnd() {
-		return nil, fmt.Errorf("unexpected unparsed tail after [%s]; context: [%s]; tail: [%s]", q, lex.context(), lex.s)
+		err := fmt.Errorf("unexpected unparsed tail after [%s]; context: [%s]; tail: [%s]", q, lex.context(), lex.s)
+		return nil, newParseError(lex, err)
 	}
 	return q, nil
 }
 
+// ParseError is returned from ParseQuery when the query cannot be parsed.
+type ParseError struct {
+	// Query is the query, which cannot be parsed.
+	Query string
+
+	// Pos is the byte offset at Query for the token where the error has been detected.
+	Pos int
+
+	// Len is the length in bytes of the token at Pos.
+	//
+	// It is zero if the error has been detected at the end of Query.
+	Len int
+
+	// Err is the underlying error.
+	Err error
+}
+
+func newParseError(lex *lexer, err error) *ParseError {
+	return &ParseError{
+		Query: lex.sOrig,
+		Pos:   lex.tokenPos(),
+		Len:   len(lex.rawToken),
+		Err:   err,
+	}
+}
+
+// Error implements error interface.
+func (pe *ParseError) Error() string {
+	return pe.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (pe *ParseError) Unwrap() error {
+	return pe.Err
+}
+
+// Snippet returns the line from pe.Query with the token at pe.Pos followed by the line with carets under this token.
+func (pe *ParseError) Snippet() string {
+	q := pe.Query
+	pos := min(max(pe.Pos, 0), len(q))
+	end := min(pos+max(pe.Len, 0), len(q))
+
+	lineStart := strings.LastIndexByte(q[:pos], '\n') + 1
+	lineEnd := len(q)
+	if n := strings.IndexByte(q[pos:], '\n'); n >= 0 {
+		lineEnd = pos + n
+	}
+	end = min(end, lineEnd)
+
+	var b strings.Builder
+	b.WriteString(q[lineStart:lineEnd])
+	b.WriteByte('\n')
+	for _, r := range q[lineStart:pos] {
+		if r == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteString(strings.Repeat("^", max(utf8.RuneCountInString(q[pos:end]), 1)))
+	return b.String()
+}
+
 func parseQuery(lex *lexer) (*Query, error) {
 	f, err := parseFilter(lex)
 	if err != nil {
@@ -620,6 +985,8 @@ func parseQuery(lex *lexer) (*Query, error) {
 		}
 		q.pipes = pipes
 	}
+	q.hasRelativeTime = lex.isCurrentTimestampUsed
+	q.timestamp = lex.currentTimestamp
 
 	return q, nil
 }
@@ -700,6 +1067,9 @@ func parseGenericFilter(lex *lexer, fieldName string) (filter, error) {
 			prefix:    "",
 		}
 		return f, nil
+	case lex.isKeyword("{") && fieldName == "":
+		// '{...}' is a shorthand for '_stream:{...}'
+		return parseFilterStream(lex)
 	case lex.isKeyword("("):
 		if !lex.isSkippedSpace && !lex.isPrevToken("", ":", "(", "!", "not") {
 			return nil, fmt.Errorf("missing whitespace before the search word %q", lex.prevToken)
@@ -1040,7 +1410,7 @@ func parseFilterIn(lex *lexer, fieldName string) (filter, error) {
 	fi, err := parseFuncArgs(lex, fieldName, func(args []string) (filter, error) {
 		fi := &filterIn{
 			fieldName: fieldName,
-			values:    args,
+			values:    deduplicateStrings(args),
 		}
 		return fi, nil
 	})
@@ -1079,6 +1449,22 @@ func parseFilterIn(lex *lexer, fieldName string) (filter, error) {
 	return fi, nil
 }
 
+// deduplicateStrings returns a without duplicate items, while preserving the original order of items.
+//
+// This reduces the number of token sets to check against bloom filters for `in(...)` filters with big lists of values.
+func deduplicateStrings(a []string) []string {
+	m := make(map[string]struct{}, len(a))
+	dst := a[:0]
+	for _, s := range a {
+		if _, ok := m[s]; ok {
+			continue
+		}
+		m[s] = struct{}{}
+		dst = append(dst, s)
+	}
+	return dst
+}
+
 func getFieldNameFromPipes(pipes []pipe) (string, error) {
 	if len(pipes) == 0 {
 		return "", fmt.Errorf("missing 'fields' or 'uniq' pipes at the end of query")
@@ -1716,6 +2102,9 @@ func parseFilterTime(lex *lexer) (*filterTime, error) {
 		sLower := strings.ToLower(s)
 		if sLower == "now" || startsWithYear(s) {
 			// Parse '_time:YYYY-MM-DD', which transforms to '_time:[YYYY-MM-DD, YYYY-MM-DD+1)'
+			if sLower == "now" {
+				lex.isCurrentTimestampUsed = true
+			}
 			nsecs, err := promutils.ParseTimeAt(s, lex.currentTimestamp)
 			if err != nil {
 				return nil, fmt.Errorf("cannot parse _time filter: %w", err)
@@ -1739,6 +2128,7 @@ func parseFilterTime(lex *lexer) (*filterTime, error) {
 		if d < 0 {
 			d = -d
 		}
+		lex.isCurrentTimestampUsed = true
 		ft := &filterTime{
 			minTimestamp: lex.currentTimestamp - int64(d),
 			maxTimestamp: lex.currentTimestamp,
@@ -1954,6 +2344,9 @@ func parseTime(lex *lexer) (int64, string, error) {
 	if err != nil {
 		return 0, "", err
 	}
+	if !startsWithYear(s) {
+		lex.isCurrentTimestampUsed = true
+	}
 	nsecs, err := promutils.ParseTimeAt(s, lex.currentTimestamp)
 	if err != nil {
 		return 0, "", err
@@ -2006,7 +2399,7 @@ func needQuoteToken(s string) bool {
 	if _, ok := reservedKeywords[sLower]; ok {
 		return true
 	}
-	if _, ok := pipeNames[sLower]; ok {
+	if isPipeName(sLower) {
 		return true
 	}
 	for _, r := range s {
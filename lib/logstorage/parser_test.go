@@ -1,10 +1,13 @@
 package logstorage
 
 import (
+	"errors"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/querytracer"
 )
 
 func TestLexer(t *testing.T) {
@@ -362,6 +365,7 @@ func TestParseFilterIn(t *testing.T) {
 	f(`foo:in(foo)`, `foo`, []string{"foo"})
 	f(`:in("foo bar,baz")`, ``, []string{"foo bar,baz"})
 	f(`ip:in(1.2.3.4, 5.6.7.8, 9.10.11.12)`, `ip`, []string{"1.2.3.4", "5.6.7.8", "9.10.11.12"})
+	f(`ip:in(1.2.3.4, 5.6.7.8, 1.2.3.4, 9.10.11.12, 5.6.7.8)`, `ip`, []string{"1.2.3.4", "5.6.7.8", "9.10.11.12"})
 	f(`foo-bar:in(foo,bar-baz.aa"bb","c,)d")`, `foo-bar`, []string{"foo", `bar-baz.aa"bb"`, "c,)d"})
 
 	// verify `in(query)` - it shouldn't set values
@@ -678,6 +682,7 @@ func TestParseQuerySuccess(t *testing.T) {
 	f(`(foo OR bar) AND baz`, `(foo or bar) baz`)
 	f(`'stats' foo`, `"stats" foo`)
 	f(`"filter" bar copy fields avg baz`, `"filter" bar "copy" "fields" "avg" baz`)
+	f(`'pack_logfmt' foo`, `"pack_logfmt" foo`)
 
 	// parens
 	f(`foo:(bar baz or not :xxx)`, `foo:bar foo:baz or !foo:xxx`)
@@ -711,6 +716,17 @@ func TestParseQuerySuccess(t *testing.T) {
 	f(`_stream:{or=a or ","="b"}`, `_stream:{"or"="a" or ","="b"}`)
 	f("_stream : { foo =  bar , }  ", `_stream:{foo="bar"}`)
 
+	// _stream filters without _stream: prefix
+	f(`{}`, `_stream:{}`)
+	f(`{app="nginx", env=~"prod|staging"}`, `_stream:{app="nginx",env=~"prod|staging"}`)
+	f(`{app="nginx"} error`, `_stream:{app="nginx"} error`)
+	f(`error or {app="nginx"}`, `error or _stream:{app="nginx"}`)
+	f(`!{app="nginx"}`, `!_stream:{app="nginx"}`)
+
+	// curly braces after field name and in quotes are treated as phrases
+	f(`foo:{bar}`, `foo:"{bar}"`)
+	f(`"{foo=bar}"`, `"{foo=bar}"`)
+
 	// _time filters
 	f(`_time:[-5m,now)`, `_time:[-5m,now)`)
 	f(`_time:(  now-1h  , now-5m34s5ms]`, `_time:(now-1h,now-5m34s5ms]`)
@@ -848,6 +864,7 @@ func TestParseQuerySuccess(t *testing.T) {
 	f(`in()`, `in()`)
 	f(`in(foo)`, `in(foo)`)
 	f(`in(foo, bar)`, `in(foo,bar)`)
+	f(`in(foo, bar, foo, "bar", baz)`, `in(foo,bar,baz)`)
 	f(`in("foo bar", baz)`, `in("foo bar",baz)`)
 	f(`foo:in(foo-bar/baz)`, `foo:in("foo-bar/baz")`)
 
@@ -983,6 +1000,8 @@ func TestParseQuerySuccess(t *testing.T) {
 	f(`* | del foo`, `* | delete foo`)
 	f(`* | rm foo`, `* | delete foo`)
 	f(`* | DELETE foo, bar`, `* | delete foo, bar`)
+	f(`* | delete kubernetes.*, foo`, `* | delete kubernetes.*, foo`)
+	f(`* | delete "foo bar"*`, `* | delete "foo bar"*`)
 
 	// limit and head pipe
 	f(`foo | limit`, `foo | limit 10`)
@@ -991,6 +1010,8 @@ func TestParseQuerySuccess(t *testing.T) {
 	f(`foo | head 20`, `foo | limit 20`)
 	f(`foo | HEAD 1_123_432`, `foo | limit 1123432`)
 	f(`foo | head 10K`, `foo | limit 10000`)
+	f(`foo | limit 5 by (x)`, `foo | limit 5 by (x)`)
+	f(`foo | head by (x, y)`, `foo | limit 10 by (x, y)`)
 
 	// multiple limit pipes
 	f(`foo | limit 100 | limit 10 | limit 234`, `foo | limit 100 | limit 10 | limit 234`)
@@ -1202,6 +1223,62 @@ func TestParseQuerySuccess(t *testing.T) {
 	f(`* | (host) count() rows, count() if (error) errors | rows:>10`, `* | stats by (host) count(*) as rows, count(*) if (error) as errors | filter rows:>10`)
 }
 
+func TestParseQueryParseError(t *testing.T) {
+	f := func(s string, posExpected, lenExpected int, snippetExpected string) {
+		t.Helper()
+
+		_, err := ParseQuery(s)
+		if err == nil {
+			t.Fatalf("expecting non-nil error for [%s]", s)
+		}
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Fatalf("expecting *ParseError for [%s]; got %T", s, err)
+		}
+		if pe.Query != s {
+			t.Fatalf("unexpected query; got %q; want %q", pe.Query, s)
+		}
+		if pe.Pos != posExpected {
+			t.Fatalf("unexpected position for [%s]; got %d; want %d", s, pe.Pos, posExpected)
+		}
+		if pe.Len != lenExpected {
+			t.Fatalf("unexpected length for [%s]; got %d; want %d", s, pe.Len, lenExpected)
+		}
+		if pe.Error() != pe.Err.Error() {
+			t.Fatalf("unexpected error message; got %q; want %q", pe.Error(), pe.Err.Error())
+		}
+		snippet := pe.Snippet()
+		if snippet != snippetExpected {
+			t.Fatalf("unexpected snippet for [%s]\ngot\n%s\nwant\n%s", s, snippet, snippetExpected)
+		}
+	}
+
+	// the query starts with pipe name
+	f(`stats count()`, 0, 5, "stats count()\n^^^^^")
+
+	// unknown pipe
+	f(`foo | bar(`, 6, 3, "foo | bar(\n      ^^^")
+
+	// unknown stats function
+	f(`foo | stats cnt(x)`, 12, 3, "foo | stats cnt(x)\n            ^^^")
+
+	// unexpected tail
+	f(`foo )`, 4, 1, "foo )\n    ^")
+
+	// quoted token
+	f(`foo | stats count() "x y" z`, 26, 1, "foo | stats count() \"x y\" z\n                          ^")
+
+	// unexpected end of query
+	f(`foo | sort by (x`, 16, 0, "foo | sort by (x\n                ^")
+
+	// multi-line query
+	f("foo\n| unpack_json\n| stats by (x) cnt(y)", 33, 3, "| stats by (x) cnt(y)\n               ^^^")
+	f("foo\n\t| stats cnt(y)", 13, 3, "\t| stats cnt(y)\n\t        ^^^")
+
+	// non-ascii chars
+	f(`привет | stats cnt(y)`, 21, 3, "привет | stats cnt(y)\n               ^^^")
+}
+
 func TestParseQueryFailure(t *testing.T) {
 	f := func(s string) {
 		t.Helper()
@@ -1229,6 +1306,8 @@ func TestParseQueryFailure(t *testing.T) {
 	f(`filter foo:bar`)
 	f(`stats count()`)
 	f(`count()`)
+	f(`pack_logfmt`)
+	f(`Block_Stats`)
 
 	// invalid parens
 	f("(")
@@ -1273,6 +1352,12 @@ func TestParseQueryFailure(t *testing.T) {
 	f("_stream:(foo)")
 	f("_stream:[foo]")
 
+	// invalid _stream filters without _stream: prefix
+	f("{")
+	f("{foo")
+	f("{foo}")
+	f("{foo=bar")
+
 	// invalid _time filters
 	f("_time:")
 	f("_time:[")
@@ -1668,7 +1753,15 @@ func TestQueryGetNeededColumns(t *testing.T) {
 	f(`* | fields *`, `*`, ``)
 	f(`* | fields * | offset 10`, `*`, ``)
 	f(`* | fields * | offset 10 | limit 20`, `*`, ``)
+	f(`* | delete foo.*, bar`, `*`, `bar,foo.*`)
+	f(`* | fields foo.a, foo.b, bar | delete foo.*`, `bar`, ``)
+	f(`* | delete foo.* | fields foo.a, bar`, `bar`, ``)
+	f(`* | rename foo.a as x | delete foo.*`, `*`, `x`)
+	f(`* | limit 5 by (x) | fields y`, `x,y`, ``)
+	f(`* | sort by (a) | limit 5 by (b) | fields c`, `_time,a,b,c`, ``)
 	f(`* | fields foo`, `foo`, ``)
+	f(`* | fields -foo, -bar.*`, `*`, `bar.*,foo`)
+	f(`* | fields -foo | fields foo, bar`, `bar`, ``)
 	f(`* | fields foo, bar`, `bar,foo`, ``)
 	f(`* | fields foo, bar | fields baz, bar`, `bar`, ``)
 	f(`* | fields foo, bar | fields baz, a`, ``, ``)
@@ -1724,12 +1817,12 @@ func TestQueryGetNeededColumns(t *testing.T) {
 	f(`* | mv f1 f2 | rm f3`, `*`, `f2,f3`)
 
 	f(`* | sort by (f1)`, `*`, ``)
-	f(`* | sort by (f1) | fields f2`, `f1,f2`, ``)
+	f(`* | sort by (f1) | fields f2`, `_time,f1,f2`, ``)
 	f(`_time:5m | sort by (_time) | fields foo`, `_time,foo`, ``)
 	f(`* | sort by (f1) | fields *`, `*`, ``)
 	f(`* | sort by (f1) | sort by (f2,f3 desc) desc`, `*`, ``)
-	f(`* | sort by (f1) | sort by (f2,f3 desc) desc | fields f4`, `f1,f2,f3,f4`, ``)
-	f(`* | sort by (f1) | sort by (f2,f3 desc) desc | fields f4 | rm f1,f2,f5`, `f1,f2,f3,f4`, ``)
+	f(`* | sort by (f1) | sort by (f2,f3 desc) desc | fields f4`, `_time,f1,f2,f3,f4`, ``)
+	f(`* | sort by (f1) | sort by (f2,f3 desc) desc | fields f4 | rm f1,f2,f5`, `_time,f1,f2,f3,f4`, ``)
 
 	f(`* | stats by(f1) count(f2) r1, count(f3,f4) r2`, `f1,f2,f3,f4`, ``)
 	f(`* | stats by(f1) count(f2) r1, count(f3,f4) r2 | fields f5,f6`, `f1`, ``)
@@ -1824,8 +1917,8 @@ func TestQueryGetNeededColumns(t *testing.T) {
 	f(`* | format "foo<f1>" as s1`, `*`, `s1`)
 	f(`* | format "foo<s1>" as s1`, `*`, ``)
 
-	f(`* | format if (x1:y) "foo" as s1`, `*`, `s1`)
-	f(`* | format if (x1:y) "foo<f1>" as s1`, `*`, `s1`)
+	f(`* | format if (x1:y) "foo" as s1`, `*`, ``)
+	f(`* | format if (x1:y) "foo<f1>" as s1`, `*`, ``)
 	f(`* | format if (s1:y) "foo<f1>" as s1`, `*`, ``)
 	f(`* | format if (x1:y) "foo<s1>" as s1`, `*`, ``)
 
@@ -1837,7 +1930,7 @@ func TestQueryGetNeededColumns(t *testing.T) {
 	f(`* | format "foo<s1>" as s1 | fields f1`, `f1`, ``)
 	f(`* | format "foo<s1>" as s1 | fields s1`, `s1`, ``)
 
-	f(`* | format if (f1:x) "foo" as s1 | fields s1`, `f1`, ``)
+	f(`* | format if (f1:x) "foo" as s1 | fields s1`, `f1,s1`, ``)
 	f(`* | format if (f1:x) "foo" as s1 | fields s2`, `s2`, ``)
 
 	f(`* | format "foo" as s1 | rm f1`, `*`, `f1,s1`)
@@ -1849,8 +1942,8 @@ func TestQueryGetNeededColumns(t *testing.T) {
 	f(`* | format "foo<s1>" as s1 | rm s1`, `*`, `s1`)
 
 	f(`* | format if (f1:x) "foo" as s1 | rm s1`, `*`, `s1`)
-	f(`* | format if (f1:x) "foo" as s1 | rm f1`, `*`, `s1`)
-	f(`* | format if (f1:x) "foo" as s1 | rm f2`, `*`, `f2,s1`)
+	f(`* | format if (f1:x) "foo" as s1 | rm f1`, `*`, ``)
+	f(`* | format if (f1:x) "foo" as s1 | rm f2`, `*`, `f2`)
 
 	f(`* | extract "<f1>x<f2>" from s1`, `*`, `f1,f2`)
 	f(`* | extract if (f3:foo) "<f1>x<f2>" from s1`, `*`, `f1,f2`)
@@ -1869,8 +1962,9 @@ func TestQueryGetNeededColumns(t *testing.T) {
 	f(`* | extract if (x:bar) "<f1>x<f2>" from s1 | rm foo,s1`, `*`, `f1,f2,foo`)
 	f(`* | extract "<f1>x<f2>" from s1 | rm foo,f1`, `*`, `f1,f2,foo`)
 	f(`* | extract if (x:bar) "<f1>x<f2>" from s1 | rm foo,f1`, `*`, `f1,f2,foo`)
-	f(`* | extract "<f1>x<f2>" from s1 | rm foo,f1,f2`, `*`, `f1,f2,foo,s1`)
-	f(`* | extract if (x:bar) "<f1>x<f2>" from s1 | rm foo,f1,f2`, `*`, `f1,f2,foo,s1`)
+	f(`* | extract "<f1>x<f2>" from s1 | rm foo,f1,f2`, `*`, `f1,f2,foo`)
+	f(`* | extract if (x:bar) "<f1>x<f2>" from s1 | rm foo,f1,f2`, `*`, `f1,f2,foo`)
+	f(`* | extract_regexp "(?P<f1>.*)x(?P<f2>.*)" from s1 | rm foo,f1,f2`, `*`, `f1,f2,foo`)
 
 	f(`* | extract "x<s1>y" from s1 `, `*`, ``)
 	f(`* | extract if (x:foo) "x<s1>y" from s1`, `*`, ``)
@@ -1965,6 +2059,44 @@ func TestQueryGetNeededColumns(t *testing.T) {
 	f(`* | unroll if (q:w p:a) (a, b) | count() r1`, `a,b,p,q`, ``)
 }
 
+func TestQueryGetQueryPlan(t *testing.T) {
+	f := func(s, filterExpected, pipesExpected, neededFieldsExpected, unneededFieldsExpected string) {
+		t.Helper()
+
+		q, err := ParseQuery(s)
+		if err != nil {
+			t.Fatalf("cannot parse query [%s]: %s", s, err)
+		}
+		q.Optimize()
+
+		qp := q.GetQueryPlan()
+		if qp.Filter != filterExpected {
+			t.Fatalf("unexpected filter for [%s]; got %q; want %q", s, qp.Filter, filterExpected)
+		}
+		pipes := strings.Join(qp.Pipes, " | ")
+		if pipes != pipesExpected {
+			t.Fatalf("unexpected pipes for [%s]; got %q; want %q", s, pipes, pipesExpected)
+		}
+		neededFields := strings.Join(qp.NeededFields, ",")
+		if neededFields != neededFieldsExpected {
+			t.Fatalf("unexpected needed fields for [%s]; got %q; want %q", s, neededFields, neededFieldsExpected)
+		}
+		unneededFields := strings.Join(qp.UnneededFields, ",")
+		if unneededFields != unneededFieldsExpected {
+			t.Fatalf("unexpected unneeded fields for [%s]; got %q; want %q", s, unneededFields, unneededFieldsExpected)
+		}
+	}
+
+	f(`*`, `*`, ``, `*`, ``)
+	f(`foo bar`, `foo bar`, ``, `*`, ``)
+	f(`foo | delete bar, baz`, `foo`, `delete bar, baz`, `*`, `bar,baz`)
+	f(`foo | fields a, b | count() x`, `foo`, `fields a, b | stats count(*) as x`, ``, ``)
+	f(`foo | stats by (a) count(b) x`, `foo`, `stats by (a) count(b) as x`, `a,b`, ``)
+
+	// filter pipes are merged into the query filter during optimization
+	f(`foo | filter bar | fields a`, `foo bar`, `fields a`, `a`, ``)
+}
+
 func TestQueryClone(t *testing.T) {
 	f := func(qStr string) {
 		t.Helper()
@@ -1986,6 +2118,35 @@ func TestQueryClone(t *testing.T) {
 	f("ip:in(foo | fields user_ip) bar | stats by (x:1h, y) count(*) if (user_id:in(q:w | fields abc)) as ccc")
 }
 
+func TestQueryCloneWithPipes(t *testing.T) {
+	q, err := ParseQuery("_time:5m error | fields foo")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	q.SetMaxMemory(1000)
+	q.SetMaxScannedRows(2000)
+	q.dedupReplicas = true
+	q.qt = querytracer.New(true, "test")
+
+	pipes := append([]pipe{}, q.pipes...)
+	pipes = append(pipes, &pipeLimit{
+		limit: 10,
+	})
+	qNew := q.cloneWithPipes(pipes)
+	if s, sExpected := qNew.String(), "_time:5m error | fields foo | limit 10"; s != sExpected {
+		t.Fatalf("unexpected query; got %s; want %s", s, sExpected)
+	}
+	if s, sExpected := q.String(), "_time:5m error | fields foo"; s != sExpected {
+		t.Fatalf("the original query mustn't change; got %s; want %s", s, sExpected)
+	}
+	if qNew.maxMemory != q.maxMemory || qNew.maxScannedRows != q.maxScannedRows || qNew.dedupReplicas != q.dedupReplicas {
+		t.Fatalf("the query limits must be preserved")
+	}
+	if !qNew.hasRelativeTime || qNew.timestamp != q.timestamp || qNew.qt != q.qt {
+		t.Fatalf("hasRelativeTime, timestamp and qt must be preserved")
+	}
+}
+
 func TestQueryGetFilterTimeRange(t *testing.T) {
 	f := func(qStr string, startExpected, endExpected int64) {
 		t.Helper()
@@ -2004,6 +2165,40 @@ func TestQueryGetFilterTimeRange(t *testing.T) {
 	f("_time:2024-05-31T10:20:30.456789123Z", 1717150830456789123, 1717150830456789123)
 	f("_time:2024-05-31", 1717113600000000000, 1717199999999999999)
 	f("_time:2024-05-31 _time:day_range[08:00, 16:00]", 1717113600000000000, 1717199999999999999)
+
+	// time filters at 'or' branches
+	f("(_time:2024-05-31 error) or (_time:2024-06-01 warn)", 1717113600000000000, 1717286399999999999)
+	f("_time:2024-05-31 or error", -9223372036854775808, 9223372036854775807)
+	f("(_time:2024-05-31 _time:2024-06-01) or _time:2024-05-30", 1717027200000000000, 1717113599999999999)
+
+	// nested time filters
+	f("foo (_time:2024-05-30 or _time:2024-05-31) (bar or _time:2024-05-31)", 1717027200000000000, 1717199999999999999)
+	f("_time:2024-05-31 (_time:2024-05-30 or _time:2024-06-01)", 1717113600000000000, 1717199999999999999)
+
+	// time filters under 'not' cannot narrow down the time range
+	f("!_time:2024-05-31", -9223372036854775808, 9223372036854775807)
+}
+
+func TestParseQueryAtTimestamp(t *testing.T) {
+	f := func(qStr string, timestamp, startExpected, endExpected int64) {
+		t.Helper()
+
+		q, err := parseQueryAtTimestamp(qStr, timestamp)
+		if err != nil {
+			t.Fatalf("cannot parse [%s]: %s", qStr, err)
+		}
+		start, end := q.GetFilterTimeRange()
+		if start != startExpected || end != endExpected {
+			t.Fatalf("unexpected filter time range; got [%d, %d]; want [%d, %d]", start, end, startExpected, endExpected)
+		}
+	}
+
+	// relative time filters are applied relative to the given timestamp
+	f("_time:1h", 1717150830456789123, 1717147230456789123, 1717150830456789123)
+	f("_time:1h offset 1h", 1717150830456789123, 1717143630456789123, 1717147230456789123)
+
+	// absolute time filters do not depend on the given timestamp
+	f("_time:2024-05-31", 1717150830456789123, 1717113600000000000, 1717199999999999999)
 }
 
 func TestQueryCanReturnLastNResults(t *testing.T) {
@@ -2035,6 +2230,118 @@ func TestQueryCanReturnLastNResults(t *testing.T) {
 
 }
 
+func TestQueryGetStatsByFieldsSuccess(t *testing.T) {
+	f := func(qStr string, fieldsExpected []string) {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse [%s]: %s", qStr, err)
+		}
+		fields, err := q.GetStatsByFields()
+		if err != nil {
+			t.Fatalf("unexpected error in GetStatsByFields() for [%s]: %s", qStr, err)
+		}
+		if !reflect.DeepEqual(fields, fieldsExpected) {
+			t.Fatalf("unexpected byFields for [%s]; got %q; want %q", qStr, fields, fieldsExpected)
+		}
+	}
+
+	f("* | stats count()", []string{})
+	f("error | stats by (_time:1m, service) count() hits", []string{"_time", "service"})
+	f("* | fields level | stats by (level) count() hits, count_uniq(user_id) users", []string{"level"})
+}
+
+func TestQueryGetStatsByFieldsFailure(t *testing.T) {
+	f := func(qStr string) {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse [%s]: %s", qStr, err)
+		}
+		fields, err := q.GetStatsByFields()
+		if err == nil {
+			t.Fatalf("expecting non-nil error for [%s]; got fields %q", qStr, fields)
+		}
+	}
+
+	f("*")
+	f("* | fields foo")
+	f("* | stats by (level) count() hits | sort by (hits)")
+}
+
+func TestQueryGetFieldsPipeFieldsSuccess(t *testing.T) {
+	f := func(qStr string, fieldsExpected []string) {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse [%s]: %s", qStr, err)
+		}
+		fields, err := q.GetFieldsPipeFields()
+		if err != nil {
+			t.Fatalf("unexpected error in GetFieldsPipeFields() for [%s]: %s", qStr, err)
+		}
+		if !reflect.DeepEqual(fields, fieldsExpected) {
+			t.Fatalf("unexpected fields for [%s]; got %q; want %q", qStr, fields, fieldsExpected)
+		}
+	}
+
+	f("* | fields _time, host, _msg", []string{"_time", "host", "_msg"})
+	f("* | keep foo", []string{"foo"})
+	f("* | fields foo, bar | sort by (bar) | offset 10 | limit 5", []string{"foo", "bar"})
+	f("* | stats by (host) count() hits | fields hits, host", []string{"hits", "host"})
+}
+
+func TestQueryGetFieldsPipeFieldsFailure(t *testing.T) {
+	f := func(qStr string) {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse [%s]: %s", qStr, err)
+		}
+		fields, err := q.GetFieldsPipeFields()
+		if err == nil {
+			t.Fatalf("expecting non-nil error for [%s]; got fields %q", qStr, fields)
+		}
+	}
+
+	f("*")
+	f("* | fields *")
+	f("* | fields -foo")
+	f("* | fields foo | copy foo bar")
+	f("* | fields foo | sort by (foo) rank as r")
+	f("* | stats count()")
+}
+
+func TestQueryAddFacetsPipe(t *testing.T) {
+	f := func(qStr string, fields []string, limit, maxValuesPerField, maxValueLen uint64, keepConstFields bool, resultExpected string) {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse [%s]: %s", qStr, err)
+		}
+		q.AddFacetsPipe(fields, limit, maxValuesPerField, maxValueLen, keepConstFields)
+		result := q.String()
+		if result != resultExpected {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	// default values
+	f("error", nil, 0, 0, 0, false, "error | facets")
+	f("error", []string{"*"}, 0, 0, 0, false, "error | facets")
+
+	// non-default values
+	f("error | unpack_json", nil, 5, 100, 20, true, "error | unpack_json | facets 5 max_values_per_field 100 max_value_len 20 keep_const_fields")
+
+	// selected fields
+	f("error", []string{"host", "level"}, 3, 0, 0, false, "error | fields host, level | facets 3")
+}
+
 func TestQueryCanLiveTail(t *testing.T) {
 	f := func(qStr string, resultExpected bool) {
 		t.Helper()
@@ -2079,6 +2386,94 @@ func TestQueryCanLiveTail(t *testing.T) {
 	f("* | unroll by (a)", true)
 }
 
+func TestQueryOptimizeSortOffsetLimit(t *testing.T) {
+	f := func(qStr, resultExpected string) {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse [%s]: %s", qStr, err)
+		}
+		q.Optimize()
+		result := q.String()
+		if result != resultExpected {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	f(`* | sort by (x) | offset 10`, `* | sort by (x) offset 10`)
+	f(`* | sort by (x) | limit 10`, `* | sort by (x) limit 10`)
+	f(`* | sort by (x) | offset 10 | limit 5`, `* | sort by (x) offset 10 limit 5`)
+	f(`* | sort by (x) offset 3 | offset 10 | limit 5`, `* | sort by (x) offset 13 limit 5`)
+	f(`* | sort by (x) | limit 10 | offset 3`, `* | sort by (x) offset 3 limit 7`)
+	f(`* | sort by (x) | offset 1 | limit 10 | offset 2 | limit 3`, `* | sort by (x) offset 3 limit 3`)
+	f(`* | sort by (x) limit 5 | limit 10`, `* | sort by (x) limit 5`)
+
+	// 'head' and 'skip' are aliases for 'limit' and 'offset'
+	f(`* | sort | head 10`, `* | sort limit 10`)
+	f(`* | sort by (x) desc | skip 3 | head 5`, `* | sort by (x) desc offset 3 limit 5`)
+
+	// The offset exceeds the limit, so it cannot be merged
+	f(`* | sort by (x) limit 5 | offset 5`, `* | sort by (x) limit 5 | offset 5`)
+
+	// Zero limit means no limit at sort pipe, so it cannot be merged
+	f(`* | sort by (x) | limit 0`, `* | sort by (x) | limit 0`)
+
+	// limit by (...) cannot be merged
+	f(`* | sort by (x) | limit 5 by (y)`, `* | sort by (x) | limit 5 by (y)`)
+}
+
+func TestQueryOptimizePushDownFilter(t *testing.T) {
+	f := func(qStr, resultExpected string) {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse [%s]: %s", qStr, err)
+		}
+		q.Optimize()
+		result := q.String()
+		if result != resultExpected {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	// The filter is moved in front of fields, delete and sort pipes, which keep the fields referred by the filter
+	f(`foo | fields x, y | filter x:bar`, `foo x:bar | fields x, y`)
+	f(`foo | delete y* | filter x:bar`, `foo x:bar | delete y*`)
+	f(`foo | fields * | filter x:bar`, `foo x:bar | fields *`)
+	f(`foo | fields -y | filter x:bar`, `foo x:bar | fields -y`)
+	f(`foo | delete y | filter x:bar`, `foo x:bar | delete y`)
+	f(`foo | fields _msg | filter baz`, `foo baz | fields _msg`)
+	f(`foo | sort by (x) | filter y:bar`, `foo y:bar | sort by (x)`)
+	f(`foo | sort by (x) | delete z | fields x, y | filter y:bar | filter x:baz`, `foo y:bar x:baz | sort by (x) | delete z | fields x, y`)
+	f(`foo | fields _time, x | filter _time:5m`, `foo _time:5m | fields _time, x`)
+
+	// The filter refers fields, which are dropped by the preceding pipe
+	f(`foo | fields x | filter y:""`, `foo | fields x | filter y:""`)
+	f(`foo | fields -y | filter y:bar`, `foo | fields -y | filter y:bar`)
+	f(`foo | delete y | filter y:""`, `foo | delete y | filter y:""`)
+	f(`foo | delete y* | filter yz:""`, `foo | delete y* | filter yz:""`)
+
+	// The fields pipe treats field names literally, so `x*` doesn't match xyz
+	f(`foo | fields x* | filter xyz:bar`, `foo | fields x* | filter xyz:bar`)
+	f(`foo | fields x | filter _time:5m`, `foo | fields x | filter _time:5m`)
+
+	// The preceding pipe changes the set of rows
+	f(`foo | sort by (x) limit 10 | filter y:bar`, `foo | sort by (x) limit 10 | filter y:bar`)
+	f(`foo | sort by (x) | limit 10 | filter y:bar`, `foo | sort by (x) limit 10 | filter y:bar`)
+	f(`foo | sort by (x) offset 10 | filter y:bar`, `foo | sort by (x) offset 10 | filter y:bar`)
+	f(`foo | limit 10 | filter y:bar`, `foo | limit 10 | filter y:bar`)
+	f(`foo | stats count() rows | filter rows:>10`, `foo | stats count(*) as rows | filter rows:>10`)
+
+	// The preceding pipe modifies the fields referred by the filter
+	f(`foo | copy a b | filter b:bar`, `foo | copy a as b | filter b:bar`)
+	f(`foo | sort by (x) rank r | filter r:<10`, `foo | sort by (x) rank as r | filter r:<10`)
+
+	// The filter is pushed down only to the first pipe, which cannot be skipped
+	f(`foo | copy a b | fields a, b | filter b:bar`, `foo | copy a as b | filter b:bar | fields a, b`)
+}
+
 func TestQueryDropAllPipes(t *testing.T) {
 	f := func(qStr, resultExpected string) {
 		t.Helper()
@@ -2100,3 +2495,29 @@ func TestQueryDropAllPipes(t *testing.T) {
 	f(`foo or bar and baz | top 5 by (x)`, `foo or bar baz`)
 	f(`foo | filter bar:baz | stats by (x) min(y)`, `foo bar:baz`)
 }
+
+func TestQueryHasRelativeTime(t *testing.T) {
+	f := func(qStr string, resultExpected bool) {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing [%s]: %s", qStr, err)
+		}
+		if q.hasRelativeTime != resultExpected {
+			t.Fatalf("unexpected hasRelativeTime for [%s]; got %v; want %v", qStr, q.hasRelativeTime, resultExpected)
+		}
+	}
+
+	f(`*`, false)
+	f(`foo | stats by (_time:1h) count()`, false)
+	f(`_time:2024-05-01`, false)
+	f(`_time:[2024-05-01, 2024-05-02) offset 1h`, false)
+
+	f(`_time:5m`, true)
+	f(`_time:now`, true)
+	f(`_time:[2024-05-01, now)`, true)
+	f(`foo | filter _time:1h`, true)
+	f(`foo | stats count() if (_time:1h) hits`, true)
+	f(`foo:in(_time:1h | fields foo)`, true)
+}
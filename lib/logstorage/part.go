@@ -2,9 +2,12 @@ package logstorage
 
 import (
 	"path/filepath"
+	"sync"
 
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/filestream"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 )
 
 type part struct {
@@ -22,6 +25,33 @@ type part struct {
 	// indexBlockHeaders contains a list of indexBlockHeader entries for the given part.
 	indexBlockHeaders []indexBlockHeader
 
+	// zstdDict is ZSTD dictionary used for compressing string values in the part.
+	//
+	// It is nil if the part doesn't use ZSTD dictionary.
+	zstdDict *zstd.Dict
+
+	// tenantStatsOnce is used for lazy initialization of tenantStats.
+	tenantStatsOnce sync.Once
+
+	// tenantStats contains per-tenant stats for the part. It is initialized by getTenantStats().
+	tenantStats []partTenantStats
+
+	// fieldsIndexData contains the compressed fields index for the part. It is unpacked into fieldsIndex by getFieldsIndex().
+	//
+	// It is nil if the part has no fields index. See part_fields_index.go for details.
+	fieldsIndexData []byte
+
+	// fieldsIndexOnce is used for lazy initialization of fieldsIndex.
+	fieldsIndexOnce sync.Once
+
+	// fieldsIndex contains field names and low-cardinality field values for the part. It is initialized by getFieldsIndex().
+	fieldsIndex *partFieldsIndex
+
+	// objectStorageFiles contains data files for the part stored at object storage.
+	//
+	// It is nil if the part is stored locally. See object_storage.go for details.
+	objectStorageFiles []objectStorageFile
+
 	indexFile              fs.MustReadAtCloser
 	columnsHeaderFile      fs.MustReadAtCloser
 	timestampsFile         fs.MustReadAtCloser
@@ -36,6 +66,7 @@ func mustOpenInmemoryPart(pt *partition, mp *inmemoryPart) *part {
 	p.pt = pt
 	p.path = ""
 	p.ph = mp.ph
+	p.zstdDict = pt.mustGetZSTDDictForPart(&p.ph, "inmemory part")
 
 	// Read metaindex
 	metaindexReader := mp.metaindex.NewReader()
@@ -43,6 +74,8 @@ func mustOpenInmemoryPart(pt *partition, mp *inmemoryPart) *part {
 	mrs.init(metaindexReader)
 	p.indexBlockHeaders = mustReadIndexBlockHeaders(p.indexBlockHeaders[:0], &mrs)
 
+	p.fieldsIndexData = mp.fieldsIndex.B
+
 	// Open data files
 	p.indexFile = &mp.index
 	p.columnsHeaderFile = &mp.columnsHeader
@@ -60,6 +93,7 @@ func mustOpenFilePart(pt *partition, path string) *part {
 	p.pt = pt
 	p.path = path
 	p.ph.mustReadMetadata(path)
+	p.zstdDict = pt.mustGetZSTDDictForPart(&p.ph, path)
 
 	metaindexPath := filepath.Join(path, metaindexFilename)
 	indexPath := filepath.Join(path, indexFilename)
@@ -77,6 +111,26 @@ func mustOpenFilePart(pt *partition, path string) *part {
 	p.indexBlockHeaders = mustReadIndexBlockHeaders(p.indexBlockHeaders[:0], &mrs)
 	mrs.MustClose()
 
+	// The fields index is kept locally for parts stored at object storage, since it is read only when the part is opened.
+	p.fieldsIndexData = mustReadFieldsIndexData(path)
+
+	if osfs := mustReadObjectStorageFiles(path); osfs != nil {
+		// Open data files stored at object storage
+		obs := pt.s.objectStorage
+		if obs == nil {
+			logger.Panicf("FATAL: the part %q is stored at object storage, but object storage isn't configured", path)
+		}
+		p.objectStorageFiles = osfs
+		p.indexFile = obs.newReaderAt(getObjectStorageFile(osfs, path, indexFilename))
+		p.columnsHeaderFile = obs.newReaderAt(getObjectStorageFile(osfs, path, columnsHeaderFilename))
+		p.timestampsFile = obs.newReaderAt(getObjectStorageFile(osfs, path, timestampsFilename))
+		p.fieldValuesFile = obs.newReaderAt(getObjectStorageFile(osfs, path, fieldValuesFilename))
+		p.fieldBloomFilterFile = obs.newReaderAt(getObjectStorageFile(osfs, path, fieldBloomFilename))
+		p.messageValuesFile = obs.newReaderAt(getObjectStorageFile(osfs, path, messageValuesFilename))
+		p.messageBloomFilterFile = obs.newReaderAt(getObjectStorageFile(osfs, path, messageBloomFilename))
+		return &p
+	}
+
 	// Open data files
 	p.indexFile = fs.MustOpenReaderAt(indexPath)
 	p.columnsHeaderFile = fs.MustOpenReaderAt(columnsHeaderPath)
@@ -98,5 +152,9 @@ func mustClosePart(p *part) {
 	p.messageValuesFile.MustClose()
 	p.messageBloomFilterFile.MustClose()
 
+	p.objectStorageFiles = nil
+	p.fieldsIndexData = nil
+	p.fieldsIndex = nil
+	p.zstdDict = nil
 	p.pt = nil
 }
@@ -0,0 +1,565 @@
+package logstorage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// maxPartFieldsIndexValues is the maximum number of unique values per field and tenant, which are stored in partFieldsIndex.
+//
+// Values for fields with bigger number of unique values aren't stored in the index, since such fields aren't low-cardinality.
+const maxPartFieldsIndexValues = 100
+
+// partFieldsIndex contains field names and low-cardinality field values for every tenant in a part.
+//
+// It is built when the part is created, e.g. when the ingested logs are converted to a part or when parts are merged.
+// It is stored in fieldsIndexFilename and it is used for obtaining the results for field_names, field_values and uniq pipes
+// over the parts fully covered by the query time range without reading the part blocks.
+type partFieldsIndex struct {
+	// tenants contains per-tenant entries sorted by tenantID.
+	tenants []partFieldsIndexTenant
+}
+
+// partFieldsIndexTenant contains fields index for a single tenant in a part.
+type partFieldsIndexTenant struct {
+	tenantID TenantID
+
+	// rowsCount is the number of log entries for the tenant in the part.
+	rowsCount uint64
+
+	// streams contains the number of log entries per every log stream of the tenant sorted by stream id.
+	streams []partFieldsIndexStream
+
+	// fields contains the fields seen in the tenant logs sorted by name.
+	fields []partFieldsIndexField
+}
+
+type partFieldsIndexStream struct {
+	// id is the stream id inside the tenant. See streamID.
+	id u128
+
+	// rowsCount is the number of log entries for the stream in the part.
+	rowsCount uint64
+}
+
+type partFieldsIndexField struct {
+	name string
+
+	// rowsCount is the number of log entries in the blocks with the given field.
+	//
+	// This is the same as the number of hits returned by the field_names pipe for the blocks.
+	rowsCount uint64
+
+	// hasAllValues is set to true if values contain all the values for the field.
+	//
+	// This is the case if the field has the same value for all the log entries in every block and the number
+	// of unique values doesn't exceed maxPartFieldsIndexValues. For example, log stream fields usually have all the values.
+	hasAllValues bool
+
+	// values contains field values with the number of log entries per each value sorted by value.
+	//
+	// values are empty if hasAllValues isn't set.
+	values []partFieldsIndexValue
+}
+
+type partFieldsIndexValue struct {
+	value string
+
+	// rowsCount is the number of log entries with the given value.
+	rowsCount uint64
+}
+
+func (pfi *partFieldsIndex) reset() {
+	clear(pfi.tenants)
+	pfi.tenants = pfi.tenants[:0]
+}
+
+// appendTenants appends entries for the given tenantIDs to dst and returns the result.
+//
+// tenantIDs without logs in the part are skipped.
+func (pfi *partFieldsIndex) appendTenants(dst []*partFieldsIndexTenant, tenantIDs []TenantID) []*partFieldsIndexTenant {
+	tenants := pfi.tenants
+	for i := range tenantIDs {
+		tenantID := &tenantIDs[i]
+		n := sort.Search(len(tenants), func(j int) bool {
+			return !tenants[j].tenantID.less(tenantID)
+		})
+		if n < len(tenants) && tenants[n].tenantID.equal(tenantID) {
+			dst = append(dst, &tenants[n])
+		}
+	}
+	return dst
+}
+
+// marshal appends marshaled pfi to dst and returns the result.
+func (pfi *partFieldsIndex) marshal(dst []byte) []byte {
+	dst = encoding.MarshalVarUint64(dst, uint64(len(pfi.tenants)))
+	for i := range pfi.tenants {
+		dst = pfi.tenants[i].marshal(dst)
+	}
+	return dst
+}
+
+// unmarshal unmarshals pfi from src.
+func (pfi *partFieldsIndex) unmarshal(src []byte) error {
+	pfi.reset()
+
+	n, nSize := encoding.UnmarshalVarUint64(src)
+	if nSize <= 0 {
+		return fmt.Errorf("cannot unmarshal tenants count")
+	}
+	src = src[nSize:]
+
+	for i := uint64(0); i < n; i++ {
+		pfi.tenants = append(pfi.tenants, partFieldsIndexTenant{})
+		tail, err := pfi.tenants[len(pfi.tenants)-1].unmarshal(src)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal tenant %d out of %d tenants: %w", i, n, err)
+		}
+		src = tail
+	}
+	if len(src) > 0 {
+		return fmt.Errorf("unexpected non-empty tail left after unmarshaling %d tenants; len(tail)=%d", n, len(src))
+	}
+	return nil
+}
+
+// getField returns the field with the given name for pft.
+//
+// nil is returned if pft has no the given field.
+func (pft *partFieldsIndexTenant) getField(name string) *partFieldsIndexField {
+	if isMsgFieldName(name) {
+		name = "_msg"
+	}
+	fields := pft.fields
+	n := sort.Search(len(fields), func(i int) bool {
+		return fields[i].name >= name
+	})
+	if n < len(fields) && fields[n].name == name {
+		return &fields[n]
+	}
+	return nil
+}
+
+func (pft *partFieldsIndexTenant) marshal(dst []byte) []byte {
+	dst = pft.tenantID.marshal(dst)
+	dst = encoding.MarshalVarUint64(dst, pft.rowsCount)
+
+	dst = encoding.MarshalVarUint64(dst, uint64(len(pft.streams)))
+	for i := range pft.streams {
+		st := &pft.streams[i]
+		dst = st.id.marshal(dst)
+		dst = encoding.MarshalVarUint64(dst, st.rowsCount)
+	}
+
+	dst = encoding.MarshalVarUint64(dst, uint64(len(pft.fields)))
+	for i := range pft.fields {
+		f := &pft.fields[i]
+		dst = encoding.MarshalBytes(dst, []byte(f.name))
+		dst = encoding.MarshalVarUint64(dst, f.rowsCount)
+		dst = encoding.MarshalBool(dst, f.hasAllValues)
+		if !f.hasAllValues {
+			continue
+		}
+		dst = encoding.MarshalVarUint64(dst, uint64(len(f.values)))
+		for j := range f.values {
+			v := &f.values[j]
+			dst = encoding.MarshalBytes(dst, []byte(v.value))
+			dst = encoding.MarshalVarUint64(dst, v.rowsCount)
+		}
+	}
+
+	return dst
+}
+
+func (pft *partFieldsIndexTenant) unmarshal(src []byte) ([]byte, error) {
+	tail, err := pft.tenantID.unmarshal(src)
+	if err != nil {
+		return src, fmt.Errorf("cannot unmarshal tenantID: %w", err)
+	}
+	src = tail
+
+	rowsCount, nSize := encoding.UnmarshalVarUint64(src)
+	if nSize <= 0 {
+		return src, fmt.Errorf("cannot unmarshal rowsCount")
+	}
+	src = src[nSize:]
+	pft.rowsCount = rowsCount
+
+	// unmarshal streams
+	n, nSize := encoding.UnmarshalVarUint64(src)
+	if nSize <= 0 {
+		return src, fmt.Errorf("cannot unmarshal streams count")
+	}
+	src = src[nSize:]
+	for i := uint64(0); i < n; i++ {
+		var st partFieldsIndexStream
+		tail, err := st.id.unmarshal(src)
+		if err != nil {
+			return src, fmt.Errorf("cannot unmarshal stream id: %w", err)
+		}
+		src = tail
+
+		rowsCount, nSize := encoding.UnmarshalVarUint64(src)
+		if nSize <= 0 {
+			return src, fmt.Errorf("cannot unmarshal rowsCount for the stream %s", &st.id)
+		}
+		src = src[nSize:]
+		st.rowsCount = rowsCount
+
+		pft.streams = append(pft.streams, st)
+	}
+
+	// unmarshal fields
+	n, nSize = encoding.UnmarshalVarUint64(src)
+	if nSize <= 0 {
+		return src, fmt.Errorf("cannot unmarshal fields count")
+	}
+	src = src[nSize:]
+	for i := uint64(0); i < n; i++ {
+		tail, err := pft.unmarshalField(src)
+		if err != nil {
+			return src, fmt.Errorf("cannot unmarshal field %d out of %d fields: %w", i, n, err)
+		}
+		src = tail
+	}
+
+	return src, nil
+}
+
+func (pft *partFieldsIndexTenant) unmarshalField(src []byte) ([]byte, error) {
+	var f partFieldsIndexField
+
+	name, nSize := encoding.UnmarshalBytes(src)
+	if nSize <= 0 {
+		return src, fmt.Errorf("cannot unmarshal field name")
+	}
+	src = src[nSize:]
+	f.name = string(name)
+
+	rowsCount, nSize := encoding.UnmarshalVarUint64(src)
+	if nSize <= 0 {
+		return src, fmt.Errorf("cannot unmarshal rowsCount for the field %q", f.name)
+	}
+	src = src[nSize:]
+	f.rowsCount = rowsCount
+
+	if len(src) < 1 {
+		return src, fmt.Errorf("cannot unmarshal hasAllValues for the field %q", f.name)
+	}
+	f.hasAllValues = encoding.UnmarshalBool(src)
+	src = src[1:]
+
+	if f.hasAllValues {
+		n, nSize := encoding.UnmarshalVarUint64(src)
+		if nSize <= 0 {
+			return src, fmt.Errorf("cannot unmarshal values count for the field %q", f.name)
+		}
+		src = src[nSize:]
+		if n > maxPartFieldsIndexValues {
+			return src, fmt.Errorf("too many values for the field %q: %d; mustn't exceed %d", f.name, n, maxPartFieldsIndexValues)
+		}
+		for i := uint64(0); i < n; i++ {
+			value, nSize := encoding.UnmarshalBytes(src)
+			if nSize <= 0 {
+				return src, fmt.Errorf("cannot unmarshal value for the field %q", f.name)
+			}
+			src = src[nSize:]
+
+			rowsCount, nSize := encoding.UnmarshalVarUint64(src)
+			if nSize <= 0 {
+				return src, fmt.Errorf("cannot unmarshal rowsCount for the value %q of the field %q", value, f.name)
+			}
+			src = src[nSize:]
+
+			f.values = append(f.values, partFieldsIndexValue{
+				value:     string(value),
+				rowsCount: rowsCount,
+			})
+		}
+	}
+
+	pft.fields = append(pft.fields, f)
+	return src, nil
+}
+
+// partFieldsIndexBuilder builds partFieldsIndex from the blocks written to a part.
+//
+// The blocks must be added in the order of their streamID.
+type partFieldsIndexBuilder struct {
+	// pfi contains the index for the tenants seen so far. The fields for the last tenant are stored in fields.
+	pfi partFieldsIndex
+
+	// fields contains the fields for the last tenant at pfi.
+	fields map[string]*partFieldsIndexFieldState
+
+	// blockRowsCount is the number of log entries in the last added block.
+	blockRowsCount uint64
+}
+
+type partFieldsIndexFieldState struct {
+	rowsCount    uint64
+	hasAllValues bool
+	values       map[string]*uint64
+}
+
+func (pfib *partFieldsIndexBuilder) reset() {
+	pfib.pfi.reset()
+	clear(pfib.fields)
+	pfib.blockRowsCount = 0
+}
+
+// addBlock registers the block with rowsCount log entries for the given sid.
+//
+// The block columns must be registered via addColumn and addConstColumn after that.
+func (pfib *partFieldsIndexBuilder) addBlock(sid *streamID, rowsCount uint64) {
+	tenants := pfib.pfi.tenants
+	if len(tenants) == 0 || !tenants[len(tenants)-1].tenantID.equal(&sid.tenantID) {
+		pfib.flushFields()
+		pfib.pfi.tenants = append(tenants, partFieldsIndexTenant{
+			tenantID: sid.tenantID,
+		})
+	}
+	pft := &pfib.pfi.tenants[len(pfib.pfi.tenants)-1]
+	pft.rowsCount += rowsCount
+
+	streams := pft.streams
+	if len(streams) == 0 || !streams[len(streams)-1].id.equal(&sid.id) {
+		pft.streams = append(streams, partFieldsIndexStream{
+			id: sid.id,
+		})
+	}
+	pft.streams[len(pft.streams)-1].rowsCount += rowsCount
+
+	pfib.blockRowsCount = rowsCount
+}
+
+// addColumn registers the column with the given name, which has distinct values across the last added block.
+func (pfib *partFieldsIndexBuilder) addColumn(name string) {
+	fst := pfib.getFieldState(name)
+	fst.rowsCount += pfib.blockRowsCount
+	fst.hasAllValues = false
+	fst.values = nil
+}
+
+// addConstColumn registers the column with the given name, which has the same value across the last added block.
+func (pfib *partFieldsIndexBuilder) addConstColumn(name, value string) {
+	if value == "" {
+		// Log fields with empty values are equivalent to missing fields.
+		return
+	}
+
+	fst := pfib.getFieldState(name)
+	fst.rowsCount += pfib.blockRowsCount
+	if !fst.hasAllValues {
+		return
+	}
+	pRowsCount, ok := fst.values[value]
+	if !ok {
+		if len(fst.values) >= maxPartFieldsIndexValues {
+			fst.hasAllValues = false
+			fst.values = nil
+			return
+		}
+		rowsCount := uint64(0)
+		pRowsCount = &rowsCount
+		fst.values[strings.Clone(value)] = pRowsCount
+	}
+	*pRowsCount += pfib.blockRowsCount
+}
+
+func (pfib *partFieldsIndexBuilder) getFieldState(name string) *partFieldsIndexFieldState {
+	if isMsgFieldName(name) {
+		name = "_msg"
+	}
+	if pfib.fields == nil {
+		pfib.fields = make(map[string]*partFieldsIndexFieldState)
+	}
+	fst := pfib.fields[name]
+	if fst == nil {
+		fst = &partFieldsIndexFieldState{
+			hasAllValues: true,
+			values:       make(map[string]*uint64),
+		}
+		pfib.fields[strings.Clone(name)] = fst
+	}
+	return fst
+}
+
+// flushFields moves the fields for the last tenant to pfib.pfi.
+func (pfib *partFieldsIndexBuilder) flushFields() {
+	tenants := pfib.pfi.tenants
+	if len(tenants) == 0 {
+		return
+	}
+	pft := &tenants[len(tenants)-1]
+
+	fields := make([]partFieldsIndexField, 0, len(pfib.fields))
+	for name, fst := range pfib.fields {
+		f := partFieldsIndexField{
+			name:         name,
+			rowsCount:    fst.rowsCount,
+			hasAllValues: fst.hasAllValues,
+		}
+		if fst.hasAllValues {
+			f.values = make([]partFieldsIndexValue, 0, len(fst.values))
+			for v, pRowsCount := range fst.values {
+				f.values = append(f.values, partFieldsIndexValue{
+					value:     v,
+					rowsCount: *pRowsCount,
+				})
+			}
+			sort.Slice(f.values, func(i, j int) bool {
+				return f.values[i].value < f.values[j].value
+			})
+		}
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].name < fields[j].name
+	})
+	pft.fields = fields
+
+	clear(pfib.fields)
+}
+
+// finish appends the compressed index for all the added blocks to dst and returns the result.
+func (pfib *partFieldsIndexBuilder) finish(dst []byte) []byte {
+	pfib.flushFields()
+
+	bb := longTermBufPool.Get()
+	bb.B = pfib.pfi.marshal(bb.B[:0])
+	dst = encoding.CompressZSTDLevel(dst, bb.B, 1)
+	if len(bb.B) < 1024*1024 {
+		longTermBufPool.Put(bb)
+	}
+
+	pfib.reset()
+	return dst
+}
+
+// mustReadFieldsIndexData reads the compressed fields index for the part at partPath.
+//
+// It returns nil if the part has no fields index, since it has been created by older VictoriaLogs versions.
+func mustReadFieldsIndexData(partPath string) []byte {
+	path := filepath.Join(partPath, fieldsIndexFilename)
+	if !fs.IsPathExist(path) {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger.Panicf("FATAL: cannot read fields index: %s", err)
+	}
+	return data
+}
+
+// getFieldsIndex returns the fields index for p.
+//
+// It returns nil if p has no fields index. The index is unpacked on the first call and then is cached, since parts are immutable.
+func (p *part) getFieldsIndex() *partFieldsIndex {
+	p.fieldsIndexOnce.Do(p.initFieldsIndex)
+	return p.fieldsIndex
+}
+
+func (p *part) initFieldsIndex() {
+	data := p.fieldsIndexData
+	p.fieldsIndexData = nil
+	if data == nil {
+		return
+	}
+
+	bb := longTermBufPool.Get()
+	defer longTermBufPool.Put(bb)
+
+	var err error
+	bb.B, err = encoding.DecompressZSTD(bb.B[:0], data)
+	if err != nil {
+		logger.Panicf("FATAL: %s: cannot decompress fields index: %s", p.path, err)
+	}
+	var pfi partFieldsIndex
+	if err := pfi.unmarshal(bb.B); err != nil {
+		logger.Panicf("FATAL: %s: cannot parse fields index: %s", p.path, err)
+	}
+	p.fieldsIndex = &pfi
+}
+
+// partFieldsIndexProcessor is implemented by pipe processors, which can obtain the results for the whole part from partFieldsIndex
+// instead of processing the part blocks.
+type partFieldsIndexProcessor interface {
+	// processPartFieldsIndex must process the fields index entries for all the logs of the given tenants at p.
+	//
+	// It must return false without processing pfts if the results for p cannot be obtained from pfts. Then p blocks are searched instead.
+	//
+	// It is called concurrently from multiple goroutines.
+	processPartFieldsIndex(p *part, pfts []*partFieldsIndexTenant) bool
+}
+
+// getPartFieldsIndexProcessor returns partFieldsIndexProcessor for q if the results from the first pipe at q can be obtained from partFieldsIndex.
+//
+// ppFirst must be the pipe processor for the first pipe at q.
+func getPartFieldsIndexProcessor(q *Query, ppFirst pipeProcessor) partFieldsIndexProcessor {
+	if len(q.pipes) == 0 || !isTimeOnlyFilter(q.f) {
+		return nil
+	}
+	pfip, ok := ppFirst.(partFieldsIndexProcessor)
+	if !ok {
+		return nil
+	}
+	return pfip
+}
+
+// isTimeOnlyFilter returns true if f selects all the logs on the time range returned by Query.GetFilterTimeRange.
+func isTimeOnlyFilter(f filter) bool {
+	switch t := f.(type) {
+	case *filterNoop, *filterTime:
+		return true
+	case *filterAnd:
+		for _, f := range t.filters {
+			switch f.(type) {
+			case *filterNoop, *filterTime:
+			default:
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// searchFieldsIndex passes the fields index for p to so.fieldsIndexProcessor instead of searching p blocks if possible.
+//
+// It returns true if the results for p are obtained from the fields index, so p blocks mustn't be searched.
+func (p *part) searchFieldsIndex(so *searchOptions) bool {
+	if so.fieldsIndexProcessor == nil || len(so.tenantIDs) == 0 {
+		return false
+	}
+	if p.path == "" {
+		// In-memory parts are small, so they are searched quickly. They also contain recently ingested logs,
+		// which may have no visible stream tags yet. Such logs are skipped by the search, so search in-memory parts
+		// in order to return consistent results.
+		return false
+	}
+	if p.ph.MinTimestamp < so.minTimestamp || p.ph.MaxTimestamp > so.maxTimestamp {
+		// The part isn't fully covered by the search time range, so only some of its logs must be processed.
+		return false
+	}
+	pfi := p.getFieldsIndex()
+	if pfi == nil {
+		return false
+	}
+
+	pfts := pfi.appendTenants(nil, so.tenantIDs)
+	if len(pfts) > 0 && !so.fieldsIndexProcessor.processPartFieldsIndex(p, pfts) {
+		return false
+	}
+	so.qs.addPartsFromFieldsIndex(1)
+	return true
+}
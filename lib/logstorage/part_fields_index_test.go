@@ -0,0 +1,247 @@
+package logstorage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/querytracer"
+)
+
+func TestPartFieldsIndexMarshalUnmarshal(t *testing.T) {
+	tenantID1 := TenantID{AccountID: 1, ProjectID: 2}
+	tenantID2 := TenantID{AccountID: 3, ProjectID: 4}
+
+	var fib partFieldsIndexBuilder
+	fib.addBlock(&streamID{tenantID: tenantID1, id: u128{lo: 1}}, 10)
+	fib.addColumn("foo")
+	fib.addConstColumn("job", "abc")
+	fib.addConstColumn("empty", "")
+	fib.addBlock(&streamID{tenantID: tenantID1, id: u128{lo: 1}}, 5)
+	fib.addConstColumn("job", "def")
+	fib.addConstColumn("foo", "bar")
+	fib.addBlock(&streamID{tenantID: tenantID1, id: u128{lo: 2}}, 3)
+	fib.addColumn("_msg")
+	fib.addBlock(&streamID{tenantID: tenantID2, id: u128{lo: 3}}, 7)
+	fib.addConstColumn("job", "abc")
+
+	data := fib.finish(nil)
+
+	pfiExpected := &partFieldsIndex{
+		tenants: []partFieldsIndexTenant{
+			{
+				tenantID:  tenantID1,
+				rowsCount: 18,
+				streams: []partFieldsIndexStream{
+					{id: u128{lo: 1}, rowsCount: 15},
+					{id: u128{lo: 2}, rowsCount: 3},
+				},
+				fields: []partFieldsIndexField{
+					{name: "_msg", rowsCount: 3},
+					{name: "foo", rowsCount: 15},
+					{
+						name:         "job",
+						rowsCount:    15,
+						hasAllValues: true,
+						values: []partFieldsIndexValue{
+							{value: "abc", rowsCount: 10},
+							{value: "def", rowsCount: 5},
+						},
+					},
+				},
+			},
+			{
+				tenantID:  tenantID2,
+				rowsCount: 7,
+				streams: []partFieldsIndexStream{
+					{id: u128{lo: 3}, rowsCount: 7},
+				},
+				fields: []partFieldsIndexField{
+					{
+						name:         "job",
+						rowsCount:    7,
+						hasAllValues: true,
+						values: []partFieldsIndexValue{
+							{value: "abc", rowsCount: 7},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	dataDecompressed, err := encoding.DecompressZSTD(nil, data)
+	if err != nil {
+		t.Fatalf("cannot decompress fields index: %s", err)
+	}
+	pfi := &partFieldsIndex{}
+	if err := pfi.unmarshal(dataDecompressed); err != nil {
+		t.Fatalf("cannot unmarshal fields index: %s", err)
+	}
+	if !reflect.DeepEqual(pfi, pfiExpected) {
+		t.Fatalf("unexpected fields index\ngot\n%#v\nwant\n%#v", pfi, pfiExpected)
+	}
+
+	pfts := pfi.appendTenants(nil, []TenantID{tenantID2, {AccountID: 5}})
+	if len(pfts) != 1 || pfts[0].tenantID != tenantID2 {
+		t.Fatalf("unexpected tenants: %#v", pfts)
+	}
+	if f := pfi.tenants[0].getField(""); f == nil || f.rowsCount != 3 {
+		t.Fatalf("unexpected _msg field: %#v", f)
+	}
+	if f := pfi.tenants[0].getField("missing"); f != nil {
+		t.Fatalf("unexpected missing field: %#v", f)
+	}
+}
+
+func TestStorageFieldsIndex(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	const tenantsCount = 3
+	const streamsPerTenant = 4
+	const rowsPerStream = 50
+
+	sc := &StorageConfig{
+		Retention: 24 * time.Hour,
+	}
+	s := MustOpenStorage(path, sc)
+
+	var allTenantIDs []TenantID
+	baseTimestamp := time.Now().UnixNano() - 3600*1e9
+	var fields []Field
+	streamTags := []string{
+		"job",
+		"instance",
+	}
+	for i := 0; i < tenantsCount; i++ {
+		tenantID := TenantID{
+			AccountID: uint32(i),
+			ProjectID: uint32(10*i + 1),
+		}
+		allTenantIDs = append(allTenantIDs, tenantID)
+		lr := GetLogRows(streamTags, nil)
+		for j := 0; j < streamsPerTenant; j++ {
+			for k := 0; k < rowsPerStream; k++ {
+				fields = append(fields[:0], Field{
+					Name:  "job",
+					Value: "foobar",
+				}, Field{
+					Name:  "instance",
+					Value: fmt.Sprintf("host-%d:234", j),
+				}, Field{
+					Name:  "_msg",
+					Value: fmt.Sprintf("log message %d", k),
+				}, Field{
+					Name:  "level",
+					Value: "info",
+				})
+				if j%2 == 0 {
+					fields = append(fields, Field{
+						Name:  "region",
+						Value: fmt.Sprintf("region-%d", i),
+					})
+				}
+				if k%3 == 0 {
+					fields = append(fields, Field{
+						Name:  "request_id",
+						Value: fmt.Sprintf("req-%d", k),
+					})
+				}
+				lr.MustAdd(tenantID, baseTimestamp+int64(k)*1e9, fields)
+			}
+		}
+		s.MustAddRows(lr)
+		PutLogRows(lr)
+	}
+
+	// Re-open the storage, so its data is stored in file parts with the fields index.
+	s.MustClose()
+	s = MustOpenStorage(path, sc)
+
+	ctx := context.Background()
+
+	t.Run("query-trace", func(t *testing.T) {
+		for _, qStr := range []string{"* | field_names", "* | field_values level", "_time:1d | field_values _stream"} {
+			q := mustParseQuery(qStr)
+			q.Optimize()
+			qt := querytracer.New(true, "test")
+			q.SetTracer(qt)
+			writeBlock := func(_ uint, _ []int64, _ []BlockColumn) {}
+			if err := s.RunQuery(ctx, allTenantIDs, q, writeBlock); err != nil {
+				t.Fatalf("unexpected error in the query [%s]: %s", q, err)
+			}
+			qt.Done()
+			if trace := qt.String(); !strings.Contains(trace, "from their fields index without reading their blocks") {
+				t.Fatalf("the fields index hasn't been used for the query [%s]\n%s", q, trace)
+			}
+		}
+	})
+
+	// The results obtained from the fields index must match the results obtained by scanning the data blocks.
+	q := mustParseQuery("*")
+	q.Optimize()
+	qScan := mustParseQuery("* !nonexistent:x")
+	qScan.Optimize()
+
+	t.Run("field_names", func(t *testing.T) {
+		results, err := s.GetFieldNames(ctx, allTenantIDs, q)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		resultsExpected, err := s.GetFieldNames(ctx, allTenantIDs, qScan)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(results, resultsExpected) {
+			t.Fatalf("unexpected results\ngot\n%v\nwant\n%v", results, resultsExpected)
+		}
+	})
+
+	fieldValues := func(t *testing.T, fieldName string) {
+		results, err := s.GetFieldValues(ctx, allTenantIDs, q, fieldName, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		resultsExpected, err := s.GetFieldValues(ctx, allTenantIDs, qScan, fieldName, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(results, resultsExpected) {
+			t.Fatalf("unexpected results for %q\ngot\n%v\nwant\n%v", fieldName, results, resultsExpected)
+		}
+	}
+	for _, fieldName := range []string{"level", "region", "job", "missing", "_stream", "_stream_id"} {
+		t.Run("field_values_"+fieldName, func(t *testing.T) {
+			fieldValues(t, fieldName)
+		})
+	}
+
+	t.Run("field_values_high_cardinality", func(t *testing.T) {
+		// The values for request_id and _msg aren't stored in the fields index, so they must be obtained by scanning the data blocks.
+		for _, fieldName := range []string{"request_id", "_msg"} {
+			results, err := s.GetFieldValues(ctx, allTenantIDs, q, fieldName, 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			resultsExpected, err := s.GetFieldValues(ctx, allTenantIDs, qScan, fieldName, 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(results, resultsExpected) {
+				t.Fatalf("unexpected results for %q\ngot\n%v\nwant\n%v", fieldName, results, resultsExpected)
+			}
+		}
+	})
+
+	s.MustClose()
+	fs.MustRemoveAll(path)
+}
@@ -12,8 +12,18 @@ import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 )
 
+// partFormatLatestVersion is the latest format version for parts.
+//
+// Parts created before the introduction of FormatVersion have zero FormatVersion.
+//
+// Version 1 parts may contain string values compressed with ZSTD dictionary - see ZSTDDictID.
+const partFormatLatestVersion = 1
+
 // partHeader contains the information about a single part
 type partHeader struct {
+	// FormatVersion is the format version for the part. See partFormatLatestVersion.
+	FormatVersion uint
+
 	// CompressedSizeBytes is physical size of the part
 	CompressedSizeBytes uint64
 
@@ -31,40 +41,69 @@ type partHeader struct {
 
 	// MaxTimestamp is the maximum timestamp seen in the part
 	MaxTimestamp int64
+
+	// ZSTDDictID is the id of ZSTD dictionary used for compressing string values in the part.
+	//
+	// The dictionary is shared among all the parts in the partition. Zero value means the part doesn't use ZSTD dictionary.
+	ZSTDDictID uint32
+
+	// DeleteTaskSeq is the sequence number of the latest delete task applied to the part.
+	//
+	// Delete tasks with bigger sequence numbers must be applied to the part during search and merge. See Storage.DeleteRows.
+	DeleteTaskSeq uint64
 }
 
 // reset resets ph for subsequent re-use
 func (ph *partHeader) reset() {
+	ph.FormatVersion = 0
 	ph.CompressedSizeBytes = 0
 	ph.UncompressedSizeBytes = 0
 	ph.RowsCount = 0
 	ph.BlocksCount = 0
 	ph.MinTimestamp = 0
 	ph.MaxTimestamp = 0
+	ph.ZSTDDictID = 0
+	ph.DeleteTaskSeq = 0
 }
 
 // String returns string represenation for ph.
 func (ph *partHeader) String() string {
-	return fmt.Sprintf("{CompressedSizeBytes=%d, UncompressedSizeBytes=%d, RowsCount=%d, BlocksCount=%d, MinTimestamp=%s, MaxTimestamp=%s}",
-		ph.CompressedSizeBytes, ph.UncompressedSizeBytes, ph.RowsCount, ph.BlocksCount, timestampToString(ph.MinTimestamp), timestampToString(ph.MaxTimestamp))
+	return fmt.Sprintf("{FormatVersion=%d, CompressedSizeBytes=%d, UncompressedSizeBytes=%d, RowsCount=%d, BlocksCount=%d, MinTimestamp=%s, MaxTimestamp=%s, ZSTDDictID=%d, DeleteTaskSeq=%d}",
+		ph.FormatVersion, ph.CompressedSizeBytes, ph.UncompressedSizeBytes, ph.RowsCount, ph.BlocksCount, timestampToString(ph.MinTimestamp), timestampToString(ph.MaxTimestamp),
+		ph.ZSTDDictID, ph.DeleteTaskSeq)
 }
 
 func (ph *partHeader) mustReadMetadata(partPath string) {
+	if err := ph.readMetadata(partPath); err != nil {
+		logger.Panicf("FATAL: %s", err)
+	}
+}
+
+// readMetadata reads ph from the metadata file for the part at partPath and verifies it.
+func (ph *partHeader) readMetadata(partPath string) error {
 	ph.reset()
 
 	metadataPath := filepath.Join(partPath, metadataFilename)
 	metadata, err := os.ReadFile(metadataPath)
 	if err != nil {
-		logger.Panicf("FATAL: cannot read %q: %s", metadataPath, err)
+		return fmt.Errorf("cannot read %q: %w", metadataPath, err)
 	}
 	if err := json.Unmarshal(metadata, ph); err != nil {
-		logger.Panicf("FATAL: cannot parse %q: %s", metadataPath, err)
+		return fmt.Errorf("cannot parse %q: %w", metadataPath, err)
 	}
 
 	// Perform various checks
+	if ph.FormatVersion > partFormatLatestVersion {
+		return fmt.Errorf("%s: unsupported part format version: %d; the maximum supported version is %d; make sure you run the latest VictoriaLogs release",
+			metadataPath, ph.FormatVersion, partFormatLatestVersion)
+	}
+	if ph.FormatVersion == 0 && ph.ZSTDDictID != 0 {
+		return fmt.Errorf("%s: unexpected non-zero ZSTDDictID=%d for the part with zero FormatVersion", metadataPath, ph.ZSTDDictID)
+	}
 	if ph.MinTimestamp > ph.MaxTimestamp {
-		logger.Panicf("FATAL: MinTimestamp cannot exceed MaxTimestamp; got %d vs %d", ph.MinTimestamp, ph.MaxTimestamp)
+		return fmt.Errorf("%s: MinTimestamp cannot exceed MaxTimestamp; got %d vs %d", metadataPath, ph.MinTimestamp, ph.MaxTimestamp)
 	}
+	return nil
 }
 
 func (ph *partHeader) mustWriteMetadata(partPath string) {
@@ -1,8 +1,11 @@
 package logstorage
 
 import (
+	"path/filepath"
 	"reflect"
 	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
 )
 
 func TestPartHeaderReset(t *testing.T) {
@@ -19,3 +22,27 @@ func TestPartHeaderReset(t *testing.T) {
 		t.Fatalf("unexpected non-zero partHeader after reset: %v", ph)
 	}
 }
+
+func TestPartHeaderReadMetadataWithoutFormatVersion(t *testing.T) {
+	path := t.Name()
+	fs.MustMkdirFailIfExist(path)
+	defer fs.MustRemoveAll(path)
+
+	// Parts created before the introduction of FormatVersion have no FormatVersion and ZSTDDictID fields in metadata.
+	metadata := `{"CompressedSizeBytes":123,"UncompressedSizeBytes":234,"RowsCount":12,"BlocksCount":2,"MinTimestamp":10,"MaxTimestamp":20}`
+	fs.MustWriteSync(filepath.Join(path, metadataFilename), []byte(metadata))
+
+	var ph partHeader
+	ph.mustReadMetadata(path)
+	phExpected := partHeader{
+		CompressedSizeBytes:   123,
+		UncompressedSizeBytes: 234,
+		RowsCount:             12,
+		BlocksCount:           2,
+		MinTimestamp:          10,
+		MaxTimestamp:          20,
+	}
+	if !reflect.DeepEqual(&ph, &phExpected) {
+		t.Fatalf("unexpected partHeader\ngot\n%s\nwant\n%s", &ph, &phExpected)
+	}
+}
@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"path/filepath"
 	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 )
@@ -28,11 +32,28 @@ type partition struct {
 	// It is used for creating keys for partition caches.
 	name string
 
+	// generation is a unique id of the opened partition.
+	//
+	// It changes every time the partition is opened, so it can be used in the keys for cache entries,
+	// which depend on the in-memory state of the partition.
+	generation uint64
+
 	// idb is indexdb used for the given partition
 	idb *indexdb
 
 	// ddb is the datadb used for the given partition
 	ddb *datadb
+
+	// zstdDict is ZSTD dictionary for compressing string values in the partition.
+	//
+	// It is nil if the dictionary isn't trained yet.
+	zstdDict atomic.Pointer[zstd.Dict]
+
+	// zstdDictLock prevents from concurrent training of zstdDict.
+	zstdDictLock sync.Mutex
+
+	// zstdDictTrainingFailed is set to true if zstdDict cannot be trained for the partition.
+	zstdDictTrainingFailed atomic.Bool
 }
 
 // mustCreatePartition creates a partition at the given path.
@@ -52,9 +73,20 @@ func mustCreatePartition(path string) {
 
 // mustDeletePartition deletes partition at the given path.
 //
+// Data files for the partition parts are deleted from obs if it isn't nil.
+//
 // The partition must be closed with MustClose before deleting it.
-func mustDeletePartition(path string) {
+func mustDeletePartition(path string, obs *objectStorage) {
+	var osfs []objectStorageFile
+	if obs != nil {
+		osfs = mustReadPartitionObjectStorageFiles(path)
+	}
+
 	fs.MustRemoveAll(path)
+
+	if len(osfs) > 0 {
+		obs.deleteFiles(osfs)
+	}
 }
 
 // mustOpenPartition opens partition at the given path for the given Storage.
@@ -69,12 +101,16 @@ func mustOpenPartition(s *Storage, path string) *partition {
 
 	// Start initializing the partition
 	pt := &partition{
-		s:    s,
-		path: path,
-		name: name,
-		idb:  idb,
+		s:          s,
+		path:       path,
+		name:       name,
+		generation: partitionGeneration.Add(1),
+		idb:        idb,
 	}
 
+	// Load ZSTD dictionary before opening datadb, since parts in datadb may need it.
+	pt.mustLoadZSTDDict()
+
 	// Open datadb
 	datadbPath := filepath.Join(path, datadbDirname)
 	pt.ddb = mustOpenDatadb(pt, datadbPath, s.flushInterval)
@@ -82,19 +118,30 @@ func mustOpenPartition(s *Storage, path string) *partition {
 	return pt
 }
 
+// partitionGeneration is used for generating unique partition generations.
+//
+// It is initialized with the current time, so the generations don't repeat after the restart.
+var partitionGeneration atomic.Uint64
+
+func init() {
+	partitionGeneration.Store(uint64(time.Now().UnixNano()))
+}
+
 // mustClosePartition closes pt.
 //
 // The caller must ensure that pt is no longer used before the call to mustClosePartition().
 //
 // The partition can be deleted if needed after it is closed via mustDeletePartition() call.
 func mustClosePartition(pt *partition) {
+	// Close datadb before indexdb, since the final merges at datadb may need indexdb for applying retention filters.
+	mustCloseDatadb(pt.ddb)
+	pt.ddb = nil
+
 	// Close indexdb
 	mustCloseIndexdb(pt.idb)
 	pt.idb = nil
 
-	// Close datadb
-	mustCloseDatadb(pt.ddb)
-	pt.ddb = nil
+	pt.zstdDict.Store(nil)
 
 	pt.name = ""
 	pt.path = ""
@@ -140,7 +187,11 @@ func (pt *partition) mustAddRows(lr *LogRows) {
 	}
 
 	// Add rows to datadb
+	if pt.s.useZSTDDicts {
+		pt.mustTrainZSTDDictIfNeeded(lr)
+	}
 	pt.ddb.mustAddRows(lr)
+	pt.s.updateIngestedTenantStats(lr)
 	if pt.s.logIngestedRows {
 		pt.logIngestedRows(lr)
 	}
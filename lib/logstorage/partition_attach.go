@@ -0,0 +1,422 @@
+package logstorage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// DetachPartition detaches the partition with the given name in the form YYYYMMDD from s.
+//
+// The detached partition is moved to <path>/detached/<name> directory. It is no longer visible to queries
+// and it isn't affected by retention. The detached partition can be copied to another Storage
+// and attached there via AttachPartition. It can be attached back to s via AttachPartition too.
+//
+// Logs ingested for the detached partition day after the call are stored in a new partition.
+func (s *Storage) DetachPartition(name string) error {
+	day, err := parsePartitionName(name)
+	if err != nil {
+		return err
+	}
+
+	s.attachLock.Lock()
+	defer s.attachLock.Unlock()
+
+	detachedPath := filepath.Join(s.path, detachedDirname, name)
+	if fs.IsPathExist(detachedPath) {
+		return fmt.Errorf("cannot detach partition %q, since %q already exists; attach or remove it before detaching the partition", name, detachedPath)
+	}
+
+	s.partitionsLock.Lock()
+	n := -1
+	for i, ptw := range s.partitions {
+		if ptw.day == day {
+			n = i
+			break
+		}
+	}
+	if n < 0 {
+		s.partitionsLock.Unlock()
+		return fmt.Errorf("cannot find partition %q", name)
+	}
+	ptw := s.partitions[n]
+	if err := s.checkNoPendingDeleteTasks(ptw); err != nil {
+		s.partitionsLock.Unlock()
+		return fmt.Errorf("cannot detach partition %q: %w", name, err)
+	}
+	s.partitions = append(s.partitions[:n:n], s.partitions[n+1:]...)
+	if ptw == s.ptwHot {
+		s.ptwHot = nil
+	}
+	detachedCh := make(chan struct{})
+	s.detachingPartitions[day] = detachedCh
+	ptw.closedCh = make(chan struct{})
+	s.partitionsLock.Unlock()
+
+	// Wait until the partition is closed by all its users, so it can be safely moved to detachedPath.
+	partitionPath := ptw.pt.path
+	closedCh := ptw.closedCh
+	ptw.decRef()
+	<-closedCh
+
+	fs.MustMkdirIfNotExist(filepath.Dir(detachedPath))
+	mustRenameDir(partitionPath, detachedPath)
+
+	s.partitionsLock.Lock()
+	delete(s.detachingPartitions, day)
+	close(detachedCh)
+	s.partitionsLock.Unlock()
+
+	logger.Infof("detached partition %q to %q", partitionPath, detachedPath)
+	return nil
+}
+
+// checkNoPendingDeleteTasks returns an error if some of the delete tasks aren't applied to ptw yet.
+//
+// The detached partition can be attached to another Storage without these delete tasks, so they must be finished before detaching.
+func (s *Storage) checkNoPendingDeleteTasks(ptw *partitionWrapper) error {
+	dts, _ := s.getDeleteTasks()
+	if len(dts) == 0 {
+		return nil
+	}
+	seq := dts[len(dts)-1].task.TaskID
+	if minSeq := ptw.pt.ddb.getMinDeleteTaskSeq(); minSeq < seq {
+		return fmt.Errorf("the partition has pending delete tasks; wait until they are finished; see /delete/active_tasks")
+	}
+	return nil
+}
+
+// ListDetachedPartitions returns sorted names of the detached partitions at s.
+//
+// See DetachPartition.
+func (s *Storage) ListDetachedPartitions() ([]string, error) {
+	detachedDir := filepath.Join(s.path, detachedDirname)
+	if !fs.IsPathExist(detachedDir) {
+		return nil, nil
+	}
+	des, err := os.ReadDir(detachedDir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read detached partitions: %w", err)
+	}
+	var names []string
+	for _, de := range des {
+		name := de.Name()
+		if !fs.IsDirOrSymlink(de) {
+			continue
+		}
+		if _, err := parsePartitionName(name); err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// AttachPartition attaches the partition with the given name in the form YYYYMMDD from <path>/detached/<name> directory to s.
+//
+// The partition may be detached from s or from another Storage via DetachPartition.
+// The partition is verified before attaching, so incompatible or corrupted partitions aren't attached.
+//
+// If s already contains the partition for the same day, then the streams and the parts from the attached partition
+// are added to the existing partition. Otherwise the attached partition is moved to <path>/partitions/<name>.
+//
+// Delete tasks created after the call are applied to the attached logs, like to the newly ingested logs.
+func (s *Storage) AttachPartition(name string) error {
+	day, err := parsePartitionName(name)
+	if err != nil {
+		return err
+	}
+
+	s.attachLock.Lock()
+	defer s.attachLock.Unlock()
+
+	if day < s.getMinAllowedDay() {
+		return fmt.Errorf("cannot attach partition %q, since it is outside the retention; see -retentionPeriod command-line flag", name)
+	}
+	if day > s.getMaxAllowedDay() {
+		return fmt.Errorf("cannot attach partition %q, since it is outside the future retention; see -futureRetention command-line flag", name)
+	}
+
+	startTime := time.Now()
+	detachedPath := filepath.Join(s.path, detachedDirname, name)
+	dp, err := s.readDetachedPartition(detachedPath)
+	if err != nil {
+		return fmt.Errorf("cannot attach partition %q: %w", name, err)
+	}
+
+	// Apply only the delete tasks created after the attach to the attached parts, like to the newly ingested logs.
+	deleteTaskSeq := s.deleteTasksLatestSeq.Load()
+
+	s.partitionsLock.Lock()
+	ptws := s.partitions
+	n := sort.Search(len(ptws), func(i int) bool {
+		return ptws[i].day >= day
+	})
+	if n >= len(ptws) || ptws[n].day != day {
+		// Fast path - there is no partition for the given day. Move the detached partition to partitions.
+		// This is performed under partitionsLock in order to prevent from concurrent creation of the partition for the same day.
+		for _, partName := range dp.partNames {
+			mustUpdateDeleteTaskSeq(filepath.Join(detachedPath, datadbDirname, partName), dp.partHeaders[partName], deleteTaskSeq)
+		}
+		partitionPath := filepath.Join(s.path, partitionsDirname, name)
+		mustRenameDir(detachedPath, partitionPath)
+
+		pt := mustOpenPartition(s, partitionPath)
+		ptw := newPartitionWrapper(pt, day)
+		ptw.expiredRetentionsCount = s.getExpiredRetentionsCount(day)
+		ptws = append(ptws, nil)
+		copy(ptws[n+1:], ptws[n:])
+		ptws[n] = ptw
+		s.partitions = ptws
+		s.partitionsLock.Unlock()
+
+		logger.Infof("attached partition %q with %d parts in %.3f seconds", partitionPath, len(dp.partNames), time.Since(startTime).Seconds())
+		return nil
+	}
+	ptw := ptws[n]
+	ptw.incRef()
+	s.partitionsLock.Unlock()
+	defer ptw.decRef()
+
+	// Slow path - merge the detached partition into the existing partition.
+	pt := ptw.pt
+	if err := pt.setZSTDDictForAttach(dp.zstdDict); err != nil {
+		return fmt.Errorf("cannot attach partition %q: %w", name, err)
+	}
+
+	// Register streams from the detached partition before adding its parts, so the streams for the added logs can be found.
+	streamsRegistered := pt.idb.mustRegisterStreamsFrom(filepath.Join(detachedPath, indexdbDirname), name, s)
+
+	pws := make([]*partWrapper, 0, len(dp.partNames))
+	for _, partName := range dp.partNames {
+		srcPartPath := filepath.Join(detachedPath, datadbDirname, partName)
+		dstPartPath := filepath.Join(pt.ddb.path, fmt.Sprintf("%016X", pt.ddb.nextMergeIdx()))
+		fs.MustHardLinkFiles(srcPartPath, dstPartPath)
+		mustUpdateDeleteTaskSeq(dstPartPath, dp.partHeaders[partName], deleteTaskSeq)
+
+		p := mustOpenFilePart(pt, dstPartPath)
+		pws = append(pws, newPartWrapper(p, nil, time.Time{}))
+	}
+	pt.ddb.mustAddFileParts(pws)
+
+	// The detached partition is no longer needed, since its' data has been added to the existing partition.
+	fs.MustRemoveAll(detachedPath)
+
+	logger.Infof("attached partition %q with %d parts and %d new streams to the existing partition %q in %.3f seconds",
+		detachedPath, len(pws), streamsRegistered, pt.path, time.Since(startTime).Seconds())
+	return nil
+}
+
+// detachedPartition contains the information about detached partition, which is needed for attaching it.
+type detachedPartition struct {
+	// partNames contains the names of parts at the datadb of the detached partition.
+	partNames []string
+
+	// partHeaders contains headers for the parts keyed by part name.
+	partHeaders map[string]*partHeader
+
+	// zstdDict is ZSTD dictionary for the detached partition. It is nil if the partition has no ZSTD dictionary.
+	zstdDict *zstd.Dict
+}
+
+// readDetachedPartition reads and verifies the detached partition at path.
+func (s *Storage) readDetachedPartition(path string) (*detachedPartition, error) {
+	if !fs.IsPathExist(path) {
+		return nil, fmt.Errorf("missing %q directory; copy the detached partition to this directory before attaching it", path)
+	}
+	indexdbPath := filepath.Join(path, indexdbDirname)
+	if !fs.IsPathExist(indexdbPath) {
+		return nil, fmt.Errorf("missing %q directory", indexdbPath)
+	}
+
+	var zd *zstd.Dict
+	zstdDictPath := filepath.Join(path, zstdDictFilename)
+	if fs.IsPathExist(zstdDictPath) {
+		data, err := os.ReadFile(zstdDictPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read ZSTD dictionary: %w", err)
+		}
+		zd, err = zstd.NewDict(data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load ZSTD dictionary from %q: %w", zstdDictPath, err)
+		}
+	}
+
+	datadbPath := filepath.Join(path, datadbDirname)
+	partNamesPath := filepath.Join(datadbPath, partsFilename)
+	data, err := os.ReadFile(partNamesPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the list of parts: %w", err)
+	}
+	var partNames []string
+	if err := json.Unmarshal(data, &partNames); err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", partNamesPath, err)
+	}
+
+	partHeaders := make(map[string]*partHeader, len(partNames))
+	for _, partName := range partNames {
+		partPath := filepath.Join(datadbPath, partName)
+		if filepath.Base(partName) != partName || partName == "." || partName == ".." {
+			return nil, fmt.Errorf("unexpected part name %q at %q", partName, partNamesPath)
+		}
+		var ph partHeader
+		if err := ph.readMetadata(partPath); err != nil {
+			return nil, err
+		}
+		if ph.ZSTDDictID != 0 {
+			if zd == nil {
+				return nil, fmt.Errorf("%s: missing ZSTD dictionary with id=%d; make sure %q file exists in the partition directory", partPath, ph.ZSTDDictID, zstdDictFilename)
+			}
+			if zd.ID() != ph.ZSTDDictID {
+				return nil, fmt.Errorf("%s: unexpected ZSTD dictionary id=%d; want %d", partPath, zd.ID(), ph.ZSTDDictID)
+			}
+		}
+		if fs.IsPathExist(filepath.Join(partPath, objectStorageFilesFilename)) && s.objectStorage == nil {
+			return nil, fmt.Errorf("the part %q is stored at object storage, but object storage isn't configured", partPath)
+		}
+		partHeaders[partName] = &ph
+	}
+
+	dp := &detachedPartition{
+		partNames:   partNames,
+		partHeaders: partHeaders,
+		zstdDict:    zd,
+	}
+	return dp, nil
+}
+
+// setZSTDDictForAttach makes sure the parts compressed with zd can be attached to pt.
+//
+// zd becomes ZSTD dictionary for pt if pt has no ZSTD dictionary yet.
+func (pt *partition) setZSTDDictForAttach(zd *zstd.Dict) error {
+	if zd == nil {
+		return nil
+	}
+
+	pt.zstdDictLock.Lock()
+	defer pt.zstdDictLock.Unlock()
+
+	zdLocal := pt.zstdDict.Load()
+	if zdLocal == nil {
+		// Persist the dictionary before making it visible to writers, since the created parts cannot be read without the dictionary.
+		path := filepath.Join(pt.path, zstdDictFilename)
+		fs.MustWriteAtomic(path, zd.Data(), false)
+		pt.zstdDict.Store(zd)
+		return nil
+	}
+	if zdLocal.ID() != zd.ID() {
+		return fmt.Errorf("the partition uses ZSTD dictionary id=%d, while the existing partition %q uses ZSTD dictionary id=%d; "+
+			"such partitions cannot be merged; attach the partition to a distinct VictoriaLogs instance", zd.ID(), pt.path, zdLocal.ID())
+	}
+	return nil
+}
+
+// mustRegisterStreamsFrom registers streams from the indexdb at srcPath in idb.
+//
+// It returns the number of registered streams, which were missing in idb.
+func (idb *indexdb) mustRegisterStreamsFrom(srcPath, partitionName string, s *Storage) int {
+	idbSrc := mustOpenIndexdb(srcPath, partitionName, s)
+	defer mustCloseIndexdb(idbSrc)
+
+	is := idbSrc.getIndexSearch()
+	defer idbSrc.putIndexSearch(is)
+
+	ts := &is.ts
+	prefix := []byte{nsPrefixStreamIDToStreamTags}
+	ts.Seek(prefix)
+
+	var sid streamID
+	n := 0
+	for ts.NextItem() {
+		item := ts.Item
+		if !bytes.HasPrefix(item, prefix) {
+			break
+		}
+		tail, _, err := unmarshalCommonPrefix(&sid.tenantID, item)
+		if err != nil {
+			logger.Panicf("FATAL: cannot unmarshal stream entry at %q: %s", srcPath, err)
+		}
+		streamTagsCanonical, err := sid.id.unmarshal(tail)
+		if err != nil {
+			logger.Panicf("FATAL: cannot unmarshal streamID at %q: %s", srcPath, err)
+		}
+		if idb.hasStreamID(&sid) {
+			continue
+		}
+		idb.mustRegisterStream(&sid, streamTagsCanonical)
+		n++
+	}
+	if err := ts.Error(); err != nil {
+		logger.Panicf("FATAL: cannot read streams from %q: %s", srcPath, err)
+	}
+	return n
+}
+
+// mustAddFileParts adds the given file parts to ddb.
+func (ddb *datadb) mustAddFileParts(pws []*partWrapper) {
+	lateRowsCount := uint64(0)
+	maxCacheableTimestamp := ddb.pt.s.getMaxCacheableTimestamp()
+	for _, pw := range pws {
+		if pw.p.ph.MinTimestamp <= maxCacheableTimestamp {
+			lateRowsCount += pw.p.ph.RowsCount
+		}
+	}
+
+	ddb.partsLock.Lock()
+	for _, pw := range pws {
+		if pw.p.ph.CompressedSizeBytes > getMaxInmemoryPartSize() {
+			ddb.bigParts = append(ddb.bigParts, pw)
+		} else {
+			ddb.smallParts = append(ddb.smallParts, pw)
+		}
+	}
+	smallPartNames := getPartNames(ddb.smallParts)
+	bigPartNames := getPartNames(ddb.bigParts)
+	mustWritePartNames(ddb.path, smallPartNames, bigPartNames)
+	ddb.startSmallPartsMergerLocked()
+	ddb.startBigPartsMergerLocked()
+
+	// Update lateRowsAdded after the parts become visible for search, so the cached states for the time buckets
+	// computed before the update are invalidated.
+	ddb.lateRowsAdded.Add(lateRowsCount)
+	ddb.partsLock.Unlock()
+}
+
+// mustUpdateDeleteTaskSeq sets DeleteTaskSeq at ph to seq and stores ph to the part at partPath.
+func mustUpdateDeleteTaskSeq(partPath string, ph *partHeader, seq uint64) {
+	if ph.DeleteTaskSeq == seq {
+		return
+	}
+	ph.DeleteTaskSeq = seq
+
+	// Remove the metadata file before writing the updated file, since it may be a hard link to the metadata file at the detached partition.
+	fs.MustRemoveAll(filepath.Join(partPath, metadataFilename))
+	ph.mustWriteMetadata(partPath)
+	fs.MustSyncPath(partPath)
+}
+
+// parsePartitionName returns the day for the partition with the given name in the form YYYYMMDD.
+func parsePartitionName(name string) (int64, error) {
+	t, err := time.Parse(partitionNameFormat, name)
+	if err != nil || t.Format(partitionNameFormat) != name {
+		return 0, fmt.Errorf("unexpected partition name %q; it must be in the form YYYYMMDD", name)
+	}
+	return t.UTC().UnixNano() / nsecPerDay, nil
+}
+
+func mustRenameDir(srcPath, dstPath string) {
+	if err := os.Rename(srcPath, dstPath); err != nil {
+		logger.Panicf("FATAL: cannot rename %q to %q: %s", srcPath, dstPath, err)
+	}
+	fs.MustSyncPath(filepath.Dir(srcPath))
+	fs.MustSyncPath(filepath.Dir(dstPath))
+}
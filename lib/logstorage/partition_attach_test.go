@@ -0,0 +1,236 @@
+package logstorage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestStorageAttachDetachPartition(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	sc := &StorageConfig{
+		Retention: 30 * 24 * time.Hour,
+
+		// Disable the query results cache, so queries return the actual data after attaching and detaching partitions.
+		DisableQueryResultsCache: true,
+	}
+	s1Path := filepath.Join(path, "s1")
+	s2Path := filepath.Join(path, "s2")
+	s3Path := filepath.Join(path, "s3")
+	s1 := MustOpenStorage(s1Path, sc)
+	s2 := MustOpenStorage(s2Path, sc)
+	s3 := MustOpenStorage(s3Path, sc)
+
+	tenantID := TenantID{
+		AccountID: 1,
+		ProjectID: 2,
+	}
+	oldTimestamp := time.Now().UnixNano() - 3*nsecPerDay
+	oldTimestamp -= oldTimestamp % nsecPerDay
+	newTimestamp := time.Now().UnixNano() - 3600*1e9
+	partitionName := time.Unix(0, oldTimestamp).UTC().Format(partitionNameFormat)
+
+	addRows := func(s *Storage, hostPrefix string, baseTimestamp int64, n int) {
+		lr := GetLogRows([]string{"host"}, nil)
+		for i := 0; i < n; i++ {
+			fields := []Field{
+				{
+					Name:  "host",
+					Value: fmt.Sprintf("%s-%d", hostPrefix, i%3),
+				},
+				{
+					Name:  "_msg",
+					Value: fmt.Sprintf("message #%d", i),
+				},
+			}
+			lr.MustAdd(tenantID, baseTimestamp+int64(i)*1e6, fields)
+		}
+		s.MustAddRows(lr)
+		PutLogRows(lr)
+	}
+
+	getResults := func(s *Storage) []string {
+		t.Helper()
+
+		var rows []string
+		var rowsLock sync.Mutex
+		writeBlock := func(_ uint, timestamps []int64, columns []BlockColumn) {
+			rowsLock.Lock()
+			defer rowsLock.Unlock()
+
+			for i := range timestamps {
+				rows = append(rows, columns[0].Values[i]+"="+columns[1].Values[i])
+			}
+		}
+		q := mustParseQuery(`* | stats by (host) count() hits | sort by (host)`)
+		if err := s.RunQuery(context.Background(), []TenantID{tenantID}, q, writeBlock); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return rows
+	}
+
+	verifyResults := func(s *Storage, resultsExpected []string) {
+		t.Helper()
+
+		results := getResults(s)
+		if !reflect.DeepEqual(results, resultsExpected) {
+			t.Fatalf("unexpected results\ngot\n%q\nwant\n%q", results, resultsExpected)
+		}
+	}
+
+	verifyDetachedPartitions := func(s *Storage, namesExpected []string) {
+		t.Helper()
+
+		names, err := s.ListDetachedPartitions()
+		if err != nil {
+			t.Fatalf("cannot list detached partitions: %s", err)
+		}
+		if !reflect.DeepEqual(names, namesExpected) {
+			t.Fatalf("unexpected detached partitions; got %q; want %q", names, namesExpected)
+		}
+	}
+
+	// moveDetachedPartition moves the detached partition from src to dst as if it is copied between hosts.
+	moveDetachedPartition := func(src, dst *Storage) {
+		t.Helper()
+
+		srcPath := filepath.Join(src.path, detachedDirname, partitionName)
+		dstPath := filepath.Join(dst.path, detachedDirname, partitionName)
+		copyDir(t, srcPath, dstPath)
+		fs.MustRemoveAll(srcPath)
+	}
+
+	addRows(s1, "a", oldTimestamp, 300)
+	addRows(s1, "a", newTimestamp, 30)
+	verifyDetachedPartitions(s1, nil)
+
+	// The detached partition mustn't be visible to queries
+	if err := s1.DetachPartition(partitionName); err != nil {
+		t.Fatalf("cannot detach partition: %s", err)
+	}
+	verifyDetachedPartitions(s1, []string{partitionName})
+	verifyResults(s1, []string{"a-0=10", "a-1=10", "a-2=10"})
+	if err := s1.DetachPartition(partitionName); err == nil {
+		t.Fatalf("expecting non-nil error when detaching missing partition")
+	}
+
+	// Attach the partition back when there is no partition for the same day
+	if err := s1.AttachPartition(partitionName); err != nil {
+		t.Fatalf("cannot attach partition: %s", err)
+	}
+	verifyDetachedPartitions(s1, nil)
+	verifyResults(s1, []string{"a-0=110", "a-1=110", "a-2=110"})
+
+	// Logs for the detached partition day must go to a new partition, which is merged with the attached partition
+	if err := s1.DetachPartition(partitionName); err != nil {
+		t.Fatalf("cannot detach partition: %s", err)
+	}
+	addRows(s1, "a", oldTimestamp+3600*1e9, 30)
+	if err := s1.DetachPartition(partitionName); err == nil {
+		t.Fatalf("expecting non-nil error when the detached partition already exists")
+	}
+	if err := s1.AttachPartition(partitionName); err != nil {
+		t.Fatalf("cannot attach partition: %s", err)
+	}
+	verifyDetachedPartitions(s1, nil)
+	verifyResults(s1, []string{"a-0=120", "a-1=120", "a-2=120"})
+
+	// Attach the partition from s1 to s2 with distinct streams for the same day
+	addRows(s2, "b", oldTimestamp, 60)
+	if err := s1.DetachPartition(partitionName); err != nil {
+		t.Fatalf("cannot detach partition: %s", err)
+	}
+	moveDetachedPartition(s1, s2)
+	verifyDetachedPartitions(s1, nil)
+	verifyDetachedPartitions(s2, []string{partitionName})
+	if err := s2.AttachPartition(partitionName); err != nil {
+		t.Fatalf("cannot attach partition: %s", err)
+	}
+	resultsExpected := []string{"a-0=110", "a-1=110", "a-2=110", "b-0=20", "b-1=20", "b-2=20"}
+	verifyResults(s2, resultsExpected)
+	verifyResults(s1, []string{"a-0=10", "a-1=10", "a-2=10"})
+
+	// The attached data must be preserved after the restart
+	s2.MustClose()
+	s2 = MustOpenStorage(s2Path, sc)
+	verifyResults(s2, resultsExpected)
+
+	// Move the merged partition from s2 to s3 without a partition for the same day
+	if err := s2.DetachPartition(partitionName); err != nil {
+		t.Fatalf("cannot detach partition: %s", err)
+	}
+	moveDetachedPartition(s2, s3)
+	if err := s3.AttachPartition(partitionName); err != nil {
+		t.Fatalf("cannot attach partition: %s", err)
+	}
+	verifyResults(s3, resultsExpected)
+	verifyResults(s2, nil)
+
+	// Delete tasks created after attaching the partition must be applied to the attached data
+	if _, err := s3.DeleteRows([]TenantID{tenantID}, mustParseQuery("host:a-1")); err != nil {
+		t.Fatalf("cannot delete rows: %s", err)
+	}
+	s3.startMergesForDeleteTasks()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		s3.removeFinishedDeleteTasks()
+		if len(s3.GetDeleteTasks()) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout when waiting for the delete task completion")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	verifyResults(s3, []string{"a-0=110", "a-2=110", "b-0=20", "b-1=20", "b-2=20"})
+
+	// Invalid partitions must be rejected
+	f := func(s *Storage, name, errExpected string) {
+		t.Helper()
+
+		err := s.AttachPartition(name)
+		if err == nil {
+			t.Fatalf("expecting non-nil error when attaching partition %q", name)
+		}
+		if !strings.Contains(err.Error(), errExpected) {
+			t.Fatalf("unexpected error when attaching partition %q; got %q; want an error containing %q", name, err, errExpected)
+		}
+	}
+	f(s3, "2024-01-01", "it must be in the form YYYYMMDD")
+	f(s3, partitionName, "missing")
+	f(s3, time.Unix(0, time.Now().UnixNano()-100*nsecPerDay).UTC().Format(partitionNameFormat), "outside the retention")
+
+	// Parts with unsupported format mustn't be attached
+	if err := s3.DetachPartition(partitionName); err != nil {
+		t.Fatalf("cannot detach partition: %s", err)
+	}
+	datadbPath := filepath.Join(s3.path, detachedDirname, partitionName, datadbDirname)
+	partNames := mustReadPartNames(datadbPath)
+	if len(partNames) == 0 {
+		t.Fatalf("expecting non-empty list of parts at the detached partition")
+	}
+	partPath := filepath.Join(datadbPath, partNames[0])
+	var ph partHeader
+	ph.mustReadMetadata(partPath)
+	ph.FormatVersion = partFormatLatestVersion + 1
+	fs.MustRemoveAll(filepath.Join(partPath, metadataFilename))
+	ph.mustWriteMetadata(partPath)
+	f(s3, partitionName, "unsupported part format version")
+	verifyDetachedPartitions(s3, []string{partitionName})
+
+	s1.MustClose()
+	s2.MustClose()
+	s3.MustClose()
+
+	fs.MustRemoveAll(path)
+}
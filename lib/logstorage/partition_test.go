@@ -46,7 +46,7 @@ func TestPartitionLifecycle(t *testing.T) {
 			time.Sleep(10 * time.Millisecond)
 			mustClosePartition(pt)
 		}
-		mustDeletePartition(path)
+		mustDeletePartition(path, nil)
 	}
 	closeTestStorage(s)
 }
@@ -130,7 +130,7 @@ func TestPartitionMustAddRowsSerial(t *testing.T) {
 	}
 
 	mustClosePartition(pt)
-	mustDeletePartition(path)
+	mustDeletePartition(path, nil)
 
 	closeTestStorage(s)
 }
@@ -174,7 +174,7 @@ func TestPartitionMustAddRowsConcurrent(t *testing.T) {
 	}
 
 	mustClosePartition(pt)
-	mustDeletePartition(path)
+	mustDeletePartition(path, nil)
 
 	closeTestStorage(s)
 }
@@ -189,6 +189,8 @@ func newTestStorage() *Storage {
 		flushInterval:     time.Second,
 		streamIDCache:     streamIDCache,
 		filterStreamCache: filterStreamCache,
+
+		ingestedTenantStats: make(map[TenantID]*ingestedTenantStats),
 	}
 }
 
@@ -159,6 +159,10 @@ func tryUnquoteString(s, opt string) (string, int) {
 	if err != nil {
 		return "", -1
 	}
+	if strings.IndexByte(qp, '\\') < 0 && strings.IndexByte(qp, '\r') < 0 {
+		// Fast path - the quoted string has no escape sequences, so return its contents without memory allocation.
+		return qp[1 : len(qp)-1], len(qp)
+	}
 	us, err := strconv.Unquote(qp)
 	if err != nil {
 		return "", -1
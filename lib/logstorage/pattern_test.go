@@ -63,11 +63,49 @@ func TestPatternApply(t *testing.T) {
 	f(`foo=<bar> `, "foo=`bar baz,abc` def", []string{"bar baz,abc"})
 	f(`<foo>`, `"foo,\"bar"`, []string{`foo,"bar`})
 	f(`<foo>,"bar`, `"foo,\"bar"`, []string{`foo,"bar`})
+	f(`<foo>,"bar`, `"foo,bar",bar`, []string{`foo,bar`})
+	f(`<foo> <bar>`, "`foo\\bar` \"abc\"", []string{`foo\bar`, `abc`})
 
 	// disable automatic unquoting of quoted field
 	f(`[<plain:foo>]`, `["foo","bar"]`, []string{`"foo","bar"`})
 }
 
+func TestTryUnquoteString(t *testing.T) {
+	f := func(s, opt, resultExpected string, nOffsetExpected int) {
+		t.Helper()
+
+		result, nOffset := tryUnquoteString(s, opt)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for tryUnquoteString(%q); got %q; want %q", s, result, resultExpected)
+		}
+		if nOffset != nOffsetExpected {
+			t.Fatalf("unexpected offset for tryUnquoteString(%q); got %d; want %d", s, nOffset, nOffsetExpected)
+		}
+	}
+
+	// non-quoted string
+	f("", "", "", -1)
+	f("foo", "", "", -1)
+	f(`'foo'`, "", "", -1)
+
+	// plain option disables unquoting
+	f(`"foo"`, "plain", "", -1)
+
+	// invalid quoted string
+	f(`"foo`, "", "", -1)
+
+	// quoted string without escape sequences
+	f(`""`, "", "", 2)
+	f(`"foo" bar`, "", "foo", 5)
+	f("`foo` bar", "", "foo", 5)
+	f(`"фу"`, "", "фу", len(`"фу"`))
+
+	// quoted string with escape sequences
+	f(`"foo\"bar" baz`, "", `foo"bar`, 10)
+	f(`"a\n\tb"`, "", "a\n\tb", 8)
+	f("`foo\r\nbar`", "", "foo\nbar", 10)
+}
+
 func TestParsePatternFailure(t *testing.T) {
 	f := func(patternStr string) {
 		t.Helper()
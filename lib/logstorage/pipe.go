@@ -2,6 +2,10 @@ package logstorage
 
 import (
 	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 )
 
 type pipe interface {
@@ -14,7 +18,7 @@ type pipe interface {
 	canLiveTail() bool
 
 	// updateNeededFields must update neededFields and unneededFields with fields it needs and not needs at the input.
-	updateNeededFields(neededFields, unneededFields fieldsSet)
+	updateNeededFields(neededFields, unneededFields *fieldsSet)
 
 	// newPipeProcessor must return new pipeProcessor, which writes data to the given ppNext.
 	//
@@ -24,7 +28,9 @@ type pipe interface {
 	// It is OK to continue processing pipeProcessor calls if they take less than a few milliseconds.
 	//
 	// The returned pipeProcessor may call cancel() at any time in order to notify the caller to stop sending new data to it.
-	newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor) pipeProcessor
+	//
+	// mb is the memory budget shared among all the pipes of the query. Pipes with state must account their state size at mb.
+	newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor, mb *memoryBudget) pipeProcessor
 
 	// optimize must optimize the pipe
 	optimize()
@@ -53,6 +59,12 @@ type pipeProcessor interface {
 	// to stop sending new data. The occurred error must be returned from flush().
 	//
 	// cancel() may be called also when the pipeProcessor decides to stop accepting new data, even if there is no any error.
+	//
+	// Stateless pipeProcessor shouldn't allocate memory per each writeBlock call. Scratch buffers needed for processing the block
+	// must be stored in the per-worker shard selected by workerID and must be re-used between writeBlock calls.
+	// For example, arena, resultColumn, bitmap and blockResult can be reset after passing the block to the next pipeProcessor.
+	// Temporary objects needed outside the per-worker shard can be obtained via getBitmap(), getArena() and getBlockResult().
+	// See BenchmarkPipeWriteBlock and TestPipeWriteBlockNoAllocs.
 	writeBlock(workerID uint, br *blockResult)
 
 	// flush must flush all the data accumulated in the pipeProcessor to the next pipeProcessor.
@@ -98,229 +110,304 @@ func parsePipes(lex *lexer) ([]pipe, error) {
 }
 
 func parsePipe(lex *lexer) (pipe, error) {
-	switch {
-	case lex.isKeyword("copy", "cp"):
-		pc, err := parsePipeCopy(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'copy' pipe: %w", err)
-		}
-		return pc, nil
-	case lex.isKeyword("delete", "del", "rm", "drop"):
-		pd, err := parsePipeDelete(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'delete' pipe: %w", err)
-		}
-		return pd, nil
-	case lex.isKeyword("drop_empty_fields"):
-		pd, err := parsePipeDropEmptyFields(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'drop_empty_fields' pipe: %w", err)
-		}
-		return pd, nil
-	case lex.isKeyword("extract"):
-		pe, err := parsePipeExtract(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'extract' pipe: %w", err)
-		}
-		return pe, nil
-	case lex.isKeyword("extract_regexp"):
-		pe, err := parsePipeExtractRegexp(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'extract_regexp' pipe: %w", err)
-		}
-		return pe, nil
-	case lex.isKeyword("field_names"):
-		pf, err := parsePipeFieldNames(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'field_names' pipe: %w", err)
-		}
-		return pf, nil
-	case lex.isKeyword("field_values"):
-		pf, err := parsePipeFieldValues(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot pase 'field_values' pipe: %w", err)
-		}
-		return pf, nil
-	case lex.isKeyword("fields", "keep"):
-		pf, err := parsePipeFields(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'fields' pipe: %w", err)
-		}
-		return pf, nil
-	case lex.isKeyword("filter", "where"):
-		pf, err := parsePipeFilter(lex, true)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'filter' pipe: %w", err)
-		}
-		return pf, nil
-	case lex.isKeyword("format"):
-		pf, err := parsePipeFormat(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'format' pipe: %w", err)
-		}
-		return pf, nil
-	case lex.isKeyword("limit", "head"):
-		pl, err := parsePipeLimit(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'limit' pipe: %w", err)
-		}
-		return pl, nil
-	case lex.isKeyword("math", "eval"):
-		pm, err := parsePipeMath(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'math' pipe: %w", err)
-		}
-		return pm, nil
-	case lex.isKeyword("offset", "skip"):
-		ps, err := parsePipeOffset(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'offset' pipe: %w", err)
-		}
-		return ps, nil
-	case lex.isKeyword("pack_json"):
-		pp, err := parsePackJSON(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'pack_json' pipe: %w", err)
-		}
-		return pp, nil
-	case lex.isKeyword("pack_logfmt"):
-		pp, err := parsePackLogfmt(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'pack_logfmt' pipe: %w", err)
-		}
-		return pp, nil
-	case lex.isKeyword("rename", "mv"):
-		pr, err := parsePipeRename(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'rename' pipe: %w", err)
-		}
-		return pr, nil
-	case lex.isKeyword("replace"):
-		pr, err := parsePipeReplace(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'replace' pipe: %w", err)
-		}
-		return pr, nil
-	case lex.isKeyword("replace_regexp"):
-		pr, err := parsePipeReplaceRegexp(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'replace_regexp' pipe: %w", err)
-		}
-		return pr, nil
-	case lex.isKeyword("sort"):
-		ps, err := parsePipeSort(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'sort' pipe: %w", err)
-		}
-		return ps, nil
-	case lex.isKeyword("stats"):
-		ps, err := parsePipeStats(lex, true)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'stats' pipe: %w", err)
+	if !lex.isQuotedToken() {
+		pp, ok := getPipeParsers()[strings.ToLower(lex.token)]
+		if ok {
+			p, err := pp.parse(lex)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse '%s' pipe: %w", pp.names[0], err)
+			}
+			return p, nil
 		}
+	}
+
+	lexState := lex.backupState()
+
+	// Try parsing stats pipe without 'stats' keyword
+	ps, err := parsePipeStats(lex, false)
+	if err == nil {
 		return ps, nil
-	case lex.isKeyword("stream_context"):
-		pc, err := parsePipeStreamContext(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'stream_context' pipe: %w", err)
-		}
-		return pc, nil
-	case lex.isKeyword("top"):
-		pt, err := parsePipeTop(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'top' pipe: %w", err)
-		}
-		return pt, nil
-	case lex.isKeyword("uniq"):
-		pu, err := parsePipeUniq(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'uniq' pipe: %w", err)
-		}
-		return pu, nil
-	case lex.isKeyword("unpack_json"):
-		pu, err := parsePipeUnpackJSON(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'unpack_json' pipe: %w", err)
-		}
-		return pu, nil
-	case lex.isKeyword("unpack_logfmt"):
-		pu, err := parsePipeUnpackLogfmt(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'unpack_logfmt' pipe: %w", err)
-		}
-		return pu, nil
-	case lex.isKeyword("unpack_syslog"):
-		pu, err := parsePipeUnpackSyslog(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'unpack_syslog' pipe: %w", err)
-		}
-		return pu, nil
-	case lex.isKeyword("unroll"):
-		pu, err := parsePipeUnroll(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'unroll' pipe: %w", err)
-		}
-		return pu, nil
-	default:
-		lexState := lex.backupState()
+	}
+	lex.restoreState(lexState)
 
-		// Try parsing stats pipe without 'stats' keyword
-		ps, err := parsePipeStats(lex, false)
-		if err == nil {
-			return ps, nil
-		}
-		lex.restoreState(lexState)
+	// Try parsing filter pipe without 'filter' keyword
+	pf, err := parsePipeFilter(lex, false)
+	if err == nil {
+		return pf, nil
+	}
+	lex.restoreState(lexState)
 
-		// Try parsing filter pipe without 'filter' keyword
-		pf, err := parsePipeFilter(lex, false)
-		if err == nil {
-			return pf, nil
-		}
-		lex.restoreState(lexState)
+	return nil, fmt.Errorf("unexpected pipe %q", lex.token)
+}
+
+// pipeParser is a parser for the pipe with the given names.
+type pipeParser struct {
+	// names contains the pipe name followed by its aliases.
+	names []string
 
-		return nil, fmt.Errorf("unexpected pipe %q", lex.token)
+	// parse must parse the pipe starting from the pipe name at lex.
+	parse func(lex *lexer) (pipe, error)
+}
+
+// allPipeParsers returns parsers for all the supported pipes.
+//
+// New builtin pipes must be added here, so they are recognized by parsePipe and isPipeName.
+// Pipes implemented outside the logstorage package must be registered via RegisterPipe.
+func allPipeParsers() []pipeParser {
+	return []pipeParser{
+		{
+			names: []string{"block_stats"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeBlockStats(lex)
+			},
+		},
+		{
+			names: []string{"blocks_count"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeBlocksCount(lex)
+			},
+		},
+		{
+			names: []string{"copy", "cp"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeCopy(lex)
+			},
+		},
+		{
+			names: []string{"delete", "del", "rm", "drop"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeDelete(lex)
+			},
+		},
+		{
+			names: []string{"drop_empty_fields"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeDropEmptyFields(lex)
+			},
+		},
+		{
+			names: []string{"extract"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeExtract(lex)
+			},
+		},
+		{
+			names: []string{"extract_regexp"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeExtractRegexp(lex)
+			},
+		},
+		{
+			names: []string{"facets"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeFacets(lex)
+			},
+		},
+		{
+			names: []string{"field_names"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeFieldNames(lex)
+			},
+		},
+		{
+			names: []string{"field_values"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeFieldValues(lex)
+			},
+		},
+		{
+			names: []string{"fields", "keep"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeFields(lex)
+			},
+		},
+		{
+			names: []string{"filter", "where"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeFilter(lex, true)
+			},
+		},
+		{
+			names: []string{"first"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeFirst(lex)
+			},
+		},
+		{
+			names: []string{"format"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeFormat(lex)
+			},
+		},
+		{
+			names: []string{"join"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeJoin(lex)
+			},
+		},
+		{
+			names: []string{"last"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeLast(lex)
+			},
+		},
+		{
+			names: []string{"limit", "head"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeLimit(lex)
+			},
+		},
+		{
+			names: []string{"math", "eval"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeMath(lex)
+			},
+		},
+		{
+			names: []string{"offset", "skip"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeOffset(lex)
+			},
+		},
+		{
+			names: []string{"pack_json"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePackJSON(lex)
+			},
+		},
+		{
+			names: []string{"pack_logfmt"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePackLogfmt(lex)
+			},
+		},
+		{
+			names: []string{"rename", "mv"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeRename(lex)
+			},
+		},
+		{
+			names: []string{"replace"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeReplace(lex)
+			},
+		},
+		{
+			names: []string{"replace_regexp"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeReplaceRegexp(lex)
+			},
+		},
+		{
+			names: []string{"sample"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeSample(lex)
+			},
+		},
+		{
+			names: []string{"sort"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeSort(lex)
+			},
+		},
+		{
+			names: []string{"stats"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeStats(lex, true)
+			},
+		},
+		{
+			names: []string{"stream_context"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeStreamContext(lex)
+			},
+		},
+		{
+			names: []string{"top"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeTop(lex)
+			},
+		},
+		{
+			names: []string{"union"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeUnion(lex)
+			},
+		},
+		{
+			names: []string{"uniq"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeUniq(lex)
+			},
+		},
+		{
+			names: []string{"unpack_json"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeUnpackJSON(lex)
+			},
+		},
+		{
+			names: []string{"unpack_logfmt"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeUnpackLogfmt(lex)
+			},
+		},
+		{
+			names: []string{"unpack_syslog"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeUnpackSyslog(lex)
+			},
+		},
+		{
+			names: []string{"unroll"},
+			parse: func(lex *lexer) (pipe, error) {
+				return parsePipeUnroll(lex)
+			},
+		},
 	}
 }
 
-var pipeNames = func() map[string]struct{} {
-	a := []string{
-		"copy", "cp",
-		"delete", "del", "rm", "drop",
-		"drop_empty_fields",
-		"extract",
-		"extract_regexp",
-		"field_names",
-		"field_values",
-		"fields", "keep",
-		"filter", "where",
-		"format",
-		"limit", "head",
-		"math", "eval",
-		"offset", "skip",
-		"pack_json",
-		"pack_logmft",
-		"rename", "mv",
-		"replace",
-		"replace_regexp",
-		"sort",
-		"stats",
-		"stream_context",
-		"top",
-		"uniq",
-		"unpack_json",
-		"unpack_logfmt",
-		"unpack_syslog",
-		"unroll",
+// getPipeParsers returns parsers for all the supported pipes keyed by pipe names and aliases.
+//
+// The parsers are initialized lazily in order to avoid initialization loop,
+// since some pipe parsers refer to parsePipe via parseQuery.
+func getPipeParsers() map[string]*pipeParser {
+	pipeParsersOnce.Do(initPipeParsers)
+	return pipeParsers
+}
+
+var (
+	pipeParsers     map[string]*pipeParser
+	pipeParsersOnce sync.Once
+)
+
+func initPipeParsers() {
+	pps := allPipeParsers()
+	m := make(map[string]*pipeParser, len(pps))
+	for i := range pps {
+		addPipeParser(m, &pps[i])
 	}
+	pipeParsers = m
+}
 
-	m := make(map[string]struct{}, len(a))
-	for _, s := range a {
-		m[s] = struct{}{}
+// addPipeParser adds pp to m under all the pp names.
+func addPipeParser(m map[string]*pipeParser, pp *pipeParser) {
+	for _, name := range pp.names {
+		if _, ok := m[name]; ok {
+			logger.Panicf("BUG: duplicate pipe name %q", name)
+		}
+		m[name] = pp
 	}
+}
 
-	// add stats names here, since they can be used without the initial `stats` keyword
-	for _, s := range statsNames {
-		m[s] = struct{}{}
+// isPipeName returns true if s is a pipe name.
+//
+// Stats function names are also treated as pipe names, since they can be used without the initial `stats` keyword.
+func isPipeName(s string) bool {
+	sLower := strings.ToLower(s)
+	if _, ok := getPipeParsers()[sLower]; ok {
+		return true
 	}
-	return m
-}()
+	return isStatsFuncName(sLower)
+}
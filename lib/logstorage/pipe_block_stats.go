@@ -0,0 +1,240 @@
+package logstorage
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+)
+
+// pipeBlockStats processes '| block_stats' pipe.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#block_stats-pipe
+type pipeBlockStats struct {
+}
+
+func (ps *pipeBlockStats) String() string {
+	return "block_stats"
+}
+
+func (ps *pipeBlockStats) canLiveTail() bool {
+	return false
+}
+
+func (ps *pipeBlockStats) updateNeededFields(neededFields, unneededFields *fieldsSet) {
+	// The stats are obtained from block headers, so there is no need in reading any columns.
+	neededFields.reset()
+	unneededFields.reset()
+}
+
+func (ps *pipeBlockStats) optimize() {
+	// nothing to do
+}
+
+func (ps *pipeBlockStats) hasFilterInWithQuery() bool {
+	return false
+}
+
+func (ps *pipeBlockStats) initFilterInValues(_ map[string][]string, _ getFieldValuesFunc) (pipe, error) {
+	return ps, nil
+}
+
+func (ps *pipeBlockStats) newPipeProcessor(workersCount int, stopCh <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
+	shards := make([]pipeBlockStatsProcessorShard, workersCount)
+	for i := range shards {
+		shards[i].wctx.init(uint(i), ppNext)
+	}
+
+	psp := &pipeBlockStatsProcessor{
+		stopCh: stopCh,
+
+		shards: shards,
+	}
+	return psp
+}
+
+type pipeBlockStatsProcessor struct {
+	stopCh <-chan struct{}
+
+	shards []pipeBlockStatsProcessorShard
+}
+
+type pipeBlockStatsProcessorShard struct {
+	pipeBlockStatsProcessorShardNopad
+
+	// The padding prevents false sharing on widespread platforms with 128 mod (cache line size) = 0 .
+	_ [128 - unsafe.Sizeof(pipeBlockStatsProcessorShardNopad{})%128]byte
+}
+
+type pipeBlockStatsProcessorShardNopad struct {
+	wctx pipeBlockStatsWriteContext
+}
+
+func (psp *pipeBlockStatsProcessor) writeBlock(workerID uint, br *blockResult) {
+	if len(br.timestamps) == 0 {
+		return
+	}
+
+	shard := &psp.shards[workerID]
+
+	bs := br.bs
+	if bs == nil {
+		// The block has been generated by the previous pipes, so it has no storage stats.
+		// Return the stats, which can be obtained from br, and leave the rest empty.
+		cs := br.getColumns()
+		constColumns := 0
+		for _, c := range cs {
+			if c.isConst {
+				constColumns++
+			}
+		}
+		rowsLen := uint64(len(br.timestamps))
+		shard.wctx.writeRow(blockStatsRow{
+			rows:         rowsLen,
+			matchingRows: rowsLen,
+			columns:      uint64(len(cs)),
+			constColumns: uint64(constColumns),
+		})
+		return
+	}
+
+	bh := &bs.bsw.bh
+	csh := &bs.csh
+
+	compressedSize := bh.timestampsHeader.blockSize + bh.columnsHeaderSize
+	for i := range csh.columnHeaders {
+		ch := &csh.columnHeaders[i]
+		compressedSize += ch.valuesSize + ch.bloomFilterSize
+	}
+
+	shard.wctx.writeRow(blockStatsRow{
+		isStorageBlock:        true,
+		partPath:              bs.bsw.p.path,
+		rows:                  bh.rowsCount,
+		matchingRows:          uint64(len(br.timestamps)),
+		columns:               uint64(len(csh.columnHeaders)),
+		constColumns:          uint64(len(csh.constColumns)),
+		uncompressedSizeBytes: bh.uncompressedSizeBytes,
+		compressedSizeBytes:   compressedSize,
+	})
+}
+
+func (psp *pipeBlockStatsProcessor) flush() error {
+	if needStop(psp.stopCh) {
+		return nil
+	}
+
+	for i := range psp.shards {
+		psp.shards[i].wctx.flush()
+	}
+	return nil
+}
+
+// blockStatsRow contains stats for a single block returned by block_stats pipe.
+type blockStatsRow struct {
+	// isStorageBlock is set to false for blocks generated by pipes. Such blocks have no part path and sizes.
+	isStorageBlock bool
+
+	partPath              string
+	rows                  uint64
+	matchingRows          uint64
+	columns               uint64
+	constColumns          uint64
+	uncompressedSizeBytes uint64
+	compressedSizeBytes   uint64
+}
+
+type pipeBlockStatsWriteContext struct {
+	workerID uint
+	ppNext   pipeProcessor
+
+	a   arena
+	rcs [7]resultColumn
+	br  blockResult
+
+	// rowsCount is the number of rows in the current block
+	rowsCount int
+
+	// valuesLen is the total length of values in the current block
+	valuesLen int
+}
+
+func (wctx *pipeBlockStatsWriteContext) init(workerID uint, ppNext pipeProcessor) {
+	wctx.workerID = workerID
+	wctx.ppNext = ppNext
+
+	rcs := &wctx.rcs
+	rcs[0].name = "part_path"
+	rcs[1].name = "rows"
+	rcs[2].name = "matching_rows"
+	rcs[3].name = "columns"
+	rcs[4].name = "const_columns"
+	rcs[5].name = "uncompressed_size_bytes"
+	rcs[6].name = "compressed_size_bytes"
+}
+
+func (wctx *pipeBlockStatsWriteContext) writeRow(r blockStatsRow) {
+	rcs := &wctx.rcs
+
+	wctx.addValue(&rcs[0], r.partPath)
+	wctx.addUint64Value(&rcs[1], r.rows)
+	wctx.addUint64Value(&rcs[2], r.matchingRows)
+	wctx.addUint64Value(&rcs[3], r.columns)
+	wctx.addUint64Value(&rcs[4], r.constColumns)
+	if r.isStorageBlock {
+		wctx.addUint64Value(&rcs[5], r.uncompressedSizeBytes)
+		wctx.addUint64Value(&rcs[6], r.compressedSizeBytes)
+	} else {
+		wctx.addValue(&rcs[5], "")
+		wctx.addValue(&rcs[6], "")
+	}
+
+	wctx.rowsCount++
+	if wctx.valuesLen >= 1_000_000 {
+		wctx.flush()
+	}
+}
+
+func (wctx *pipeBlockStatsWriteContext) addValue(rc *resultColumn, v string) {
+	v = wctx.a.copyString(v)
+	rc.addValue(v)
+	wctx.valuesLen += len(v)
+}
+
+func (wctx *pipeBlockStatsWriteContext) addUint64Value(rc *resultColumn, n uint64) {
+	bLen := len(wctx.a.b)
+	wctx.a.b = marshalUint64String(wctx.a.b, n)
+	v := bytesutil.ToUnsafeString(wctx.a.b[bLen:])
+	rc.addValue(v)
+	wctx.valuesLen += len(v)
+}
+
+func (wctx *pipeBlockStatsWriteContext) flush() {
+	if wctx.rowsCount == 0 {
+		return
+	}
+
+	br := &wctx.br
+	rcs := wctx.rcs[:]
+
+	wctx.valuesLen = 0
+
+	// Flush rcs to ppNext
+	br.setResultColumns(rcs, wctx.rowsCount)
+	wctx.rowsCount = 0
+	wctx.ppNext.writeBlock(wctx.workerID, br)
+	br.reset()
+	for i := range rcs {
+		rcs[i].resetValues()
+	}
+	wctx.a.reset()
+}
+
+func parsePipeBlockStats(lex *lexer) (*pipeBlockStats, error) {
+	if !lex.isKeyword("block_stats") {
+		return nil, fmt.Errorf("expecting 'block_stats'; got %q", lex.token)
+	}
+	lex.nextToken()
+
+	return &pipeBlockStats{}, nil
+}
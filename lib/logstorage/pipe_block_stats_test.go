@@ -0,0 +1,69 @@
+package logstorage
+
+import (
+	"testing"
+)
+
+func TestParsePipeBlockStatsSuccess(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeSuccess(t, pipeStr)
+	}
+
+	f(`block_stats`)
+}
+
+func TestParsePipeBlockStatsFailure(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeFailure(t, pipeStr)
+	}
+
+	f(`block_stats foo`)
+	f(`block_stats(foo)`)
+}
+
+func TestPipeBlockStats(t *testing.T) {
+	f := func(pipeStr string, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeResultsSingleBlock(t, pipeStr, rows, rowsExpected)
+	}
+
+	// blocks generated by pipes have no storage stats
+	f("block_stats", [][]Field{
+		{
+			{"_msg", `foo`},
+			{"a", `test`},
+		},
+		{
+			{"_msg", `bar`},
+			{"a", `test`},
+		},
+	}, [][]Field{
+		{
+			{"part_path", ""},
+			{"rows", "2"},
+			{"matching_rows", "2"},
+			{"columns", "2"},
+			{"const_columns", "1"},
+			{"uncompressed_size_bytes", ""},
+			{"compressed_size_bytes", ""},
+		},
+	})
+}
+
+func TestPipeBlockStatsUpdateNeededFields(t *testing.T) {
+	f := func(s string, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected string) {
+		t.Helper()
+		expectPipeNeededFields(t, s, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected)
+	}
+
+	// all the needed fields
+	f("block_stats", "*", "", "", "")
+
+	// all the needed fields, unneeded fields
+	f("block_stats", "*", "s1,f1,f2", "", "")
+
+	// needed fields
+	f("block_stats", "f1,f2", "", "", "")
+}
@@ -0,0 +1,74 @@
+package logstorage
+
+import (
+	"testing"
+)
+
+func TestParsePipeBlocksCountSuccess(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeSuccess(t, pipeStr)
+	}
+
+	f(`blocks_count`)
+	f(`blocks_count as x`)
+}
+
+func TestParsePipeBlocksCountFailure(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeFailure(t, pipeStr)
+	}
+
+	f(`blocks_count(foo)`)
+	f(`blocks_count a b`)
+	f(`blocks_count as`)
+}
+
+func TestPipeBlocksCount(t *testing.T) {
+	f := func(pipeStr string, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeResultsSingleBlock(t, pipeStr, rows, rowsExpected)
+	}
+
+	f("blocks_count", [][]Field{
+		{
+			{"_msg", `foo`},
+			{"a", `test`},
+		},
+		{
+			{"_msg", `bar`},
+			{"a", `test`},
+		},
+	}, [][]Field{
+		{
+			{"blocks_count", "1"},
+		},
+	})
+
+	f("blocks_count as x", [][]Field{
+		{
+			{"a", `test`},
+		},
+	}, [][]Field{
+		{
+			{"x", "1"},
+		},
+	})
+}
+
+func TestPipeBlocksCountUpdateNeededFields(t *testing.T) {
+	f := func(s string, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected string) {
+		t.Helper()
+		expectPipeNeededFields(t, s, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected)
+	}
+
+	// all the needed fields
+	f("blocks_count as f1", "*", "", "", "")
+
+	// all the needed fields, unneeded fields intersect with src
+	f("blocks_count as f1", "*", "s1,f1,f2", "", "")
+
+	// needed fields
+	f("blocks_count as f3", "f1,f2", "", "", "")
+}
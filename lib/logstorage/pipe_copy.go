@@ -35,7 +35,7 @@ func (pc *pipeCopy) canLiveTail() bool {
 	return true
 }
 
-func (pc *pipeCopy) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pc *pipeCopy) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	for i := len(pc.srcFields) - 1; i >= 0; i-- {
 		srcField := pc.srcFields[i]
 		dstField := pc.dstFields[i]
@@ -66,7 +66,7 @@ func (pc *pipeCopy) initFilterInValues(_ map[string][]string, _ getFieldValuesFu
 	return pc, nil
 }
 
-func (pc *pipeCopy) newPipeProcessor(_ int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pc *pipeCopy) newPipeProcessor(_ int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	return &pipeCopyProcessor{
 		pc:     pc,
 		ppNext: ppNext,
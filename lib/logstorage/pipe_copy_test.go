@@ -103,6 +103,20 @@ func TestPipeCopy(t *testing.T) {
 		},
 	})
 
+	// copy non-existing field to itself
+	f("copy x as x", [][]Field{
+		{
+			{"_msg", `{"foo":"bar"}`},
+			{"a", `test`},
+		},
+	}, [][]Field{
+		{
+			{"_msg", `{"foo":"bar"}`},
+			{"a", `test`},
+			{"x", ``},
+		},
+	})
+
 	// swap copy
 	f("copy a as b, _msg as a, b as _msg", [][]Field{
 		{
@@ -239,7 +253,7 @@ func expectPipeNeededFields(t *testing.T, s, neededFields, unneededFields, neede
 	assertNeededFields(t, nfs, unfs, neededFieldsExpected, unneededFieldsExpected)
 }
 
-func assertNeededFields(t *testing.T, nfs, unfs fieldsSet, neededFieldsExpected, unneededFieldsExpected string) {
+func assertNeededFields(t *testing.T, nfs, unfs *fieldsSet, neededFieldsExpected, unneededFieldsExpected string) {
 	t.Helper()
 
 	nfsStr := nfs.String()
@@ -258,10 +272,10 @@ func assertNeededFields(t *testing.T, nfs, unfs fieldsSet, neededFieldsExpected,
 	}
 }
 
-func newTestFieldsSet(fields string) fieldsSet {
+func newTestFieldsSet(fields string) *fieldsSet {
 	fs := newFieldsSet()
 	if fields != "" {
-		fs.addFields(strings.Split(fields, ","))
+		fs.addPatterns(strings.Split(fields, ","))
 	}
 	return fs
 }
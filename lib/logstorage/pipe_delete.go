@@ -26,11 +26,11 @@ func (pd *pipeDelete) canLiveTail() bool {
 	return true
 }
 
-func (pd *pipeDelete) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pd *pipeDelete) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	if neededFields.contains("*") {
-		unneededFields.addFields(pd.fields)
+		unneededFields.addPatterns(pd.fields)
 	} else {
-		neededFields.removeFields(pd.fields)
+		neededFields.removePatterns(pd.fields)
 	}
 }
 
@@ -46,7 +46,7 @@ func (pd *pipeDelete) initFilterInValues(_ map[string][]string, _ getFieldValues
 	return pd, nil
 }
 
-func (pd *pipeDelete) newPipeProcessor(_ int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pd *pipeDelete) newPipeProcessor(_ int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	return &pipeDeleteProcessor{
 		pd:     pd,
 		ppNext: ppNext,
@@ -12,6 +12,9 @@ func TestParsePipeDeleteSuccess(t *testing.T) {
 
 	f(`delete f1`)
 	f(`delete f1, f2`)
+	f(`delete f*`)
+	f(`delete f1, foo.*`)
+	f(`delete "foo bar"*`)
 }
 
 func TestParsePipeDeleteFailure(t *testing.T) {
@@ -76,6 +79,22 @@ func TestPipeDelete(t *testing.T) {
 		},
 	})
 
+	// delete fields by wildcard
+	f("delete kubernetes.*, _msg", [][]Field{
+		{
+			{"_msg", `{"foo":"bar"}`},
+			{"kubernetes.pod", `x`},
+			{"kubernetes.namespace", `y`},
+			{"kubernetes", `z`},
+			{"a", `test`},
+		},
+	}, [][]Field{
+		{
+			{"kubernetes", `z`},
+			{"a", `test`},
+		},
+	})
+
 	// Multiple rows
 	f("delete _msg, a", [][]Field{
 		{
@@ -129,4 +148,16 @@ func TestPipeDeleteUpdateNeededFields(t *testing.T) {
 
 	// needed fields intersect with src
 	f("del s1,s2", "s1,f1,f2", "", "f1,f2", "")
+
+	// wildcard, all the needed fields
+	f("del s*", "*", "", "*", "s*")
+
+	// wildcard, all the needed fields, unneeded fields intersect with src
+	f("del s*", "*", "s1,f1,f2", "*", "f1,f2,s*")
+
+	// wildcard, needed fields do not intersect with src
+	f("del s*", "f1,f2", "", "f1,f2", "")
+
+	// wildcard, needed fields intersect with src
+	f("del s*", "s1,s2,f1,f2", "", "f1,f2", "")
 }
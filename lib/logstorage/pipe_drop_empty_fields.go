@@ -33,11 +33,11 @@ func (pd *pipeDropEmptyFields) initFilterInValues(_ map[string][]string, _ getFi
 	return pd, nil
 }
 
-func (pd *pipeDropEmptyFields) updateNeededFields(_, _ fieldsSet) {
+func (pd *pipeDropEmptyFields) updateNeededFields(_, _ *fieldsSet) {
 	// nothing to do
 }
 
-func (pd *pipeDropEmptyFields) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pd *pipeDropEmptyFields) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	return &pipeDropEmptyFieldsProcessor{
 		ppNext: ppNext,
 
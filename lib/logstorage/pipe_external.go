@@ -0,0 +1,250 @@
+package logstorage
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// Pipe is a pipe, which can be implemented outside the logstorage package and registered via RegisterPipe.
+//
+// The pipe receives all the fields of the logs returned by the previous pipes.
+type Pipe interface {
+	// String must return LogsQL representation of the pipe, which can be parsed back by the registered PipeParser.
+	String() string
+
+	// NewPipeProcessor must return new PipeProcessor, which passes the processed logs to writeBlock.
+	//
+	// workersCount is the number of goroutine workers, which call PipeProcessor.WriteBlock().
+	//
+	// If stopCh is closed, the returned PipeProcessor must stop performing CPU-intensive tasks which take more than a few milliseconds.
+	//
+	// The returned PipeProcessor may call cancel() at any time in order to notify the caller to stop sending new data to it.
+	//
+	// writeBlock must be called with the workerID passed to PipeProcessor.WriteBlock() from the goroutine calling it.
+	// It may be called with workerID=0 from PipeProcessor.Flush(). writeBlock doesn't hold references to columns after returning.
+	NewPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), writeBlock PipeWriteBlockFunc) PipeProcessor
+}
+
+// PipeWriteBlockFunc must process a block with rowsCount logs with the given columns.
+//
+// Every column must contain rowsCount values.
+type PipeWriteBlockFunc func(workerID uint, rowsCount int, columns []BlockColumn)
+
+// PipeProcessor processes logs for the Pipe.
+type PipeProcessor interface {
+	// WriteBlock must process the block with rowsCount logs with the given columns.
+	//
+	// WriteBlock is called concurrently from worker goroutines. The workerID is in the range 0 ... workersCount-1 .
+	//
+	// WriteBlock cannot hold references to columns after returning, since the caller may re-use them.
+	WriteBlock(workerID uint, rowsCount int, columns []BlockColumn)
+
+	// Flush must flush all the data accumulated in the PipeProcessor.
+	//
+	// Flush is called after all the worker goroutines are stopped.
+	Flush() error
+}
+
+// PipeParser must parse the pipe starting from the pipe name at pl.
+//
+// The parser must stop at the first token after the pipe, e.g. when pl.IsPipeEnd() returns true.
+type PipeParser func(pl *PipeLexer) (Pipe, error)
+
+// PipeLexer provides access to LogsQL tokens for parsers registered via RegisterPipe and RegisterStatsFunc.
+type PipeLexer struct {
+	lex *lexer
+}
+
+// Token returns the current token. Quoted tokens are returned unquoted.
+//
+// An empty token means the end of the query.
+func (pl *PipeLexer) Token() string {
+	return pl.lex.token
+}
+
+// NextToken moves pl to the next token.
+func (pl *PipeLexer) NextToken() {
+	pl.lex.nextToken()
+}
+
+// IsKeyword returns true if the current token is unquoted and it equals to any of the given lowercase keywords case-insensitively.
+func (pl *PipeLexer) IsKeyword(keywords ...string) bool {
+	return pl.lex.isKeyword(keywords...)
+}
+
+// IsPipeEnd returns true if the current token ends the pipe, e.g. it is '|', ')' or the end of the query.
+func (pl *PipeLexer) IsPipeEnd() bool {
+	return pl.lex.isKeyword("|", ")", "")
+}
+
+// RegisterPipe registers the pipe with the given name, which is parsed by parse.
+//
+// The name is case-insensitive. It mustn't clash with the names of the builtin pipes, stats functions and other registered pipes.
+//
+// RegisterPipe must be called before parsing queries, e.g. from init() functions. The pipe must be registered
+// at all the VictoriaLogs nodes, which may receive queries with it.
+func RegisterPipe(name string, parse PipeParser) {
+	name = strings.ToLower(name)
+	if !isRegisteredFuncName(name) {
+		logger.Panicf("BUG: pipe name %q must be a single unquoted LogsQL token", name)
+	}
+	if isStatsFuncName(name) {
+		logger.Panicf("BUG: pipe name %q clashes with stats func name", name)
+	}
+
+	pp := &pipeParser{
+		names: []string{name},
+		parse: func(lex *lexer) (pipe, error) {
+			p, err := parse(&PipeLexer{lex: lex})
+			if err != nil {
+				return nil, err
+			}
+			return &pipeExternal{p: p}, nil
+		},
+	}
+	addPipeParser(getPipeParsers(), pp)
+}
+
+// isRegisteredFuncName returns true if name can be used as a name for pipes and stats functions registered outside the logstorage package.
+func isRegisteredFuncName(name string) bool {
+	if name == "" {
+		return false
+	}
+	lex := newLexer(name)
+	if lex.isQuotedToken() || lex.token != name {
+		return false
+	}
+	lex.nextToken()
+	return lex.isEnd()
+}
+
+// pipeExternal is a pipe registered via RegisterPipe.
+type pipeExternal struct {
+	p Pipe
+}
+
+func (pe *pipeExternal) String() string {
+	return pe.p.String()
+}
+
+func (pe *pipeExternal) canLiveTail() bool {
+	return false
+}
+
+func (pe *pipeExternal) updateNeededFields(neededFields, unneededFields *fieldsSet) {
+	// The fields needed by the pipe are unknown, so pass all the fields to it.
+	neededFields.reset()
+	neededFields.add("*")
+	unneededFields.reset()
+}
+
+func (pe *pipeExternal) optimize() {
+	// nothing to do
+}
+
+func (pe *pipeExternal) hasFilterInWithQuery() bool {
+	return false
+}
+
+func (pe *pipeExternal) initFilterInValues(_ map[string][]string, _ getFieldValuesFunc) (pipe, error) {
+	return pe, nil
+}
+
+func (pe *pipeExternal) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
+	pep := &pipeExternalProcessor{
+		pe:     pe,
+		ppNext: ppNext,
+
+		shards: make([]pipeExternalProcessorShard, workersCount),
+	}
+	pep.pp = pe.p.NewPipeProcessor(workersCount, stopCh, cancel, pep.writeOutputBlock)
+	return pep
+}
+
+type pipeExternalProcessor struct {
+	pe     *pipeExternal
+	pp     PipeProcessor
+	ppNext pipeProcessor
+
+	shards []pipeExternalProcessorShard
+}
+
+type pipeExternalProcessorShard struct {
+	pipeExternalProcessorShardNopad
+
+	// The padding prevents false sharing on widespread platforms with 128 mod (cache line size) = 0 .
+	_ [128 - unsafe.Sizeof(pipeExternalProcessorShardNopad{})%128]byte
+}
+
+type pipeExternalProcessorShardNopad struct {
+	// columns is used for passing the input blocks to the PipeProcessor.
+	columns []BlockColumn
+
+	// rcs and br are used for passing the blocks from the PipeProcessor to the next pipe.
+	rcs []resultColumn
+	br  blockResult
+}
+
+func (pep *pipeExternalProcessor) writeBlock(workerID uint, br *blockResult) {
+	if len(br.timestamps) == 0 {
+		return
+	}
+
+	shard := &pep.shards[workerID]
+
+	columns := shard.columns[:0]
+	for _, c := range br.getColumns() {
+		columns = append(columns, BlockColumn{
+			Name:   c.name,
+			Values: c.getValues(br),
+		})
+	}
+	shard.columns = columns
+
+	pep.pp.WriteBlock(workerID, len(br.timestamps), columns)
+
+	for i := range columns {
+		columns[i].reset()
+	}
+}
+
+func (pep *pipeExternalProcessor) writeOutputBlock(workerID uint, rowsCount int, columns []BlockColumn) {
+	if workerID >= uint(len(pep.shards)) {
+		logger.Panicf("BUG: unexpected workerID=%d passed by [%s] pipe; it must be smaller than %d", workerID, pep.pe, len(pep.shards))
+	}
+	if rowsCount == 0 {
+		return
+	}
+
+	shard := &pep.shards[workerID]
+
+	rcs := shard.rcs[:0]
+	for _, c := range columns {
+		if len(c.Values) != rowsCount {
+			logger.Panicf("BUG: column %q passed by [%s] pipe must contain %d values; got %d values", c.Name, pep.pe, rowsCount, len(c.Values))
+		}
+		rcs = append(rcs, resultColumn{
+			name:   c.Name,
+			values: c.Values,
+		})
+	}
+	shard.rcs = rcs
+
+	br := &shard.br
+	br.setResultColumns(rcs, rowsCount)
+	pep.ppNext.writeBlock(workerID, br)
+	br.reset()
+
+	// Do not call resetValues(), since the values belong to the caller.
+	clear(rcs)
+}
+
+func (pep *pipeExternalProcessor) flush() error {
+	if err := pep.pp.Flush(); err != nil {
+		return fmt.Errorf("cannot flush [%s] pipe: %w", pep.pe, err)
+	}
+	return nil
+}
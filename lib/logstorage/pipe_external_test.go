@@ -0,0 +1,201 @@
+package logstorage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// testPipeUpper converts the values of the given field to upper case.
+//
+// It uses only the exported API, like the pipes implemented outside the logstorage package.
+type testPipeUpper struct {
+	field string
+}
+
+func (pu *testPipeUpper) String() string {
+	return "test_upper " + pu.field
+}
+
+func (pu *testPipeUpper) NewPipeProcessor(_ int, _ <-chan struct{}, _ func(), writeBlock PipeWriteBlockFunc) PipeProcessor {
+	return &testPipeUpperProcessor{
+		pu:         pu,
+		writeBlock: writeBlock,
+	}
+}
+
+type testPipeUpperProcessor struct {
+	pu         *testPipeUpper
+	writeBlock PipeWriteBlockFunc
+}
+
+func (pup *testPipeUpperProcessor) WriteBlock(workerID uint, rowsCount int, columns []BlockColumn) {
+	columnsNew := make([]BlockColumn, len(columns))
+	for i, c := range columns {
+		columnsNew[i] = c
+		if c.Name != pup.pu.field {
+			continue
+		}
+		values := make([]string, len(c.Values))
+		for j, v := range c.Values {
+			values[j] = strings.ToUpper(v)
+		}
+		columnsNew[i].Values = values
+	}
+	pup.writeBlock(workerID, rowsCount, columnsNew)
+}
+
+func (pup *testPipeUpperProcessor) Flush() error {
+	return nil
+}
+
+// testPipeRows returns the number of input rows.
+type testPipeRows struct{}
+
+func (pr *testPipeRows) String() string {
+	return "test_rows"
+}
+
+func (pr *testPipeRows) NewPipeProcessor(_ int, _ <-chan struct{}, _ func(), writeBlock PipeWriteBlockFunc) PipeProcessor {
+	return &testPipeRowsProcessor{
+		writeBlock: writeBlock,
+	}
+}
+
+type testPipeRowsProcessor struct {
+	writeBlock PipeWriteBlockFunc
+	rows       atomic.Int64
+}
+
+func (prp *testPipeRowsProcessor) WriteBlock(_ uint, rowsCount int, _ []BlockColumn) {
+	prp.rows.Add(int64(rowsCount))
+}
+
+func (prp *testPipeRowsProcessor) Flush() error {
+	prp.writeBlock(0, 1, []BlockColumn{
+		{
+			Name:   "rows",
+			Values: []string{strconv.FormatInt(prp.rows.Load(), 10)},
+		},
+	})
+	return nil
+}
+
+var registerTestPipesOnce sync.Once
+
+func registerTestPipes() {
+	registerTestPipesOnce.Do(func() {
+		RegisterPipe("test_upper", func(pl *PipeLexer) (Pipe, error) {
+			pl.NextToken()
+			if pl.IsPipeEnd() {
+				return nil, fmt.Errorf("missing field name")
+			}
+			pu := &testPipeUpper{
+				field: pl.Token(),
+			}
+			pl.NextToken()
+			return pu, nil
+		})
+		RegisterPipe("Test_Rows", func(pl *PipeLexer) (Pipe, error) {
+			pl.NextToken()
+			return &testPipeRows{}, nil
+		})
+	})
+}
+
+func TestRegisterPipe(t *testing.T) {
+	registerTestPipes()
+
+	if !isPipeName("test_upper") || !isPipeName("TEST_ROWS") {
+		t.Fatalf("the registered pipes must be recognized as pipe names")
+	}
+
+	expectParsePipeSuccess(t, "test_upper foo")
+	expectParsePipeSuccess(t, "test_rows")
+	expectParsePipeFailure(t, "test_upper")
+
+	f := func(qStr, resultExpected string) {
+		t.Helper()
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", qStr, err)
+		}
+		result := q.String()
+		if result != resultExpected {
+			t.Fatalf("unexpected result; got\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+	f("* | test_upper x | Test_Rows | fields rows", "* | test_upper x | test_rows | fields rows")
+
+	expectPipeResults(t, "test_upper a", [][]Field{
+		{
+			{"a", "foo"},
+			{"b", "bar"},
+		},
+		{
+			{"a", "baz"},
+		},
+		{
+			{"b", "x"},
+		},
+	}, [][]Field{
+		{
+			{"a", "FOO"},
+			{"b", "bar"},
+		},
+		{
+			{"a", "BAZ"},
+		},
+		{
+			{"b", "x"},
+		},
+	})
+
+	expectPipeResults(t, "test_rows", [][]Field{
+		{
+			{"a", "foo"},
+		},
+		{
+			{"b", "x"},
+		},
+		{},
+	}, [][]Field{
+		{
+			{"rows", "3"},
+		},
+	})
+}
+
+func TestRegisterPipeFailure(t *testing.T) {
+	registerTestPipes()
+
+	f := func(name string) {
+		t.Helper()
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("expecting panic when registering pipe %q", name)
+			}
+		}()
+		RegisterPipe(name, func(_ *PipeLexer) (Pipe, error) {
+			return nil, fmt.Errorf("unexpected call")
+		})
+	}
+
+	// duplicate names
+	f("test_upper")
+	f("TEST_UPPER")
+	f("sort")
+	f("cp")
+
+	// stats func names
+	f("count")
+
+	// invalid names
+	f("")
+	f("foo bar")
+	f("foo|bar")
+	f(`"foo"`)
+}
@@ -63,7 +63,7 @@ func (pe *pipeExtract) initFilterInValues(cache map[string][]string, getFieldVal
 	return &peNew, nil
 }
 
-func (pe *pipeExtract) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pe *pipeExtract) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	if neededFields.isEmpty() {
 		if pe.iff != nil {
 			neededFields.addFields(pe.iff.neededFields)
@@ -90,8 +90,6 @@ func (pe *pipeExtract) updateNeededFields(neededFields, unneededFields fieldsSet
 			if pe.iff != nil {
 				unneededFields.removeFields(pe.iff.neededFields)
 			}
-		} else {
-			unneededFields.add(pe.fromField)
 		}
 	} else {
 		neededFieldsOrig := neededFields.clone()
@@ -116,7 +114,7 @@ func (pe *pipeExtract) updateNeededFields(neededFields, unneededFields fieldsSet
 	}
 }
 
-func (pe *pipeExtract) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pe *pipeExtract) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	return &pipeExtractProcessor{
 		pe:     pe,
 		ppNext: ppNext,
@@ -65,7 +65,7 @@ func (pe *pipeExtractRegexp) initFilterInValues(cache map[string][]string, getFi
 	return &peNew, nil
 }
 
-func (pe *pipeExtractRegexp) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pe *pipeExtractRegexp) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	if neededFields.isEmpty() {
 		if pe.iff != nil {
 			neededFields.addFields(pe.iff.neededFields)
@@ -92,8 +92,6 @@ func (pe *pipeExtractRegexp) updateNeededFields(neededFields, unneededFields fie
 			if pe.iff != nil {
 				unneededFields.removeFields(pe.iff.neededFields)
 			}
-		} else {
-			unneededFields.add(pe.fromField)
 		}
 	} else {
 		neededFieldsOrig := neededFields.clone()
@@ -118,7 +116,7 @@ func (pe *pipeExtractRegexp) updateNeededFields(neededFields, unneededFields fie
 	}
 }
 
-func (pe *pipeExtractRegexp) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pe *pipeExtractRegexp) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	return &pipeExtractRegexpProcessor{
 		pe:     pe,
 		ppNext: ppNext,
@@ -225,20 +223,27 @@ func (pep *pipeExtractRegexpProcessor) writeBlock(workerID uint, br *blockResult
 
 				shard.apply(pe.re, v)
 
-				for i, v := range shard.fields {
-					if reFields[i] == "" {
-						continue
+				fields := shard.fields
+				for i, v := range fields {
+					if reFields[i] != "" {
+						fields[i] = shard.a.copyString(v)
 					}
-					if v == "" && pe.skipEmptyResults || pe.keepOriginalFields {
-						c := resultColumns[i]
-						if vOrig := c.getValueAtRow(br, rowIdx); vOrig != "" {
-							v = vOrig
-						}
-					} else {
-						v = shard.a.copyString(v)
+				}
+			}
+
+			// The original field values may differ per row, so they must be checked for every row
+			// even if the extracted values are re-used from the previous row.
+			for i, v := range shard.fields {
+				if reFields[i] == "" {
+					continue
+				}
+				if v == "" && pe.skipEmptyResults || pe.keepOriginalFields {
+					c := resultColumns[i]
+					if vOrig := c.getValueAtRow(br, rowIdx); vOrig != "" {
+						v = vOrig
 					}
-					resultValues[i] = v
 				}
+				resultValues[i] = v
 			}
 		} else {
 			for i, c := range resultColumns {
@@ -297,10 +297,10 @@ func TestPipeExtractRegexpUpdateNeededFields(t *testing.T) {
 	f("extract_regexp if (f2:abc foo:w) '(?P<foo>.*)x(?P<bar>.*)' from x skip_empty_results", "*", "f2,foo", "*", "")
 
 	// unneeded fields intersect with all the output fields
-	f("extract_regexp '(?P<foo>.*)x(?P<bar>.*)' from x", "*", "f2,foo,bar", "*", "bar,f2,foo,x")
-	f("extract_regexp if (a:b f2:q x:y foo:w) '(?P<foo>.*)x(?P<bar>.*)' from x", "*", "f2,foo,bar", "*", "bar,f2,foo,x")
-	f("extract_regexp if (a:b f2:q x:y foo:w) '(?P<foo>.*)x(?P<bar>.*)' from x keep_original_fields", "*", "f2,foo,bar", "*", "bar,f2,foo,x")
-	f("extract_regexp if (a:b f2:q x:y foo:w) '(?P<foo>.*)x(?P<bar>.*)' from x skip_empty_results", "*", "f2,foo,bar", "*", "bar,f2,foo,x")
+	f("extract_regexp '(?P<foo>.*)x(?P<bar>.*)' from x", "*", "f2,foo,bar", "*", "bar,f2,foo")
+	f("extract_regexp if (a:b f2:q x:y foo:w) '(?P<foo>.*)x(?P<bar>.*)' from x", "*", "f2,foo,bar", "*", "bar,f2,foo")
+	f("extract_regexp if (a:b f2:q x:y foo:w) '(?P<foo>.*)x(?P<bar>.*)' from x keep_original_fields", "*", "f2,foo,bar", "*", "bar,f2,foo")
+	f("extract_regexp if (a:b f2:q x:y foo:w) '(?P<foo>.*)x(?P<bar>.*)' from x skip_empty_results", "*", "f2,foo,bar", "*", "bar,f2,foo")
 
 	// needed fields do not intersect with pattern and output fields
 	f("extract_regexp '(?P<foo>.*)x(?P<bar>.*)' from x", "f1,f2", "", "f1,f2", "")
@@ -327,3 +327,80 @@ func TestPipeExtractRegexpUpdateNeededFields(t *testing.T) {
 	f("extract_regexp '(?P<foo>.*)x(?P<bar>.*)' from x skip_empty_results", "f2,foo,x,y", "", "foo,f2,x,y", "")
 	f("extract_regexp if (a:b foo:q) '(?P<foo>.*)x(?P<bar>.*)' from x", "f2,foo,x,y", "", "a,f2,foo,x,y", "")
 }
+
+func TestPipeExtractRegexpSameValuesInBlock(t *testing.T) {
+	f := func(pipeStr string, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeResultsSingleBlock(t, pipeStr, rows, rowsExpected)
+	}
+
+	// keep original fields
+	f(`extract_regexp "baz=(?P<abc>.*) a=(?P<aa>.*)" keep_original_fields`, [][]Field{
+		{
+			{"_msg", `foo=bar baz=x a=b`},
+			{"aa", "foobar"},
+			{"abc", ""},
+		},
+		{
+			{"_msg", `foo=bar baz=x a=b`},
+			{"aa", ""},
+			{"abc", ""},
+		},
+		{
+			{"_msg", `foo=bar baz=x a=b`},
+			{"aa", ""},
+			{"abc", "qwe"},
+		},
+	}, [][]Field{
+		{
+			{"_msg", `foo=bar baz=x a=b`},
+			{"abc", "x"},
+			{"aa", "foobar"},
+		},
+		{
+			{"_msg", `foo=bar baz=x a=b`},
+			{"abc", "x"},
+			{"aa", "b"},
+		},
+		{
+			{"_msg", `foo=bar baz=x a=b`},
+			{"abc", "qwe"},
+			{"aa", "b"},
+		},
+	})
+
+	// skip empty results
+	f(`extract_regexp "baz=(?P<abc>.*) a=(?P<aa>.*)" skip_empty_results`, [][]Field{
+		{
+			{"_msg", `foo=bar baz=x a=`},
+			{"aa", "foobar"},
+			{"abc", "abc"},
+		},
+		{
+			{"_msg", `foo=bar baz=x a=`},
+			{"aa", ""},
+			{"abc", ""},
+		},
+		{
+			{"_msg", `foo=bar baz=x a=`},
+			{"aa", "qwe"},
+			{"abc", "abc"},
+		},
+	}, [][]Field{
+		{
+			{"_msg", `foo=bar baz=x a=`},
+			{"abc", "x"},
+			{"aa", "foobar"},
+		},
+		{
+			{"_msg", `foo=bar baz=x a=`},
+			{"abc", "x"},
+			{"aa", ""},
+		},
+		{
+			{"_msg", `foo=bar baz=x a=`},
+			{"abc", "x"},
+			{"aa", "qwe"},
+		},
+	})
+}
@@ -0,0 +1,451 @@
+package logstorage
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// pipeFacetsDefaultLimit is the default number of the most frequent values to return per every field.
+const pipeFacetsDefaultLimit = 10
+
+// pipeFacetsDefaultMaxValuesPerField is the default maximum number of unique values to track per every field.
+const pipeFacetsDefaultMaxValuesPerField = 1000
+
+// pipeFacetsDefaultMaxValueLen is the default maximum length of values to track.
+const pipeFacetsDefaultMaxValueLen = 128
+
+// pipeFacets processes '| facets' pipe.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#facets-pipe
+type pipeFacets struct {
+	// limit is the maximum number of the most frequent values to return per every field.
+	limit uint64
+
+	// maxValuesPerField is the maximum number of unique values to track per every field.
+	//
+	// Fields with bigger number of unique values are skipped, since they aren't useful for faceted navigation.
+	maxValuesPerField uint64
+
+	// maxValueLen is the maximum length of values to track.
+	//
+	// Fields with longer values are skipped, since they aren't useful for faceted navigation.
+	maxValueLen uint64
+
+	// keepConstFields is set if fields with the same value across all the logs must be returned.
+	keepConstFields bool
+}
+
+func (pf *pipeFacets) String() string {
+	s := "facets"
+	if pf.limit != pipeFacetsDefaultLimit {
+		s += fmt.Sprintf(" %d", pf.limit)
+	}
+	if pf.maxValuesPerField != pipeFacetsDefaultMaxValuesPerField {
+		s += fmt.Sprintf(" max_values_per_field %d", pf.maxValuesPerField)
+	}
+	if pf.maxValueLen != pipeFacetsDefaultMaxValueLen {
+		s += fmt.Sprintf(" max_value_len %d", pf.maxValueLen)
+	}
+	if pf.keepConstFields {
+		s += " keep_const_fields"
+	}
+	return s
+}
+
+func (pf *pipeFacets) canLiveTail() bool {
+	return false
+}
+
+func (pf *pipeFacets) updateNeededFields(neededFields, unneededFields *fieldsSet) {
+	neededFields.add("*")
+	unneededFields.reset()
+
+	// _time field has unique values for almost all the logs, so it is useless for facets.
+	unneededFields.add("_time")
+}
+
+func (pf *pipeFacets) optimize() {
+	// nothing to do
+}
+
+func (pf *pipeFacets) hasFilterInWithQuery() bool {
+	return false
+}
+
+func (pf *pipeFacets) initFilterInValues(_ map[string][]string, _ getFieldValuesFunc) (pipe, error) {
+	return pf, nil
+}
+
+func (pf *pipeFacets) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor, mb *memoryBudget) pipeProcessor {
+	pmb := mb.newPipeMemoryBudget(pf)
+	shards := make([]pipeFacetsProcessorShard, workersCount)
+	for i := range shards {
+		shards[i] = pipeFacetsProcessorShard{
+			pipeFacetsProcessorShardNopad: pipeFacetsProcessorShardNopad{
+				pf:              pf,
+				stateSizeBudget: stateSizeBudgetChunk,
+			},
+		}
+		pmb.reserve(stateSizeBudgetChunk)
+	}
+
+	pfp := &pipeFacetsProcessor{
+		pf:     pf,
+		stopCh: stopCh,
+		cancel: cancel,
+		ppNext: ppNext,
+
+		shards: shards,
+
+		pmb: pmb,
+	}
+	return pfp
+}
+
+type pipeFacetsProcessor struct {
+	pf     *pipeFacets
+	stopCh <-chan struct{}
+	cancel func()
+	ppNext pipeProcessor
+
+	shards []pipeFacetsProcessorShard
+
+	pmb *pipeMemoryBudget
+}
+
+type pipeFacetsProcessorShard struct {
+	pipeFacetsProcessorShardNopad
+
+	// The padding prevents false sharing on widespread platforms with 128 mod (cache line size) = 0 .
+	_ [128 - unsafe.Sizeof(pipeFacetsProcessorShardNopad{})%128]byte
+}
+
+type pipeFacetsProcessorShardNopad struct {
+	// pf points to the parent pipeFacets.
+	pf *pipeFacets
+
+	// m holds per-value hits for every field.
+	m map[string]*pipeFacetsFieldHits
+
+	// rowsTotal is the number of rows processed by the shard.
+	rowsTotal uint64
+
+	// stateSizeBudget is the remaining budget for the whole state size for the shard.
+	// The per-shard budget is provided in chunks from the parent pipeFacetsProcessor.
+	stateSizeBudget int
+}
+
+// pipeFacetsFieldHits holds per-value hits for a single field.
+type pipeFacetsFieldHits struct {
+	// m holds hits per every value of the field.
+	m map[string]*uint64
+
+	// mustIgnore is set if the field has too many unique values or too long values.
+	mustIgnore bool
+}
+
+func (fhs *pipeFacetsFieldHits) ignore() {
+	fhs.m = nil
+	fhs.mustIgnore = true
+}
+
+func (shard *pipeFacetsProcessorShard) getFieldHits(name string) *pipeFacetsFieldHits {
+	if shard.m == nil {
+		shard.m = make(map[string]*pipeFacetsFieldHits)
+	}
+	fhs, ok := shard.m[name]
+	if !ok {
+		nameCopy := strings.Clone(name)
+		fhs = &pipeFacetsFieldHits{
+			m: make(map[string]*uint64),
+		}
+		shard.m[nameCopy] = fhs
+		shard.stateSizeBudget -= len(nameCopy) + int(unsafe.Sizeof(nameCopy)+unsafe.Sizeof(fhs)+unsafe.Sizeof(*fhs))
+	}
+	return fhs
+}
+
+func (shard *pipeFacetsProcessorShard) writeBlock(br *blockResult) {
+	shard.rowsTotal += uint64(len(br.timestamps))
+
+	cs := br.getColumns()
+	for _, c := range cs {
+		if c.name == "_time" {
+			continue
+		}
+		if c.isConst && c.valuesEncoded[0] == "" {
+			// The column is empty for all the rows in the block, e.g. it has been created by the previous pipes.
+			// Do not count it, since log fields with empty values are equivalent to missing fields.
+			continue
+		}
+
+		fhs := shard.getFieldHits(c.name)
+		if fhs.mustIgnore {
+			continue
+		}
+
+		if c.isConst {
+			shard.updateState(fhs, c.valuesEncoded[0], uint64(len(br.timestamps)))
+			continue
+		}
+		if c.valueType == valueTypeDict {
+			c.forEachDictValueWithHits(br, func(v string, hits uint64) {
+				shard.updateState(fhs, v, hits)
+			})
+			continue
+		}
+
+		values := c.getValues(br)
+		hits := uint64(0)
+		for i, v := range values {
+			hits++
+			if i+1 < len(values) && values[i+1] == v {
+				// Count consecutive identical values in one go in order to reduce the number of map lookups.
+				continue
+			}
+			shard.updateState(fhs, v, hits)
+			if fhs.mustIgnore {
+				break
+			}
+			hits = 0
+		}
+	}
+}
+
+func (shard *pipeFacetsProcessorShard) updateState(fhs *pipeFacetsFieldHits, v string, hits uint64) {
+	if v == "" || fhs.mustIgnore {
+		// Empty values are equivalent to missing fields.
+		return
+	}
+	if uint64(len(v)) > shard.pf.maxValueLen {
+		fhs.ignore()
+		return
+	}
+
+	pHits, ok := fhs.m[v]
+	if !ok {
+		if uint64(len(fhs.m)) >= shard.pf.maxValuesPerField {
+			fhs.ignore()
+			return
+		}
+		vCopy := strings.Clone(v)
+		hits := uint64(0)
+		pHits = &hits
+		fhs.m[vCopy] = pHits
+		shard.stateSizeBudget -= len(vCopy) + int(unsafe.Sizeof(vCopy)+unsafe.Sizeof(hits)+unsafe.Sizeof(pHits))
+	}
+	*pHits += hits
+}
+
+func (pfp *pipeFacetsProcessor) writeBlock(workerID uint, br *blockResult) {
+	if len(br.timestamps) == 0 {
+		return
+	}
+
+	shard := &pfp.shards[workerID]
+
+	for shard.stateSizeBudget < 0 {
+		// steal some budget for the state size from the global budget.
+		if !pfp.pmb.borrow(stateSizeBudgetChunk) {
+			// The state size is too big. Stop processing data in order to avoid OOM crash.
+			// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
+			pfp.cancel()
+			return
+		}
+		shard.stateSizeBudget += stateSizeBudgetChunk
+	}
+
+	shard.writeBlock(br)
+}
+
+func (pfp *pipeFacetsProcessor) flush() error {
+	defer pfp.pmb.release()
+
+	if pfp.pmb.isExceeded() {
+		return pfp.pmb.limitError()
+	}
+
+	// merge state across shards
+	shards := pfp.shards
+	m := shards[0].m
+	if m == nil {
+		m = make(map[string]*pipeFacetsFieldHits)
+	}
+	rowsTotal := shards[0].rowsTotal
+	shards = shards[1:]
+	for i := range shards {
+		if needStop(pfp.stopCh) {
+			return nil
+		}
+
+		rowsTotal += shards[i].rowsTotal
+		for name, fhsSrc := range shards[i].m {
+			fhs, ok := m[name]
+			if !ok {
+				m[name] = fhsSrc
+				continue
+			}
+			if fhs.mustIgnore {
+				continue
+			}
+			if fhsSrc.mustIgnore {
+				fhs.ignore()
+				continue
+			}
+			for v, pHitsSrc := range fhsSrc.m {
+				pHits, ok := fhs.m[v]
+				if !ok {
+					fhs.m[v] = pHitsSrc
+				} else {
+					*pHits += *pHitsSrc
+				}
+			}
+			if uint64(len(fhs.m)) > pfp.pf.maxValuesPerField {
+				fhs.ignore()
+			}
+		}
+	}
+
+	// write result
+	wctx := &pipeFacetsWriteContext{
+		pfp: pfp,
+	}
+	wctx.rcs[0].name = "field_name"
+	wctx.rcs[1].name = "field_value"
+	wctx.rcs[2].name = "hits"
+
+	var hitsBuf []byte
+	for name, fhs := range m {
+		if needStop(pfp.stopCh) {
+			return nil
+		}
+		if fhs.mustIgnore || len(fhs.m) == 0 {
+			continue
+		}
+		if !pfp.pf.keepConstFields && len(fhs.m) == 1 {
+			if hits := getSingleHits(fhs.m); hits == rowsTotal {
+				// The field has the same value across all the logs, so it is useless for faceted navigation.
+				continue
+			}
+		}
+
+		vs := make([]ValueWithHits, 0, len(fhs.m))
+		for v, pHits := range fhs.m {
+			vs = append(vs, ValueWithHits{
+				Value: v,
+				Hits:  *pHits,
+			})
+		}
+		sortValuesWithHits(vs)
+		if uint64(len(vs)) > pfp.pf.limit {
+			vs = vs[:pfp.pf.limit]
+		}
+		for _, x := range vs {
+			hitsBuf = marshalUint64String(hitsBuf[:0], x.Hits)
+			wctx.writeRow(name, x.Value, string(hitsBuf))
+		}
+	}
+	wctx.flush()
+
+	return nil
+}
+
+func getSingleHits(m map[string]*uint64) uint64 {
+	for _, pHits := range m {
+		return *pHits
+	}
+	return 0
+}
+
+type pipeFacetsWriteContext struct {
+	pfp *pipeFacetsProcessor
+	rcs [3]resultColumn
+	br  blockResult
+
+	// rowsCount is the number of rows in the current block
+	rowsCount int
+
+	// valuesLen is the total length of values in the current block
+	valuesLen int
+}
+
+func (wctx *pipeFacetsWriteContext) writeRow(name, value, hits string) {
+	wctx.rcs[0].addValue(name)
+	wctx.rcs[1].addValue(value)
+	wctx.rcs[2].addValue(hits)
+	wctx.valuesLen += len(name) + len(value) + len(hits)
+	wctx.rowsCount++
+	if wctx.valuesLen >= 1_000_000 {
+		wctx.flush()
+	}
+}
+
+func (wctx *pipeFacetsWriteContext) flush() {
+	br := &wctx.br
+
+	wctx.valuesLen = 0
+
+	// Flush rcs to ppNext
+	br.setResultColumns(wctx.rcs[:], wctx.rowsCount)
+	wctx.rowsCount = 0
+	wctx.pfp.ppNext.writeBlock(0, br)
+	br.reset()
+	for i := range wctx.rcs {
+		wctx.rcs[i].resetValues()
+	}
+}
+
+func parsePipeFacets(lex *lexer) (*pipeFacets, error) {
+	if !lex.isKeyword("facets") {
+		return nil, fmt.Errorf("expecting 'facets'; got %q", lex.token)
+	}
+	lex.nextToken()
+
+	pf := &pipeFacets{
+		limit:             pipeFacetsDefaultLimit,
+		maxValuesPerField: pipeFacetsDefaultMaxValuesPerField,
+		maxValueLen:       pipeFacetsDefaultMaxValueLen,
+	}
+
+	if isNumberPrefix(lex.token) {
+		n, err := parsePipeFacetsUint64(lex)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse N in 'facets': %w", err)
+		}
+		pf.limit = n
+	}
+
+	for {
+		switch {
+		case lex.isKeyword("max_values_per_field"):
+			lex.nextToken()
+			n, err := parsePipeFacetsUint64(lex)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse 'max_values_per_field' in 'facets': %w", err)
+			}
+			pf.maxValuesPerField = n
+		case lex.isKeyword("max_value_len"):
+			lex.nextToken()
+			n, err := parsePipeFacetsUint64(lex)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse 'max_value_len' in 'facets': %w", err)
+			}
+			pf.maxValueLen = n
+		case lex.isKeyword("keep_const_fields"):
+			lex.nextToken()
+			pf.keepConstFields = true
+		default:
+			return pf, nil
+		}
+	}
+}
+
+func parsePipeFacetsUint64(lex *lexer) (uint64, error) {
+	n, ok := tryParseUint64(lex.token)
+	if !ok || n == 0 {
+		return 0, fmt.Errorf("expecting integer bigger than 0; got %q", lex.token)
+	}
+	lex.nextToken()
+	return n, nil
+}
@@ -0,0 +1,163 @@
+package logstorage
+
+import (
+	"testing"
+)
+
+func TestParsePipeFacetsSuccess(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeSuccess(t, pipeStr)
+	}
+
+	f(`facets`)
+	f(`facets 5`)
+	f(`facets max_values_per_field 100`)
+	f(`facets max_value_len 20`)
+	f(`facets keep_const_fields`)
+	f(`facets 3 max_values_per_field 100 max_value_len 20 keep_const_fields`)
+}
+
+func TestParsePipeFacetsFailure(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeFailure(t, pipeStr)
+	}
+
+	f(`facets foo`)
+	f(`facets 0`)
+	f(`facets -1`)
+	f(`facets 1.5`)
+	f(`facets max_values_per_field`)
+	f(`facets max_values_per_field 0`)
+	f(`facets max_values_per_field foo`)
+	f(`facets max_value_len`)
+	f(`facets max_value_len 0`)
+	f(`facets by (foo)`)
+}
+
+func TestPipeFacets(t *testing.T) {
+	f := func(pipeStr string, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeResults(t, pipeStr, rows, rowsExpected)
+	}
+
+	rows := [][]Field{
+		{
+			{"_time", "2024-05-31T10:20:30Z"},
+			{"host", "host1"},
+			{"level", "info"},
+			{"app", "nginx"},
+		},
+		{
+			{"_time", "2024-05-31T10:20:31Z"},
+			{"host", "host2"},
+			{"level", "error"},
+			{"app", "nginx"},
+		},
+		{
+			{"_time", "2024-05-31T10:20:32Z"},
+			{"host", "host1"},
+			{"level", "info"},
+			{"app", "nginx"},
+			{"trace_id", "very-long-trace-identifier"},
+		},
+		{
+			{"_time", "2024-05-31T10:20:33Z"},
+			{"host", "host3"},
+			{"level", ""},
+			{"app", "nginx"},
+		},
+	}
+
+	// by default const fields and _time are skipped, while empty values are ignored
+	f("facets", rows, [][]Field{
+		{
+			{"field_name", "host"},
+			{"field_value", "host1"},
+			{"hits", "2"},
+		},
+		{
+			{"field_name", "host"},
+			{"field_value", "host2"},
+			{"hits", "1"},
+		},
+		{
+			{"field_name", "host"},
+			{"field_value", "host3"},
+			{"hits", "1"},
+		},
+		{
+			{"field_name", "level"},
+			{"field_value", "info"},
+			{"hits", "2"},
+		},
+		{
+			{"field_name", "level"},
+			{"field_value", "error"},
+			{"hits", "1"},
+		},
+		{
+			{"field_name", "trace_id"},
+			{"field_value", "very-long-trace-identifier"},
+			{"hits", "1"},
+		},
+	})
+
+	// limit the number of values per field
+	f("facets 1", rows, [][]Field{
+		{
+			{"field_name", "host"},
+			{"field_value", "host1"},
+			{"hits", "2"},
+		},
+		{
+			{"field_name", "level"},
+			{"field_value", "info"},
+			{"hits", "2"},
+		},
+		{
+			{"field_name", "trace_id"},
+			{"field_value", "very-long-trace-identifier"},
+			{"hits", "1"},
+		},
+	})
+
+	// skip fields with too many unique values and too long values; keep const fields
+	f("facets max_values_per_field 2 max_value_len 10 keep_const_fields", rows, [][]Field{
+		{
+			{"field_name", "app"},
+			{"field_value", "nginx"},
+			{"hits", "4"},
+		},
+		{
+			{"field_name", "level"},
+			{"field_value", "info"},
+			{"hits", "2"},
+		},
+		{
+			{"field_name", "level"},
+			{"field_value", "error"},
+			{"hits", "1"},
+		},
+	})
+
+	// no rows
+	f("facets", nil, [][]Field{})
+}
+
+func TestPipeFacetsUpdateNeededFields(t *testing.T) {
+	f := func(s string, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected string) {
+		t.Helper()
+		expectPipeNeededFields(t, s, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected)
+	}
+
+	// all the needed fields
+	f("facets", "*", "", "*", "_time")
+
+	// all the needed fields, unneeded fields do not intersect with src
+	f("facets 3", "*", "f1,f2", "*", "_time")
+
+	// needed fields do not intersect with src
+	f("facets", "f1,f2", "", "*", "_time")
+}
@@ -3,6 +3,7 @@ package logstorage
 import (
 	"fmt"
 	"strings"
+	"sync"
 	"unsafe"
 )
 
@@ -32,7 +33,7 @@ func (pf *pipeFieldNames) canLiveTail() bool {
 	return false
 }
 
-func (pf *pipeFieldNames) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pf *pipeFieldNames) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	neededFields.add("*")
 	unneededFields.reset()
 
@@ -53,7 +54,7 @@ func (pf *pipeFieldNames) initFilterInValues(_ map[string][]string, _ getFieldVa
 	return pf, nil
 }
 
-func (pf *pipeFieldNames) newPipeProcessor(workersCount int, stopCh <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pf *pipeFieldNames) newPipeProcessor(workersCount int, stopCh <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	shards := make([]pipeFieldNamesProcessorShard, workersCount)
 
 	pfp := &pipeFieldNamesProcessor{
@@ -72,6 +73,10 @@ type pipeFieldNamesProcessor struct {
 	ppNext pipeProcessor
 
 	shards []pipeFieldNamesProcessorShard
+
+	// indexM holds hits per each field name obtained from the fields index of the parts. It is protected by indexMLock.
+	indexMLock sync.Mutex
+	indexM     map[string]*uint64
 }
 
 type pipeFieldNamesProcessorShard struct {
@@ -103,6 +108,12 @@ func (pfp *pipeFieldNamesProcessor) writeBlock(workerID uint, br *blockResult) {
 
 	cs := br.getColumns()
 	for _, c := range cs {
+		if c.isConst && c.valuesEncoded[0] == "" {
+			// The column is empty for all the rows in the block, e.g. it has been created by the previous pipes.
+			// Do not count it, since log fields with empty values are equivalent to missing fields.
+			continue
+		}
+
 		pHits, ok := m[c.name]
 		if !ok {
 			nameCopy := strings.Clone(c.name)
@@ -117,6 +128,41 @@ func (pfp *pipeFieldNamesProcessor) writeBlock(workerID uint, br *blockResult) {
 	}
 }
 
+// processPartFieldsIndex implements partFieldsIndexProcessor interface.
+func (pfp *pipeFieldNamesProcessor) processPartFieldsIndex(_ *part, pfts []*partFieldsIndexTenant) bool {
+	pfp.indexMLock.Lock()
+	defer pfp.indexMLock.Unlock()
+
+	if pfp.indexM == nil {
+		pfp.indexM = make(map[string]*uint64)
+	}
+	m := pfp.indexM
+	addHits := func(name string, hits uint64) {
+		pHits, ok := m[name]
+		if !ok {
+			hits := uint64(0)
+			pHits = &hits
+			m[name] = pHits
+		}
+		*pHits += hits
+	}
+
+	for _, pft := range pfts {
+		// The _time, _stream_id and _stream fields are set for all the logs.
+		if !pfp.pf.isFirstPipe {
+			addHits("_time", pft.rowsCount)
+		}
+		addHits("_stream_id", pft.rowsCount)
+		addHits("_stream", pft.rowsCount)
+
+		for i := range pft.fields {
+			f := &pft.fields[i]
+			addHits(f.name, f.rowsCount)
+		}
+	}
+	return true
+}
+
 func (pfp *pipeFieldNamesProcessor) flush() error {
 	if needStop(pfp.stopCh) {
 		return nil
@@ -136,13 +182,20 @@ func (pfp *pipeFieldNamesProcessor) flush() error {
 			}
 		}
 	}
+	for name, pHitsSrc := range pfp.indexM {
+		pHits, ok := m[name]
+		if !ok {
+			m[name] = pHitsSrc
+		} else {
+			*pHits += *pHitsSrc
+		}
+	}
 	if pfp.pf.isFirstPipe {
-		pHits := m["_stream"]
-		if pHits == nil {
-			hits := uint64(0)
-			pHits = &hits
+		// Every log entry has _time field, while _time column isn't loaded for the first pipe.
+		// So use hits for _stream field, which is always loaded. Do not return _time if there are no matching logs.
+		if pHits := m["_stream"]; pHits != nil {
+			m["_time"] = pHits
 		}
-		m["_time"] = pHits
 	}
 
 	// write result
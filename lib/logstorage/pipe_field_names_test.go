@@ -75,6 +75,74 @@ func TestPipeFieldNames(t *testing.T) {
 			{"hits", "1"},
 		},
 	})
+
+	// fields with empty values in all the rows must be skipped
+	f("field_names", [][]Field{
+		{
+			{"a", `test`},
+		},
+		{
+			{"a", `bar`},
+			{"b", ""},
+		},
+		{
+			{"b", ""},
+			{"c", ""},
+		},
+	}, [][]Field{
+		{
+			{"name", "a"},
+			{"hits", "2"},
+		},
+	})
+}
+
+func TestPipeFieldNamesFirstPipe(t *testing.T) {
+	f := func(rows, rowsExpected [][]Field) {
+		t.Helper()
+
+		pf := &pipeFieldNames{
+			resultName:  "name",
+			isFirstPipe: true,
+		}
+		ppTest := newTestPipeProcessor()
+		pp := pf.newPipeProcessor(1, make(chan struct{}), func() {}, ppTest, newMemoryBudget(0))
+		if len(rows) > 0 {
+			pp.writeBlock(0, newTestStatsBlockResult(rows))
+		}
+		if err := pp.flush(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		ppTest.expectRows(t, rowsExpected)
+	}
+
+	// no matching logs
+	f(nil, nil)
+
+	// _time hits are taken from _stream hits
+	f([][]Field{
+		{
+			{"_stream", `{}`},
+			{"a", "foo"},
+		},
+		{
+			{"_stream", `{}`},
+			{"a", "bar"},
+		},
+	}, [][]Field{
+		{
+			{"name", "_stream"},
+			{"hits", "2"},
+		},
+		{
+			{"name", "_time"},
+			{"hits", "2"},
+		},
+		{
+			{"name", "a"},
+			{"hits", "2"},
+		},
+	})
 }
 
 func TestPipeFieldNamesUpdateNeededFields(t *testing.T) {
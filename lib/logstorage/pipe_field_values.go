@@ -25,7 +25,7 @@ func (pf *pipeFieldValues) canLiveTail() bool {
 	return false
 }
 
-func (pf *pipeFieldValues) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pf *pipeFieldValues) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	if neededFields.isEmpty() {
 		neededFields.add(pf.field)
 		return
@@ -58,7 +58,7 @@ func (pf *pipeFieldValues) initFilterInValues(_ map[string][]string, _ getFieldV
 	return pf, nil
 }
 
-func (pf *pipeFieldValues) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor) pipeProcessor {
+func (pf *pipeFieldValues) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor, mb *memoryBudget) pipeProcessor {
 	hitsFieldName := "hits"
 	if hitsFieldName == pf.field {
 		hitsFieldName = "hitss"
@@ -68,7 +68,7 @@ func (pf *pipeFieldValues) newPipeProcessor(workersCount int, stopCh <-chan stru
 		hitsFieldName: hitsFieldName,
 		limit:         pf.limit,
 	}
-	return pu.newPipeProcessor(workersCount, stopCh, cancel, ppNext)
+	return pu.newPipeProcessor(workersCount, stopCh, cancel, ppNext, mb)
 }
 
 func parsePipeFieldValues(lex *lexer) (*pipeFieldValues, error) {
@@ -3,6 +3,8 @@ package logstorage
 import (
 	"fmt"
 	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 )
@@ -16,20 +18,54 @@ type pipeFields struct {
 
 	// whether fields contains star
 	containsStar bool
+
+	// excludeFields contains list of fields to exclude from '| fields -f1, ..., -fN' form.
+	//
+	// All the other fields are kept if excludeFields isn't empty. fields must be empty in this case.
+	excludeFields []string
 }
 
 func (pf *pipeFields) String() string {
+	if len(pf.excludeFields) > 0 {
+		a := make([]string, len(pf.excludeFields))
+		for i, f := range pf.excludeFields {
+			a[i] = "-" + pipeFieldsNameString(f)
+		}
+		return "fields " + strings.Join(a, ", ")
+	}
 	if len(pf.fields) == 0 {
 		logger.Panicf("BUG: pipeFields must contain at least a single field")
 	}
-	return "fields " + fieldNamesString(pf.fields)
+	a := make([]string, len(pf.fields))
+	for i, f := range pf.fields {
+		a[i] = pipeFieldsNameString(f)
+	}
+	return "fields " + strings.Join(a, ", ")
+}
+
+// pipeFieldsNameString returns string representation of the field name f at 'fields' pipe.
+//
+// Field names starting with '-' are quoted, since they are parsed as excluded field names otherwise.
+func pipeFieldsNameString(f string) string {
+	if strings.HasPrefix(f, "-") {
+		return strconv.Quote(f)
+	}
+	return fieldNamesString([]string{f})
 }
 
 func (pf *pipeFields) canLiveTail() bool {
 	return true
 }
 
-func (pf *pipeFields) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pf *pipeFields) updateNeededFields(neededFields, unneededFields *fieldsSet) {
+	if len(pf.excludeFields) > 0 {
+		if neededFields.contains("*") {
+			unneededFields.addPatterns(pf.excludeFields)
+		} else {
+			neededFields.removePatterns(pf.excludeFields)
+		}
+		return
+	}
 	if pf.containsStar {
 		return
 	}
@@ -37,9 +73,10 @@ func (pf *pipeFields) updateNeededFields(neededFields, unneededFields fieldsSet)
 	if neededFields.contains("*") {
 		// subtract unneeded fields from pf.fields
 		neededFields.reset()
-		neededFields.addFields(pf.fields)
-		for _, f := range unneededFields.getAll() {
-			neededFields.remove(f)
+		for _, f := range pf.fields {
+			if !unneededFields.contains(f) {
+				neededFields.add(f)
+			}
 		}
 	} else {
 		// intersect needed fields with pf.fields
@@ -66,7 +103,7 @@ func (pf *pipeFields) initFilterInValues(_ map[string][]string, _ getFieldValues
 	return pf, nil
 }
 
-func (pf *pipeFields) newPipeProcessor(_ int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pf *pipeFields) newPipeProcessor(_ int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	return &pipeFieldsProcessor{
 		pf:     pf,
 		ppNext: ppNext,
@@ -83,7 +120,9 @@ func (pfp *pipeFieldsProcessor) writeBlock(workerID uint, br *blockResult) {
 		return
 	}
 
-	if !pfp.pf.containsStar {
+	if len(pfp.pf.excludeFields) > 0 {
+		br.deleteColumns(pfp.pf.excludeFields)
+	} else if !pfp.pf.containsStar {
 		br.setColumns(pfp.pf.fields)
 	}
 	pfp.ppNext.writeBlock(workerID, br)
@@ -98,16 +137,36 @@ func parsePipeFields(lex *lexer) (*pipeFields, error) {
 		return nil, fmt.Errorf("expecting 'fields'; got %q", lex.token)
 	}
 
-	var fields []string
+	var fields, excludeFields []string
 	for {
 		lex.nextToken()
-		field, err := parseFieldName(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse field name: %w", err)
+		if lex.isKeyword("-") {
+			// Parse excluded field name
+			lex.nextToken()
+			field, err := parseFieldName(lex)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse excluded field name: %w", err)
+			}
+			excludeFields = append(excludeFields, field)
+		} else {
+			field, err := parseFieldName(lex)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse field name: %w", err)
+			}
+			fields = append(fields, field)
 		}
-		fields = append(fields, field)
 		switch {
 		case lex.isKeyword("|", ")", ""):
+			if len(excludeFields) > 0 {
+				if len(fields) > 0 {
+					return nil, fmt.Errorf("cannot mix field names %s with excluded field names %s; either field names or excluded field names must be specified",
+						fieldNamesString(fields), fieldNamesString(excludeFields))
+				}
+				pf := &pipeFields{
+					excludeFields: excludeFields,
+				}
+				return pf, nil
+			}
 			if slices.Contains(fields, "*") {
 				fields = []string{"*"}
 			}
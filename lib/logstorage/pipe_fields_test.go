@@ -13,6 +13,10 @@ func TestParsePipeFieldsSuccess(t *testing.T) {
 	f(`fields *`)
 	f(`fields f1`)
 	f(`fields f1, f2, f3`)
+	f(`fields -f1`)
+	f(`fields -f1, -f2, -foo.*`)
+	f(`fields -"-f1"`)
+	f(`fields "-f1", f2`)
 }
 
 func TestParsePipeFieldsFailure(t *testing.T) {
@@ -23,6 +27,10 @@ func TestParsePipeFieldsFailure(t *testing.T) {
 
 	f(`fields`)
 	f(`fields x y`)
+	f(`fields -`)
+	f(`fields -x y`)
+	f(`fields x, -y`)
+	f(`fields -x, y`)
 }
 
 func TestPipeFields(t *testing.T) {
@@ -131,6 +139,43 @@ func TestPipeFields(t *testing.T) {
 			{"b", ""},
 		},
 	})
+
+	// exclude fields
+	f("fields -a, -foo.*", [][]Field{
+		{
+			{"_msg", `{"foo":"bar"}`},
+			{"a", `test`},
+			{"foo.bar", `x`},
+			{"foo.baz", `y`},
+			{"foobar", `z`},
+		},
+		{
+			{"b", `baz`},
+			{"c", "d"},
+		},
+	}, [][]Field{
+		{
+			{"_msg", `{"foo":"bar"}`},
+			{"foobar", `z`},
+		},
+		{
+			{"b", `baz`},
+			{"c", "d"},
+		},
+	})
+
+	// exclude non-existing fields
+	f("fields -x, -y", [][]Field{
+		{
+			{"_msg", `{"foo":"bar"}`},
+			{"a", `test`},
+		},
+	}, [][]Field{
+		{
+			{"_msg", `{"foo":"bar"}`},
+			{"a", `test`},
+		},
+	})
 }
 
 func TestPipeFieldsUpdateNeededFields(t *testing.T) {
@@ -157,4 +202,10 @@ func TestPipeFieldsUpdateNeededFields(t *testing.T) {
 	// needed fields intersect with src
 	f("fields s1, s2", "s1,f1,f2", "", "s1", "")
 	f("fields *", "s1,f1,f2", "", "s1,f1,f2", "")
+
+	// exclude fields
+	f("fields -s1, -s2", "*", "", "*", "s1,s2")
+	f("fields -s1, -s2", "*", "f1", "*", "f1,s1,s2")
+	f("fields -s1, -s2", "s1,f1", "", "f1", "")
+	f("fields -s*", "*", "f1", "*", "f1,s*")
 }
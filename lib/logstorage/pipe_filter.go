@@ -21,19 +21,21 @@ func (pf *pipeFilter) canLiveTail() bool {
 	return true
 }
 
-func (pf *pipeFilter) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pf *pipeFilter) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	if neededFields.contains("*") {
 		fs := newFieldsSet()
 		pf.f.updateNeededFields(fs)
-		for f := range fs {
-			unneededFields.remove(f)
-		}
+		unneededFields.removeFields(fs.getAll())
 	} else {
 		pf.f.updateNeededFields(neededFields)
 	}
 }
 
 func (pf *pipeFilter) optimize() {
+	// Substitute '*' prefixFilter with filterNoop, so `| filter *` matches all the rows
+	// in the same way as the `*` query does, even if the _msg field is missing after the previous pipes.
+	pf.f = removeStarFilters(pf.f)
+
 	optimizeFilterIn(pf.f)
 }
 
@@ -51,7 +53,7 @@ func (pf *pipeFilter) initFilterInValues(cache map[string][]string, getFieldValu
 	return &pfNew, nil
 }
 
-func (pf *pipeFilter) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pf *pipeFilter) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	shards := make([]pipeFilterProcessorShard, workersCount)
 
 	pfp := &pipeFilterProcessor{
@@ -40,6 +40,34 @@ func TestPipeFilter(t *testing.T) {
 		},
 	}, [][]Field{})
 
+	// star filter matches rows without _msg field
+	f("filter *", [][]Field{
+		{
+			{"a", `test`},
+		},
+		{
+			{"_msg", `abc`},
+		},
+	}, [][]Field{
+		{
+			{"a", `test`},
+		},
+		{
+			{"_msg", `abc`},
+		},
+	})
+
+	// star filter in the compound filter
+	f("filter a:=x or *", [][]Field{
+		{
+			{"a", `test`},
+		},
+	}, [][]Field{
+		{
+			{"a", `test`},
+		},
+	})
+
 	// filter mismatch
 	f("filter abc", [][]Field{
 		{
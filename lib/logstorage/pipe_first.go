@@ -0,0 +1,128 @@
+package logstorage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pipeFirst processes '| first ...' queries.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#first-pipe
+type pipeFirst struct {
+	// ps contains the equivalent 'sort ... limit N' pipe.
+	//
+	// The 'first' pipe is executed via top-N sort, which keeps only N rows per each shard.
+	ps *pipeSort
+}
+
+func (pf *pipeFirst) String() string {
+	return "first" + pipeLastFirstString(pf.ps)
+}
+
+func (pf *pipeFirst) canLiveTail() bool {
+	return false
+}
+
+func (pf *pipeFirst) updateNeededFields(neededFields, unneededFields *fieldsSet) {
+	pf.ps.updateNeededFields(neededFields, unneededFields)
+}
+
+func (pf *pipeFirst) optimize() {
+	// nothing to do
+}
+
+func (pf *pipeFirst) hasFilterInWithQuery() bool {
+	return false
+}
+
+func (pf *pipeFirst) initFilterInValues(_ map[string][]string, _ getFieldValuesFunc) (pipe, error) {
+	return pf, nil
+}
+
+func (pf *pipeFirst) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor, mb *memoryBudget) pipeProcessor {
+	return newPipeTopkProcessor(pf.ps, workersCount, stopCh, cancel, ppNext, mb)
+}
+
+func parsePipeFirst(lex *lexer) (*pipeFirst, error) {
+	if !lex.isKeyword("first") {
+		return nil, fmt.Errorf("expecting 'first'; got %q", lex.token)
+	}
+	lex.nextToken()
+
+	ps, err := parsePipeLastFirst(lex)
+	if err != nil {
+		return nil, err
+	}
+	pf := &pipeFirst{
+		ps: ps,
+	}
+	return pf, nil
+}
+
+// pipeLastFirstString returns string representation for the args of 'first' and 'last' pipes.
+func pipeLastFirstString(ps *pipeSort) string {
+	s := ""
+	if ps.limit != 1 {
+		s += fmt.Sprintf(" %d", ps.limit)
+	}
+
+	a := make([]string, len(ps.byFields))
+	for i, bf := range ps.byFields {
+		a[i] = bf.String()
+	}
+	s += " by (" + strings.Join(a, ", ") + ")"
+
+	if ps.rankName != "" {
+		s += " rank as " + quoteTokenIfNeeded(ps.rankName)
+	}
+	return s
+}
+
+// parsePipeLastFirst parses the args of 'first' and 'last' pipes into the equivalent 'sort ... limit N' pipe.
+func parsePipeLastFirst(lex *lexer) (*pipeSort, error) {
+	ps := &pipeSort{
+		limit: 1,
+	}
+
+	if !lex.isKeyword("by", "(") {
+		s := lex.token
+		n, ok := tryParseUint64(s)
+		if !ok {
+			return nil, fmt.Errorf("cannot parse the number of rows to return from %q", s)
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("the number of rows to return must be bigger than 0; got %q", s)
+		}
+		lex.nextToken()
+		ps.limit = n
+	}
+
+	if !lex.isKeyword("by", "(") {
+		return nil, fmt.Errorf("missing 'by (...)' clause; got %q", lex.token)
+	}
+	if lex.isKeyword("by") {
+		lex.nextToken()
+	}
+	bfs, err := parseBySortFields(lex)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse 'by' clause: %w", err)
+	}
+	if len(bfs) == 0 {
+		return nil, fmt.Errorf("'by' clause must contain at least a single field")
+	}
+	ps.byFields = bfs
+
+	if lex.isKeyword("rank") {
+		lex.nextToken()
+		if lex.isKeyword("as") {
+			lex.nextToken()
+		}
+		rankName, err := getCompoundToken(lex)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read rank field name: %s", err)
+		}
+		ps.rankName = rankName
+	}
+
+	return ps, nil
+}
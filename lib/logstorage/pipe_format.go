@@ -3,8 +3,12 @@ package logstorage
 import (
 	"fmt"
 	"math"
+	"net/url"
+	"strconv"
+	"strings"
 	"unsafe"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/valyala/quicktemplate"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
@@ -48,7 +52,7 @@ func (pf *pipeFormat) canLiveTail() bool {
 	return true
 }
 
-func (pf *pipeFormat) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pf *pipeFormat) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	if neededFields.isEmpty() {
 		if pf.iff != nil {
 			neededFields.addFields(pf.iff.neededFields)
@@ -56,9 +60,13 @@ func (pf *pipeFormat) updateNeededFields(neededFields, unneededFields fieldsSet)
 		return
 	}
 
+	// The original value of the result field must be read if it may be left untouched by the pipe.
+	// This is the case for rows, which do not match the optional if (...) filter.
+	needResultField := pf.keepOriginalFields || pf.skipEmptyResults || pf.iff != nil
+
 	if neededFields.contains("*") {
 		if !unneededFields.contains(pf.resultField) {
-			if !pf.keepOriginalFields && !pf.skipEmptyResults {
+			if !needResultField {
 				unneededFields.add(pf.resultField)
 			}
 			if pf.iff != nil {
@@ -72,7 +80,7 @@ func (pf *pipeFormat) updateNeededFields(neededFields, unneededFields fieldsSet)
 		}
 	} else {
 		if neededFields.contains(pf.resultField) {
-			if !pf.keepOriginalFields && !pf.skipEmptyResults {
+			if !needResultField {
 				neededFields.remove(pf.resultField)
 			}
 			if pf.iff != nil {
@@ -105,7 +113,7 @@ func (pf *pipeFormat) initFilterInValues(cache map[string][]string, getFieldValu
 	return &pfNew, nil
 }
 
-func (pf *pipeFormat) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pf *pipeFormat) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	return &pipeFormatProcessor{
 		pf:     pf,
 		ppNext: ppNext,
@@ -194,6 +202,23 @@ func (shard *pipeFormatProcessorShard) formatRow(pf *pipeFormat, br *blockResult
 			switch step.fieldOpt {
 			case "q":
 				b = quicktemplate.AppendJSONString(b, v, true)
+			case "uc":
+				b = append(b, strings.ToUpper(v)...)
+			case "lc":
+				b = append(b, strings.ToLower(v)...)
+			case "trim":
+				b = append(b, strings.TrimSpace(v)...)
+			case "urldecode":
+				s, err := url.QueryUnescape(v)
+				if err != nil {
+					b = append(b, v...)
+					continue
+				}
+				b = append(b, s...)
+			case "len":
+				b = strconv.AppendInt(b, int64(len(v)), 10)
+			case "hash":
+				b = strconv.AppendUint(b, xxhash.Sum64(bytesutil.ToUnsafeBytes(v)), 10)
 			case "time":
 				nsecs, ok := tryParseInt64(v)
 				if !ok {
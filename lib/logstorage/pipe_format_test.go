@@ -74,6 +74,33 @@ func TestPipeFormat(t *testing.T) {
 		},
 	})
 
+	// string transforms
+	f(`format 'uc=<uc:foo>, lc=<lc:foo>, trim=[<trim:bar>], url=<urldecode:baz>, len=<len:foo>, hash=<hash:foo>' as x`, [][]Field{
+		{
+			{"foo", `Foo Bar`},
+			{"bar", "  abc \t"},
+			{"baz", "a%20b+c%2Fd"},
+		},
+		{
+			{"foo", ``},
+			{"bar", `de`},
+			{"baz", "%zz"},
+		},
+	}, [][]Field{
+		{
+			{"foo", `Foo Bar`},
+			{"bar", "  abc \t"},
+			{"baz", "a%20b+c%2Fd"},
+			{"x", "uc=FOO BAR, lc=foo bar, trim=[abc], url=a b c/d, len=7, hash=14015567012313467217"},
+		},
+		{
+			{"foo", ``},
+			{"bar", `de`},
+			{"baz", "%zz"},
+			{"x", "uc=, lc=, trim=[de], url=%zz, len=0, hash=17241709254077376921"},
+		},
+	})
+
 	// skip_empty_results
 	f(`format '<foo><bar>' as x skip_empty_results`, [][]Field{
 		{
@@ -296,15 +323,15 @@ func TestPipeFormatUpdateNeededFields(t *testing.T) {
 	f(`format "foo" as x skip_empty_results`, "*", "", "*", "")
 	f(`format "foo" as x keep_original_fields`, "*", "", "*", "")
 	f(`format "<f1>foo" as x`, "*", "", "*", "x")
-	f(`format if (f2:z) "<f1>foo" as x`, "*", "", "*", "x")
+	f(`format if (f2:z) "<f1>foo" as x`, "*", "", "*", "")
 	f(`format if (f2:z) "<f1>foo" as x skip_empty_results`, "*", "", "*", "")
 	f(`format if (f2:z) "<f1>foo" as x keep_original_fields`, "*", "", "*", "")
 
 	// unneeded fields do not intersect with pattern and output field
 	f(`format "foo" as x`, "*", "f1,f2", "*", "f1,f2,x")
 	f(`format "<f3>foo" as x`, "*", "f1,f2", "*", "f1,f2,x")
-	f(`format if (f4:z) "<f3>foo" as x`, "*", "f1,f2", "*", "f1,f2,x")
-	f(`format if (f1:z) "<f3>foo" as x`, "*", "f1,f2", "*", "f2,x")
+	f(`format if (f4:z) "<f3>foo" as x`, "*", "f1,f2", "*", "f1,f2")
+	f(`format if (f1:z) "<f3>foo" as x`, "*", "f1,f2", "*", "f2")
 	f(`format if (f1:z) "<f3>foo" as x skip_empty_results`, "*", "f1,f2", "*", "f2")
 	f(`format if (f1:z) "<f3>foo" as x keep_original_fields`, "*", "f1,f2", "*", "f2")
 
@@ -312,10 +339,10 @@ func TestPipeFormatUpdateNeededFields(t *testing.T) {
 	f(`format "<f1>foo" as x`, "*", "f1,f2", "*", "f2,x")
 	f(`format "<f1>foo" as x skip_empty_results`, "*", "f1,f2", "*", "f2")
 	f(`format "<f1>foo" as x keep_original_fields`, "*", "f1,f2", "*", "f2")
-	f(`format if (f4:z) "<f1>foo" as x`, "*", "f1,f2", "*", "f2,x")
+	f(`format if (f4:z) "<f1>foo" as x`, "*", "f1,f2", "*", "f2")
 	f(`format if (f4:z) "<f1>foo" as x skip_empty_results`, "*", "f1,f2", "*", "f2")
 	f(`format if (f4:z) "<f1>foo" as x keep_original_fields`, "*", "f1,f2", "*", "f2")
-	f(`format if (f2:z) "<f1>foo" as x`, "*", "f1,f2", "*", "x")
+	f(`format if (f2:z) "<f1>foo" as x`, "*", "f1,f2", "*", "")
 	f(`format if (f2:z) "<f1>foo" as x skip_empty_results`, "*", "f1,f2", "*", "")
 	f(`format if (f2:z) "<f1>foo" as x keep_original_fields`, "*", "f1,f2", "*", "")
 
@@ -354,8 +381,8 @@ func TestPipeFormatUpdateNeededFields(t *testing.T) {
 	f(`format "<f1>foo" as f2`, "f2,y", "", "f1,y", "")
 	f(`format "<f1>foo" as f2 skip_empty_results`, "f2,y", "", "f1,f2,y", "")
 	f(`format "<f1>foo" as f2 keep_original_fields`, "f2,y", "", "f1,f2,y", "")
-	f(`format if (f3:z) "<f1>foo" as f2`, "f2,y", "", "f1,f3,y", "")
-	f(`format if (x:z or y:w) "<f1>foo" as f2`, "f2,y", "", "f1,x,y", "")
+	f(`format if (f3:z) "<f1>foo" as f2`, "f2,y", "", "f1,f2,f3,y", "")
+	f(`format if (x:z or y:w) "<f1>foo" as f2`, "f2,y", "", "f1,f2,x,y", "")
 	f(`format if (x:z or y:w) "<f1>foo" as f2 skip_empty_results`, "f2,y", "", "f1,f2,x,y", "")
 	f(`format if (x:z or y:w) "<f1>foo" as f2 keep_original_fields`, "f2,y", "", "f1,f2,x,y", "")
 
@@ -363,10 +390,10 @@ func TestPipeFormatUpdateNeededFields(t *testing.T) {
 	f(`format "<f1>foo" as f2`, "f1,f2,y", "", "f1,y", "")
 	f(`format "<f1>foo" as f2 skip_empty_results`, "f1,f2,y", "", "f1,f2,y", "")
 	f(`format "<f1>foo" as f2 keep_original_fields`, "f1,f2,y", "", "f1,f2,y", "")
-	f(`format if (f3:z) "<f1>foo" as f2`, "f1,f2,y", "", "f1,f3,y", "")
+	f(`format if (f3:z) "<f1>foo" as f2`, "f1,f2,y", "", "f1,f2,f3,y", "")
 	f(`format if (f3:z) "<f1>foo" as f2 skip_empty_results`, "f1,f2,y", "", "f1,f2,f3,y", "")
 	f(`format if (f3:z) "<f1>foo" as f2 keep_original_fields`, "f1,f2,y", "", "f1,f2,f3,y", "")
-	f(`format if (x:z or y:w) "<f1>foo" as f2`, "f1,f2,y", "", "f1,x,y", "")
+	f(`format if (x:z or y:w) "<f1>foo" as f2`, "f1,f2,y", "", "f1,f2,x,y", "")
 	f(`format if (x:z or y:w) "<f1>foo" as f2 skip_empty_results`, "f1,f2,y", "", "f1,f2,x,y", "")
 	f(`format if (x:z or y:w) "<f1>foo" as f2 keep_original_fields`, "f1,f2,y", "", "f1,f2,x,y", "")
 }
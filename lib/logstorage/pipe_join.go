@@ -0,0 +1,236 @@
+package logstorage
+
+import (
+	"fmt"
+	"slices"
+	"unsafe"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+// pipeJoin processes '| join ...' pipe.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#join-pipe
+type pipeJoin struct {
+	// byFields contains fields to join the results of q with the input logs.
+	byFields []string
+
+	// q is the query for obtaining the logs to join with the input logs.
+	q *Query
+
+	// isInner is set to true for inner join. Input logs without matching logs from q are dropped in this case.
+	//
+	// Input logs without matching logs from q are passed as is to the next pipe for left join.
+	isInner bool
+
+	// m contains logs from q grouped by byFields values.
+	//
+	// It is populated by initJoinMap before the query execution.
+	m map[string][][]Field
+}
+
+func (pj *pipeJoin) String() string {
+	s := fmt.Sprintf("join by (%s) (%s)", fieldNamesString(pj.byFields), pj.q.String())
+	if pj.isInner {
+		s += " inner"
+	}
+	return s
+}
+
+func (pj *pipeJoin) canLiveTail() bool {
+	return false
+}
+
+func (pj *pipeJoin) updateNeededFields(neededFields, unneededFields *fieldsSet) {
+	if neededFields.contains("*") {
+		unneededFields.removeFields(pj.byFields)
+	} else {
+		neededFields.addFields(pj.byFields)
+	}
+}
+
+func (pj *pipeJoin) optimize() {
+	pj.q.Optimize()
+}
+
+func (pj *pipeJoin) hasFilterInWithQuery() bool {
+	// 'in(subquery)' filters at pj.q are initialized when the join map is obtained.
+	return false
+}
+
+func (pj *pipeJoin) initFilterInValues(_ map[string][]string, _ getFieldValuesFunc) (pipe, error) {
+	return pj, nil
+}
+
+// initJoinMap returns new pipeJoin with the join map obtained via getJoinMapFunc.
+func (pj *pipeJoin) initJoinMap(getJoinMapFunc getJoinMapFunc) (*pipeJoin, error) {
+	m, err := getJoinMapFunc(pj.q, pj.byFields)
+	if err != nil {
+		return nil, fmt.Errorf("cannot execute query at [%s]: %w", pj, err)
+	}
+	pjNew := *pj
+	pjNew.m = m
+	return &pjNew, nil
+}
+
+// getJoinMapFunc must return logs from q grouped by byFields values, which are marshaled with marshalJoinKey.
+//
+// byFields must be excluded from the returned logs.
+type getJoinMapFunc func(q *Query, byFields []string) (map[string][][]Field, error)
+
+// marshalJoinKey appends the key for the given byFields values to dst and returns the result.
+func marshalJoinKey(dst []byte, values []string) []byte {
+	for _, v := range values {
+		dst = encoding.MarshalBytes(dst, bytesutil.ToUnsafeBytes(v))
+	}
+	return dst
+}
+
+func (pj *pipeJoin) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
+	return &pipeJoinProcessor{
+		pj:     pj,
+		ppNext: ppNext,
+
+		shards: make([]pipeJoinProcessorShard, workersCount),
+	}
+}
+
+type pipeJoinProcessor struct {
+	pj     *pipeJoin
+	ppNext pipeProcessor
+
+	shards []pipeJoinProcessorShard
+}
+
+type pipeJoinProcessorShard struct {
+	pipeJoinProcessorShardNopad
+
+	// The padding prevents false sharing on widespread platforms with 128 mod (cache line size) = 0 .
+	_ [128 - unsafe.Sizeof(pipeJoinProcessorShardNopad{})%128]byte
+}
+
+type pipeJoinProcessorShardNopad struct {
+	wctx pipeUnpackWriteContext
+
+	columnValues [][]string
+	values       []string
+	keyBuf       []byte
+}
+
+func (pjp *pipeJoinProcessor) writeBlock(workerID uint, br *blockResult) {
+	if len(br.timestamps) == 0 {
+		return
+	}
+
+	pj := pjp.pj
+	shard := &pjp.shards[workerID]
+	shard.wctx.init(workerID, pjp.ppNext, false, false, br)
+
+	columnValues := shard.columnValues[:0]
+	for _, f := range pj.byFields {
+		c := br.getColumnByName(f)
+		columnValues = append(columnValues, c.getValues(br))
+	}
+	shard.columnValues = columnValues
+
+	for rowIdx := range br.timestamps {
+		values := shard.values[:0]
+		for _, cv := range columnValues {
+			values = append(values, cv[rowIdx])
+		}
+		shard.values = values
+
+		var matchingRows [][]Field
+		if !areEmptyJoinValues(values) {
+			shard.keyBuf = marshalJoinKey(shard.keyBuf[:0], values)
+			matchingRows = pj.m[string(shard.keyBuf)]
+		}
+
+		if len(matchingRows) == 0 {
+			if !pj.isInner {
+				shard.wctx.writeRow(rowIdx, nil)
+			}
+			continue
+		}
+		for _, extraFields := range matchingRows {
+			shard.wctx.writeRow(rowIdx, extraFields)
+		}
+	}
+
+	shard.wctx.flush()
+	shard.wctx.reset()
+}
+
+// areEmptyJoinValues returns true if all the values are empty.
+//
+// Logs with empty values for all the join fields aren't joined.
+func areEmptyJoinValues(values []string) bool {
+	for _, v := range values {
+		if v != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (pjp *pipeJoinProcessor) flush() error {
+	return nil
+}
+
+func parsePipeJoin(lex *lexer) (*pipeJoin, error) {
+	if !lex.isKeyword("join") {
+		return nil, fmt.Errorf("unexpected token: %q; want %q", lex.token, "join")
+	}
+	lex.nextToken()
+
+	// parse by (...)
+	if lex.isKeyword("by") {
+		lex.nextToken()
+	}
+	byFields, err := parseFieldNamesInParens(lex)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse 'by(...)' at 'join': %w", err)
+	}
+	if len(byFields) == 0 {
+		return nil, fmt.Errorf("'by(...)' at 'join' must contain at least a single field")
+	}
+	if slices.Contains(byFields, "*") {
+		return nil, fmt.Errorf("join by '*' isn't supported")
+	}
+	for _, f := range byFields {
+		if isWildcardFieldName(f) {
+			return nil, fmt.Errorf("join by wildcard field %q isn't supported", f)
+		}
+	}
+
+	// parse (query)
+	if !lex.isKeyword("(") {
+		return nil, fmt.Errorf("missing '(' in front of the query at 'join' pipe")
+	}
+	lex.nextToken()
+	q, err := parseQuery(lex)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse query at 'join': %w", err)
+	}
+	if !lex.isKeyword(")") {
+		return nil, fmt.Errorf("missing ')' after 'join(%s)'", q)
+	}
+	lex.nextToken()
+
+	pj := &pipeJoin{
+		byFields: byFields,
+		q:        q,
+	}
+
+	// parse optional join mode
+	switch {
+	case lex.isKeyword("inner"):
+		lex.nextToken()
+		pj.isInner = true
+	case lex.isKeyword("left"):
+		lex.nextToken()
+	}
+
+	return pj, nil
+}
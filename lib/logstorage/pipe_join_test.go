@@ -0,0 +1,240 @@
+package logstorage
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePipeJoinSuccess(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeSuccess(t, pipeStr)
+	}
+
+	f(`join by (foo) (error)`)
+	f(`join by (foo, bar) (error)`)
+	f(`join by (foo) (error | fields foo, bar)`)
+	f(`join by (foo) (_time:1h error | stats by (foo) count(*) as errors)`)
+	f(`join by (foo) (error) inner`)
+	f(`join by (foo) (x:in(y | fields x)) inner`)
+}
+
+func TestParsePipeJoinFailure(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeFailure(t, pipeStr)
+	}
+
+	f(`join`)
+	f(`join by`)
+	f(`join by ()`)
+	f(`join by (*)`)
+	f(`join by (foo*)`)
+	f(`join by (foo)`)
+	f(`join by (foo) ()`)
+	f(`join by (foo) (`)
+	f(`join by (foo) (error`)
+	f(`join by (foo) error`)
+	f(`join by (foo) (error) outer`)
+}
+
+func TestPipeJoin(t *testing.T) {
+	f := func(pipeStr string, joinRows, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeJoinResults(t, pipeStr, joinRows, rows, rowsExpected)
+	}
+
+	joinRows := [][]Field{
+		{
+			{"user", "alice"},
+			{"email", "alice@example.com"},
+		},
+		{
+			{"user", "bob"},
+			{"email", "bob@example.com"},
+			{"role", "admin"},
+		},
+		{
+			{"user", "bob"},
+			{"email", "bob@example.org"},
+		},
+		{
+			{"email", "nobody@example.com"},
+		},
+	}
+
+	rows := [][]Field{
+		{
+			{"_msg", "login"},
+			{"user", "alice"},
+		},
+		{
+			{"_msg", "logout"},
+			{"user", "bob"},
+			{"email", "old@example.com"},
+		},
+		{
+			{"_msg", "error"},
+			{"user", "charlie"},
+		},
+		{
+			{"_msg", "anonymous"},
+		},
+	}
+
+	// left join
+	f(`join by (user) (*)`, joinRows, rows, [][]Field{
+		{
+			{"_msg", "login"},
+			{"user", "alice"},
+			{"email", "alice@example.com"},
+		},
+		{
+			{"_msg", "logout"},
+			{"user", "bob"},
+			{"email", "bob@example.com"},
+			{"role", "admin"},
+		},
+		{
+			{"_msg", "logout"},
+			{"user", "bob"},
+			{"email", "bob@example.org"},
+		},
+		{
+			{"_msg", "error"},
+			{"user", "charlie"},
+		},
+		{
+			{"_msg", "anonymous"},
+		},
+	})
+
+	// inner join
+	f(`join by (user) (*) inner`, joinRows, rows, [][]Field{
+		{
+			{"_msg", "login"},
+			{"user", "alice"},
+			{"email", "alice@example.com"},
+		},
+		{
+			{"_msg", "logout"},
+			{"user", "bob"},
+			{"email", "bob@example.com"},
+			{"role", "admin"},
+		},
+		{
+			{"_msg", "logout"},
+			{"user", "bob"},
+			{"email", "bob@example.org"},
+		},
+	})
+
+	// join by multiple fields
+	f(`join by (host, app) (*) inner`, [][]Field{
+		{
+			{"host", "h1"},
+			{"app", "nginx"},
+			{"owner", "web"},
+		},
+		{
+			{"host", "h1"},
+			{"app", "postgres"},
+			{"owner", "db"},
+		},
+	}, [][]Field{
+		{
+			{"host", "h1"},
+			{"app", "postgres"},
+		},
+		{
+			{"host", "h2"},
+			{"app", "nginx"},
+		},
+	}, [][]Field{
+		{
+			{"host", "h1"},
+			{"app", "postgres"},
+			{"owner", "db"},
+		},
+	})
+
+	// empty join results
+	f(`join by (user) (*) inner`, nil, rows, nil)
+	f(`join by (user) (*)`, nil, rows, rows)
+}
+
+func TestPipeJoinUpdateNeededFields(t *testing.T) {
+	f := func(s, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected string) {
+		t.Helper()
+		expectPipeNeededFields(t, s, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected)
+	}
+
+	// all the needed fields
+	f("join by (x, y) (foo)", "*", "", "*", "")
+
+	// all the needed fields, unneeded fields do not intersect with by fields
+	f("join by (x, y) (foo)", "*", "f1,f2", "*", "f1,f2")
+
+	// all the needed fields, unneeded fields intersect with by fields
+	f("join by (x, y) (foo)", "*", "f1,x", "*", "f1")
+
+	// needed fields do not intersect with by fields
+	f("join by (x, y) (foo)", "f1,f2", "", "f1,f2,x,y", "")
+
+	// needed fields intersect with by fields
+	f("join by (x, y) (foo)", "f1,x", "", "f1,x,y", "")
+}
+
+func expectPipeJoinResults(t *testing.T, pipeStr string, joinRows, rows, rowsExpected [][]Field) {
+	t.Helper()
+
+	lex := newLexer(pipeStr)
+	p, err := parsePipe(lex)
+	if err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+	}
+	p.optimize()
+
+	getJoinMap := func(_ *Query, byFields []string) (map[string][][]Field, error) {
+		return newTestJoinMap(joinRows, byFields), nil
+	}
+	pj, err := p.(*pipeJoin).initJoinMap(getJoinMap)
+	if err != nil {
+		t.Fatalf("unexpected error when initializing join map for %q: %s", pipeStr, err)
+	}
+
+	workersCount := 5
+	ppTest := newTestPipeProcessor()
+	pp := pj.newPipeProcessor(workersCount, make(chan struct{}), func() {}, ppTest, newMemoryBudget(0))
+
+	brw := newTestBlockResultWriter(workersCount, pp)
+	for _, row := range rows {
+		brw.writeRow(row)
+	}
+	brw.flush()
+	pp.flush()
+
+	ppTest.expectRows(t, rowsExpected)
+}
+
+func newTestJoinMap(rows [][]Field, byFields []string) map[string][][]Field {
+	m := make(map[string][][]Field)
+	for _, row := range rows {
+		values := make([]string, len(byFields))
+		var fields []Field
+		for _, f := range row {
+			idx := slices.Index(byFields, f.Name)
+			if idx >= 0 {
+				values[idx] = f.Value
+			} else {
+				fields = append(fields, f)
+			}
+		}
+		if areEmptyJoinValues(values) {
+			continue
+		}
+		key := string(marshalJoinKey(nil, values))
+		m[key] = append(m[key], fields)
+	}
+	return m
+}
@@ -0,0 +1,60 @@
+package logstorage
+
+import (
+	"fmt"
+)
+
+// pipeLast processes '| last ...' queries.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#last-pipe
+type pipeLast struct {
+	// ps contains the equivalent 'sort ... desc limit N' pipe.
+	//
+	// The 'last' pipe is executed via top-N sort, which keeps only N rows per each shard.
+	ps *pipeSort
+}
+
+func (pl *pipeLast) String() string {
+	return "last" + pipeLastFirstString(pl.ps)
+}
+
+func (pl *pipeLast) canLiveTail() bool {
+	return false
+}
+
+func (pl *pipeLast) updateNeededFields(neededFields, unneededFields *fieldsSet) {
+	pl.ps.updateNeededFields(neededFields, unneededFields)
+}
+
+func (pl *pipeLast) optimize() {
+	// nothing to do
+}
+
+func (pl *pipeLast) hasFilterInWithQuery() bool {
+	return false
+}
+
+func (pl *pipeLast) initFilterInValues(_ map[string][]string, _ getFieldValuesFunc) (pipe, error) {
+	return pl, nil
+}
+
+func (pl *pipeLast) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor, mb *memoryBudget) pipeProcessor {
+	return newPipeTopkProcessor(pl.ps, workersCount, stopCh, cancel, ppNext, mb)
+}
+
+func parsePipeLast(lex *lexer) (*pipeLast, error) {
+	if !lex.isKeyword("last") {
+		return nil, fmt.Errorf("expecting 'last'; got %q", lex.token)
+	}
+	lex.nextToken()
+
+	ps, err := parsePipeLastFirst(lex)
+	if err != nil {
+		return nil, err
+	}
+	ps.isDesc = true
+	pl := &pipeLast{
+		ps: ps,
+	}
+	return pl, nil
+}
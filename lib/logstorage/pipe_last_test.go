@@ -0,0 +1,134 @@
+package logstorage
+
+import (
+	"testing"
+)
+
+func TestParsePipeLastSuccess(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeSuccess(t, pipeStr)
+	}
+
+	f(`last by (x)`)
+	f(`last 10 by (x)`)
+	f(`last 10 by (x desc, y)`)
+	f(`last 10 by (_time) rank as foo`)
+}
+
+func TestParsePipeLastFailure(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeFailure(t, pipeStr)
+	}
+
+	f(`last`)
+	f(`last 10`)
+	f(`last 0 by (x)`)
+	f(`last -1 by (x)`)
+	f(`last foo by (x)`)
+	f(`last by ()`)
+	f(`last by (x`)
+	f(`last by (x) rank`)
+}
+
+func TestPipeLast(t *testing.T) {
+	f := func(pipeStr string, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeResults(t, pipeStr, rows, rowsExpected)
+	}
+
+	rows := [][]Field{
+		{
+			{"_time", "2024-06-01T00:00:03Z"},
+			{"_msg", "msg 3"},
+			{"a", "12"},
+		},
+		{
+			{"_time", "2024-06-01T00:00:01Z"},
+			{"_msg", "msg 1"},
+			{"a", "5"},
+		},
+		{
+			{"_time", "2024-06-01T00:00:04Z"},
+			{"_msg", "msg 4"},
+			{"a", "3"},
+		},
+		{
+			{"_time", "2024-06-01T00:00:02Z"},
+			{"_msg", "msg 2"},
+			{"a", "100"},
+		},
+	}
+
+	f("last by (_time)", rows, [][]Field{
+		{
+			{"_time", "2024-06-01T00:00:04Z"},
+			{"_msg", "msg 4"},
+			{"a", "3"},
+		},
+	})
+	f("last 2 by (_time)", rows, [][]Field{
+		{
+			{"_time", "2024-06-01T00:00:04Z"},
+			{"_msg", "msg 4"},
+			{"a", "3"},
+		},
+		{
+			{"_time", "2024-06-01T00:00:03Z"},
+			{"_msg", "msg 3"},
+			{"a", "12"},
+		},
+	})
+	f("last 2 by (_time) rank as r", rows, [][]Field{
+		{
+			{"_time", "2024-06-01T00:00:04Z"},
+			{"_msg", "msg 4"},
+			{"a", "3"},
+			{"r", "1"},
+		},
+		{
+			{"_time", "2024-06-01T00:00:03Z"},
+			{"_msg", "msg 3"},
+			{"a", "12"},
+			{"r", "2"},
+		},
+	})
+
+	// numeric sort
+	f("last 2 by (a)", rows, [][]Field{
+		{
+			{"_time", "2024-06-01T00:00:02Z"},
+			{"_msg", "msg 2"},
+			{"a", "100"},
+		},
+		{
+			{"_time", "2024-06-01T00:00:03Z"},
+			{"_msg", "msg 3"},
+			{"a", "12"},
+		},
+	})
+
+	// the number of rows exceeds the number of input rows
+	f("last 10 by (a)", rows, rows)
+}
+
+func TestPipeLastUpdateNeededFields(t *testing.T) {
+	f := func(s, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected string) {
+		t.Helper()
+		expectPipeNeededFields(t, s, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected)
+	}
+
+	// all the needed fields
+	f("last 10 by (s1,s2)", "*", "", "*", "")
+	f("last 10 by (s1,s2) rank as x", "*", "", "*", "x")
+
+	// all the needed fields, unneeded fields intersect with src
+	f("last 10 by (s1,s2)", "*", "s1,f1,f2", "*", "f1,f2")
+
+	// needed fields do not intersect with src
+	f("last 10 by (s1,s2)", "f1,f2", "", "_time,s1,s2,f1,f2", "")
+
+	// needed fields intersect with src
+	f("last 10 by (s1,s2) rank as x", "s1,f1,f2,x", "", "_time,s1,s2,f1,f2", "")
+}
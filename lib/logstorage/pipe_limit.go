@@ -2,7 +2,13 @@ package logstorage
 
 import (
 	"fmt"
+	"slices"
+	"sync"
 	"sync/atomic"
+	"unsafe"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 )
 
 // pipeLimit implements '| limit ...' pipe.
@@ -10,18 +16,35 @@ import (
 // See https://docs.victoriametrics.com/victorialogs/logsql/#limit-pipe
 type pipeLimit struct {
 	limit uint64
+
+	// byFields contains optional fields for grouping rows.
+	//
+	// If byFields isn't empty, then up to limit rows are returned per each group.
+	byFields []string
 }
 
 func (pl *pipeLimit) String() string {
-	return fmt.Sprintf("limit %d", pl.limit)
+	s := fmt.Sprintf("limit %d", pl.limit)
+	if len(pl.byFields) > 0 {
+		s += " by (" + fieldNamesString(pl.byFields) + ")"
+	}
+	return s
 }
 
 func (pl *pipeLimit) canLiveTail() bool {
 	return false
 }
 
-func (pl *pipeLimit) updateNeededFields(_, _ fieldsSet) {
-	// nothing to do
+func (pl *pipeLimit) updateNeededFields(neededFields, unneededFields *fieldsSet) {
+	if len(pl.byFields) == 0 {
+		return
+	}
+
+	if neededFields.contains("*") {
+		unneededFields.removeFields(pl.byFields)
+	} else {
+		neededFields.addFields(pl.byFields)
+	}
 }
 
 func (pl *pipeLimit) optimize() {
@@ -36,11 +59,14 @@ func (pl *pipeLimit) initFilterInValues(_ map[string][]string, _ getFieldValuesF
 	return pl, nil
 }
 
-func (pl *pipeLimit) newPipeProcessor(_ int, _ <-chan struct{}, cancel func(), ppNext pipeProcessor) pipeProcessor {
+func (pl *pipeLimit) newPipeProcessor(workersCount int, _ <-chan struct{}, cancel func(), ppNext pipeProcessor, mb *memoryBudget) pipeProcessor {
 	if pl.limit == 0 {
 		// Special case - notify the caller to stop writing data to the returned pipeLimitProcessor
 		cancel()
 	}
+	if len(pl.byFields) > 0 {
+		return newPipeLimitByProcessor(pl, workersCount, cancel, ppNext, mb)
+	}
 	return &pipeLimitProcessor{
 		pl:     pl,
 		cancel: cancel,
@@ -92,6 +118,168 @@ func (plp *pipeLimitProcessor) flush() error {
 	return nil
 }
 
+func newPipeLimitByProcessor(pl *pipeLimit, workersCount int, cancel func(), ppNext pipeProcessor, mb *memoryBudget) *pipeLimitByProcessor {
+	plp := &pipeLimitByProcessor{
+		pl:     pl,
+		cancel: cancel,
+		ppNext: ppNext,
+
+		shards: make([]pipeLimitByProcessorShard, workersCount),
+
+		m: make(map[string]*uint64),
+
+		pmb: mb.newPipeMemoryBudget(pl),
+	}
+	return plp
+}
+
+// pipeLimitByProcessor processes '| limit N by (...)' pipe.
+//
+// It passes the rows to ppNext as soon as they are received, while the number of rows passed per each group doesn't exceed pl.limit.
+type pipeLimitByProcessor struct {
+	pl     *pipeLimit
+	cancel func()
+	ppNext pipeProcessor
+
+	shards []pipeLimitByProcessorShard
+
+	// mu protects m and stateSizeBudget
+	mu sync.Mutex
+
+	// m holds the number of rows passed to ppNext per each group key.
+	m map[string]*uint64
+
+	// stateSizeBudget is the remaining budget for the size of m.
+	// The budget is provided in chunks from pmb.
+	stateSizeBudget int
+
+	pmb *pipeMemoryBudget
+}
+
+type pipeLimitByProcessorShard struct {
+	pipeLimitByProcessorShardNopad
+
+	// The padding prevents false sharing on widespread platforms with 128 mod (cache line size) = 0 .
+	_ [128 - unsafe.Sizeof(pipeLimitByProcessorShardNopad{})%128]byte
+}
+
+type pipeLimitByProcessorShardNopad struct {
+	// keysBuf is a temporary buffer for building group keys for the rows of the processed block.
+	keysBuf []byte
+
+	// keyEnds contains end offsets at keysBuf for the group keys of the processed block.
+	keyEnds []int
+
+	// columnValues is a temporary buffer for the processed column values.
+	columnValues [][]string
+
+	// bm holds the rows to pass to ppNext.
+	bm bitmap
+
+	// br is used for passing the selected rows to ppNext.
+	br blockResult
+}
+
+func (plp *pipeLimitByProcessor) writeBlock(workerID uint, br *blockResult) {
+	if len(br.timestamps) == 0 {
+		return
+	}
+
+	shard := &plp.shards[workerID]
+
+	// Build group keys outside the lock in order to reduce contention.
+	columnValues := shard.columnValues[:0]
+	for _, f := range plp.pl.byFields {
+		c := br.getColumnByName(f)
+		values := c.getValues(br)
+		columnValues = append(columnValues, values)
+	}
+	shard.columnValues = columnValues
+
+	keysBuf := shard.keysBuf[:0]
+	keyEnds := shard.keyEnds[:0]
+	for rowIdx := range br.timestamps {
+		for _, values := range columnValues {
+			keysBuf = encoding.MarshalBytes(keysBuf, bytesutil.ToUnsafeBytes(values[rowIdx]))
+		}
+		keyEnds = append(keyEnds, len(keysBuf))
+	}
+	shard.keysBuf = keysBuf
+	shard.keyEnds = keyEnds
+
+	bm := &shard.bm
+	bm.init(len(br.timestamps))
+	if !plp.selectRows(bm, keysBuf, keyEnds) {
+		// The state size is too big. Stop processing data in order to avoid OOM crash.
+		return
+	}
+
+	if bm.areAllBitsSet() {
+		// Fast path - pass all the rows to ppNext.
+		plp.ppNext.writeBlock(workerID, br)
+		return
+	}
+	if bm.isZero() {
+		// Nothing to pass
+		return
+	}
+
+	// Slow path - pass only the selected rows to ppNext.
+	shard.br.initFromFilterAllColumns(br, bm)
+	plp.ppNext.writeBlock(workerID, &shard.br)
+}
+
+// selectRows sets bits at bm for rows, which must be passed to ppNext.
+//
+// keysBuf must contain group keys for the rows, while keyEnds must contain end offsets for these keys at keysBuf.
+//
+// false is returned if the state size exceeds the memory budget.
+func (plp *pipeLimitByProcessor) selectRows(bm *bitmap, keysBuf []byte, keyEnds []int) bool {
+	limit := plp.pl.limit
+
+	plp.mu.Lock()
+	defer plp.mu.Unlock()
+
+	for plp.stateSizeBudget < 0 {
+		// steal some budget for the state size from the global budget.
+		if !plp.pmb.borrow(stateSizeBudgetChunk) {
+			// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
+			plp.cancel()
+			return false
+		}
+		plp.stateSizeBudget += stateSizeBudgetChunk
+	}
+
+	m := plp.m
+	keyStart := 0
+	for rowIdx, keyEnd := range keyEnds {
+		key := keysBuf[keyStart:keyEnd]
+		keyStart = keyEnd
+
+		pn := m[string(key)]
+		if pn == nil {
+			keyCopy := string(key)
+			pn = new(uint64)
+			m[keyCopy] = pn
+			plp.stateSizeBudget -= len(keyCopy) + int(unsafe.Sizeof(keyCopy)+unsafe.Sizeof(pn)+unsafe.Sizeof(*pn))
+		}
+		if *pn < limit {
+			*pn++
+			bm.setBit(rowIdx)
+		}
+	}
+	return true
+}
+
+func (plp *pipeLimitByProcessor) flush() error {
+	defer plp.pmb.release()
+
+	if plp.pmb.isExceeded() {
+		return plp.pmb.limitError()
+	}
+	return nil
+}
+
 func parsePipeLimit(lex *lexer) (*pipeLimit, error) {
 	if !lex.isKeyword("limit", "head") {
 		return nil, fmt.Errorf("expecting 'limit' or 'head'; got %q", lex.token)
@@ -99,7 +287,7 @@ func parsePipeLimit(lex *lexer) (*pipeLimit, error) {
 	lex.nextToken()
 
 	limit := uint64(10)
-	if !lex.isKeyword("|", ")", "") {
+	if !lex.isKeyword("|", ")", "", "by", "(") {
 		n, err := parseUint(lex.token)
 		if err != nil {
 			return nil, fmt.Errorf("cannot parse rows limit from %q: %w", lex.token, err)
@@ -111,5 +299,20 @@ func parsePipeLimit(lex *lexer) (*pipeLimit, error) {
 	pl := &pipeLimit{
 		limit: limit,
 	}
+
+	if lex.isKeyword("by", "(") {
+		if lex.isKeyword("by") {
+			lex.nextToken()
+		}
+		bfs, err := parseFieldNamesInParens(lex)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse 'by' clause: %w", err)
+		}
+		if slices.Contains(bfs, "*") {
+			return nil, fmt.Errorf("'*' isn't allowed in 'by' clause of 'limit' pipe")
+		}
+		pl.byFields = bfs
+	}
+
 	return pl, nil
 }
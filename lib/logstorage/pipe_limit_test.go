@@ -12,6 +12,8 @@ func TestParsePipeLimitSuccess(t *testing.T) {
 
 	f(`limit 10`)
 	f(`limit 10000`)
+	f(`limit 10 by (foo)`)
+	f(`limit 10 by (foo, bar)`)
 }
 
 func TestParsePipeLimitFailure(t *testing.T) {
@@ -22,6 +24,10 @@ func TestParsePipeLimitFailure(t *testing.T) {
 
 	f(`limit -10`)
 	f(`limit foo`)
+	f(`limit 10 by`)
+	f(`limit 10 by (`)
+	f(`limit 10 by (*)`)
+	f(`limit by (foo`)
 }
 
 func TestPipeLimit(t *testing.T) {
@@ -106,6 +112,120 @@ func TestPipeLimit(t *testing.T) {
 	})
 }
 
+func TestPipeLimitBy(t *testing.T) {
+	f := func(pipeStr string, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeResults(t, pipeStr, rows, rowsExpected)
+	}
+
+	rows := [][]Field{
+		{
+			{"host", "a"},
+			{"_msg", "foo"},
+		},
+		{
+			{"host", "b"},
+			{"_msg", "bar"},
+		},
+		{
+			{"host", "a"},
+			{"_msg", "baz"},
+		},
+		{
+			{"_msg", "abc"},
+		},
+		{
+			{"host", "a"},
+			{"_msg", "qwe"},
+		},
+	}
+
+	f("limit 0 by (host)", rows, [][]Field{})
+
+	f("limit 1 by (host)", rows, [][]Field{
+		{
+			{"host", "a"},
+			{"_msg", "foo"},
+		},
+		{
+			{"host", "b"},
+			{"_msg", "bar"},
+		},
+		{
+			{"_msg", "abc"},
+		},
+	})
+
+	f("limit 2 by (host)", rows, [][]Field{
+		{
+			{"host", "a"},
+			{"_msg", "foo"},
+		},
+		{
+			{"host", "b"},
+			{"_msg", "bar"},
+		},
+		{
+			{"host", "a"},
+			{"_msg", "baz"},
+		},
+		{
+			{"_msg", "abc"},
+		},
+	})
+
+	f("head by (host, _msg)", rows, rows)
+}
+
+func TestPipeLimitByPassesRowsBeforeFlush(t *testing.T) {
+	lex := newLexer("limit 2 by (host)")
+	p, err := parsePipe(lex)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ppTest := newTestPipeProcessor()
+	pp := p.newPipeProcessor(1, make(chan struct{}), func() {}, ppTest, newMemoryBudget(0))
+	pp.writeBlock(0, newTestStatsBlockResult([][]Field{
+		{
+			{"host", "a"},
+			{"_msg", "foo"},
+		},
+		{
+			{"host", "a"},
+			{"_msg", "bar"},
+		},
+		{
+			{"host", "a"},
+			{"_msg", "baz"},
+		},
+		{
+			{"host", "b"},
+			{"_msg", "qwe"},
+		},
+	}))
+
+	// The rows must be passed to the next pipe without waiting for flush()
+	ppTest.expectRows(t, [][]Field{
+		{
+			{"host", "a"},
+			{"_msg", "foo"},
+		},
+		{
+			{"host", "a"},
+			{"_msg", "bar"},
+		},
+		{
+			{"host", "b"},
+			{"_msg", "qwe"},
+		},
+	})
+
+	if err := pp.flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
 func TestPipeLimitUpdateNeededFields(t *testing.T) {
 	f := func(s, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected string) {
 		t.Helper()
@@ -120,4 +240,16 @@ func TestPipeLimitUpdateNeededFields(t *testing.T) {
 
 	// needed fields
 	f("limit 10", "f1,f2", "", "f1,f2", "")
+
+	// all the needed fields, unneeded fields do not intersect with by fields
+	f("limit 10 by (x)", "*", "f1,f2", "*", "f1,f2")
+
+	// all the needed fields, unneeded fields intersect with by fields
+	f("limit 10 by (x, f1)", "*", "f1,f2", "*", "f2")
+
+	// needed fields do not intersect with by fields
+	f("limit 10 by (x)", "f1,f2", "", "f1,f2,x", "")
+
+	// needed fields intersect with by fields
+	f("limit 10 by (x, f1)", "f1,f2", "", "f1,f2,x", "")
 }
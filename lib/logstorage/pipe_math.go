@@ -40,6 +40,11 @@ type mathExpr struct {
 	// if fieldName isn't empty, then the given mathExpr fetches numeric values from the given fieldName.
 	fieldName string
 
+	// fieldFunc is an optional function for converting fieldName values to numeric values.
+	//
+	// If it isn't set, then fieldName values are parsed as numbers.
+	fieldFunc func(v string) float64
+
 	// args are args for the given mathExpr.
 	args []*mathExpr
 
@@ -84,7 +89,11 @@ func (me *mathExpr) String() string {
 		return me.constValueStr
 	}
 	if me.fieldName != "" {
-		return quoteTokenIfNeeded(me.fieldName)
+		s := quoteTokenIfNeeded(me.fieldName)
+		if me.fieldFunc != nil {
+			s = fmt.Sprintf("%s(%s)", me.op, s)
+		}
+		return s
 	}
 
 	args := me.args
@@ -188,7 +197,7 @@ type mathBinaryOp struct {
 	f        mathFunc
 }
 
-func (pm *pipeMath) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pm *pipeMath) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	for i := len(pm.entries) - 1; i >= 0; i-- {
 		e := pm.entries[i]
 		if neededFields.contains("*") {
@@ -208,7 +217,7 @@ func (pm *pipeMath) updateNeededFields(neededFields, unneededFields fieldsSet) {
 	}
 }
 
-func (me *mathExpr) updateNeededFields(neededFields fieldsSet) {
+func (me *mathExpr) updateNeededFields(neededFields *fieldsSet) {
 	if me.isConst {
 		return
 	}
@@ -233,7 +242,7 @@ func (pm *pipeMath) initFilterInValues(_ map[string][]string, _ getFieldValuesFu
 	return pm, nil
 }
 
-func (pm *pipeMath) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pm *pipeMath) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	pmp := &pipeMathProcessor{
 		pm:     pm,
 		ppNext: ppNext,
@@ -310,7 +319,11 @@ func (shard *pipeMathProcessorShard) executeExpr(me *mathExpr, br *blockResult)
 		var f float64
 		for i, v := range values {
 			if i == 0 || v != values[i-1] {
-				f = parseMathNumber(v)
+				if me.fieldFunc != nil {
+					f = me.fieldFunc(v)
+				} else {
+					f = parseMathNumber(v)
+				}
 			}
 			r[i] = f
 		}
@@ -454,9 +467,20 @@ func parseMathExpr(lex *lexer) (*mathExpr, error) {
 		}
 
 		// balance operands according to their priority
-		if !left.wrappedInParens && isMathBinaryOp(left.op) && getMathBinaryOpPriority(left.op) > getMathBinaryOpPriority(op) {
-			me.args[0] = left.args[1]
-			left.args[1] = me
+		opPriority := getMathBinaryOpPriority(op)
+		if !left.wrappedInParens && isMathBinaryOp(left.op) && getMathBinaryOpPriority(left.op) > opPriority {
+			// Find the rightmost operand, which must be bound to op, since it has lower priority than op.
+			// For example, `a + b * c ^ d` must be parsed as `a + (b * (c ^ d))`.
+			parent := left
+			for {
+				rightmost := parent.args[1]
+				if rightmost.wrappedInParens || !isMathBinaryOp(rightmost.op) || getMathBinaryOpPriority(rightmost.op) <= opPriority {
+					break
+				}
+				parent = rightmost
+			}
+			me.args[0] = parent.args[1]
+			parent.args[1] = me
 			me = left
 		}
 
@@ -505,6 +529,8 @@ func parseMathExprOperand(lex *lexer) (*mathExpr, error) {
 		return parseMathExprCeil(lex)
 	case lex.isKeyword("floor"):
 		return parseMathExprFloor(lex)
+	case lex.isKeyword("len"):
+		return parseMathExprLen(lex)
 	case lex.isKeyword("-"):
 		return parseMathExprUnaryMinus(lex)
 	case lex.isKeyword("+"):
@@ -606,6 +632,34 @@ func parseMathExprFloor(lex *lexer) (*mathExpr, error) {
 	return me, nil
 }
 
+func parseMathExprLen(lex *lexer) (*mathExpr, error) {
+	if !lex.isKeyword("len") {
+		return nil, fmt.Errorf("missing 'len' keyword")
+	}
+	lex.nextToken()
+
+	if !lex.isKeyword("(") {
+		return nil, fmt.Errorf("missing '(' after 'len'")
+	}
+	lex.nextToken()
+
+	fieldName, err := getCompoundMathToken(lex)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse field name for 'len' function: %w", err)
+	}
+	if !lex.isKeyword(")") {
+		return nil, fmt.Errorf("'len' function accepts only a single field name; unexpected token after [%s]: %q; want ')'", fieldName, lex.token)
+	}
+	lex.nextToken()
+
+	me := &mathExpr{
+		fieldName: getCanonicalColumnName(fieldName),
+		fieldFunc: mathFieldFuncLen,
+		op:        "len",
+	}
+	return me, nil
+}
+
 func parseMathExprGenericFunc(lex *lexer, funcName string, f mathFunc) (*mathExpr, error) {
 	if !lex.isKeyword(funcName) {
 		return nil, fmt.Errorf("missing %q keyword", funcName)
@@ -726,6 +780,10 @@ func getCompoundMathToken(lex *lexer) (string, error) {
 	return rawS + suffix, nil
 }
 
+func mathFieldFuncLen(v string) float64 {
+	return float64(len(v))
+}
+
 func mathFuncAnd(result []float64, args [][]float64) {
 	a := args[0]
 	b := args[1]
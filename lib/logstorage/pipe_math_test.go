@@ -18,12 +18,16 @@ func TestParsePipeMathSuccess(t *testing.T) {
 	f(`math (foo / bar + baz * abc % -45ms) as a`)
 	f(`math (foo / (bar + baz) * abc ^ 2) as a`)
 	f(`math (foo / ((bar + baz) * abc) ^ -2) as a`)
+	f(`math (foo + bar * baz ^ 2) as a`)
+	f(`math (foo - bar / baz * abc) as a`)
 	f(`math (foo + bar / baz - abc) as a`)
 	f(`math min(3, foo, (1 + bar) / baz) as a, max(a, b) as b, (abs(c) + 5) as d`)
 	f(`math round(foo) as x`)
 	f(`math round(foo, 0.1) as y`)
 	f(`math (a / b default 10) as z`)
 	f(`math (ln(a) + exp(b)) as x`)
+	f(`math len(a) as x`)
+	f(`math ((len(_msg) - len("foo bar")) / 2) as x`)
 }
 
 func TestParsePipeMathFailure(t *testing.T) {
@@ -42,6 +46,10 @@ func TestParsePipeMathFailure(t *testing.T) {
 	f(`math max(a) as x`)
 	f(`math round() as x`)
 	f(`math round(a, b, c) as x`)
+	f(`math len() as x`)
+	f(`math len(a, b) as x`)
+	f(`math len(a + b) as x`)
+	f(`math len a as x`)
 }
 
 func TestPipeMath(t *testing.T) {
@@ -77,6 +85,30 @@ func TestPipeMath(t *testing.T) {
 		},
 	})
 
+	f("math len(a) as x, len(_msg) + len(b) as y", [][]Field{
+		{
+			{"_msg", "foo bar"},
+			{"a", "12345"},
+		},
+		{
+			{"a", ""},
+			{"b", "абв"},
+		},
+	}, [][]Field{
+		{
+			{"_msg", "foo bar"},
+			{"a", "12345"},
+			{"x", "5"},
+			{"y", "7"},
+		},
+		{
+			{"a", ""},
+			{"b", "абв"},
+			{"x", "0"},
+			{"y", "6"},
+		},
+	})
+
 	f("eval b+1 as a, a*2 as b, b-10.5+c as c", [][]Field{
 		{
 			{"a", "v1"},
@@ -274,6 +306,23 @@ func TestPipeMath(t *testing.T) {
 		},
 	})
 
+	f("math 1 + b * c ^ 2 as x, 1 + b * c / 3 as y, 10 - b - c + 1 as z", [][]Field{
+		{
+			{"a", "v1"},
+			{"b", "2"},
+			{"c", "3"},
+		},
+	}, [][]Field{
+		{
+			{"a", "v1"},
+			{"b", "2"},
+			{"c", "3"},
+			{"x", "19"},
+			{"y", "3"},
+			{"z", "6"},
+		},
+	})
+
 	f("math abs(-min(a,b)) as min, round(max(40*b/30,c)) as max", [][]Field{
 		{
 			{"a", "v1"},
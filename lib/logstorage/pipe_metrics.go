@@ -0,0 +1,110 @@
+package logstorage
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PipeMetrics contains execution stats for pipes with the given name.
+//
+// PipeMetrics may be obtained via Storage.GetPipeMetrics().
+type PipeMetrics struct {
+	// Name is the pipe name such as `stats`, `sort` or `filter`.
+	Name string
+
+	// InputRows is the number of rows passed to the pipes with the given name since the storage start.
+	//
+	// The rows covered by stats states from the query results cache aren't counted for stats pipes.
+	InputRows uint64
+
+	// OutputRows is the number of rows returned by the pipes with the given name since the storage start.
+	OutputRows uint64
+
+	// Flushes is the number of flushes for the pipes with the given name since the storage start.
+	//
+	// Every pipe is flushed once per query execution.
+	Flushes uint64
+
+	// FlushDurationSeconds is the total duration of flushes for the pipes with the given name since the storage start.
+	//
+	// The duration includes the time needed for processing the flushed rows by the next pipes.
+	FlushDurationSeconds float64
+}
+
+// pipeMetrics contains execution stats for pipes with a single name.
+type pipeMetrics struct {
+	inputRows          atomic.Uint64
+	outputRows         atomic.Uint64
+	flushes            atomic.Uint64
+	flushDurationNanos atomic.Uint64
+}
+
+func (pm *pipeMetrics) updateFlushStats(inputRows, outputRows uint64, d time.Duration) {
+	pm.inputRows.Add(inputRows)
+	pm.outputRows.Add(outputRows)
+	pm.flushes.Add(1)
+	pm.flushDurationNanos.Add(uint64(d.Nanoseconds()))
+}
+
+// getPipeMetrics returns pipe metrics for the given p.
+func (s *Storage) getPipeMetrics(p pipe) *pipeMetrics {
+	name := getPipeName(p)
+
+	s.pipeMetricsLock.Lock()
+	defer s.pipeMetricsLock.Unlock()
+
+	pm := s.pipeMetrics[name]
+	if pm == nil {
+		pm = &pipeMetrics{}
+		s.pipeMetrics[name] = pm
+	}
+	return pm
+}
+
+// GetPipeMetrics returns execution stats per every pipe name seen at s.
+//
+// The returned stats are sorted by pipe name.
+func (s *Storage) GetPipeMetrics() []PipeMetrics {
+	s.pipeMetricsLock.Lock()
+	result := make([]PipeMetrics, 0, len(s.pipeMetrics))
+	for name, pm := range s.pipeMetrics {
+		result = append(result, PipeMetrics{
+			Name:                 name,
+			InputRows:            pm.inputRows.Load(),
+			OutputRows:           pm.outputRows.Load(),
+			Flushes:              pm.flushes.Load(),
+			FlushDurationSeconds: float64(pm.flushDurationNanos.Load()) / 1e9,
+		})
+	}
+	s.pipeMetricsLock.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// getPipeName returns the name of the given pipe.
+//
+// The name is the first word of the canonical pipe representation returned by p.String().
+// It is cached per pipe type, since p.String() may be slow for pipes with big number of args.
+func getPipeName(p pipe) string {
+	t := reflect.TypeOf(p)
+	if v, ok := pipeNamesCache.Load(t); ok {
+		return v.(string)
+	}
+
+	name := p.String()
+	if n := strings.IndexAny(name, " ("); n >= 0 {
+		name = name[:n]
+	}
+	name = strings.Clone(name)
+	pipeNamesCache.Store(t, name)
+	return name
+}
+
+var pipeNamesCache sync.Map
@@ -0,0 +1,45 @@
+package logstorage
+
+import (
+	"testing"
+)
+
+func TestGetPipeName(t *testing.T) {
+	f := func(pipeStr, nameExpected string) {
+		t.Helper()
+
+		lex := newLexer(pipeStr)
+		p, err := parsePipe(lex)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+		}
+		name := getPipeName(p)
+		if name != nameExpected {
+			t.Fatalf("unexpected name for %q; got %q; want %q", pipeStr, name, nameExpected)
+		}
+		if _, ok := getPipeParsers()[name]; !ok {
+			t.Fatalf("pipe name %q for %q must be a known pipe name", name, pipeStr)
+		}
+
+		// The cached name must be returned for pipes of the same type
+		name = getPipeName(p)
+		if name != nameExpected {
+			t.Fatalf("unexpected cached name for %q; got %q; want %q", pipeStr, name, nameExpected)
+		}
+	}
+
+	f(`fields foo, bar`, "fields")
+	f(`count() rows`, "stats")
+	f(`stats by (host) count()`, "stats")
+	f(`sort by (_time)`, "sort")
+	f(`keep foo`, "fields")
+	f(`rm foo`, "delete")
+	f(`head 10`, "limit")
+	f(`filter foo:bar`, "filter")
+	f(`where foo:bar`, "filter")
+	f(`uniq by (host)`, "uniq")
+	f(`unpack_json`, "unpack_json")
+	f(`format "<foo>" as bar`, "format")
+	f(`replace ("foo", "bar")`, "replace")
+	f(`sample 0.5`, "sample")
+}
@@ -20,7 +20,7 @@ func (po *pipeOffset) canLiveTail() bool {
 	return false
 }
 
-func (po *pipeOffset) updateNeededFields(_, _ fieldsSet) {
+func (po *pipeOffset) updateNeededFields(_, _ *fieldsSet) {
 	// nothing to do
 }
 
@@ -36,7 +36,7 @@ func (po *pipeOffset) initFilterInValues(_ map[string][]string, _ getFieldValues
 	return po, nil
 }
 
-func (po *pipeOffset) newPipeProcessor(_ int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (po *pipeOffset) newPipeProcessor(_ int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	return &pipeOffsetProcessor{
 		po:     po,
 		ppNext: ppNext,
@@ -6,11 +6,11 @@ import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 )
 
-func updateNeededFieldsForPipePack(neededFields, unneededFields fieldsSet, resultField string, fields []string) {
+func updateNeededFieldsForPipePack(neededFields, unneededFields *fieldsSet, resultField string, fields []string) {
 	if neededFields.contains("*") {
 		if !unneededFields.contains(resultField) {
 			if len(fields) > 0 {
-				unneededFields.removeFields(fields)
+				unneededFields.removePatterns(fields)
 			} else {
 				unneededFields.reset()
 			}
@@ -19,7 +19,7 @@ func updateNeededFieldsForPipePack(neededFields, unneededFields fieldsSet, resul
 		if neededFields.contains(resultField) {
 			neededFields.remove(resultField)
 			if len(fields) > 0 {
-				neededFields.addFields(fields)
+				neededFields.addPatterns(fields)
 			} else {
 				neededFields.add("*")
 			}
@@ -78,8 +78,17 @@ func (ppp *pipePackProcessor) writeBlock(workerID uint, br *blockResult) {
 		cs = append(cs, csAll...)
 	} else {
 		for _, f := range ppp.fields {
-			c := br.getColumnByName(f)
-			cs = append(cs, c)
+			if !isWildcardFieldName(f) {
+				if !hasColumnWithName(cs, f) {
+					cs = append(cs, br.getColumnByName(f))
+				}
+				continue
+			}
+			for _, c := range br.getColumns() {
+				if matchFieldName(f, c.name) && !hasColumnWithName(cs, c.name) {
+					cs = append(cs, c)
+				}
+			}
 		}
 	}
 	shard.cs = cs
@@ -101,6 +110,7 @@ func (ppp *pipePackProcessor) writeBlock(workerID uint, br *blockResult) {
 		v := bytesutil.ToUnsafeString(buf[bufLen:])
 		shard.rc.addValue(v)
 	}
+	shard.buf = buf
 	shard.fields = fields
 
 	br.addResultColumn(&shard.rc)
@@ -109,6 +119,15 @@ func (ppp *pipePackProcessor) writeBlock(workerID uint, br *blockResult) {
 	shard.rc.reset()
 }
 
+func hasColumnWithName(cs []*blockResultColumn, name string) bool {
+	for _, c := range cs {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (ppp *pipePackProcessor) flush() error {
 	return nil
 }
@@ -17,7 +17,7 @@ type pipePackJSON struct {
 func (pp *pipePackJSON) String() string {
 	s := "pack_json"
 	if len(pp.fields) > 0 {
-		s += " fields (" + fieldsToString(pp.fields) + ")"
+		s += " fields (" + fieldNamesString(pp.fields) + ")"
 	}
 	if !isMsgFieldName(pp.resultField) {
 		s += " as " + quoteTokenIfNeeded(pp.resultField)
@@ -29,7 +29,7 @@ func (pp *pipePackJSON) canLiveTail() bool {
 	return true
 }
 
-func (pp *pipePackJSON) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pp *pipePackJSON) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	updateNeededFieldsForPipePack(neededFields, unneededFields, pp.resultField, pp.fields)
 }
 
@@ -45,7 +45,7 @@ func (pp *pipePackJSON) initFilterInValues(_ map[string][]string, _ getFieldValu
 	return pp, nil
 }
 
-func (pp *pipePackJSON) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pp *pipePackJSON) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	return newPipePackProcessor(workersCount, ppNext, pp.resultField, pp.fields, MarshalFieldsToJSON)
 }
 
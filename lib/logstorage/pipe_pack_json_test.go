@@ -14,6 +14,8 @@ func TestParsePipePackJSONSuccess(t *testing.T) {
 	f(`pack_json as x`)
 	f(`pack_json fields (a, b)`)
 	f(`pack_json fields (a, b) as x`)
+	f(`pack_json fields (foo.*, bar) as x`)
+	f(`pack_json fields (foo.*, "bar baz"*)`)
 }
 
 func TestParsePipePackJSONFailure(t *testing.T) {
@@ -125,4 +127,10 @@ func TestPipePackJSONUpdateNeededFields(t *testing.T) {
 
 	// needed fields intersect with output
 	f(`pack_json as f2`, "f2,y", "", "*", "")
+
+	// needed fields intersect with output, with wildcard fields
+	f(`pack_json fields (foo.*, bar) as f2`, "f2,y", "", "bar,foo.*,y", "")
+
+	// unneeded fields intersect with wildcard fields
+	f(`pack_json fields (foo.*, bar) as x`, "*", "foo.a,bar,baz", "*", "baz")
 }
@@ -17,7 +17,7 @@ type pipePackLogfmt struct {
 func (pp *pipePackLogfmt) String() string {
 	s := "pack_logfmt"
 	if len(pp.fields) > 0 {
-		s += " fields (" + fieldsToString(pp.fields) + ")"
+		s += " fields (" + fieldNamesString(pp.fields) + ")"
 	}
 	if !isMsgFieldName(pp.resultField) {
 		s += " as " + quoteTokenIfNeeded(pp.resultField)
@@ -29,7 +29,7 @@ func (pp *pipePackLogfmt) canLiveTail() bool {
 	return true
 }
 
-func (pp *pipePackLogfmt) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pp *pipePackLogfmt) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	updateNeededFieldsForPipePack(neededFields, unneededFields, pp.resultField, pp.fields)
 }
 
@@ -45,7 +45,7 @@ func (pp *pipePackLogfmt) initFilterInValues(_ map[string][]string, _ getFieldVa
 	return pp, nil
 }
 
-func (pp *pipePackLogfmt) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pp *pipePackLogfmt) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	return newPipePackProcessor(workersCount, ppNext, pp.resultField, pp.fields, MarshalFieldsToLogfmt)
 }
 
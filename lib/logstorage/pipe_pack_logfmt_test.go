@@ -14,6 +14,8 @@ func TestParsePipePackLogfmtSuccess(t *testing.T) {
 	f(`pack_logfmt as x`)
 	f(`pack_logfmt fields (a, b)`)
 	f(`pack_logfmt fields (a, b) as x`)
+	f(`pack_logfmt fields (foo.*, bar) as x`)
+	f(`pack_logfmt fields (foo.*, "bar baz"*)`)
 }
 
 func TestParsePipePackLogfmtFailure(t *testing.T) {
@@ -130,4 +132,8 @@ func TestPipePackLogfmtUpdateNeededFields(t *testing.T) {
 	// needed fields intersect with output
 	f(`pack_logfmt as f2`, "f2,y", "", "*", "")
 	f(`pack_logfmt fields (x,y) as f2`, "f2,y", "", "x,y", "")
+	f(`pack_logfmt fields (foo.*, bar) as f2`, "f2,y", "", "bar,foo.*,y", "")
+
+	// unneeded fields intersect with wildcard fields
+	f(`pack_logfmt fields (foo.*, bar) as x`, "*", "foo.a,bar,baz", "*", "baz")
 }
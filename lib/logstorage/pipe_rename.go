@@ -35,7 +35,7 @@ func (pr *pipeRename) canLiveTail() bool {
 	return true
 }
 
-func (pr *pipeRename) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pr *pipeRename) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	for i := len(pr.srcFields) - 1; i >= 0; i-- {
 		srcField := pr.srcFields[i]
 		dstField := pr.dstFields[i]
@@ -70,7 +70,7 @@ func (pr *pipeRename) initFilterInValues(_ map[string][]string, _ getFieldValues
 	return pr, nil
 }
 
-func (pr *pipeRename) newPipeProcessor(_ int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pr *pipeRename) newPipeProcessor(_ int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	return &pipeRenameProcessor{
 		pr:     pr,
 		ppNext: ppNext,
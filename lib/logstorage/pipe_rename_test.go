@@ -98,6 +98,20 @@ func TestPipeRename(t *testing.T) {
 		},
 	})
 
+	// rename non-existing field to itself
+	f("rename x as x", [][]Field{
+		{
+			{"_msg", `{"foo":"bar"}`},
+			{"a", `test`},
+		},
+	}, [][]Field{
+		{
+			{"_msg", `{"foo":"bar"}`},
+			{"a", `test`},
+			{"x", ``},
+		},
+	})
+
 	// swap rename
 	f("rename a as b, _msg as a, b as _msg", [][]Field{
 		{
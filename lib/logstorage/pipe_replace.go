@@ -41,7 +41,7 @@ func (pr *pipeReplace) canLiveTail() bool {
 	return true
 }
 
-func (pr *pipeReplace) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pr *pipeReplace) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	updateNeededFieldsForUpdatePipe(neededFields, unneededFields, pr.field, pr.iff)
 }
 
@@ -63,7 +63,7 @@ func (pr *pipeReplace) initFilterInValues(cache map[string][]string, getFieldVal
 	return &peNew, nil
 }
 
-func (pr *pipeReplace) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pr *pipeReplace) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	updateFunc := func(a *arena, v string) string {
 		bLen := len(a.b)
 		a.b = appendReplace(a.b, v, pr.oldSubstr, pr.newSubstr, pr.limit)
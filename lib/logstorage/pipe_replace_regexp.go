@@ -2,6 +2,7 @@ package logstorage
 
 import (
 	"fmt"
+	"math"
 	"regexp"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
@@ -41,7 +42,7 @@ func (pr *pipeReplaceRegexp) canLiveTail() bool {
 	return true
 }
 
-func (pr *pipeReplaceRegexp) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pr *pipeReplaceRegexp) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	updateNeededFieldsForUpdatePipe(neededFields, unneededFields, pr.field, pr.iff)
 }
 
@@ -63,7 +64,7 @@ func (pr *pipeReplaceRegexp) initFilterInValues(cache map[string][]string, getFi
 	return &peNew, nil
 }
 
-func (pr *pipeReplaceRegexp) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pr *pipeReplaceRegexp) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	updateFunc := func(a *arena, v string) string {
 		bLen := len(a.b)
 		a.b = appendReplaceRegexp(a.b, v, pr.re, pr.replacement, pr.limit)
@@ -155,20 +156,22 @@ func appendReplaceRegexp(dst []byte, s string, re *regexp.Regexp, replacement st
 		return dst
 	}
 
-	replacements := uint64(0)
-	for {
-		locs := re.FindStringSubmatchIndex(s)
-		if locs == nil {
-			return append(dst, s...)
-		}
-		start := locs[0]
-		dst = append(dst, s[:start]...)
-		end := locs[1]
+	// Search for all the matches in the whole string instead of the remaining tail after every match,
+	// so anchors such as ^ and \b are applied to the original string and empty matches do not lead to an endless loop.
+	n := -1
+	if limit > 0 && limit <= math.MaxInt {
+		n = int(limit)
+	}
+	locss := re.FindAllStringSubmatchIndex(s, n)
+	if len(locss) == 0 {
+		return append(dst, s...)
+	}
+
+	offset := 0
+	for _, locs := range locss {
+		dst = append(dst, s[offset:locs[0]]...)
 		dst = re.ExpandString(dst, replacement, s, locs)
-		s = s[end:]
-		replacements++
-		if limit > 0 && replacements >= limit {
-			return append(dst, s...)
-		}
+		offset = locs[1]
 	}
+	return append(dst, s[offset:]...)
 }
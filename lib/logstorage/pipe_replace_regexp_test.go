@@ -197,4 +197,13 @@ func TestAppendReplaceRegexp(t *testing.T) {
 	// placeholders
 	f("afoo abc barz", "a([^ ]+)", "b${1}x", 0, "bfoox bbcx bbrzx")
 	f("afoo abc barz", "a([^ ]+)", "b${1}x", 1, "bfoox abc barz")
+
+	// anchors must be applied to the original string
+	f("foofoo", "^foo", "bar", 0, "barfoo")
+	f("foo foobar", `foo\b`, "x", 0, "x foobar")
+
+	// empty matches
+	f("abc", "x*", "-", 0, "-a-b-c-")
+	f("abc", "x*", "-", 2, "-a-bc")
+	f("abc", "", "", 0, "abc")
 }
@@ -0,0 +1,172 @@
+package logstorage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"unsafe"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// pipeSample processes '| sample ...' pipe.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#sample-pipe
+type pipeSample struct {
+	// fractionStr is the original string representation of the fraction of rows to keep in the range (0..1].
+	fractionStr string
+
+	// threshold is the maximum hash value for the rows to keep.
+	threshold uint64
+}
+
+func (ps *pipeSample) String() string {
+	return "sample " + ps.fractionStr
+}
+
+func (ps *pipeSample) canLiveTail() bool {
+	return true
+}
+
+func (ps *pipeSample) updateNeededFields(neededFields, unneededFields *fieldsSet) {
+	if neededFields.contains("*") {
+		unneededFields.remove("_stream")
+		unneededFields.remove("_time")
+	} else {
+		neededFields.add("_stream")
+		neededFields.add("_time")
+	}
+}
+
+func (ps *pipeSample) optimize() {
+	// nothing to do
+}
+
+func (ps *pipeSample) hasFilterInWithQuery() bool {
+	return false
+}
+
+func (ps *pipeSample) initFilterInValues(_ map[string][]string, _ getFieldValuesFunc) (pipe, error) {
+	return ps, nil
+}
+
+func (ps *pipeSample) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
+	return &pipeSampleProcessor{
+		ps:     ps,
+		ppNext: ppNext,
+
+		shards: make([]pipeSampleProcessorShard, workersCount),
+	}
+}
+
+type pipeSampleProcessor struct {
+	ps     *pipeSample
+	ppNext pipeProcessor
+
+	shards []pipeSampleProcessorShard
+}
+
+type pipeSampleProcessorShard struct {
+	pipeSampleProcessorShardNopad
+
+	// The padding prevents false sharing on widespread platforms with 128 mod (cache line size) = 0 .
+	_ [128 - unsafe.Sizeof(pipeSampleProcessorShardNopad{})%128]byte
+}
+
+type pipeSampleProcessorShardNopad struct {
+	br blockResult
+	bm bitmap
+
+	// buf is used for building the hashed value per every row.
+	buf []byte
+}
+
+func (psp *pipeSampleProcessor) writeBlock(workerID uint, br *blockResult) {
+	if len(br.timestamps) == 0 {
+		return
+	}
+
+	ps := psp.ps
+	if ps.threshold == math.MaxUint64 {
+		// Fast path - keep all the rows.
+		psp.ppNext.writeBlock(workerID, br)
+		return
+	}
+
+	shard := &psp.shards[workerID]
+
+	bm := &shard.bm
+	bm.init(len(br.timestamps))
+	bm.setBits()
+
+	// The decision whether to keep the row depends only on _stream and _time values,
+	// so the same rows are returned on every query execution.
+	cStream := br.getColumnByName("_stream")
+	cTime := br.getColumnByName("_time")
+	var timeValues []string
+	if !cTime.isTime {
+		timeValues = cTime.getValues(br)
+	}
+	streamValues := cStream.getValues(br)
+
+	buf := shard.buf
+	bm.forEachSetBit(func(idx int) bool {
+		buf = append(buf[:0], streamValues[idx]...)
+		if timeValues == nil {
+			buf = binary.BigEndian.AppendUint64(buf, uint64(br.timestamps[idx]))
+		} else {
+			buf = append(buf, timeValues[idx]...)
+		}
+		h := xxhash.Sum64(buf)
+		return h <= ps.threshold
+	})
+	shard.buf = buf
+
+	if bm.areAllBitsSet() {
+		psp.ppNext.writeBlock(workerID, br)
+		return
+	}
+	if bm.isZero() {
+		return
+	}
+
+	shard.br.initFromFilterAllColumns(br, bm)
+	psp.ppNext.writeBlock(workerID, &shard.br)
+}
+
+func (psp *pipeSampleProcessor) flush() error {
+	return nil
+}
+
+func parsePipeSample(lex *lexer) (*pipeSample, error) {
+	if !lex.isKeyword("sample") {
+		return nil, fmt.Errorf("expecting 'sample'; got %q", lex.token)
+	}
+	lex.nextToken()
+
+	fractionStr, err := getCompoundToken(lex)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read fraction of rows to keep: %w", err)
+	}
+	fraction, ok := tryParseFloat64(fractionStr)
+	if !ok {
+		return nil, fmt.Errorf("cannot parse fraction of rows to keep from %q", fractionStr)
+	}
+	if fraction <= 0 || fraction > 1 {
+		return nil, fmt.Errorf("fraction of rows to keep must be in the range (0..1]; got %q", fractionStr)
+	}
+
+	ps := &pipeSample{
+		fractionStr: fractionStr,
+		threshold:   getPipeSampleThreshold(fraction),
+	}
+	return ps, nil
+}
+
+// getPipeSampleThreshold returns the maximum hash value for the rows to keep with the given fraction.
+func getPipeSampleThreshold(fraction float64) uint64 {
+	if fraction >= 1 {
+		return math.MaxUint64
+	}
+	return uint64(fraction * math.MaxUint64)
+}
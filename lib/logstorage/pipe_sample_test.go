@@ -0,0 +1,151 @@
+package logstorage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParsePipeSampleSuccess(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeSuccess(t, pipeStr)
+	}
+
+	f(`sample 0.01`)
+	f(`sample 0.5`)
+	f(`sample 1`)
+}
+
+func TestParsePipeSampleFailure(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeFailure(t, pipeStr)
+	}
+
+	f(`sample`)
+	f(`sample foo`)
+	f(`sample 0`)
+	f(`sample -0.1`)
+	f(`sample 1.5`)
+	f(`sample 0.1 foo`)
+}
+
+func TestPipeSample(t *testing.T) {
+	f := func(pipeStr string, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeResults(t, pipeStr, rows, rowsExpected)
+	}
+
+	// all the rows are kept
+	f(`sample 1`, [][]Field{
+		{
+			{"_stream", `{host="a"}`},
+			{"_time", "2024-06-01T00:00:00Z"},
+			{"_msg", "foo"},
+		},
+		{
+			{"_msg", "bar"},
+		},
+	}, [][]Field{
+		{
+			{"_stream", `{host="a"}`},
+			{"_time", "2024-06-01T00:00:00Z"},
+			{"_msg", "foo"},
+		},
+		{
+			{"_msg", "bar"},
+		},
+	})
+}
+
+func TestPipeSampleFraction(t *testing.T) {
+	var rows [][]Field
+	for i := 0; i < 10000; i++ {
+		rows = append(rows, []Field{
+			{"_stream", fmt.Sprintf(`{host="host-%d"}`, i%7)},
+			{"_time", fmt.Sprintf("2024-06-01T00:%02d:%02d.%03dZ", (i/60)%60, i%60, i%1000)},
+			{"_msg", fmt.Sprintf("message %d", i)},
+		})
+	}
+
+	run := func(pipeStr string) map[string]struct{} {
+		t.Helper()
+
+		lex := newLexer(pipeStr)
+		p, err := parsePipe(lex)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+		}
+		ppTest := newTestPipeProcessor()
+		pp := p.newPipeProcessor(5, make(chan struct{}), func() {}, ppTest, newMemoryBudget(0))
+		brw := newTestBlockResultWriter(5, pp)
+		for _, row := range rows {
+			brw.writeRow(row)
+		}
+		brw.flush()
+		if err := pp.flush(); err != nil {
+			t.Fatalf("unexpected error when flushing %q: %s", pipeStr, err)
+		}
+
+		m := make(map[string]struct{})
+		for _, row := range ppTest.resultRows {
+			m[rowToString(row)] = struct{}{}
+		}
+		return m
+	}
+
+	f := func(pipeStr string, minRows, maxRows int) map[string]struct{} {
+		t.Helper()
+
+		m := run(pipeStr)
+		if len(m) < minRows || len(m) > maxRows {
+			t.Fatalf("unexpected number of rows for %q; got %d; want [%d..%d]", pipeStr, len(m), minRows, maxRows)
+		}
+
+		// The results must be reproducible
+		mAgain := run(pipeStr)
+		if len(mAgain) != len(m) {
+			t.Fatalf("unexpected number of rows on the second run of %q; got %d; want %d", pipeStr, len(mAgain), len(m))
+		}
+		for k := range m {
+			if _, ok := mAgain[k]; !ok {
+				t.Fatalf("missing row on the second run of %q: %s", pipeStr, k)
+			}
+		}
+		return m
+	}
+
+	m01 := f("sample 0.01", 50, 150)
+	m1 := f("sample 0.1", 800, 1200)
+	m5 := f("sample 0.5", 4500, 5500)
+
+	// Smaller samples must be subsets of bigger samples
+	for k := range m01 {
+		if _, ok := m1[k]; !ok {
+			t.Fatalf("row from 'sample 0.01' is missing in 'sample 0.1': %s", k)
+		}
+	}
+	for k := range m1 {
+		if _, ok := m5[k]; !ok {
+			t.Fatalf("row from 'sample 0.1' is missing in 'sample 0.5': %s", k)
+		}
+	}
+}
+
+func TestPipeSampleUpdateNeededFields(t *testing.T) {
+	f := func(s, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected string) {
+		t.Helper()
+		expectPipeNeededFields(t, s, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected)
+	}
+
+	// all the needed fields
+	f("sample 0.1", "*", "", "*", "")
+
+	// all the needed fields, plus unneeded fields
+	f("sample 0.1", "*", "f1,f2", "*", "f1,f2")
+	f("sample 0.1", "*", "_time,f1,_stream", "*", "f1")
+
+	// needed fields
+	f("sample 0.1", "f1,f2", "", "_stream,_time,f1,f2", "")
+	f("sample 0.1", "f1,_time", "", "_stream,_time,f1", "")
+}
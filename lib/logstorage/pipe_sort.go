@@ -4,16 +4,15 @@ import (
 	"container/heap"
 	"fmt"
 	"math"
+	"os"
 	"sort"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"unsafe"
 
 	"github.com/valyala/quicktemplate"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
-	"github.com/VictoriaMetrics/VictoriaMetrics/lib/memory"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/stringsutil"
 )
 
@@ -67,7 +66,7 @@ func (ps *pipeSort) canLiveTail() bool {
 	return false
 }
 
-func (ps *pipeSort) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (ps *pipeSort) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	if neededFields.isEmpty() {
 		return
 	}
@@ -87,6 +86,11 @@ func (ps *pipeSort) updateNeededFields(neededFields, unneededFields fieldsSet) {
 			neededFields.add(bf.name)
 			unneededFields.remove(bf.name)
 		}
+
+		// Rows with equal 'by(...)' fields are ordered by _time, so it must be read even if the next pipes do not need it.
+		// Otherwise the order of such rows depends on the fields needed by the next pipes.
+		neededFields.add("_time")
+		unneededFields.remove("_time")
 	}
 }
 
@@ -94,6 +98,37 @@ func (ps *pipeSort) optimize() {
 	// nothing to do
 }
 
+// mergeOffset merges '| offset n' pipe going after ps into ps.
+//
+// It returns false if the offset cannot be merged into ps.
+func (ps *pipeSort) mergeOffset(n uint64) bool {
+	if ps.limit == 0 {
+		ps.offset += n
+		return true
+	}
+	if n >= ps.limit {
+		// The result is empty, but it cannot be expressed with ps.limit, since zero limit means no limit.
+		return false
+	}
+	ps.offset += n
+	ps.limit -= n
+	return true
+}
+
+// mergeLimit merges '| limit n' pipe going after ps into ps.
+//
+// It returns false if the limit cannot be merged into ps.
+func (ps *pipeSort) mergeLimit(n uint64) bool {
+	if n == 0 {
+		// Zero limit means no limit for ps, so it cannot be merged.
+		return false
+	}
+	if ps.limit == 0 || n < ps.limit {
+		ps.limit = n
+	}
+	return true
+}
+
 func (ps *pipeSort) hasFilterInWithQuery() bool {
 	return false
 }
@@ -102,16 +137,15 @@ func (ps *pipeSort) initFilterInValues(_ map[string][]string, _ getFieldValuesFu
 	return ps, nil
 }
 
-func (ps *pipeSort) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor) pipeProcessor {
+func (ps *pipeSort) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor, mb *memoryBudget) pipeProcessor {
 	if ps.limit > 0 {
-		return newPipeTopkProcessor(ps, workersCount, stopCh, cancel, ppNext)
+		return newPipeTopkProcessor(ps, workersCount, stopCh, cancel, ppNext, mb)
 	}
-	return newPipeSortProcessor(ps, workersCount, stopCh, cancel, ppNext)
+	return newPipeSortProcessor(ps, workersCount, stopCh, cancel, ppNext, mb)
 }
 
-func newPipeSortProcessor(ps *pipeSort, workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor) pipeProcessor {
-	maxStateSize := int64(float64(memory.Allowed()) * 0.2)
-
+func newPipeSortProcessor(ps *pipeSort, workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor, mb *memoryBudget) pipeProcessor {
+	pmb := mb.newPipeMemoryBudget(ps)
 	shards := make([]pipeSortProcessorShard, workersCount)
 	for i := range shards {
 		shards[i] = pipeSortProcessorShard{
@@ -120,7 +154,7 @@ func newPipeSortProcessor(ps *pipeSort, workersCount int, stopCh <-chan struct{}
 				stateSizeBudget: stateSizeBudgetChunk,
 			},
 		}
-		maxStateSize -= stateSizeBudgetChunk
+		pmb.reserve(stateSizeBudgetChunk)
 	}
 
 	psp := &pipeSortProcessor{
@@ -131,9 +165,8 @@ func newPipeSortProcessor(ps *pipeSort, workersCount int, stopCh <-chan struct{}
 
 		shards: shards,
 
-		maxStateSize: maxStateSize,
+		pmb: pmb,
 	}
-	psp.stateSizeBudget.Store(maxStateSize)
 
 	return psp
 }
@@ -146,8 +179,16 @@ type pipeSortProcessor struct {
 
 	shards []pipeSortProcessorShard
 
-	maxStateSize    int64
-	stateSizeBudget atomic.Int64
+	pmb *pipeMemoryBudget
+
+	// spillLock protects spillPaths and spillErr.
+	spillLock sync.Mutex
+
+	// spillPaths contains paths to files with the spilled sorted rows.
+	spillPaths []string
+
+	// spillErr contains the first error occurred during spilling the rows to disk.
+	spillErr error
 }
 
 type pipeSortProcessorShard struct {
@@ -176,6 +217,11 @@ type pipeSortProcessorShardNopad struct {
 	// The per-shard budget is provided in chunks from the parent pipeSortProcessor.
 	stateSizeBudget int
 
+	// stateSizeBorrowed is the state size budget borrowed by the shard from the parent pipeSortProcessor.
+	//
+	// It is returned to the parent pipeSortProcessor when the shard rows are spilled to disk.
+	stateSizeBorrowed int64
+
 	// columnValues is used as temporary buffer at pipeSortProcessorShard.writeBlock
 	columnValues [][]string
 }
@@ -413,33 +459,88 @@ func (psp *pipeSortProcessor) writeBlock(workerID uint, br *blockResult) {
 
 	for shard.stateSizeBudget < 0 {
 		// steal some budget for the state size from the global budget.
-		remaining := psp.stateSizeBudget.Add(-stateSizeBudgetChunk)
-		if remaining < 0 {
-			// The state size is too big. Stop processing data in order to avoid OOM crash.
-			if remaining+stateSizeBudgetChunk >= 0 {
-				// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
-				psp.cancel()
+		if !psp.pmb.tryBorrow(stateSizeBudgetChunk) {
+			// The state size is too big. Spill the sorted shard rows to disk in order to free up memory.
+			// The spilled rows are merged at flush().
+			if !psp.spillShardRows(shard) {
+				return
 			}
-			return
+			break
 		}
 		shard.stateSizeBudget += stateSizeBudgetChunk
+		shard.stateSizeBorrowed += stateSizeBudgetChunk
 	}
 
 	shard.writeBlock(br)
 }
 
+// spillShardRows spills sorted shard rows to disk and returns the borrowed state size budget to psp.
+//
+// It returns false if the rows couldn't be spilled.
+func (psp *pipeSortProcessor) spillShardRows(shard *pipeSortProcessorShard) bool {
+	path, err := shard.spillRows()
+
+	psp.spillLock.Lock()
+	if err != nil {
+		if psp.spillErr == nil {
+			psp.spillErr = err
+		}
+	} else {
+		psp.spillPaths = append(psp.spillPaths, path)
+	}
+	psp.spillLock.Unlock()
+
+	if err != nil {
+		// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
+		psp.cancel()
+		return false
+	}
+
+	psp.pmb.giveBack(shard.stateSizeBorrowed)
+	shard.stateSizeBorrowed = 0
+	shard.stateSizeBudget = stateSizeBudgetChunk
+	return true
+}
+
 func (psp *pipeSortProcessor) flush() error {
-	if n := psp.stateSizeBudget.Load(); n <= 0 {
-		return fmt.Errorf("cannot calculate [%s], since it requires more than %dMB of memory", psp.ps.String(), psp.maxStateSize/(1<<20))
+	defer psp.pmb.release()
+
+	// Remove the spilled rows files after the flush.
+	defer func() {
+		for _, path := range psp.spillPaths {
+			_ = os.Remove(path)
+		}
+	}()
+
+	if psp.spillErr != nil {
+		return fmt.Errorf("cannot calculate [%s]: %w", psp.ps.String(), psp.spillErr)
 	}
 
 	if needStop(psp.stopCh) {
 		return nil
 	}
 
+	shards := psp.shards
+	if len(psp.spillPaths) > 0 {
+		// Some shards were spilled to disk. Spill the remaining shards too,
+		// so all the rows could be merged from disk without the need to hold them in memory.
+		for i := range shards {
+			shard := &shards[i]
+			if len(shard.rowRefs) == 0 {
+				continue
+			}
+			if !psp.spillShardRows(shard) {
+				return fmt.Errorf("cannot calculate [%s]: %w", psp.ps.String(), psp.spillErr)
+			}
+		}
+		if err := psp.flushSpilled(); err != nil {
+			return fmt.Errorf("cannot calculate [%s]: %w", psp.ps.String(), err)
+		}
+		return nil
+	}
+
 	// Sort every shard in parallel
 	var wg sync.WaitGroup
-	shards := psp.shards
 	for i := range shards {
 		wg.Add(1)
 		go func(shard *pipeSortProcessorShard) {
@@ -742,7 +843,39 @@ func sortBlockLess(shardA *pipeSortProcessorShard, rowIdxA int, shardB *pipeSort
 		}
 		return stringsutil.LessNatural(sA, sB)
 	}
-	return false
+
+	// Rows with equal 'by(...)' columns are ordered by timestamps and then by the remaining columns,
+	// so the order of the returned rows is stable across queries. This is needed for consistent pagination via 'offset'.
+	if shardA.ps.isDesc {
+		bA, bB = bB, bA
+		rrA, rrB = rrB, rrA
+	}
+	tA := bA.br.timestamps[rrA.rowIdx]
+	tB := bB.br.timestamps[rrB.rowIdx]
+	if tA != tB {
+		return tA < tB
+	}
+	return sortBlockLessOtherColumns(bA, rrA.rowIdx, bB, rrB.rowIdx)
+}
+
+func sortBlockLessOtherColumns(bA *sortBlock, rowIdxA int, bB *sortBlock, rowIdxB int) bool {
+	csA := bA.otherColumns
+	csB := bB.otherColumns
+	for i, cA := range csA {
+		if i >= len(csB) {
+			return false
+		}
+		cB := csB[i]
+		if cA.name != cB.name {
+			return cA.name < cB.name
+		}
+		vA := cA.getValueAtRow(bA.br, rowIdxA)
+		vB := cB.getValueAtRow(bB.br, rowIdxB)
+		if vA != vB {
+			return vA < vB
+		}
+	}
+	return len(csA) < len(csB)
 }
 
 func parsePipeSort(lex *lexer) (*pipeSort, error) {
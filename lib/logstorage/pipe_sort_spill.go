@@ -0,0 +1,431 @@
+package logstorage
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+// pipeSortSpillDir is the directory for temporary files with the spilled sort pipe rows.
+//
+// The system temporary directory is used if it is empty. See SetSpillDir.
+var pipeSortSpillDir string
+
+// pipeSortSpillFilePattern is the pattern for names of files with the spilled sort pipe rows.
+const pipeSortSpillFilePattern = "vlogs-sort-*.bin"
+
+// pipeSortSpillBatchSize is the maximum size of row values, which are read at once from every spilled file during the merge.
+const pipeSortSpillBatchSize = 64 * 1024
+
+// spillRows sorts the shard rows, writes them into a temporary file and frees up the shard state.
+//
+// It returns the path to the created file.
+func (shard *pipeSortProcessorShard) spillRows() (string, error) {
+	sort.Sort(shard)
+
+	w, err := newSpillFileWriter(pipeSortSpillDir, pipeSortSpillFilePattern)
+	if err != nil {
+		return "", fmt.Errorf("cannot create temporary file for spilling sorted rows: %w", err)
+	}
+
+	var record []byte
+	for _, rr := range shard.rowRefs {
+		b := &shard.blocks[rr.blockIdx]
+		record = marshalSortRowRecord(record[:0], b.br, rr.rowIdx)
+		if err := w.writeRecord(record); err != nil {
+			return "", w.abort(fmt.Errorf("cannot write sorted rows to %q: %w", w.path(), err))
+		}
+	}
+	if err := w.finish(); err != nil {
+		return "", fmt.Errorf("cannot write sorted rows: %w", err)
+	}
+
+	clear(shard.blocks)
+	shard.blocks = shard.blocks[:0]
+	shard.rowRefs = shard.rowRefs[:0]
+	shard.rowRefNext = 0
+	return w.path(), nil
+}
+
+// marshalSortRowRecord appends the row with the given rowIdx from br to dst.
+//
+// All the columns of br are stored in the record in their original order, so the block could be restored
+// by unmarshalSortRowRecord. The contents of _time columns isn't stored, since it is restored from the row timestamp.
+func marshalSortRowRecord(dst []byte, br *blockResult, rowIdx int) []byte {
+	dst = encoding.MarshalVarInt64(dst, br.timestamps[rowIdx])
+	cs := br.getColumns()
+	dst = encoding.MarshalVarUint64(dst, uint64(len(cs)))
+	for _, c := range cs {
+		dst = encoding.MarshalBytes(dst, bytesutil.ToUnsafeBytes(c.name))
+		dst = encoding.MarshalBool(dst, c.isTime)
+		if !c.isTime {
+			v := c.getValueAtRow(br, rowIdx)
+			dst = encoding.MarshalBytes(dst, bytesutil.ToUnsafeBytes(v))
+		}
+	}
+	return dst
+}
+
+// sortSpillColumn is a single column value for the row stored in the spilled file.
+type sortSpillColumn struct {
+	name   string
+	isTime bool
+	value  string
+}
+
+// unmarshalSortRowRecord unmarshals the record created by marshalSortRowRecord from src.
+//
+// It appends the row columns to dst and returns the result together with the row timestamp.
+// The returned columns refer to src.
+func unmarshalSortRowRecord(dst []sortSpillColumn, src []byte) (int64, []sortSpillColumn, error) {
+	timestamp, n := encoding.UnmarshalVarInt64(src)
+	if n <= 0 {
+		return 0, dst, fmt.Errorf("cannot unmarshal timestamp")
+	}
+	src = src[n:]
+
+	columnsLen, n := encoding.UnmarshalVarUint64(src)
+	if n <= 0 {
+		return 0, dst, fmt.Errorf("cannot unmarshal the number of columns")
+	}
+	src = src[n:]
+
+	for i := uint64(0); i < columnsLen; i++ {
+		name, n := encoding.UnmarshalBytes(src)
+		if n <= 0 {
+			return 0, dst, fmt.Errorf("cannot unmarshal name for column #%d", i)
+		}
+		src = src[n:]
+
+		if len(src) < 1 {
+			return 0, dst, fmt.Errorf("cannot unmarshal isTime flag for column %q", name)
+		}
+		isTime := encoding.UnmarshalBool(src)
+		src = src[1:]
+
+		var value []byte
+		if !isTime {
+			value, n = encoding.UnmarshalBytes(src)
+			if n <= 0 {
+				return 0, dst, fmt.Errorf("cannot unmarshal value for column %q", name)
+			}
+			src = src[n:]
+		}
+
+		dst = append(dst, sortSpillColumn{
+			name:   bytesutil.ToUnsafeString(name),
+			isTime: isTime,
+			value:  bytesutil.ToUnsafeString(value),
+		})
+	}
+	if len(src) > 0 {
+		return 0, dst, fmt.Errorf("unexpected tail left after reading record; len(tail)=%d", len(src))
+	}
+	return timestamp, dst, nil
+}
+
+// pipeSortSpillReader reads sorted rows from a file created by pipeSortProcessorShard.spillRows.
+//
+// The rows are read in batches into shard, so they could be merged with the rows from other files
+// via sortBlockLess and pipeSortWriteContext.
+type pipeSortSpillReader struct {
+	path string
+	f    *os.File
+	br   *bufio.Reader
+
+	// shard contains the current batch of rows read from the file.
+	shard *pipeSortProcessorShard
+
+	// buf holds records for the rows, which aren't written to shard yet.
+	buf []byte
+
+	// columns contains columns for the last read row.
+	columns []sortSpillColumn
+
+	// rcs and timestamps contain the rows with the same set of columns, which aren't written to shard yet.
+	rcs        []resultColumn
+	isTime     []bool
+	timestamps []int64
+
+	// brTmp is used for writing the pending rows to shard.
+	brTmp blockResult
+}
+
+func newPipeSortSpillReader(ps *pipeSort, path string) (*pipeSortSpillReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open spilled sorted rows: %w", err)
+	}
+	r := &pipeSortSpillReader{
+		path: path,
+		f:    f,
+		br:   bufio.NewReaderSize(f, 64*1024),
+		shard: &pipeSortProcessorShard{
+			pipeSortProcessorShardNopad: pipeSortProcessorShardNopad{
+				ps: ps,
+			},
+		},
+	}
+	return r, nil
+}
+
+func (r *pipeSortSpillReader) close() {
+	_ = r.f.Close()
+}
+
+// nextBatch replaces the rows at r.shard with the next batch of sorted rows from r.
+//
+// It returns false if there are no more rows in r.
+func (r *pipeSortSpillReader) nextBatch() (bool, error) {
+	shard := r.shard
+	shard.blocks = nil
+	shard.rowRefs = nil
+	shard.rowRefNext = 0
+
+	r.buf = r.buf[:0]
+	for len(r.buf) < pipeSortSpillBatchSize {
+		recordLen, err := binary.ReadUvarint(r.br)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return false, fmt.Errorf("cannot read record length from %q: %w", r.path, err)
+		}
+
+		// Do not overwrite the already read records, since they are referred by r.rcs.
+		// The previously read records remain valid if r.buf is re-allocated.
+		bufLen := len(r.buf)
+		r.buf = slices.Grow(r.buf, int(recordLen))[:bufLen+int(recordLen)]
+		record := r.buf[bufLen:]
+		if _, err := io.ReadFull(r.br, record); err != nil {
+			return false, fmt.Errorf("cannot read record with length %d from %q: %w", recordLen, r.path, err)
+		}
+
+		timestamp, columns, err := unmarshalSortRowRecord(r.columns[:0], record)
+		r.columns = columns
+		if err != nil {
+			return false, fmt.Errorf("cannot read record from %q: %w", r.path, err)
+		}
+		r.addRow(timestamp, columns)
+	}
+	r.flushPendingRows()
+
+	return len(shard.rowRefs) > 0, nil
+}
+
+// addRow adds the row with the given timestamp and columns to the pending rows.
+func (r *pipeSortSpillReader) addRow(timestamp int64, columns []sortSpillColumn) {
+	if !r.hasSameColumns(columns) {
+		r.flushPendingRows()
+
+		r.rcs = r.rcs[:0]
+		r.isTime = r.isTime[:0]
+		for _, c := range columns {
+			r.rcs = appendResultColumnWithName(r.rcs, c.name)
+			r.isTime = append(r.isTime, c.isTime)
+		}
+	}
+
+	r.timestamps = append(r.timestamps, timestamp)
+	for i, c := range columns {
+		r.rcs[i].addValue(c.value)
+	}
+}
+
+func (r *pipeSortSpillReader) hasSameColumns(columns []sortSpillColumn) bool {
+	if len(r.rcs) != len(columns) {
+		return false
+	}
+	for i, c := range columns {
+		if r.rcs[i].name != c.name || r.isTime[i] != c.isTime {
+			return false
+		}
+	}
+	return true
+}
+
+// flushPendingRows writes the pending rows to r.shard.
+//
+// The rows are already sorted, so there is no need in sorting r.shard after that.
+func (r *pipeSortSpillReader) flushPendingRows() {
+	if len(r.timestamps) == 0 {
+		return
+	}
+
+	br := &r.brTmp
+	br.reset()
+	br.timestamps = append(br.timestamps[:0], r.timestamps...)
+	for i := range r.rcs {
+		rc := &r.rcs[i]
+		if r.isTime[i] {
+			br.csBuf = append(br.csBuf, blockResultColumn{
+				name:   rc.name,
+				isTime: true,
+			})
+			br.csInitialized = false
+			continue
+		}
+		br.addResultColumn(rc)
+	}
+
+	// shard.writeBlock clones br, so the pending rows can be reset after that.
+	r.shard.writeBlock(br)
+
+	br.reset()
+	r.timestamps = r.timestamps[:0]
+	for i := range r.rcs {
+		r.rcs[i].resetValues()
+	}
+}
+
+type pipeSortSpillReadersHeap []*pipeSortSpillReader
+
+func (h *pipeSortSpillReadersHeap) Len() int {
+	return len(*h)
+}
+
+func (h *pipeSortSpillReadersHeap) Less(i, j int) bool {
+	a := *h
+	shardA := a[i].shard
+	shardB := a[j].shard
+	return sortBlockLess(shardA, shardA.rowRefNext, shardB, shardB.rowRefNext)
+}
+
+func (h *pipeSortSpillReadersHeap) Swap(i, j int) {
+	a := *h
+	a[i], a[j] = a[j], a[i]
+}
+
+func (h *pipeSortSpillReadersHeap) Push(v any) {
+	*h = append(*h, v.(*pipeSortSpillReader))
+}
+
+func (h *pipeSortSpillReadersHeap) Pop() any {
+	a := *h
+	r := a[len(a)-1]
+	a[len(a)-1] = nil
+	*h = a[:len(a)-1]
+	return r
+}
+
+// flushSpilled merges sorted rows from the spilled files and writes the results to psp.ppNext.
+//
+// Rows in every spilled file are sorted, so the files are merged in a streaming manner,
+// which needs memory only for a small batch of rows per every file. If there are too many spilled files,
+// then they are merged into bigger files in multiple passes, so the number of simultaneously open files remains bounded.
+func (psp *pipeSortProcessor) flushSpilled() error {
+	paths, err := reduceSpillFiles(psp.spillPaths, psp.stopCh, psp.mergeSpilledToFile)
+	psp.spillPaths = paths
+	if err != nil {
+		return err
+	}
+
+	wctx := &pipeSortWriteContext{
+		psp: psp,
+	}
+	err = psp.mergeSpilled(paths, func(shard *pipeSortProcessorShard) error {
+		wctx.writeNextRow(shard)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if needStop(psp.stopCh) {
+		return nil
+	}
+	wctx.flush()
+
+	return nil
+}
+
+// mergeSpilledToFile merges sorted rows from the spilled files at paths into a new spilled file and returns the path to it.
+func (psp *pipeSortProcessor) mergeSpilledToFile(paths []string) (string, error) {
+	w, err := newSpillFileWriter(pipeSortSpillDir, pipeSortSpillFilePattern)
+	if err != nil {
+		return "", fmt.Errorf("cannot create temporary file for merging spilled sorted rows: %w", err)
+	}
+
+	var record []byte
+	err = psp.mergeSpilled(paths, func(shard *pipeSortProcessorShard) error {
+		rr := shard.rowRefs[shard.rowRefNext]
+		shard.rowRefNext++
+
+		b := &shard.blocks[rr.blockIdx]
+		record = marshalSortRowRecord(record[:0], b.br, rr.rowIdx)
+		if err := w.writeRecord(record); err != nil {
+			return fmt.Errorf("cannot write sorted rows to %q: %w", w.path(), err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", w.abort(err)
+	}
+	if err := w.finish(); err != nil {
+		return "", fmt.Errorf("cannot write sorted rows: %w", err)
+	}
+	return w.path(), nil
+}
+
+// mergeSpilled merges sorted rows from the spilled files at paths and calls writeNextRow for every row in sort order.
+//
+// writeNextRow must consume the row at shard.rowRefNext and advance shard.rowRefNext.
+func (psp *pipeSortProcessor) mergeSpilled(paths []string, writeNextRow func(shard *pipeSortProcessorShard) error) error {
+	var h pipeSortSpillReadersHeap
+	defer func() {
+		for _, r := range h {
+			r.close()
+		}
+	}()
+	for _, path := range paths {
+		r, err := newPipeSortSpillReader(psp.ps, path)
+		if err != nil {
+			return err
+		}
+		ok, err := r.nextBatch()
+		if err != nil {
+			r.close()
+			return err
+		}
+		if !ok {
+			r.close()
+			continue
+		}
+		h = append(h, r)
+	}
+	heap.Init(&h)
+
+	for len(h) > 0 {
+		r := h[0]
+		if err := writeNextRow(r.shard); err != nil {
+			return err
+		}
+
+		if r.shard.rowRefNext >= len(r.shard.rowRefs) {
+			if needStop(psp.stopCh) {
+				return nil
+			}
+
+			ok, err := r.nextBatch()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				r.close()
+				heap.Pop(&h)
+				continue
+			}
+		}
+		heap.Fix(&h, 0)
+	}
+
+	return nil
+}
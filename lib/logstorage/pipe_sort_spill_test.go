@@ -0,0 +1,166 @@
+package logstorage
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestPipeSortSpill(t *testing.T) {
+	pipeSortSpillDir = t.TempDir()
+	defer func() {
+		pipeSortSpillDir = ""
+	}()
+
+	// Use fixed-width values, since const columns are compared as plain strings by sortBlockLess,
+	// while other columns are compared as numbers or natural strings.
+	var rows [][]Field
+	for i := 0; i < 1000; i++ {
+		row := []Field{
+			{"host", fmt.Sprintf("host-%02d", i%37)},
+			{"duration", fmt.Sprintf("%03d", (i*7919)%101)},
+			{"_msg", fmt.Sprintf("message %04d", i)},
+		}
+		if i%3 == 0 {
+			row = append(row, Field{"user", fmt.Sprintf("user-%02d", i%11)})
+		}
+		rows = append(rows, row)
+	}
+
+	f := func(pipeStr string) {
+		t.Helper()
+
+		rowsExpected := runTestPipeSort(t, pipeStr, rows, false)
+		rowsResult := runTestPipeSort(t, pipeStr, rows, true)
+		assertRowsEqualOrdered(t, rowsResult, rowsExpected)
+
+		// Merge the spilled files in multiple passes
+		maxSpillFilesPerMergeOrig := maxSpillFilesPerMerge
+		maxSpillFilesPerMerge = 2
+		rowsResult = runTestPipeSort(t, pipeStr, rows, true)
+		maxSpillFilesPerMerge = maxSpillFilesPerMergeOrig
+		assertRowsEqualOrdered(t, rowsResult, rowsExpected)
+
+		entries, err := os.ReadDir(pipeSortSpillDir)
+		if err != nil {
+			t.Fatalf("cannot read spill dir: %s", err)
+		}
+		if len(entries) > 0 {
+			t.Fatalf("unexpected %d spilled files left after flush", len(entries))
+		}
+	}
+
+	f("sort")
+	f("sort desc")
+	f("sort by (host)")
+	f("sort by (duration desc, host)")
+	f("sort by (user, _msg) desc")
+	f("sort by (duration) offset 123")
+	f("sort by (host, duration) rank as position")
+}
+
+func TestPipeSortSpillTimeColumn(t *testing.T) {
+	pipeSortSpillDir = t.TempDir()
+	defer func() {
+		pipeSortSpillDir = ""
+	}()
+
+	f := func(pipeStr string) {
+		t.Helper()
+
+		run := func(forceSpill bool) [][]Field {
+			ppTest := newTestPipeProcessor()
+			psp := newTestPipeSortProcessor(t, pipeStr, ppTest, forceSpill)
+
+			var br blockResult
+			for i := 0; i < 50; i++ {
+				rcs := []resultColumn{
+					{
+						name: "_msg",
+					},
+				}
+				var timestamps []int64
+				for j := 0; j < 7; j++ {
+					rcs[0].addValue(fmt.Sprintf("message %02d", (i*j)%13))
+					timestamps = append(timestamps, int64((i*31+j*17)%97)*1e9)
+				}
+				br.setResultColumns(rcs, len(timestamps))
+				br.timestamps = append(br.timestamps[:0], timestamps...)
+				br.addTimeColumn()
+				psp.writeBlock(uint(i%len(psp.shards)), &br)
+			}
+			if err := psp.flush(); err != nil {
+				t.Fatalf("unexpected error when flushing %q: %s", pipeStr, err)
+			}
+			if forceSpill && len(psp.spillPaths) == 0 {
+				t.Fatalf("expecting spilled rows for %q", pipeStr)
+			}
+			return ppTest.resultRows
+		}
+
+		rowsExpected := run(false)
+		rowsResult := run(true)
+		assertRowsEqualOrdered(t, rowsResult, rowsExpected)
+	}
+
+	f("sort by (_time)")
+	f("sort by (_time desc, _msg)")
+	f("sort by (_msg, _time) desc")
+}
+
+func runTestPipeSort(t *testing.T, pipeStr string, rows [][]Field, forceSpill bool) [][]Field {
+	t.Helper()
+
+	ppTest := newTestPipeProcessor()
+	psp := newTestPipeSortProcessor(t, pipeStr, ppTest, forceSpill)
+
+	brw := newTestBlockResultWriter(len(psp.shards), psp)
+	for _, row := range rows {
+		brw.writeRow(row)
+	}
+	brw.flush()
+	if err := psp.flush(); err != nil {
+		t.Fatalf("unexpected error when flushing %q: %s", pipeStr, err)
+	}
+
+	if forceSpill && len(psp.spillPaths) == 0 {
+		t.Fatalf("expecting spilled rows for %q", pipeStr)
+	}
+
+	return ppTest.resultRows
+}
+
+func newTestPipeSortProcessor(t *testing.T, pipeStr string, ppNext pipeProcessor, forceSpill bool) *pipeSortProcessor {
+	t.Helper()
+
+	lex := newLexer(pipeStr)
+	p, err := parsePipe(lex)
+	if err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+	}
+
+	workersCount := 3
+	pp := p.newPipeProcessor(workersCount, make(chan struct{}), func() {}, ppNext, newMemoryBudget(0))
+	psp := pp.(*pipeSortProcessor)
+	if forceSpill {
+		// Leave zero state size budget, so every shard spills its rows to disk on every block.
+		psp.pmb.mb.remaining.Store(0)
+		for i := range psp.shards {
+			psp.shards[i].stateSizeBudget = 0
+		}
+	}
+	return psp
+}
+
+func assertRowsEqualOrdered(t *testing.T, resultRows, expectedRows [][]Field) {
+	t.Helper()
+
+	if len(resultRows) != len(expectedRows) {
+		t.Fatalf("unexpected number of rows; got %d; want %d", len(resultRows), len(expectedRows))
+	}
+	for i, resultRow := range resultRows {
+		if s, sExpected := rowToString(resultRow), rowToString(expectedRows[i]); s != sExpected {
+			t.Fatalf("unexpected row #%d\ngot\n%s\nwant\n%s", i, s, sExpected)
+		}
+	}
+}
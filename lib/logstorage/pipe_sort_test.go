@@ -1,6 +1,8 @@
 package logstorage
 
 import (
+	"fmt"
+	"math/rand"
 	"testing"
 )
 
@@ -331,6 +333,90 @@ func TestPipeSort(t *testing.T) {
 	})
 }
 
+func TestPipeSortStableOrder(t *testing.T) {
+	var rows [][]Field
+	for i := 0; i < 200; i++ {
+		rows = append(rows, []Field{
+			{"x", fmt.Sprintf("%d", i%3)},
+			{"y", fmt.Sprintf("y-%d", i)},
+		})
+	}
+
+	f := func(sortStr string) {
+		t.Helper()
+
+		// Obtain the expected order with the full sort
+		rowsShuffled := append([][]Field{}, rows...)
+		rand.Shuffle(len(rowsShuffled), func(i, j int) {
+			rowsShuffled[i], rowsShuffled[j] = rowsShuffled[j], rowsShuffled[i]
+		})
+		rowsExpected := runTestPipeOrdered(t, sortStr, rowsShuffled)
+		if len(rowsExpected) != len(rows) {
+			t.Fatalf("unexpected number of rows for [%s]; got %d; want %d", sortStr, len(rowsExpected), len(rows))
+		}
+
+		// The full sort must return rows in the same order for shuffled input
+		rand.Shuffle(len(rowsShuffled), func(i, j int) {
+			rowsShuffled[i], rowsShuffled[j] = rowsShuffled[j], rowsShuffled[i]
+		})
+		rowsResult := runTestPipeOrdered(t, sortStr, rowsShuffled)
+		assertRowsOrderEqual(t, sortStr, rowsResult, rowsExpected)
+
+		// Pages obtained via offset and limit must match the full sort results
+		const pageSize = 7
+		for offset := 0; offset < len(rows); offset += pageSize {
+			pageStr := fmt.Sprintf("%s offset %d limit %d", sortStr, offset, pageSize)
+			rand.Shuffle(len(rowsShuffled), func(i, j int) {
+				rowsShuffled[i], rowsShuffled[j] = rowsShuffled[j], rowsShuffled[i]
+			})
+			rowsResult := runTestPipeOrdered(t, pageStr, rowsShuffled)
+			assertRowsOrderEqual(t, pageStr, rowsResult, rowsExpected[offset:min(offset+pageSize, len(rowsExpected))])
+		}
+	}
+
+	f("sort by (x)")
+	f("sort by (x) desc")
+	f("sort by (x desc)")
+}
+
+func runTestPipeOrdered(t *testing.T, pipeStr string, rows [][]Field) [][]Field {
+	t.Helper()
+
+	lex := newLexer(pipeStr)
+	p, err := parsePipe(lex)
+	if err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+	}
+
+	workersCount := 5
+	ppTest := newTestPipeProcessor()
+	pp := p.newPipeProcessor(workersCount, make(chan struct{}), func() {}, ppTest, newMemoryBudget(0))
+
+	brw := newTestBlockResultWriter(workersCount, pp)
+	for _, row := range rows {
+		brw.writeRow(row)
+	}
+	brw.flush()
+	if err := pp.flush(); err != nil {
+		t.Fatalf("unexpected error when flushing %q: %s", pipeStr, err)
+	}
+
+	return ppTest.resultRows
+}
+
+func assertRowsOrderEqual(t *testing.T, pipeStr string, resultRows, expectedRows [][]Field) {
+	t.Helper()
+
+	if len(resultRows) != len(expectedRows) {
+		t.Fatalf("unexpected number of rows for [%s]; got %d; want %d", pipeStr, len(resultRows), len(expectedRows))
+	}
+	for i := range resultRows {
+		if got, want := rowToString(resultRows[i]), rowToString(expectedRows[i]); got != want {
+			t.Fatalf("unexpected row #%d for [%s]; got\n%s\nwant\n%s", i, pipeStr, got, want)
+		}
+	}
+}
+
 func TestPipeSortUpdateNeededFields(t *testing.T) {
 	f := func(s, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected string) {
 		t.Helper()
@@ -351,14 +437,15 @@ func TestPipeSortUpdateNeededFields(t *testing.T) {
 
 	// all the needed fields, unneeded fields intersect with src
 	f("sort by(s1,s2)", "*", "s1,f1,f2", "*", "f1,f2")
+	f("sort by(s1,s2)", "*", "_time,f1", "*", "f1")
 	f("sort by(s1,s2) rank as x", "*", "s1,f1,f2", "*", "f1,f2,x")
 	f("sort by(x,s2) rank as x", "*", "s1,f1,f2", "*", "f1,f2,s1")
 
 	// needed fields do not intersect with src
-	f("sort by(s1,s2)", "f1,f2", "", "s1,s2,f1,f2", "")
-	f("sort by(s1,s2) rank as x", "f1,f2", "", "s1,s2,f1,f2", "")
+	f("sort by(s1,s2)", "f1,f2", "", "_time,s1,s2,f1,f2", "")
+	f("sort by(s1,s2) rank as x", "f1,f2", "", "_time,s1,s2,f1,f2", "")
 
 	// needed fields intersect with src
-	f("sort by(s1,s2)", "s1,f1,f2", "", "s1,s2,f1,f2", "")
-	f("sort by(s1,s2) rank as x", "s1,f1,f2,x", "", "s1,s2,f1,f2", "")
+	f("sort by(s1,s2)", "s1,f1,f2", "", "_time,s1,s2,f1,f2", "")
+	f("sort by(s1,s2) rank as x", "s1,f1,f2,x", "", "_time,s1,s2,f1,f2", "")
 }
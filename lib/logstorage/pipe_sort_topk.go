@@ -2,20 +2,16 @@ package logstorage
 
 import (
 	"container/heap"
-	"fmt"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"unsafe"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
-	"github.com/VictoriaMetrics/VictoriaMetrics/lib/memory"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/stringsutil"
 )
 
-func newPipeTopkProcessor(ps *pipeSort, workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor) pipeProcessor {
-	maxStateSize := int64(float64(memory.Allowed()) * 0.2)
-
+func newPipeTopkProcessor(ps *pipeSort, workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor, mb *memoryBudget) pipeProcessor {
+	pmb := mb.newPipeMemoryBudget(ps)
 	shards := make([]pipeTopkProcessorShard, workersCount)
 	for i := range shards {
 		shards[i] = pipeTopkProcessorShard{
@@ -24,7 +20,7 @@ func newPipeTopkProcessor(ps *pipeSort, workersCount int, stopCh <-chan struct{}
 				stateSizeBudget: stateSizeBudgetChunk,
 			},
 		}
-		maxStateSize -= stateSizeBudgetChunk
+		pmb.reserve(stateSizeBudgetChunk)
 	}
 
 	ptp := &pipeTopkProcessor{
@@ -35,9 +31,8 @@ func newPipeTopkProcessor(ps *pipeSort, workersCount int, stopCh <-chan struct{}
 
 		shards: shards,
 
-		maxStateSize: maxStateSize,
+		pmb: pmb,
 	}
-	ptp.stateSizeBudget.Store(maxStateSize)
 
 	return ptp
 }
@@ -50,8 +45,7 @@ type pipeTopkProcessor struct {
 
 	shards []pipeTopkProcessorShard
 
-	maxStateSize    int64
-	stateSizeBudget atomic.Int64
+	pmb *pipeMemoryBudget
 }
 
 type pipeTopkProcessorShard struct {
@@ -259,7 +253,8 @@ func (shard *pipeTopkProcessorShard) addRow(br *blockResult, byColumns []string,
 
 	rows := shard.rows
 	maxRows := shard.ps.offset + shard.ps.limit
-	if uint64(len(rows)) >= maxRows && !topkLess(shard.ps, r, rows[0]) {
+	isFull := uint64(len(rows)) >= maxRows
+	if isFull && topkCompareKeys(shard.ps, r, rows[0]) > 0 {
 		// Fast path - nothing to add.
 		return
 	}
@@ -278,12 +273,17 @@ func (shard *pipeTopkProcessorShard) addRow(br *blockResult, byColumns []string,
 	shard.otherColumns = otherColumns
 	r.otherColumns = otherColumns
 
+	if isFull && !topkLess(shard.ps, r, rows[0]) {
+		// r has the same keys as rows[0], but it must go after rows[0] according to the remaining columns.
+		return
+	}
+
 	// Clone r, so it doesn't refer the original data.
 	r = r.clone()
 	shard.stateSizeBudget -= r.sizeBytes()
 
 	// Push r to shard.rows.
-	if uint64(len(rows)) < maxRows {
+	if !isFull {
 		heap.Push(shard, r)
 		shard.stateSizeBudget -= int(unsafe.Sizeof(r))
 	} else {
@@ -315,13 +315,10 @@ func (ptp *pipeTopkProcessor) writeBlock(workerID uint, br *blockResult) {
 
 	for shard.stateSizeBudget < 0 {
 		// steal some budget for the state size from the global budget.
-		remaining := ptp.stateSizeBudget.Add(-stateSizeBudgetChunk)
-		if remaining < 0 {
+		if !ptp.pmb.borrow(stateSizeBudgetChunk) {
 			// The state size is too big. Stop processing data in order to avoid OOM crash.
-			if remaining+stateSizeBudgetChunk >= 0 {
-				// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
-				ptp.cancel()
-			}
+			// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
+			ptp.cancel()
 			return
 		}
 		shard.stateSizeBudget += stateSizeBudgetChunk
@@ -331,8 +328,10 @@ func (ptp *pipeTopkProcessor) writeBlock(workerID uint, br *blockResult) {
 }
 
 func (ptp *pipeTopkProcessor) flush() error {
-	if n := ptp.stateSizeBudget.Load(); n <= 0 {
-		return fmt.Errorf("cannot calculate [%s], since it requires more than %dMB of memory", ptp.ps.String(), ptp.maxStateSize/(1<<20))
+	defer ptp.pmb.release()
+
+	if ptp.pmb.isExceeded() {
+		return ptp.pmb.limitError()
 	}
 
 	if needStop(ptp.stopCh) {
@@ -569,6 +568,23 @@ func (sh *pipeTopkProcessorShardsHeap) Pop() any {
 }
 
 func topkLess(ps *pipeSort, a, b *pipeTopkRow) bool {
+	if n := topkCompareKeys(ps, a, b); n != 0 {
+		return n < 0
+	}
+
+	// Rows with equal keys are ordered by the remaining columns,
+	// so the order of the returned rows is stable across queries. This is needed for consistent pagination via 'offset'.
+	if ps.isDesc {
+		a, b = b, a
+	}
+	return lessFields(a.otherColumns, b.otherColumns)
+}
+
+// topkCompareKeys compares a and b by 'by(...)' columns and then by timestamps.
+//
+// It returns -1 if a must go before b, 1 if b must go before a and 0 if a and b have equal keys.
+// It doesn't compare a.otherColumns and b.otherColumns.
+func topkCompareKeys(ps *pipeSort, a, b *pipeTopkRow) int {
 	byFields := ps.byFields
 
 	csA := a.byColumns
@@ -588,10 +604,7 @@ func topkLess(ps *pipeSort, a, b *pipeTopkRow) bool {
 			if a.timestamp == b.timestamp {
 				continue
 			}
-			if isDesc {
-				return b.timestamp < a.timestamp
-			}
-			return a.timestamp < b.timestamp
+			return compareWithOrder(a.timestamp < b.timestamp, isDesc)
 		}
 
 		vA := csA[i]
@@ -606,27 +619,59 @@ func topkLess(ps *pipeSort, a, b *pipeTopkRow) bool {
 			bb.B = marshalTimestampRFC3339NanoString(bb.B[:0], a.timestamp)
 			vA = bytesutil.ToUnsafeString(bb.B)
 		} else if isTimeB[i] {
-			bb.B = marshalTimestampRFC3339NanoString(bb.B[:0], a.timestamp)
+			bb.B = marshalTimestampRFC3339NanoString(bb.B[:0], b.timestamp)
 			vB = bytesutil.ToUnsafeString(bb.B)
 		}
 
-		if vA == vB {
-			if bb != nil {
-				bbPool.Put(bb)
-			}
-			continue
-		}
-
 		if isDesc {
 			vA, vB = vB, vA
 		}
-		ok := lessString(vA, vB)
+		n := 0
+		if lessString(vA, vB) {
+			n = -1
+		} else if lessString(vB, vA) {
+			n = 1
+		}
 		if bb != nil {
 			bbPool.Put(bb)
 		}
-		return ok
+		if n != 0 {
+			return n
+		}
+	}
+
+	if a.timestamp == b.timestamp {
+		return 0
+	}
+	return compareWithOrder(a.timestamp < b.timestamp, ps.isDesc)
+}
+
+// compareWithOrder returns -1 if isLess is true and 1 otherwise for ascending order.
+//
+// The result is inverted if isDesc is true.
+func compareWithOrder(isLess, isDesc bool) int {
+	if isLess != isDesc {
+		return -1
+	}
+	return 1
+}
+
+// lessFields returns true if a is smaller than b.
+//
+// Fields are compared by names and values in the order they are stored in a and b.
+func lessFields(a, b []Field) bool {
+	for i := range a {
+		if i >= len(b) {
+			return false
+		}
+		if a[i].Name != b[i].Name {
+			return a[i].Name < b[i].Name
+		}
+		if a[i].Value != b[i].Value {
+			return a[i].Value < b[i].Value
+		}
 	}
-	return false
+	return len(a) < len(b)
 }
 
 func lessString(a, b string) bool {
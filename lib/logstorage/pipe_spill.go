@@ -0,0 +1,134 @@
+package logstorage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// maxSpillFilesPerMerge is the maximum number of spilled files, which are merged at once.
+//
+// This limits the number of simultaneously open files when merging big number of spilled files.
+// It is a variable in order to be able to override it in tests.
+var maxSpillFilesPerMerge = 64
+
+// SetSpillDir sets the directory for temporary files with the state of stats and sort pipes, which doesn't fit memory.
+//
+// The directory is created if it doesn't exist. Spilled files left there after unclean shutdown are removed.
+// This function must be called before running queries.
+func SetSpillDir(dir string) {
+	if dir == "" {
+		logger.Panicf("BUG: the spill dir cannot be empty")
+	}
+	fs.MustMkdirIfNotExist(dir)
+	for _, pattern := range []string{pipeStatsSpillFilePattern, pipeSortSpillFilePattern} {
+		paths, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			logger.Panicf("BUG: unexpected error for pattern %q: %s", pattern, err)
+		}
+		for _, path := range paths {
+			if err := os.Remove(path); err != nil {
+				logger.Errorf("cannot remove stale spilled file: %s", err)
+			}
+		}
+	}
+	pipeStatsSpillDir = dir
+	pipeSortSpillDir = dir
+}
+
+// spillFileWriter writes length-prefixed records into a temporary file.
+type spillFileWriter struct {
+	f  *os.File
+	bw *bufio.Writer
+}
+
+func newSpillFileWriter(dir, pattern string) (*spillFileWriter, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	w := &spillFileWriter{
+		f:  f,
+		bw: bufio.NewWriterSize(f, 64*1024),
+	}
+	return w, nil
+}
+
+func (w *spillFileWriter) path() string {
+	return w.f.Name()
+}
+
+func (w *spillFileWriter) writeRecord(record []byte) error {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(record)))
+	if _, err := w.bw.Write(lenBuf[:n]); err != nil {
+		return err
+	}
+	_, err := w.bw.Write(record)
+	return err
+}
+
+// finish flushes the written records to the file and closes it.
+//
+// The file is removed on error.
+func (w *spillFileWriter) finish() error {
+	if err := w.bw.Flush(); err != nil {
+		return closeAndRemoveSpillFile(w.f, fmt.Errorf("cannot write data to %q: %w", w.path(), err))
+	}
+	if err := w.f.Close(); err != nil {
+		_ = os.Remove(w.path())
+		return fmt.Errorf("cannot close %q: %w", w.path(), err)
+	}
+	return nil
+}
+
+// abort closes and removes the file. It returns err.
+func (w *spillFileWriter) abort(err error) error {
+	return closeAndRemoveSpillFile(w.f, err)
+}
+
+func closeAndRemoveSpillFile(f *os.File, err error) error {
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return err
+}
+
+// reduceSpillFiles merges the spilled files at paths with mergeToFile in passes until their number doesn't exceed maxSpillFilesPerMerge.
+//
+// mergeToFile must merge up to maxSpillFilesPerMerge files into a new file and return the path to it.
+// The merged files are removed after every successful mergeToFile call.
+//
+// The returned paths contain all the remaining spilled files, including the case of an error.
+func reduceSpillFiles(paths []string, stopCh <-chan struct{}, mergeToFile func(paths []string) (string, error)) ([]string, error) {
+	for len(paths) > maxSpillFilesPerMerge {
+		var pathsMerged []string
+		for len(paths) > 0 {
+			if needStop(stopCh) {
+				return append(pathsMerged, paths...), nil
+			}
+
+			n := min(len(paths), maxSpillFilesPerMerge)
+			if n == 1 {
+				pathsMerged = append(pathsMerged, paths[0])
+				paths = paths[1:]
+				continue
+			}
+			path, err := mergeToFile(paths[:n])
+			if err != nil {
+				return append(pathsMerged, paths...), err
+			}
+			for _, p := range paths[:n] {
+				_ = os.Remove(p)
+			}
+			pathsMerged = append(pathsMerged, path)
+			paths = paths[n:]
+		}
+		paths = pathsMerged
+	}
+	return paths, nil
+}
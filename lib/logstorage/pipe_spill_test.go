@@ -0,0 +1,36 @@
+package logstorage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetSpillDir(t *testing.T) {
+	defer func() {
+		pipeStatsSpillDir = ""
+		pipeSortSpillDir = ""
+	}()
+
+	dir := filepath.Join(t.TempDir(), "spill")
+	SetSpillDir(dir)
+	if pipeStatsSpillDir != dir || pipeSortSpillDir != dir {
+		t.Fatalf("unexpected spill dirs; got %q and %q; want %q", pipeStatsSpillDir, pipeSortSpillDir, dir)
+	}
+
+	// Create stale spilled files together with an unrelated file
+	for _, name := range []string{"vlogs-stats-123.bin", "vlogs-sort-456.bin", "other.bin"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("foo"), 0o600); err != nil {
+			t.Fatalf("cannot create %q: %s", name, err)
+		}
+	}
+
+	SetSpillDir(dir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("cannot read spill dir: %s", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "other.bin" {
+		t.Fatalf("unexpected entries left in spill dir: %v", entries)
+	}
+}
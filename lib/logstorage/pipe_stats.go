@@ -2,14 +2,19 @@ package logstorage
 
 import (
 	"fmt"
+	"os"
+	"slices"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"unsafe"
 
+	"github.com/cespare/xxhash/v2"
+
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
-	"github.com/VictoriaMetrics/VictoriaMetrics/lib/memory"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/slicesutil"
 )
 
 // pipeStats processes '| stats ...' queries.
@@ -39,7 +44,7 @@ type statsFunc interface {
 	String() string
 
 	// updateNeededFields update neededFields with the fields needed for calculating the given stats
-	updateNeededFields(neededFields fieldsSet)
+	updateNeededFields(neededFields *fieldsSet)
 
 	// newStatsProcessor must create new statsProcessor for calculating stats for the given statsFunc
 	//
@@ -65,6 +70,18 @@ type statsProcessor interface {
 	// mergeState must merge sfp state into statsProcessor state.
 	mergeState(sfp statsProcessor)
 
+	// exportState must append the marshaled statsProcessor state to dst and return the result.
+	//
+	// The exported state is used for spilling stats state to disk when it doesn't fit memory,
+	// for caching partial stats results and for sending partial stats from storage nodes during query federation.
+	// The imported state must be mergeable via mergeState with the state of other statsProcessors for the same stats function.
+	exportState(dst []byte) []byte
+
+	// importState must restore the statsProcessor state from src obtained via exportState.
+	//
+	// It is called only on a newly created statsProcessor.
+	importState(src []byte) error
+
 	// finalizeStats must return the collected stats result from statsProcessor.
 	finalizeStats() string
 }
@@ -99,7 +116,7 @@ func (ps *pipeStats) canLiveTail() bool {
 	return false
 }
 
-func (ps *pipeStats) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (ps *pipeStats) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	neededFieldsOrig := neededFields.clone()
 	neededFields.reset()
 
@@ -109,7 +126,9 @@ func (ps *pipeStats) updateNeededFields(neededFields, unneededFields fieldsSet)
 	}
 
 	for _, f := range ps.funcs {
-		if neededFieldsOrig.contains(f.resultName) && !unneededFields.contains(f.resultName) {
+		// Per-field stats functions such as `sum(metrics_*)` generate result names from the matching fields,
+		// so they are needed unconditionally.
+		if isPerFieldStatsFunc(f.f) || neededFieldsOrig.contains(f.resultName) && !unneededFields.contains(f.resultName) {
 			f.f.updateNeededFields(neededFields)
 			if f.iff != nil {
 				neededFields.addFields(f.iff.neededFields)
@@ -154,9 +173,8 @@ func (ps *pipeStats) initFilterInValues(cache map[string][]string, getFieldValue
 
 const stateSizeBudgetChunk = 1 << 20
 
-func (ps *pipeStats) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor) pipeProcessor {
-	maxStateSize := int64(float64(memory.Allowed()) * 0.3)
-
+func (ps *pipeStats) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor, mb *memoryBudget) pipeProcessor {
+	pmb := mb.newPipeMemoryBudget(ps)
 	shards := make([]pipeStatsProcessorShard, workersCount)
 	for i := range shards {
 		shards[i] = pipeStatsProcessorShard{
@@ -166,7 +184,7 @@ func (ps *pipeStats) newPipeProcessor(workersCount int, stopCh <-chan struct{},
 				stateSizeBudget: stateSizeBudgetChunk,
 			},
 		}
-		maxStateSize -= stateSizeBudgetChunk
+		pmb.reserve(stateSizeBudgetChunk)
 	}
 
 	psp := &pipeStatsProcessor{
@@ -177,9 +195,8 @@ func (ps *pipeStats) newPipeProcessor(workersCount int, stopCh <-chan struct{},
 
 		shards: shards,
 
-		maxStateSize: maxStateSize,
+		pmb: pmb,
 	}
-	psp.stateSizeBudget.Store(maxStateSize)
 
 	return psp
 }
@@ -192,8 +209,16 @@ type pipeStatsProcessor struct {
 
 	shards []pipeStatsProcessorShard
 
-	maxStateSize    int64
-	stateSizeBudget atomic.Int64
+	pmb *pipeMemoryBudget
+
+	// spillLock protects spillPaths and spillErr.
+	spillLock sync.Mutex
+
+	// spillPaths contains paths to files with the spilled shards state.
+	spillPaths []string
+
+	// spillErr contains the first error occurred during spilling the state to disk.
+	spillErr error
 }
 
 type pipeStatsProcessorShard struct {
@@ -206,7 +231,7 @@ type pipeStatsProcessorShard struct {
 type pipeStatsProcessorShardNopad struct {
 	ps *pipeStats
 
-	m map[string]*pipeStatsGroup
+	m *pipeStatsGroupsMap
 
 	// bms and brTmp are used for applying per-func filters.
 	bms   []bitmap
@@ -215,7 +240,21 @@ type pipeStatsProcessorShardNopad struct {
 	columnValues [][]string
 	keyBuf       []byte
 
+	// bmsDict and bmTmp are used for grouping by a single dict-encoded or uint column.
+	bmsDict []bitmap
+	bmTmp   bitmap
+
+	// uintValues, uintGroups and valueBuf are used for grouping by a single uint column.
+	//
+	// uintGroups is reset per each block, since the groups may be spilled to disk between blocks.
+	uintValues []uint64
+	uintGroups map[uint64]*pipeStatsGroup
+	valueBuf   []byte
+
 	stateSizeBudget int
+
+	// stateSizeBorrowed is the state size budget borrowed by the shard from the global budget.
+	stateSizeBorrowed int64
 }
 
 func (shard *pipeStatsProcessorShard) init() {
@@ -226,7 +265,7 @@ func (shard *pipeStatsProcessorShard) init() {
 
 	funcsLen := len(shard.ps.funcs)
 
-	shard.m = make(map[string]*pipeStatsGroup)
+	shard.m = &pipeStatsGroupsMap{}
 	shard.bms = make([]bitmap, funcsLen)
 }
 
@@ -257,6 +296,17 @@ func (shard *pipeStatsProcessorShard) writeBlock(br *blockResult) {
 			return
 		}
 
+		if c.valueType == valueTypeDict {
+			// Fast path for dict-encoded column.
+			shard.writeBlockByDictColumn(br, c, bf)
+			return
+		}
+		if !bf.hasBucketConfig() && isUintValueType(c.valueType) {
+			// Fast path for uint column.
+			shard.writeBlockByUintColumn(br, c)
+			return
+		}
+
 		values := c.getValuesBucketed(br, bf)
 		if areConstValues(values) {
 			// Fast path for column with constant values.
@@ -334,6 +384,129 @@ func (shard *pipeStatsProcessorShard) writeBlock(br *blockResult) {
 	shard.keyBuf = keyBuf
 }
 
+// writeBlockByDictColumn updates stats for br grouped by the dict-encoded column c.
+//
+// The stats are updated at once for all the rows with the same dict value instead of updating them per each row.
+func (shard *pipeStatsProcessorShard) writeBlockByDictColumn(br *blockResult, c *blockResultColumn, bf *byStatsField) {
+	dictValues := c.dictValues
+	if bf.hasBucketConfig() {
+		dictValues = br.getBucketedStringValues(dictValues, bf)
+	}
+
+	bmsDict := slicesutil.SetLength(shard.bmsDict, len(dictValues))
+	for i := range bmsDict {
+		bmsDict[i].init(len(br.timestamps))
+	}
+	shard.bmsDict = bmsDict
+
+	for rowIdx, v := range c.getValuesEncoded(br) {
+		dictIdx := v[0]
+		bmsDict[dictIdx].setBit(rowIdx)
+	}
+
+	for dictIdx := range bmsDict {
+		bm := &bmsDict[dictIdx]
+		if bm.isZero() {
+			continue
+		}
+		shard.keyBuf = encoding.MarshalBytes(shard.keyBuf[:0], bytesutil.ToUnsafeBytes(dictValues[dictIdx]))
+		psg := shard.getPipeStatsGroup(shard.keyBuf)
+		shard.stateSizeBudget -= psg.updateStatsForRows(shard.bms, bm, br, &shard.brTmp, &shard.bmTmp)
+	}
+}
+
+// writeBlockByUintColumn updates stats for br grouped by the uint column c.
+//
+// If the block contains up to maxDictLen unique values, then the stats are updated at once for all the rows with the same value.
+// Otherwise the stats are updated per each row. The groups are located by the uint value in both cases,
+// so there is no need in converting every value to string.
+func (shard *pipeStatsProcessorShard) writeBlockByUintColumn(br *blockResult, c *blockResultColumn) {
+	valuesEncoded := c.getValuesEncoded(br)
+
+	uintValues := shard.uintValues[:0]
+	bmsDict := shard.bmsDict[:0]
+	idx := 0
+	for i, v := range valuesEncoded {
+		if i <= 0 || valuesEncoded[i-1] != v {
+			n := unmarshalUintValue(c.valueType, v)
+			idx = slices.Index(uintValues, n)
+			if idx < 0 {
+				if len(uintValues) >= maxDictLen {
+					// Too many unique values in the block.
+					shard.uintValues = uintValues
+					shard.bmsDict = bmsDict
+					shard.writeBlockByUintColumnPerRow(br, c)
+					return
+				}
+				idx = len(uintValues)
+				uintValues = append(uintValues, n)
+				bmsDict = slicesutil.SetLength(bmsDict, len(bmsDict)+1)
+				bmsDict[idx].init(len(br.timestamps))
+			}
+		}
+		bmsDict[idx].setBit(i)
+	}
+	shard.uintValues = uintValues
+	shard.bmsDict = bmsDict
+
+	for i, n := range uintValues {
+		shard.valueBuf = marshalUint64String(shard.valueBuf[:0], n)
+		shard.keyBuf = encoding.MarshalBytes(shard.keyBuf[:0], shard.valueBuf)
+		psg := shard.getPipeStatsGroup(shard.keyBuf)
+		shard.stateSizeBudget -= psg.updateStatsForRows(shard.bms, &bmsDict[i], br, &shard.brTmp, &shard.bmTmp)
+	}
+}
+
+func (shard *pipeStatsProcessorShard) writeBlockByUintColumnPerRow(br *blockResult, c *blockResultColumn) {
+	if shard.uintGroups == nil {
+		shard.uintGroups = make(map[uint64]*pipeStatsGroup)
+	}
+	m := shard.uintGroups
+
+	var psg *pipeStatsGroup
+	valuesEncoded := c.getValuesEncoded(br)
+	for i, v := range valuesEncoded {
+		if i <= 0 || valuesEncoded[i-1] != v {
+			n := unmarshalUintValue(c.valueType, v)
+			psg = m[n]
+			if psg == nil {
+				shard.valueBuf = marshalUint64String(shard.valueBuf[:0], n)
+				shard.keyBuf = encoding.MarshalBytes(shard.keyBuf[:0], shard.valueBuf)
+				psg = shard.getPipeStatsGroup(shard.keyBuf)
+				m[n] = psg
+			}
+		}
+		shard.stateSizeBudget -= psg.updateStatsForRow(shard.bms, br, i)
+	}
+
+	clear(m)
+}
+
+func isUintValueType(vt valueType) bool {
+	switch vt {
+	case valueTypeUint8, valueTypeUint16, valueTypeUint32, valueTypeUint64:
+		return true
+	default:
+		return false
+	}
+}
+
+func unmarshalUintValue(vt valueType, v string) uint64 {
+	switch vt {
+	case valueTypeUint8:
+		return uint64(unmarshalUint8(v))
+	case valueTypeUint16:
+		return uint64(unmarshalUint16(v))
+	case valueTypeUint32:
+		return uint64(unmarshalUint32(v))
+	case valueTypeUint64:
+		return unmarshalUint64(v)
+	default:
+		logger.Panicf("BUG: unexpected valueType=%d; want uint type", vt)
+		return 0
+	}
+}
+
 func (shard *pipeStatsProcessorShard) applyPerFunctionFilters(br *blockResult) {
 	funcs := shard.ps.funcs
 	for i := range funcs {
@@ -349,7 +522,9 @@ func (shard *pipeStatsProcessorShard) applyPerFunctionFilters(br *blockResult) {
 }
 
 func (shard *pipeStatsProcessorShard) getPipeStatsGroup(key []byte) *pipeStatsGroup {
-	psg := shard.m[string(key)]
+	keyStr := bytesutil.ToUnsafeString(key)
+	h := xxhash.Sum64(key)
+	psg := shard.m.get(h, keyStr)
 	if psg != nil {
 		return psg
 	}
@@ -360,16 +535,21 @@ func (shard *pipeStatsProcessorShard) getPipeStatsGroup(key []byte) *pipeStatsGr
 		sfps[i] = sfp
 		shard.stateSizeBudget -= stateSize
 	}
-	psg = &pipeStatsGroup{
-		funcs: shard.ps.funcs,
-		sfps:  sfps,
-	}
-	shard.m[string(key)] = psg
-	shard.stateSizeBudget -= len(key) + int(unsafe.Sizeof("")+unsafe.Sizeof(psg)+unsafe.Sizeof(sfps[0])*uintptr(len(sfps)))
+	psg = shard.m.add(h, keyStr)
+	psg.funcs = shard.ps.funcs
+	psg.sfps = sfps
+	shard.stateSizeBudget -= getPipeStatsGroupSize(keyStr, sfps)
 
 	return psg
 }
 
+// getPipeStatsGroupSize returns the size in bytes needed for storing the group with the given key and sfps at pipeStatsGroupsMap.
+//
+// The returned size doesn't include the size of sfps states.
+func getPipeStatsGroupSize(key string, sfps []statsProcessor) int {
+	return len(key) + int(unsafe.Sizeof(pipeStatsGroupsMapEntry{})+unsafe.Sizeof(&pipeStatsGroup{})+unsafe.Sizeof(pipeStatsGroup{})+unsafe.Sizeof(sfps[0])*uintptr(len(sfps)))
+}
+
 type pipeStatsGroup struct {
 	funcs []pipeStatsFunc
 	sfps  []statsProcessor
@@ -389,6 +569,32 @@ func (psg *pipeStatsGroup) updateStatsForAllRows(bms []bitmap, br, brTmp *blockR
 	return n
 }
 
+// updateStatsForRows updates psg stats for the rows in br selected by bm.
+//
+// The filtered dict-encoded columns keep all the dict values from br, so stats functions must use forEachDictValue
+// for visiting only the values referenced by the selected rows.
+func (psg *pipeStatsGroup) updateStatsForRows(bms []bitmap, bm *bitmap, br, brTmp *blockResult, bmTmp *bitmap) int {
+	n := 0
+	for i, sfp := range psg.sfps {
+		bmRows := bm
+		if psg.funcs[i].iff != nil {
+			bmTmp.copyFrom(bm)
+			bmTmp.and(&bms[i])
+			if bmTmp.isZero() {
+				continue
+			}
+			bmRows = bmTmp
+		}
+		if bmRows.areAllBitsSet() {
+			n += sfp.updateStatsForAllRows(br)
+		} else {
+			brTmp.initFromFilterAllColumns(br, bmRows)
+			n += sfp.updateStatsForAllRows(brTmp)
+		}
+	}
+	return n
+}
+
 func (psg *pipeStatsGroup) updateStatsForRow(bms []bitmap, br *blockResult, rowIdx int) int {
 	n := 0
 	for i, sfp := range psg.sfps {
@@ -408,125 +614,522 @@ func (psp *pipeStatsProcessor) writeBlock(workerID uint, br *blockResult) {
 
 	for shard.stateSizeBudget < 0 {
 		// steal some budget for the state size from the global budget.
-		remaining := psp.stateSizeBudget.Add(-stateSizeBudgetChunk)
-		if remaining < 0 {
-			// The state size is too big. Stop processing data in order to avoid OOM crash.
-			if remaining+stateSizeBudgetChunk >= 0 {
-				// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
-				psp.cancel()
+		if len(psp.ps.byFields) > 0 {
+			if !psp.pmb.tryBorrow(stateSizeBudgetChunk) {
+				// The state size is too big. Spill the shard state to disk in order to free up memory.
+				// The spilled states are merged at flush().
+				if !psp.spillShardState(shard) {
+					return
+				}
+				break
 			}
+		} else if !psp.pmb.borrow(stateSizeBudgetChunk) {
+			// The state size is too big. Stop processing data in order to avoid OOM crash.
+			// There is no sense in spilling the state to disk for a single group without 'by (...)' fields,
+			// since it must be loaded into memory at once at flush().
+			// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
+			psp.cancel()
 			return
 		}
 		shard.stateSizeBudget += stateSizeBudgetChunk
+		shard.stateSizeBorrowed += stateSizeBudgetChunk
 	}
 
 	shard.writeBlock(br)
 }
 
+// spillShardState spills shard state to disk and returns the borrowed state size budget to psp.
+//
+// It returns false if the state couldn't be spilled.
+func (psp *pipeStatsProcessor) spillShardState(shard *pipeStatsProcessorShard) bool {
+	path, err := shard.spillState()
+
+	psp.spillLock.Lock()
+	if err != nil {
+		if psp.spillErr == nil {
+			psp.spillErr = err
+		}
+	} else {
+		psp.spillPaths = append(psp.spillPaths, path)
+	}
+	psp.spillLock.Unlock()
+
+	if err != nil {
+		// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
+		psp.cancel()
+		return false
+	}
+
+	psp.pmb.giveBack(shard.stateSizeBorrowed)
+	shard.stateSizeBorrowed = 0
+	shard.stateSizeBudget = stateSizeBudgetChunk
+	return true
+}
+
 func (psp *pipeStatsProcessor) flush() error {
-	if n := psp.stateSizeBudget.Load(); n <= 0 {
-		return fmt.Errorf("cannot calculate [%s], since it requires more than %dMB of memory", psp.ps.String(), psp.maxStateSize/(1<<20))
+	defer psp.pmb.release()
+
+	// Remove the spilled state files after the flush.
+	defer func() {
+		for _, path := range psp.spillPaths {
+			_ = os.Remove(path)
+		}
+	}()
+
+	if psp.spillErr != nil {
+		return fmt.Errorf("cannot calculate [%s]: %w", psp.ps.String(), psp.spillErr)
+	}
+	if psp.pmb.isExceeded() {
+		return psp.pmb.limitError()
 	}
 
-	// Merge states across shards
 	shards := psp.shards
+	if len(psp.spillPaths) > 0 {
+		// Some shards were spilled to disk. Spill the remaining shards too,
+		// so all the states could be merged from disk without the need to hold them in memory.
+		for i := range shards {
+			shard := &shards[i]
+			if shard.m.len() == 0 {
+				continue
+			}
+			if !psp.spillShardState(shard) {
+				return fmt.Errorf("cannot calculate [%s]: %w", psp.ps.String(), psp.spillErr)
+			}
+		}
+		if err := psp.flushSpilled(); err != nil {
+			return fmt.Errorf("cannot calculate [%s]: %w", psp.ps.String(), err)
+		}
+		return nil
+	}
+
+	if len(psp.ps.byFields) > 0 && len(shards) > 1 {
+		// Merge states across shards in parallel.
+		return psp.mergeShardsParallel()
+	}
+
+	// Merge states across shards
 	shardMain := &shards[0]
 	shardMain.init()
 	m := shardMain.m
 	shards = shards[1:]
 	for i := range shards {
 		shard := &shards[i]
-		for key, psg := range shard.m {
+		ok := shard.m.forEach(func(h uint64, key string, psg *pipeStatsGroup) bool {
 			// shard.m may be quite big, so this loop can take a lot of time and CPU.
 			// Stop processing data as soon as stopCh is closed without wasting additional CPU time.
 			if needStop(psp.stopCh) {
-				return nil
+				return false
 			}
 
-			spgBase := m[key]
+			spgBase := m.get(h, key)
 			if spgBase == nil {
-				m[key] = psg
+				*m.add(h, key) = *psg
 			} else {
 				for i, sfp := range spgBase.sfps {
 					sfp.mergeState(psg.sfps[i])
 				}
 			}
+			return true
+		})
+		if !ok {
+			return nil
 		}
 	}
 
 	// Write per-group states to ppNext
-	byFields := psp.ps.byFields
-	if len(byFields) == 0 && len(m) == 0 {
+	if len(psp.ps.byFields) == 0 && m.len() == 0 {
 		// Special case - zero matching rows.
 		_ = shardMain.getPipeStatsGroup(nil)
 		m = shardMain.m
 	}
 
-	rcs := make([]resultColumn, 0, len(byFields)+len(psp.ps.funcs))
-	for _, bf := range byFields {
-		rcs = appendResultColumnWithName(rcs, bf.name)
-	}
-	for _, f := range psp.ps.funcs {
-		rcs = appendResultColumnWithName(rcs, f.resultName)
-	}
-	var br blockResult
-
-	var values []string
-	rowsCount := 0
-	valuesLen := 0
-	for key, psg := range m {
+	wctx := newPipeStatsWriteContext(psp, 0)
+	ok := m.forEach(func(_ uint64, key string, psg *pipeStatsGroup) bool {
 		// m may be quite big, so this loop can take a lot of time and CPU.
 		// Stop processing data as soon as stopCh is closed without wasting additional CPU time.
 		if needStop(psp.stopCh) {
-			return nil
+			return false
 		}
+		wctx.writeRow(key, psg.sfps)
+		return true
+	})
+	if !ok {
+		return nil
+	}
+	wctx.flush()
+
+	return nil
+}
+
+// mergeShardsParallel merges states across psp.shards in parallel and writes the results to psp.ppNext.
+//
+// Every shard state is split into len(psp.shards) partitions by group key hash,
+// so the partitions with the same index across workers contain the same set of keys.
+// Then every partition is merged and written to psp.ppNext by a dedicated worker.
+//
+// Shard states may be skewed - for example, when the cached state is imported into the first shard,
+// or when the previous pipe writes all the blocks from a single worker. So the shard states are split
+// into chunks with approximately equal number of groups, and every worker picks up the next chunk
+// for partitioning as soon as it finishes the current one. This keeps all the workers busy.
+//
+// The partitions hold copies of the groups until the partitioned shard states are released, so the memory
+// for the partitions is charged to the memory budget of the pipe. An error is returned if the partitions do not fit the budget.
+func (psp *pipeStatsProcessor) mergeShardsParallel() error {
+	shards := psp.shards
+	shardsLen := len(shards)
+
+	chunks := getPipeStatsMergeChunks(shards)
+	var nextChunkIdx atomic.Int64
+
+	perWorkerPartitions := make([][]pipeStatsGroupsMap, shardsLen)
+	var wg sync.WaitGroup
+	for i := 0; i < shardsLen; i++ {
+		wg.Add(1)
+		go func(workerIdx int) {
+			defer wg.Done()
+
+			partitions := make([]pipeStatsGroupsMap, shardsLen)
+			budget := 0
+			for {
+				n := int(nextChunkIdx.Add(1)) - 1
+				if n >= len(chunks) {
+					break
+				}
+
+				c := &chunks[n]
+				ok := c.shard.m.forEachInSlots(c.start, c.end, func(h uint64, key string, psg *pipeStatsGroup) bool {
+					// The chunk may be quite big, so this loop can take a lot of time and CPU.
+					// Stop processing data as soon as stopCh is closed without wasting additional CPU time.
+					if needStop(psp.stopCh) {
+						return false
+					}
+					// Select the partition by the upper bits of the hash, since the lower bits are used
+					// for selecting hash table slots inside the partition.
+					m := &partitions[(h>>32)%uint64(shardsLen)]
+
+					// The worker may process chunks from distinct shards, so the key may already exist in the partition.
+					psgBase := m.get(h, key)
+					if psgBase == nil {
+						if !psp.borrowMergeBudget(&budget, key, psg) {
+							return false
+						}
+						*m.add(h, key) = *psg
+					} else {
+						for i, sfp := range psgBase.sfps {
+							sfp.mergeState(psg.sfps[i])
+						}
+					}
+					return true
+				})
+				if !ok {
+					return
+				}
+
+				if c.pendingChunks.Add(-1) == 0 {
+					// All the chunks for the shard are partitioned. Release the shard state in order to reduce memory usage.
+					c.shard.m = nil
+				}
+			}
+
+			perWorkerPartitions[workerIdx] = partitions
+		}(i)
+	}
+	wg.Wait()
+
+	if psp.pmb.isExceeded() {
+		return psp.pmb.limitError()
+	}
+	if needStop(psp.stopCh) {
+		return nil
+	}
 
-		// Unmarshal values for byFields from key.
-		values = values[:0]
-		keyBuf := bytesutil.ToUnsafeBytes(key)
-		for len(keyBuf) > 0 {
-			v, nSize := encoding.UnmarshalBytes(keyBuf)
-			if nSize <= 0 {
-				logger.Panicf("BUG: cannot unmarshal value from keyBuf=%q", keyBuf)
+	for i := 0; i < shardsLen; i++ {
+		wg.Add(1)
+		go func(workerID uint) {
+			defer wg.Done()
+
+			m := &perWorkerPartitions[0][workerID]
+			budget := 0
+			for _, partitions := range perWorkerPartitions[1:] {
+				ok := partitions[workerID].forEach(func(h uint64, key string, psg *pipeStatsGroup) bool {
+					if needStop(psp.stopCh) {
+						return false
+					}
+
+					spgBase := m.get(h, key)
+					if spgBase == nil {
+						if !psp.borrowMergeBudget(&budget, key, psg) {
+							return false
+						}
+						*m.add(h, key) = *psg
+					} else {
+						for i, sfp := range spgBase.sfps {
+							sfp.mergeState(psg.sfps[i])
+						}
+					}
+					return true
+				})
+				if !ok {
+					return
+				}
+
+				// The partition is merged into m. Release it in order to reduce memory usage.
+				partitions[workerID].reset()
 			}
-			keyBuf = keyBuf[nSize:]
-			values = append(values, bytesutil.ToUnsafeString(v))
+
+			wctx := newPipeStatsWriteContext(psp, workerID)
+			ok := m.forEach(func(_ uint64, key string, psg *pipeStatsGroup) bool {
+				if needStop(psp.stopCh) {
+					return false
+				}
+				wctx.writeRow(key, psg.sfps)
+				return true
+			})
+			if !ok {
+				return
+			}
+			wctx.flush()
+		}(uint(i))
+	}
+	wg.Wait()
+
+	if psp.pmb.isExceeded() {
+		return psp.pmb.limitError()
+	}
+	return nil
+}
+
+// borrowMergeBudget charges the memory needed for adding the copy of psg with the given key to the partition at mergeShardsParallel.
+//
+// budget is the remaining memory borrowed by the merging worker. It returns false if the memory budget is exceeded.
+// In this case the remaining workers stop merging too, since psp.pmb.isExceeded() returns true.
+func (psp *pipeStatsProcessor) borrowMergeBudget(budget *int, key string, psg *pipeStatsGroup) bool {
+	if psp.pmb.isExceeded() {
+		return false
+	}
+	*budget -= getPipeStatsGroupSize(key, psg.sfps)
+	for *budget < 0 {
+		if !psp.pmb.borrow(stateSizeBudgetChunk) {
+			return false
 		}
-		if len(values) != len(byFields) {
-			logger.Panicf("BUG: unexpected number of values decoded from keyBuf; got %d; want %d", len(values), len(byFields))
+		*budget += stateSizeBudgetChunk
+	}
+	return true
+}
+
+// pipeStatsMergeChunk is a range of hash table slots at the shard state, which is partitioned by a single worker at mergeShardsParallel.
+type pipeStatsMergeChunk struct {
+	shard *pipeStatsProcessorShard
+
+	// pendingChunks is the number of chunks for the shard, which aren't partitioned yet.
+	//
+	// It is shared among all the chunks for the shard.
+	pendingChunks *atomic.Int64
+
+	start int
+	end   int
+}
+
+// getPipeStatsMergeChunks splits the states of the given shards into chunks with approximately equal number of groups.
+func getPipeStatsMergeChunks(shards []pipeStatsProcessorShard) []pipeStatsMergeChunk {
+	groupsTotal := 0
+	for i := range shards {
+		groupsTotal += shards[i].m.len()
+	}
+
+	// Create a few chunks per worker, so the workers remain busy until the end of partitioning.
+	// Do not create too small chunks, since their processing overhead may exceed the processing time.
+	chunkGroups := max(groupsTotal/(4*len(shards)), 4096)
+
+	var chunks []pipeStatsMergeChunk
+	for i := range shards {
+		shard := &shards[i]
+		groupsCount := shard.m.len()
+		if groupsCount == 0 {
+			continue
 		}
 
-		// calculate values for stats functions
-		for _, sfp := range psg.sfps {
-			value := sfp.finalizeStats()
-			values = append(values, value)
+		// The groups are evenly distributed among hash table slots, since the slots are selected by hash.
+		chunksCount := (groupsCount + chunkGroups - 1) / chunkGroups
+		slotsLen := shard.m.slotsLen()
+		pendingChunks := &atomic.Int64{}
+		pendingChunks.Store(int64(chunksCount))
+		for j := 0; j < chunksCount; j++ {
+			chunks = append(chunks, pipeStatsMergeChunk{
+				shard:         shard,
+				pendingChunks: pendingChunks,
+				start:         j * slotsLen / chunksCount,
+				end:           (j + 1) * slotsLen / chunksCount,
+			})
 		}
+	}
+	return chunks
+}
+
+// pipeStatsWriteContext writes the calculated stats to ppNext.
+type pipeStatsWriteContext struct {
+	psp      *pipeStatsProcessor
+	workerID uint
+
+	rcs []resultColumn
+	br  blockResult
 
-		if len(values) != len(rcs) {
-			logger.Panicf("BUG: len(values)=%d must be equal to len(rcs)=%d", len(values), len(rcs))
+	values    []string
+	rowsCount int
+	valuesLen int
+
+	// hasPerFieldFuncs is set to true if some of stats functions return a separate result per every matching field.
+	//
+	// In this case the set of result columns may change from one row to another.
+	hasPerFieldFuncs bool
+
+	// fields is used as temporary buffer for the results of stats functions if hasPerFieldFuncs is set.
+	fields []Field
+}
+
+func newPipeStatsWriteContext(psp *pipeStatsProcessor, workerID uint) *pipeStatsWriteContext {
+	rcs := make([]resultColumn, 0, len(psp.ps.byFields)+len(psp.ps.funcs))
+	for _, bf := range psp.ps.byFields {
+		rcs = appendResultColumnWithName(rcs, bf.name)
+	}
+	hasPerFieldFuncs := false
+	for _, f := range psp.ps.funcs {
+		rcs = appendResultColumnWithName(rcs, f.resultName)
+		if isPerFieldStatsFunc(f.f) {
+			hasPerFieldFuncs = true
 		}
-		for i, v := range values {
-			rcs[i].addValue(v)
-			valuesLen += len(v)
+	}
+	return &pipeStatsWriteContext{
+		psp:      psp,
+		workerID: workerID,
+		rcs:      rcs,
+
+		hasPerFieldFuncs: hasPerFieldFuncs,
+	}
+}
+
+// writeRow writes stats for the group with the given key and the given sfps.
+func (wctx *pipeStatsWriteContext) writeRow(key string, sfps []statsProcessor) {
+	byFields := wctx.psp.ps.byFields
+	rcs := wctx.rcs
+
+	// Unmarshal values for byFields from key.
+	values := wctx.values[:0]
+	keyBuf := bytesutil.ToUnsafeBytes(key)
+	for len(keyBuf) > 0 {
+		v, nSize := encoding.UnmarshalBytes(keyBuf)
+		if nSize <= 0 {
+			logger.Panicf("BUG: cannot unmarshal value from keyBuf=%q", keyBuf)
 		}
+		keyBuf = keyBuf[nSize:]
+		values = append(values, bytesutil.ToUnsafeString(v))
+	}
+	if len(values) != len(byFields) {
+		logger.Panicf("BUG: unexpected number of values decoded from keyBuf; got %d; want %d", len(values), len(byFields))
+	}
+
+	if wctx.hasPerFieldFuncs {
+		wctx.values = values
+		wctx.writeRowWithPerFieldFuncs(values, sfps)
+		return
+	}
+
+	// calculate values for stats functions
+	for _, sfp := range sfps {
+		value := sfp.finalizeStats()
+		values = append(values, value)
+	}
+	wctx.values = values
+
+	if len(values) != len(rcs) {
+		logger.Panicf("BUG: len(values)=%d must be equal to len(rcs)=%d", len(values), len(rcs))
+	}
+	for i, v := range values {
+		rcs[i].addValue(v)
+		wctx.valuesLen += len(v)
+	}
+
+	wctx.rowsCount++
+	if wctx.valuesLen >= 1_000_000 {
+		wctx.flush()
+	}
+}
 
-		rowsCount++
-		if valuesLen >= 1_000_000 {
-			br.setResultColumns(rcs, rowsCount)
-			rowsCount = 0
-			psp.ppNext.writeBlock(0, &br)
-			br.reset()
-			for i := range rcs {
-				rcs[i].resetValues()
+// writeRowWithPerFieldFuncs writes stats for the group with the given byValues and the given sfps,
+// which may return a separate result per every matching field.
+func (wctx *pipeStatsWriteContext) writeRowWithPerFieldFuncs(byValues []string, sfps []statsProcessor) {
+	byFields := wctx.psp.ps.byFields
+	funcs := wctx.psp.ps.funcs
+
+	// calculate results for stats functions
+	fields := wctx.fields[:0]
+	for i, sfp := range sfps {
+		if spp, ok := sfp.(*statsPerFieldProcessor); ok {
+			fields = spp.finalizeStatsFields(fields, funcs[i].resultName)
+			continue
+		}
+		fields = append(fields, Field{
+			Name:  funcs[i].resultName,
+			Value: sfp.finalizeStats(),
+		})
+	}
+	wctx.fields = fields
+
+	rcs := wctx.rcs
+	areEqualColumns := len(rcs) == len(byFields)+len(fields)
+	if areEqualColumns {
+		for i, f := range fields {
+			if rcs[len(byFields)+i].name != f.Name {
+				areEqualColumns = false
+				break
 			}
-			valuesLen = 0
 		}
 	}
+	if !areEqualColumns {
+		// send the current block to ppNext and construct a block with new set of columns
+		wctx.flush()
 
-	br.setResultColumns(rcs, rowsCount)
-	psp.ppNext.writeBlock(0, &br)
+		rcs = wctx.rcs[:0]
+		for _, bf := range byFields {
+			rcs = appendResultColumnWithName(rcs, bf.name)
+		}
+		for _, f := range fields {
+			rcs = appendResultColumnWithName(rcs, f.Name)
+		}
+		wctx.rcs = rcs
+	}
 
-	return nil
+	for i, v := range byValues {
+		rcs[i].addValue(v)
+		wctx.valuesLen += len(v)
+	}
+	for i, f := range fields {
+		rcs[len(byFields)+i].addValue(f.Value)
+		wctx.valuesLen += len(f.Value)
+	}
+
+	wctx.rowsCount++
+	if wctx.valuesLen >= 1_000_000 {
+		wctx.flush()
+	}
+}
+
+func (wctx *pipeStatsWriteContext) flush() {
+	if wctx.rowsCount == 0 {
+		return
+	}
+
+	rcs := wctx.rcs
+	br := &wctx.br
+
+	br.setResultColumns(rcs, wctx.rowsCount)
+	wctx.rowsCount = 0
+	wctx.psp.ppNext.writeBlock(wctx.workerID, br)
+	br.reset()
+	for i := range rcs {
+		rcs[i].resetValues()
+	}
+	wctx.valuesLen = 0
 }
 
 func parsePipeStats(lex *lexer, needStatsKeyword bool) (*pipeStats, error) {
@@ -587,6 +1190,10 @@ func parsePipeStats(lex *lexer, needStatsKeyword bool) (*pipeStats, error) {
 			}
 			resultName = fieldName
 		}
+		if isPerFieldStatsFunc(sf) && resultName != sf.String() && !isWildcardFieldName(resultName) {
+			return nil, fmt.Errorf("the result name %q for [%s] must end with '*', since [%s] returns a separate result per every matching field; "+
+				"for example, [%s as result_*]", resultName, sf, sf, sf)
+		}
 		if bf := seenByFields[resultName]; bf != nil {
 			return nil, fmt.Errorf("the %q is used as 'by' field [%s], so it cannot be used as result name for [%s]", resultName, bf, sf)
 		}
@@ -610,118 +1217,172 @@ func parsePipeStats(lex *lexer, needStatsKeyword bool) (*pipeStats, error) {
 }
 
 func parseStatsFunc(lex *lexer) (statsFunc, error) {
-	switch {
-	case lex.isKeyword("avg"):
-		sas, err := parseStatsAvg(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'avg' func: %w", err)
-		}
-		return sas, nil
-	case lex.isKeyword("count"):
-		scs, err := parseStatsCount(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'count' func: %w", err)
-		}
-		return scs, nil
-	case lex.isKeyword("count_empty"):
-		scs, err := parseStatsCountEmpty(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'count_empty' func: %w", err)
-		}
-		return scs, nil
-	case lex.isKeyword("count_uniq"):
-		sus, err := parseStatsCountUniq(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'count_uniq' func: %w", err)
-		}
-		return sus, nil
-	case lex.isKeyword("max"):
-		sms, err := parseStatsMax(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'max' func: %w", err)
-		}
-		return sms, nil
-	case lex.isKeyword("median"):
-		sms, err := parseStatsMedian(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'median' func: %w", err)
-		}
-		return sms, nil
-	case lex.isKeyword("min"):
-		sms, err := parseStatsMin(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'min' func: %w", err)
-		}
-		return sms, nil
-	case lex.isKeyword("quantile"):
-		sqs, err := parseStatsQuantile(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'quantile' func: %w", err)
-		}
-		return sqs, nil
-	case lex.isKeyword("row_any"):
-		sas, err := parseStatsRowAny(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'row_any' func: %w", err)
-		}
-		return sas, nil
-	case lex.isKeyword("row_max"):
-		sms, err := parseStatsRowMax(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'row_max' func: %w", err)
-		}
-		return sms, nil
-	case lex.isKeyword("row_min"):
-		sms, err := parseStatsRowMin(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'row_min' func: %w", err)
-		}
-		return sms, nil
-	case lex.isKeyword("sum"):
-		sss, err := parseStatsSum(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'sum' func: %w", err)
-		}
-		return sss, nil
-	case lex.isKeyword("sum_len"):
-		sss, err := parseStatsSumLen(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'sum_len' func: %w", err)
-		}
-		return sss, nil
-	case lex.isKeyword("uniq_values"):
-		sus, err := parseStatsUniqValues(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'uniq_values' func: %w", err)
-		}
-		return sus, nil
-	case lex.isKeyword("values"):
-		svs, err := parseStatsValues(lex)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse 'values' func: %w", err)
+	if !lex.isQuotedToken() {
+		sp, ok := getStatsFuncParsers()[strings.ToLower(lex.token)]
+		if ok {
+			sf, err := sp.parse(lex)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse '%s' func: %w", sp.name, err)
+			}
+			return sf, nil
 		}
-		return svs, nil
-	default:
-		return nil, fmt.Errorf("unknown stats func %q", lex.token)
 	}
+	return nil, fmt.Errorf("unknown stats func %q", lex.token)
+}
+
+// statsFuncParser is a parser for the stats function with the given name.
+type statsFuncParser struct {
+	name string
+
+	// parse must parse the stats function starting from the function name at lex.
+	parse func(lex *lexer) (statsFunc, error)
+}
+
+// allStatsFuncParsers returns parsers for all the supported stats functions.
+//
+// New builtin stats functions must be added here, so they are recognized by parseStatsFunc and isPipeName.
+// Stats functions implemented outside the logstorage package must be registered via RegisterStatsFunc.
+func allStatsFuncParsers() []statsFuncParser {
+	return []statsFuncParser{
+		{
+			name: "avg",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsAvg(lex)
+			},
+		},
+		{
+			name: "count",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsCount(lex)
+			},
+		},
+		{
+			name: "count_empty",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsCountEmpty(lex)
+			},
+		},
+		{
+			name: "count_uniq",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsCountUniq(lex)
+			},
+		},
+		{
+			name: "count_uniq_hash",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsCountUniqHash(lex)
+			},
+		},
+		{
+			name: "max",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsMax(lex)
+			},
+		},
+		{
+			name: "median",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsMedian(lex)
+			},
+		},
+		{
+			name: "min",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsMin(lex)
+			},
+		},
+		{
+			name: "quantile",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsQuantile(lex)
+			},
+		},
+		{
+			name: "row_any",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsRowAny(lex)
+			},
+		},
+		{
+			name: "row_max",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsRowMax(lex)
+			},
+		},
+		{
+			name: "row_min",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsRowMin(lex)
+			},
+		},
+		{
+			name: "sum",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsSum(lex)
+			},
+		},
+		{
+			name: "sum_len",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsSumLen(lex)
+			},
+		},
+		{
+			name: "uniq_approx",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsUniqApprox(lex)
+			},
+		},
+		{
+			name: "uniq_values",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsUniqValues(lex)
+			},
+		},
+		{
+			name: "values",
+			parse: func(lex *lexer) (statsFunc, error) {
+				return parseStatsValues(lex)
+			},
+		},
+	}
+}
+
+// getStatsFuncParsers returns parsers for all the supported stats functions keyed by stats function names.
+//
+// The parsers are initialized lazily in the same way as pipe parsers at getPipeParsers.
+func getStatsFuncParsers() map[string]*statsFuncParser {
+	statsFuncParsersOnce.Do(initStatsFuncParsers)
+	return statsFuncParsers
+}
+
+var (
+	statsFuncParsers     map[string]*statsFuncParser
+	statsFuncParsersOnce sync.Once
+)
+
+func initStatsFuncParsers() {
+	sps := allStatsFuncParsers()
+	m := make(map[string]*statsFuncParser, len(sps))
+	for i := range sps {
+		addStatsFuncParser(m, &sps[i])
+	}
+	statsFuncParsers = m
 }
 
-var statsNames = []string{
-	"avg",
-	"count",
-	"count_empty",
-	"count_uniq",
-	"max",
-	"median",
-	"min",
-	"quantile",
-	"row_any",
-	"row_max",
-	"row_min",
-	"sum",
-	"sum_len",
-	"uniq_values",
-	"values",
+// addStatsFuncParser adds sp to m.
+func addStatsFuncParser(m map[string]*statsFuncParser, sp *statsFuncParser) {
+	if _, ok := m[sp.name]; ok {
+		logger.Panicf("BUG: duplicate stats func name %q", sp.name)
+	}
+	m[sp.name] = sp
+}
+
+// isStatsFuncName returns true if s is a stats function name.
+func isStatsFuncName(s string) bool {
+	_, ok := getStatsFuncParsers()[strings.ToLower(s)]
+	return ok
 }
 
 var zeroByStatsField = &byStatsField{}
@@ -945,7 +1606,11 @@ func fieldNamesString(fields []string) string {
 	a := make([]string, len(fields))
 	for i, f := range fields {
 		if f != "*" {
-			f = quoteTokenIfNeeded(f)
+			if isWildcardFieldName(f) {
+				f = quoteTokenIfNeeded(f[:len(f)-1]) + "*"
+			} else {
+				f = quoteTokenIfNeeded(f)
+			}
 		}
 		a[i] = f
 	}
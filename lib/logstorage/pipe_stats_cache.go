@@ -0,0 +1,114 @@
+package logstorage
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+// exportState merges the states collected by psp shards and returns them in the form suitable for importState.
+//
+// Groups in the returned state are sorted by key. The state is exported instead of calling flush() at psp,
+// so psp cannot be used after the call.
+//
+// false is returned if the state cannot be exported, since it has been spilled to disk or it exceeds the memory budget.
+func (psp *pipeStatsProcessor) exportState() ([]byte, bool) {
+	defer func() {
+		for _, path := range psp.spillPaths {
+			_ = os.Remove(path)
+		}
+	}()
+
+	if psp.spillErr != nil || len(psp.spillPaths) > 0 {
+		return nil, false
+	}
+	if psp.pmb.isExceeded() {
+		return nil, false
+	}
+
+	shards := psp.shards
+	shardMain := &shards[0]
+	shardMain.init()
+	m := shardMain.m
+	for i := range shards[1:] {
+		shard := &shards[i+1]
+		shard.m.forEach(func(h uint64, key string, psg *pipeStatsGroup) bool {
+			psgBase := m.get(h, key)
+			if psgBase == nil {
+				*m.add(h, key) = *psg
+			} else {
+				for i, sfp := range psgBase.sfps {
+					sfp.mergeState(psg.sfps[i])
+				}
+			}
+			return true
+		})
+	}
+
+	var dst, record, state []byte
+	m.forEachSorted(func(key string, psg *pipeStatsGroup) bool {
+		record, state = marshalStatsStateRecord(record[:0], state, key, psg.sfps)
+		dst = encoding.MarshalVarUint64(dst, uint64(len(record)))
+		dst = append(dst, record...)
+		return true
+	})
+	return dst, true
+}
+
+// importState merges the state obtained via exportState at another pipeStatsProcessor for the same pipe into psp.
+//
+// It must be called before the first writeBlock call. psp remains unchanged if an error is returned.
+func (psp *pipeStatsProcessor) importState(src []byte) error {
+	funcs := psp.ps.funcs
+	stateSize := len(src)
+
+	type group struct {
+		key  []byte
+		sfps []statsProcessor
+	}
+	var groups []group
+	var states [][]byte
+	for len(src) > 0 {
+		recordLen, n := encoding.UnmarshalVarUint64(src)
+		if n <= 0 {
+			return fmt.Errorf("cannot unmarshal record length")
+		}
+		src = src[n:]
+		if uint64(len(src)) < recordLen {
+			return fmt.Errorf("too short record; got %d bytes; want %d bytes", len(src), recordLen)
+		}
+		record := src[:recordLen]
+		src = src[recordLen:]
+
+		key, statesNew, err := unmarshalStatsStateRecord(states[:0], record, len(funcs))
+		states = statesNew
+		if err != nil {
+			return err
+		}
+		sfps := make([]statsProcessor, len(funcs))
+		for i, f := range funcs {
+			sfp, _ := f.f.newStatsProcessor()
+			if err := sfp.importState(states[i]); err != nil {
+				return fmt.Errorf("cannot import state for [%s]: %w", f.f, err)
+			}
+			sfps[i] = sfp
+		}
+		groups = append(groups, group{
+			key:  key,
+			sfps: sfps,
+		})
+	}
+
+	shard := &psp.shards[0]
+	shard.init()
+	for _, g := range groups {
+		psg := shard.getPipeStatsGroup(g.key)
+		for i, sfp := range psg.sfps {
+			sfp.mergeState(g.sfps[i])
+		}
+	}
+	shard.stateSizeBudget -= stateSize
+
+	return nil
+}
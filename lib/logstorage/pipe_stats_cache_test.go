@@ -0,0 +1,148 @@
+package logstorage
+
+import (
+	"testing"
+)
+
+func TestPipeStatsExportImportState(t *testing.T) {
+	f := func(pipeStr string, rowsExported, rows, rowsExpected [][]Field) {
+		t.Helper()
+
+		lex := newLexer(pipeStr)
+		p, err := parsePipe(lex)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+		}
+		p.optimize()
+		ps := p.(*pipeStats)
+
+		workersCount := 5
+		stopCh := make(chan struct{})
+		cancel := func() {}
+
+		// Collect the state for rowsExported.
+		pspExported := ps.newPipeProcessor(workersCount, stopCh, cancel, nil, newMemoryBudget(0)).(*pipeStatsProcessor)
+		brw := newTestBlockResultWriter(workersCount, pspExported)
+		for _, row := range rowsExported {
+			brw.writeRow(row)
+		}
+		brw.flush()
+		state, ok := pspExported.exportState()
+		if !ok {
+			t.Fatalf("cannot export state for [%s]", pipeStr)
+		}
+
+		// Import the state and merge it with the state for rows.
+		ppTest := newTestPipeProcessor()
+		psp := ps.newPipeProcessor(workersCount, stopCh, cancel, ppTest, newMemoryBudget(0)).(*pipeStatsProcessor)
+		if err := psp.importState(state); err != nil {
+			t.Fatalf("cannot import state for [%s]: %s", pipeStr, err)
+		}
+		brw = newTestBlockResultWriter(workersCount, psp)
+		for _, row := range rows {
+			brw.writeRow(row)
+		}
+		brw.flush()
+		if err := psp.flush(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		ppTest.expectRows(t, rowsExpected)
+	}
+
+	rowsExported := [][]Field{
+		{
+			{"a", "x"},
+			{"b", "3"},
+		},
+		{
+			{"a", "y"},
+			{"b", "5"},
+		},
+		{
+			{"a", "x"},
+			{"b", "7"},
+		},
+	}
+	rows := [][]Field{
+		{
+			{"a", "x"},
+			{"b", "2"},
+		},
+		{
+			{"a", "z"},
+			{"b", "3"},
+		},
+	}
+
+	f("stats count() hits, sum(b) sum_b, count_uniq(b) uniq_b", rowsExported, rows, [][]Field{
+		{
+			{"hits", "5"},
+			{"sum_b", "20"},
+			{"uniq_b", "4"},
+		},
+	})
+
+	f("stats by (a) count() hits, max(b) max_b", rowsExported, rows, [][]Field{
+		{
+			{"a", "x"},
+			{"hits", "3"},
+			{"max_b", "7"},
+		},
+		{
+			{"a", "y"},
+			{"hits", "1"},
+			{"max_b", "5"},
+		},
+		{
+			{"a", "z"},
+			{"hits", "1"},
+			{"max_b", "3"},
+		},
+	})
+
+	// empty exported state
+	f("stats by (a) count() hits", nil, rows, [][]Field{
+		{
+			{"a", "x"},
+			{"hits", "1"},
+		},
+		{
+			{"a", "z"},
+			{"hits", "1"},
+		},
+	})
+
+	// empty state for new rows
+	f("stats count() hits", rowsExported, nil, [][]Field{
+		{
+			{"hits", "3"},
+		},
+	})
+}
+
+func TestPipeStatsImportStateFailure(t *testing.T) {
+	f := func(state []byte) {
+		t.Helper()
+
+		lex := newLexer("stats by (a) count() hits")
+		p, err := parsePipe(lex)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		ps := p.(*pipeStats)
+		psp := ps.newPipeProcessor(1, make(chan struct{}), func() {}, nil, newMemoryBudget(0)).(*pipeStatsProcessor)
+		if err := psp.importState(state); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// too short record
+	f([]byte{10, 1, 2})
+
+	// missing state for the stats function
+	f([]byte{2, 1, 'x'})
+
+	// invalid record length
+	f([]byte{0xff})
+}
@@ -0,0 +1,206 @@
+package logstorage
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+)
+
+// pipeStatsGroupsMap maps group keys to pipeStatsGroup.
+//
+// It uses open addressing with linear probing over 64-bit hashes of group keys.
+// Key bytes for all the groups are stored in a single buffer, while the groups are allocated in chunks,
+// so adding new groups doesn't allocate memory per every group. The hash table slots do not contain pointers,
+// so GC doesn't need scanning them. This reduces the number of allocations and GC pressure for queries with millions of groups.
+//
+// Hashes for the keys must be calculated with xxhash.Sum64String by the caller,
+// so they can be re-used for partitioning the groups without re-hashing the keys.
+type pipeStatsGroupsMap struct {
+	// entries contains the hash table slots. Its length is zero or a power of two.
+	entries []pipeStatsGroupsMapEntry
+
+	// keysBuf contains key bytes for all the entries.
+	keysBuf []byte
+
+	// groups contains pointers to all the groups in the order they were added.
+	groups []*pipeStatsGroup
+
+	// groupsBuf is the current chunk for allocating new groups.
+	groupsBuf []pipeStatsGroup
+}
+
+type pipeStatsGroupsMapEntry struct {
+	hash uint64
+
+	// keyStart and keyEnd are the boundaries of the key at keysBuf.
+	//
+	// Offsets are used instead of strings, since keysBuf may be re-allocated when adding new keys.
+	keyStart int
+	keyEnd   int
+
+	// groupIdx is the index of the group at groups plus one. Empty slots have zero groupIdx.
+	groupIdx int
+}
+
+// len returns the number of groups in m.
+//
+// It is safe calling len on nil m.
+func (m *pipeStatsGroupsMap) len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.groups)
+}
+
+// reset releases all the memory occupied by m.
+func (m *pipeStatsGroupsMap) reset() {
+	m.entries = nil
+	m.keysBuf = nil
+	m.groups = nil
+	m.groupsBuf = nil
+}
+
+// get returns the group for the given key with the given hash h.
+//
+// nil is returned if m doesn't contain the given key.
+func (m *pipeStatsGroupsMap) get(h uint64, key string) *pipeStatsGroup {
+	if len(m.entries) == 0 {
+		return nil
+	}
+
+	mask := uint64(len(m.entries) - 1)
+	for i := h & mask; ; i = (i + 1) & mask {
+		e := &m.entries[i]
+		if e.groupIdx == 0 {
+			return nil
+		}
+		if e.hash == h && m.getKey(e) == key {
+			return m.groups[e.groupIdx-1]
+		}
+	}
+}
+
+// add adds a zero group for the given key with the given hash h to m and returns it.
+//
+// The key must be missing in m. The key is copied to m, so it may be changed after the call.
+// The returned group remains valid until m.reset() call.
+func (m *pipeStatsGroupsMap) add(h uint64, key string) *pipeStatsGroup {
+	// Keep the load factor below 3/4, so linear probing remains fast.
+	if 4*(len(m.groups)+1) > 3*len(m.entries) {
+		m.grow()
+	}
+
+	if len(m.groupsBuf) == cap(m.groupsBuf) {
+		// Allocate the next chunk of groups. Start with small chunks, so queries with a few groups do not waste memory.
+		chunkLen := min(max(len(m.groups), 8), 1024)
+		m.groupsBuf = make([]pipeStatsGroup, 0, chunkLen)
+	}
+	m.groupsBuf = m.groupsBuf[:len(m.groupsBuf)+1]
+	psg := &m.groupsBuf[len(m.groupsBuf)-1]
+	m.groups = append(m.groups, psg)
+
+	keyStart := len(m.keysBuf)
+	m.keysBuf = append(m.keysBuf, key...)
+	m.insertEntry(pipeStatsGroupsMapEntry{
+		hash:     h,
+		keyStart: keyStart,
+		keyEnd:   len(m.keysBuf),
+		groupIdx: len(m.groups),
+	})
+
+	return psg
+}
+
+func (m *pipeStatsGroupsMap) grow() {
+	entriesLen := 2 * len(m.entries)
+	if entriesLen == 0 {
+		entriesLen = 16
+	}
+
+	entries := m.entries
+	m.entries = make([]pipeStatsGroupsMapEntry, entriesLen)
+	for i := range entries {
+		if entries[i].groupIdx != 0 {
+			m.insertEntry(entries[i])
+		}
+	}
+}
+
+func (m *pipeStatsGroupsMap) insertEntry(e pipeStatsGroupsMapEntry) {
+	mask := uint64(len(m.entries) - 1)
+	i := e.hash & mask
+	for m.entries[i].groupIdx != 0 {
+		i = (i + 1) & mask
+	}
+	m.entries[i] = e
+}
+
+// forEach calls f for every group in m in arbitrary order.
+//
+// The key passed to f is valid until m is modified. forEach stops and returns false as soon as f returns false.
+//
+// It is safe calling forEach on nil m.
+func (m *pipeStatsGroupsMap) forEach(f func(h uint64, key string, psg *pipeStatsGroup) bool) bool {
+	return m.forEachInSlots(0, m.slotsLen(), f)
+}
+
+// slotsLen returns the number of hash table slots in m.
+//
+// The slots may be split into ranges, which can be iterated independently with forEachInSlots.
+//
+// It is safe calling slotsLen on nil m.
+func (m *pipeStatsGroupsMap) slotsLen() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.entries)
+}
+
+// forEachInSlots calls f for every group in m located at the hash table slots in the range [start..end).
+//
+// The key passed to f is valid until m is modified. forEachInSlots stops and returns false as soon as f returns false.
+//
+// It is safe calling forEachInSlots on nil m.
+func (m *pipeStatsGroupsMap) forEachInSlots(start, end int, f func(h uint64, key string, psg *pipeStatsGroup) bool) bool {
+	if m == nil {
+		return true
+	}
+	for i := start; i < end; i++ {
+		e := &m.entries[i]
+		if e.groupIdx == 0 {
+			continue
+		}
+		if !f(e.hash, m.getKey(e), m.groups[e.groupIdx-1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// forEachSorted calls f for every group in m in ascending order of keys.
+//
+// The key passed to f is valid until m is modified. forEachSorted stops and returns false as soon as f returns false.
+func (m *pipeStatsGroupsMap) forEachSorted(f func(key string, psg *pipeStatsGroup) bool) bool {
+	idxs := make([]int, 0, len(m.groups))
+	for i := range m.entries {
+		if m.entries[i].groupIdx != 0 {
+			idxs = append(idxs, i)
+		}
+	}
+	slices.SortFunc(idxs, func(a, b int) int {
+		return strings.Compare(m.getKey(&m.entries[a]), m.getKey(&m.entries[b]))
+	})
+
+	for _, idx := range idxs {
+		e := &m.entries[idx]
+		if !f(m.getKey(e), m.groups[e.groupIdx-1]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *pipeStatsGroupsMap) getKey(e *pipeStatsGroupsMapEntry) string {
+	return bytesutil.ToUnsafeString(m.keysBuf[e.keyStart:e.keyEnd])
+}
@@ -0,0 +1,149 @@
+package logstorage
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+func TestPipeStatsGroupsMap(t *testing.T) {
+	f := func(keysCount int) {
+		t.Helper()
+
+		var m pipeStatsGroupsMap
+		var keys []string
+		groups := make(map[string]*pipeStatsGroup)
+		for i := 0; i < keysCount; i++ {
+			key := fmt.Sprintf("key_%d", i)
+			h := xxhash.Sum64String(key)
+			if psg := m.get(h, key); psg != nil {
+				t.Fatalf("unexpected group found for the missing key %q", key)
+			}
+			psg := m.add(h, key)
+			psg.sfps = make([]statsProcessor, i%3)
+			groups[key] = psg
+			keys = append(keys, key)
+		}
+		if n := m.len(); n != keysCount {
+			t.Fatalf("unexpected number of groups; got %d; want %d", n, keysCount)
+		}
+
+		// The groups must remain valid after the map growth.
+		for _, key := range keys {
+			psg := m.get(xxhash.Sum64String(key), key)
+			if psg != groups[key] {
+				t.Fatalf("unexpected group for the key %q", key)
+			}
+		}
+
+		// The same hash with the distinct key must be missing.
+		if len(keys) > 0 {
+			if psg := m.get(xxhash.Sum64String(keys[0]), "missing"); psg != nil {
+				t.Fatalf("unexpected group found for the missing key")
+			}
+		}
+
+		var keysIterated []string
+		m.forEach(func(h uint64, key string, psg *pipeStatsGroup) bool {
+			if h != xxhash.Sum64String(key) {
+				t.Fatalf("unexpected hash for the key %q", key)
+			}
+			if psg != groups[key] {
+				t.Fatalf("unexpected group for the key %q", key)
+			}
+			keysIterated = append(keysIterated, key)
+			return true
+		})
+		slices.Sort(keys)
+		slices.Sort(keysIterated)
+		if !slices.Equal(keysIterated, keys) {
+			t.Fatalf("unexpected keys iterated by forEach\ngot\n%q\nwant\n%q", keysIterated, keys)
+		}
+
+		var keysSorted []string
+		m.forEachSorted(func(key string, psg *pipeStatsGroup) bool {
+			if psg != groups[key] {
+				t.Fatalf("unexpected group for the key %q", key)
+			}
+			keysSorted = append(keysSorted, key)
+			return true
+		})
+		if !slices.Equal(keysSorted, keys) {
+			t.Fatalf("unexpected keys iterated by forEachSorted\ngot\n%q\nwant\n%q", keysSorted, keys)
+		}
+
+		m.reset()
+		if n := m.len(); n != 0 {
+			t.Fatalf("unexpected number of groups after reset; got %d; want 0", n)
+		}
+		if len(keys) > 0 {
+			if psg := m.get(xxhash.Sum64String(keys[0]), keys[0]); psg != nil {
+				t.Fatalf("unexpected group found after reset")
+			}
+		}
+	}
+
+	f(0)
+	f(1)
+	f(10)
+	f(1000)
+	f(100_000)
+}
+
+func TestPipeStatsGroupsMapEmptyKey(t *testing.T) {
+	var m pipeStatsGroupsMap
+	h := xxhash.Sum64String("")
+	psg := m.add(h, "")
+	if m.get(h, "") != psg {
+		t.Fatalf("cannot find the group for empty key")
+	}
+	if m.len() != 1 {
+		t.Fatalf("unexpected number of groups; got %d; want 1", m.len())
+	}
+}
+
+func TestPipeStatsGroupsMapForEachStop(t *testing.T) {
+	var m pipeStatsGroupsMap
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		m.add(xxhash.Sum64String(key), key)
+	}
+
+	calls := 0
+	ok := m.forEach(func(_ uint64, _ string, _ *pipeStatsGroup) bool {
+		calls++
+		return calls < 3
+	})
+	if ok {
+		t.Fatalf("forEach must return false when f returns false")
+	}
+	if calls != 3 {
+		t.Fatalf("unexpected number of forEach calls; got %d; want 3", calls)
+	}
+
+	calls = 0
+	ok = m.forEachSorted(func(_ string, _ *pipeStatsGroup) bool {
+		calls++
+		return false
+	})
+	if ok {
+		t.Fatalf("forEachSorted must return false when f returns false")
+	}
+	if calls != 1 {
+		t.Fatalf("unexpected number of forEachSorted calls; got %d; want 1", calls)
+	}
+
+	// nil map must be safe to iterate
+	var mNil *pipeStatsGroupsMap
+	if n := mNil.len(); n != 0 {
+		t.Fatalf("unexpected len for nil map; got %d; want 0", n)
+	}
+	if !mNil.forEach(func(_ uint64, _ string, _ *pipeStatsGroup) bool {
+		t.Fatalf("unexpected call for nil map")
+		return true
+	}) {
+		t.Fatalf("forEach must return true for nil map")
+	}
+}
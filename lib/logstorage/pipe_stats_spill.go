@@ -0,0 +1,427 @@
+package logstorage
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+// pipeStatsSpillDir is the directory for temporary files with the spilled stats pipe state.
+//
+// The system temporary directory is used if it is empty. See SetSpillDir.
+var pipeStatsSpillDir string
+
+// pipeStatsSpillFilePattern is the pattern for names of files with the spilled stats pipe state.
+const pipeStatsSpillFilePattern = "vlogs-stats-*.bin"
+
+// spillState writes the shard state sorted by group keys into a temporary file and frees up the state.
+//
+// It returns the path to the created file.
+func (shard *pipeStatsProcessorShard) spillState() (string, error) {
+	w, err := newSpillFileWriter(pipeStatsSpillDir, pipeStatsSpillFilePattern)
+	if err != nil {
+		return "", fmt.Errorf("cannot create temporary file for spilling stats state: %w", err)
+	}
+
+	var record, state []byte
+	var writeErr error
+	shard.m.forEachSorted(func(key string, psg *pipeStatsGroup) bool {
+		record, state = marshalStatsStateRecord(record[:0], state, key, psg.sfps)
+		if err := w.writeRecord(record); err != nil {
+			writeErr = err
+			return false
+		}
+		return true
+	})
+	if writeErr != nil {
+		return "", w.abort(fmt.Errorf("cannot write stats state to %q: %w", w.path(), writeErr))
+	}
+	if err := w.finish(); err != nil {
+		return "", fmt.Errorf("cannot write stats state: %w", err)
+	}
+
+	shard.m.reset()
+	return w.path(), nil
+}
+
+// marshalStatsStateRecord appends the record with the given group key and the exported states of sfps to dst.
+//
+// stateBuf is used as a temporary buffer for the exported states. It is returned for the reuse.
+func marshalStatsStateRecord(dst, stateBuf []byte, key string, sfps []statsProcessor) ([]byte, []byte) {
+	dst = encoding.MarshalBytes(dst, bytesutil.ToUnsafeBytes(key))
+	for _, sfp := range sfps {
+		stateBuf = sfp.exportState(stateBuf[:0])
+		dst = encoding.MarshalBytes(dst, stateBuf)
+	}
+	return dst, stateBuf
+}
+
+// unmarshalStatsStateRecord unmarshals the record created by marshalStatsStateRecord from src.
+//
+// It appends the exported states for funcsLen stats functions to dst and returns the result together with the group key.
+// The returned key and states refer to src.
+func unmarshalStatsStateRecord(dst [][]byte, src []byte, funcsLen int) ([]byte, [][]byte, error) {
+	key, n := encoding.UnmarshalBytes(src)
+	if n <= 0 {
+		return nil, dst, fmt.Errorf("cannot unmarshal group key")
+	}
+	src = src[n:]
+
+	for i := 0; i < funcsLen; i++ {
+		state, n := encoding.UnmarshalBytes(src)
+		if n <= 0 {
+			return nil, dst, fmt.Errorf("cannot unmarshal state for stats function #%d", i)
+		}
+		src = src[n:]
+		dst = append(dst, state)
+	}
+	if len(src) > 0 {
+		return nil, dst, fmt.Errorf("unexpected tail left after reading record; len(tail)=%d", len(src))
+	}
+	return key, dst, nil
+}
+
+// pipeStatsSpillReader reads groups from a file created by pipeStatsProcessorShard.spillState.
+type pipeStatsSpillReader struct {
+	path string
+	f    *os.File
+	br   *bufio.Reader
+
+	buf []byte
+
+	// key is the key for the current group.
+	key string
+
+	// states contains exported states for stats functions of the current group.
+	states [][]byte
+}
+
+func newPipeStatsSpillReader(path string) (*pipeStatsSpillReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open spilled stats state: %w", err)
+	}
+	r := &pipeStatsSpillReader{
+		path: path,
+		f:    f,
+		br:   bufio.NewReaderSize(f, 64*1024),
+	}
+	return r, nil
+}
+
+func (r *pipeStatsSpillReader) close() {
+	_ = r.f.Close()
+}
+
+// next reads the next group from r.
+//
+// It returns false if there are no more groups in r.
+func (r *pipeStatsSpillReader) next(funcsLen int) (bool, error) {
+	recordLen, err := binary.ReadUvarint(r.br)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		return false, fmt.Errorf("cannot read record length from %q: %w", r.path, err)
+	}
+
+	r.buf = slices.Grow(r.buf[:0], int(recordLen))[:recordLen]
+	if _, err := io.ReadFull(r.br, r.buf); err != nil {
+		return false, fmt.Errorf("cannot read record with length %d from %q: %w", recordLen, r.path, err)
+	}
+
+	key, states, err := unmarshalStatsStateRecord(r.states[:0], r.buf, funcsLen)
+	r.states = states
+	if err != nil {
+		return false, fmt.Errorf("cannot read record from %q: %w", r.path, err)
+	}
+	r.key = bytesutil.ToUnsafeString(key)
+	return true, nil
+}
+
+type pipeStatsSpillReadersHeap []*pipeStatsSpillReader
+
+func (h *pipeStatsSpillReadersHeap) Len() int {
+	return len(*h)
+}
+
+func (h *pipeStatsSpillReadersHeap) Less(i, j int) bool {
+	a := *h
+	return a[i].key < a[j].key
+}
+
+func (h *pipeStatsSpillReadersHeap) Swap(i, j int) {
+	a := *h
+	a[i], a[j] = a[j], a[i]
+}
+
+func (h *pipeStatsSpillReadersHeap) Push(v any) {
+	*h = append(*h, v.(*pipeStatsSpillReader))
+}
+
+func (h *pipeStatsSpillReadersHeap) Pop() any {
+	a := *h
+	r := a[len(a)-1]
+	a[len(a)-1] = nil
+	*h = a[:len(a)-1]
+	return r
+}
+
+// flushSpilled merges states from the spilled files and writes the results to psp.ppNext.
+//
+// Groups in every spilled file are sorted by key, so the files are merged in a streaming manner,
+// which needs memory only for a single group at a time. If there are too many spilled files, then they are merged
+// into bigger files in multiple passes, so the number of simultaneously open files remains bounded.
+func (psp *pipeStatsProcessor) flushSpilled() error {
+	paths, err := reduceSpillFiles(psp.spillPaths, psp.stopCh, psp.mergeSpilledToFile)
+	psp.spillPaths = paths
+	if err != nil {
+		return err
+	}
+
+	wctx := newPipeStatsWriteContext(psp, 0)
+	err = psp.mergeSpilled(paths, func(key string, sfps []statsProcessor) error {
+		wctx.writeRow(key, sfps)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if needStop(psp.stopCh) {
+		return nil
+	}
+	wctx.flush()
+
+	return nil
+}
+
+// mergeSpilledToFile merges states from the spilled files at paths into a new spilled file and returns the path to it.
+func (psp *pipeStatsProcessor) mergeSpilledToFile(paths []string) (string, error) {
+	w, err := newSpillFileWriter(pipeStatsSpillDir, pipeStatsSpillFilePattern)
+	if err != nil {
+		return "", fmt.Errorf("cannot create temporary file for merging spilled stats state: %w", err)
+	}
+
+	var record, state []byte
+	err = psp.mergeSpilled(paths, func(key string, sfps []statsProcessor) error {
+		record, state = marshalStatsStateRecord(record[:0], state, key, sfps)
+		if err := w.writeRecord(record); err != nil {
+			return fmt.Errorf("cannot write stats state to %q: %w", w.path(), err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", w.abort(err)
+	}
+	if err := w.finish(); err != nil {
+		return "", fmt.Errorf("cannot write stats state: %w", err)
+	}
+	return w.path(), nil
+}
+
+// mergeSpilled merges states for the same group keys from the spilled files at paths and calls f for every group in key order.
+func (psp *pipeStatsProcessor) mergeSpilled(paths []string, f func(key string, sfps []statsProcessor) error) error {
+	funcs := psp.ps.funcs
+
+	var h pipeStatsSpillReadersHeap
+	defer func() {
+		for _, r := range h {
+			r.close()
+		}
+	}()
+	for _, path := range paths {
+		r, err := newPipeStatsSpillReader(path)
+		if err != nil {
+			return err
+		}
+		ok, err := r.next(len(funcs))
+		if err != nil {
+			r.close()
+			return err
+		}
+		if !ok {
+			r.close()
+			continue
+		}
+		h = append(h, r)
+	}
+	heap.Init(&h)
+
+	// advance moves the reader with the smallest key to the next group.
+	advance := func() error {
+		r := h[0]
+		ok, err := r.next(len(funcs))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			r.close()
+			heap.Pop(&h)
+			return nil
+		}
+		heap.Fix(&h, 0)
+		return nil
+	}
+
+	for len(h) > 0 {
+		if needStop(psp.stopCh) {
+			return nil
+		}
+
+		r := h[0]
+		key := strings.Clone(r.key)
+		sfps := make([]statsProcessor, len(funcs))
+		for i, f := range funcs {
+			sfp, _ := f.f.newStatsProcessor()
+			if err := sfp.importState(r.states[i]); err != nil {
+				return fmt.Errorf("cannot import state for [%s] from %q: %w", f.f, r.path, err)
+			}
+			sfps[i] = sfp
+		}
+		if err := advance(); err != nil {
+			return err
+		}
+
+		// Merge states for the same key from the remaining files.
+		for len(h) > 0 && h[0].key == key {
+			r := h[0]
+			for i, f := range funcs {
+				sfp, _ := f.f.newStatsProcessor()
+				if err := sfp.importState(r.states[i]); err != nil {
+					return fmt.Errorf("cannot import state for [%s] from %q: %w", f.f, r.path, err)
+				}
+				sfps[i].mergeState(sfp)
+			}
+			if err := advance(); err != nil {
+				return err
+			}
+		}
+
+		if err := f(key, sfps); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func marshalStateBool(dst []byte, v bool) []byte {
+	if v {
+		return append(dst, 1)
+	}
+	return append(dst, 0)
+}
+
+func unmarshalStateBool(src []byte) (bool, []byte, error) {
+	if len(src) < 1 {
+		return false, src, fmt.Errorf("cannot unmarshal bool from %d bytes; need at least 1 byte", len(src))
+	}
+	return src[0] != 0, src[1:], nil
+}
+
+func marshalStateUint64(dst []byte, n uint64) []byte {
+	return encoding.MarshalUint64(dst, n)
+}
+
+func unmarshalStateUint64(src []byte) (uint64, []byte, error) {
+	if len(src) < 8 {
+		return 0, src, fmt.Errorf("cannot unmarshal uint64 from %d bytes; need at least 8 bytes", len(src))
+	}
+	return encoding.UnmarshalUint64(src), src[8:], nil
+}
+
+func marshalStateFloat64(dst []byte, f float64) []byte {
+	return marshalStateUint64(dst, math.Float64bits(f))
+}
+
+func unmarshalStateFloat64(src []byte) (float64, []byte, error) {
+	n, tail, err := unmarshalStateUint64(src)
+	return math.Float64frombits(n), tail, err
+}
+
+func marshalStateString(dst []byte, s string) []byte {
+	return encoding.MarshalBytes(dst, bytesutil.ToUnsafeBytes(s))
+}
+
+// unmarshalStateString returns a copy of the string unmarshaled from src, so it remains valid after src is modified.
+func unmarshalStateString(src []byte) (string, []byte, error) {
+	b, n := encoding.UnmarshalBytes(src)
+	if n <= 0 {
+		return "", src, fmt.Errorf("cannot unmarshal string")
+	}
+	return string(b), src[n:], nil
+}
+
+func marshalStateStrings(dst []byte, a []string) []byte {
+	dst = marshalStateUint64(dst, uint64(len(a)))
+	for _, s := range a {
+		dst = marshalStateString(dst, s)
+	}
+	return dst
+}
+
+func unmarshalStateStrings(dst []string, src []byte) ([]string, []byte, error) {
+	n, src, err := unmarshalStateUint64(src)
+	if err != nil {
+		return dst, src, fmt.Errorf("cannot unmarshal the number of strings: %w", err)
+	}
+	for i := uint64(0); i < n; i++ {
+		var s string
+		s, src, err = unmarshalStateString(src)
+		if err != nil {
+			return dst, src, fmt.Errorf("cannot unmarshal string #%d out of %d: %w", i, n, err)
+		}
+		dst = append(dst, s)
+	}
+	return dst, src, nil
+}
+
+func marshalStateFields(dst []byte, fields []Field) []byte {
+	dst = marshalStateUint64(dst, uint64(len(fields)))
+	for _, f := range fields {
+		dst = marshalStateString(dst, f.Name)
+		dst = marshalStateString(dst, f.Value)
+	}
+	return dst
+}
+
+func unmarshalStateFields(dst []Field, src []byte) ([]Field, []byte, error) {
+	n, src, err := unmarshalStateUint64(src)
+	if err != nil {
+		return dst, src, fmt.Errorf("cannot unmarshal the number of fields: %w", err)
+	}
+	for i := uint64(0); i < n; i++ {
+		var name, value string
+		name, src, err = unmarshalStateString(src)
+		if err != nil {
+			return dst, src, fmt.Errorf("cannot unmarshal field name #%d out of %d: %w", i, n, err)
+		}
+		value, src, err = unmarshalStateString(src)
+		if err != nil {
+			return dst, src, fmt.Errorf("cannot unmarshal value for field %q: %w", name, err)
+		}
+		dst = append(dst, Field{
+			Name:  name,
+			Value: value,
+		})
+	}
+	return dst, src, nil
+}
+
+// checkStateTail returns an error if tail isn't empty after unmarshaling the state.
+func checkStateTail(tail []byte) error {
+	if len(tail) > 0 {
+		return fmt.Errorf("unexpected non-empty tail left after unmarshaling state; len(tail)=%d", len(tail))
+	}
+	return nil
+}
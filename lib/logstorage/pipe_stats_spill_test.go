@@ -0,0 +1,266 @@
+package logstorage
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestPipeStatsSpill(t *testing.T) {
+	pipeStatsSpillDir = t.TempDir()
+	defer func() {
+		pipeStatsSpillDir = ""
+	}()
+
+	var rows [][]Field
+	for i := 0; i < 1000; i++ {
+		rows = append(rows, []Field{
+			{"host", fmt.Sprintf("host-%d", i%37)},
+			{"path", fmt.Sprintf("/path/%d", i%5)},
+			{"user", fmt.Sprintf("user-%d", i%11)},
+			{"duration", fmt.Sprintf("%d", i)},
+			{"_msg", fmt.Sprintf("message %d", i)},
+		})
+	}
+
+	f := func(pipeStr string) {
+		t.Helper()
+
+		rowsExpected := runTestPipeStats(t, pipeStr, rows, false)
+		rowsResult := runTestPipeStats(t, pipeStr, rows, true)
+		assertRowsEqual(t, rowsResult, rowsExpected)
+
+		// Merge the spilled files in multiple passes
+		maxSpillFilesPerMergeOrig := maxSpillFilesPerMerge
+		maxSpillFilesPerMerge = 2
+		rowsResult = runTestPipeStats(t, pipeStr, rows, true)
+		maxSpillFilesPerMerge = maxSpillFilesPerMergeOrig
+		assertRowsEqual(t, rowsResult, rowsExpected)
+
+		entries, err := os.ReadDir(pipeStatsSpillDir)
+		if err != nil {
+			t.Fatalf("cannot read spill dir: %s", err)
+		}
+		if len(entries) > 0 {
+			t.Fatalf("unexpected %d spilled files left after flush", len(entries))
+		}
+	}
+
+	f("stats by (host) count() as rows")
+	f("stats by (host, path) count() as rows, count_uniq(user) as users, sum(duration) as duration_sum")
+	f("stats by (path) min(duration) as min_duration, max(duration) as max_duration, avg(duration) as avg_duration")
+	f("stats by (path) uniq_values(user) as users, count_uniq_hash(user) as users_hash, uniq_approx(user) as users_approx")
+	f("stats by (path) count_empty(missing) as empty, sum_len(_msg) as msg_len, median(duration) as median_duration")
+	f("stats by (path) row_max(duration, host) as row, quantile(0.9, duration) as p90")
+	f("stats by (path) count() if (user:user-1) as user1_rows")
+}
+
+func runTestPipeStats(t *testing.T, pipeStr string, rows [][]Field, forceSpill bool) [][]Field {
+	t.Helper()
+
+	lex := newLexer(pipeStr)
+	p, err := parsePipe(lex)
+	if err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+	}
+
+	workersCount := 3
+	ppTest := newTestPipeProcessor()
+	pp := p.newPipeProcessor(workersCount, make(chan struct{}), func() {}, ppTest, newMemoryBudget(0))
+	psp := pp.(*pipeStatsProcessor)
+	if forceSpill {
+		// Leave zero state size budget, so every shard spills its state to disk on every block.
+		psp.pmb.mb.remaining.Store(0)
+		for i := range psp.shards {
+			psp.shards[i].stateSizeBudget = 0
+		}
+	}
+
+	brw := newTestBlockResultWriter(workersCount, pp)
+	for _, row := range rows {
+		brw.writeRow(row)
+	}
+	brw.flush()
+	if err := pp.flush(); err != nil {
+		t.Fatalf("unexpected error when flushing %q: %s", pipeStr, err)
+	}
+
+	if forceSpill && len(psp.spillPaths) == 0 {
+		t.Fatalf("expecting spilled state for %q", pipeStr)
+	}
+
+	return ppTest.resultRows
+}
+
+func TestStatsProcessorExportImportState(t *testing.T) {
+	f := func(funcStr string, rows [][]Field) {
+		t.Helper()
+
+		lex := newLexer(funcStr)
+		sf, err := parseStatsFunc(lex)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", funcStr, err)
+		}
+
+		sfp, _ := sf.newStatsProcessor()
+		if len(rows) > 0 {
+			br := newTestStatsBlockResult(rows)
+			sfp.updateStatsForAllRows(br)
+		}
+		resultExpected := sfp.finalizeStats()
+
+		state := sfp.exportState(nil)
+		sfpImported, _ := sf.newStatsProcessor()
+		if err := sfpImported.importState(state); err != nil {
+			t.Fatalf("cannot import state for %q: %s", funcStr, err)
+		}
+		result := sfpImported.finalizeStats()
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %q after importing the state; got %q; want %q", funcStr, result, resultExpected)
+		}
+
+		// Verify that the state is exported identically after the import
+		stateImported := sfpImported.exportState(nil)
+		if len(stateImported) != len(state) {
+			t.Fatalf("unexpected state length for %q after import; got %d; want %d", funcStr, len(stateImported), len(state))
+		}
+
+		// Verify that corrupted state is detected
+		if len(state) > 0 {
+			sfpBroken, _ := sf.newStatsProcessor()
+			if err := sfpBroken.importState(state[:len(state)-1]); err == nil {
+				t.Fatalf("expecting non-nil error when importing truncated state for %q", funcStr)
+			}
+		}
+		sfpBroken, _ := sf.newStatsProcessor()
+		if err := sfpBroken.importState(append(state, 'x')); err == nil {
+			t.Fatalf("expecting non-nil error when importing state with unexpected tail for %q", funcStr)
+		}
+	}
+
+	rows := [][]Field{
+		{
+			{"a", "1"},
+			{"b", "foo"},
+		},
+		{
+			{"a", "3.5"},
+			{"b", "bar"},
+		},
+		{
+			{"a", "-2"},
+			{"b", ""},
+		},
+	}
+
+	for _, funcStr := range []string{
+		"avg(a)",
+		"count()",
+		"count_empty(b)",
+		"count_uniq(b)",
+		"count_uniq_hash(b)",
+		"max(a)",
+		"median(a)",
+		"min(a)",
+		"quantile(0.5, a)",
+		"row_any(b)",
+		"row_max(a)",
+		"row_min(a, b)",
+		"sum(a)",
+		"sum_len(b)",
+		"uniq_approx(b)",
+		"uniq_values(b)",
+		"values(b)",
+	} {
+		f(funcStr, rows)
+		f(funcStr, nil)
+	}
+}
+
+func TestStatsProcessorMergeImportedState(t *testing.T) {
+	f := func(funcStr string, rowsParts ...[][]Field) {
+		t.Helper()
+
+		lex := newLexer(funcStr)
+		sf, err := parseStatsFunc(lex)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", funcStr, err)
+		}
+
+		// Calculate the expected result over all the rows
+		var rowsAll [][]Field
+		for _, rows := range rowsParts {
+			rowsAll = append(rowsAll, rows...)
+		}
+		sfpAll, _ := sf.newStatsProcessor()
+		sfpAll.updateStatsForAllRows(newTestStatsBlockResult(rowsAll))
+		resultExpected := sfpAll.finalizeStats()
+
+		// Calculate the result by merging states exported from distinct statsProcessors
+		sfpMerged, _ := sf.newStatsProcessor()
+		for _, rows := range rowsParts {
+			sfp, _ := sf.newStatsProcessor()
+			sfp.updateStatsForAllRows(newTestStatsBlockResult(rows))
+			state := sfp.exportState(nil)
+
+			sfpImported, _ := sf.newStatsProcessor()
+			if err := sfpImported.importState(state); err != nil {
+				t.Fatalf("cannot import state for %q: %s", funcStr, err)
+			}
+			sfpMerged.mergeState(sfpImported)
+		}
+		result := sfpMerged.finalizeStats()
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %q after merging imported states; got %q; want %q", funcStr, result, resultExpected)
+		}
+	}
+
+	rows1 := [][]Field{
+		{
+			{"a", "1"},
+			{"b", "foo"},
+		},
+		{
+			{"a", "3.5"},
+			{"b", "bar"},
+		},
+	}
+	rows2 := [][]Field{
+		{
+			{"a", "-2"},
+			{"b", ""},
+		},
+		{
+			{"a", "10"},
+			{"b", "foo"},
+		},
+		{
+			{"a", "7"},
+			{"b", "baz"},
+		},
+	}
+
+	for _, funcStr := range []string{
+		"avg(a)",
+		"count()",
+		"count_empty(b)",
+		"count_uniq(b)",
+		"count_uniq_hash(b)",
+		"max(a)",
+		"median(a)",
+		"min(a)",
+		"quantile(0.9, a)",
+		"row_max(a)",
+		"row_min(a, b)",
+		"sum(a)",
+		"sum(a, b)",
+		"sum_len(b)",
+		"uniq_approx(b)",
+		"uniq_values(b)",
+		"values(b)",
+		"max(*)",
+		"sum_len(a*)",
+	} {
+		f(funcStr, rows1, rows2)
+	}
+}
@@ -1,7 +1,12 @@
 package logstorage
 
 import (
+	"fmt"
+	"strings"
+	"sync/atomic"
 	"testing"
+
+	"github.com/cespare/xxhash/v2"
 )
 
 func TestParsePipeStatsSuccess(t *testing.T) {
@@ -14,6 +19,7 @@ func TestParsePipeStatsSuccess(t *testing.T) {
 	f(`stats by (x) count(*) as rows, count_uniq(x) as uniqs`)
 	f(`stats by (_time:month offset 6.5h, y) count(*) as rows, count_uniq(x) as uniqs`)
 	f(`stats by (_time:month offset 6.5h, y) count(*) if (q:w) as rows, count_uniq(x) as uniqs`)
+	f(`stats by (x) sum(metrics_*) as "sum_*", avg(metrics_*) as "avg(metrics_*)"`)
 }
 
 func TestParsePipeStatsFailure(t *testing.T) {
@@ -27,6 +33,7 @@ func TestParsePipeStatsFailure(t *testing.T) {
 	f(`stats foo`)
 	f(`stats count`)
 	f(`stats if (x:y)`)
+	f(`stats sum(metrics_*) as total`)
 	f(`stats by(x) foo`)
 	f(`stats by(x:abc) count() rows`)
 	f(`stats by(x:1h offset) count () rows`)
@@ -103,6 +110,28 @@ func TestPipeStats(t *testing.T) {
 		},
 	})
 
+	// word filter at 'if (...)' is applied to _msg field
+	f("stats count() if (error) as errors, count() as total", [][]Field{
+		{
+			{"_msg", `an error occurred`},
+		},
+		{
+			{"_msg", `errors are ignored`},
+		},
+		{
+			{"_msg", `error`},
+			{"a", `2`},
+		},
+		{
+			{"a", `error`},
+		},
+	}, [][]Field{
+		{
+			{"errors", "2"},
+			{"total", "4"},
+		},
+	})
+
 	f("stats count(*) as rows", [][]Field{
 		{
 			{"_msg", `abc`},
@@ -394,6 +423,71 @@ func TestPipeStats(t *testing.T) {
 		},
 	})
 
+	// negative numbers must be truncated towards negative infinity
+	f("stats by (x:10) count(*) as rows", [][]Field{
+		{
+			{"x", "-5"},
+		},
+		{
+			{"x", "-15"},
+		},
+		{
+			{"x", "5"},
+		},
+	}, [][]Field{
+		{
+			{"x", "-10"},
+			{"rows", "1"},
+		},
+		{
+			{"x", "-20"},
+			{"rows", "1"},
+		},
+		{
+			{"x", "0"},
+			{"rows", "1"},
+		},
+	})
+	f("stats by (x:1m) count(*) as rows", [][]Field{
+		{
+			{"x", "-30s"},
+		},
+		{
+			{"x", "30s"},
+		},
+	}, [][]Field{
+		{
+			{"x", "-1m"},
+			{"rows", "1"},
+		},
+		{
+			{"x", "0"},
+			{"rows", "1"},
+		},
+	})
+
+	// timestamps before 1970 must be truncated towards the past
+	f("stats by (_time:1d) count(*) as rows", [][]Field{
+		{
+			{"_time", "1969-12-31T10:20:30Z"},
+		},
+		{
+			{"_time", "1969-12-31T20:20:30Z"},
+		},
+		{
+			{"_time", "1970-01-01T10:20:30Z"},
+		},
+	}, [][]Field{
+		{
+			{"_time", "1969-12-31T00:00:00Z"},
+			{"rows", "2"},
+		},
+		{
+			{"_time", "1970-01-01T00:00:00Z"},
+			{"rows", "1"},
+		},
+	})
+
 	f("stats by (_time:1d offset 2h) count(*) as rows", [][]Field{
 		{
 			{"_time", "2024-04-01T00:20:30Z"},
@@ -512,3 +606,326 @@ func TestPipeStatsUpdateNeededFields(t *testing.T) {
 	f("stats by (b1,b2) count(f1,f2) r1", "r1,r2", "", "b1,b2,f1,f2", "")
 	f("stats by (b1,b2) count(f1,f2) r1, count(f1,f3) r2", "r1,r3", "", "b1,b2,f1,f2", "")
 }
+
+func TestParseStatsFuncAllNames(t *testing.T) {
+	for _, sp := range allStatsFuncParsers() {
+		name := sp.name
+		if !isStatsFuncName(name) {
+			t.Fatalf("expecting %q to be a stats func name", name)
+		}
+
+		// stats funcs can be used as pipes without the 'stats' keyword
+		if !isPipeName(strings.ToUpper(name)) {
+			t.Fatalf("expecting %q to be a pipe name", strings.ToUpper(name))
+		}
+
+		// Verify that parseStatsFunc passes the name to the registered parser.
+		// The parser may fail because of missing args, but it mustn't fail with unknown func error.
+		lex := newLexer(name)
+		_, err := parseStatsFunc(lex)
+		if err != nil && strings.Contains(err.Error(), "unknown stats func") {
+			t.Fatalf("unexpected error when parsing stats func %q: %s", name, err)
+		}
+	}
+
+	// quoted names aren't stats funcs
+	lex := newLexer(`"count"()`)
+	if _, err := parseStatsFunc(lex); err == nil {
+		t.Fatalf("expecting non-nil error when parsing quoted stats func name")
+	}
+}
+
+func TestPipeStatsManyGroups(t *testing.T) {
+	// Verify that the groups are properly merged across shards
+	var rows [][]Field
+	for i := 0; i < 1000; i++ {
+		rows = append(rows, []Field{
+			{"host", fmt.Sprintf("host-%d", i%37)},
+			{"duration", fmt.Sprintf("%d", i%10)},
+		})
+	}
+
+	var rowsExpected [][]Field
+	for i := 0; i < 37; i++ {
+		n := 1000 / 37
+		if i < 1000%37 {
+			n++
+		}
+		sum := 0
+		for j := i; j < 1000; j += 37 {
+			sum += j % 10
+		}
+		rowsExpected = append(rowsExpected, []Field{
+			{"host", fmt.Sprintf("host-%d", i)},
+			{"rows", fmt.Sprintf("%d", n)},
+			{"duration_sum", fmt.Sprintf("%d", sum)},
+		})
+	}
+
+	expectPipeResults(t, "stats by (host) count() rows, sum(duration) duration_sum", rows, rowsExpected)
+}
+
+func TestPipeStatsFlushMultiRowBlocks(t *testing.T) {
+	f := func(workersCount int) {
+		t.Helper()
+
+		const groupsCount = 10_000
+
+		pipeStr := "stats by (user) count() rows"
+		lex := newLexer(pipeStr)
+		p, err := parsePipe(lex)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+		}
+
+		ppTest := &testBlocksCounterPipeProcessor{}
+		pp := p.newPipeProcessor(workersCount, make(chan struct{}), func() {}, ppTest, newMemoryBudget(0))
+
+		brw := newTestBlockResultWriter(workersCount, pp)
+		for i := 0; i < groupsCount; i++ {
+			brw.writeRow([]Field{
+				{"user", fmt.Sprintf("user-%d", i)},
+			})
+		}
+		brw.flush()
+		if err := pp.flush(); err != nil {
+			t.Fatalf("unexpected error when flushing %q: %s", pipeStr, err)
+		}
+
+		if n := ppTest.rowsCount.Load(); n != groupsCount {
+			t.Fatalf("unexpected number of rows; got %d; want %d", n, groupsCount)
+		}
+
+		// Groups must be written in multi-row blocks instead of a block per group.
+		if n := ppTest.blocksCount.Load(); n > int64(workersCount) {
+			t.Fatalf("too many blocks written for %d groups; got %d; want up to %d", groupsCount, n, workersCount)
+		}
+	}
+
+	f(1)
+	f(5)
+}
+
+// testBlocksCounterPipeProcessor counts the number of blocks and rows written to it.
+type testBlocksCounterPipeProcessor struct {
+	blocksCount atomic.Int64
+	rowsCount   atomic.Int64
+}
+
+func (pp *testBlocksCounterPipeProcessor) writeBlock(_ uint, br *blockResult) {
+	if len(br.timestamps) == 0 {
+		return
+	}
+	pp.blocksCount.Add(1)
+	pp.rowsCount.Add(int64(len(br.timestamps)))
+}
+
+func (pp *testBlocksCounterPipeProcessor) flush() error {
+	return nil
+}
+
+func TestPipeStatsBySingleTypedColumn(t *testing.T) {
+	f := func(pipeStr string, levels []string, valueTypeExpected valueType) {
+		t.Helper()
+
+		var rows [][]Field
+		for i := 0; i < 1000; i++ {
+			rows = append(rows, []Field{
+				{"level", levels[i%len(levels)]},
+				{"duration", fmt.Sprintf("%d", i%100)},
+			})
+		}
+
+		// Obtain the expected results for string column.
+		lex := newLexer(pipeStr)
+		p, err := parsePipe(lex)
+		if err != nil {
+			t.Fatalf("cannot parse %q: %s", pipeStr, err)
+		}
+		ppExpected := newTestPipeProcessor()
+		pp := p.newPipeProcessor(1, make(chan struct{}), func() {}, ppExpected, newMemoryBudget(0))
+		pp.writeBlock(0, newTestStatsBlockResult(rows))
+		if err := pp.flush(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		// Obtain the results for the typed column.
+		levelValues := make([]string, len(rows))
+		for i, row := range rows {
+			levelValues[i] = row[0].Value
+		}
+		c := newTestStatsByColumn("level", levelValues, valueTypeExpected == valueTypeDict)
+		if c.valueType != valueTypeExpected {
+			t.Fatalf("unexpected valueType for the level column; got %d; want %d", c.valueType, valueTypeExpected)
+		}
+
+		br := newTestStatsBlockResult(rows)
+		br.csBuf[0] = c
+		br.csInitialized = false
+
+		ppTest := newTestPipeProcessor()
+		pp = p.newPipeProcessor(1, make(chan struct{}), func() {}, ppTest, newMemoryBudget(0))
+		pp.writeBlock(0, br)
+		if err := pp.flush(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		ppTest.expectRows(t, ppExpected.resultRows)
+	}
+
+	// dict-encoded column
+	levels := []string{"info", "warn", "error", "debug", "fatal"}
+	f("stats by (level) count()", levels, valueTypeDict)
+	f("stats by (level) count(), sum(duration), max(duration), count_uniq(duration)", levels, valueTypeDict)
+	f("stats by (level) count() if (duration:>50) x, sum(duration) if (level:error) y, count() z", levels, valueTypeDict)
+	f("stats by (level:3) count()", []string{"1", "2", "3", "4", "5", "6", "7", "8"}, valueTypeDict)
+
+	// uint columns with small number of unique values
+	f("stats by (level) count(), sum(duration)", []string{"1", "12", "123", "42", "9"}, valueTypeUint8)
+	f("stats by (level) count() if (duration:<10), sum(duration)", []string{"200", "404", "500", "502", "1234"}, valueTypeUint16)
+	f("stats by (level) count(), count_uniq(duration)", []string{"1", "123456", "123"}, valueTypeUint32)
+	f("stats by (level) count()", []string{"1", "12345678901"}, valueTypeUint64)
+
+	// uint columns with big number of unique values
+	f("stats by (level) count(), sum(duration)", []string{"1", "12", "123", "42", "9", "10", "11", "13", "14", "15"}, valueTypeUint8)
+	f("stats by (level) count(), sum(duration)", []string{"1", "1234", "123", "42", "9", "10", "11", "13", "14", "15"}, valueTypeUint16)
+	f("stats by (level) count() if (duration:<10), sum(duration)", []string{"1", "123456", "123", "42", "9", "10", "11", "13", "14", "15"}, valueTypeUint32)
+	f("stats by (level) count()", []string{"1", "12345678901", "123", "42", "9", "10", "11", "13", "14", "15"}, valueTypeUint64)
+}
+
+// newTestStatsByColumn returns the column with the given name and values encoded in the same way as they are stored in the block.
+//
+// The values are encoded as dict if isDict is set. Otherwise they are encoded as uint values.
+func newTestStatsByColumn(name string, values []string, isDict bool) blockResultColumn {
+	if isDict {
+		var dict valuesDict
+		_, valuesEncoded, vt := tryDictEncoding(nil, nil, values, &dict)
+		return blockResultColumn{
+			name:          name,
+			valueType:     vt,
+			dictValues:    dict.values,
+			valuesEncoded: valuesEncoded,
+		}
+	}
+
+	_, valuesEncoded, vt, minValue, maxValue := tryUintEncoding(nil, nil, values)
+	return blockResultColumn{
+		name:          name,
+		valueType:     vt,
+		minValue:      minValue,
+		maxValue:      maxValue,
+		valuesEncoded: valuesEncoded,
+	}
+}
+
+func TestPipeStatsSkewedShards(t *testing.T) {
+	// Verify that the groups are properly merged when the majority of the state is located at a single shard
+	const workersCount = 4
+	const groupsCount = 50_000
+
+	pipeStr := "stats by (user) count() rows"
+	lex := newLexer(pipeStr)
+	p, err := parsePipe(lex)
+	if err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+	}
+
+	ppTest := newTestPipeProcessor()
+	pp := p.newPipeProcessor(workersCount, make(chan struct{}), func() {}, ppTest, newMemoryBudget(0))
+
+	// Write all the groups to the first shard.
+	brw := newTestBlockResultWriter(1, pp)
+	for i := 0; i < groupsCount; i++ {
+		brw.writeRow([]Field{
+			{"user", fmt.Sprintf("user-%d", i)},
+		})
+	}
+	brw.flush()
+
+	// Write a few groups to all the shards.
+	brw = newTestBlockResultWriter(workersCount, pp)
+	for i := 0; i < 100; i++ {
+		brw.writeRow([]Field{
+			{"user", fmt.Sprintf("user-%d", i)},
+		})
+	}
+	brw.flush()
+
+	if err := pp.flush(); err != nil {
+		t.Fatalf("unexpected error when flushing %q: %s", pipeStr, err)
+	}
+
+	if len(ppTest.resultRows) != groupsCount {
+		t.Fatalf("unexpected number of rows; got %d; want %d", len(ppTest.resultRows), groupsCount)
+	}
+	for _, row := range ppTest.resultRows {
+		var user, rows string
+		for _, f := range row {
+			switch f.Name {
+			case "user":
+				user = f.Value
+			case "rows":
+				rows = f.Value
+			}
+		}
+		var n int
+		if _, err := fmt.Sscanf(user, "user-%d", &n); err != nil {
+			t.Fatalf("cannot parse user from %q: %s", user, err)
+		}
+		rowsExpected := "1"
+		if n < 100 {
+			rowsExpected = "2"
+		}
+		if rows != rowsExpected {
+			t.Fatalf("unexpected rows for %q; got %q; want %q", user, rows, rowsExpected)
+		}
+	}
+}
+
+func TestGetPipeStatsMergeChunks(t *testing.T) {
+	f := func(groupsPerShard []int, chunksExpected int) {
+		t.Helper()
+
+		shards := make([]pipeStatsProcessorShard, len(groupsPerShard))
+		groupsTotal := 0
+		for i, n := range groupsPerShard {
+			m := &pipeStatsGroupsMap{}
+			for j := 0; j < n; j++ {
+				key := fmt.Sprintf("key-%d-%d", i, j)
+				m.add(xxhash.Sum64String(key), key)
+			}
+			shards[i].m = m
+			groupsTotal += n
+		}
+
+		chunks := getPipeStatsMergeChunks(shards)
+		if len(chunks) != chunksExpected {
+			t.Fatalf("unexpected number of chunks; got %d; want %d", len(chunks), chunksExpected)
+		}
+
+		// Every group must be located exactly at a single chunk
+		groupsSeen := make(map[*pipeStatsGroup]struct{})
+		for _, c := range chunks {
+			c.shard.m.forEachInSlots(c.start, c.end, func(_ uint64, _ string, psg *pipeStatsGroup) bool {
+				if _, ok := groupsSeen[psg]; ok {
+					t.Fatalf("the group is located at multiple chunks")
+				}
+				groupsSeen[psg] = struct{}{}
+				return true
+			})
+		}
+		if len(groupsSeen) != groupsTotal {
+			t.Fatalf("unexpected number of groups in chunks; got %d; want %d", len(groupsSeen), groupsTotal)
+		}
+	}
+
+	// empty shards
+	f([]int{0, 0}, 0)
+
+	// small shards
+	f([]int{10, 0, 5}, 2)
+
+	// a single big shard is split into multiple chunks
+	f([]int{100_000, 0, 0, 0}, 16)
+	f([]int{100_000, 10, 20, 30}, 19)
+}
@@ -0,0 +1,167 @@
+package logstorage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fastnum"
+)
+
+func BenchmarkPipeStatsBySingleField(b *testing.B) {
+	levelsByType := []struct {
+		name   string
+		levels []string
+	}{
+		{"dict", []string{"info", "warn", "error", "debug", "fatal"}},
+		{"uint8", []string{"1", "12", "123", "42", "9"}},
+		{"uint16", []string{"200", "404", "500", "502", "1234"}},
+		{"uint16-many-values", []string{"1", "1234", "123", "42", "9", "10", "11", "13", "14", "15"}},
+	}
+	for _, lt := range levelsByType {
+		for _, funcs := range []string{"count()", "count(), sum(duration), max(duration)", "count() if (duration:>500)"} {
+			b.Run(fmt.Sprintf("%s/%s", lt.name, funcs), func(b *testing.B) {
+				benchmarkPipeStatsBySingleField(b, "stats by (level) "+funcs, lt.levels, lt.name == "dict")
+			})
+		}
+	}
+}
+
+func benchmarkPipeStatsBySingleField(b *testing.B, pipeStr string, levels []string, isDict bool) {
+	lex := newLexer(pipeStr)
+	p, err := parsePipe(lex)
+	if err != nil {
+		b.Fatalf("cannot parse %q: %s", pipeStr, err)
+	}
+	ppNext := newDefaultPipeProcessor(func(_ uint, _ *blockResult) {})
+	pp := p.newPipeProcessor(1, make(chan struct{}), func() {}, ppNext, newMemoryBudget(0))
+
+	const rowsCount = 8192
+	levelValues := make([]string, rowsCount)
+	durationValues := make([]string, rowsCount)
+	for i := range levelValues {
+		levelValues[i] = levels[(i/3)%len(levels)]
+		durationValues[i] = fmt.Sprintf("%d", i%1000)
+	}
+	levelColumn := newTestStatsByColumn("level", levelValues, isDict)
+
+	var br blockResult
+	b.ReportAllocs()
+	b.SetBytes(rowsCount)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		br.reset()
+		br.timestamps = fastnum.AppendInt64Zeros(br.timestamps[:0], rowsCount)
+		br.csBuf = append(br.csBuf, levelColumn, blockResultColumn{
+			name:          "duration",
+			valueType:     valueTypeString,
+			valuesEncoded: durationValues,
+		})
+		pp.writeBlock(0, &br)
+	}
+}
+
+func BenchmarkPipeStatsManyGroups(b *testing.B) {
+	for _, groupsCount := range []int{1_000, 100_000, 1_000_000} {
+		b.Run(fmt.Sprintf("groups-%d", groupsCount), func(b *testing.B) {
+			benchmarkPipeStatsManyGroups(b, "stats by (user_id) count()", groupsCount)
+		})
+	}
+}
+
+func benchmarkPipeStatsManyGroups(b *testing.B, pipeStr string, groupsCount int) {
+	lex := newLexer(pipeStr)
+	p, err := parsePipe(lex)
+	if err != nil {
+		b.Fatalf("cannot parse %q: %s", pipeStr, err)
+	}
+
+	const rowsPerBlock = 8192
+	var blocksValues [][]string
+	for i := 0; i < groupsCount; i += rowsPerBlock {
+		n := min(rowsPerBlock, groupsCount-i)
+		values := make([]string, n)
+		for j := range values {
+			values[j] = fmt.Sprintf("user-%d", i+j)
+		}
+		blocksValues = append(blocksValues, values)
+	}
+
+	var br blockResult
+	b.ReportAllocs()
+	b.SetBytes(int64(groupsCount))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ppNext := newDefaultPipeProcessor(func(_ uint, _ *blockResult) {})
+		pp := p.newPipeProcessor(1, make(chan struct{}), func() {}, ppNext, newMemoryBudget(0))
+		for _, values := range blocksValues {
+			br.reset()
+			br.timestamps = fastnum.AppendInt64Zeros(br.timestamps[:0], len(values))
+			br.csBuf = append(br.csBuf, blockResultColumn{
+				name:          "user_id",
+				valueType:     valueTypeString,
+				valuesEncoded: values,
+			})
+			pp.writeBlock(0, &br)
+		}
+		if err := pp.flush(); err != nil {
+			b.Fatalf("unexpected error: %s", err)
+		}
+	}
+}
+
+func BenchmarkPipeStatsSkewedShards(b *testing.B) {
+	for _, skewed := range []bool{false, true} {
+		b.Run(fmt.Sprintf("skewed-%v", skewed), func(b *testing.B) {
+			benchmarkPipeStatsSkewedShards(b, skewed)
+		})
+	}
+}
+
+func benchmarkPipeStatsSkewedShards(b *testing.B, skewed bool) {
+	const workersCount = 8
+	const groupsCount = 1_000_000
+
+	pipeStr := "stats by (user_id) count()"
+	lex := newLexer(pipeStr)
+	p, err := parsePipe(lex)
+	if err != nil {
+		b.Fatalf("cannot parse %q: %s", pipeStr, err)
+	}
+
+	const rowsPerBlock = 8192
+	var blocksValues [][]string
+	for i := 0; i < groupsCount; i += rowsPerBlock {
+		n := min(rowsPerBlock, groupsCount-i)
+		values := make([]string, n)
+		for j := range values {
+			values[j] = fmt.Sprintf("user-%d", i+j)
+		}
+		blocksValues = append(blocksValues, values)
+	}
+
+	var br blockResult
+	b.ReportAllocs()
+	b.SetBytes(groupsCount)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ppNext := newDefaultPipeProcessor(func(_ uint, _ *blockResult) {})
+		pp := p.newPipeProcessor(workersCount, make(chan struct{}), func() {}, ppNext, newMemoryBudget(0))
+		for j, values := range blocksValues {
+			br.reset()
+			br.timestamps = fastnum.AppendInt64Zeros(br.timestamps[:0], len(values))
+			br.csBuf = append(br.csBuf, blockResultColumn{
+				name:          "user_id",
+				valueType:     valueTypeString,
+				valuesEncoded: values,
+			})
+			workerID := uint(j % workersCount)
+			if skewed {
+				workerID = 0
+			}
+			pp.writeBlock(workerID, &br)
+		}
+		if err := pp.flush(); err != nil {
+			b.Fatalf("unexpected error: %s", err)
+		}
+	}
+}
@@ -7,11 +7,9 @@ import (
 	"sort"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"unsafe"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
-	"github.com/VictoriaMetrics/VictoriaMetrics/lib/memory"
 )
 
 // pipeStreamContext processes '| stream_context ...' queries.
@@ -45,7 +43,7 @@ var neededFieldsForStreamContext = []string{
 	"_stream_id",
 }
 
-func (pc *pipeStreamContext) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pc *pipeStreamContext) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	neededFields.addFields(neededFieldsForStreamContext)
 	unneededFields.removeFields(neededFieldsForStreamContext)
 }
@@ -62,9 +60,8 @@ func (pc *pipeStreamContext) initFilterInValues(_ map[string][]string, _ getFiel
 	return pc, nil
 }
 
-func (pc *pipeStreamContext) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor) pipeProcessor {
-	maxStateSize := int64(float64(memory.Allowed()) * 0.2)
-
+func (pc *pipeStreamContext) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor, mb *memoryBudget) pipeProcessor {
+	pmb := mb.newPipeMemoryBudget(pc)
 	shards := make([]pipeStreamContextProcessorShard, workersCount)
 	for i := range shards {
 		shards[i] = pipeStreamContextProcessorShard{
@@ -73,7 +70,7 @@ func (pc *pipeStreamContext) newPipeProcessor(workersCount int, stopCh <-chan st
 				stateSizeBudget: stateSizeBudgetChunk,
 			},
 		}
-		maxStateSize -= stateSizeBudgetChunk
+		pmb.reserve(stateSizeBudgetChunk)
 	}
 
 	pcp := &pipeStreamContextProcessor{
@@ -84,9 +81,8 @@ func (pc *pipeStreamContext) newPipeProcessor(workersCount int, stopCh <-chan st
 
 		shards: shards,
 
-		maxStateSize: maxStateSize,
+		pmb: pmb,
 	}
-	pcp.stateSizeBudget.Store(maxStateSize)
 
 	return pcp
 }
@@ -101,8 +97,7 @@ type pipeStreamContextProcessor struct {
 
 	getStreamRows func(streamID string, stateSizeBudget int) ([]streamContextRow, error)
 
-	maxStateSize    int64
-	stateSizeBudget atomic.Int64
+	pmb *pipeMemoryBudget
 }
 
 func (pcp *pipeStreamContextProcessor) init(ctx context.Context, s *Storage, minTimestamp, maxTimestamp int64) {
@@ -161,7 +156,7 @@ func getStreamRows(ctx context.Context, s *Storage, streamID string, minTimestam
 		}
 	}
 
-	if err := s.runQuery(ctxWithCancel, []TenantID{tenantID}, q, writeBlock); err != nil {
+	if err := s.runQuery(ctxWithCancel, nil, []TenantID{tenantID}, q, writeBlock); err != nil {
 		return nil, err
 	}
 	if stateSize > stateSizeBudget {
@@ -263,13 +258,10 @@ func (pcp *pipeStreamContextProcessor) writeBlock(workerID uint, br *blockResult
 
 	for shard.stateSizeBudget < 0 {
 		// steal some budget for the state size from the global budget.
-		remaining := pcp.stateSizeBudget.Add(-stateSizeBudgetChunk)
-		if remaining < 0 {
+		if !pcp.pmb.borrow(stateSizeBudgetChunk) {
 			// The state size is too big. Stop processing data in order to avoid OOM crash.
-			if remaining+stateSizeBudgetChunk >= 0 {
-				// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
-				pcp.cancel()
-			}
+			// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
+			pcp.cancel()
 			return
 		}
 		shard.stateSizeBudget += stateSizeBudgetChunk
@@ -284,9 +276,14 @@ func (pcp *pipeStreamContextProcessor) flush() error {
 		return nil
 	}
 
-	n := pcp.stateSizeBudget.Load()
+	defer pcp.pmb.release()
+
+	if pcp.pmb.isExceeded() {
+		return pcp.pmb.limitError()
+	}
+	n := pcp.pmb.mb.remaining.Load()
 	if n <= 0 {
-		return fmt.Errorf("cannot calculate [%s], since it requires more than %dMB of memory", pcp.pc.String(), pcp.maxStateSize/(1<<20))
+		return pcp.pmb.limitError()
 	}
 	if n > math.MaxInt {
 		logger.Panicf("BUG: stateSizeBudget shouldn't exceed math.MaxInt=%v; got %d", math.MaxInt, n)
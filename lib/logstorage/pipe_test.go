@@ -0,0 +1,92 @@
+package logstorage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePipeAllNames(t *testing.T) {
+	for _, pp := range allPipeParsers() {
+		for _, name := range pp.names {
+			if !isPipeName(name) {
+				t.Fatalf("expecting %q to be a pipe name", name)
+			}
+			if !isPipeName(strings.ToUpper(name)) {
+				t.Fatalf("expecting %q to be a pipe name", strings.ToUpper(name))
+			}
+
+			// Verify that parsePipe passes the pipe name to the registered parser.
+			// The parser may fail because of missing args, but it mustn't fail with unknown pipe error.
+			lex := newLexer(name)
+			_, err := parsePipe(lex)
+			if err != nil && strings.Contains(err.Error(), "unexpected pipe") {
+				t.Fatalf("unexpected error when parsing pipe %q: %s", name, err)
+			}
+		}
+	}
+}
+
+func TestIsPipeName(t *testing.T) {
+	f := func(s string, resultExpected bool) {
+		t.Helper()
+		result := isPipeName(s)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for isPipeName(%q); got %v; want %v", s, result, resultExpected)
+		}
+	}
+
+	f("", false)
+	f("foo", false)
+	f("pack_logmft", false)
+
+	f("pack_logfmt", true)
+	f("cp", true)
+	f("Where", true)
+
+	// stats functions can be used as pipes without the 'stats' keyword
+	f("count", true)
+	f("uniq_values", true)
+}
+
+func TestPipeWriteBlockNoAllocs(t *testing.T) {
+	if isRaceEnabled {
+		t.Skip("skipping the test, since the race detector allocates memory")
+	}
+
+	f := func(pipeStr string) {
+		t.Helper()
+
+		rows := newTestPipeWriteBlockRows(1000)
+		writeBlock := newTestPipeWriteBlockFunc(t, pipeStr, rows)
+
+		// Warm up per-worker buffers
+		writeBlock()
+
+		n := testing.AllocsPerRun(10, writeBlock)
+		if n != 0 {
+			t.Fatalf("unexpected memory allocations per writeBlock call for %q; got %v; want 0", pipeStr, n)
+		}
+	}
+
+	// The pipes based on regexp package aren't verified here, since regexp allocates memory per each match.
+	f(`copy host as h`)
+	f(`delete host`)
+	f(`drop_empty_fields`)
+	f(`extract '"ip":"<ip>"'`)
+	f(`fields host`)
+	f(`filter host:="host-1"`)
+	f(`format "<host>:<_msg>" as x`)
+	f(`format if (host:="host-1") "<host>" as x`)
+	f(`limit 100000`)
+	f(`math len(_msg) as x`)
+	f(`offset 10`)
+	f(`pack_json`)
+	f(`pack_logfmt`)
+	f(`rename host as h`)
+	f(`replace ("foo", "bar") at _msg`)
+	f(`sample 0.5`)
+	f(`unpack_json`)
+	f(`unpack_json fields (ip, path)`)
+	f(`unpack_logfmt`)
+	f(`unroll (host)`)
+}
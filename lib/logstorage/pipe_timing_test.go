@@ -0,0 +1,46 @@
+package logstorage
+
+import (
+	"testing"
+)
+
+func BenchmarkPipeWriteBlock(b *testing.B) {
+	for _, pipeStr := range []string{
+		`copy host as h`,
+		`delete host`,
+		`drop_empty_fields`,
+		`extract '"ip":"<ip>"'`,
+		`extract_regexp '"ip":"(?P<ip>[^"]+)"'`,
+		`fields host`,
+		`filter host:="host-1"`,
+		`format "<host>:<_msg>" as x`,
+		`format if (host:="host-1") "<host>" as x`,
+		`math len(_msg) as x`,
+		`pack_json`,
+		`pack_logfmt`,
+		`rename host as h`,
+		`replace ("foo", "bar") at _msg`,
+		`replace_regexp ("fo+", "bar") at _msg`,
+		`sample 0.5`,
+		`unpack_json`,
+		`unpack_json fields (ip, path)`,
+		`unpack_logfmt`,
+		`unroll (host)`,
+	} {
+		b.Run(pipeStr, func(b *testing.B) {
+			benchmarkPipeWriteBlock(b, pipeStr)
+		})
+	}
+}
+
+func benchmarkPipeWriteBlock(b *testing.B, pipeStr string) {
+	rows := newTestPipeWriteBlockRows(1000)
+	writeBlock := newTestPipeWriteBlockFunc(b, pipeStr, rows)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(rows)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		writeBlock()
+	}
+}
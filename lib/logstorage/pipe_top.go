@@ -1,17 +1,16 @@
 package logstorage
 
 import (
+	"container/heap"
 	"fmt"
 	"slices"
 	"sort"
 	"strings"
-	"sync/atomic"
 	"unsafe"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
-	"github.com/VictoriaMetrics/VictoriaMetrics/lib/memory"
 )
 
 // pipeTopDefaultLimit is the default number of entries pipeTop returns.
@@ -49,7 +48,7 @@ func (pt *pipeTop) canLiveTail() bool {
 	return false
 }
 
-func (pt *pipeTop) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pt *pipeTop) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	neededFields.reset()
 	unneededFields.reset()
 
@@ -72,9 +71,8 @@ func (pt *pipeTop) initFilterInValues(_ map[string][]string, _ getFieldValuesFun
 	return pt, nil
 }
 
-func (pt *pipeTop) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor) pipeProcessor {
-	maxStateSize := int64(float64(memory.Allowed()) * 0.2)
-
+func (pt *pipeTop) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor, mb *memoryBudget) pipeProcessor {
+	pmb := mb.newPipeMemoryBudget(pt)
 	shards := make([]pipeTopProcessorShard, workersCount)
 	for i := range shards {
 		shards[i] = pipeTopProcessorShard{
@@ -83,7 +81,7 @@ func (pt *pipeTop) newPipeProcessor(workersCount int, stopCh <-chan struct{}, ca
 				stateSizeBudget: stateSizeBudgetChunk,
 			},
 		}
-		maxStateSize -= stateSizeBudgetChunk
+		pmb.reserve(stateSizeBudgetChunk)
 	}
 
 	ptp := &pipeTopProcessor{
@@ -94,9 +92,8 @@ func (pt *pipeTop) newPipeProcessor(workersCount int, stopCh <-chan struct{}, ca
 
 		shards: shards,
 
-		maxStateSize: maxStateSize,
+		pmb: pmb,
 	}
-	ptp.stateSizeBudget.Store(maxStateSize)
 
 	return ptp
 }
@@ -109,8 +106,7 @@ type pipeTopProcessor struct {
 
 	shards []pipeTopProcessorShard
 
-	maxStateSize    int64
-	stateSizeBudget atomic.Int64
+	pmb *pipeMemoryBudget
 }
 
 type pipeTopProcessorShard struct {
@@ -166,17 +162,7 @@ func (shard *pipeTopProcessorShard) writeBlock(br *blockResult) {
 			return
 		}
 		if c.valueType == valueTypeDict {
-			a := encoding.GetUint64s(len(c.dictValues))
-			hits := a.A
-			valuesEncoded := c.getValuesEncoded(br)
-			for _, v := range valuesEncoded {
-				idx := unmarshalUint8(v)
-				hits[idx]++
-			}
-			for i, v := range c.dictValues {
-				shard.updateState(v, hits[i])
-			}
-			encoding.PutUint64s(a)
+			c.forEachDictValueWithHits(br, shard.updateState)
 			return
 		}
 
@@ -236,13 +222,10 @@ func (ptp *pipeTopProcessor) writeBlock(workerID uint, br *blockResult) {
 
 	for shard.stateSizeBudget < 0 {
 		// steal some budget for the state size from the global budget.
-		remaining := ptp.stateSizeBudget.Add(-stateSizeBudgetChunk)
-		if remaining < 0 {
+		if !ptp.pmb.borrow(stateSizeBudgetChunk) {
 			// The state size is too big. Stop processing data in order to avoid OOM crash.
-			if remaining+stateSizeBudgetChunk >= 0 {
-				// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
-				ptp.cancel()
-			}
+			// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
+			ptp.cancel()
 			return
 		}
 		shard.stateSizeBudget += stateSizeBudgetChunk
@@ -252,8 +235,10 @@ func (ptp *pipeTopProcessor) writeBlock(workerID uint, br *blockResult) {
 }
 
 func (ptp *pipeTopProcessor) flush() error {
-	if n := ptp.stateSizeBudget.Load(); n <= 0 {
-		return fmt.Errorf("cannot calculate [%s], since it requires more than %dMB of memory", ptp.pt.String(), ptp.maxStateSize/(1<<20))
+	defer ptp.pmb.release()
+
+	if ptp.pmb.isExceeded() {
+		return ptp.pmb.limitError()
 	}
 
 	// merge state across shards
@@ -275,24 +260,30 @@ func (ptp *pipeTopProcessor) flush() error {
 		}
 	}
 
-	// select top entries with the biggest number of hits
-	entries := make([]pipeTopEntry, 0, len(m))
+	// select top entries with the biggest number of hits.
+	//
+	// Use a heap with up to limit entries instead of sorting all the entries in m,
+	// since the number of entries in m may be much bigger than the limit.
+	limit := ptp.pt.limit
+	var eh pipeTopEntriesHeap
 	for k, pHits := range m {
-		entries = append(entries, pipeTopEntry{
+		e := pipeTopEntry{
 			k:    k,
 			hits: *pHits,
-		})
+		}
+		if uint64(len(eh)) < limit {
+			heap.Push(&eh, e)
+			continue
+		}
+		if eh[0].less(&e) {
+			eh[0] = e
+			heap.Fix(&eh, 0)
+		}
 	}
+	entries := []pipeTopEntry(eh)
 	sort.Slice(entries, func(i, j int) bool {
-		a, b := &entries[i], &entries[j]
-		if a.hits == b.hits {
-			return a.k < b.k
-		}
-		return a.hits > b.hits
+		return entries[j].less(&entries[i])
 	})
-	if uint64(len(entries)) > ptp.pt.limit {
-		entries = entries[:ptp.pt.limit]
-	}
 
 	// write result
 	wctx := &pipeTopWriteContext{
@@ -390,6 +381,46 @@ type pipeTopEntry struct {
 	hits uint64
 }
 
+// less returns true if e must be put after b in the results of the top pipe.
+func (e *pipeTopEntry) less(b *pipeTopEntry) bool {
+	if e.hits == b.hits {
+		return e.k > b.k
+	}
+	return e.hits < b.hits
+}
+
+// pipeTopEntriesHeap is a min-heap of pipeTopEntry items.
+//
+// The top of the heap contains the entry, which must be put at the end of the top pipe results.
+type pipeTopEntriesHeap []pipeTopEntry
+
+func (h *pipeTopEntriesHeap) Len() int {
+	return len(*h)
+}
+
+func (h *pipeTopEntriesHeap) Swap(i, j int) {
+	a := *h
+	a[i], a[j] = a[j], a[i]
+}
+
+func (h *pipeTopEntriesHeap) Less(i, j int) bool {
+	a := *h
+	return a[i].less(&a[j])
+}
+
+func (h *pipeTopEntriesHeap) Push(x any) {
+	e := x.(pipeTopEntry)
+	*h = append(*h, e)
+}
+
+func (h *pipeTopEntriesHeap) Pop() any {
+	a := *h
+	x := a[len(a)-1]
+	a[len(a)-1] = pipeTopEntry{}
+	*h = a[:len(a)-1]
+	return x
+}
+
 type pipeTopWriteContext struct {
 	ptp *pipeTopProcessor
 	rcs []resultColumn
@@ -1,6 +1,7 @@
 package logstorage
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -282,6 +283,49 @@ func TestPipeTop(t *testing.T) {
 	})
 }
 
+func TestPipeTopLimit(t *testing.T) {
+	f := func(pipeStr string, rows []string, resultExpected []string) {
+		t.Helper()
+
+		lex := newLexer(pipeStr)
+		p, err := parsePipe(lex)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+		}
+
+		workersCount := 3
+		ppTest := newTestPipeProcessor()
+		pp := p.newPipeProcessor(workersCount, make(chan struct{}), func() {}, ppTest, newMemoryBudget(0))
+
+		brw := newTestBlockResultWriter(workersCount, pp)
+		for _, v := range rows {
+			brw.writeRow([]Field{
+				{"x", v},
+			})
+		}
+		brw.flush()
+		if err := pp.flush(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		// Verify the order of the returned rows
+		var result []string
+		for _, row := range ppTest.resultRows {
+			result = append(result, row[0].Value+":"+row[1].Value)
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result\ngot\n%q\nwant\n%q", result, resultExpected)
+		}
+	}
+
+	rows := []string{"e", "c", "a", "d", "a", "b", "c", "a", "d", "b", "a", "c", "b", "d", "a"}
+
+	f("top 1 by (x)", rows, []string{"a:5"})
+	f("top 3 by (x)", rows, []string{"a:5", "b:3", "c:3"})
+	f("top 4 by (x)", rows, []string{"a:5", "b:3", "c:3", "d:3"})
+	f("top by (x)", rows, []string{"a:5", "b:3", "c:3", "d:3", "e:1"})
+}
+
 func TestPipeTopUpdateNeededFields(t *testing.T) {
 	f := func(s, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected string) {
 		t.Helper()
@@ -0,0 +1,51 @@
+package logstorage
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// pipeTracerProcessor counts blocks and rows passed to pp.
+//
+// It is used for collecting per-pipe stats for query tracing and for pipe metrics.
+type pipeTracerProcessor struct {
+	pp pipeProcessor
+
+	// measureDuration enables measuring the time spent in pp.writeBlock calls.
+	measureDuration bool
+
+	blocksCount atomic.Uint64
+	rowsCount   atomic.Uint64
+
+	// writeBlockDuration is the time spent in pp.writeBlock calls summed across workers.
+	//
+	// It includes the time spent in the next pipes when pp passes the block to them.
+	// It is updated only if measureDuration is set.
+	writeBlockDuration atomic.Int64
+}
+
+func newPipeTracerProcessor(pp pipeProcessor, measureDuration bool) *pipeTracerProcessor {
+	return &pipeTracerProcessor{
+		pp:              pp,
+		measureDuration: measureDuration,
+	}
+}
+
+func (ptp *pipeTracerProcessor) writeBlock(workerID uint, br *blockResult) {
+	if len(br.timestamps) > 0 {
+		ptp.blocksCount.Add(1)
+		ptp.rowsCount.Add(uint64(len(br.timestamps)))
+	}
+	if !ptp.measureDuration {
+		ptp.pp.writeBlock(workerID, br)
+		return
+	}
+
+	startTime := time.Now()
+	ptp.pp.writeBlock(workerID, br)
+	ptp.writeBlockDuration.Add(int64(time.Since(startTime)))
+}
+
+func (ptp *pipeTracerProcessor) flush() error {
+	return ptp.pp.flush()
+}
@@ -0,0 +1,116 @@
+package logstorage
+
+import (
+	"context"
+	"fmt"
+)
+
+// pipeUnion processes '| union ...' pipe.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#union-pipe
+type pipeUnion struct {
+	// q is the query, which results must be appended to the input logs.
+	q *Query
+}
+
+func (pu *pipeUnion) String() string {
+	return fmt.Sprintf("union (%s)", pu.q.String())
+}
+
+func (pu *pipeUnion) canLiveTail() bool {
+	return false
+}
+
+func (pu *pipeUnion) updateNeededFields(_, _ *fieldsSet) {
+	// nothing to do - the input logs are passed as is to the next pipe.
+}
+
+func (pu *pipeUnion) optimize() {
+	pu.q.Optimize()
+}
+
+func (pu *pipeUnion) hasFilterInWithQuery() bool {
+	// 'in(subquery)' filters at pu.q are initialized when pu.q is executed.
+	return false
+}
+
+func (pu *pipeUnion) initFilterInValues(_ map[string][]string, _ getFieldValuesFunc) (pipe, error) {
+	return pu, nil
+}
+
+func (pu *pipeUnion) newPipeProcessor(_ int, stopCh <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
+	return &pipeUnionProcessor{
+		pu:     pu,
+		stopCh: stopCh,
+		ppNext: ppNext,
+	}
+}
+
+type pipeUnionProcessor struct {
+	pu     *pipeUnion
+	stopCh <-chan struct{}
+	ppNext pipeProcessor
+
+	// runUnionQuery must execute pu.q and pass its results to writeBlock.
+	runUnionQuery func(writeBlock func(workerID uint, br *blockResult)) error
+}
+
+func (pup *pipeUnionProcessor) init(ctx context.Context, s *Storage, tenantIDs []TenantID) {
+	pup.runUnionQuery = func(writeBlock func(workerID uint, br *blockResult)) error {
+		q, err := s.initFilterInValues(ctx, tenantIDs, pup.pu.q)
+		if err != nil {
+			return err
+		}
+		return s.runQuery(ctx, nil, tenantIDs, q, writeBlock)
+	}
+}
+
+func (pup *pipeUnionProcessor) writeBlock(workerID uint, br *blockResult) {
+	if len(br.timestamps) == 0 {
+		return
+	}
+	pup.ppNext.writeBlock(workerID, br)
+}
+
+func (pup *pipeUnionProcessor) flush() error {
+	if needStop(pup.stopCh) {
+		return nil
+	}
+
+	if pup.runUnionQuery == nil {
+		// The pipe processor wasn't initialized with the storage, e.g. when the pipe is executed outside the storage search.
+		return fmt.Errorf("cannot execute query at [%s]: the storage for executing the query isn't set", pup.pu)
+	}
+
+	// The union query is executed after all the input logs are passed to the next pipe,
+	// since the next pipe cannot be called concurrently from the same worker.
+	if err := pup.runUnionQuery(pup.ppNext.writeBlock); err != nil {
+		return fmt.Errorf("cannot execute query at [%s]: %w", pup.pu, err)
+	}
+	return nil
+}
+
+func parsePipeUnion(lex *lexer) (*pipeUnion, error) {
+	if !lex.isKeyword("union") {
+		return nil, fmt.Errorf("unexpected token: %q; want %q", lex.token, "union")
+	}
+	lex.nextToken()
+
+	if !lex.isKeyword("(") {
+		return nil, fmt.Errorf("missing '(' in front of the query at 'union' pipe")
+	}
+	lex.nextToken()
+	q, err := parseQuery(lex)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse query at 'union': %w", err)
+	}
+	if !lex.isKeyword(")") {
+		return nil, fmt.Errorf("missing ')' after 'union(%s)'", q)
+	}
+	lex.nextToken()
+
+	pu := &pipeUnion{
+		q: q,
+	}
+	return pu, nil
+}
@@ -0,0 +1,152 @@
+package logstorage
+
+import (
+	"testing"
+)
+
+func TestParsePipeUnionSuccess(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeSuccess(t, pipeStr)
+	}
+
+	f(`union (error)`)
+	f(`union (error | fields foo, bar)`)
+	f(`union (_time:1h error | stats by (foo) count(*) as errors)`)
+	f(`union (x:in(y | fields x))`)
+	f(`union (foo | union (bar))`)
+}
+
+func TestParsePipeUnionFailure(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeFailure(t, pipeStr)
+	}
+
+	f(`union`)
+	f(`union ()`)
+	f(`union (`)
+	f(`union (error`)
+	f(`union error`)
+	f(`union (error) foo`)
+}
+
+func TestPipeUnion(t *testing.T) {
+	f := func(pipeStr string, unionRows, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeUnionResults(t, pipeStr, unionRows, rows, rowsExpected)
+	}
+
+	f(`union (*)`, [][]Field{
+		{
+			{"_msg", "warn"},
+			{"host", "h2"},
+		},
+		{
+			{"_msg", "fatal"},
+		},
+	}, [][]Field{
+		{
+			{"_msg", "error"},
+			{"host", "h1"},
+		},
+	}, [][]Field{
+		{
+			{"_msg", "error"},
+			{"host", "h1"},
+		},
+		{
+			{"_msg", "warn"},
+			{"host", "h2"},
+		},
+		{
+			{"_msg", "fatal"},
+		},
+	})
+
+	// empty union results
+	f(`union (*)`, nil, [][]Field{
+		{
+			{"_msg", "error"},
+		},
+	}, [][]Field{
+		{
+			{"_msg", "error"},
+		},
+	})
+
+	// empty input
+	f(`union (*)`, [][]Field{
+		{
+			{"_msg", "warn"},
+		},
+	}, nil, [][]Field{
+		{
+			{"_msg", "warn"},
+		},
+	})
+}
+
+func TestPipeUnionUpdateNeededFields(t *testing.T) {
+	f := func(s, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected string) {
+		t.Helper()
+		expectPipeNeededFields(t, s, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected)
+	}
+
+	// all the needed fields
+	f("union (foo)", "*", "", "*", "")
+
+	// all the needed fields, unneeded fields
+	f("union (foo)", "*", "f1,f2", "*", "f1,f2")
+
+	// needed fields
+	f("union (foo)", "f1,f2", "", "f1,f2", "")
+}
+
+func TestPipeUnionFlushWithoutStorage(t *testing.T) {
+	pipeStr := "union (foo)"
+	lex := newLexer(pipeStr)
+	p, err := parsePipe(lex)
+	if err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+	}
+
+	pp := p.newPipeProcessor(1, make(chan struct{}), func() {}, newTestPipeProcessor(), newMemoryBudget(0))
+	if err := pp.flush(); err == nil {
+		t.Fatalf("expecting non-nil error when flushing %q without the storage", pipeStr)
+	}
+}
+
+func expectPipeUnionResults(t *testing.T, pipeStr string, unionRows, rows, rowsExpected [][]Field) {
+	t.Helper()
+
+	lex := newLexer(pipeStr)
+	p, err := parsePipe(lex)
+	if err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+	}
+	p.optimize()
+
+	workersCount := 5
+	ppTest := newTestPipeProcessor()
+	pp := p.newPipeProcessor(workersCount, make(chan struct{}), func() {}, ppTest, newMemoryBudget(0))
+	pp.(*pipeUnionProcessor).runUnionQuery = func(writeBlock func(workerID uint, br *blockResult)) error {
+		brw := newTestBlockResultWriter(workersCount, newDefaultPipeProcessor(writeBlock))
+		for _, row := range unionRows {
+			brw.writeRow(row)
+		}
+		brw.flush()
+		return nil
+	}
+
+	brw := newTestBlockResultWriter(workersCount, pp)
+	for _, row := range rows {
+		brw.writeRow(row)
+	}
+	brw.flush()
+	if err := pp.flush(); err != nil {
+		t.Fatalf("unexpected error when flushing %q: %s", pipeStr, err)
+	}
+
+	ppTest.expectRows(t, rowsExpected)
+}
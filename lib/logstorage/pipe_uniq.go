@@ -4,13 +4,12 @@ import (
 	"fmt"
 	"slices"
 	"strings"
-	"sync/atomic"
+	"sync"
 	"unsafe"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
-	"github.com/VictoriaMetrics/VictoriaMetrics/lib/memory"
 )
 
 // pipeUniq processes '| uniq ...' queries.
@@ -44,7 +43,7 @@ func (pu *pipeUniq) canLiveTail() bool {
 	return false
 }
 
-func (pu *pipeUniq) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pu *pipeUniq) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	neededFields.reset()
 	unneededFields.reset()
 
@@ -67,9 +66,8 @@ func (pu *pipeUniq) initFilterInValues(_ map[string][]string, _ getFieldValuesFu
 	return pu, nil
 }
 
-func (pu *pipeUniq) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor) pipeProcessor {
-	maxStateSize := int64(float64(memory.Allowed()) * 0.2)
-
+func (pu *pipeUniq) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor, mb *memoryBudget) pipeProcessor {
+	pmb := mb.newPipeMemoryBudget(pu)
 	shards := make([]pipeUniqProcessorShard, workersCount)
 	for i := range shards {
 		shards[i] = pipeUniqProcessorShard{
@@ -78,7 +76,7 @@ func (pu *pipeUniq) newPipeProcessor(workersCount int, stopCh <-chan struct{}, c
 				stateSizeBudget: stateSizeBudgetChunk,
 			},
 		}
-		maxStateSize -= stateSizeBudgetChunk
+		pmb.reserve(stateSizeBudgetChunk)
 	}
 
 	pup := &pipeUniqProcessor{
@@ -89,9 +87,9 @@ func (pu *pipeUniq) newPipeProcessor(workersCount int, stopCh <-chan struct{}, c
 
 		shards: shards,
 
-		maxStateSize: maxStateSize,
+		pmb: pmb,
 	}
-	pup.stateSizeBudget.Store(maxStateSize)
+	pup.indexShard.pu = pu
 
 	return pup
 }
@@ -104,8 +102,11 @@ type pipeUniqProcessor struct {
 
 	shards []pipeUniqProcessorShard
 
-	maxStateSize    int64
-	stateSizeBudget atomic.Int64
+	pmb *pipeMemoryBudget
+
+	// indexShard holds the state obtained from the fields index of the parts. It is protected by indexShardLock.
+	indexShardLock sync.Mutex
+	indexShard     pipeUniqProcessorShard
 }
 
 type pipeUniqProcessorShard struct {
@@ -137,7 +138,7 @@ type pipeUniqProcessorShardNopad struct {
 //
 // It returns false if the block cannot be written because of the exceeded limit.
 func (shard *pipeUniqProcessorShard) writeBlock(br *blockResult) bool {
-	if limit := shard.pu.limit; limit > 0 && uint64(len(shard.m)) >= limit {
+	if shard.isLimitReached() {
 		return false
 	}
 
@@ -155,6 +156,10 @@ func (shard *pipeUniqProcessorShard) writeBlock(br *blockResult) bool {
 				keyBuf = encoding.MarshalBytes(keyBuf, bytesutil.ToUnsafeBytes(v))
 			}
 			shard.updateState(bytesutil.ToUnsafeString(keyBuf), 1)
+			if shard.isLimitReached() {
+				shard.keyBuf = keyBuf
+				return false
+			}
 		}
 		shard.keyBuf = keyBuf
 		return true
@@ -169,29 +174,22 @@ func (shard *pipeUniqProcessorShard) writeBlock(br *blockResult) bool {
 		}
 		if c.valueType == valueTypeDict {
 			if needHits {
-				a := encoding.GetUint64s(len(c.dictValues))
-				hits := a.A
-				valuesEncoded := c.getValuesEncoded(br)
-				for _, v := range valuesEncoded {
-					idx := unmarshalUint8(v)
-					hits[idx]++
-				}
-				for i, v := range c.dictValues {
-					shard.updateState(v, hits[i])
-				}
-				encoding.PutUint64s(a)
+				c.forEachDictValueWithHits(br, shard.updateState)
 			} else {
-				for _, v := range c.dictValues {
+				c.forEachDictValue(br, func(v string) {
 					shard.updateState(v, 0)
-				}
+				})
 			}
-			return true
+			return !shard.isLimitReached()
 		}
 
 		values := c.getValues(br)
 		for i, v := range values {
 			if needHits || i == 0 || values[i-1] != values[i] {
 				shard.updateState(v, 1)
+				if shard.isLimitReached() {
+					return false
+				}
 			}
 		}
 		return true
@@ -224,12 +222,22 @@ func (shard *pipeUniqProcessorShard) writeBlock(br *blockResult) bool {
 			keyBuf = encoding.MarshalBytes(keyBuf, bytesutil.ToUnsafeBytes(values[i]))
 		}
 		shard.updateState(bytesutil.ToUnsafeString(keyBuf), 1)
+		if shard.isLimitReached() {
+			shard.keyBuf = keyBuf
+			return false
+		}
 	}
 	shard.keyBuf = keyBuf
 
 	return true
 }
 
+// isLimitReached returns true if the shard already contains the maximum number of unique entries allowed by the limit.
+func (shard *pipeUniqProcessorShard) isLimitReached() bool {
+	limit := shard.pu.limit
+	return limit > 0 && uint64(len(shard.m)) >= limit
+}
+
 func (shard *pipeUniqProcessorShard) updateState(v string, hits uint64) {
 	m := shard.getM()
 	pHits, ok := m[v]
@@ -259,13 +267,10 @@ func (pup *pipeUniqProcessor) writeBlock(workerID uint, br *blockResult) {
 
 	for shard.stateSizeBudget < 0 {
 		// steal some budget for the state size from the global budget.
-		remaining := pup.stateSizeBudget.Add(-stateSizeBudgetChunk)
-		if remaining < 0 {
+		if !pup.pmb.borrow(stateSizeBudgetChunk) {
 			// The state size is too big. Stop processing data in order to avoid OOM crash.
-			if remaining+stateSizeBudgetChunk >= 0 {
-				// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
-				pup.cancel()
-			}
+			// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
+			pup.cancel()
 			return
 		}
 		shard.stateSizeBudget += stateSizeBudgetChunk
@@ -276,9 +281,102 @@ func (pup *pipeUniqProcessor) writeBlock(workerID uint, br *blockResult) {
 	}
 }
 
+// processPartFieldsIndex implements partFieldsIndexProcessor interface.
+func (pup *pipeUniqProcessor) processPartFieldsIndex(p *part, pfts []*partFieldsIndexTenant) bool {
+	byFields := pup.pu.byFields
+	if len(byFields) != 1 {
+		return false
+	}
+	fieldName := byFields[0]
+	switch fieldName {
+	case "_time":
+		// The fields index doesn't contain timestamps.
+		return false
+	case "_stream", "_stream_id":
+		// The fields index contains all the streams.
+	default:
+		for _, pft := range pfts {
+			if f := pft.getField(fieldName); f != nil && !f.hasAllValues {
+				return false
+			}
+		}
+	}
+
+	pup.indexShardLock.Lock()
+	defer pup.indexShardLock.Unlock()
+
+	shard := &pup.indexShard
+	if shard.isLimitReached() {
+		return true
+	}
+
+	bb := bbPool.Get()
+	for _, pft := range pfts {
+		switch fieldName {
+		case "_stream_id":
+			for i := range pft.streams {
+				pfs := &pft.streams[i]
+				sid := streamID{
+					tenantID: pft.tenantID,
+					id:       pfs.id,
+				}
+				bb.B = sid.marshalString(bb.B[:0])
+				shard.updateState(bytesutil.ToUnsafeString(bb.B), pfs.rowsCount)
+			}
+		case "_stream":
+			for i := range pft.streams {
+				pfs := &pft.streams[i]
+				sid := streamID{
+					tenantID: pft.tenantID,
+					id:       pfs.id,
+				}
+				bb.B = p.pt.appendStreamTagsByStreamID(bb.B[:0], &sid)
+				if len(bb.B) == 0 {
+					// Skip the stream with missing stream tags in the same way as the search does.
+					continue
+				}
+				st := GetStreamTags()
+				mustUnmarshalStreamTags(st, bb.B)
+				bb.B = st.marshalString(bb.B[:0])
+				PutStreamTags(st)
+				shard.updateState(bytesutil.ToUnsafeString(bb.B), pfs.rowsCount)
+			}
+		default:
+			rowsWithValues := uint64(0)
+			if f := pft.getField(fieldName); f != nil {
+				for _, v := range f.values {
+					shard.updateState(v.value, v.rowsCount)
+					rowsWithValues += v.rowsCount
+				}
+			}
+			if n := pft.rowsCount - rowsWithValues; n > 0 {
+				// The remaining logs have no the given field, e.g. they have an empty value for it.
+				shard.updateState("", n)
+			}
+		}
+	}
+	bbPool.Put(bb)
+
+	for shard.stateSizeBudget < 0 {
+		// steal some budget for the state size from the global budget.
+		if !pup.pmb.borrow(stateSizeBudgetChunk) {
+			// The state size is too big. Stop processing data in order to avoid OOM crash.
+			pup.cancel()
+			return true
+		}
+		shard.stateSizeBudget += stateSizeBudgetChunk
+	}
+	if shard.isLimitReached() {
+		pup.cancel()
+	}
+	return true
+}
+
 func (pup *pipeUniqProcessor) flush() error {
-	if n := pup.stateSizeBudget.Load(); n <= 0 {
-		return fmt.Errorf("cannot calculate [%s], since it requires more than %dMB of memory", pup.pu.String(), pup.maxStateSize/(1<<20))
+	defer pup.pmb.release()
+
+	if pup.pmb.isExceeded() {
+		return pup.pmb.limitError()
 	}
 
 	// merge state across shards
@@ -299,6 +397,14 @@ func (pup *pipeUniqProcessor) flush() error {
 			}
 		}
 	}
+	for k, pHitsSrc := range pup.indexShard.getM() {
+		pHits, ok := m[k]
+		if !ok {
+			m[k] = pHitsSrc
+		} else {
+			*pHits += *pHitsSrc
+		}
+	}
 
 	// There is little sense in returning partial hits when the limit on the number of unique entries is reached.
 	// It is better from UX experience is to return zero hits instead.
@@ -1,6 +1,7 @@
 package logstorage
 
 import (
+	"fmt"
 	"testing"
 )
 
@@ -355,6 +356,59 @@ func TestPipeUniq(t *testing.T) {
 	})
 }
 
+func TestPipeUniqLimitStopsEarly(t *testing.T) {
+	f := func(pipeStr string, rows [][]Field, uniqEntriesExpected int) {
+		t.Helper()
+
+		lex := newLexer(pipeStr)
+		p, err := parsePipe(lex)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+		}
+
+		cancelCalls := 0
+		cancel := func() {
+			cancelCalls++
+		}
+		pp := p.newPipeProcessor(1, make(chan struct{}), cancel, newTestPipeProcessor(), newMemoryBudget(0))
+		pup := pp.(*pipeUniqProcessor)
+
+		// Write all the rows in a single block
+		var rcs []resultColumn
+		for _, f := range rows[0] {
+			rcs = appendResultColumnWithName(rcs, f.Name)
+		}
+		for _, row := range rows {
+			for i, f := range row {
+				rcs[i].addValue(f.Value)
+			}
+		}
+		var br blockResult
+		br.setResultColumns(rcs, len(rows))
+		pup.writeBlock(0, &br)
+
+		if n := len(pup.shards[0].m); n != uniqEntriesExpected {
+			t.Fatalf("unexpected number of unique entries; got %d; want %d", n, uniqEntriesExpected)
+		}
+		if cancelCalls != 1 {
+			t.Fatalf("unexpected number of cancel() calls; got %d; want 1", cancelCalls)
+		}
+	}
+
+	var rows [][]Field
+	for i := 0; i < 100; i++ {
+		rows = append(rows, []Field{
+			{"a", fmt.Sprintf("a_%d", i)},
+			{"b", fmt.Sprintf("b_%d", i)},
+		})
+	}
+
+	f("uniq limit 3", rows, 3)
+	f("uniq by (a) limit 3", rows, 3)
+	f("uniq by (a, b) limit 3", rows, 3)
+	f("uniq by (a) with hits limit 5", rows, 5)
+}
+
 func TestPipeUniqUpdateNeededFields(t *testing.T) {
 	f := func(s, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected string) {
 		t.Helper()
@@ -6,7 +6,16 @@ import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 )
 
-func updateNeededFieldsForUnpackPipe(fromField string, outFields []string, keepOriginalFields, skipEmptyResults bool, iff *ifFilter, neededFields, unneededFields fieldsSet) {
+func updateNeededFieldsForUnpackPipe(fromField, resultPrefix string, outFields []string, keepOriginalFields, skipEmptyResults bool, iff *ifFilter, neededFields, unneededFields *fieldsSet) {
+	if resultPrefix != "" && len(outFields) > 0 {
+		// The unpacked fields are written to the output with the resultPrefix.
+		outFieldsWithPrefix := make([]string, len(outFields))
+		for i, f := range outFields {
+			outFieldsWithPrefix[i] = resultPrefix + f
+		}
+		outFields = outFieldsWithPrefix
+	}
+
 	if neededFields.isEmpty() {
 		if iff != nil {
 			neededFields.addFields(iff.neededFields)
@@ -56,8 +56,8 @@ func (pu *pipeUnpackJSON) canLiveTail() bool {
 	return true
 }
 
-func (pu *pipeUnpackJSON) updateNeededFields(neededFields, unneededFields fieldsSet) {
-	updateNeededFieldsForUnpackPipe(pu.fromField, pu.fields, pu.keepOriginalFields, pu.skipEmptyResults, pu.iff, neededFields, unneededFields)
+func (pu *pipeUnpackJSON) updateNeededFields(neededFields, unneededFields *fieldsSet) {
+	updateNeededFieldsForUnpackPipe(pu.fromField, pu.resultPrefix, pu.fields, pu.keepOriginalFields, pu.skipEmptyResults, pu.iff, neededFields, unneededFields)
 }
 
 func (pu *pipeUnpackJSON) optimize() {
@@ -78,7 +78,7 @@ func (pu *pipeUnpackJSON) initFilterInValues(cache map[string][]string, getField
 	return &puNew, nil
 }
 
-func (pu *pipeUnpackJSON) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pu *pipeUnpackJSON) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	unpackJSON := func(uctx *fieldsUnpackerContext, s string) {
 		if len(s) == 0 || s[0] != '{' {
 			// This isn't a JSON object
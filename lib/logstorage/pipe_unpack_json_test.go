@@ -379,4 +379,11 @@ func TestPipeUnpackJSONUpdateNeededFields(t *testing.T) {
 	f("unpack_json if (y:z) from x fields (x)", "f2,x", "", "f2,x,y", "")
 	f("unpack_json if (y:z) from x fields (x) skip_empty_results", "f2,x", "", "f2,x,y", "")
 	f("unpack_json if (y:z) from x fields (x) keep_original_fields", "f2,x", "", "f2,x,y", "")
+
+	// fields with result_prefix
+	f("unpack_json from x fields (f1) result_prefix qwe_", "qwe_f1", "", "x", "")
+	f("unpack_json from x fields (f1) result_prefix qwe_", "f1", "", "f1", "")
+	f("unpack_json from x fields (f1) result_prefix qwe_ keep_original_fields", "qwe_f1", "", "qwe_f1,x", "")
+	f("unpack_json from x fields (f1) result_prefix qwe_", "*", "qwe_f1", "*", "qwe_f1")
+	f("unpack_json from x fields (f1) result_prefix qwe_", "*", "f1", "*", "f1,qwe_f1")
 }
@@ -25,6 +25,18 @@ type pipeUnpackLogfmt struct {
 
 	// iff is an optional filter for skipping unpacking logfmt
 	iff *ifFilter
+
+	// neededOutFields contains the unpacked field names without resultPrefix, which are needed by the subsequent pipes.
+	//
+	// If it is nil, then all the unpacked fields except of unneededOutFields are needed.
+	//
+	// It is initialized at initNeededOutFields() when fields list is empty.
+	neededOutFields *fieldsSet
+
+	// unneededOutFields contains the unpacked field names without resultPrefix, which aren't needed by the subsequent pipes.
+	//
+	// It is initialized at initNeededOutFields() when fields list is empty.
+	unneededOutFields *fieldsSet
 }
 
 func (pu *pipeUnpackLogfmt) String() string {
@@ -54,8 +66,8 @@ func (pu *pipeUnpackLogfmt) canLiveTail() bool {
 	return true
 }
 
-func (pu *pipeUnpackLogfmt) updateNeededFields(neededFields, unneededFields fieldsSet) {
-	updateNeededFieldsForUnpackPipe(pu.fromField, pu.fields, pu.keepOriginalFields, pu.skipEmptyResults, pu.iff, neededFields, unneededFields)
+func (pu *pipeUnpackLogfmt) updateNeededFields(neededFields, unneededFields *fieldsSet) {
+	updateNeededFieldsForUnpackPipe(pu.fromField, pu.resultPrefix, pu.fields, pu.keepOriginalFields, pu.skipEmptyResults, pu.iff, neededFields, unneededFields)
 }
 
 func (pu *pipeUnpackLogfmt) optimize() {
@@ -76,14 +88,16 @@ func (pu *pipeUnpackLogfmt) initFilterInValues(cache map[string][]string, getFie
 	return &puNew, nil
 }
 
-func (pu *pipeUnpackLogfmt) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pu *pipeUnpackLogfmt) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	unpackLogfmt := func(uctx *fieldsUnpackerContext, s string) {
 		p := getLogfmtParser()
 
 		p.parse(s)
 		if len(pu.fields) == 0 {
 			for _, f := range p.fields {
-				uctx.addField(f.Name, f.Value)
+				if pu.isNeededOutField(f.Name) {
+					uctx.addField(f.Name, f.Value)
+				}
 			}
 		} else {
 			for _, fieldName := range pu.fields {
@@ -107,6 +121,37 @@ func (pu *pipeUnpackLogfmt) newPipeProcessor(workersCount int, _ <-chan struct{}
 	return newPipeUnpackProcessor(workersCount, unpackLogfmt, ppNext, pu.fromField, pu.resultPrefix, pu.keepOriginalFields, pu.skipEmptyResults, pu.iff)
 }
 
+// initNeededOutFields initializes the list of unpacked fields needed by pipesNext, which follow pu.
+//
+// This allows skipping the materialization of unpacked fields, which aren't used by pipesNext.
+func (pu *pipeUnpackLogfmt) initNeededOutFields(pipesNext []pipe) {
+	pu.neededOutFields = nil
+	pu.unneededOutFields = nil
+	if len(pu.fields) > 0 {
+		// The unpacked fields are explicitly set.
+		return
+	}
+
+	neededFields, unneededFields := getNeededFieldsForPipes(pipesNext)
+	if neededFields.contains("*") {
+		if !unneededFields.isEmpty() {
+			pu.unneededOutFields = unneededFields.getFieldsWithoutPrefix(pu.resultPrefix)
+		}
+	} else {
+		pu.neededOutFields = neededFields.getFieldsWithoutPrefix(pu.resultPrefix)
+	}
+}
+
+func (pu *pipeUnpackLogfmt) isNeededOutField(name string) bool {
+	if pu.neededOutFields != nil {
+		return pu.neededOutFields.contains(name)
+	}
+	if pu.unneededOutFields != nil {
+		return !pu.unneededOutFields.contains(name)
+	}
+	return true
+}
+
 func parsePipeUnpackLogfmt(lex *lexer) (*pipeUnpackLogfmt, error) {
 	if !lex.isKeyword("unpack_logfmt") {
 		return nil, fmt.Errorf("unexpected token: %q; want %q", lex.token, "unpack_logfmt")
@@ -319,4 +319,87 @@ func TestPipeUnpackLogfmtUpdateNeededFields(t *testing.T) {
 	f("unpack_logfmt from x", "f2,x", "", "f2,x", "")
 	f("unpack_logfmt if (y:z) from x", "f2,x", "", "f2,x,y", "")
 	f("unpack_logfmt if (f2:z y:qwe) from x", "f2,x", "", "f2,x,y", "")
+
+	// fields with result_prefix
+	f("unpack_logfmt from x fields (f1) result_prefix qwe_", "qwe_f1", "", "x", "")
+	f("unpack_logfmt from x fields (f1) result_prefix qwe_", "f1", "", "f1", "")
+	f("unpack_logfmt from x fields (f1) result_prefix qwe_", "*", "qwe_f1", "*", "qwe_f1")
+}
+
+func TestPipeUnpackLogfmtNeededOutFields(t *testing.T) {
+	f := func(qStr string, neededExpected, unneededExpected []string) {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse [%s]: %s", qStr, err)
+		}
+		q.Optimize()
+
+		pu, ok := q.pipes[0].(*pipeUnpackLogfmt)
+		if !ok {
+			t.Fatalf("unexpected first pipe; got %T; want *pipeUnpackLogfmt", q.pipes[0])
+		}
+		for _, name := range neededExpected {
+			if !pu.isNeededOutField(name) {
+				t.Fatalf("expecting %q to be needed for [%s]", name, qStr)
+			}
+		}
+		for _, name := range unneededExpected {
+			if pu.isNeededOutField(name) {
+				t.Fatalf("expecting %q to be unneeded for [%s]", name, qStr)
+			}
+		}
+	}
+
+	// all the fields are needed
+	f("* | unpack_logfmt", []string{"foo", "bar"}, nil)
+	f("* | unpack_logfmt | fields *", []string{"foo", "bar"}, nil)
+
+	// explicitly set fields are always unpacked
+	f("* | unpack_logfmt fields (foo, bar) | fields foo", []string{"foo", "bar"}, nil)
+
+	// only the needed fields are unpacked
+	f("* | unpack_logfmt | fields foo", []string{"foo"}, []string{"bar", "baz"})
+	f("* | unpack_logfmt | stats by (foo) count_uniq(bar) x", []string{"foo", "bar"}, []string{"baz"})
+	f("* | unpack_logfmt | count()", nil, []string{"foo", "bar"})
+	f("* | unpack_logfmt | delete foo", []string{"bar", "baz"}, []string{"foo"})
+
+	// needed fields with result_prefix
+	f("* | unpack_logfmt result_prefix qwe_ | fields qwe_foo, bar", []string{"foo"}, []string{"bar", "qwe_foo"})
+	f("* | unpack_logfmt result_prefix qwe_ | delete qwe_foo, bar", []string{"bar", "baz"}, []string{"foo"})
+}
+
+func TestPipeUnpackLogfmtSkipUnneededOutFields(t *testing.T) {
+	q, err := ParseQuery("* | unpack_logfmt result_prefix qwe_ | fields _msg, qwe_foo")
+	if err != nil {
+		t.Fatalf("cannot parse query: %s", err)
+	}
+	q.Optimize()
+	pu := q.pipes[0].(*pipeUnpackLogfmt)
+
+	workersCount := 3
+	stopCh := make(chan struct{})
+	ppTest := newTestPipeProcessor()
+	pp := pu.newPipeProcessor(workersCount, stopCh, func() {}, ppTest, newMemoryBudget(0))
+
+	brw := newTestBlockResultWriter(workersCount, pp)
+	brw.writeRow([]Field{
+		{"_msg", `foo=bar baz="x y=z" a=b`},
+	})
+	brw.writeRow([]Field{
+		{"_msg", `a=b`},
+	})
+	brw.flush()
+	pp.flush()
+
+	ppTest.expectRows(t, [][]Field{
+		{
+			{"_msg", `foo=bar baz="x y=z" a=b`},
+			{"qwe_foo", "bar"},
+		},
+		{
+			{"_msg", `a=b`},
+		},
+	})
 }
@@ -50,8 +50,8 @@ func (pu *pipeUnpackSyslog) canLiveTail() bool {
 	return true
 }
 
-func (pu *pipeUnpackSyslog) updateNeededFields(neededFields, unneededFields fieldsSet) {
-	updateNeededFieldsForUnpackPipe(pu.fromField, nil, pu.keepOriginalFields, false, pu.iff, neededFields, unneededFields)
+func (pu *pipeUnpackSyslog) updateNeededFields(neededFields, unneededFields *fieldsSet) {
+	updateNeededFieldsForUnpackPipe(pu.fromField, pu.resultPrefix, nil, pu.keepOriginalFields, false, pu.iff, neededFields, unneededFields)
 }
 
 func (pu *pipeUnpackSyslog) optimize() {
@@ -72,7 +72,7 @@ func (pu *pipeUnpackSyslog) initFilterInValues(cache map[string][]string, getFie
 	return &puNew, nil
 }
 
-func (pu *pipeUnpackSyslog) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pu *pipeUnpackSyslog) newPipeProcessor(workersCount int, _ <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	unpackSyslog := func(uctx *fieldsUnpackerContext, s string) {
 		year := currentYear.Load()
 		p := GetSyslogParser(int(year), pu.offsetTimezone)
@@ -54,7 +54,7 @@ func (pu *pipeUnroll) initFilterInValues(cache map[string][]string, getFieldValu
 	return &puNew, nil
 }
 
-func (pu *pipeUnroll) updateNeededFields(neededFields, unneededFields fieldsSet) {
+func (pu *pipeUnroll) updateNeededFields(neededFields, unneededFields *fieldsSet) {
 	if neededFields.contains("*") {
 		if pu.iff != nil {
 			unneededFields.removeFields(pu.iff.neededFields)
@@ -68,7 +68,7 @@ func (pu *pipeUnroll) updateNeededFields(neededFields, unneededFields fieldsSet)
 	}
 }
 
-func (pu *pipeUnroll) newPipeProcessor(workersCount int, stopCh <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+func (pu *pipeUnroll) newPipeProcessor(workersCount int, stopCh <-chan struct{}, _ func(), ppNext pipeProcessor, _ *memoryBudget) pipeProcessor {
 	return &pipeUnrollProcessor{
 		pu:     pu,
 		stopCh: stopCh,
@@ -103,6 +103,11 @@ type pipeUnrollProcessorShardNopad struct {
 	unrolledValues [][]string
 	valuesBuf      []string
 	fields         []Field
+
+	// valuesPrev contains the previously unrolled values per each column.
+	//
+	// It is used for avoiding JSON parsing for identical consecutive values.
+	valuesPrev []string
 }
 
 func (pup *pipeUnrollProcessor) writeBlock(workerID uint, br *blockResult) {
@@ -132,13 +137,19 @@ func (pup *pipeUnrollProcessor) writeBlock(workerID uint, br *blockResult) {
 		columnValues[i] = c.getValues(br)
 	}
 
+	shard.unrolledValues = slicesutil.SetLength(shard.unrolledValues, len(pu.fields))
+	shard.valuesPrev = slicesutil.SetLength(shard.valuesPrev, len(pu.fields))
+	shard.valuesBuf = shard.valuesBuf[:0]
+	hadUnrolls := false
+
 	fields := shard.fields
 	for rowIdx := range br.timestamps {
 		if bm.isSetBit(rowIdx) {
 			if needStop(pup.stopCh) {
 				return
 			}
-			shard.writeUnrolledFields(pu.fields, columnValues, rowIdx)
+			shard.writeUnrolledFields(pu.fields, columnValues, rowIdx, hadUnrolls)
+			hadUnrolls = true
 		} else {
 			fields = fields[:0]
 			for i, f := range pu.fields {
@@ -157,15 +168,21 @@ func (pup *pipeUnrollProcessor) writeBlock(workerID uint, br *blockResult) {
 	shard.a.reset()
 }
 
-func (shard *pipeUnrollProcessorShard) writeUnrolledFields(fieldNames []string, columnValues [][]string, rowIdx int) {
+func (shard *pipeUnrollProcessorShard) writeUnrolledFields(fieldNames []string, columnValues [][]string, rowIdx int, hadUnrolls bool) {
 	// unroll values at rowIdx row
 
-	shard.unrolledValues = slicesutil.SetLength(shard.unrolledValues, len(columnValues))
 	unrolledValues := shard.unrolledValues
+	valuesPrev := shard.valuesPrev
 
-	valuesBuf := shard.valuesBuf[:0]
+	valuesBuf := shard.valuesBuf
 	for i, values := range columnValues {
 		v := values[rowIdx]
+		if hadUnrolls && v == valuesPrev[i] {
+			// Re-use the unrolled values from the previous row, since they are identical.
+			continue
+		}
+		valuesPrev[i] = v
+
 		valuesBufLen := len(valuesBuf)
 		valuesBuf = unpackJSONArray(valuesBuf, &shard.a, v)
 		unrolledValues[i] = valuesBuf[valuesBufLen:]
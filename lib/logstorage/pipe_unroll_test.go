@@ -208,6 +208,99 @@ func TestPipeUnroll(t *testing.T) {
 
 }
 
+func TestPipeUnrollSameValuesInBlock(t *testing.T) {
+	f := func(pipeStr string, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeResultsSingleBlock(t, pipeStr, rows, rowsExpected)
+	}
+
+	// identical consecutive values in one column and distinct values in another column
+	f(`unroll (a, b)`, [][]Field{
+		{
+			{"a", `["x","y"]`},
+			{"b", `[1]`},
+		},
+		{
+			{"a", `["x","y"]`},
+			{"b", `[2,3,4]`},
+		},
+		{
+			{"a", `["z"]`},
+			{"b", `[2,3,4]`},
+		},
+	}, [][]Field{
+		{
+			{"a", "x"},
+			{"b", "1"},
+		},
+		{
+			{"a", "y"},
+			{"b", ""},
+		},
+		{
+			{"a", "x"},
+			{"b", "2"},
+		},
+		{
+			{"a", "y"},
+			{"b", "3"},
+		},
+		{
+			{"a", ""},
+			{"b", "4"},
+		},
+		{
+			{"a", "z"},
+			{"b", "2"},
+		},
+		{
+			{"a", ""},
+			{"b", "3"},
+		},
+		{
+			{"a", ""},
+			{"b", "4"},
+		},
+	})
+
+	// identical values separated by the rows skipped by the if (...) filter
+	f(`unroll if (c:foo) (a)`, [][]Field{
+		{
+			{"a", `["x","y"]`},
+			{"c", "foo"},
+		},
+		{
+			{"a", `["z"]`},
+			{"c", "bar"},
+		},
+		{
+			{"a", `["x","y"]`},
+			{"c", "foo"},
+		},
+	}, [][]Field{
+		{
+			{"a", "x"},
+			{"c", "foo"},
+		},
+		{
+			{"a", "y"},
+			{"c", "foo"},
+		},
+		{
+			{"a", `["z"]`},
+			{"c", "bar"},
+		},
+		{
+			{"a", "x"},
+			{"c", "foo"},
+		},
+		{
+			{"a", "y"},
+			{"c", "foo"},
+		},
+	})
+}
+
 func TestPipeUnrollUpdateNeededFields(t *testing.T) {
 	f := func(s string, neededFields, unneededFields, neededFieldsExpected, unneededFieldsExpected string) {
 		t.Helper()
@@ -4,7 +4,7 @@ import (
 	"unsafe"
 )
 
-func updateNeededFieldsForUpdatePipe(neededFields, unneededFields fieldsSet, field string, iff *ifFilter) {
+func updateNeededFieldsForUpdatePipe(neededFields, unneededFields *fieldsSet, field string, iff *ifFilter) {
 	if neededFields.isEmpty() {
 		if iff != nil {
 			neededFields.addFields(iff.neededFields)
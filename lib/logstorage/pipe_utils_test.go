@@ -1,6 +1,7 @@
 package logstorage
 
 import (
+	"fmt"
 	"math/rand"
 	"slices"
 	"strings"
@@ -44,12 +45,13 @@ func expectPipeResults(t *testing.T, pipeStr string, rows, rowsExpected [][]Fiel
 	if err != nil {
 		t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
 	}
+	p.optimize()
 
 	workersCount := 5
 	stopCh := make(chan struct{})
 	cancel := func() {}
 	ppTest := newTestPipeProcessor()
-	pp := p.newPipeProcessor(workersCount, stopCh, cancel, ppTest)
+	pp := p.newPipeProcessor(workersCount, stopCh, cancel, ppTest, newMemoryBudget(0))
 
 	brw := newTestBlockResultWriter(workersCount, pp)
 	for _, row := range rows {
@@ -61,6 +63,27 @@ func expectPipeResults(t *testing.T, pipeStr string, rows, rowsExpected [][]Fiel
 	ppTest.expectRows(t, rowsExpected)
 }
 
+// expectPipeResultsSingleBlock verifies pipe results when all the rows are written to the pipe in a single block.
+//
+// The rows must have identical sets of fields. This allows verifying pipes, which re-use results across rows in the block.
+func expectPipeResultsSingleBlock(t *testing.T, pipeStr string, rows, rowsExpected [][]Field) {
+	t.Helper()
+
+	lex := newLexer(pipeStr)
+	p, err := parsePipe(lex)
+	if err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+	}
+	p.optimize()
+
+	ppTest := newTestPipeProcessor()
+	pp := p.newPipeProcessor(1, make(chan struct{}), func() {}, ppTest, newMemoryBudget(0))
+	pp.writeBlock(0, newTestStatsBlockResult(rows))
+	pp.flush()
+
+	ppTest.expectRows(t, rowsExpected)
+}
+
 func newTestBlockResultWriter(workersCount int, ppNext pipeProcessor) *testBlockResultWriter {
 	return &testBlockResultWriter{
 		workersCount: workersCount,
@@ -129,6 +152,10 @@ type testPipeProcessor struct {
 }
 
 func (pp *testPipeProcessor) writeBlock(_ uint, br *blockResult) {
+	if len(br.timestamps) == 0 {
+		return
+	}
+
 	cs := br.getColumns()
 	var columnValues [][]string
 	for _, c := range cs {
@@ -256,3 +283,47 @@ func rowToString(row []Field) string {
 	}
 	return "{" + strings.Join(a, ",") + "}"
 }
+
+// newTestPipeWriteBlockFunc returns a function, which writes a block with the given rows to the processor for the given pipeStr.
+//
+// The processor is created with a single worker. The results are dropped.
+func newTestPipeWriteBlockFunc(tb testing.TB, pipeStr string, rows [][]Field) func() {
+	tb.Helper()
+
+	lex := newLexer(pipeStr)
+	p, err := parsePipe(lex)
+	if err != nil {
+		tb.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+	}
+	p.optimize()
+
+	ppNext := newDefaultPipeProcessor(func(_ uint, _ *blockResult) {})
+	pp := p.newPipeProcessor(1, make(chan struct{}), func() {}, ppNext, newMemoryBudget(0))
+
+	var rcs []resultColumn
+	for _, f := range rows[0] {
+		rcs = appendResultColumnWithName(rcs, f.Name)
+	}
+	for _, row := range rows {
+		for i, f := range row {
+			rcs[i].addValue(f.Value)
+		}
+	}
+
+	var br blockResult
+	return func() {
+		br.setResultColumns(rcs, len(rows))
+		pp.writeBlock(0, &br)
+	}
+}
+
+func newTestPipeWriteBlockRows(rowsCount int) [][]Field {
+	rows := make([][]Field, rowsCount)
+	for i := range rows {
+		rows[i] = []Field{
+			{"_msg", fmt.Sprintf(`{"level":"info","ip":"10.0.0.%d","duration":%d,"path":"/foo/bar?x=%d"}`, i%256, i, i)},
+			{"host", fmt.Sprintf("host-%d", i%10)},
+		}
+	}
+	return rows
+}
@@ -0,0 +1,480 @@
+package logstorage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// The query results cache contains two kinds of entries:
+//
+//   - finished results for queries over time ranges, which end before now-queryResultsCacheTimestampOffset;
+//   - per-partition states for queries starting with the `stats` pipe for per-day partitions,
+//     which end before now-queryResultsCacheTimestampOffset and which are fully covered by the query time range;
+//   - per-bucket states for queries starting with the `stats` pipe for time buckets at the remaining partitions,
+//     which end before now-queryResultsCacheTimestampOffset and which are fully covered by the query time range.
+//
+// Cache keys contain the state of the data at the selected partitions (see partitionWrapper.marshalDataState
+// and partitionWrapper.marshalLateDataState) and the latest delete task id, so the cached entries are automatically
+// invalidated when new rows are ingested into the cached time ranges or when rows are deleted from these partitions.
+const (
+	queryResultsCacheKindQuery = byte(iota)
+	queryResultsCacheKindStatsState
+	queryResultsCacheKindStatsStateBucket
+)
+
+// queryResultsCacheVersion must be incremented when the format of query results cache entries changes.
+//
+// It is stored in the first byte of every cache entry, so empty cached results can be distinguished from missing entries.
+const queryResultsCacheVersion = 1
+
+// maxQueryResultsCacheEntrySize is the maximum size of a single entry at the query results cache.
+//
+// Bigger results aren't cached, since they may evict many smaller entries from the cache.
+const maxQueryResultsCacheEntrySize = 4 * 1024 * 1024
+
+// getQueryResultsCacheKey returns the key for caching the results of q for the given tenantIDs.
+//
+// nil is returned if the results of q cannot be cached.
+func (s *Storage) getQueryResultsCacheKey(tenantIDs []TenantID, q *Query) []byte {
+	if s.queryResultsCache == nil || q.hasRelativeTime {
+		return nil
+	}
+	if !canCacheQueryPipes(q.pipes) || hasFilterInWithQueryForFilter(q.f) || hasFilterInWithQueryForPipes(q.pipes) {
+		return nil
+	}
+	minTimestamp, maxTimestamp := q.GetFilterTimeRange()
+	if maxTimestamp > s.getMaxCacheableTimestamp() {
+		return nil
+	}
+
+	key := []byte{queryResultsCacheKindQuery}
+	key = marshalTenantIDsForCache(key, tenantIDs)
+	key = encoding.MarshalBytes(key, bytesutil.ToUnsafeBytes(q.String()))
+	key = encoding.MarshalVarInt64(key, minTimestamp)
+	key = encoding.MarshalVarInt64(key, maxTimestamp)
+	key = encoding.MarshalUint64(key, s.deleteTasksLatestSeq.Load())
+
+	ptws := s.getPartitionsForTimeRange(minTimestamp, maxTimestamp)
+	for _, ptw := range ptws {
+		key = ptw.marshalDataState(key)
+		ptw.decRef()
+	}
+
+	return key
+}
+
+// canCacheQueryPipes returns false if the results of the given pipes cannot be cached.
+func canCacheQueryPipes(pipes []pipe) bool {
+	for _, p := range pipes {
+		switch p.(type) {
+		case *pipeJoin, *pipeUnion, *pipeStreamContext:
+			// These pipes read the data outside the query time range.
+			return false
+		case *pipeBlockStats, *pipeBlocksCount:
+			// The results of these pipes depend on the query execution and on the physical layout of the data.
+			return false
+		}
+	}
+	return true
+}
+
+// getMaxCacheableTimestamp returns the maximum timestamp for the data, which can be put into the query results cache.
+func (s *Storage) getMaxCacheableTimestamp() int64 {
+	return time.Now().UnixNano() - s.queryResultsCacheTimestampOffset
+}
+
+// marshalDataState appends the state of the data at ptw to dst.
+//
+// The state changes when new rows are added to the partition or when rows are deleted from the partition.
+func (ptw *partitionWrapper) marshalDataState(dst []byte) []byte {
+	dst = encoding.MarshalVarInt64(dst, ptw.day)
+	dst = encoding.MarshalVarUint64(dst, ptw.pt.ddb.getRowsCount())
+	return dst
+}
+
+// marshalLateDataState appends the state of the data with timestamps older than Storage.getMaxCacheableTimestamp() at ptw to dst.
+//
+// Unlike marshalDataState, the state doesn't change when new rows with recent timestamps are added to the partition.
+// The state changes after the partition is re-opened, since the number of late rows isn't persisted.
+func (ptw *partitionWrapper) marshalLateDataState(dst []byte) []byte {
+	dst = encoding.MarshalVarInt64(dst, ptw.day)
+	dst = encoding.MarshalUint64(dst, ptw.pt.generation)
+	dst = encoding.MarshalVarUint64(dst, ptw.pt.ddb.lateRowsAdded.Load())
+	return dst
+}
+
+func marshalTenantIDsForCache(dst []byte, tenantIDs []TenantID) []byte {
+	dst = encoding.MarshalVarUint64(dst, uint64(len(tenantIDs)))
+	for i := range tenantIDs {
+		dst = tenantIDs[i].marshal(dst)
+	}
+	return dst
+}
+
+// getQueryResultsCacheEntry returns the entry for the given key from the query results cache.
+//
+// false is returned if the entry is missing in the cache.
+func (s *Storage) getQueryResultsCacheEntry(key []byte) ([]byte, bool) {
+	s.queryResultsCacheRequests.Add(1)
+	data := s.queryResultsCache.GetBig(nil, key)
+	if len(data) == 0 || data[0] != queryResultsCacheVersion {
+		s.queryResultsCacheMisses.Add(1)
+		return nil, false
+	}
+	return data[1:], true
+}
+
+// putQueryResultsCacheEntry puts data for the given key into the query results cache.
+//
+// data isn't cached if it exceeds maxQueryResultsCacheEntrySize.
+func (s *Storage) putQueryResultsCacheEntry(key, data []byte) {
+	if len(data) >= maxQueryResultsCacheEntrySize {
+		return
+	}
+	entry := make([]byte, 0, 1+len(data))
+	entry = append(entry, queryResultsCacheVersion)
+	entry = append(entry, data...)
+	s.queryResultsCache.SetBig(key, entry)
+}
+
+// writeCachedQueryResults writes the cached query results for the given key to writeBlock.
+//
+// false is returned if the results are missing in the cache.
+func (s *Storage) writeCachedQueryResults(key []byte, writeBlock WriteBlockFunc) bool {
+	data, ok := s.getQueryResultsCacheEntry(key)
+	if !ok {
+		return false
+	}
+
+	blocks, err := unmarshalCachedQueryResults(data)
+	if err != nil {
+		logger.Warnf("cannot unmarshal cached query results; executing the query: %s", err)
+		return false
+	}
+	for _, b := range blocks {
+		writeBlock(0, b.timestamps, b.columns)
+	}
+	return true
+}
+
+// putQueryResultsToCache puts the results collected by qrr into the query results cache under the given key.
+func (s *Storage) putQueryResultsToCache(key []byte, qrr *queryResultsRecorder) {
+	if qrr.isTooBig {
+		return
+	}
+	s.putQueryResultsCacheEntry(key, qrr.buf)
+}
+
+// queryResultsRecorder collects the query results for putting them into the query results cache.
+type queryResultsRecorder struct {
+	mu sync.Mutex
+
+	// buf contains the marshaled blocks with the query results.
+	buf []byte
+
+	// isTooBig is set to true if the query results exceed maxQueryResultsCacheEntrySize.
+	isTooBig bool
+}
+
+// writeBlock appends a block with the given timestamps and columns to qrr.
+//
+// It is safe calling writeBlock from concurrently running goroutines.
+func (qrr *queryResultsRecorder) writeBlock(timestamps []int64, columns []BlockColumn) {
+	qrr.mu.Lock()
+	defer qrr.mu.Unlock()
+
+	if qrr.isTooBig {
+		return
+	}
+
+	buf := qrr.buf
+	buf = encoding.MarshalVarUint64(buf, uint64(len(timestamps)))
+	for _, ts := range timestamps {
+		buf = encoding.MarshalVarInt64(buf, ts)
+	}
+	buf = encoding.MarshalVarUint64(buf, uint64(len(columns)))
+	for _, c := range columns {
+		buf = encoding.MarshalBytes(buf, bytesutil.ToUnsafeBytes(c.Name))
+		for _, v := range c.Values {
+			buf = encoding.MarshalBytes(buf, bytesutil.ToUnsafeBytes(v))
+		}
+	}
+
+	if len(buf) >= maxQueryResultsCacheEntrySize {
+		qrr.isTooBig = true
+		buf = nil
+	}
+	qrr.buf = buf
+}
+
+type cachedQueryResultsBlock struct {
+	timestamps []int64
+	columns    []BlockColumn
+}
+
+// unmarshalCachedQueryResults unmarshals blocks collected by queryResultsRecorder from src.
+//
+// The returned blocks refer to src.
+func unmarshalCachedQueryResults(src []byte) ([]cachedQueryResultsBlock, error) {
+	var blocks []cachedQueryResultsBlock
+	for len(src) > 0 {
+		rowsCount, n := encoding.UnmarshalVarUint64(src)
+		if n <= 0 {
+			return nil, fmt.Errorf("cannot unmarshal rows count")
+		}
+		src = src[n:]
+		if rowsCount > uint64(len(src)) {
+			return nil, fmt.Errorf("too big rows count: %d; it cannot exceed %d", rowsCount, len(src))
+		}
+
+		timestamps := make([]int64, rowsCount)
+		for i := range timestamps {
+			ts, n := encoding.UnmarshalVarInt64(src)
+			if n <= 0 {
+				return nil, fmt.Errorf("cannot unmarshal timestamp #%d", i)
+			}
+			src = src[n:]
+			timestamps[i] = ts
+		}
+
+		columnsCount, n := encoding.UnmarshalVarUint64(src)
+		if n <= 0 {
+			return nil, fmt.Errorf("cannot unmarshal columns count")
+		}
+		src = src[n:]
+		if columnsCount > uint64(len(src)) {
+			return nil, fmt.Errorf("too big columns count: %d; it cannot exceed %d", columnsCount, len(src))
+		}
+
+		columns := make([]BlockColumn, columnsCount)
+		for i := range columns {
+			name, n := encoding.UnmarshalBytes(src)
+			if n <= 0 {
+				return nil, fmt.Errorf("cannot unmarshal name for column #%d", i)
+			}
+			src = src[n:]
+
+			values := make([]string, rowsCount)
+			for j := range values {
+				v, n := encoding.UnmarshalBytes(src)
+				if n <= 0 {
+					return nil, fmt.Errorf("cannot unmarshal value #%d for column %q", j, name)
+				}
+				src = src[n:]
+				values[j] = bytesutil.ToUnsafeString(v)
+			}
+
+			columns[i] = BlockColumn{
+				Name:   bytesutil.ToUnsafeString(name),
+				Values: values,
+			}
+		}
+
+		blocks = append(blocks, cachedQueryResultsBlock{
+			timestamps: timestamps,
+			columns:    columns,
+		})
+	}
+	return blocks, nil
+}
+
+// statsStateCacheBucketDuration is the duration of time buckets for caching the states of the `stats` pipe
+// at partitions, which aren't fully covered by the query time range or which may receive new rows.
+//
+// This allows re-using the cached states for the most of the data selected by queries with sliding time windows,
+// such as `_time:1h`, so only the data for the most recent time bucket must be scanned at every query.
+//
+// nsecPerDay must be divisible by statsStateCacheBucketDuration.
+const statsStateCacheBucketDuration = int64(10 * time.Minute)
+
+// timeRange is a time range [minTimestamp, maxTimestamp] in nanoseconds.
+type timeRange struct {
+	minTimestamp int64
+	maxTimestamp int64
+}
+
+// importCachedStatsStates imports states from the query results cache into psp for the first `stats` pipe at q.
+//
+// The states are imported for per-day partitions fully covered by the time range at so, and for time buckets
+// with statsStateCacheBucketDuration at the remaining partitions. Only the data, which isn't expected to change, is considered.
+// The states missing in the cache are calculated and put into the cache.
+//
+// It returns sorted non-overlapping time ranges with the imported states. The data on these time ranges must be skipped during the search.
+func (s *Storage) importCachedStatsStates(ctx context.Context, workersCount int, tenantIDs []TenantID, q *Query, so *genericSearchOptions, psp *pipeStatsProcessor) []timeRange {
+	if s.queryResultsCache == nil {
+		return nil
+	}
+	filterStr, ok := getFilterStringWithoutTimeRange(q.f)
+	if !ok || !canCacheStatsState(psp.ps) {
+		return nil
+	}
+
+	ptws := s.getPartitionsForTimeRange(so.minTimestamp, so.maxTimestamp)
+	defer func() {
+		for _, ptw := range ptws {
+			ptw.decRef()
+		}
+	}()
+
+	var keyPrefix []byte
+	keyPrefix = marshalTenantIDsForCache(keyPrefix, tenantIDs)
+	keyPrefix = encoding.MarshalBytes(keyPrefix, bytesutil.ToUnsafeBytes(filterStr))
+	keyPrefix = encoding.MarshalBytes(keyPrefix, bytesutil.ToUnsafeBytes(psp.ps.String()))
+	keyPrefix = encoding.MarshalUint64(keyPrefix, s.deleteTasksLatestSeq.Load())
+
+	var trs []timeRange
+	importState := func(key []byte, minTimestamp, maxTimestamp int64) bool {
+		state, ok := s.getQueryResultsCacheEntry(key)
+		if !ok {
+			state, ok = s.getStatsStateForTimeRange(ctx, workersCount, so, psp.ps, minTimestamp, maxTimestamp)
+			if !ok {
+				return false
+			}
+			s.putQueryResultsCacheEntry(key, state)
+		}
+		if err := psp.importState(state); err != nil {
+			logger.Warnf("cannot import cached state for [%s]; scanning the data on the time range [%d, %d]: %s", psp.ps, minTimestamp, maxTimestamp, err)
+			return false
+		}
+
+		if n := len(trs); n > 0 && trs[n-1].maxTimestamp+1 == minTimestamp {
+			trs[n-1].maxTimestamp = maxTimestamp
+		} else {
+			trs = append(trs, timeRange{
+				minTimestamp: minTimestamp,
+				maxTimestamp: maxTimestamp,
+			})
+		}
+		return true
+	}
+
+	maxCacheableTimestamp := s.getMaxCacheableTimestamp()
+	var key []byte
+	for _, ptw := range ptws {
+		if ctx.Err() != nil {
+			break
+		}
+
+		dayMinTimestamp := ptw.day * nsecPerDay
+		dayMaxTimestamp := dayMinTimestamp + nsecPerDay - 1
+		if dayMinTimestamp >= so.minTimestamp && dayMaxTimestamp <= so.maxTimestamp && dayMaxTimestamp <= maxCacheableTimestamp {
+			key = append(key[:0], queryResultsCacheKindStatsState)
+			key = append(key, keyPrefix...)
+			key = ptw.marshalDataState(key)
+			importState(key, dayMinTimestamp, dayMaxTimestamp)
+			continue
+		}
+
+		// The partition isn't fully covered by the query time range or it may receive new rows.
+		// Use the states for time buckets, which are fully covered by the query time range
+		// and which contain the data, which isn't expected to change.
+		minTimestamp := max(dayMinTimestamp, so.minTimestamp)
+		maxTimestamp := min(dayMaxTimestamp, so.maxTimestamp, maxCacheableTimestamp)
+		bucketMinTimestamp := (minTimestamp + statsStateCacheBucketDuration - 1) / statsStateCacheBucketDuration * statsStateCacheBucketDuration
+		for ; bucketMinTimestamp+statsStateCacheBucketDuration-1 <= maxTimestamp; bucketMinTimestamp += statsStateCacheBucketDuration {
+			key = append(key[:0], queryResultsCacheKindStatsStateBucket)
+			key = append(key, keyPrefix...)
+			key = ptw.marshalLateDataState(key)
+			key = encoding.MarshalVarInt64(key, bucketMinTimestamp)
+			if !importState(key, bucketMinTimestamp, bucketMinTimestamp+statsStateCacheBucketDuration-1) {
+				// Stop processing the remaining buckets, since their states most likely cannot be calculated too.
+				break
+			}
+		}
+	}
+	return trs
+}
+
+// getStatsStateForTimeRange calculates the state for the ps pipe over the data with the given time range.
+//
+// false is returned if the state cannot be calculated.
+func (s *Storage) getStatsStateForTimeRange(ctx context.Context, workersCount int, so *genericSearchOptions, ps *pipeStats, minTimestamp, maxTimestamp int64) ([]byte, bool) {
+	ctxSearch, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopCh := ctxSearch.Done()
+
+	// The state is exported from psp instead of flushing it to the next pipe, so the next pipe isn't needed.
+	mb := newMemoryBudget(0)
+	psp := ps.newPipeProcessor(workersCount, stopCh, cancel, nil, mb).(*pipeStatsProcessor)
+
+	s.search(workersCount, so.withTimeRange(minTimestamp, maxTimestamp), stopCh, psp.writeBlock)
+
+	state, ok := psp.exportState()
+	if !ok || ctxSearch.Err() != nil {
+		return nil, false
+	}
+	return state, true
+}
+
+// getUncoveredTimeRanges returns time ranges on [minTimestamp, maxTimestamp], which aren't covered by the given sorted non-overlapping trs.
+func getUncoveredTimeRanges(minTimestamp, maxTimestamp int64, trs []timeRange) []timeRange {
+	var result []timeRange
+	for _, tr := range trs {
+		if tr.minTimestamp > minTimestamp {
+			result = append(result, timeRange{
+				minTimestamp: minTimestamp,
+				maxTimestamp: min(tr.minTimestamp-1, maxTimestamp),
+			})
+		}
+		if tr.maxTimestamp >= maxTimestamp {
+			return result
+		}
+		minTimestamp = max(minTimestamp, tr.maxTimestamp+1)
+	}
+	return append(result, timeRange{
+		minTimestamp: minTimestamp,
+		maxTimestamp: maxTimestamp,
+	})
+}
+
+// getFilterStringWithoutTimeRange returns the string representation of f without top-level time filters.
+//
+// The results of f and of the returned filter are identical for partitions fully covered by the time range for f.
+// false is returned if f contains nested time filters, since their string representation may depend on the current time.
+func getFilterStringWithoutTimeRange(f filter) (string, bool) {
+	filters := []filter{f}
+	if fa, ok := f.(*filterAnd); ok {
+		filters = fa.filters
+	}
+
+	var filtersWithoutTime []filter
+	for _, f := range filters {
+		if _, ok := f.(*filterTime); !ok {
+			filtersWithoutTime = append(filtersWithoutTime, f)
+		}
+	}
+	if hasTimeFilter(filtersWithoutTime) {
+		return "", false
+	}
+
+	fa := &filterAnd{
+		filters: filtersWithoutTime,
+	}
+	return fa.String(), true
+}
+
+// canCacheStatsState returns true if per-partition states for ps can be cached.
+func canCacheStatsState(ps *pipeStats) bool {
+	for _, f := range ps.funcs {
+		if f.iff == nil {
+			continue
+		}
+		if hasFilterInWithQueryForFilter(f.iff.f) || hasTimeFilter([]filter{f.iff.f}) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasTimeFilter(filters []filter) bool {
+	return visitFilters(filters, func(f filter) bool {
+		_, ok := f.(*filterTime)
+		return ok
+	})
+}
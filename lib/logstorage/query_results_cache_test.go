@@ -0,0 +1,248 @@
+package logstorage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestStorageQueryResultsCache(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	sc := &StorageConfig{
+		Retention: 10 * 365 * 24 * time.Hour,
+	}
+	s := MustOpenStorage(path, sc)
+
+	tenantID := TenantID{
+		AccountID: 1,
+		ProjectID: 2,
+	}
+	tenantIDs := []TenantID{tenantID}
+
+	addRows := func(day string, rowsCount int) {
+		t.Helper()
+
+		dayStart, err := time.Parse(time.DateOnly, day)
+		if err != nil {
+			t.Fatalf("cannot parse day %q: %s", day, err)
+		}
+		lr := GetLogRows([]string{"host"}, nil)
+		for i := 0; i < rowsCount; i++ {
+			fields := []Field{
+				{
+					Name:  "host",
+					Value: fmt.Sprintf("host-%d", i%2),
+				},
+				{
+					Name:  "level",
+					Value: []string{"info", "error"}[i%2],
+				},
+			}
+			lr.MustAdd(tenantID, dayStart.UnixNano()+int64(i)*1e9, fields)
+		}
+		s.MustAddRows(lr)
+		PutLogRows(lr)
+		s.debugFlush()
+	}
+
+	runQuery := func(qStr string) []string {
+		t.Helper()
+
+		q := mustParseQuery(qStr)
+		var rows []string
+		var rowsLock sync.Mutex
+		writeBlock := func(_ uint, timestamps []int64, columns []BlockColumn) {
+			rowsLock.Lock()
+			defer rowsLock.Unlock()
+
+			for i := range timestamps {
+				a := make([]string, len(columns))
+				for j, c := range columns {
+					a[j] = c.Name + "=" + c.Values[i]
+				}
+				rows = append(rows, strings.Join(a, ","))
+			}
+		}
+		if err := s.RunQuery(context.Background(), tenantIDs, q, writeBlock); err != nil {
+			t.Fatalf("unexpected error in query [%s]: %s", q, err)
+		}
+		sort.Strings(rows)
+		return rows
+	}
+
+	getCacheStats := func() (uint64, uint64) {
+		var ss StorageStats
+		s.UpdateStats(&ss)
+		return ss.QueryResultsCacheRequests, ss.QueryResultsCacheMisses
+	}
+
+	f := func(qStr string, rowsExpected []string, hitsExpected uint64) {
+		t.Helper()
+
+		requestsPrev, missesPrev := getCacheStats()
+		rows := runQuery(qStr)
+		if !reflect.DeepEqual(rows, rowsExpected) {
+			t.Fatalf("unexpected rows for query [%s]\ngot\n%q\nwant\n%q", qStr, rows, rowsExpected)
+		}
+		requests, misses := getCacheStats()
+		if hits := (requests - requestsPrev) - (misses - missesPrev); hits != hitsExpected {
+			t.Fatalf("unexpected number of cache hits for query [%s]; got %d; want %d", qStr, hits, hitsExpected)
+		}
+	}
+
+	addRows("2024-05-01", 10)
+	addRows("2024-05-02", 20)
+	addRows("2024-05-03", 30)
+
+	// The finished results are cached for queries over the time range in the past
+	qFinished := `_time:[2024-05-01T00:00:00Z, 2024-05-04T00:00:00Z) | stats by (level) count() hits`
+	f(qFinished, []string{"level=error,hits=30", "level=info,hits=30"}, 0)
+	f(qFinished, []string{"level=error,hits=30", "level=info,hits=30"}, 1)
+
+	// Per-day states for the `stats` pipe are cached for days fully covered by the query time range,
+	// while per-bucket states are cached for the remaining days
+	qPartial := `_time:[2024-05-01T12:00:00Z, now) | stats by (host) count() hits`
+	f(qPartial, []string{"host=host-0,hits=25", "host=host-1,hits=25"}, 0)
+	f(qPartial, []string{"host=host-0,hits=25", "host=host-1,hits=25"}, 2+72)
+
+	// Newly ingested rows invalidate the cached results for the corresponding day,
+	// while the cached states for the remaining days are still used
+	addRows("2024-05-02", 4)
+	f(qFinished, []string{"level=error,hits=32", "level=info,hits=32"}, 2)
+	f(qPartial, []string{"host=host-0,hits=27", "host=host-1,hits=27"}, 1+72)
+
+	// Newly ingested rows invalidate the cached per-bucket states for the corresponding day
+	addRows("2024-05-05", 20)
+	qBuckets := `_time:[2024-05-05T00:00:00Z, 2024-05-05T00:30:00Z) | stats count() hits`
+	f(qBuckets, []string{"hits=20"}, 0)
+	f(qBuckets, []string{"hits=20"}, 1)
+	addRows("2024-05-05", 4)
+	f(qBuckets, []string{"hits=24"}, 0)
+	f(qBuckets, []string{"hits=24"}, 1)
+
+	// Queries with relative time filters outside the top level aren't cached
+	qRelative := `_time:[2024-05-01T00:00:00Z, 2024-05-04T00:00:00Z) (level:info or _time:1h) | stats count() hits`
+	f(qRelative, []string{"hits=32"}, 0)
+	f(qRelative, []string{"hits=32"}, 0)
+
+	// Deleted rows invalidate the cached results
+	if _, err := s.DeleteRows(tenantIDs, mustParseQuery("level:error")); err != nil {
+		t.Fatalf("unexpected error when deleting rows: %s", err)
+	}
+	f(qFinished, []string{"level=info,hits=32"}, 0)
+	f(qFinished, []string{"level=info,hits=32"}, 1)
+
+	s.MustClose()
+	fs.MustRemoveAll(path)
+}
+
+func TestStorageQueryResultsCacheDisabled(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	sc := &StorageConfig{
+		Retention:                10 * 365 * 24 * time.Hour,
+		DisableQueryResultsCache: true,
+	}
+	s := MustOpenStorage(path, sc)
+
+	tenantID := TenantID{
+		AccountID: 1,
+	}
+	lr := GetLogRows(nil, nil)
+	for i := 0; i < 10; i++ {
+		lr.MustAdd(tenantID, time.Date(2024, 5, 1, 0, 0, i, 0, time.UTC).UnixNano(), []Field{{Name: "_msg", Value: "foo"}})
+	}
+	s.MustAddRows(lr)
+	PutLogRows(lr)
+	s.debugFlush()
+
+	for i := 0; i < 2; i++ {
+		var rows []string
+		var rowsLock sync.Mutex
+		writeBlock := func(_ uint, _ []int64, columns []BlockColumn) {
+			rowsLock.Lock()
+			rows = append(rows, columns[0].Values...)
+			rowsLock.Unlock()
+		}
+		q := mustParseQuery(`_time:[2024-05-01T00:00:00Z, 2024-05-02T00:00:00Z) | count()`)
+		if err := s.RunQuery(context.Background(), []TenantID{tenantID}, q, writeBlock); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(rows, []string{"10"}) {
+			t.Fatalf("unexpected rows; got %q; want %q", rows, []string{"10"})
+		}
+	}
+
+	var ss StorageStats
+	s.UpdateStats(&ss)
+	if ss.QueryResultsCacheRequests != 0 {
+		t.Fatalf("unexpected number of requests to the disabled cache; got %d; want 0", ss.QueryResultsCacheRequests)
+	}
+
+	s.MustClose()
+	fs.MustRemoveAll(path)
+}
+
+func TestGetUncoveredTimeRanges(t *testing.T) {
+	f := func(minTimestamp, maxTimestamp int64, trs, resultExpected []timeRange) {
+		t.Helper()
+
+		result := getUncoveredTimeRanges(minTimestamp, maxTimestamp, trs)
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result for [%d, %d] and %v; got %v; want %v", minTimestamp, maxTimestamp, trs, result, resultExpected)
+		}
+	}
+
+	// no covered time ranges
+	f(10, 20, nil, []timeRange{{10, 20}})
+
+	// the whole time range is covered
+	f(10, 20, []timeRange{{10, 20}}, nil)
+
+	// the head is covered
+	f(10, 20, []timeRange{{10, 14}}, []timeRange{{15, 20}})
+
+	// the tail is covered
+	f(10, 20, []timeRange{{15, 20}}, []timeRange{{10, 14}})
+
+	// the middle is covered
+	f(10, 20, []timeRange{{12, 13}, {16, 17}}, []timeRange{{10, 11}, {14, 15}, {18, 20}})
+
+	// adjacent covered time ranges
+	f(10, 20, []timeRange{{10, 12}, {13, 15}}, []timeRange{{16, 20}})
+}
+
+func TestGetFilterStringWithoutTimeRange(t *testing.T) {
+	f := func(qStr, resultExpected string, okExpected bool) {
+		t.Helper()
+
+		q := mustParseQuery(qStr)
+		result, ok := getFilterStringWithoutTimeRange(q.f)
+		if ok != okExpected {
+			t.Fatalf("unexpected ok for [%s]; got %v; want %v", qStr, ok, okExpected)
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected result for [%s]; got %q; want %q", qStr, result, resultExpected)
+		}
+	}
+
+	f(`*`, `*`, true)
+	f(`_time:5m`, ``, true)
+	f(`_time:5m foo`, `foo`, true)
+	f(`_time:[2024-01-01, 2024-01-02) foo _time:1h (bar or baz)`, `foo (bar or baz)`, true)
+	f(`foo or _time:5m`, ``, false)
+	f(`foo (bar or _time:5m)`, ``, false)
+	f(`foo !_time:5m`, ``, false)
+}
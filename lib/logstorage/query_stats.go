@@ -0,0 +1,167 @@
+package logstorage
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// queryStats contains execution stats for a single query.
+//
+// It is updated concurrently by search workers. All the methods are safe to call on nil queryStats.
+type queryStats struct {
+	// exec contains detailed execution stats for the query.
+	//
+	// It is nil unless the detailed stats are enabled via enableExecStats.
+	exec *queryExecStats
+
+	// rowsScanned is the number of rows in the scanned blocks
+	rowsScanned atomic.Uint64
+
+	// rowsMatched is the number of rows matching the query filters
+	rowsMatched atomic.Uint64
+
+	// bytesRead is the number of compressed bytes read from the storage during the query
+	bytesRead atomic.Uint64
+
+	// maxRowsScanned is the maximum number of rows, which can be scanned by the query. There is no limit if it is zero.
+	maxRowsScanned uint64
+
+	// maxBytesRead is the maximum number of compressed bytes, which can be read by the query. There is no limit if it is zero.
+	maxBytesRead uint64
+
+	// cancel is called when the query exceeds maxRowsScanned or maxBytesRead.
+	cancel func()
+
+	// limitErr is set to the error when the query exceeds maxRowsScanned or maxBytesRead.
+	limitErr atomic.Pointer[error]
+}
+
+// queryExecStats contains detailed execution stats for a single query.
+//
+// These stats are collected only when they are requested via query tracing,
+// since they aren't needed for the regular query execution.
+// See https://docs.victoriametrics.com/victorialogs/querying/#query-tracing
+type queryExecStats struct {
+	// blocksScanned is the number of blocks, which were matched against the query filters
+	blocksScanned atomic.Uint64
+
+	// blocksMatched is the number of scanned blocks with at least a single row matching the query filters
+	blocksMatched atomic.Uint64
+
+	// blocksSkipped is the number of blocks in the searched parts, which were skipped by the index without reading them
+	blocksSkipped atomic.Uint64
+
+	// bytesDecompressed is the number of bytes obtained after decompressing the data read from the storage
+	bytesDecompressed atomic.Uint64
+
+	// partsFromFieldsIndex is the number of parts, which results were obtained from their fields index without reading their blocks
+	partsFromFieldsIndex atomic.Uint64
+}
+
+func newQueryStats() *queryStats {
+	return &queryStats{}
+}
+
+// enableExecStats enables collecting the detailed execution stats for the query.
+//
+// It must be called before the query execution.
+func (qs *queryStats) enableExecStats() {
+	qs.exec = &queryExecStats{}
+}
+
+// getExecStats returns the detailed execution stats for the query.
+//
+// It returns zero stats if they aren't enabled via enableExecStats.
+func (qs *queryStats) getExecStats() *queryExecStats {
+	if qs.exec == nil {
+		return &queryExecStats{}
+	}
+	return qs.exec
+}
+
+// setScanLimits sets the limits on the number of scanned rows and read bytes for the query.
+//
+// cancel is called when the query exceeds the limits. The error for the exceeded limit can be obtained via getLimitErr then.
+func (qs *queryStats) setScanLimits(maxRowsScanned, maxBytesRead uint64, cancel func()) {
+	qs.maxRowsScanned = maxRowsScanned
+	qs.maxBytesRead = maxBytesRead
+	qs.cancel = cancel
+}
+
+// getLimitErr returns non-nil error if the query exceeds the limits set via setScanLimits.
+func (qs *queryStats) getLimitErr() error {
+	if qs == nil {
+		return nil
+	}
+	errP := qs.limitErr.Load()
+	if errP == nil {
+		return nil
+	}
+	return *errP
+}
+
+func (qs *queryStats) setLimitErr(err error) {
+	if qs.limitErr.CompareAndSwap(nil, &err) {
+		qs.cancel()
+	}
+}
+
+func (qs *queryStats) updateBlockStats(rowsScanned, rowsMatched int) {
+	if qs == nil {
+		return
+	}
+	n := qs.rowsScanned.Add(uint64(rowsScanned))
+	if qs.maxRowsScanned > 0 && n > qs.maxRowsScanned {
+		qs.setLimitErr(fmt.Errorf("the query scans more than %d rows; narrow down the time range with `_time` filter or add more specific filters "+
+			"in order to reduce the number of scanned rows; see https://docs.victoriametrics.com/victorialogs/querying/#query-scan-limits", qs.maxRowsScanned))
+	}
+	if rowsMatched > 0 {
+		qs.rowsMatched.Add(uint64(rowsMatched))
+	}
+
+	if qs.exec != nil {
+		qs.exec.blocksScanned.Add(1)
+		if rowsMatched > 0 {
+			qs.exec.blocksMatched.Add(1)
+		}
+	}
+}
+
+func (qs *queryStats) addBlocksSkipped(n uint64) {
+	if qs == nil || qs.exec == nil {
+		return
+	}
+	qs.exec.blocksSkipped.Add(n)
+}
+
+// isExecStatsEnabled returns true if the detailed execution stats are collected for the query.
+//
+// It may be used for avoiding the calculation of costly stats when they aren't needed.
+func (qs *queryStats) isExecStatsEnabled() bool {
+	return qs != nil && qs.exec != nil
+}
+
+func (qs *queryStats) addBytesDecompressed(n uint64) {
+	if qs == nil || qs.exec == nil {
+		return
+	}
+	qs.exec.bytesDecompressed.Add(n)
+}
+
+func (qs *queryStats) addPartsFromFieldsIndex(n uint64) {
+	if qs == nil || qs.exec == nil {
+		return
+	}
+	qs.exec.partsFromFieldsIndex.Add(n)
+}
+
+func (qs *queryStats) addBytesRead(n uint64) {
+	if qs == nil {
+		return
+	}
+	bytesRead := qs.bytesRead.Add(n)
+	if qs.maxBytesRead > 0 && bytesRead > qs.maxBytesRead {
+		qs.setLimitErr(fmt.Errorf("the query reads more than %d bytes from the storage; narrow down the time range with `_time` filter or add more specific filters "+
+			"in order to reduce the number of read bytes; see https://docs.victoriametrics.com/victorialogs/querying/#query-scan-limits", qs.maxBytesRead))
+	}
+}
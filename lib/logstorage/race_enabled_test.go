@@ -0,0 +1,6 @@
+//go:build race
+
+package logstorage
+
+// isRaceEnabled is set to true when the tests are run with -race flag.
+const isRaceEnabled = true
@@ -0,0 +1,778 @@
+package logstorage
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/slicesutil"
+)
+
+// RemoteStorageNode is a storage node, which executes queries sent by RunFederatedQuery.
+type RemoteStorageNode interface {
+	// String must return the name of the storage node. It is used in error messages.
+	String() string
+
+	// RunRemoteQuery must pass reqData to Storage.RunRemoteQuery at the storage node and return the response written by Storage.RunRemoteQuery.
+	//
+	// The caller must close the returned response when it is no longer needed.
+	RunRemoteQuery(ctx context.Context, reqData []byte) (io.ReadCloser, error)
+}
+
+// remoteQueryProtocolVersion is the version of the protocol between RunFederatedQuery and Storage.RunRemoteQuery.
+//
+// It must be incremented on every incompatible change in remoteQueryRequest or in the response frames.
+const remoteQueryProtocolVersion = 1
+
+// maxRemoteFrameSize is the maximum size of a single response frame written by Storage.RunRemoteQuery.
+const maxRemoteFrameSize = 1 << 30
+
+// The types of the response frames written by Storage.RunRemoteQuery.
+//
+// Every frame is written as varuint length followed by the frame type and the frame payload.
+// The response must end with either remoteFrameError or remoteFrameEnd frame.
+const (
+	// remoteFrameBlock contains zstd-compressed block of rows marshaled via marshalRemoteBlock.
+	remoteFrameBlock = byte(iota)
+
+	// remoteFrameStatsState contains the state of the last stats pipe obtained via pipeStatsProcessor.exportState.
+	remoteFrameStatsState
+
+	// remoteFrameError contains the error message for the failed query.
+	remoteFrameError
+
+	// remoteFrameEnd is written after all the query results are written.
+	remoteFrameEnd
+)
+
+// remoteQueryRequest is the request sent by RunFederatedQuery to remote storage nodes.
+type remoteQueryRequest struct {
+	tenantIDs []TenantID
+
+	// timestamp is the timestamp in nanoseconds for parsing the query, so relative time filters select the same time range at all the storage nodes.
+	timestamp int64
+
+	// query is the query to execute at the storage nodes.
+	query string
+
+	// exportStatsState is set to true if the storage nodes must return the state of the last stats pipe at the query instead of the calculated stats.
+	exportStatsState bool
+
+	maxMemory       int64
+	maxScannedRows  uint64
+	maxScannedBytes uint64
+}
+
+// marshal appends the marshaled rq to dst and returns the result.
+func (rq *remoteQueryRequest) marshal(dst []byte) []byte {
+	dst = encoding.MarshalVarUint64(dst, remoteQueryProtocolVersion)
+	dst = encoding.MarshalVarUint64(dst, uint64(len(rq.tenantIDs)))
+	for i := range rq.tenantIDs {
+		dst = rq.tenantIDs[i].marshal(dst)
+	}
+	dst = encoding.MarshalInt64(dst, rq.timestamp)
+	dst = encoding.MarshalBytes(dst, bytesutil.ToUnsafeBytes(rq.query))
+	dst = encoding.MarshalBool(dst, rq.exportStatsState)
+	dst = encoding.MarshalVarInt64(dst, rq.maxMemory)
+	dst = encoding.MarshalVarUint64(dst, rq.maxScannedRows)
+	dst = encoding.MarshalVarUint64(dst, rq.maxScannedBytes)
+	return dst
+}
+
+// unmarshal unmarshals rq from src.
+func (rq *remoteQueryRequest) unmarshal(src []byte) error {
+	version, n := encoding.UnmarshalVarUint64(src)
+	if n <= 0 {
+		return fmt.Errorf("cannot unmarshal protocol version")
+	}
+	src = src[n:]
+	if version != remoteQueryProtocolVersion {
+		return fmt.Errorf("unsupported protocol version %d; want %d; make sure all the VictoriaLogs instances in the cluster run the same version", version, remoteQueryProtocolVersion)
+	}
+
+	tenantIDsLen, n := encoding.UnmarshalVarUint64(src)
+	if n <= 0 {
+		return fmt.Errorf("cannot unmarshal the number of tenants")
+	}
+	src = src[n:]
+	if tenantIDsLen > uint64(len(src)/8) {
+		return fmt.Errorf("too big number of tenants: %d", tenantIDsLen)
+	}
+	rq.tenantIDs = make([]TenantID, tenantIDsLen)
+	for i := range rq.tenantIDs {
+		tail, err := rq.tenantIDs[i].unmarshal(src)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal tenant #%d: %w", i, err)
+		}
+		src = tail
+	}
+
+	if len(src) < 8 {
+		return fmt.Errorf("cannot unmarshal timestamp from %d bytes; need at least 8 bytes", len(src))
+	}
+	rq.timestamp = encoding.UnmarshalInt64(src)
+	src = src[8:]
+
+	query, n := encoding.UnmarshalBytes(src)
+	if n <= 0 {
+		return fmt.Errorf("cannot unmarshal query")
+	}
+	src = src[n:]
+	rq.query = string(query)
+
+	if len(src) < 1 {
+		return fmt.Errorf("cannot unmarshal exportStatsState")
+	}
+	rq.exportStatsState = encoding.UnmarshalBool(src)
+	src = src[1:]
+
+	maxMemory, n := encoding.UnmarshalVarInt64(src)
+	if n <= 0 {
+		return fmt.Errorf("cannot unmarshal maxMemory")
+	}
+	src = src[n:]
+	rq.maxMemory = maxMemory
+
+	maxScannedRows, n := encoding.UnmarshalVarUint64(src)
+	if n <= 0 {
+		return fmt.Errorf("cannot unmarshal maxScannedRows")
+	}
+	src = src[n:]
+	rq.maxScannedRows = maxScannedRows
+
+	maxScannedBytes, n := encoding.UnmarshalVarUint64(src)
+	if n <= 0 {
+		return fmt.Errorf("cannot unmarshal maxScannedBytes")
+	}
+	src = src[n:]
+	rq.maxScannedBytes = maxScannedBytes
+
+	if len(src) > 0 {
+		return fmt.Errorf("unexpected tail left after unmarshaling remote query request; len(tail)=%d", len(src))
+	}
+	return nil
+}
+
+// newRemoteQueryRequest returns the request for executing q at remote storage nodes together with the pipes,
+// which must be executed locally over the results returned from the storage nodes.
+//
+// The filter and the longest prefix of pipes, which process every row independently, are executed at the storage nodes.
+// The next stats, sort with limit, uniq and limit pipe is also executed at the storage nodes, and then it is executed
+// locally over the merged results from the storage nodes. The remaining pipes are executed locally.
+//
+// Only the filter is executed at the storage nodes if the deduplication of logs from replicas is enabled for q.
+func newRemoteQueryRequest(tenantIDs []TenantID, q *Query) (*remoteQueryRequest, []pipe, error) {
+	if hasFilterInWithQueryForFilter(q.f) || hasFilterInWithQueryForPipes(q.pipes) {
+		return nil, nil, fmt.Errorf("in(subquery) filters aren't supported in queries to multiple storage nodes; query: [%s]", q)
+	}
+	for _, p := range q.pipes {
+		switch p.(type) {
+		case *pipeJoin, *pipeUnion, *pipeStreamContext, *pipeBlockStats, *pipeBlocksCount:
+			return nil, nil, fmt.Errorf("[%s] pipe isn't supported in queries to multiple storage nodes; query: [%s]", p, q)
+		}
+	}
+
+	if q.dedupReplicas {
+		// The duplicate logs can be detected only by the original fields of the logs, so all the pipes are executed locally
+		// after the deduplication. The storage nodes return only the fields needed by the pipes plus the fields needed for the deduplication.
+		rq := newRemoteQueryRequestForPipes(tenantIDs, q, q.f.String(), q.pipes, replicasDedupFields)
+		return rq, q.pipes, nil
+	}
+
+	n := 0
+	for n < len(q.pipes) && q.pipes[n].canLiveTail() {
+		n++
+	}
+	remotePipes := make([]string, 0, n+2)
+	for _, p := range q.pipes[:n] {
+		remotePipes = append(remotePipes, p.String())
+	}
+	pipesLocal := q.pipes[n:]
+
+	exportStatsState := false
+	needFieldsPipe := len(pipesLocal) > 0
+	if len(pipesLocal) > 0 {
+		switch t := pipesLocal[0].(type) {
+		case *pipeStats:
+			remotePipes = append(remotePipes, t.String())
+			exportStatsState = true
+			needFieldsPipe = false
+		case *pipeSort:
+			if t.limit > 0 {
+				// Every storage node must return up to offset+limit rows, since the offset is applied to the merged results.
+				psRemote := &pipeSort{
+					byFields: t.byFields,
+					isDesc:   t.isDesc,
+					limit:    t.offset + t.limit,
+				}
+				remotePipes = append(remotePipes, psRemote.String())
+			}
+		case *pipeUniq:
+			if t.hitsFieldName == "" {
+				// The uniq pipe returns only the needed fields.
+				remotePipes = append(remotePipes, t.String())
+				needFieldsPipe = false
+			}
+		case *pipeLimit:
+			remotePipes = append(remotePipes, t.String())
+		}
+	}
+
+	query := q.f.String()
+	if len(remotePipes) > 0 {
+		query += " | " + strings.Join(remotePipes, " | ")
+	}
+	var pipesNeeded []pipe
+	if needFieldsPipe {
+		pipesNeeded = pipesLocal
+	}
+	rq := newRemoteQueryRequestForPipes(tenantIDs, q, query, pipesNeeded, nil)
+	rq.exportStatsState = exportStatsState
+	return rq, pipesLocal, nil
+}
+
+// newRemoteQueryRequestForPipes returns the request for executing the given query at remote storage nodes.
+//
+// If pipesLocal isn't empty, then the storage nodes return only the fields needed by pipesLocal plus extraFields.
+func newRemoteQueryRequestForPipes(tenantIDs []TenantID, q *Query, query string, pipesLocal []pipe, extraFields []string) *remoteQueryRequest {
+	if len(pipesLocal) > 0 || len(extraFields) > 0 {
+		// Return only the fields needed by local pipes from the storage nodes.
+		neededFields, unneededFields := getNeededFieldsForPipes(pipesLocal)
+		if !neededFields.contains("*") {
+			neededFields.addFields(extraFields)
+			if fields := neededFields.getAll(); len(fields) > 0 {
+				query += " | fields " + fieldNamesString(fields)
+			}
+		} else {
+			unneededFields.removeFields(extraFields)
+			if fields := unneededFields.getAllStrict(); len(fields) > 0 {
+				query += " | delete " + fieldNamesString(fields)
+			}
+		}
+	}
+
+	timestamp := q.timestamp
+	if timestamp == 0 {
+		timestamp = time.Now().UnixNano()
+	}
+
+	return &remoteQueryRequest{
+		tenantIDs:       tenantIDs,
+		timestamp:       timestamp,
+		query:           query,
+		maxMemory:       q.maxMemory,
+		maxScannedRows:  q.maxScannedRows,
+		maxScannedBytes: q.maxScannedBytes,
+	}
+}
+
+// RunFederatedQuery runs q at the given storage nodes sns and calls writeBlock for the merged results.
+//
+// The filter and the pipes, which can be executed independently at every storage node, are sent to sns,
+// while the remaining pipes are executed locally. The partial states for stats pipe are merged from all the storage nodes,
+// so the results are identical to the results of q executed over all the data from sns.
+// Queries with in(subquery) filters and with join, union, stream_context, block_stats and blocks_count pipes aren't supported.
+//
+// The limits on the memory usage and on the scanned data for q are applied to every storage node individually.
+//
+// If q.SetDedupReplicas(true) is called, then logs with the same _stream_id, _time and _msg returned from sns are passed to the pipes only once.
+// This is needed when the same logs are stored at multiple storage nodes (replicas). In this case only the filter is executed at sns,
+// while all the pipes are executed locally over the deduplicated logs.
+func RunFederatedQuery(ctx context.Context, sns []RemoteStorageNode, tenantIDs []TenantID, q *Query, writeBlock WriteBlockFunc) error {
+	qt := q.qt.NewChild("run query [%s] at %d storage nodes", q, len(sns))
+	defer qt.Done()
+
+	if len(sns) == 0 {
+		logger.Panicf("BUG: sns cannot be empty")
+	}
+
+	rq, pipesLocal, err := newRemoteQueryRequest(tenantIDs, q)
+	if err != nil {
+		return err
+	}
+	qt.Printf("send [%s] to storage nodes; exportStatsState=%v, dedupReplicas=%v", rq.query, rq.exportStatsState, q.dedupReplicas)
+
+	writeBlockResult := func(workerID uint, br *blockResult) {
+		if len(br.timestamps) == 0 {
+			return
+		}
+
+		brs := getBlockRows()
+		csDst := brs.cs
+
+		cs := br.getColumns()
+		for _, c := range cs {
+			values := c.getValues(br)
+			csDst = append(csDst, BlockColumn{
+				Name:   c.name,
+				Values: values,
+			})
+		}
+		writeBlock(workerID, br.timestamps, csDst)
+
+		brs.cs = csDst
+		putBlockRows(brs)
+	}
+
+	// Every storage node is read by a dedicated worker.
+	workersCount := len(sns)
+
+	var ppMain pipeProcessor = newDefaultPipeProcessor(writeBlockResult)
+	pp := ppMain
+	mb := newMemoryBudget(q.maxMemory)
+	cancels := make([]func(), len(pipesLocal))
+	pps := make([]pipeProcessor, len(pipesLocal))
+
+	// Every pipe gets a context canceled by the next pipe, while ctx remains the query context.
+	ctxPipe := ctx
+	stopCh := ctxPipe.Done()
+	for i := len(pipesLocal) - 1; i >= 0; i-- {
+		p := pipesLocal[i]
+		ctxChild, cancel := context.WithCancel(ctxPipe)
+		pp = p.newPipeProcessor(workersCount, stopCh, cancel, pp, mb)
+
+		stopCh = ctxChild.Done()
+		ctxPipe = ctxChild
+
+		cancels[i] = cancel
+		pps[i] = pp
+	}
+	if q.dedupReplicas {
+		ctxChild, cancel := context.WithCancel(ctxPipe)
+		pp = newReplicasDedupProcessor(workersCount, cancel, pp, mb)
+
+		ctxPipe = ctxChild
+
+		// The deduplication must be flushed before the local pipes.
+		cancels = append([]func(){cancel}, cancels...)
+		pps = append([]pipeProcessor{pp}, pps...)
+	}
+
+	var importState func(state []byte) error
+	if rq.exportStatsState {
+		psp, ok := pp.(*pipeStatsProcessor)
+		if !ok {
+			logger.Panicf("BUG: unexpected pipe processor for [%s]: %T; want *pipeStatsProcessor", pipesLocal[0], pp)
+		}
+		var importStateLock sync.Mutex
+		importState = func(state []byte) error {
+			// psp.importState cannot be called concurrently.
+			importStateLock.Lock()
+			defer importStateLock.Unlock()
+
+			return psp.importState(state)
+		}
+	}
+
+	reqData := rq.marshal(nil)
+	ctxSearch, cancelSearch := context.WithCancel(ctxPipe)
+	defer cancelSearch()
+
+	errs := make([]error, len(sns))
+	var wg sync.WaitGroup
+	for i, sn := range sns {
+		wg.Add(1)
+		go func(workerID uint) {
+			defer wg.Done()
+
+			err := readRemoteQueryResponse(ctxSearch, sn, reqData, workerID, pp, importState)
+			if err == nil || ctxSearch.Err() != nil {
+				// Errors are expected when the query is stopped by the local pipes, e.g. after the limit is reached,
+				// or when the query is stopped because of the error at another storage node.
+				return
+			}
+			errs[workerID] = fmt.Errorf("cannot execute query at storage node %s: %w", sn, err)
+
+			// Stop the query at the remaining storage nodes, since the results are incomplete.
+			cancelSearch()
+		}(uint(i))
+	}
+	wg.Wait()
+	qt.Printf("read the results from %d storage nodes", len(sns))
+
+	var errFlush error
+	for i, pp := range pps {
+		if err := pp.flush(); err != nil && errFlush == nil {
+			errFlush = err
+		}
+		cancel := cancels[i]
+		cancel()
+	}
+	if err := ppMain.flush(); err != nil && errFlush == nil {
+		errFlush = err
+	}
+
+	if err := ctx.Err(); errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("query exceeded timeout: %w", err)
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return errFlush
+}
+
+// readRemoteQueryResponse executes the query from reqData at sn and passes the returned results to pp.
+//
+// The returned stats states are passed to importState if it isn't nil.
+func readRemoteQueryResponse(ctx context.Context, sn RemoteStorageNode, reqData []byte, workerID uint, pp pipeProcessor, importState func(state []byte) error) error {
+	r, err := sn.RunRemoteQuery(ctx, reqData)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	bufr := bufio.NewReaderSize(r, 64*1024)
+	br := getBlockResult()
+	defer putBlockResult(br)
+
+	var frame, blockData []byte
+	var rcs []resultColumn
+	for {
+		frameLen, err := binary.ReadUvarint(bufr)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("unexpected end of response")
+			}
+			return fmt.Errorf("cannot read frame length: %w", err)
+		}
+		if frameLen == 0 || frameLen > maxRemoteFrameSize {
+			return fmt.Errorf("unexpected frame length: %d; it must be in the range [1..%d]", frameLen, maxRemoteFrameSize)
+		}
+		frame = slices.Grow(frame[:0], int(frameLen))[:frameLen]
+		if _, err := io.ReadFull(bufr, frame); err != nil {
+			return fmt.Errorf("cannot read frame with length %d: %w", frameLen, err)
+		}
+
+		frameType, payload := frame[0], frame[1:]
+		switch frameType {
+		case remoteFrameBlock:
+			if importState != nil {
+				return fmt.Errorf("unexpected block of rows in the response; want stats state")
+			}
+			blockData, err = encoding.DecompressZSTD(blockData[:0], payload)
+			if err != nil {
+				return fmt.Errorf("cannot decompress block of rows: %w", err)
+			}
+			var rowsCount int
+			rcs, rowsCount, err = unmarshalRemoteBlock(rcs[:0], blockData)
+			if err != nil {
+				return fmt.Errorf("cannot unmarshal block of rows: %w", err)
+			}
+			br.setResultColumns(rcs, rowsCount)
+			pp.writeBlock(workerID, br)
+		case remoteFrameStatsState:
+			if importState == nil {
+				return fmt.Errorf("unexpected stats state in the response; want blocks of rows")
+			}
+			if err := importState(payload); err != nil {
+				return fmt.Errorf("cannot import stats state: %w", err)
+			}
+		case remoteFrameError:
+			return fmt.Errorf("%s", payload)
+		case remoteFrameEnd:
+			return nil
+		default:
+			return fmt.Errorf("unexpected frame type: %d", frameType)
+		}
+	}
+}
+
+// marshalRemoteBlock appends the marshaled rows from br to dst and returns the result.
+func marshalRemoteBlock(dst []byte, br *blockResult) []byte {
+	rowsCount := len(br.timestamps)
+	dst = encoding.MarshalVarUint64(dst, uint64(rowsCount))
+
+	cs := br.getColumns()
+	dst = encoding.MarshalVarUint64(dst, uint64(len(cs)))
+	for _, c := range cs {
+		dst = encoding.MarshalBytes(dst, bytesutil.ToUnsafeBytes(c.name))
+		values := c.getValues(br)
+		if areConstValues(values) {
+			dst = encoding.MarshalBool(dst, true)
+			dst = encoding.MarshalBytes(dst, bytesutil.ToUnsafeBytes(values[0]))
+			continue
+		}
+		dst = encoding.MarshalBool(dst, false)
+		for _, v := range values {
+			dst = encoding.MarshalBytes(dst, bytesutil.ToUnsafeBytes(v))
+		}
+	}
+	return dst
+}
+
+// unmarshalRemoteBlock unmarshals the block marshaled via marshalRemoteBlock from src.
+//
+// It appends the unmarshaled columns to dst and returns the result together with the number of rows in the block.
+// The returned columns refer to src.
+func unmarshalRemoteBlock(dst []resultColumn, src []byte) ([]resultColumn, int, error) {
+	rowsCount, n := encoding.UnmarshalVarUint64(src)
+	if n <= 0 {
+		return dst, 0, fmt.Errorf("cannot unmarshal the number of rows")
+	}
+	src = src[n:]
+	if rowsCount > maxRemoteFrameSize {
+		return dst, 0, fmt.Errorf("too big number of rows: %d", rowsCount)
+	}
+
+	columnsCount, n := encoding.UnmarshalVarUint64(src)
+	if n <= 0 {
+		return dst, 0, fmt.Errorf("cannot unmarshal the number of columns")
+	}
+	src = src[n:]
+	if columnsCount > uint64(len(src)) {
+		return dst, 0, fmt.Errorf("too big number of columns: %d", columnsCount)
+	}
+
+	for i := uint64(0); i < columnsCount; i++ {
+		name, n := encoding.UnmarshalBytes(src)
+		if n <= 0 {
+			return dst, 0, fmt.Errorf("cannot unmarshal the name for column #%d", i)
+		}
+		src = src[n:]
+
+		if len(src) < 1 {
+			return dst, 0, fmt.Errorf("cannot unmarshal the const flag for column %q", name)
+		}
+		isConst := encoding.UnmarshalBool(src)
+		src = src[1:]
+
+		dst = slicesutil.SetLength(dst, len(dst)+1)
+		rc := &dst[len(dst)-1]
+		rc.name = bytesutil.ToUnsafeString(name)
+		rc.resetValues()
+
+		if isConst {
+			v, n := encoding.UnmarshalBytes(src)
+			if n <= 0 {
+				return dst, 0, fmt.Errorf("cannot unmarshal the value for const column %q", name)
+			}
+			src = src[n:]
+			rc.values = slicesutil.SetLength(rc.values, int(rowsCount))
+			for j := range rc.values {
+				rc.values[j] = bytesutil.ToUnsafeString(v)
+			}
+			continue
+		}
+
+		for j := uint64(0); j < rowsCount; j++ {
+			v, n := encoding.UnmarshalBytes(src)
+			if n <= 0 {
+				return dst, 0, fmt.Errorf("cannot unmarshal value #%d for column %q", j, name)
+			}
+			src = src[n:]
+			rc.addValue(bytesutil.ToUnsafeString(v))
+		}
+	}
+	if len(src) > 0 {
+		return dst, 0, fmt.Errorf("unexpected tail left after unmarshaling block of rows; len(tail)=%d", len(src))
+	}
+	return dst, int(rowsCount), nil
+}
+
+// RunRemoteQuery runs the query from reqData sent by RunFederatedQuery via RemoteStorageNode and writes the response to w.
+//
+// An error is returned if reqData cannot be parsed. Errors occurred during the query execution are written to w,
+// so they are returned from RunFederatedQuery.
+func (s *Storage) RunRemoteQuery(ctx context.Context, reqData []byte, w io.Writer) error {
+	var rq remoteQueryRequest
+	if err := rq.unmarshal(reqData); err != nil {
+		return fmt.Errorf("cannot unmarshal remote query request: %w", err)
+	}
+	q, err := parseQueryAtTimestamp(rq.query, rq.timestamp)
+	if err != nil {
+		return fmt.Errorf("cannot parse remote query [%s]: %w", rq.query, err)
+	}
+	q.maxMemory = rq.maxMemory
+	q.maxScannedRows = rq.maxScannedRows
+	q.maxScannedBytes = rq.maxScannedBytes
+
+	ctxWrite, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rw := &remoteQueryResponseWriter{
+		w:      w,
+		cancel: cancel,
+	}
+
+	if rq.exportStatsState {
+		var ps *pipeStats
+		if len(q.pipes) > 0 {
+			ps, _ = q.pipes[len(q.pipes)-1].(*pipeStats)
+		}
+		if ps == nil {
+			return fmt.Errorf("remote query [%s] must end with stats pipe", q)
+		}
+		q.pipes[len(q.pipes)-1] = &pipeStatsRemote{
+			ps:         ps,
+			writeState: rw.writeStatsState,
+		}
+	}
+
+	// Do not use s.RunQuery(), since the results of the remote query mustn't be cached.
+	err = s.runQuery(ctxWrite, nil, rq.tenantIDs, q, rw.writeBlock)
+	if err == nil && ctx.Err() != nil {
+		// The results are incomplete, so they cannot be marked as complete via remoteFrameEnd.
+		err = fmt.Errorf("the query has been canceled at the storage node: %w", ctx.Err())
+	}
+	if err != nil {
+		rw.writeFrame(remoteFrameError, bytesutil.ToUnsafeBytes(err.Error()))
+	} else {
+		rw.writeFrame(remoteFrameEnd, nil)
+	}
+	return nil
+}
+
+// remoteQueryResponseWriter writes the response for Storage.RunRemoteQuery.
+type remoteQueryResponseWriter struct {
+	w io.Writer
+
+	// cancel stops the query if the response cannot be written.
+	cancel func()
+
+	// mu protects the fields below, since writeBlock is called concurrently.
+	mu sync.Mutex
+
+	// buf is a scratch buffer for the frame header.
+	buf []byte
+
+	// err is the first error occurred when writing the response.
+	err error
+}
+
+func (rw *remoteQueryResponseWriter) writeBlock(_ uint, br *blockResult) {
+	if len(br.timestamps) == 0 {
+		return
+	}
+
+	bb := bbPool.Get()
+	bb.B = marshalRemoteBlock(bb.B[:0], br)
+
+	bbCompressed := bbPool.Get()
+	bbCompressed.B = encoding.CompressZSTDLevel(bbCompressed.B[:0], bb.B, 1)
+	bbPool.Put(bb)
+
+	rw.writeFrame(remoteFrameBlock, bbCompressed.B)
+	bbPool.Put(bbCompressed)
+}
+
+func (rw *remoteQueryResponseWriter) writeStatsState(state []byte) error {
+	rw.writeFrame(remoteFrameStatsState, state)
+
+	rw.mu.Lock()
+	err := rw.err
+	rw.mu.Unlock()
+
+	return err
+}
+
+func (rw *remoteQueryResponseWriter) writeFrame(frameType byte, payload []byte) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.err != nil {
+		return
+	}
+	if len(payload)+1 > maxRemoteFrameSize {
+		rw.err = fmt.Errorf("too big frame with %d bytes; it mustn't exceed %d bytes", len(payload)+1, maxRemoteFrameSize)
+		rw.cancel()
+		return
+	}
+
+	rw.buf = encoding.MarshalVarUint64(rw.buf[:0], uint64(len(payload)+1))
+	rw.buf = append(rw.buf, frameType)
+	if _, err := rw.w.Write(rw.buf); err != nil {
+		rw.err = fmt.Errorf("cannot write response: %w", err)
+		rw.cancel()
+		return
+	}
+	if _, err := rw.w.Write(payload); err != nil {
+		rw.err = fmt.Errorf("cannot write response: %w", err)
+		rw.cancel()
+	}
+}
+
+// pipeStatsRemote is the stats pipe executed at the storage node for RunFederatedQuery.
+//
+// It passes the state of the stats pipe to writeState instead of writing the calculated stats to the next pipe,
+// so the states from all the storage nodes can be merged by RunFederatedQuery.
+type pipeStatsRemote struct {
+	ps *pipeStats
+
+	writeState func(state []byte) error
+}
+
+func (psr *pipeStatsRemote) String() string {
+	return psr.ps.String()
+}
+
+func (psr *pipeStatsRemote) canLiveTail() bool {
+	return false
+}
+
+func (psr *pipeStatsRemote) updateNeededFields(neededFields, unneededFields *fieldsSet) {
+	psr.ps.updateNeededFields(neededFields, unneededFields)
+}
+
+func (psr *pipeStatsRemote) newPipeProcessor(workersCount int, stopCh <-chan struct{}, cancel func(), _ pipeProcessor, mb *memoryBudget) pipeProcessor {
+	// The state is exported from psp instead of flushing it to the next pipe, so the next pipe isn't needed.
+	psp := psr.ps.newPipeProcessor(workersCount, stopCh, cancel, nil, mb).(*pipeStatsProcessor)
+	return &pipeStatsRemoteProcessor{
+		psr: psr,
+		psp: psp,
+	}
+}
+
+func (psr *pipeStatsRemote) optimize() {
+	psr.ps.optimize()
+}
+
+func (psr *pipeStatsRemote) hasFilterInWithQuery() bool {
+	return psr.ps.hasFilterInWithQuery()
+}
+
+func (psr *pipeStatsRemote) initFilterInValues(cache map[string][]string, getFieldValuesFunc getFieldValuesFunc) (pipe, error) {
+	psNew, err := psr.ps.initFilterInValues(cache, getFieldValuesFunc)
+	if err != nil {
+		return nil, err
+	}
+	psrNew := &pipeStatsRemote{
+		ps:         psNew.(*pipeStats),
+		writeState: psr.writeState,
+	}
+	return psrNew, nil
+}
+
+type pipeStatsRemoteProcessor struct {
+	psr *pipeStatsRemote
+	psp *pipeStatsProcessor
+}
+
+func (psp *pipeStatsRemoteProcessor) writeBlock(workerID uint, br *blockResult) {
+	psp.psp.writeBlock(workerID, br)
+}
+
+func (psp *pipeStatsRemoteProcessor) flush() error {
+	pmb := psp.psp.pmb
+	defer pmb.release()
+
+	state, ok := psp.psp.exportState()
+	if !ok {
+		if culprit := pmb.culprit(); culprit != pmb {
+			// Another pipe exceeded the memory limit.
+			return culprit.limitError()
+		}
+		return fmt.Errorf("cannot export the state for [%s], since it requires more than %dMB of memory", psp.psr, pmb.mb.maxSize/(1<<20))
+	}
+	return psp.psr.writeState(state)
+}
@@ -0,0 +1,170 @@
+package logstorage
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// replicasDedupFields contains the fields, which must be returned from the storage nodes for the deduplication of logs from replicas.
+var replicasDedupFields = []string{"_stream_id", "_time", "_msg"}
+
+// replicasDedupKey is the key for detecting duplicate logs returned from replicas.
+//
+// Logs with the same _stream_id, _time and _msg are considered duplicates.
+type replicasDedupKey struct {
+	// streamTimeHash is the hash of _stream_id and _time values for the log.
+	streamTimeHash uint64
+
+	// msgHash is the hash of _msg value for the log.
+	msgHash uint64
+}
+
+// replicasDedupProcessor removes duplicate logs returned from replicas by RunFederatedQuery before passing them to ppNext.
+type replicasDedupProcessor struct {
+	cancel func()
+	ppNext pipeProcessor
+
+	shards []replicasDedupProcessorShard
+
+	pmb *pipeMemoryBudget
+
+	// mu protects m and stateSizeBudget, since the same log may be returned from multiple storage nodes,
+	// which are read concurrently by distinct workers.
+	mu sync.Mutex
+
+	// m contains keys for the already seen logs.
+	m map[replicasDedupKey]struct{}
+
+	// stateSizeBudget is the remaining budget for the size of m.
+	// The budget is provided in chunks from pmb.
+	stateSizeBudget int
+}
+
+// replicasDedupName is used instead of the pipe name in error messages when the deduplication of logs from replicas exceeds the memory limit.
+type replicasDedupName struct{}
+
+func (replicasDedupName) String() string {
+	return "deduplication of logs from replicas"
+}
+
+type replicasDedupProcessorShard struct {
+	replicasDedupProcessorShardNopad
+
+	// The padding prevents false sharing on widespread platforms with 128 mod (cache line size) = 0 .
+	_ [128 - unsafe.Sizeof(replicasDedupProcessorShardNopad{})%128]byte
+}
+
+type replicasDedupProcessorShardNopad struct {
+	br blockResult
+	bm bitmap
+
+	// keys is a temporary buffer for the keys of the processed block.
+	keys []replicasDedupKey
+
+	// keyBuf is a temporary buffer for calculating replicasDedupKey.streamTimeHash.
+	keyBuf []byte
+}
+
+func newReplicasDedupProcessor(workersCount int, cancel func(), ppNext pipeProcessor, mb *memoryBudget) *replicasDedupProcessor {
+	return &replicasDedupProcessor{
+		cancel: cancel,
+		ppNext: ppNext,
+
+		shards: make([]replicasDedupProcessorShard, workersCount),
+
+		pmb: mb.newPipeMemoryBudget(replicasDedupName{}),
+
+		m: make(map[replicasDedupKey]struct{}),
+	}
+}
+
+func (rdp *replicasDedupProcessor) writeBlock(workerID uint, br *blockResult) {
+	if len(br.timestamps) == 0 {
+		return
+	}
+
+	shard := &rdp.shards[workerID]
+
+	streamIDs := br.getColumnByName("_stream_id").getValues(br)
+	times := br.getColumnByName("_time").getValues(br)
+	msgs := br.getColumnByName("_msg").getValues(br)
+
+	keys := shard.keys[:0]
+	for i := range br.timestamps {
+		shard.keyBuf = append(shard.keyBuf[:0], streamIDs[i]...)
+		shard.keyBuf = append(shard.keyBuf, 0)
+		shard.keyBuf = append(shard.keyBuf, times[i]...)
+		keys = append(keys, replicasDedupKey{
+			streamTimeHash: xxhash.Sum64(shard.keyBuf),
+			msgHash:        xxhash.Sum64String(msgs[i]),
+		})
+	}
+	shard.keys = keys
+
+	bm := &shard.bm
+	bm.init(len(br.timestamps))
+	bm.resetBits()
+
+	if !rdp.updateState(keys, bm) {
+		// The state size is too big. Stop processing data in order to avoid OOM crash.
+		rdp.cancel()
+		return
+	}
+
+	if bm.areAllBitsSet() {
+		// Fast path - there are no duplicates - send br to the next pipe as is.
+		rdp.ppNext.writeBlock(workerID, br)
+		return
+	}
+	if bm.isZero() {
+		// All the logs are duplicates
+		return
+	}
+
+	// Slow path - copy the remaining logs from br to shard.br before sending them to the next pipe.
+	shard.br.initFromFilterAllColumns(br, bm)
+	rdp.ppNext.writeBlock(workerID, &shard.br)
+}
+
+// updateState registers keys at rdp and sets bits at bm for the keys, which haven't been registered yet.
+//
+// It returns false if the state size exceeds the memory budget.
+func (rdp *replicasDedupProcessor) updateState(keys []replicasDedupKey, bm *bitmap) bool {
+	rdp.mu.Lock()
+	defer rdp.mu.Unlock()
+
+	for rdp.stateSizeBudget < 0 {
+		// steal some budget for the state size from the global budget.
+		if rdp.pmb.isExceeded() || !rdp.pmb.borrow(stateSizeBudgetChunk) {
+			return false
+		}
+		rdp.stateSizeBudget += stateSizeBudgetChunk
+	}
+
+	m := rdp.m
+	for i, k := range keys {
+		if _, ok := m[k]; ok {
+			continue
+		}
+		m[k] = struct{}{}
+		bm.setBit(i)
+		rdp.stateSizeBudget -= int(unsafe.Sizeof(k))
+	}
+	return true
+}
+
+func (rdp *replicasDedupProcessor) flush() error {
+	defer rdp.pmb.release()
+
+	if rdp.pmb.isExceeded() {
+		if culprit := rdp.pmb.culprit(); culprit != rdp.pmb {
+			// Another pipe exceeded the memory limit.
+			return culprit.limitError()
+		}
+		return fmt.Errorf("cannot deduplicate logs from replicas, since it requires more than %dMB of memory", rdp.pmb.mb.maxSize/(1<<20))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package logstorage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestRunFederatedQueryDedupReplicas(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	sc := &StorageConfig{
+		Retention: 24 * time.Hour,
+	}
+	sAll := MustOpenStorage(path+"/all", sc)
+	sns := []RemoteStorageNode{
+		&testRemoteStorageNode{
+			name: "replica-0",
+			s:    MustOpenStorage(path+"/replica-0", sc),
+		},
+		&testRemoteStorageNode{
+			name: "replica-1",
+			s:    MustOpenStorage(path+"/replica-1", sc),
+		},
+		&testRemoteStorageNode{
+			name: "replica-2",
+			s:    MustOpenStorage(path+"/replica-2", sc),
+		},
+	}
+
+	tenantID := TenantID{
+		AccountID: 1,
+		ProjectID: 2,
+	}
+	baseTimestamp := time.Now().UnixNano() - 3600*1e9
+	for i := 0; i < 1000; i++ {
+		fields := []Field{
+			{
+				Name:  "host",
+				Value: fmt.Sprintf("host-%d", i%7),
+			},
+			{
+				Name:  "_msg",
+				Value: fmt.Sprintf("message #%d", i),
+			},
+			{
+				Name:  "n",
+				Value: fmt.Sprintf("%d", i),
+			},
+		}
+		timestamp := baseTimestamp + int64(i)*1e9
+
+		lr := GetLogRows([]string{"host"}, nil)
+		lr.MustAdd(tenantID, timestamp, fields)
+		sAll.MustAddRows(lr)
+
+		// Every log is stored at two replicas out of three.
+		for j := 0; j < 2; j++ {
+			lr.ResetKeepSettings()
+			lr.MustAdd(tenantID, timestamp, fields)
+			sns[(i+j)%len(sns)].(*testRemoteStorageNode).s.MustAddRows(lr)
+		}
+		PutLogRows(lr)
+	}
+	sAll.debugFlush()
+	for _, sn := range sns {
+		sn.(*testRemoteStorageNode).s.debugFlush()
+	}
+
+	runQuery := func(qStr string, runFunc func(q *Query, writeBlock WriteBlockFunc) error) []string {
+		t.Helper()
+
+		q := mustParseQuery(qStr)
+
+		var rows []string
+		var rowsLock sync.Mutex
+		writeBlock := func(_ uint, timestamps []int64, columns []BlockColumn) {
+			rowsLock.Lock()
+			defer rowsLock.Unlock()
+
+			for i := range timestamps {
+				a := make([]string, len(columns))
+				for j, c := range columns {
+					a[j] = fmt.Sprintf("%s=%q", c.Name, c.Values[i])
+				}
+				sort.Strings(a)
+				rows = append(rows, strings.Join(a, ","))
+			}
+		}
+		if err := runFunc(q, writeBlock); err != nil {
+			t.Fatalf("unexpected error for [%s]: %s", qStr, err)
+		}
+		sort.Strings(rows)
+		return rows
+	}
+
+	f := func(qStr string) {
+		t.Helper()
+
+		rowsExpected := runQuery(qStr, func(q *Query, writeBlock WriteBlockFunc) error {
+			return sAll.RunQuery(context.Background(), []TenantID{tenantID}, q, writeBlock)
+		})
+		rows := runQuery(qStr, func(q *Query, writeBlock WriteBlockFunc) error {
+			q.SetDedupReplicas(true)
+			return RunFederatedQuery(context.Background(), sns, []TenantID{tenantID}, q, writeBlock)
+		})
+		if len(rows) == 0 {
+			t.Fatalf("expecting non-empty results for [%s]", qStr)
+		}
+		if !reflect.DeepEqual(rows, rowsExpected) {
+			t.Fatalf("unexpected results for [%s]\ngot\n%s\nwant\n%s", qStr, strings.Join(rows, "\n"), strings.Join(rowsExpected, "\n"))
+		}
+	}
+
+	f(`message`)
+	f(`host:host-3 | fields host, n`)
+	f(`* | stats count() hits`)
+	f(`* | stats by (host) count() hits, sum(n) sum_n, count_uniq(n) uniq_n`)
+	f(`* | sort by (n) desc limit 5`)
+	f(`* | uniq by (host) with hits`)
+	f(`* | delete _msg | limit 1000 | stats count() hits`)
+	f(`* | field_names`)
+
+	// Verify that duplicate logs are returned without the deduplication
+	rows := runQuery(`* | stats count() hits`, func(q *Query, writeBlock WriteBlockFunc) error {
+		return RunFederatedQuery(context.Background(), sns, []TenantID{tenantID}, q, writeBlock)
+	})
+	if want := []string{`hits="2000"`}; !reflect.DeepEqual(rows, want) {
+		t.Fatalf("unexpected results without the deduplication; got %q; want %q", rows, want)
+	}
+
+	// Verify that the memory limit is applied to the deduplication
+	q := mustParseQuery(`message`)
+	q.SetDedupReplicas(true)
+	q.SetMaxMemory(1)
+	err := RunFederatedQuery(context.Background(), sns, []TenantID{tenantID}, q, func(_ uint, _ []int64, _ []BlockColumn) {})
+	if err == nil {
+		t.Fatalf("expecting non-nil error when the deduplication exceeds the memory limit")
+	}
+	if !strings.Contains(err.Error(), "cannot deduplicate logs from replicas") {
+		t.Fatalf("unexpected error when the deduplication exceeds the memory limit: %s", err)
+	}
+
+	sAll.MustClose()
+	for _, sn := range sns {
+		sn.(*testRemoteStorageNode).s.MustClose()
+	}
+
+	fs.MustRemoveAll(path)
+}
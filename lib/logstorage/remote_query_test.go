@@ -0,0 +1,333 @@
+package logstorage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestRemoteQueryRequestMarshalUnmarshal(t *testing.T) {
+	f := func(rq *remoteQueryRequest) {
+		t.Helper()
+
+		data := rq.marshal(nil)
+		var rqUnmarshaled remoteQueryRequest
+		if err := rqUnmarshaled.unmarshal(data); err != nil {
+			t.Fatalf("cannot unmarshal remote query request: %s", err)
+		}
+		if !reflect.DeepEqual(&rqUnmarshaled, rq) {
+			t.Fatalf("unexpected unmarshaled request\ngot\n%#v\nwant\n%#v", &rqUnmarshaled, rq)
+		}
+
+		// Verify that truncated requests are rejected
+		for i := 0; i < len(data); i++ {
+			var rqBroken remoteQueryRequest
+			if err := rqBroken.unmarshal(data[:i]); err == nil {
+				t.Fatalf("expecting non-nil error when unmarshaling request truncated to %d bytes out of %d bytes", i, len(data))
+			}
+		}
+		var rqBroken remoteQueryRequest
+		if err := rqBroken.unmarshal(append(data, 'x')); err == nil {
+			t.Fatalf("expecting non-nil error when unmarshaling request with unexpected tail")
+		}
+	}
+
+	f(&remoteQueryRequest{
+		tenantIDs: []TenantID{},
+		query:     "*",
+	})
+	f(&remoteQueryRequest{
+		tenantIDs: []TenantID{
+			{
+				AccountID: 12,
+				ProjectID: 34,
+			},
+			{
+				AccountID: 0,
+				ProjectID: 5,
+			},
+		},
+		timestamp:        1234567890,
+		query:            "error | stats by (host) count(*) as hits",
+		exportStatsState: true,
+		maxMemory:        1 << 20,
+		maxScannedRows:   1000,
+		maxScannedBytes:  123456,
+	})
+}
+
+func TestNewRemoteQueryRequest(t *testing.T) {
+	f := func(qStr, remoteQueryExpected string, exportStatsStateExpected bool, localPipesExpected string) {
+		t.Helper()
+
+		q := mustParseQuery(qStr)
+		rq, pipesLocal, err := newRemoteQueryRequest(nil, q)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if rq.query != remoteQueryExpected {
+			t.Fatalf("unexpected remote query\ngot\n%s\nwant\n%s", rq.query, remoteQueryExpected)
+		}
+		if rq.exportStatsState != exportStatsStateExpected {
+			t.Fatalf("unexpected exportStatsState; got %v; want %v", rq.exportStatsState, exportStatsStateExpected)
+		}
+		a := make([]string, len(pipesLocal))
+		for i, p := range pipesLocal {
+			a[i] = p.String()
+		}
+		if localPipes := strings.Join(a, " | "); localPipes != localPipesExpected {
+			t.Fatalf("unexpected local pipes\ngot\n%s\nwant\n%s", localPipes, localPipesExpected)
+		}
+		if rq.timestamp != q.timestamp {
+			t.Fatalf("unexpected timestamp; got %d; want %d", rq.timestamp, q.timestamp)
+		}
+	}
+
+	// no pipes
+	f(`error`, `error`, false, ``)
+
+	// pipes, which can be executed at storage nodes
+	f(`error | fields a, b | extract "x=<x>" from a`, `error | fields a, b | extract "x=<x>" from a`, false, ``)
+
+	// stats pipe
+	f(`error | stats by (host) count() hits | sort by (hits) desc`, `error | stats by (host) count(*) as hits`, true, `stats by (host) count(*) as hits | sort by (hits) desc`)
+	f(`error | copy a b | stats sum(b)`, `error | copy a as b | stats sum(b) as "sum(b)"`, true, `stats sum(b) as "sum(b)"`)
+
+	// sort pipe with limit
+	f(`error | sort by (_time) desc offset 10 limit 5`, `error | sort by (_time) desc limit 15`, false, `sort by (_time) desc offset 10 limit 5`)
+	f(`error | sort by (x) limit 5 rank as r | fields x, r`, `error | sort by (x) limit 5 | fields _time, x`, false, `sort by (x) limit 5 rank as r | fields x, r`)
+
+	// sort pipe without limit
+	f(`error | sort by (x) | fields x, y`, `error | fields _time, x, y`, false, `sort by (x) | fields x, y`)
+
+	// uniq pipe
+	f(`error | uniq by (host) limit 10`, `error | uniq by (host) limit 10`, false, `uniq by (host) limit 10`)
+	f(`error | uniq by (host) with hits`, `error | fields host`, false, `uniq by (host) with hits`)
+
+	// limit pipe
+	f(`error | limit 10 | delete x`, `error | limit 10 | delete x`, false, `limit 10 | delete x`)
+
+	// other pipes are executed locally
+	f(`error | top 5 by (host)`, `error | fields host`, false, `top 5 by (host)`)
+	f(`error | field_names`, `error`, false, `field_names`)
+}
+
+func TestNewRemoteQueryRequestDedupReplicas(t *testing.T) {
+	f := func(qStr, remoteQueryExpected string) {
+		t.Helper()
+
+		q := mustParseQuery(qStr)
+		q.SetDedupReplicas(true)
+		rq, pipesLocal, err := newRemoteQueryRequest(nil, q)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if rq.query != remoteQueryExpected {
+			t.Fatalf("unexpected remote query\ngot\n%s\nwant\n%s", rq.query, remoteQueryExpected)
+		}
+		if rq.exportStatsState {
+			t.Fatalf("exportStatsState must be disabled when the deduplication of logs from replicas is enabled")
+		}
+
+		// All the pipes must be executed locally
+		if !reflect.DeepEqual(pipesLocal, q.pipes) {
+			t.Fatalf("unexpected local pipes; got %v; want %v", pipesLocal, q.pipes)
+		}
+	}
+
+	f(`error`, `error`)
+	f(`error | delete _msg, x`, `error | delete x`)
+	f(`error | fields a, b | extract "x=<x>" from a`, `error | fields _msg, _stream_id, _time, a, b`)
+	f(`error | stats by (host) count() hits`, `error | fields _msg, _stream_id, _time, host`)
+	f(`error | sort by (_time) desc limit 5`, `error`)
+}
+
+func TestNewRemoteQueryRequestFailure(t *testing.T) {
+	f := func(qStr string) {
+		t.Helper()
+
+		q := mustParseQuery(qStr)
+		if _, _, err := newRemoteQueryRequest(nil, q); err == nil {
+			t.Fatalf("expecting non-nil error for [%s]", qStr)
+		}
+	}
+
+	f(`host:in(error | fields host)`)
+	f(`* | filter host:in(error | fields host)`)
+	f(`* | join by (host) (error | stats by (host) count() errors)`)
+	f(`* | union (error)`)
+	f(`* | stream_context before 10`)
+	f(`* | block_stats`)
+	f(`* | blocks_count`)
+}
+
+// testRemoteStorageNode executes remote queries at the in-process storage.
+type testRemoteStorageNode struct {
+	name string
+	s    *Storage
+}
+
+func (sn *testRemoteStorageNode) String() string {
+	return sn.name
+}
+
+func (sn *testRemoteStorageNode) RunRemoteQuery(ctx context.Context, reqData []byte) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		err := sn.s.RunRemoteQuery(ctx, reqData, pw)
+		_ = pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+func TestRunFederatedQuery(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	sc := &StorageConfig{
+		Retention: 24 * time.Hour,
+	}
+	sAll := MustOpenStorage(path+"/all", sc)
+	sns := []RemoteStorageNode{
+		&testRemoteStorageNode{
+			name: "node-0",
+			s:    MustOpenStorage(path+"/node-0", sc),
+		},
+		&testRemoteStorageNode{
+			name: "node-1",
+			s:    MustOpenStorage(path+"/node-1", sc),
+		},
+	}
+
+	tenantID := TenantID{
+		AccountID: 1,
+		ProjectID: 2,
+	}
+	baseTimestamp := time.Now().UnixNano() - 3600*1e9
+	for i := 0; i < 1000; i++ {
+		fields := []Field{
+			{
+				Name:  "host",
+				Value: fmt.Sprintf("host-%d", i%7),
+			},
+			{
+				Name:  "_msg",
+				Value: fmt.Sprintf("message #%d", i),
+			},
+			{
+				Name:  "n",
+				Value: fmt.Sprintf("%d", i),
+			},
+		}
+		timestamp := baseTimestamp + int64(i)*1e9
+
+		lr := GetLogRows([]string{"host"}, nil)
+		lr.MustAdd(tenantID, timestamp, fields)
+		sAll.MustAddRows(lr)
+		lr.ResetKeepSettings()
+		lr.MustAdd(tenantID, timestamp, fields)
+		sns[i%len(sns)].(*testRemoteStorageNode).s.MustAddRows(lr)
+		PutLogRows(lr)
+	}
+	sAll.debugFlush()
+	for _, sn := range sns {
+		sn.(*testRemoteStorageNode).s.debugFlush()
+	}
+
+	runQuery := func(qStr string, runFunc func(q *Query, writeBlock WriteBlockFunc) error) ([]string, error) {
+		q := mustParseQuery(qStr)
+
+		var rows []string
+		var rowsLock sync.Mutex
+		writeBlock := func(_ uint, timestamps []int64, columns []BlockColumn) {
+			rowsLock.Lock()
+			defer rowsLock.Unlock()
+
+			for i := range timestamps {
+				a := make([]string, len(columns))
+				for j, c := range columns {
+					a[j] = fmt.Sprintf("%s=%q", c.Name, c.Values[i])
+				}
+				sort.Strings(a)
+				rows = append(rows, strings.Join(a, ","))
+			}
+		}
+		err := runFunc(q, writeBlock)
+		sort.Strings(rows)
+		return rows, err
+	}
+
+	f := func(qStr string) {
+		t.Helper()
+
+		rowsExpected, err := runQuery(qStr, func(q *Query, writeBlock WriteBlockFunc) error {
+			return sAll.RunQuery(context.Background(), []TenantID{tenantID}, q, writeBlock)
+		})
+		if err != nil {
+			t.Fatalf("unexpected error for local query [%s]: %s", qStr, err)
+		}
+		rows, err := runQuery(qStr, func(q *Query, writeBlock WriteBlockFunc) error {
+			return RunFederatedQuery(context.Background(), sns, []TenantID{tenantID}, q, writeBlock)
+		})
+		if err != nil {
+			t.Fatalf("unexpected error for federated query [%s]: %s", qStr, err)
+		}
+		if len(rows) == 0 {
+			t.Fatalf("expecting non-empty results for [%s]", qStr)
+		}
+		if !reflect.DeepEqual(rows, rowsExpected) {
+			t.Fatalf("unexpected results for [%s]\ngot\n%s\nwant\n%s", qStr, strings.Join(rows, "\n"), strings.Join(rowsExpected, "\n"))
+		}
+	}
+
+	f(`message`)
+	f(`host:host-3 | fields host, n`)
+	f(`* | stats count() hits`)
+	f(`* | stats by (host) count() hits, sum(n) sum_n, count_uniq(n) uniq_n, min(n) min_n, max(_msg) max_msg, avg(n) avg_n`)
+	f(`* | stats by (host) median(n) median_n, quantile(0.9, n) p90, uniq_values(host) hosts | sort by (host) limit 3`)
+	f(`* | extract "message #<num>" | stats by (host) sum(num) sum_num`)
+	f(`* | stats by (_time:10m) count() hits`)
+	f(`* | sort by (n) desc limit 5`)
+	f(`* | sort by (host, _time) offset 3 limit 4 rank as r | fields host, n, r`)
+	f(`* | sort by (_msg) | limit 3`)
+	f(`* | uniq by (host)`)
+	f(`* | uniq by (host) with hits`)
+	f(`* | limit 7 | stats count() hits`)
+	f(`* | top 3 by (host)`)
+	f(`* | field_names`)
+
+	// Unsupported query
+	if _, err := runQuery(`* | join by (host) (* | stats by (host) count() hits)`, func(q *Query, writeBlock WriteBlockFunc) error {
+		return RunFederatedQuery(context.Background(), sns, []TenantID{tenantID}, q, writeBlock)
+	}); err == nil {
+		t.Fatalf("expecting non-nil error for the query with join pipe")
+	}
+
+	// The error at the storage node must be returned from RunFederatedQuery
+	_, err := runQuery(`* | stats count() hits`, func(q *Query, writeBlock WriteBlockFunc) error {
+		q.SetMaxScannedRows(10)
+		return RunFederatedQuery(context.Background(), sns, []TenantID{tenantID}, q, writeBlock)
+	})
+	if err == nil {
+		t.Fatalf("expecting non-nil error for the query exceeding the limit on the number of scanned rows")
+	}
+	if !strings.Contains(err.Error(), "storage node node-") {
+		t.Fatalf("the error must contain the storage node name; got %s", err)
+	}
+
+	sAll.MustClose()
+	for _, sn := range sns {
+		sn.(*testRemoteStorageNode).s.MustClose()
+	}
+
+	fs.MustRemoveAll(path)
+}
@@ -0,0 +1,211 @@
+package logstorage
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promutils"
+)
+
+// RetentionFilter overrides the retention for log streams matching the given TenantID and StreamFilter.
+//
+// See https://docs.victoriametrics.com/victorialogs/#retention-filters
+type RetentionFilter struct {
+	// TenantID is an optional tenant to apply the filter to.
+	//
+	// The filter is applied to all the tenants if TenantID is nil.
+	TenantID *TenantID
+
+	// StreamFilter is the filter for log streams to apply the Retention to.
+	StreamFilter *StreamFilter
+
+	// Retention is the retention for log streams matching the filter.
+	Retention time.Duration
+}
+
+// ParseRetentionFilter parses retention filter from s.
+//
+// s must be in the form `[accountID:projectID:]{stream_filter}:retention`, for example `{env="prod"}:90d` or `12:34:{}:30d`.
+func ParseRetentionFilter(s string) (*RetentionFilter, error) {
+	n := strings.IndexByte(s, '{')
+	if n < 0 {
+		return nil, fmt.Errorf("missing stream filter in %q; it must be in the form `[accountID:projectID:]{stream_filter}:retention`", s)
+	}
+	tenantStr := s[:n]
+	tail := s[n:]
+
+	var rf RetentionFilter
+	if tenantStr != "" {
+		if !strings.HasSuffix(tenantStr, ":") {
+			return nil, fmt.Errorf("missing ':' after tenant %q in %q", tenantStr, s)
+		}
+		tenantID, err := ParseTenantID(tenantStr[:len(tenantStr)-1])
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse tenant in %q: %w", s, err)
+		}
+		rf.TenantID = &tenantID
+	}
+
+	n = strings.LastIndexByte(tail, ':')
+	if n < 0 {
+		return nil, fmt.Errorf("missing retention in %q; it must be in the form `[accountID:projectID:]{stream_filter}:retention`", s)
+	}
+	sfStr := tail[:n]
+	retentionStr := tail[n+1:]
+
+	lex := newLexer(sfStr)
+	sf, err := parseStreamFilter(lex)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse stream filter in %q: %w", s, err)
+	}
+	if !lex.isEnd() {
+		return nil, fmt.Errorf("unexpected tail after the stream filter in %q: %q", s, lex.context())
+	}
+	rf.StreamFilter = sf
+
+	retention, err := promutils.ParseDuration(retentionStr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse retention in %q: %w", s, err)
+	}
+	if retention < 24*time.Hour {
+		return nil, fmt.Errorf("retention in %q cannot be smaller than a day; got %s", s, retentionStr)
+	}
+	rf.Retention = retention
+
+	return &rf, nil
+}
+
+// String returns string representation for rf.
+func (rf *RetentionFilter) String() string {
+	tenant := ""
+	if rf.TenantID != nil {
+		tenant = fmt.Sprintf("%d:%d:", rf.TenantID.AccountID, rf.TenantID.ProjectID)
+	}
+	return fmt.Sprintf("%s%s:%dd", tenant, rf.StreamFilter, durationToDays(rf.Retention))
+}
+
+// match returns true if rf matches the log stream with the given tenantID and the given streamName.
+//
+// streamName must be obtained via getStreamTagsString().
+func (rf *RetentionFilter) match(tenantID TenantID, streamName string) bool {
+	if rf.TenantID != nil && !rf.TenantID.equal(&tenantID) {
+		return false
+	}
+	return rf.StreamFilter.matchStreamName(streamName)
+}
+
+// getStreamRetention returns the retention for the log stream with the given tenantID and streamName.
+//
+// The retention from the first matching retention filter is returned. s.retention is returned if there are no matching filters.
+func (s *Storage) getStreamRetention(tenantID TenantID, streamName string) time.Duration {
+	for _, rf := range s.retentionFilters {
+		if rf.match(tenantID, streamName) {
+			return rf.Retention
+		}
+	}
+	return s.retention
+}
+
+// getRetentionDescription returns human-readable description of the retention configured at s.
+func (s *Storage) getRetentionDescription() string {
+	if len(s.retentionFilters) == 0 {
+		return fmt.Sprintf("-retentionPeriod=%dd", durationToDays(s.retention))
+	}
+	return fmt.Sprintf("the maximum retention=%dd across -retentionPeriod and -retention.filter", durationToDays(s.maxRetention))
+}
+
+// getRetentionsForForceMerge returns the list of unique retentions smaller than s.maxRetention.
+//
+// Partitions, which go outside these retentions, contain log streams, which must be deleted by the next merge.
+func (s *Storage) getRetentionsForForceMerge() []time.Duration {
+	var retentions []time.Duration
+	addRetention := func(d time.Duration) {
+		if d >= s.maxRetention {
+			return
+		}
+		for _, x := range retentions {
+			if x == d {
+				return
+			}
+		}
+		retentions = append(retentions, d)
+	}
+	addRetention(s.retention)
+	for _, rf := range s.retentionFilters {
+		addRetention(rf.Retention)
+	}
+	return retentions
+}
+
+// getExpiredRetentionsCount returns the number of retentions for the force merge, which are outside the given partition day.
+func (s *Storage) getExpiredRetentionsCount(day int64) int {
+	now := time.Now().UTC()
+	n := 0
+	for _, d := range s.retentionsForForceMerge {
+		minAllowedDay := now.Add(-d).UnixNano() / nsecPerDay
+		if day < minAllowedDay {
+			n++
+		}
+	}
+	return n
+}
+
+// streamRetentionMatcher returns the minimum allowed timestamps for log streams in the partition according to retention filters.
+type streamRetentionMatcher struct {
+	pt *partition
+
+	// currentTimestamp is the timestamp used for calculating the minimum allowed timestamps.
+	currentTimestamp int64
+
+	// streamID is the last seen streamID.
+	streamID streamID
+
+	// minTimestamp is the minimum allowed timestamp for streamID.
+	minTimestamp int64
+
+	// streamTagsCanonical is a buffer for canonical stream tags.
+	streamTagsCanonical []byte
+}
+
+// newStreamRetentionMatcher returns streamRetentionMatcher for pt.
+//
+// nil is returned if there are no retention filters at pt.s.
+func (pt *partition) newStreamRetentionMatcher() *streamRetentionMatcher {
+	if pt == nil || len(pt.s.retentionFilters) == 0 {
+		return nil
+	}
+	return &streamRetentionMatcher{
+		pt:               pt,
+		currentTimestamp: time.Now().UnixNano(),
+		minTimestamp:     math.MinInt64,
+	}
+}
+
+// getMinTimestamp returns the minimum allowed timestamp for log entries for the given sid.
+//
+// Log entries with smaller timestamps must be deleted.
+//
+// The function is optimized for calling with sorted sids, since this is the case during merges.
+func (srm *streamRetentionMatcher) getMinTimestamp(sid *streamID) int64 {
+	if srm == nil {
+		return math.MinInt64
+	}
+	if sid.equal(&srm.streamID) {
+		return srm.minTimestamp
+	}
+
+	srm.streamID = *sid
+	srm.streamTagsCanonical = srm.pt.appendStreamTagsByStreamID(srm.streamTagsCanonical[:0], sid)
+	if len(srm.streamTagsCanonical) == 0 {
+		// The stream may be missing in the indexdb search results if it has been registered recently.
+		// Do not drop log entries for such a stream, since its retention is unknown.
+		srm.minTimestamp = math.MinInt64
+		return srm.minTimestamp
+	}
+	streamName := getStreamTagsString(srm.streamTagsCanonical)
+	retention := srm.pt.s.getStreamRetention(sid.tenantID, streamName)
+	srm.minTimestamp = srm.currentTimestamp - int64(retention)
+	return srm.minTimestamp
+}
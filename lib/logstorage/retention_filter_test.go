@@ -0,0 +1,237 @@
+package logstorage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestParseRetentionFilterSuccess(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+
+		rf, err := ParseRetentionFilter(s)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		result := rf.String()
+		if result != resultExpected {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	f(`{}:1d`, `{}:1d`)
+	f(`{env="prod"}:90d`, `{env="prod"}:90d`)
+	f(`{env="prod",app=~"nginx|apache"}:2w`, `{env="prod",app=~"nginx|apache"}:14d`)
+	f(`{env="prod" or env="staging"}:30d`, `{env="prod" or env="staging"}:30d`)
+	f(`{url="http://foo:1234/bar"}:30d`, `{url="http://foo:1234/bar"}:30d`)
+	f(`12:34:{}:30d`, `12:34:{}:30d`)
+	f(`0:0:{env!="dev"}:720h`, `0:0:{env!="dev"}:30d`)
+	f(`12:{}:30d`, `12:0:{}:30d`)
+}
+
+func TestParseRetentionFilterFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+
+		rf, err := ParseRetentionFilter(s)
+		if err == nil {
+			t.Fatalf("expecting non-nil error; got %s", rf)
+		}
+	}
+
+	f(``)
+	f(`90d`)
+
+	// missing retention
+	f(`{env="prod"}`)
+	f(`{env="prod"}:`)
+
+	// invalid retention
+	f(`{env="prod"}:foo`)
+
+	// too small retention
+	f(`{env="prod"}:1h`)
+
+	// invalid stream filter
+	f(`{env}:30d`)
+	f(`{env="prod"} foo:30d`)
+
+	// invalid tenant
+	f(`foo:{}:30d`)
+	f(`12:34{}:30d`)
+	f(`12:foo:{}:30d`)
+}
+
+func TestStorageGetStreamRetention(t *testing.T) {
+	mustParseRetentionFilter := func(s string) *RetentionFilter {
+		t.Helper()
+
+		rf, err := ParseRetentionFilter(s)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return rf
+	}
+	s := &Storage{
+		retention: 7 * 24 * time.Hour,
+		retentionFilters: []*RetentionFilter{
+			mustParseRetentionFilter(`12:34:{env="prod"}:90d`),
+			mustParseRetentionFilter(`{env="prod"}:30d`),
+			mustParseRetentionFilter(`{env=~"stag.*"}:14d`),
+		},
+	}
+
+	f := func(tenantID TenantID, streamName string, retentionDaysExpected int64) {
+		t.Helper()
+
+		retention := s.getStreamRetention(tenantID, streamName)
+		if days := durationToDays(retention); days != retentionDaysExpected {
+			t.Fatalf("unexpected retention for %s%s; got %dd; want %dd", &tenantID, streamName, days, retentionDaysExpected)
+		}
+	}
+
+	f(TenantID{AccountID: 12, ProjectID: 34}, `{env="prod"}`, 90)
+	f(TenantID{AccountID: 12, ProjectID: 34}, `{app="nginx",env="prod"}`, 90)
+	f(TenantID{AccountID: 12, ProjectID: 35}, `{env="prod"}`, 30)
+	f(TenantID{}, `{env="prod"}`, 30)
+	f(TenantID{}, `{env="staging"}`, 14)
+	f(TenantID{}, `{env="dev"}`, 7)
+	f(TenantID{}, `{}`, 7)
+}
+
+func TestStorageRetentionFilters(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	rf, err := ParseRetentionFilter(`{env="prod"}:30d`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	sc := &StorageConfig{
+		Retention:        2 * 24 * time.Hour,
+		RetentionFilters: []*RetentionFilter{rf},
+	}
+
+	now := time.Now().UnixNano()
+	minTimestamp := now - int64(sc.Retention)
+
+	// Generate log entries for the whole day containing minTimestamp, so they are split by minTimestamp into two parts.
+	// Generate also log entries for a day outside the default retention.
+	var timestamps []int64
+	rowsCountExpected := 0
+	dayStart := minTimestamp / nsecPerDay * nsecPerDay
+	for ts := dayStart; ts < dayStart+nsecPerDay; ts += 10 * 60 * 1e9 {
+		if ts > minTimestamp && ts < minTimestamp+60*1e9 {
+			// Skip log entries close to minTimestamp, since the minimum allowed timestamp is calculated during the merge.
+			continue
+		}
+		timestamps = append(timestamps, ts)
+		if ts >= minTimestamp {
+			rowsCountExpected++
+		}
+	}
+	for i := 0; i < 100; i++ {
+		timestamps = append(timestamps, dayStart-3*nsecPerDay+int64(i)*1e9)
+	}
+
+	s := MustOpenStorage(path, sc)
+
+	for _, env := range []string{"prod", "dev"} {
+		// Add log entries in two batches in order to verify merging multiple parts
+		for _, batch := range [][]int64{timestamps[:len(timestamps)/2], timestamps[len(timestamps)/2:]} {
+			lr := GetLogRows([]string{"env"}, nil)
+			for _, ts := range batch {
+				fields := []Field{
+					{
+						Name:  "env",
+						Value: env,
+					},
+					{
+						Name:  "_msg",
+						Value: fmt.Sprintf("message at %d", ts),
+					},
+				}
+				lr.MustAdd(TenantID{}, ts, fields)
+			}
+			s.MustAddRows(lr)
+			PutLogRows(lr)
+		}
+	}
+
+	// Re-open the storage in order to flush in-memory parts to disk.
+	s.MustClose()
+	s = MustOpenStorage(path, sc)
+
+	getRowsCount := func(qStr string) string {
+		t.Helper()
+
+		q := mustParseQuery(qStr)
+		var results []string
+		var resultsLock sync.Mutex
+		writeBlock := func(_ uint, _ []int64, columns []BlockColumn) {
+			resultsLock.Lock()
+			results = append(results, columns[0].Values...)
+			resultsLock.Unlock()
+		}
+		if err := s.RunQuery(context.Background(), []TenantID{{}}, q, writeBlock); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("unexpected number of results for [%s]; got %d; want 1", qStr, len(results))
+		}
+		return results[0]
+	}
+
+	allRowsCount := fmt.Sprintf("%d", len(timestamps))
+	if n := getRowsCount(`{env="prod"} | count()`); n != allRowsCount {
+		t.Fatalf("unexpected number of prod log entries before the merge; got %s; want %s", n, allRowsCount)
+	}
+	if n := getRowsCount(`{env="dev"} | count()`); n != allRowsCount {
+		t.Fatalf("unexpected number of dev log entries before the merge; got %s; want %s", n, allRowsCount)
+	}
+
+	// Force merge the partitions and wait until the outdated dev log entries are dropped
+	s.partitionsLock.Lock()
+	for _, ptw := range s.partitions {
+		ptw.pt.ddb.startForceMerge()
+	}
+	s.partitionsLock.Unlock()
+
+	devRowsCountExpected := fmt.Sprintf("%d", rowsCountExpected)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		n := getRowsCount(`{env="dev"} | count()`)
+		if n == devRowsCountExpected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected number of dev log entries after the merge; got %s; want %s", n, devRowsCountExpected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// prod log entries must remain untouched
+	if n := getRowsCount(`{env="prod"} | count()`); n != allRowsCount {
+		t.Fatalf("unexpected number of prod log entries after the merge; got %s; want %s", n, allRowsCount)
+	}
+
+	s.MustClose()
+
+	// Verify the data after re-opening the storage
+	s = MustOpenStorage(path, sc)
+	if n := getRowsCount(`{env="dev"} | count()`); n != devRowsCountExpected {
+		t.Fatalf("unexpected number of dev log entries after re-opening the storage; got %s; want %s", n, devRowsCountExpected)
+	}
+	if n := getRowsCount(`{env="prod"} | count()`); n != allRowsCount {
+		t.Fatalf("unexpected number of prod log entries after re-opening the storage; got %s; want %s", n, allRowsCount)
+	}
+	s.MustClose()
+
+	fs.MustRemoveAll(path)
+}
@@ -0,0 +1,107 @@
+package logstorage
+
+import (
+	"fmt"
+	"sync"
+)
+
+// RowFilter is LogsQL filter, which can be applied to individual log entries before they are stored.
+//
+// Use ParseRowFilter for obtaining RowFilter.
+type RowFilter struct {
+	f filter
+}
+
+// ParseRowFilter parses LogsQL filter from s.
+//
+// s mustn't contain pipes, subqueries, `_stream` and `_stream_id` filters, since they cannot be applied to individual log entries.
+func ParseRowFilter(s string) (*RowFilter, error) {
+	q, err := ParseQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(q.pipes) > 0 {
+		return nil, fmt.Errorf("the filter mustn't contain pipes; got [%s]", q)
+	}
+	if hasFilterInWithQueryForFilter(q.f) {
+		return nil, fmt.Errorf("the filter mustn't contain subqueries; got [%s]", q)
+	}
+	hasStreamFilter := visitFilter(q.f, func(f filter) bool {
+		switch f.(type) {
+		case *filterStream, *filterStreamID:
+			return true
+		default:
+			return false
+		}
+	})
+	if hasStreamFilter {
+		return nil, fmt.Errorf("the filter mustn't contain _stream and _stream_id filters; got [%s]", q)
+	}
+
+	rf := &RowFilter{
+		f: q.f,
+	}
+	return rf, nil
+}
+
+// String returns string representation for rf.
+func (rf *RowFilter) String() string {
+	return rf.f.String()
+}
+
+// MatchRow returns true if rf matches the log entry with the given timestamp and fields.
+//
+// It is safe calling MatchRow from concurrently running goroutines.
+func (rf *RowFilter) MatchRow(timestamp int64, fields []Field) bool {
+	rfs := getRowFilterState()
+	defer putRowFilterState(rfs)
+
+	rcs := rfs.rcs[:0]
+	for _, f := range fields {
+		rcs = appendResultColumnWithName(rcs, getCanonicalColumnName(f.Name))
+		rcs[len(rcs)-1].addValue(f.Value)
+	}
+	rfs.rcs = rcs
+
+	br := &rfs.br
+	br.setResultColumns(rcs, 1)
+	br.timestamps[0] = timestamp
+	br.addTimeColumn()
+
+	bm := &rfs.bm
+	bm.init(1)
+	bm.setBits()
+	rf.f.applyToBlockResult(br, bm)
+	return bm.isSetBit(0)
+}
+
+type rowFilterState struct {
+	rcs []resultColumn
+	br  blockResult
+	bm  bitmap
+}
+
+func (rfs *rowFilterState) reset() {
+	for i := range rfs.rcs {
+		rfs.rcs[i].reset()
+	}
+	rfs.rcs = rfs.rcs[:0]
+
+	rfs.br.reset()
+	rfs.bm.reset()
+}
+
+func getRowFilterState() *rowFilterState {
+	v := rowFilterStatePool.Get()
+	if v == nil {
+		return &rowFilterState{}
+	}
+	return v.(*rowFilterState)
+}
+
+func putRowFilterState(rfs *rowFilterState) {
+	rfs.reset()
+	rowFilterStatePool.Put(rfs)
+}
+
+var rowFilterStatePool sync.Pool
@@ -0,0 +1,93 @@
+package logstorage
+
+import (
+	"testing"
+)
+
+func TestParseRowFilterFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+
+		rf, err := ParseRowFilter(s)
+		if err == nil {
+			t.Fatalf("expecting non-nil error for [%s]; got %s", s, rf)
+		}
+	}
+
+	f("")
+	f("foo:(")
+
+	// pipes
+	f("foo | limit 10")
+	f("* | fields foo")
+
+	// subqueries
+	f("foo:in(* | fields foo)")
+
+	// stream filters
+	f(`_stream:{app="foo"}`)
+	f(`foo or _stream_id:0000007b000001c8302bc96e02e54e5524b3a68ec271e55e`)
+}
+
+func TestRowFilterMatchRow(t *testing.T) {
+	f := func(s string, timestamp int64, fields []Field, resultExpected bool) {
+		t.Helper()
+
+		rf, err := ParseRowFilter(s)
+		if err != nil {
+			t.Fatalf("cannot parse [%s]: %s", s, err)
+		}
+		result := rf.MatchRow(timestamp, fields)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for [%s]; got %v; want %v", s, result, resultExpected)
+		}
+	}
+
+	fields := []Field{
+		{Name: "_msg", Value: "GET /foo/bar HTTP/1.1 200"},
+		{Name: "level", Value: "debug"},
+		{Name: "duration", Value: "123.5"},
+		{Name: "ip", Value: "10.1.2.3"},
+	}
+	timestamp := int64(1717150800000000000)
+
+	f("*", timestamp, fields, true)
+
+	// `*` matches log entries with non-empty _msg field
+	f("*", timestamp, nil, false)
+
+	// word filters
+	f("foo", timestamp, fields, true)
+	f("baz", timestamp, fields, false)
+	f(`"foo/bar"`, timestamp, fields, true)
+	f("level:debug", timestamp, fields, true)
+	f("level:info", timestamp, fields, false)
+	f(`level:in(info, debug)`, timestamp, fields, true)
+	f(`level:=deb*`, timestamp, fields, true)
+	f(`level:~"^de"`, timestamp, fields, true)
+	f("missing:foo", timestamp, fields, false)
+	f(`missing:""`, timestamp, fields, true)
+	f(`level:""`, timestamp, fields, false)
+
+	// logical filters
+	f("level:debug foo", timestamp, fields, true)
+	f("level:debug baz", timestamp, fields, false)
+	f("level:info or foo", timestamp, fields, true)
+	f("level:info or baz", timestamp, fields, false)
+	f("!level:debug", timestamp, fields, false)
+	f("not baz", timestamp, fields, true)
+
+	// range filters
+	f("duration:>100", timestamp, fields, true)
+	f("duration:<100", timestamp, fields, false)
+	f("duration:range[100, 200)", timestamp, fields, true)
+	f("ip:ipv4_range(10.0.0.0/8)", timestamp, fields, true)
+	f("ip:ipv4_range(192.168.0.0/16)", timestamp, fields, false)
+	f("_msg:len_range(10, 100)", timestamp, fields, true)
+
+	// time filters
+	f("_time:[2024-05-31T10:00:00Z, 2024-05-31T11:00:00Z)", timestamp, fields, true)
+	f("_time:[2024-05-31T11:00:00Z, 2024-05-31T12:00:00Z)", timestamp, fields, false)
+	f("_time:2024-05-31", timestamp, fields, true)
+	f("_time:2024-06", timestamp, fields, false)
+}
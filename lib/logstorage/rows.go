@@ -2,6 +2,7 @@ package logstorage
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/valyala/quicktemplate"
 
@@ -190,6 +191,45 @@ func (rs *rows) appendRows(timestamps []int64, rows [][]Field) {
 	rs.fieldsBuf = fieldsBuf
 }
 
+// dropRowsBefore drops rows with timestamps smaller than minTimestamp starting from rs.rows[startIdx:].
+//
+// Rows starting from startIdx must be sorted by timestamp.
+func (rs *rows) dropRowsBefore(startIdx int, minTimestamp int64) {
+	timestamps := rs.timestamps[startIdx:]
+	n := sort.Search(len(timestamps), func(i int) bool {
+		return timestamps[i] >= minTimestamp
+	})
+	if n == 0 {
+		return
+	}
+	rs.timestamps = append(rs.timestamps[:startIdx], timestamps[n:]...)
+
+	rows := rs.rows
+	rowsNew := append(rows[:startIdx], rows[startIdx+n:]...)
+	clear(rows[len(rowsNew):])
+	rs.rows = rowsNew
+}
+
+// dropRows drops rows starting from rs.rows[startIdx:], which have set bits at bm.
+//
+// bm must contain a bit per every row starting from startIdx.
+func (rs *rows) dropRows(startIdx int, bm *bitmap) {
+	timestamps := rs.timestamps
+	rows := rs.rows
+	dstIdx := startIdx
+	for i := startIdx; i < len(rows); i++ {
+		if bm.isSetBit(i - startIdx) {
+			continue
+		}
+		timestamps[dstIdx] = timestamps[i]
+		rows[dstIdx] = rows[i]
+		dstIdx++
+	}
+	clear(rows[dstIdx:])
+	rs.timestamps = timestamps[:dstIdx]
+	rs.rows = rows[:dstIdx]
+}
+
 // mergeRows merges the args and appends them to rs.
 func (rs *rows) mergeRows(timestampsA, timestampsB []int64, fieldsA, fieldsB [][]Field) {
 	for len(timestampsA) > 0 && len(timestampsB) > 0 {
@@ -1,7 +1,9 @@
 package logstorage
 
 import (
+	"fmt"
 	"reflect"
+	"slices"
 	"testing"
 )
 
@@ -349,3 +351,102 @@ func TestMergeRows(t *testing.T) {
 	}
 	f(timestampsA, timestampsB, fieldsA, fieldsB, resultTimestamps, resultFields)
 }
+
+func TestRowsDropRowsBefore(t *testing.T) {
+	f := func(timestamps []int64, startIdx int, minTimestamp int64, timestampsExpected []int64) {
+		t.Helper()
+
+		var rs rows
+		rows := make([][]Field, len(timestamps))
+		for i := range rows {
+			rows[i] = []Field{
+				{
+					Name:  "_msg",
+					Value: fmt.Sprintf("%d", timestamps[i]),
+				},
+			}
+		}
+		rs.appendRows(timestamps, rows)
+		rs.dropRowsBefore(startIdx, minTimestamp)
+
+		if !reflect.DeepEqual(rs.timestamps, timestampsExpected) {
+			t.Fatalf("unexpected timestamps; got %d; want %d", rs.timestamps, timestampsExpected)
+		}
+		if len(rs.rows) != len(timestampsExpected) {
+			t.Fatalf("unexpected number of rows; got %d; want %d", len(rs.rows), len(timestampsExpected))
+		}
+		for i, fields := range rs.rows {
+			if v := fields[0].Value; v != fmt.Sprintf("%d", timestampsExpected[i]) {
+				t.Fatalf("unexpected row #%d; got %q; want %d", i, v, timestampsExpected[i])
+			}
+		}
+	}
+
+	// nothing to drop
+	f([]int64{1, 2, 3}, 0, 1, []int64{1, 2, 3})
+	f([]int64{1, 2, 3}, 0, 0, []int64{1, 2, 3})
+
+	// drop all the rows
+	f([]int64{1, 2, 3}, 0, 4, []int64{})
+
+	// drop some rows
+	f([]int64{1, 2, 3}, 0, 2, []int64{2, 3})
+	f([]int64{1, 2, 2, 3}, 0, 3, []int64{3})
+
+	// drop rows starting from startIdx
+	f([]int64{5, 6, 1, 2, 3}, 2, 3, []int64{5, 6, 3})
+	f([]int64{5, 6, 1, 2, 3}, 2, 10, []int64{5, 6})
+	f([]int64{5, 6, 1, 2, 3}, 5, 10, []int64{5, 6, 1, 2, 3})
+}
+
+func TestRowsDropRows(t *testing.T) {
+	f := func(timestamps []int64, startIdx int, dropIdxs []int, timestampsExpected []int64) {
+		t.Helper()
+
+		var rs rows
+		rows := make([][]Field, len(timestamps))
+		for i := range rows {
+			rows[i] = []Field{
+				{
+					Name:  "_msg",
+					Value: fmt.Sprintf("%d", timestamps[i]),
+				},
+			}
+		}
+		rs.appendRows(timestamps, rows)
+
+		bm := getBitmap(len(timestamps) - startIdx)
+		bm.setBits()
+		bm.forEachSetBit(func(idx int) bool {
+			return slices.Contains(dropIdxs, idx)
+		})
+		rs.dropRows(startIdx, bm)
+		putBitmap(bm)
+
+		if !reflect.DeepEqual(rs.timestamps, timestampsExpected) {
+			t.Fatalf("unexpected timestamps; got %d; want %d", rs.timestamps, timestampsExpected)
+		}
+		if len(rs.rows) != len(timestampsExpected) {
+			t.Fatalf("unexpected number of rows; got %d; want %d", len(rs.rows), len(timestampsExpected))
+		}
+		for i, fields := range rs.rows {
+			if v := fields[0].Value; v != fmt.Sprintf("%d", timestampsExpected[i]) {
+				t.Fatalf("unexpected row #%d; got %q; want %d", i, v, timestampsExpected[i])
+			}
+		}
+	}
+
+	// nothing to drop
+	f([]int64{1, 2, 3}, 0, nil, []int64{1, 2, 3})
+
+	// drop all the rows
+	f([]int64{1, 2, 3}, 0, []int{0, 1, 2}, []int64{})
+
+	// drop some rows
+	f([]int64{1, 2, 3}, 0, []int{1}, []int64{1, 3})
+	f([]int64{1, 2, 3, 4}, 0, []int{0, 3}, []int64{2, 3})
+
+	// drop rows starting from startIdx
+	f([]int64{5, 6, 1, 2, 3}, 2, []int{0, 2}, []int64{5, 6, 2})
+	f([]int64{5, 6, 1, 2, 3}, 2, []int{0, 1, 2}, []int64{5, 6})
+}
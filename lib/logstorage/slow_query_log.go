@@ -0,0 +1,66 @@
+package logstorage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// logSlowQueryIfNeeded logs q if its execution time exceeds s.logSlowQueryDuration.
+//
+// The query is logged as JSON object, so it can be ingested into VictoriaLogs and analyzed with LogsQL.
+func (s *Storage) logSlowQueryIfNeeded(startTime time.Time, tenantIDs []TenantID, q *Query, qs *queryStats, rowsReturned uint64) {
+	if s.logSlowQueryDuration <= 0 {
+		return
+	}
+	d := time.Since(startTime)
+	if d < s.logSlowQueryDuration {
+		return
+	}
+
+	s.slowQueries.Add(1)
+	rf := RowFormatter(getSlowQueryFields(d, tenantIDs, q, qs, rowsReturned))
+	logger.Warnf("slow query: %s", &rf)
+}
+
+// getSlowQueryFields returns fields describing the slow query q with the given execution duration d.
+func getSlowQueryFields(d time.Duration, tenantIDs []TenantID, q *Query, qs *queryStats, rowsReturned uint64) []Field {
+	tenants := make([]string, len(tenantIDs))
+	for i := range tenantIDs {
+		tenants[i] = tenantIDs[i].String()
+	}
+
+	return []Field{
+		{
+			Name:  "duration_seconds",
+			Value: fmt.Sprintf("%.3f", d.Seconds()),
+		},
+		{
+			Name:  "tenants",
+			Value: strings.Join(tenants, ","),
+		},
+		{
+			Name:  "query",
+			Value: q.String(),
+		},
+		{
+			Name:  "rows_scanned",
+			Value: strconv.FormatUint(qs.rowsScanned.Load(), 10),
+		},
+		{
+			Name:  "bytes_read",
+			Value: strconv.FormatUint(qs.bytesRead.Load(), 10),
+		},
+		{
+			Name:  "rows_matched",
+			Value: strconv.FormatUint(qs.rowsMatched.Load(), 10),
+		},
+		{
+			Name:  "rows_returned",
+			Value: strconv.FormatUint(rowsReturned, 10),
+		},
+	}
+}
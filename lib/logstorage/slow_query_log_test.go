@@ -0,0 +1,50 @@
+package logstorage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetSlowQueryFields(t *testing.T) {
+	q := mustParseQuery(`error | stats by (host) count() rows`)
+	tenantIDs := []TenantID{
+		{
+			AccountID: 0,
+			ProjectID: 0,
+		},
+		{
+			AccountID: 12,
+			ProjectID: 34,
+		},
+	}
+	qs := newQueryStats()
+	qs.rowsScanned.Add(1000)
+	qs.bytesRead.Add(12345)
+	qs.rowsMatched.Add(10)
+
+	fields := getSlowQueryFields(1234567*time.Microsecond, tenantIDs, q, qs, 3)
+	fieldsExpected := []Field{
+		{"duration_seconds", "1.235"},
+		{"tenants", "{accountID=0,projectID=0},{accountID=12,projectID=34}"},
+		{"query", "error | stats by (host) count(*) as rows"},
+		{"rows_scanned", "1000"},
+		{"bytes_read", "12345"},
+		{"rows_matched", "10"},
+		{"rows_returned", "3"},
+	}
+	if !reflect.DeepEqual(fields, fieldsExpected) {
+		t.Fatalf("unexpected fields\ngot\n%v\nwant\n%v", fields, fieldsExpected)
+	}
+
+	// The logged slow query must be parseable back into the same fields
+	rf := RowFormatter(fields)
+	p := GetJSONParser()
+	defer PutJSONParser(p)
+	if err := p.ParseLogMessage([]byte(rf.String())); err != nil {
+		t.Fatalf("cannot parse the logged slow query: %s", err)
+	}
+	if !reflect.DeepEqual(p.Fields, fieldsExpected) {
+		t.Fatalf("unexpected parsed fields\ngot\n%v\nwant\n%v", p.Fields, fieldsExpected)
+	}
+}
@@ -0,0 +1,351 @@
+package logstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/snapshot/snapshotutil"
+)
+
+// CreateSnapshot creates a snapshot for s and returns the snapshot name.
+//
+// The snapshot is created at <path>/snapshots/<name> directory, which has the same layout as the Storage directory.
+// Parts are added to the snapshot via hard links, so snapshots are usually created very quickly.
+// The snapshot can be backed up with vmbackup and restored with vmrestore. The checksums for all the files in the restored data
+// are verified when the Storage is opened for the first time after the restore.
+//
+// The snapshot must be deleted via DeleteSnapshot when it is no longer needed, since it prevents from freeing disk space occupied by deleted parts.
+func (s *Storage) CreateSnapshot() (string, error) {
+	logger.Infof("creating Storage snapshot for %q...", s.path)
+	startTime := time.Now()
+
+	s.snapshotLock.Lock()
+	defer s.snapshotLock.Unlock()
+
+	snapshotName := snapshotutil.NewName()
+	dstDir := filepath.Join(s.path, snapshotsDirname, snapshotName)
+	fs.MustMkdirFailIfExist(dstDir)
+
+	if err := s.createSnapshotAt(dstDir); err != nil {
+		fs.MustRemoveAll(dstDir)
+		return "", err
+	}
+
+	logger.Infof("created Storage snapshot for %q at %q in %.3f seconds", s.path, dstDir, time.Since(startTime).Seconds())
+	return snapshotName, nil
+}
+
+func (s *Storage) createSnapshotAt(dstDir string) error {
+	s.partitionsLock.Lock()
+	ptws := append([]*partitionWrapper{}, s.partitions...)
+	for _, ptw := range ptws {
+		ptw.incRef()
+	}
+	s.partitionsLock.Unlock()
+
+	defer func() {
+		for _, ptw := range ptws {
+			ptw.decRef()
+		}
+	}()
+
+	dstPartitionsDir := filepath.Join(dstDir, partitionsDirname)
+	fs.MustMkdirFailIfExist(dstPartitionsDir)
+	for _, ptw := range ptws {
+		dstPartitionDir := filepath.Join(dstPartitionsDir, ptw.pt.name)
+		if err := ptw.pt.createSnapshotAt(dstPartitionDir); err != nil {
+			return fmt.Errorf("cannot create snapshot for partition %q: %w", ptw.pt.path, err)
+		}
+	}
+	fs.MustSyncPath(dstPartitionsDir)
+
+	// Copy delete tasks, so they are applied to the parts restored from the snapshot.
+	// Do not make hard link to this file, since it is modified over time.
+	deleteTasksPath := filepath.Join(s.path, deleteTasksFilename)
+	if fs.IsPathExist(deleteTasksPath) {
+		fs.MustCopyFile(deleteTasksPath, filepath.Join(dstDir, deleteTasksFilename))
+	}
+	mustWriteChecksums(dstDir)
+
+	// Instruct the Storage to verify checksums for the restored data.
+	fs.MustWriteSync(filepath.Join(dstDir, verifyChecksumsFilename), nil)
+
+	fs.MustSyncPath(dstDir)
+	fs.MustSyncPath(filepath.Dir(dstDir))
+	return nil
+}
+
+// ListSnapshots returns sorted list of existing snapshots for s.
+func (s *Storage) ListSnapshots() ([]string, error) {
+	snapshotsPath := filepath.Join(s.path, snapshotsDirname)
+	des, err := os.ReadDir(snapshotsPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read snapshots directory: %w", err)
+	}
+	snapshotNames := make([]string, 0, len(des))
+	for _, de := range des {
+		fname := de.Name()
+		if err := snapshotutil.Validate(fname); err != nil {
+			continue
+		}
+		snapshotNames = append(snapshotNames, fname)
+	}
+	sort.Strings(snapshotNames)
+	return snapshotNames, nil
+}
+
+// DeleteSnapshot deletes the snapshot with the given snapshotName.
+func (s *Storage) DeleteSnapshot(snapshotName string) error {
+	if err := snapshotutil.Validate(snapshotName); err != nil {
+		return fmt.Errorf("invalid snapshotName %q: %w", snapshotName, err)
+	}
+	snapshotPath := filepath.Join(s.path, snapshotsDirname, snapshotName)
+	if !fs.IsPathExist(snapshotPath) {
+		return fmt.Errorf("cannot find snapshot %q", snapshotName)
+	}
+
+	logger.Infof("deleting snapshot %q...", snapshotPath)
+	startTime := time.Now()
+
+	fs.MustRemoveDirAtomic(snapshotPath)
+
+	logger.Infof("deleted snapshot %q in %.3f seconds", snapshotPath, time.Since(startTime).Seconds())
+	return nil
+}
+
+// DeleteStaleSnapshots deletes snapshots older than the given maxAge.
+func (s *Storage) DeleteStaleSnapshots(maxAge time.Duration) error {
+	snapshotNames, err := s.ListSnapshots()
+	if err != nil {
+		return err
+	}
+	expireDeadline := time.Now().UTC().Add(-maxAge)
+	for _, snapshotName := range snapshotNames {
+		t, err := snapshotutil.Time(snapshotName)
+		if err != nil {
+			return fmt.Errorf("cannot parse snapshot date from %q: %w", snapshotName, err)
+		}
+		if t.Before(expireDeadline) {
+			if err := s.DeleteSnapshot(snapshotName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// createSnapshotAt creates pt snapshot at the given dstDir.
+func (pt *partition) createSnapshotAt(dstDir string) error {
+	fs.MustMkdirFailIfExist(dstDir)
+
+	// Copy ZSTD dictionary, since it is needed for reading parts at the snapshot.
+	zstdDictPath := filepath.Join(pt.path, zstdDictFilename)
+	if fs.IsPathExist(zstdDictPath) {
+		fs.MustCopyFile(zstdDictPath, filepath.Join(dstDir, zstdDictFilename))
+	}
+	mustWriteChecksums(dstDir)
+
+	// Create datadb snapshot before indexdb snapshot, since the indexdb must contain all the streams for the logs in datadb snapshot.
+	pt.ddb.mustCreateSnapshotAt(filepath.Join(dstDir, datadbDirname))
+
+	dstIndexdbDir := filepath.Join(dstDir, indexdbDirname)
+	if err := pt.idb.tb.CreateSnapshotAt(dstIndexdbDir); err != nil {
+		return fmt.Errorf("cannot create indexdb snapshot: %w", err)
+	}
+	// Parts at indexdb snapshot are created by lib/mergeset, so checksums cannot be cached in them.
+	for _, de := range fs.MustReadDir(dstIndexdbDir) {
+		if de.IsDir() {
+			mustWriteChecksums(filepath.Join(dstIndexdbDir, de.Name()))
+		}
+	}
+
+	fs.MustSyncPath(dstDir)
+	return nil
+}
+
+// mustCreateSnapshotAt creates ddb snapshot at the given dstDir.
+func (ddb *datadb) mustCreateSnapshotAt(dstDir string) {
+	// Flush in-memory parts to disk, so they are included in the snapshot.
+	ddb.mustFlushInmemoryPartsToFiles(true)
+
+	ddb.partsLock.Lock()
+	pwsSmall := append([]*partWrapper{}, ddb.smallParts...)
+	pwsBig := append([]*partWrapper{}, ddb.bigParts...)
+	for _, pw := range pwsSmall {
+		pw.incRef()
+	}
+	for _, pw := range pwsBig {
+		pw.incRef()
+	}
+	ddb.partsLock.Unlock()
+
+	defer func() {
+		for _, pw := range pwsSmall {
+			pw.decRef()
+		}
+		for _, pw := range pwsBig {
+			pw.decRef()
+		}
+	}()
+
+	fs.MustMkdirFailIfExist(dstDir)
+	for _, pws := range [][]*partWrapper{pwsSmall, pwsBig} {
+		for _, pw := range pws {
+			srcPartPath := pw.p.path
+
+			// Parts are immutable, so checksums are calculated only once per part and are re-used by the next snapshots.
+			if !fs.IsPathExist(filepath.Join(srcPartPath, checksumsFilename)) {
+				mustWriteChecksums(srcPartPath)
+			}
+
+			dstPartPath := filepath.Join(dstDir, filepath.Base(srcPartPath))
+			fs.MustHardLinkFiles(srcPartPath, dstPartPath)
+		}
+	}
+	mustWritePartNames(dstDir, getPartNames(pwsSmall), getPartNames(pwsBig))
+
+	fs.MustSyncPath(dstDir)
+}
+
+// fileChecksum contains the checksum for a single file.
+type fileChecksum struct {
+	// Name is the file name.
+	Name string
+
+	// Size is the file size in bytes.
+	Size int64
+
+	// XXHash64 is xxhash64 of the file contents.
+	XXHash64 uint64
+}
+
+// mustWriteChecksums writes checksums for all the files at dir to checksumsFilename at dir.
+func mustWriteChecksums(dir string) {
+	var fcs []fileChecksum
+	for _, de := range fs.MustReadDir(dir) {
+		fname := de.Name()
+		if !de.Type().IsRegular() || fname == checksumsFilename || fs.IsTemporaryFileName(fname) {
+			continue
+		}
+		size, hash, err := getFileChecksum(filepath.Join(dir, fname))
+		if err != nil {
+			logger.Panicf("FATAL: %s", err)
+		}
+		fcs = append(fcs, fileChecksum{
+			Name:     fname,
+			Size:     size,
+			XXHash64: hash,
+		})
+	}
+	sort.Slice(fcs, func(i, j int) bool {
+		return fcs[i].Name < fcs[j].Name
+	})
+
+	data, err := json.Marshal(fcs)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal checksums: %s", err)
+	}
+	fs.MustWriteAtomic(filepath.Join(dir, checksumsFilename), data, true)
+}
+
+// verifyChecksums verifies files at dir against checksums written via mustWriteChecksums.
+func verifyChecksums(dir string) error {
+	checksumsPath := filepath.Join(dir, checksumsFilename)
+	data, err := os.ReadFile(checksumsPath)
+	if err != nil {
+		return fmt.Errorf("cannot read checksums: %w", err)
+	}
+	var fcs []fileChecksum
+	if err := json.Unmarshal(data, &fcs); err != nil {
+		return fmt.Errorf("cannot parse %s: %w", checksumsPath, err)
+	}
+	for _, fc := range fcs {
+		path := filepath.Join(dir, fc.Name)
+		size, hash, err := getFileChecksum(path)
+		if err != nil {
+			return err
+		}
+		if size != fc.Size {
+			return fmt.Errorf("unexpected size for %s; got %d bytes; want %d bytes", path, size, fc.Size)
+		}
+		if hash != fc.XXHash64 {
+			return fmt.Errorf("checksum mismatch for %s; got %016X; want %016X", path, hash, fc.XXHash64)
+		}
+	}
+	return nil
+}
+
+func getFileChecksum(path string) (int64, uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot open file for calculating checksum: %w", err)
+	}
+	defer fs.MustClose(f)
+
+	d := xxhash.New()
+	size, err := io.Copy(d, f)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot read %s for calculating checksum: %w", path, err)
+	}
+	return size, d.Sum64(), nil
+}
+
+// verifyRestoredChecksums verifies checksums for all the files restored from a snapshot into the Storage at the given path.
+func verifyRestoredChecksums(path string) error {
+	if err := verifyChecksums(path); err != nil {
+		return err
+	}
+
+	partitionsPath := filepath.Join(path, partitionsDirname)
+	for _, de := range fs.MustReadDir(partitionsPath) {
+		if !de.IsDir() {
+			continue
+		}
+		partitionPath := filepath.Join(partitionsPath, de.Name())
+		if err := verifyChecksums(partitionPath); err != nil {
+			return err
+		}
+		for _, dirname := range []string{datadbDirname, indexdbDirname} {
+			dir := filepath.Join(partitionPath, dirname)
+			for _, de := range fs.MustReadDir(dir) {
+				if !de.IsDir() {
+					continue
+				}
+				if err := verifyChecksums(filepath.Join(dir, de.Name())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// mustVerifyRestoredChecksums verifies checksums for the data restored from a snapshot into the Storage at the given path if needed.
+//
+// The verification is performed only once after the restore.
+func mustVerifyRestoredChecksums(path string) {
+	verifyChecksumsPath := filepath.Join(path, verifyChecksumsFilename)
+	if !fs.IsPathExist(verifyChecksumsPath) {
+		return
+	}
+
+	logger.Infof("verifying checksums for the data restored from a snapshot at %q...", path)
+	startTime := time.Now()
+
+	if err := verifyRestoredChecksums(path); err != nil {
+		logger.Panicf("FATAL: the data restored from a snapshot at %q is corrupted: %s; restore the data from the backup again", path, err)
+	}
+	fs.MustRemoveAll(filepath.Join(path, checksumsFilename))
+	fs.MustRemoveAll(verifyChecksumsPath)
+
+	logger.Infof("verified checksums for the data restored from a snapshot at %q in %.3f seconds", path, time.Since(startTime).Seconds())
+}
@@ -0,0 +1,219 @@
+package logstorage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestStorageSnapshots(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	sc := &StorageConfig{
+		Retention: 24 * time.Hour,
+	}
+	s := MustOpenStorage(path+"/storage", sc)
+
+	snapshotNames, err := s.ListSnapshots()
+	if err != nil {
+		t.Fatalf("cannot list snapshots: %s", err)
+	}
+	if len(snapshotNames) != 0 {
+		t.Fatalf("unexpected snapshots for new storage: %q", snapshotNames)
+	}
+
+	tenantID := TenantID{
+		AccountID: 1,
+		ProjectID: 2,
+	}
+	baseTimestamp := time.Now().UnixNano() - 3600*1e9
+	addRows := func(n int) {
+		lr := GetLogRows([]string{"host"}, nil)
+		for i := 0; i < n; i++ {
+			fields := []Field{
+				{
+					Name:  "host",
+					Value: fmt.Sprintf("host-%d", i%5),
+				},
+				{
+					Name:  "_msg",
+					Value: fmt.Sprintf("message #%d", i),
+				},
+			}
+			lr.MustAdd(tenantID, baseTimestamp+int64(i)*1e6, fields)
+		}
+		s.MustAddRows(lr)
+		PutLogRows(lr)
+	}
+
+	getResults := func(s *Storage) []string {
+		t.Helper()
+
+		var rows []string
+		var rowsLock sync.Mutex
+		writeBlock := func(_ uint, _ []int64, columns []BlockColumn) {
+			rowsLock.Lock()
+			defer rowsLock.Unlock()
+
+			for _, c := range columns {
+				rows = append(rows, c.Values...)
+			}
+		}
+		q := mustParseQuery(`* | stats by (host) count() hits | sort by (host)`)
+		if err := s.RunQuery(context.Background(), []TenantID{tenantID}, q, writeBlock); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return rows
+	}
+
+	// Create the snapshot for in-memory data
+	addRows(1000)
+	snapshotName, err := s.CreateSnapshot()
+	if err != nil {
+		t.Fatalf("cannot create snapshot: %s", err)
+	}
+	resultsExpected := getResults(s)
+
+	// Rows added after the snapshot creation mustn't be visible in the snapshot
+	addRows(100)
+
+	// The snapshot must be listed
+	snapshotNames, err = s.ListSnapshots()
+	if err != nil {
+		t.Fatalf("cannot list snapshots: %s", err)
+	}
+	if !reflect.DeepEqual(snapshotNames, []string{snapshotName}) {
+		t.Fatalf("unexpected snapshots; got %q; want %q", snapshotNames, []string{snapshotName})
+	}
+
+	// The next snapshot must re-use cached checksums for parts
+	snapshotNameNext, err := s.CreateSnapshot()
+	if err != nil {
+		t.Fatalf("cannot create the next snapshot: %s", err)
+	}
+	if snapshotNameNext == snapshotName {
+		t.Fatalf("the next snapshot must have distinct name; got %q", snapshotNameNext)
+	}
+	if err := s.DeleteSnapshot(snapshotNameNext); err != nil {
+		t.Fatalf("cannot delete snapshot: %s", err)
+	}
+
+	// Copy the snapshot as if it is restored with vmrestore and verify it contains the expected data
+	snapshotPath := filepath.Join(path, "storage", snapshotsDirname, snapshotName)
+	restoredPath := filepath.Join(path, "restored")
+	copyDir(t, snapshotPath, restoredPath)
+	if err := verifyRestoredChecksums(restoredPath); err != nil {
+		t.Fatalf("cannot verify checksums for the restored data: %s", err)
+	}
+	sRestored := MustOpenStorage(restoredPath, sc)
+	if fs.IsPathExist(filepath.Join(restoredPath, verifyChecksumsFilename)) {
+		t.Fatalf("%s must be removed after the verification", verifyChecksumsFilename)
+	}
+	results := getResults(sRestored)
+	if !reflect.DeepEqual(results, resultsExpected) {
+		t.Fatalf("unexpected results for the restored data\ngot\n%q\nwant\n%q", results, resultsExpected)
+	}
+	sRestored.MustClose()
+
+	// Verify that corrupted data is detected
+	corruptedPath := filepath.Join(path, "corrupted")
+	copyDir(t, snapshotPath, corruptedPath)
+	corruptFile(t, filepath.Join(corruptedPath, partitionsDirname), datadbDirname, timestampsFilename)
+	err = verifyRestoredChecksums(corruptedPath)
+	if err == nil {
+		t.Fatalf("expecting non-nil error for corrupted data")
+	}
+	if !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("unexpected error for corrupted data: %s", err)
+	}
+
+	// Verify snapshots deletion
+	if err := s.DeleteSnapshot("invalid-name"); err == nil {
+		t.Fatalf("expecting non-nil error when deleting snapshot with invalid name")
+	}
+	if err := s.DeleteStaleSnapshots(time.Hour); err != nil {
+		t.Fatalf("cannot delete stale snapshots: %s", err)
+	}
+	snapshotNames, err = s.ListSnapshots()
+	if err != nil {
+		t.Fatalf("cannot list snapshots: %s", err)
+	}
+	if len(snapshotNames) != 1 {
+		t.Fatalf("fresh snapshot mustn't be deleted; got %q", snapshotNames)
+	}
+	if err := s.DeleteStaleSnapshots(0); err != nil {
+		t.Fatalf("cannot delete stale snapshots: %s", err)
+	}
+	snapshotNames, err = s.ListSnapshots()
+	if err != nil {
+		t.Fatalf("cannot list snapshots: %s", err)
+	}
+	if len(snapshotNames) != 0 {
+		t.Fatalf("unexpected snapshots after deleting all the snapshots: %q", snapshotNames)
+	}
+	if err := s.DeleteSnapshot(snapshotName); err == nil {
+		t.Fatalf("expecting non-nil error when deleting missing snapshot")
+	}
+
+	s.MustClose()
+
+	fs.MustRemoveAll(path)
+}
+
+// copyDir copies files from srcDir to dstDir recursively.
+func copyDir(t *testing.T, srcDir, dstDir string) {
+	t.Helper()
+
+	err := filepath.WalkDir(srcDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dstDir, relPath)
+		if d.IsDir() {
+			return os.MkdirAll(dstPath, 0755)
+		}
+		fs.MustCopyFile(path, dstPath)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot copy %q to %q: %s", srcDir, dstDir, err)
+	}
+}
+
+// corruptFile changes the first byte of the file with the given filename at the first part in dirname of the first partition at partitionsPath.
+func corruptFile(t *testing.T, partitionsPath, dirname, filename string) {
+	t.Helper()
+
+	des := fs.MustReadDir(partitionsPath)
+	dir := filepath.Join(partitionsPath, des[0].Name(), dirname)
+	for _, de := range fs.MustReadDir(dir) {
+		if !de.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, de.Name(), filename)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("cannot read %q: %s", path, err)
+		}
+		data[0]++
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("cannot write %q: %s", path, err)
+		}
+		return
+	}
+	t.Fatalf("cannot find parts at %q", dir)
+}
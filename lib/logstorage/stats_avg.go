@@ -16,11 +16,25 @@ func (sa *statsAvg) String() string {
 	return "avg(" + statsFuncFieldsToString(sa.fields) + ")"
 }
 
-func (sa *statsAvg) updateNeededFields(neededFields fieldsSet) {
+func (sa *statsAvg) updateNeededFields(neededFields *fieldsSet) {
 	updateNeededFieldsForStatsFunc(neededFields, sa.fields)
 }
 
+func (sa *statsAvg) isPerField() bool {
+	return hasWildcardFieldNames(sa.fields)
+}
+
+func (sa *statsAvg) newStatsFuncForField(field string) statsFunc {
+	return &statsAvg{
+		fields: []string{field},
+	}
+}
+
 func (sa *statsAvg) newStatsProcessor() (statsProcessor, int) {
+	if sa.isPerField() {
+		return newStatsPerFieldProcessor(sa, sa.fields)
+	}
+
 	sap := &statsAvgProcessor{
 		sa: sa,
 	}
@@ -86,6 +100,26 @@ func (sap *statsAvgProcessor) mergeState(sfp statsProcessor) {
 	sap.count += src.count
 }
 
+func (sap *statsAvgProcessor) exportState(dst []byte) []byte {
+	dst = marshalStateFloat64(dst, sap.sum)
+	dst = marshalStateUint64(dst, sap.count)
+	return dst
+}
+
+func (sap *statsAvgProcessor) importState(src []byte) error {
+	sum, src, err := unmarshalStateFloat64(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal sum: %w", err)
+	}
+	count, src, err := unmarshalStateUint64(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal count: %w", err)
+	}
+	sap.sum = sum
+	sap.count = count
+	return checkStateTail(src)
+}
+
 func (sap *statsAvgProcessor) finalizeStats() string {
 	avg := sap.sum / float64(sap.count)
 	return strconv.FormatFloat(avg, 'f', -1, 64)
@@ -121,7 +155,7 @@ func statsFuncFieldsToString(fields []string) string {
 	if len(fields) == 0 {
 		return "*"
 	}
-	return fieldsToString(fields)
+	return fieldNamesString(fields)
 }
 
 func fieldsToString(fields []string) string {
@@ -132,9 +166,9 @@ func fieldsToString(fields []string) string {
 	return strings.Join(a, ", ")
 }
 
-func updateNeededFieldsForStatsFunc(neededFields fieldsSet, fields []string) {
+func updateNeededFieldsForStatsFunc(neededFields *fieldsSet, fields []string) {
 	if len(fields) == 0 {
 		neededFields.add("*")
 	}
-	neededFields.addFields(fields)
+	neededFields.addPatterns(fields)
 }
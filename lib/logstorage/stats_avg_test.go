@@ -13,6 +13,8 @@ func TestParseStatsAvgSuccess(t *testing.T) {
 	f(`avg(*)`)
 	f(`avg(a)`)
 	f(`avg(a, b)`)
+	f(`avg(a*)`)
+	f(`avg(a*, b)`)
 }
 
 func TestParseStatsAvgFailure(t *testing.T) {
@@ -1,6 +1,7 @@
 package logstorage
 
 import (
+	"fmt"
 	"slices"
 	"strconv"
 	"unsafe"
@@ -16,7 +17,7 @@ func (sc *statsCount) String() string {
 	return "count(" + statsFuncFieldsToString(sc.fields) + ")"
 }
 
-func (sc *statsCount) updateNeededFields(neededFields fieldsSet) {
+func (sc *statsCount) updateNeededFields(neededFields *fieldsSet) {
 	if len(sc.fields) == 0 {
 		// There is no need in fetching any columns for count(*) - the number of matching rows can be calculated as len(blockResult.timestamps)
 		return
@@ -194,6 +195,19 @@ func (scp *statsCountProcessor) mergeState(sfp statsProcessor) {
 	scp.rowsCount += src.rowsCount
 }
 
+func (scp *statsCountProcessor) exportState(dst []byte) []byte {
+	return marshalStateUint64(dst, scp.rowsCount)
+}
+
+func (scp *statsCountProcessor) importState(src []byte) error {
+	rowsCount, src, err := unmarshalStateUint64(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal rowsCount: %w", err)
+	}
+	scp.rowsCount = rowsCount
+	return checkStateTail(src)
+}
+
 func (scp *statsCountProcessor) finalizeStats() string {
 	return strconv.FormatUint(scp.rowsCount, 10)
 }
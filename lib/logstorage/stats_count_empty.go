@@ -1,6 +1,7 @@
 package logstorage
 
 import (
+	"fmt"
 	"slices"
 	"strconv"
 	"unsafe"
@@ -16,7 +17,7 @@ func (sc *statsCountEmpty) String() string {
 	return "count_empty(" + statsFuncFieldsToString(sc.fields) + ")"
 }
 
-func (sc *statsCountEmpty) updateNeededFields(neededFields fieldsSet) {
+func (sc *statsCountEmpty) updateNeededFields(neededFields *fieldsSet) {
 	updateNeededFieldsForStatsFunc(neededFields, sc.fields)
 }
 
@@ -191,6 +192,19 @@ func (scp *statsCountEmptyProcessor) mergeState(sfp statsProcessor) {
 	scp.rowsCount += src.rowsCount
 }
 
+func (scp *statsCountEmptyProcessor) exportState(dst []byte) []byte {
+	return marshalStateUint64(dst, scp.rowsCount)
+}
+
+func (scp *statsCountEmptyProcessor) importState(src []byte) error {
+	rowsCount, src, err := unmarshalStateUint64(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal rowsCount: %w", err)
+	}
+	scp.rowsCount = rowsCount
+	return checkStateTail(src)
+}
+
 func (scp *statsCountEmptyProcessor) finalizeStats() string {
 	return strconv.FormatUint(scp.rowsCount, 10)
 }
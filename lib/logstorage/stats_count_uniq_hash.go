@@ -0,0 +1,143 @@
+package logstorage
+
+import (
+	"fmt"
+	"strconv"
+	"unsafe"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// statsCountUniqHash calculates the number of unique values by counting unique 64-bit hashes of these values.
+//
+// It uses much less memory than statsCountUniq on fields with high number of unique values,
+// at the cost of possible undercounting because of hash collisions.
+type statsCountUniqHash struct {
+	fields []string
+	limit  uint64
+}
+
+func (su *statsCountUniqHash) String() string {
+	s := "count_uniq_hash(" + statsFuncFieldsToString(su.fields) + ")"
+	if su.limit > 0 {
+		s += fmt.Sprintf(" limit %d", su.limit)
+	}
+	return s
+}
+
+func (su *statsCountUniqHash) updateNeededFields(neededFields *fieldsSet) {
+	updateNeededFieldsForStatsFunc(neededFields, su.fields)
+}
+
+func (su *statsCountUniqHash) newStatsProcessor() (statsProcessor, int) {
+	sup := &statsCountUniqHashProcessor{
+		su: su,
+
+		m: make(map[uint64]struct{}),
+	}
+	return sup, int(unsafe.Sizeof(*sup))
+}
+
+type statsCountUniqHashProcessor struct {
+	su *statsCountUniqHash
+
+	// m contains hashes for the unique keys.
+	m map[uint64]struct{}
+
+	kb uniqKeysBuilder
+}
+
+func (sup *statsCountUniqHashProcessor) updateStatsForAllRows(br *blockResult) int {
+	if sup.limitReached() {
+		return 0
+	}
+	return sup.kb.updateStatsForAllRows(br, sup.su.fields, sup.updateState)
+}
+
+func (sup *statsCountUniqHashProcessor) updateStatsForRow(br *blockResult, rowIdx int) int {
+	if sup.limitReached() {
+		return 0
+	}
+	return sup.kb.updateStatsForRow(br, rowIdx, sup.su.fields, sup.updateState)
+}
+
+func (sup *statsCountUniqHashProcessor) mergeState(sfp statsProcessor) {
+	if sup.limitReached() {
+		return
+	}
+
+	src := sfp.(*statsCountUniqHashProcessor)
+	m := sup.m
+	for k := range src.m {
+		if _, ok := m[k]; !ok {
+			m[k] = struct{}{}
+		}
+	}
+}
+
+func (sup *statsCountUniqHashProcessor) exportState(dst []byte) []byte {
+	dst = marshalStateUint64(dst, uint64(len(sup.m)))
+	for h := range sup.m {
+		dst = marshalStateUint64(dst, h)
+	}
+	return dst
+}
+
+func (sup *statsCountUniqHashProcessor) importState(src []byte) error {
+	n, src, err := unmarshalStateUint64(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal the number of unique hashes: %w", err)
+	}
+	for i := uint64(0); i < n; i++ {
+		var h uint64
+		h, src, err = unmarshalStateUint64(src)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal unique hash #%d out of %d: %w", i, n, err)
+		}
+		sup.m[h] = struct{}{}
+	}
+	return checkStateTail(src)
+}
+
+func (sup *statsCountUniqHashProcessor) finalizeStats() string {
+	n := uint64(len(sup.m))
+	if limit := sup.su.limit; limit > 0 && n > limit {
+		n = limit
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+func (sup *statsCountUniqHashProcessor) updateState(v []byte) int {
+	stateSizeIncrease := 0
+	h := xxhash.Sum64(v)
+	if _, ok := sup.m[h]; !ok {
+		sup.m[h] = struct{}{}
+		stateSizeIncrease += int(unsafe.Sizeof(h))
+	}
+	return stateSizeIncrease
+}
+
+func (sup *statsCountUniqHashProcessor) limitReached() bool {
+	limit := sup.su.limit
+	return limit > 0 && uint64(len(sup.m)) >= limit
+}
+
+func parseStatsCountUniqHash(lex *lexer) (*statsCountUniqHash, error) {
+	fields, err := parseStatsFuncFields(lex, "count_uniq_hash")
+	if err != nil {
+		return nil, err
+	}
+	su := &statsCountUniqHash{
+		fields: fields,
+	}
+	if lex.isKeyword("limit") {
+		lex.nextToken()
+		n, ok := tryParseUint64(lex.token)
+		if !ok {
+			return nil, fmt.Errorf("cannot parse 'limit %s' for 'count_uniq_hash'", lex.token)
+		}
+		lex.nextToken()
+		su.limit = n
+	}
+	return su, nil
+}
@@ -0,0 +1,184 @@
+package logstorage
+
+import (
+	"strings"
+	"unsafe"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// StatsFunc is a stats function, which can be implemented outside the logstorage package and registered via RegisterStatsFunc.
+type StatsFunc interface {
+	// String must return LogsQL representation of the stats function, which can be parsed back by the registered StatsFuncParser.
+	String() string
+
+	// NeededFields must return the fields needed for calculating the stats.
+	//
+	// The fields may contain wildcards in the form `prefix*`.
+	NeededFields() []string
+
+	// NewStatsProcessor must return new StatsProcessor for calculating the stats.
+	NewStatsProcessor() StatsProcessor
+}
+
+// StatsProcessor calculates the stats for the StatsFunc.
+//
+// All the StatsProcessor methods are called from a single goroutine at a time,
+// so there is no need in the internal synchronization.
+type StatsProcessor interface {
+	// UpdateStatsForAllRows must update the stats for all the rowsCount rows with the given columns.
+	//
+	// The columns contain only the fields returned by StatsFunc.NeededFields.
+	// UpdateStatsForAllRows cannot hold references to columns after returning.
+	//
+	// It must return the change of internal state size in bytes for the StatsProcessor.
+	UpdateStatsForAllRows(rowsCount int, columns []BlockColumn) int
+
+	// UpdateStatsForRow must update the stats for the row at rowIdx in columns.
+	//
+	// The columns contain only the fields returned by StatsFunc.NeededFields.
+	// UpdateStatsForRow cannot hold references to columns after returning.
+	//
+	// It must return the change of internal state size in bytes for the StatsProcessor.
+	UpdateStatsForRow(rowIdx int, columns []BlockColumn) int
+
+	// MergeState must merge the state of sp into the StatsProcessor state.
+	//
+	// sp is always created by the same StatsFunc.
+	MergeState(sp StatsProcessor)
+
+	// ExportState must append the marshaled StatsProcessor state to dst and return the result.
+	//
+	// The exported state is used for spilling the state to disk, for caching partial stats results
+	// and for sending partial stats from storage nodes during query federation.
+	ExportState(dst []byte) []byte
+
+	// ImportState must restore the StatsProcessor state from src obtained via ExportState.
+	//
+	// It is called only on a newly created StatsProcessor.
+	ImportState(src []byte) error
+
+	// FinalizeStats must return the calculated stats.
+	FinalizeStats() string
+}
+
+// StatsFuncParser must parse the stats function starting from the function name at pl.
+//
+// The parser must stop at the first token after the stats function, such as 'as', 'if', ',', '|' or ')'.
+type StatsFuncParser func(pl *PipeLexer) (StatsFunc, error)
+
+// RegisterStatsFunc registers the stats function with the given name, which is parsed by parse.
+//
+// The name is case-insensitive. It mustn't clash with the names of the builtin stats functions, pipes and other registered stats functions.
+//
+// RegisterStatsFunc must be called before parsing queries, e.g. from init() functions. The stats function must be registered
+// at all the VictoriaLogs nodes, which may receive queries with it.
+func RegisterStatsFunc(name string, parse StatsFuncParser) {
+	name = strings.ToLower(name)
+	if !isRegisteredFuncName(name) {
+		logger.Panicf("BUG: stats func name %q must be a single unquoted LogsQL token", name)
+	}
+	if _, ok := getPipeParsers()[name]; ok {
+		logger.Panicf("BUG: stats func name %q clashes with pipe name", name)
+	}
+
+	sp := &statsFuncParser{
+		name: name,
+		parse: func(lex *lexer) (statsFunc, error) {
+			sf, err := parse(&PipeLexer{lex: lex})
+			if err != nil {
+				return nil, err
+			}
+			se := &statsExternal{
+				sf:           sf,
+				neededFields: sf.NeededFields(),
+			}
+			return se, nil
+		},
+	}
+	addStatsFuncParser(getStatsFuncParsers(), sp)
+}
+
+// statsExternal is a stats function registered via RegisterStatsFunc.
+type statsExternal struct {
+	sf StatsFunc
+
+	// neededFields contains the fields returned by sf.NeededFields.
+	neededFields []string
+}
+
+func (se *statsExternal) String() string {
+	return se.sf.String()
+}
+
+func (se *statsExternal) updateNeededFields(neededFields *fieldsSet) {
+	neededFields.addPatterns(se.neededFields)
+}
+
+func (se *statsExternal) newStatsProcessor() (statsProcessor, int) {
+	sep := &statsExternalProcessor{
+		se: se,
+		sp: se.sf.NewStatsProcessor(),
+	}
+	return sep, int(unsafe.Sizeof(*sep))
+}
+
+type statsExternalProcessor struct {
+	se *statsExternal
+	sp StatsProcessor
+
+	// columns is used for passing the needed columns to sp.
+	columns []BlockColumn
+}
+
+func (sep *statsExternalProcessor) updateStatsForAllRows(br *blockResult) int {
+	columns := sep.initColumns(br)
+	stateSizeIncrease := sep.sp.UpdateStatsForAllRows(len(br.timestamps), columns)
+	sep.resetColumns()
+	return stateSizeIncrease
+}
+
+func (sep *statsExternalProcessor) updateStatsForRow(br *blockResult, rowIdx int) int {
+	columns := sep.initColumns(br)
+	stateSizeIncrease := sep.sp.UpdateStatsForRow(rowIdx, columns)
+	sep.resetColumns()
+	return stateSizeIncrease
+}
+
+func (sep *statsExternalProcessor) initColumns(br *blockResult) []BlockColumn {
+	columns := sep.columns[:0]
+	for _, c := range br.getColumns() {
+		if !matchAnyFieldName(sep.se.neededFields, c.name) {
+			continue
+		}
+		columns = append(columns, BlockColumn{
+			Name:   c.name,
+			Values: c.getValues(br),
+		})
+	}
+	sep.columns = columns
+	return columns
+}
+
+func (sep *statsExternalProcessor) resetColumns() {
+	for i := range sep.columns {
+		sep.columns[i].reset()
+	}
+}
+
+func (sep *statsExternalProcessor) mergeState(sfp statsProcessor) {
+	src := sfp.(*statsExternalProcessor)
+	sep.sp.MergeState(src.sp)
+}
+
+func (sep *statsExternalProcessor) exportState(dst []byte) []byte {
+	return sep.sp.ExportState(dst)
+}
+
+func (sep *statsExternalProcessor) importState(src []byte) error {
+	return sep.sp.ImportState(src)
+}
+
+func (sep *statsExternalProcessor) finalizeStats() string {
+	return sep.sp.FinalizeStats()
+}
@@ -0,0 +1,225 @@
+package logstorage
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+// testStatsNonEmpty counts non-empty values for the given field.
+//
+// It uses only the exported API, like the stats functions implemented outside the logstorage package.
+type testStatsNonEmpty struct {
+	field string
+}
+
+func (sn *testStatsNonEmpty) String() string {
+	return "test_nonempty(" + sn.field + ")"
+}
+
+func (sn *testStatsNonEmpty) NeededFields() []string {
+	return []string{sn.field}
+}
+
+func (sn *testStatsNonEmpty) NewStatsProcessor() StatsProcessor {
+	return &testStatsNonEmptyProcessor{}
+}
+
+type testStatsNonEmptyProcessor struct {
+	n uint64
+}
+
+func (snp *testStatsNonEmptyProcessor) UpdateStatsForAllRows(rowsCount int, columns []BlockColumn) int {
+	for i := 0; i < rowsCount; i++ {
+		snp.UpdateStatsForRow(i, columns)
+	}
+	return 0
+}
+
+func (snp *testStatsNonEmptyProcessor) UpdateStatsForRow(rowIdx int, columns []BlockColumn) int {
+	for _, c := range columns {
+		if c.Values[rowIdx] != "" {
+			snp.n++
+		}
+	}
+	return 0
+}
+
+func (snp *testStatsNonEmptyProcessor) MergeState(sp StatsProcessor) {
+	snp.n += sp.(*testStatsNonEmptyProcessor).n
+}
+
+func (snp *testStatsNonEmptyProcessor) ExportState(dst []byte) []byte {
+	return encoding.MarshalVarUint64(dst, snp.n)
+}
+
+func (snp *testStatsNonEmptyProcessor) ImportState(src []byte) error {
+	n, nSize := encoding.UnmarshalVarUint64(src)
+	if nSize <= 0 || nSize != len(src) {
+		return fmt.Errorf("cannot unmarshal the number of non-empty values")
+	}
+	snp.n = n
+	return nil
+}
+
+func (snp *testStatsNonEmptyProcessor) FinalizeStats() string {
+	return strconv.FormatUint(snp.n, 10)
+}
+
+var registerTestStatsFuncsOnce sync.Once
+
+func registerTestStatsFuncs() {
+	registerTestStatsFuncsOnce.Do(func() {
+		RegisterStatsFunc("Test_NonEmpty", func(pl *PipeLexer) (StatsFunc, error) {
+			pl.NextToken()
+			if !pl.IsKeyword("(") {
+				return nil, fmt.Errorf("missing '('; got %q", pl.Token())
+			}
+			pl.NextToken()
+			sn := &testStatsNonEmpty{
+				field: pl.Token(),
+			}
+			pl.NextToken()
+			if !pl.IsKeyword(")") {
+				return nil, fmt.Errorf("missing ')'; got %q", pl.Token())
+			}
+			pl.NextToken()
+			return sn, nil
+		})
+	})
+}
+
+func TestRegisterStatsFunc(t *testing.T) {
+	registerTestStatsFuncs()
+
+	if !isStatsFuncName("test_nonempty") || !isPipeName("TEST_NONEMPTY") {
+		t.Fatalf("the registered stats func must be recognized as stats func name and pipe name")
+	}
+
+	expectParsePipeSuccess(t, "stats test_nonempty(a) as x")
+	expectParsePipeSuccess(t, "stats by (b) test_nonempty(a) if (c:foo) as x")
+	expectParsePipeFailure(t, "stats test_nonempty a")
+
+	q, err := ParseQuery("* | TEST_NONEMPTY(a) x")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if s, sExpected := q.String(), "* | stats test_nonempty(a) as x"; s != sExpected {
+		t.Fatalf("unexpected query string; got %s; want %s", s, sExpected)
+	}
+
+	f := func(pipeStr string, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeResults(t, pipeStr, rows, rowsExpected)
+	}
+
+	rows := [][]Field{
+		{
+			{"a", "1"},
+			{"b", "foo"},
+		},
+		{
+			{"a", ""},
+			{"b", "foo"},
+		},
+		{
+			{"a", "3"},
+			{"b", "bar"},
+		},
+		{
+			{"b", "bar"},
+		},
+		{
+			{"a", "5"},
+		},
+	}
+	f("stats test_nonempty(a) as x", rows, [][]Field{
+		{
+			{"x", "3"},
+		},
+	})
+	f("stats by (b) test_nonempty(a) as x", rows, [][]Field{
+		{
+			{"b", "foo"},
+			{"x", "1"},
+		},
+		{
+			{"b", "bar"},
+			{"x", "1"},
+		},
+		{
+			{"b", ""},
+			{"x", "1"},
+		},
+	})
+	f("stats test_nonempty(a) if (b:bar) as x", rows, [][]Field{
+		{
+			{"x", "1"},
+		},
+	})
+
+	// verify that the state is passed via exportState and importState
+	lex := newLexer("test_nonempty(a)")
+	sf, err := parseStatsFunc(lex)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	sfp, _ := sf.newStatsProcessor()
+	br := newTestStatsBlockResult([][]Field{
+		{
+			{"a", "1"},
+			{"b", "foo"},
+		},
+		{
+			{"a", ""},
+			{"b", "bar"},
+		},
+		{
+			{"a", "3"},
+			{"b", ""},
+		},
+	})
+	sfp.updateStatsForAllRows(br)
+	sfp.updateStatsForRow(br, 2)
+	state := sfp.exportState(nil)
+	sfpNew, _ := sf.newStatsProcessor()
+	if err := sfpNew.importState(state); err != nil {
+		t.Fatalf("unexpected error when importing state: %s", err)
+	}
+	sfpNew.mergeState(sfp)
+	if result := sfpNew.finalizeStats(); result != "6" {
+		t.Fatalf("unexpected result; got %s; want 6", result)
+	}
+}
+
+func TestRegisterStatsFuncFailure(t *testing.T) {
+	registerTestStatsFuncs()
+
+	f := func(name string) {
+		t.Helper()
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("expecting panic when registering stats func %q", name)
+			}
+		}()
+		RegisterStatsFunc(name, func(_ *PipeLexer) (StatsFunc, error) {
+			return nil, fmt.Errorf("unexpected call")
+		})
+	}
+
+	// duplicate names
+	f("test_nonempty")
+	f("count")
+	f("COUNT_UNIQ")
+
+	// pipe names
+	f("sort")
+
+	// invalid names
+	f("")
+	f("foo(")
+	f(`"foo"`)
+}
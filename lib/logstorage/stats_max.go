@@ -1,6 +1,7 @@
 package logstorage
 
 import (
+	"fmt"
 	"math"
 	"strings"
 	"unsafe"
@@ -17,11 +18,25 @@ func (sm *statsMax) String() string {
 	return "max(" + statsFuncFieldsToString(sm.fields) + ")"
 }
 
-func (sm *statsMax) updateNeededFields(neededFields fieldsSet) {
+func (sm *statsMax) updateNeededFields(neededFields *fieldsSet) {
 	updateNeededFieldsForStatsFunc(neededFields, sm.fields)
 }
 
+func (sm *statsMax) isPerField() bool {
+	return hasWildcardFieldNames(sm.fields)
+}
+
+func (sm *statsMax) newStatsFuncForField(field string) statsFunc {
+	return &statsMax{
+		fields: []string{field},
+	}
+}
+
 func (sm *statsMax) newStatsProcessor() (statsProcessor, int) {
+	if sm.isPerField() {
+		return newStatsPerFieldProcessor(sm, sm.fields)
+	}
+
 	smp := &statsMaxProcessor{
 		sm: sm,
 	}
@@ -114,9 +129,9 @@ func (smp *statsMaxProcessor) updateStateForColumn(br *blockResult, c *blockResu
 			smp.updateStateString(v)
 		}
 	case valueTypeDict:
-		for _, v := range c.dictValues {
+		c.forEachDictValue(br, func(v string) {
 			smp.updateStateString(v)
-		}
+		})
 	case valueTypeUint8, valueTypeUint16, valueTypeUint32, valueTypeUint64:
 		bb := bbPool.Get()
 		bb.B = marshalUint64String(bb.B[:0], c.maxValue)
@@ -159,6 +174,19 @@ func (smp *statsMaxProcessor) updateStateString(v string) {
 	smp.max = strings.Clone(v)
 }
 
+func (smp *statsMaxProcessor) exportState(dst []byte) []byte {
+	return marshalStateString(dst, smp.max)
+}
+
+func (smp *statsMaxProcessor) importState(src []byte) error {
+	v, src, err := unmarshalStateString(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal max value: %w", err)
+	}
+	smp.max = v
+	return checkStateTail(src)
+}
+
 func (smp *statsMaxProcessor) finalizeStats() string {
 	return smp.max
 }
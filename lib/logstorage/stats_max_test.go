@@ -13,6 +13,8 @@ func TestParseStatsMaxSuccess(t *testing.T) {
 	f(`max(*)`)
 	f(`max(a)`)
 	f(`max(a, b)`)
+	f(`max(a*)`)
+	f(`max(a*, b)`)
 }
 
 func TestParseStatsMaxFailure(t *testing.T) {
@@ -12,7 +12,7 @@ func (sm *statsMedian) String() string {
 	return "median(" + statsFuncFieldsToString(sm.fields) + ")"
 }
 
-func (sm *statsMedian) updateNeededFields(neededFields fieldsSet) {
+func (sm *statsMedian) updateNeededFields(neededFields *fieldsSet) {
 	updateNeededFieldsForStatsFunc(neededFields, sm.fields)
 }
 
@@ -45,6 +45,14 @@ func (smp *statsMedianProcessor) mergeState(sfp statsProcessor) {
 	smp.sqp.mergeState(src.sqp)
 }
 
+func (smp *statsMedianProcessor) exportState(dst []byte) []byte {
+	return smp.sqp.exportState(dst)
+}
+
+func (smp *statsMedianProcessor) importState(src []byte) error {
+	return smp.sqp.importState(src)
+}
+
 func (smp *statsMedianProcessor) finalizeStats() string {
 	return smp.sqp.finalizeStats()
 }
@@ -1,6 +1,7 @@
 package logstorage
 
 import (
+	"fmt"
 	"math"
 	"strings"
 	"unsafe"
@@ -17,11 +18,25 @@ func (sm *statsMin) String() string {
 	return "min(" + statsFuncFieldsToString(sm.fields) + ")"
 }
 
-func (sm *statsMin) updateNeededFields(neededFields fieldsSet) {
+func (sm *statsMin) updateNeededFields(neededFields *fieldsSet) {
 	updateNeededFieldsForStatsFunc(neededFields, sm.fields)
 }
 
+func (sm *statsMin) isPerField() bool {
+	return hasWildcardFieldNames(sm.fields)
+}
+
+func (sm *statsMin) newStatsFuncForField(field string) statsFunc {
+	return &statsMin{
+		fields: []string{field},
+	}
+}
+
 func (sm *statsMin) newStatsProcessor() (statsProcessor, int) {
+	if sm.isPerField() {
+		return newStatsPerFieldProcessor(sm, sm.fields)
+	}
+
 	smp := &statsMinProcessor{
 		sm: sm,
 	}
@@ -116,9 +131,9 @@ func (smp *statsMinProcessor) updateStateForColumn(br *blockResult, c *blockResu
 			smp.updateStateString(v)
 		}
 	case valueTypeDict:
-		for _, v := range c.dictValues {
+		c.forEachDictValue(br, func(v string) {
 			smp.updateStateString(v)
-		}
+		})
 	case valueTypeUint8, valueTypeUint16, valueTypeUint32, valueTypeUint64:
 		bb := bbPool.Get()
 		bb.B = marshalUint64String(bb.B[:0], c.minValue)
@@ -161,6 +176,19 @@ func (smp *statsMinProcessor) updateStateString(v string) {
 	smp.min = strings.Clone(v)
 }
 
+func (smp *statsMinProcessor) exportState(dst []byte) []byte {
+	return marshalStateString(dst, smp.min)
+}
+
+func (smp *statsMinProcessor) importState(src []byte) error {
+	v, src, err := unmarshalStateString(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal min value: %w", err)
+	}
+	smp.min = v
+	return checkStateTail(src)
+}
+
 func (smp *statsMinProcessor) finalizeStats() string {
 	return smp.min
 }
@@ -13,6 +13,8 @@ func TestParseStatsMinSuccess(t *testing.T) {
 	f(`min(*)`)
 	f(`min(a)`)
 	f(`min(a, b)`)
+	f(`min(a*)`)
+	f(`min(a*, b)`)
 }
 
 func TestParseStatsMinFailure(t *testing.T) {
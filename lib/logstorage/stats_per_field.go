@@ -0,0 +1,196 @@
+package logstorage
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unsafe"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+// statsPerFieldFunc is implemented by stats functions, which accept wildcards in the form `prefix*` in their args.
+//
+// Such functions calculate stats individually per every field matching the wildcards, such as `sum(metrics_*)`,
+// and return a separate result per every matching field.
+type statsPerFieldFunc interface {
+	statsFunc
+
+	// isPerField must return true if the stats function args contain wildcards.
+	isPerField() bool
+
+	// newStatsFuncForField must return the stats function for the given field.
+	newStatsFuncForField(field string) statsFunc
+}
+
+// isPerFieldStatsFunc returns true if sf returns a separate result per every field matching wildcards in its args.
+func isPerFieldStatsFunc(sf statsFunc) bool {
+	spf, ok := sf.(statsPerFieldFunc)
+	return ok && spf.isPerField()
+}
+
+// hasWildcardFieldNames returns true if fields contain wildcards in the form `prefix*`.
+func hasWildcardFieldNames(fields []string) bool {
+	return slices.ContainsFunc(fields, isWildcardFieldName)
+}
+
+// getPerFieldResultName returns the result name for the given field matching args of sf with the given resultName.
+//
+// If resultName is a wildcard in the form `prefix*`, then the `*` is substituted with the field name.
+// Otherwise the default result name for sf applied to the field is returned, such as `sum(field)`.
+func getPerFieldResultName(sf statsPerFieldFunc, resultName, field string) string {
+	if isWildcardFieldName(resultName) {
+		return resultName[:len(resultName)-1] + field
+	}
+	return sf.newStatsFuncForField(field).String()
+}
+
+// statsPerFieldProcessor calculates stats individually per every field matching the args of sf.
+type statsPerFieldProcessor struct {
+	sf     statsPerFieldFunc
+	fields []string
+
+	// m contains stats processors per every field seen so far.
+	m map[string]statsProcessor
+}
+
+func newStatsPerFieldProcessor(sf statsPerFieldFunc, fields []string) (statsProcessor, int) {
+	spp := &statsPerFieldProcessor{
+		sf:     sf,
+		fields: fields,
+		m:      make(map[string]statsProcessor),
+	}
+	return spp, int(unsafe.Sizeof(*spp))
+}
+
+func (spp *statsPerFieldProcessor) updateStatsForAllRows(br *blockResult) int {
+	stateSizeIncrease := 0
+	for _, c := range br.getColumns() {
+		if !spp.matchField(c.name) {
+			continue
+		}
+		sfp, n := spp.getStatsProcessor(c.name)
+		stateSizeIncrease += n
+		stateSizeIncrease += sfp.updateStatsForAllRows(br)
+	}
+	return stateSizeIncrease
+}
+
+func (spp *statsPerFieldProcessor) updateStatsForRow(br *blockResult, rowIdx int) int {
+	stateSizeIncrease := 0
+	for _, c := range br.getColumns() {
+		if !spp.matchField(c.name) {
+			continue
+		}
+		sfp, n := spp.getStatsProcessor(c.name)
+		stateSizeIncrease += n
+		stateSizeIncrease += sfp.updateStatsForRow(br, rowIdx)
+	}
+	return stateSizeIncrease
+}
+
+// matchField returns true if the given field matches spp args.
+//
+// Fields with wildcard-like names are skipped, since the stats function for them would return per-field results again.
+func (spp *statsPerFieldProcessor) matchField(field string) bool {
+	return !isWildcardFieldName(field) && matchAnyFieldName(spp.fields, field)
+}
+
+// getStatsProcessor returns stats processor for the given field.
+//
+// It also returns the state size increase if the stats processor has been created.
+func (spp *statsPerFieldProcessor) getStatsProcessor(field string) (statsProcessor, int) {
+	if sfp, ok := spp.m[field]; ok {
+		return sfp, 0
+	}
+	field = strings.Clone(field)
+	sfp, n := spp.sf.newStatsFuncForField(field).newStatsProcessor()
+	spp.m[field] = sfp
+	return sfp, n + len(field) + int(unsafe.Sizeof(field)+unsafe.Sizeof(sfp))
+}
+
+func (spp *statsPerFieldProcessor) mergeState(sfp statsProcessor) {
+	src := sfp.(*statsPerFieldProcessor)
+	for field, sfpSrc := range src.m {
+		if sfpDst, ok := spp.m[field]; ok {
+			sfpDst.mergeState(sfpSrc)
+		} else {
+			spp.m[field] = sfpSrc
+		}
+	}
+}
+
+func (spp *statsPerFieldProcessor) exportState(dst []byte) []byte {
+	fields := spp.getSortedFields()
+	dst = marshalStateUint64(dst, uint64(len(fields)))
+	var state []byte
+	for _, field := range fields {
+		dst = marshalStateString(dst, field)
+		state = spp.m[field].exportState(state[:0])
+		dst = encoding.MarshalBytes(dst, state)
+	}
+	return dst
+}
+
+func (spp *statsPerFieldProcessor) importState(src []byte) error {
+	n, src, err := unmarshalStateUint64(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal the number of fields: %w", err)
+	}
+	for i := uint64(0); i < n; i++ {
+		var field string
+		field, src, err = unmarshalStateString(src)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal field name #%d out of %d: %w", i, n, err)
+		}
+		state, nSize := encoding.UnmarshalBytes(src)
+		if nSize <= 0 {
+			return fmt.Errorf("cannot unmarshal state for field %q", field)
+		}
+		src = src[nSize:]
+
+		sfp, _ := spp.sf.newStatsFuncForField(field).newStatsProcessor()
+		if err := sfp.importState(state); err != nil {
+			return fmt.Errorf("cannot import state for field %q: %w", field, err)
+		}
+		spp.m[field] = sfp
+	}
+	return checkStateTail(src)
+}
+
+// finalizeStats returns the collected results per every field as JSON object.
+//
+// The pipeStatsWriteContext uses finalizeStatsFields instead, so every result is returned in a separate field.
+func (spp *statsPerFieldProcessor) finalizeStats() string {
+	dst := []byte{'{'}
+	for i, field := range spp.getSortedFields() {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = marshalJSONKeyValue(dst, field, spp.m[field].finalizeStats())
+	}
+	dst = append(dst, '}')
+	return string(dst)
+}
+
+// finalizeStatsFields appends the collected results per every field to dst and returns the result.
+//
+// The names for the results are generated from resultName via getPerFieldResultName.
+func (spp *statsPerFieldProcessor) finalizeStatsFields(dst []Field, resultName string) []Field {
+	for _, field := range spp.getSortedFields() {
+		dst = append(dst, Field{
+			Name:  getPerFieldResultName(spp.sf, resultName, field),
+			Value: spp.m[field].finalizeStats(),
+		})
+	}
+	return dst
+}
+
+func (spp *statsPerFieldProcessor) getSortedFields() []string {
+	fields := make([]string, 0, len(spp.m))
+	for field := range spp.m {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	return fields
+}
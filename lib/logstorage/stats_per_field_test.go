@@ -0,0 +1,131 @@
+package logstorage
+
+import (
+	"testing"
+)
+
+func TestStatsPerField(t *testing.T) {
+	f := func(pipeStr string, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeResults(t, pipeStr, rows, rowsExpected)
+	}
+
+	rows := [][]Field{
+		{
+			{"host", "a"},
+			{"m_cpu", `10`},
+			{"m_mem", `100`},
+		},
+		{
+			{"host", "a"},
+			{"m_cpu", `20`},
+		},
+		{
+			{"host", "b"},
+			{"m_disk", `5`},
+			{"x", `7`},
+		},
+	}
+
+	f("stats sum(m_*)", rows, [][]Field{
+		{
+			{"sum(m_cpu)", "30"},
+			{"sum(m_disk)", "5"},
+			{"sum(m_mem)", "100"},
+		},
+	})
+
+	f("stats by (host) avg(m_*) as avg_*, count() as rows", rows, [][]Field{
+		{
+			{"host", "a"},
+			{"avg_m_cpu", "15"},
+			{"avg_m_mem", "100"},
+			{"rows", "2"},
+		},
+		{
+			{"host", "b"},
+			{"avg_m_disk", "5"},
+			{"rows", "1"},
+		},
+	})
+
+	f("stats min(m_*, x) as min_*, max(m_*, x) as max_*", rows, [][]Field{
+		{
+			{"min_m_cpu", "10"},
+			{"min_m_disk", "5"},
+			{"min_m_mem", "100"},
+			{"min_x", "7"},
+			{"max_m_cpu", "20"},
+			{"max_m_disk", "5"},
+			{"max_m_mem", "100"},
+			{"max_x", "7"},
+		},
+	})
+
+	// per-field stats with additional filter
+	f("stats sum(m_*) if (host:a) as sum_*", rows, [][]Field{
+		{
+			{"sum_m_cpu", "30"},
+			{"sum_m_mem", "100"},
+		},
+	})
+
+	// missing fields
+	f("stats sum(foo_*) as sum_*, count() as rows", rows, [][]Field{
+		{
+			{"rows", "3"},
+		},
+	})
+}
+
+func TestStatsPerFieldExportImportState(t *testing.T) {
+	f := func(funcStr string) {
+		t.Helper()
+
+		lex := newLexer(funcStr)
+		sf, err := parseStatsFunc(lex)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", funcStr, err)
+		}
+		if !isPerFieldStatsFunc(sf) {
+			t.Fatalf("expecting per-field stats func for %q", funcStr)
+		}
+
+		rows := [][]Field{
+			{
+				{"a1", "1"},
+				{"a2", "foo"},
+				{"b", "10"},
+			},
+			{
+				{"a1", "3.5"},
+				{"a2", ""},
+				{"b", "-2"},
+			},
+		}
+
+		sfp, _ := sf.newStatsProcessor()
+		sfp.updateStatsForAllRows(newTestStatsBlockResult(rows))
+		resultExpected := sfp.finalizeStats()
+
+		state := sfp.exportState(nil)
+		sfpImported, _ := sf.newStatsProcessor()
+		if err := sfpImported.importState(state); err != nil {
+			t.Fatalf("cannot import state for %q: %s", funcStr, err)
+		}
+		result := sfpImported.finalizeStats()
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %q after importing the state; got %q; want %q", funcStr, result, resultExpected)
+		}
+
+		sfpBroken, _ := sf.newStatsProcessor()
+		if err := sfpBroken.importState(state[:len(state)-1]); err == nil {
+			t.Fatalf("expecting non-nil error when importing truncated state for %q", funcStr)
+		}
+	}
+
+	f("avg(a*)")
+	f("max(a*)")
+	f("min(a*, b)")
+	f("sum(a*)")
+}
@@ -7,8 +7,6 @@ import (
 	"strconv"
 	"unsafe"
 
-	"github.com/valyala/fastrand"
-
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 )
@@ -29,7 +27,7 @@ func (sq *statsQuantile) String() string {
 	return s
 }
 
-func (sq *statsQuantile) updateNeededFields(neededFields fieldsSet) {
+func (sq *statsQuantile) updateNeededFields(neededFields *fieldsSet) {
 	updateNeededFieldsForStatsFunc(neededFields, sq.fields)
 }
 
@@ -128,35 +126,35 @@ func (sqp *statsQuantileProcessor) updateStateForColumn(br *blockResult, c *bloc
 			idx := v[0]
 			f := a.A[idx]
 			if !math.IsNaN(f) {
-				h.update(f)
+				stateSizeIncrease += h.update(f)
 			}
 		}
 		encoding.PutFloat64s(a)
 	case valueTypeUint8:
 		for _, v := range c.getValuesEncoded(br) {
 			n := unmarshalUint8(v)
-			h.update(float64(n))
+			stateSizeIncrease += h.update(float64(n))
 		}
 	case valueTypeUint16:
 		for _, v := range c.getValuesEncoded(br) {
 			n := unmarshalUint16(v)
-			h.update(float64(n))
+			stateSizeIncrease += h.update(float64(n))
 		}
 	case valueTypeUint32:
 		for _, v := range c.getValuesEncoded(br) {
 			n := unmarshalUint32(v)
-			h.update(float64(n))
+			stateSizeIncrease += h.update(float64(n))
 		}
 	case valueTypeUint64:
 		for _, v := range c.getValuesEncoded(br) {
 			n := unmarshalUint64(v)
-			h.update(float64(n))
+			stateSizeIncrease += h.update(float64(n))
 		}
 	case valueTypeFloat64:
 		for _, v := range c.getValuesEncoded(br) {
 			f := unmarshalFloat64(v)
 			if !math.IsNaN(f) {
-				h.update(f)
+				stateSizeIncrease += h.update(f)
 			}
 		}
 	case valueTypeIPv4:
@@ -173,6 +171,80 @@ func (sqp *statsQuantileProcessor) mergeState(sfp statsProcessor) {
 	sqp.h.mergeState(&src.h)
 }
 
+func (sqp *statsQuantileProcessor) exportState(dst []byte) []byte {
+	h := &sqp.h
+	dst = marshalStateUint64(dst, h.count)
+	dst = marshalStateFloat64(dst, h.min)
+	dst = marshalStateFloat64(dst, h.max)
+	dst = marshalStateUint64(dst, uint64(len(h.centroids)))
+	for _, c := range h.centroids {
+		dst = marshalStateFloat64(dst, c.mean)
+		dst = marshalStateFloat64(dst, c.weight)
+	}
+	dst = marshalStateUint64(dst, uint64(len(h.buf)))
+	for _, f := range h.buf {
+		dst = marshalStateFloat64(dst, f)
+	}
+	return dst
+}
+
+func (sqp *statsQuantileProcessor) importState(src []byte) error {
+	h := &sqp.h
+
+	count, src, err := unmarshalStateUint64(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal count: %w", err)
+	}
+	minValue, src, err := unmarshalStateFloat64(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal min value: %w", err)
+	}
+	maxValue, src, err := unmarshalStateFloat64(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal max value: %w", err)
+	}
+	centroidsLen, src, err := unmarshalStateUint64(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal the number of centroids: %w", err)
+	}
+	if centroidsLen > maxHistogramCentroids {
+		return fmt.Errorf("too many centroids: %d; mustn't exceed %d", centroidsLen, maxHistogramCentroids)
+	}
+	centroids := make([]histogramCentroid, centroidsLen)
+	for i := range centroids {
+		c := &centroids[i]
+		c.mean, src, err = unmarshalStateFloat64(src)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal mean for centroid #%d out of %d: %w", i, centroidsLen, err)
+		}
+		c.weight, src, err = unmarshalStateFloat64(src)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal weight for centroid #%d out of %d: %w", i, centroidsLen, err)
+		}
+	}
+	bufLen, src, err := unmarshalStateUint64(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal the number of buffered values: %w", err)
+	}
+	if bufLen > histogramBufferSize {
+		return fmt.Errorf("too many buffered values: %d; mustn't exceed %d", bufLen, histogramBufferSize)
+	}
+	buf := make([]float64, bufLen)
+	for i := range buf {
+		buf[i], src, err = unmarshalStateFloat64(src)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal buffered value #%d out of %d: %w", i, bufLen, err)
+		}
+	}
+
+	h.centroids = centroids
+	h.buf = buf
+	h.min = minValue
+	h.max = maxValue
+	h.count = count
+	return checkStateTail(src)
+}
+
 func (sqp *statsQuantileProcessor) finalizeStats() string {
 	q := sqp.h.quantile(sqp.sq.phi)
 	return strconv.FormatFloat(q, 'f', -1, 64)
@@ -217,15 +289,40 @@ func parseStatsQuantile(lex *lexer) (*statsQuantile, error) {
 	return sq, nil
 }
 
+// histogram is a mergeable t-digest, which estimates quantiles over unbounded number of values with bounded memory usage.
+//
+// See https://arxiv.org/abs/1902.04023
+//
+// Quantiles are exact until the number of values exceeds histogramBufferSize.
+// The histogram doesn't use randomness, so it returns the same results for the same sequence of updates and merges.
 type histogram struct {
-	a     []float64
+	// centroids contains centroids sorted by mean.
+	centroids []histogramCentroid
+
+	// buf contains values, which aren't merged into centroids yet.
+	buf []float64
+
 	min   float64
 	max   float64
 	count uint64
+}
 
-	rng fastrand.RNG
+type histogramCentroid struct {
+	mean   float64
+	weight float64
 }
 
+// histogramCompression is the t-digest compression parameter.
+//
+// Higher values improve the quantile accuracy at the cost of higher memory usage.
+const histogramCompression = 200
+
+// histogramBufferSize is the number of values to buffer before merging them into centroids.
+const histogramBufferSize = 5 * histogramCompression
+
+// maxHistogramCentroids is the upper bound for the number of centroids in the histogram.
+const maxHistogramCentroids = 2 * histogramCompression
+
 func (h *histogram) update(f float64) int {
 	if h.count == 0 || f < h.min {
 		h.min = f
@@ -233,50 +330,113 @@ func (h *histogram) update(f float64) int {
 	if h.count == 0 || f > h.max {
 		h.max = f
 	}
-
 	h.count++
-	if len(h.a) < maxHistogramSamples {
-		h.a = append(h.a, f)
-		return int(unsafe.Sizeof(f))
-	}
 
-	if n := h.rng.Uint32n(uint32(h.count)); n < uint32(len(h.a)) {
-		h.a[n] = f
+	sizeBefore := h.sizeBytes()
+	if len(h.buf) >= histogramBufferSize {
+		h.compress(nil)
 	}
-	return 0
+	h.buf = append(h.buf, f)
+	return h.sizeBytes() - sizeBefore
 }
 
-const maxHistogramSamples = 100_000
+func (h *histogram) sizeBytes() int {
+	return cap(h.buf)*int(unsafe.Sizeof(h.buf[0])) + cap(h.centroids)*int(unsafe.Sizeof(h.centroids[0]))
+}
 
 func (h *histogram) mergeState(src *histogram) {
 	if src.count == 0 {
 		// Nothing to merge
 		return
 	}
-	if h.count == 0 {
-		h.a = append(h.a, src.a...)
+	if h.count == 0 || src.min < h.min {
 		h.min = src.min
+	}
+	if h.count == 0 || src.max > h.max {
 		h.max = src.max
-		h.count = src.count
+	}
+	h.count += src.count
+
+	h.buf = append(h.buf, src.buf...)
+	if len(src.centroids) > 0 || len(h.buf) > histogramBufferSize {
+		h.compress(src.centroids)
+	}
+}
+
+// compress merges h.buf and the given centroids into h.centroids.
+func (h *histogram) compress(extraCentroids []histogramCentroid) {
+	all := make([]histogramCentroid, 0, len(h.centroids)+len(extraCentroids)+len(h.buf))
+	all = append(all, h.centroids...)
+	all = append(all, extraCentroids...)
+	totalWeight := 0.0
+	for _, c := range all {
+		totalWeight += c.weight
+	}
+	for _, f := range h.buf {
+		all = append(all, histogramCentroid{
+			mean:   f,
+			weight: 1,
+		})
+	}
+	totalWeight += float64(len(h.buf))
+	h.buf = h.buf[:0]
+	if len(all) == 0 {
 		return
 	}
 
-	h.a = append(h.a, src.a...)
-	if src.min < h.min {
-		h.min = src.min
+	// Centroids with equal means and weights are interchangeable, so the result doesn't depend on the initial order of centroids.
+	slices.SortFunc(all, func(a, b histogramCentroid) int {
+		if a.mean != b.mean {
+			if a.mean < b.mean {
+				return -1
+			}
+			return 1
+		}
+		if a.weight != b.weight {
+			if a.weight < b.weight {
+				return -1
+			}
+			return 1
+		}
+		return 0
+	})
+
+	// Merge adjacent centroids while their combined weight fits the limit set by the k1 scale function.
+	dst := h.centroids[:0]
+	cur := all[0]
+	weightSoFar := 0.0
+	weightLimit := totalWeight * histogramQuantileLimit(0)
+	for _, c := range all[1:] {
+		if weightSoFar+cur.weight+c.weight <= weightLimit {
+			cur.weight += c.weight
+			cur.mean += (c.mean - cur.mean) * c.weight / cur.weight
+			continue
+		}
+		weightSoFar += cur.weight
+		dst = append(dst, cur)
+		weightLimit = totalWeight * histogramQuantileLimit(weightSoFar/totalWeight)
+		cur = c
 	}
-	if src.max > h.max {
-		h.max = src.max
+	dst = append(dst, cur)
+	h.centroids = dst
+}
+
+// histogramQuantileLimit returns the maximum quantile, which can be covered by the centroid starting at quantile q.
+func histogramQuantileLimit(q float64) float64 {
+	// k1 scale function: k(q) = compression * (asin(2q-1)/pi + 1/2)
+	k := histogramCompression*(math.Asin(2*q-1)/math.Pi+0.5) + 1
+	if k >= histogramCompression {
+		return 1
 	}
-	h.count += src.count
+	return (math.Sin(k*math.Pi/histogramCompression-math.Pi/2) + 1) / 2
 }
 
 func (h *histogram) quantile(phi float64) float64 {
-	if len(h.a) == 0 {
+	if h.count == 0 {
 		return nan
 	}
-	if len(h.a) == 1 {
-		return h.a[0]
+	if len(h.centroids) == 0 {
+		return h.exactQuantile(phi)
 	}
 	if phi <= 0 {
 		return h.min
@@ -285,10 +445,59 @@ func (h *histogram) quantile(phi float64) float64 {
 		return h.max
 	}
 
-	slices.Sort(h.a)
-	idx := int(phi * float64(len(h.a)))
-	if idx == len(h.a) {
+	h.compress(nil)
+	cs := h.centroids
+	if len(cs) == 1 {
+		return clampFloat64(cs[0].mean, h.min, h.max)
+	}
+
+	// Linearly interpolate between centroid centers.
+	totalWeight := 0.0
+	for _, c := range cs {
+		totalWeight += c.weight
+	}
+	target := phi * totalWeight
+	first := cs[0]
+	if target < first.weight/2 {
+		return h.min + (first.mean-h.min)*target/(first.weight/2)
+	}
+	weightSoFar := 0.0
+	for i := 0; i < len(cs)-1; i++ {
+		c, next := cs[i], cs[i+1]
+		center := weightSoFar + c.weight/2
+		nextCenter := weightSoFar + c.weight + next.weight/2
+		if target < nextCenter {
+			v := c.mean + (next.mean-c.mean)*(target-center)/(nextCenter-center)
+			return clampFloat64(v, h.min, h.max)
+		}
+		weightSoFar += c.weight
+	}
+	last := cs[len(cs)-1]
+	lastCenter := totalWeight - last.weight/2
+	v := last.mean + (h.max-last.mean)*(target-lastCenter)/(last.weight/2)
+	return clampFloat64(v, h.min, h.max)
+}
+
+func (h *histogram) exactQuantile(phi float64) float64 {
+	a := h.buf
+	if len(a) == 1 {
+		return a[0]
+	}
+	if phi <= 0 {
+		return h.min
+	}
+	if phi >= 1 {
 		return h.max
 	}
-	return h.a[idx]
+
+	slices.Sort(a)
+	idx := int(phi * float64(len(a)))
+	if idx == len(a) {
+		return h.max
+	}
+	return a[idx]
+}
+
+func clampFloat64(f, minValue, maxValue float64) float64 {
+	return max(minValue, min(f, maxValue))
 }
@@ -415,3 +415,102 @@ func TestHistogramQuantile(t *testing.T) {
 	f([]float64{5, 1, 3}, 1, 5)
 	f([]float64{5, 1, 3}, 10, 5)
 }
+
+func TestHistogramMergeState(t *testing.T) {
+	f := func(shards, valuesPerShard int) {
+		t.Helper()
+
+		newMergedHistogram := func() *histogram {
+			var hMain histogram
+			n := 0
+			for i := 0; i < shards; i++ {
+				var h histogram
+				for j := 0; j < valuesPerShard; j++ {
+					// Spread values across shards in non-sorted order
+					h.update(float64((n * 7919) % (shards * valuesPerShard)))
+					n++
+				}
+				hMain.mergeState(&h)
+			}
+			return &hMain
+		}
+
+		hMain := newMergedHistogram()
+		n := shards * valuesPerShard
+		if hMain.count != uint64(n) {
+			t.Fatalf("unexpected count; got %d; want %d", hMain.count, n)
+		}
+		if len(hMain.centroids) > maxHistogramCentroids {
+			t.Fatalf("too many centroids after the merge; got %d; want up to %d", len(hMain.centroids), maxHistogramCentroids)
+		}
+		if len(hMain.buf) > histogramBufferSize {
+			t.Fatalf("too many buffered values after the merge; got %d; want up to %d", len(hMain.buf), histogramBufferSize)
+		}
+		if hMain.min != 0 {
+			t.Fatalf("unexpected min; got %v; want 0", hMain.min)
+		}
+		if hMain.max != float64(n-1) {
+			t.Fatalf("unexpected max; got %v; want %d", hMain.max, n-1)
+		}
+
+		// Verify quantiles are close to the real quantiles
+		for _, phi := range []float64{0.01, 0.1, 0.5, 0.9, 0.99, 0.999} {
+			q := hMain.quantile(phi)
+			qExpected := phi * float64(n)
+			if math.Abs(q-qExpected) > max(1, float64(n)*0.01) {
+				t.Fatalf("unexpected quantile for phi=%v; got %v; want %v", phi, q, qExpected)
+			}
+		}
+
+		// Verify the same sequence of updates and merges results in the same state
+		hNew := newMergedHistogram()
+		for _, phi := range []float64{0.01, 0.5, 0.99} {
+			q := hMain.quantile(phi)
+			qNew := hNew.quantile(phi)
+			if q != qNew {
+				t.Fatalf("non-deterministic quantile for phi=%v; got %v and %v", phi, q, qNew)
+			}
+		}
+	}
+
+	f(1, 10)
+	f(5, 10)
+	f(3, 50_000)
+	f(8, 100_000)
+}
+
+func TestHistogramExportImportState(t *testing.T) {
+	f := func(n int) {
+		t.Helper()
+
+		var h histogram
+		for i := 0; i < n; i++ {
+			h.update(float64(i))
+		}
+		sqp := &statsQuantileProcessor{
+			h: h,
+		}
+		data := sqp.exportState(nil)
+
+		var sqpNew statsQuantileProcessor
+		if err := sqpNew.importState(data); err != nil {
+			t.Fatalf("cannot import state: %s", err)
+		}
+		if dataNew := sqpNew.exportState(nil); string(dataNew) != string(data) {
+			t.Fatalf("unexpected state after import\ngot\n%X\nwant\n%X", dataNew, data)
+		}
+		for _, phi := range []float64{0, 0.5, 0.99, 1} {
+			q := sqp.h.quantile(phi)
+			qNew := sqpNew.h.quantile(phi)
+			if q != qNew && (!math.IsNaN(q) || !math.IsNaN(qNew)) {
+				t.Fatalf("unexpected quantile for phi=%v after import; got %v; want %v", phi, qNew, q)
+			}
+		}
+	}
+
+	f(0)
+	f(1)
+	f(histogramBufferSize)
+	f(histogramBufferSize + 1)
+	f(10 * histogramBufferSize)
+}
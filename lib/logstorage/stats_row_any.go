@@ -15,7 +15,7 @@ func (sa *statsRowAny) String() string {
 	return "row_any(" + statsFuncFieldsToString(sa.fields) + ")"
 }
 
-func (sa *statsRowAny) updateNeededFields(neededFields fieldsSet) {
+func (sa *statsRowAny) updateNeededFields(neededFields *fieldsSet) {
 	if len(sa.fields) == 0 {
 		neededFields.add("*")
 	} else {
@@ -97,6 +97,26 @@ func (sap *statsRowAnyProcessor) updateState(br *blockResult, rowIdx int) int {
 	return stateSizeIncrease
 }
 
+func (sap *statsRowAnyProcessor) exportState(dst []byte) []byte {
+	dst = marshalStateBool(dst, sap.captured)
+	dst = marshalStateFields(dst, sap.fields)
+	return dst
+}
+
+func (sap *statsRowAnyProcessor) importState(src []byte) error {
+	captured, src, err := unmarshalStateBool(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal captured flag: %w", err)
+	}
+	fields, src, err := unmarshalStateFields(nil, src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal fields: %w", err)
+	}
+	sap.captured = captured
+	sap.fields = fields
+	return checkStateTail(src)
+}
+
 func (sap *statsRowAnyProcessor) finalizeStats() string {
 	bb := bbPool.Get()
 	bb.B = MarshalFieldsToJSON(bb.B, sap.fields)
@@ -26,7 +26,7 @@ func (sm *statsRowMax) String() string {
 	return s
 }
 
-func (sm *statsRowMax) updateNeededFields(neededFields fieldsSet) {
+func (sm *statsRowMax) updateNeededFields(neededFields *fieldsSet) {
 	if len(sm.fetchFields) == 0 {
 		neededFields.add("*")
 	} else {
@@ -60,10 +60,19 @@ func (smp *statsRowMaxProcessor) updateStatsForAllRows(br *blockResult) int {
 		return stateSizeIncrease
 	}
 	if c.isTime {
+		// Rows in br may be unsorted by time, so locate the row with the maximum timestamp.
+		timestamps := br.timestamps
+		rowIdx := 0
+		for i, timestamp := range timestamps {
+			if timestamp > timestamps[rowIdx] {
+				rowIdx = i
+			}
+		}
+
 		bb := bbPool.Get()
-		bb.B = marshalTimestampRFC3339NanoString(bb.B[:0], br.timestamps[0])
+		bb.B = marshalTimestampRFC3339NanoString(bb.B[:0], timestamps[rowIdx])
 		v := bytesutil.ToUnsafeString(bb.B)
-		stateSizeIncrease += smp.updateState(v, br, 0)
+		stateSizeIncrease += smp.updateState(v, br, rowIdx)
 		bbPool.Put(bb)
 		return stateSizeIncrease
 	}
@@ -73,12 +82,11 @@ func (smp *statsRowMaxProcessor) updateStatsForAllRows(br *blockResult) int {
 	case valueTypeString:
 		needUpdateState = true
 	case valueTypeDict:
-		for _, v := range c.dictValues {
-			if smp.needUpdateStateString(v) {
+		c.forEachDictValue(br, func(v string) {
+			if !needUpdateState && smp.needUpdateStateString(v) {
 				needUpdateState = true
-				break
 			}
-		}
+		})
 	case valueTypeUint8, valueTypeUint16, valueTypeUint32, valueTypeUint64:
 		bb := bbPool.Get()
 		bb.B = marshalUint64String(bb.B[:0], c.maxValue)
@@ -204,6 +212,26 @@ func (smp *statsRowMaxProcessor) updateState(v string, br *blockResult, rowIdx i
 	return stateSizeIncrease
 }
 
+func (smp *statsRowMaxProcessor) exportState(dst []byte) []byte {
+	dst = marshalStateString(dst, smp.max)
+	dst = marshalStateFields(dst, smp.fields)
+	return dst
+}
+
+func (smp *statsRowMaxProcessor) importState(src []byte) error {
+	v, src, err := unmarshalStateString(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal max value: %w", err)
+	}
+	fields, src, err := unmarshalStateFields(nil, src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal fields: %w", err)
+	}
+	smp.max = v
+	smp.fields = fields
+	return checkStateTail(src)
+}
+
 func (smp *statsRowMaxProcessor) finalizeStats() string {
 	bb := bbPool.Get()
 	bb.B = MarshalFieldsToJSON(bb.B, smp.fields)
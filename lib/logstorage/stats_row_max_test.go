@@ -284,3 +284,28 @@ func TestStatsRowMax(t *testing.T) {
 		},
 	})
 }
+
+func TestStatsRowMaxTimeUnsorted(t *testing.T) {
+	br := newTestStatsBlockResult([][]Field{
+		{{"a", "0"}},
+		{{"a", "1"}},
+		{{"a", "2"}},
+		{{"a", "3"}},
+	})
+	copy(br.timestamps, []int64{200, 400, 100, 300})
+	br.addTimeColumn()
+
+	lex := newLexer("row_max(_time, a)")
+	sf, err := parseStatsFunc(lex)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	sfp, _ := sf.newStatsProcessor()
+	sfp.updateStatsForAllRows(br)
+
+	result := sfp.finalizeStats()
+	resultExpected := `{"a":"1"}`
+	if result != resultExpected {
+		t.Fatalf("unexpected result; got %s; want %s", result, resultExpected)
+	}
+}
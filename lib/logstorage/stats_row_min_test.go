@@ -283,3 +283,28 @@ func TestStatsRowMin(t *testing.T) {
 		},
 	})
 }
+
+func TestStatsRowMinTimeUnsorted(t *testing.T) {
+	br := newTestStatsBlockResult([][]Field{
+		{{"a", "0"}},
+		{{"a", "1"}},
+		{{"a", "2"}},
+		{{"a", "3"}},
+	})
+	copy(br.timestamps, []int64{200, 400, 100, 300})
+	br.addTimeColumn()
+
+	lex := newLexer("row_min(_time, a)")
+	sf, err := parseStatsFunc(lex)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	sfp, _ := sf.newStatsProcessor()
+	sfp.updateStatsForAllRows(br)
+
+	result := sfp.finalizeStats()
+	resultExpected := `{"a":"2"}`
+	if result != resultExpected {
+		t.Fatalf("unexpected result; got %s; want %s", result, resultExpected)
+	}
+}
@@ -1,6 +1,7 @@
 package logstorage
 
 import (
+	"fmt"
 	"math"
 	"strconv"
 	"unsafe"
@@ -14,11 +15,25 @@ func (ss *statsSum) String() string {
 	return "sum(" + statsFuncFieldsToString(ss.fields) + ")"
 }
 
-func (ss *statsSum) updateNeededFields(neededFields fieldsSet) {
+func (ss *statsSum) updateNeededFields(neededFields *fieldsSet) {
 	updateNeededFieldsForStatsFunc(neededFields, ss.fields)
 }
 
+func (ss *statsSum) isPerField() bool {
+	return hasWildcardFieldNames(ss.fields)
+}
+
+func (ss *statsSum) newStatsFuncForField(field string) statsFunc {
+	return &statsSum{
+		fields: []string{field},
+	}
+}
+
 func (ss *statsSum) newStatsProcessor() (statsProcessor, int) {
+	if ss.isPerField() {
+		return newStatsPerFieldProcessor(ss, ss.fields)
+	}
+
 	ssp := &statsSumProcessor{
 		ss:  ss,
 		sum: nan,
@@ -94,6 +109,19 @@ func (ssp *statsSumProcessor) mergeState(sfp statsProcessor) {
 	}
 }
 
+func (ssp *statsSumProcessor) exportState(dst []byte) []byte {
+	return marshalStateFloat64(dst, ssp.sum)
+}
+
+func (ssp *statsSumProcessor) importState(src []byte) error {
+	sum, src, err := unmarshalStateFloat64(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal sum: %w", err)
+	}
+	ssp.sum = sum
+	return checkStateTail(src)
+}
+
 func (ssp *statsSumProcessor) finalizeStats() string {
 	return strconv.FormatFloat(ssp.sum, 'f', -1, 64)
 }
@@ -1,6 +1,7 @@
 package logstorage
 
 import (
+	"fmt"
 	"strconv"
 	"unsafe"
 )
@@ -13,7 +14,7 @@ func (ss *statsSumLen) String() string {
 	return "sum_len(" + statsFuncFieldsToString(ss.fields) + ")"
 }
 
-func (ss *statsSumLen) updateNeededFields(neededFields fieldsSet) {
+func (ss *statsSumLen) updateNeededFields(neededFields *fieldsSet) {
 	updateNeededFieldsForStatsFunc(neededFields, ss.fields)
 }
 
@@ -72,6 +73,19 @@ func (ssp *statsSumLenProcessor) mergeState(sfp statsProcessor) {
 	ssp.sumLen += src.sumLen
 }
 
+func (ssp *statsSumLenProcessor) exportState(dst []byte) []byte {
+	return marshalStateUint64(dst, ssp.sumLen)
+}
+
+func (ssp *statsSumLenProcessor) importState(src []byte) error {
+	sumLen, src, err := unmarshalStateUint64(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal sumLen: %w", err)
+	}
+	ssp.sumLen = sumLen
+	return checkStateTail(src)
+}
+
 func (ssp *statsSumLenProcessor) finalizeStats() string {
 	return strconv.FormatUint(ssp.sumLen, 10)
 }
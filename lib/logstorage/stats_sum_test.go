@@ -13,6 +13,8 @@ func TestParseStatsSumSuccess(t *testing.T) {
 	f(`sum(*)`)
 	f(`sum(a)`)
 	f(`sum(a, b)`)
+	f(`sum(a*)`)
+	f(`sum(a*, b)`)
 }
 
 func TestParseStatsSumFailure(t *testing.T) {
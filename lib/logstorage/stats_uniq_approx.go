@@ -0,0 +1,234 @@
+package logstorage
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+	"strconv"
+	"unsafe"
+
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+// statsUniqApprox estimates the number of unique values with HyperLogLog.
+//
+// See https://en.wikipedia.org/wiki/HyperLogLog
+type statsUniqApprox struct {
+	fields []string
+
+	// precision is the number of bits used for selecting HyperLogLog register.
+	//
+	// Zero precision means uniqApproxDefaultPrecision.
+	precision uint8
+}
+
+const (
+	uniqApproxMinPrecision     = 4
+	uniqApproxMaxPrecision     = 18
+	uniqApproxDefaultPrecision = 14
+)
+
+func (su *statsUniqApprox) String() string {
+	s := "uniq_approx(" + statsFuncFieldsToString(su.fields)
+	if su.precision > 0 {
+		s += fmt.Sprintf(", %d", su.precision)
+	}
+	s += ")"
+	return s
+}
+
+func (su *statsUniqApprox) updateNeededFields(neededFields *fieldsSet) {
+	updateNeededFieldsForStatsFunc(neededFields, su.fields)
+}
+
+func (su *statsUniqApprox) newStatsProcessor() (statsProcessor, int) {
+	precision := su.precision
+	if precision == 0 {
+		precision = uniqApproxDefaultPrecision
+	}
+	sup := &statsUniqApproxProcessor{
+		su: su,
+
+		sketch: hllSketch{
+			precision: precision,
+		},
+	}
+	return sup, int(unsafe.Sizeof(*sup))
+}
+
+type statsUniqApproxProcessor struct {
+	su *statsUniqApprox
+
+	sketch hllSketch
+
+	kb uniqKeysBuilder
+}
+
+func (sup *statsUniqApproxProcessor) updateStatsForAllRows(br *blockResult) int {
+	return sup.kb.updateStatsForAllRows(br, sup.su.fields, sup.updateState)
+}
+
+func (sup *statsUniqApproxProcessor) updateStatsForRow(br *blockResult, rowIdx int) int {
+	return sup.kb.updateStatsForRow(br, rowIdx, sup.su.fields, sup.updateState)
+}
+
+func (sup *statsUniqApproxProcessor) mergeState(sfp statsProcessor) {
+	src := sfp.(*statsUniqApproxProcessor)
+	sup.sketch.merge(&src.sketch)
+}
+
+func (sup *statsUniqApproxProcessor) exportState(dst []byte) []byte {
+	return encoding.MarshalBytes(dst, sup.sketch.registers)
+}
+
+func (sup *statsUniqApproxProcessor) importState(src []byte) error {
+	registers, n := encoding.UnmarshalBytes(src)
+	if n <= 0 {
+		return fmt.Errorf("cannot unmarshal HyperLogLog registers")
+	}
+	src = src[n:]
+	if len(registers) > 0 {
+		if registersLen := 1 << sup.sketch.precision; len(registers) != registersLen {
+			return fmt.Errorf("unexpected number of HyperLogLog registers; got %d; want %d", len(registers), registersLen)
+		}
+		sup.sketch.registers = append([]uint8{}, registers...)
+	}
+	return checkStateTail(src)
+}
+
+func (sup *statsUniqApproxProcessor) finalizeStats() string {
+	n := sup.sketch.estimate()
+	return strconv.FormatUint(n, 10)
+}
+
+func (sup *statsUniqApproxProcessor) updateState(v []byte) int {
+	h := xxhash.Sum64(v)
+	return sup.sketch.add(h)
+}
+
+// hllSketch is HyperLogLog sketch for estimating the number of unique 64-bit hashes.
+//
+// The sketch occupies 2^precision bytes regardless of the number of added hashes,
+// while the standard error of the estimation is 1.04/sqrt(2^precision).
+type hllSketch struct {
+	precision uint8
+
+	// registers contains the maximum observed rank per every register.
+	//
+	// registers is allocated lazily on the first add() call in order to save memory for empty sketches.
+	registers []uint8
+}
+
+// add adds hash h to sh and returns the increase of the state size in bytes.
+func (sh *hllSketch) add(h uint64) int {
+	stateSizeIncrease := 0
+	if sh.registers == nil {
+		sh.registers = make([]uint8, 1<<sh.precision)
+		stateSizeIncrease += len(sh.registers)
+	}
+
+	p := sh.precision
+	idx := h >> (64 - p)
+	// Set the lowest bit after the shifted hash in order to limit the rank by 64-p+1.
+	w := (h << p) | (1 << (p - 1))
+	rank := uint8(bits.LeadingZeros64(w) + 1)
+	if rank > sh.registers[idx] {
+		sh.registers[idx] = rank
+	}
+	return stateSizeIncrease
+}
+
+// merge merges src into sh.
+//
+// Both sketches must have the same precision.
+func (sh *hllSketch) merge(src *hllSketch) {
+	if src.registers == nil {
+		return
+	}
+	if sh.registers == nil {
+		sh.registers = append([]uint8{}, src.registers...)
+		return
+	}
+	for i, rank := range src.registers {
+		if rank > sh.registers[i] {
+			sh.registers[i] = rank
+		}
+	}
+}
+
+// estimate returns the estimated number of unique hashes added to sh.
+func (sh *hllSketch) estimate() uint64 {
+	if sh.registers == nil {
+		return 0
+	}
+
+	m := float64(len(sh.registers))
+	sum := 0.0
+	zeros := 0
+	for _, rank := range sh.registers {
+		sum += 1 / float64(uint64(1)<<rank)
+		if rank == 0 {
+			zeros++
+		}
+	}
+	e := hllAlpha(len(sh.registers)) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// Use linear counting for small cardinalities, since it is more precise than HyperLogLog estimation.
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(e))
+}
+
+func hllAlpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	default:
+		return 0.7213 / (1 + 1.079/float64(m))
+	}
+}
+
+func parseStatsUniqApprox(lex *lexer) (*statsUniqApprox, error) {
+	if !lex.isKeyword("uniq_approx") {
+		return nil, fmt.Errorf("unexpected func; got %q; want %q", lex.token, "uniq_approx")
+	}
+	lex.nextToken()
+
+	fields, err := parseFieldNamesInParens(lex)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse 'uniq_approx' args: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("'uniq_approx' must contain at least a single field")
+	}
+
+	// Parse optional precision at the last arg
+	var precision uint8
+	if len(fields) > 1 {
+		precisionStr := fields[len(fields)-1]
+		if n, ok := tryParseUint64(precisionStr); ok {
+			if n < uniqApproxMinPrecision || n > uniqApproxMaxPrecision {
+				return nil, fmt.Errorf("precision arg in 'uniq_approx' must be in the range [%d..%d]; got %q",
+					uniqApproxMinPrecision, uniqApproxMaxPrecision, precisionStr)
+			}
+			precision = uint8(n)
+			fields = fields[:len(fields)-1]
+		}
+	}
+	if slices.Contains(fields, "*") {
+		fields = nil
+	}
+
+	su := &statsUniqApprox{
+		fields:    fields,
+		precision: precision,
+	}
+	return su, nil
+}
@@ -0,0 +1,289 @@
+package logstorage
+
+import (
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+// uniqKeysBuilder builds unique keys for the given fields at blockResult rows.
+//
+// It is shared among count_uniq, count_uniq_hash and uniq_approx stats functions,
+// so they count the same keys.
+type uniqKeysBuilder struct {
+	columnValues [][]string
+	keyBuf       []byte
+}
+
+// updateStatsForAllRows calls updateState for keys built from the given fields at all the rows of br.
+//
+// All the columns are used if fields is empty. Keys with all the empty values are skipped,
+// as well as keys equal to the key at the previous row.
+//
+// It returns the sum of the values returned by updateState.
+func (kb *uniqKeysBuilder) updateStatsForAllRows(br *blockResult, fields []string, updateState func(k []byte) int) int {
+	stateSizeIncrease := 0
+	if len(fields) == 0 {
+		// Count unique rows
+		cs := br.getColumns()
+
+		columnValues := kb.columnValues[:0]
+		for _, c := range cs {
+			values := c.getValues(br)
+			columnValues = append(columnValues, values)
+		}
+		kb.columnValues = columnValues
+
+		keyBuf := kb.keyBuf[:0]
+		for i := range br.timestamps {
+			seenKey := true
+			for _, values := range columnValues {
+				if i == 0 || values[i-1] != values[i] {
+					seenKey = false
+					break
+				}
+			}
+			if seenKey {
+				// This key has been already counted.
+				continue
+			}
+
+			allEmptyValues := true
+			keyBuf = keyBuf[:0]
+			for j, values := range columnValues {
+				v := values[i]
+				if v != "" {
+					allEmptyValues = false
+				}
+				// Put column name into key, since every block can contain different set of columns for '*' selector.
+				keyBuf = encoding.MarshalBytes(keyBuf, bytesutil.ToUnsafeBytes(cs[j].name))
+				keyBuf = encoding.MarshalBytes(keyBuf, bytesutil.ToUnsafeBytes(v))
+			}
+			if allEmptyValues {
+				// Do not count empty values
+				continue
+			}
+			stateSizeIncrease += updateState(keyBuf)
+		}
+		kb.keyBuf = keyBuf
+		return stateSizeIncrease
+	}
+	if len(fields) == 1 {
+		// Fast path for a single column.
+		// The unique key is formed as "<is_time> <value>",
+		// This guarantees that keys do not clash for different column types across blocks.
+		c := br.getColumnByName(fields[0])
+		if c.isTime {
+			// Count unique br.timestamps
+			timestamps := br.timestamps
+			keyBuf := kb.keyBuf[:0]
+			for i, timestamp := range timestamps {
+				if i > 0 && timestamps[i-1] == timestamps[i] {
+					// This timestamp has been already counted.
+					continue
+				}
+				keyBuf = append(keyBuf[:0], 1)
+				keyBuf = encoding.MarshalInt64(keyBuf, timestamp)
+				stateSizeIncrease += updateState(keyBuf)
+			}
+			kb.keyBuf = keyBuf
+			return stateSizeIncrease
+		}
+		if c.isConst {
+			// count unique const values
+			v := c.valuesEncoded[0]
+			if v == "" {
+				// Do not count empty values
+				return stateSizeIncrease
+			}
+			keyBuf := kb.keyBuf[:0]
+			keyBuf = append(keyBuf[:0], 0)
+			keyBuf = append(keyBuf, v...)
+			stateSizeIncrease += updateState(keyBuf)
+			kb.keyBuf = keyBuf
+			return stateSizeIncrease
+		}
+		if c.valueType == valueTypeDict {
+			// count unique non-zero c.dictValues referenced by br rows
+			keyBuf := kb.keyBuf[:0]
+			c.forEachDictValue(br, func(v string) {
+				if v == "" {
+					// Do not count empty values
+					return
+				}
+				keyBuf = append(keyBuf[:0], 0)
+				keyBuf = append(keyBuf, v...)
+				stateSizeIncrease += updateState(keyBuf)
+			})
+			kb.keyBuf = keyBuf
+			return stateSizeIncrease
+		}
+
+		// Count unique values across values
+		values := c.getValues(br)
+		keyBuf := kb.keyBuf[:0]
+		for i, v := range values {
+			if v == "" {
+				// Do not count empty values
+				continue
+			}
+			if i > 0 && values[i-1] == v {
+				// This value has been already counted.
+				continue
+			}
+			keyBuf = append(keyBuf[:0], 0)
+			keyBuf = append(keyBuf, v...)
+			stateSizeIncrease += updateState(keyBuf)
+		}
+		kb.keyBuf = keyBuf
+		return stateSizeIncrease
+	}
+
+	// Slow path for multiple columns.
+
+	// Pre-calculate column values for byFields in order to speed up building group key in the loop below.
+	columnValues := kb.columnValues[:0]
+	for _, f := range fields {
+		c := br.getColumnByName(f)
+		values := c.getValues(br)
+		columnValues = append(columnValues, values)
+	}
+	kb.columnValues = columnValues
+
+	keyBuf := kb.keyBuf[:0]
+	for i := range br.timestamps {
+		seenKey := true
+		for _, values := range columnValues {
+			if i == 0 || values[i-1] != values[i] {
+				seenKey = false
+				break
+			}
+		}
+		if seenKey {
+			continue
+		}
+
+		allEmptyValues := true
+		keyBuf = keyBuf[:0]
+		for _, values := range columnValues {
+			v := values[i]
+			if v != "" {
+				allEmptyValues = false
+			}
+			keyBuf = encoding.MarshalBytes(keyBuf, bytesutil.ToUnsafeBytes(v))
+		}
+		if allEmptyValues {
+			// Do not count empty values
+			continue
+		}
+		stateSizeIncrease += updateState(keyBuf)
+	}
+	kb.keyBuf = keyBuf
+	return stateSizeIncrease
+}
+
+// updateStatsForRow calls updateState for the key built from the given fields at the given rowIdx of br.
+//
+// See updateStatsForAllRows for details.
+func (kb *uniqKeysBuilder) updateStatsForRow(br *blockResult, rowIdx int, fields []string, updateState func(k []byte) int) int {
+	stateSizeIncrease := 0
+	if len(fields) == 0 {
+		// Count unique rows
+		allEmptyValues := true
+		keyBuf := kb.keyBuf[:0]
+		for _, c := range br.getColumns() {
+			v := c.getValueAtRow(br, rowIdx)
+			if v != "" {
+				allEmptyValues = false
+			}
+			// Put column name into key, since every block can contain different set of columns for '*' selector.
+			keyBuf = encoding.MarshalBytes(keyBuf, bytesutil.ToUnsafeBytes(c.name))
+			keyBuf = encoding.MarshalBytes(keyBuf, bytesutil.ToUnsafeBytes(v))
+		}
+		kb.keyBuf = keyBuf
+
+		if allEmptyValues {
+			// Do not count empty values
+			return stateSizeIncrease
+		}
+		stateSizeIncrease += updateState(keyBuf)
+		return stateSizeIncrease
+	}
+	if len(fields) == 1 {
+		// Fast path for a single column.
+		// The unique key is formed as "<is_time> <value>",
+		// This guarantees that keys do not clash for different column types across blocks.
+		c := br.getColumnByName(fields[0])
+		if c.isTime {
+			// Count unique br.timestamps
+			keyBuf := kb.keyBuf[:0]
+			keyBuf = append(keyBuf[:0], 1)
+			keyBuf = encoding.MarshalInt64(keyBuf, br.timestamps[rowIdx])
+			stateSizeIncrease += updateState(keyBuf)
+			kb.keyBuf = keyBuf
+			return stateSizeIncrease
+		}
+		if c.isConst {
+			// count unique const values
+			v := c.valuesEncoded[0]
+			if v == "" {
+				// Do not count empty values
+				return stateSizeIncrease
+			}
+			keyBuf := kb.keyBuf[:0]
+			keyBuf = append(keyBuf[:0], 0)
+			keyBuf = append(keyBuf, v...)
+			stateSizeIncrease += updateState(keyBuf)
+			kb.keyBuf = keyBuf
+			return stateSizeIncrease
+		}
+		if c.valueType == valueTypeDict {
+			// count unique non-zero c.dictValues
+			valuesEncoded := c.getValuesEncoded(br)
+			dictIdx := valuesEncoded[rowIdx][0]
+			v := c.dictValues[dictIdx]
+			if v == "" {
+				// Do not count empty values
+				return stateSizeIncrease
+			}
+			keyBuf := kb.keyBuf[:0]
+			keyBuf = append(keyBuf[:0], 0)
+			keyBuf = append(keyBuf, v...)
+			stateSizeIncrease += updateState(keyBuf)
+			kb.keyBuf = keyBuf
+			return stateSizeIncrease
+		}
+
+		// Count unique values for the given rowIdx
+		v := c.getValueAtRow(br, rowIdx)
+		if v == "" {
+			// Do not count empty values
+			return stateSizeIncrease
+		}
+		keyBuf := kb.keyBuf[:0]
+		keyBuf = append(keyBuf[:0], 0)
+		keyBuf = append(keyBuf, v...)
+		stateSizeIncrease += updateState(keyBuf)
+		kb.keyBuf = keyBuf
+		return stateSizeIncrease
+	}
+
+	// Slow path for multiple columns.
+	allEmptyValues := true
+	keyBuf := kb.keyBuf[:0]
+	for _, f := range fields {
+		c := br.getColumnByName(f)
+		v := c.getValueAtRow(br, rowIdx)
+		if v != "" {
+			allEmptyValues = false
+		}
+		keyBuf = encoding.MarshalBytes(keyBuf, bytesutil.ToUnsafeBytes(v))
+	}
+	kb.keyBuf = keyBuf
+
+	if allEmptyValues {
+		// Do not count empty values
+		return stateSizeIncrease
+	}
+	stateSizeIncrease += updateState(keyBuf)
+	return stateSizeIncrease
+}
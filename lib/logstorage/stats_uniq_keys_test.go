@@ -0,0 +1,55 @@
+package logstorage
+
+import (
+	"testing"
+)
+
+func TestUniqKeysBuilder(t *testing.T) {
+	rows := [][]Field{
+		{{"a", "1"}, {"b", "x"}, {"c", ""}},
+		{{"a", "1"}, {"b", "x"}, {"c", ""}},
+		{{"a", "2"}, {"b", "x"}, {"c", ""}},
+		{{"a", ""}, {"b", ""}, {"c", ""}},
+		{{"a", "1"}, {"b", "y"}, {"c", ""}},
+		{{"a", "2"}, {"b", "x"}, {"c", ""}},
+	}
+
+	f := func(fields []string, uniqKeysExpected int) {
+		t.Helper()
+
+		br := newTestStatsBlockResult(rows)
+
+		var kb uniqKeysBuilder
+		mAll := make(map[string]struct{})
+		kb.updateStatsForAllRows(br, fields, func(k []byte) int {
+			mAll[string(k)] = struct{}{}
+			return len(k)
+		})
+		if len(mAll) != uniqKeysExpected {
+			t.Fatalf("unexpected number of unique keys for all the rows of fields %q; got %d; want %d", fields, len(mAll), uniqKeysExpected)
+		}
+
+		mRow := make(map[string]struct{})
+		for i := range rows {
+			kb.updateStatsForRow(br, i, fields, func(k []byte) int {
+				mRow[string(k)] = struct{}{}
+				return len(k)
+			})
+		}
+		if len(mRow) != len(mAll) {
+			t.Fatalf("unexpected number of unique keys for individual rows of fields %q; got %d; want %d", fields, len(mRow), len(mAll))
+		}
+		for k := range mRow {
+			if _, ok := mAll[k]; !ok {
+				t.Fatalf("missing key %q for all the rows of fields %q", k, fields)
+			}
+		}
+	}
+
+	f(nil, 3)
+	f([]string{"a"}, 2)
+	f([]string{"b"}, 2)
+	f([]string{"c"}, 0)
+	f([]string{"a", "b"}, 3)
+	f([]string{"a", "c"}, 2)
+}
@@ -24,7 +24,7 @@ func (su *statsUniqValues) String() string {
 	return s
 }
 
-func (su *statsUniqValues) updateNeededFields(neededFields fieldsSet) {
+func (su *statsUniqValues) updateNeededFields(neededFields *fieldsSet) {
 	updateNeededFieldsForStatsFunc(neededFields, su.fields)
 }
 
@@ -53,10 +53,16 @@ func (sup *statsUniqValuesProcessor) updateStatsForAllRows(br *blockResult) int
 	fields := sup.su.fields
 	if len(fields) == 0 {
 		for _, c := range br.getColumns() {
+			if sup.limitReached() {
+				break
+			}
 			stateSizeIncrease += sup.updateStatsForAllRowsColumn(c, br)
 		}
 	} else {
 		for _, field := range fields {
+			if sup.limitReached() {
+				break
+			}
 			c := br.getColumnByName(field)
 			stateSizeIncrease += sup.updateStatsForAllRowsColumn(c, br)
 		}
@@ -77,14 +83,14 @@ func (sup *statsUniqValuesProcessor) updateStatsForAllRowsColumn(c *blockResultC
 		return stateSizeIncrease
 	}
 	if c.valueType == valueTypeDict {
-		// collect unique non-zero c.dictValues
-		for _, v := range c.dictValues {
-			if v == "" {
-				// skip empty values
-				continue
+		// collect unique non-zero c.dictValues referenced by br rows
+		c.forEachDictValue(br, func(v string) {
+			if v == "" || sup.limitReached() {
+				// skip empty values and stop collecting values after the limit is reached
+				return
 			}
 			stateSizeIncrease += sup.updateState(v)
-		}
+		})
 		return stateSizeIncrease
 	}
 
@@ -99,6 +105,9 @@ func (sup *statsUniqValuesProcessor) updateStatsForAllRowsColumn(c *blockResultC
 			// This value has been already counted.
 			continue
 		}
+		if sup.limitReached() {
+			break
+		}
 		stateSizeIncrease += sup.updateState(v)
 	}
 	return stateSizeIncrease
@@ -114,10 +123,16 @@ func (sup *statsUniqValuesProcessor) updateStatsForRow(br *blockResult, rowIdx i
 	fields := sup.su.fields
 	if len(fields) == 0 {
 		for _, c := range br.getColumns() {
+			if sup.limitReached() {
+				break
+			}
 			stateSizeIncrease += sup.updateStatsForRowColumn(c, br, rowIdx)
 		}
 	} else {
 		for _, field := range fields {
+			if sup.limitReached() {
+				break
+			}
 			c := br.getColumnByName(field)
 			stateSizeIncrease += sup.updateStatsForRowColumn(c, br, rowIdx)
 		}
@@ -167,12 +182,39 @@ func (sup *statsUniqValuesProcessor) mergeState(sfp statsProcessor) {
 
 	src := sfp.(*statsUniqValuesProcessor)
 	for k := range src.m {
+		if sup.limitReached() {
+			break
+		}
 		if _, ok := sup.m[k]; !ok {
 			sup.m[k] = struct{}{}
 		}
 	}
 }
 
+func (sup *statsUniqValuesProcessor) exportState(dst []byte) []byte {
+	dst = marshalStateUint64(dst, uint64(len(sup.m)))
+	for k := range sup.m {
+		dst = marshalStateString(dst, k)
+	}
+	return dst
+}
+
+func (sup *statsUniqValuesProcessor) importState(src []byte) error {
+	n, src, err := unmarshalStateUint64(src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal the number of unique values: %w", err)
+	}
+	for i := uint64(0); i < n; i++ {
+		var v string
+		v, src, err = unmarshalStateString(src)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal unique value #%d out of %d: %w", i, n, err)
+		}
+		sup.m[v] = struct{}{}
+	}
+	return checkStateTail(src)
+}
+
 func (sup *statsUniqValuesProcessor) finalizeStats() string {
 	if len(sup.m) == 0 {
 		return "[]"
@@ -251,7 +293,7 @@ func parseStatsUniqValues(lex *lexer) (*statsUniqValues, error) {
 		lex.nextToken()
 		n, ok := tryParseUint64(lex.token)
 		if !ok {
-			return nil, fmt.Errorf("cannot parse 'limit %s' for 'uniq_values': %w", lex.token, err)
+			return nil, fmt.Errorf("cannot parse 'limit %s' for 'uniq_values'", lex.token)
 		}
 		lex.nextToken()
 		su.limit = n
@@ -1,6 +1,7 @@
 package logstorage
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -437,3 +438,44 @@ func TestSortStrings(t *testing.T) {
 	f("v1.10.9,v1.10.10,v1.9.0", "v1.9.0,v1.10.9,v1.10.10")
 	f("10s,123,100M", "123,100M,10s")
 }
+
+func TestStatsUniqValuesLimitStopsEarly(t *testing.T) {
+	f := func(funcStr string, rows [][]Field, valuesExpected int) {
+		t.Helper()
+
+		lex := newLexer(funcStr)
+		sf, err := parseStatsFunc(lex)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", funcStr, err)
+		}
+		sfp, _ := sf.newStatsProcessor()
+		sup := sfp.(*statsUniqValuesProcessor)
+
+		br := newTestStatsBlockResult(rows)
+		sup.updateStatsForAllRows(br)
+		if n := len(sup.m); n != valuesExpected {
+			t.Fatalf("unexpected number of values after updateStatsForAllRows; got %d; want %d", n, valuesExpected)
+		}
+
+		src, _ := sf.newStatsProcessor()
+		src.updateStatsForAllRows(br)
+		clear(sup.m)
+		sup.mergeState(src)
+		if n := len(sup.m); n != valuesExpected {
+			t.Fatalf("unexpected number of values after mergeState; got %d; want %d", n, valuesExpected)
+		}
+	}
+
+	var rows [][]Field
+	for i := 0; i < 100; i++ {
+		rows = append(rows, []Field{
+			{"a", fmt.Sprintf("a_%d", i)},
+			{"b", fmt.Sprintf("b_%d", i)},
+		})
+	}
+
+	f("uniq_values(*) limit 3", rows, 3)
+	f("uniq_values(a) limit 5", rows, 5)
+	f("uniq_values(a, b) limit 150", rows, 150)
+	f("uniq_values(a) limit 1000", rows, 100)
+}
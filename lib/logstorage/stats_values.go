@@ -19,7 +19,7 @@ func (sv *statsValues) String() string {
 	return s
 }
 
-func (sv *statsValues) updateNeededFields(neededFields fieldsSet) {
+func (sv *statsValues) updateNeededFields(neededFields *fieldsSet) {
 	updateNeededFieldsForStatsFunc(neededFields, sv.fields)
 }
 
@@ -46,10 +46,16 @@ func (svp *statsValuesProcessor) updateStatsForAllRows(br *blockResult) int {
 	fields := svp.sv.fields
 	if len(fields) == 0 {
 		for _, c := range br.getColumns() {
+			if svp.limitReached() {
+				break
+			}
 			stateSizeIncrease += svp.updateStatsForAllRowsColumn(c, br)
 		}
 	} else {
 		for _, field := range fields {
+			if svp.limitReached() {
+				break
+			}
 			c := br.getColumnByName(field)
 			stateSizeIncrease += svp.updateStatsForAllRowsColumn(c, br)
 		}
@@ -63,13 +69,14 @@ func (svp *statsValuesProcessor) updateStatsForAllRowsColumn(c *blockResultColum
 		v := strings.Clone(c.valuesEncoded[0])
 		stateSizeIncrease += len(v)
 
+		rowsCount := svp.limitRowsCount(len(br.timestamps))
 		values := svp.values
-		for range br.timestamps {
+		for i := 0; i < rowsCount; i++ {
 			values = append(values, v)
 		}
 		svp.values = values
 
-		stateSizeIncrease += len(br.timestamps) * int(unsafe.Sizeof(values[0]))
+		stateSizeIncrease += rowsCount * int(unsafe.Sizeof(values[0]))
 		return stateSizeIncrease
 	}
 	if c.valueType == valueTypeDict {
@@ -79,19 +86,21 @@ func (svp *statsValuesProcessor) updateStatsForAllRowsColumn(c *blockResultColum
 			stateSizeIncrease += len(v)
 		}
 
+		rowsCount := svp.limitRowsCount(len(br.timestamps))
 		values := svp.values
-		for _, encodedValue := range c.getValuesEncoded(br) {
+		for _, encodedValue := range c.getValuesEncoded(br)[:rowsCount] {
 			idx := encodedValue[0]
 			values = append(values, dictValues[idx])
 		}
 		svp.values = values
 
-		stateSizeIncrease += len(br.timestamps) * int(unsafe.Sizeof(values[0]))
+		stateSizeIncrease += rowsCount * int(unsafe.Sizeof(values[0]))
 		return stateSizeIncrease
 	}
 
+	rowsCount := svp.limitRowsCount(len(br.timestamps))
 	values := svp.values
-	for _, v := range c.getValues(br) {
+	for _, v := range c.getValues(br)[:rowsCount] {
 		if len(values) == 0 || values[len(values)-1] != v {
 			v = strings.Clone(v)
 			stateSizeIncrease += len(v)
@@ -100,7 +109,7 @@ func (svp *statsValuesProcessor) updateStatsForAllRowsColumn(c *blockResultColum
 	}
 	svp.values = values
 
-	stateSizeIncrease += len(br.timestamps) * int(unsafe.Sizeof(values[0]))
+	stateSizeIncrease += rowsCount * int(unsafe.Sizeof(values[0]))
 	return stateSizeIncrease
 }
 
@@ -114,10 +123,16 @@ func (svp *statsValuesProcessor) updateStatsForRow(br *blockResult, rowIdx int)
 	fields := svp.sv.fields
 	if len(fields) == 0 {
 		for _, c := range br.getColumns() {
+			if svp.limitReached() {
+				break
+			}
 			stateSizeIncrease += svp.updateStatsForRowColumn(c, br, rowIdx)
 		}
 	} else {
 		for _, field := range fields {
+			if svp.limitReached() {
+				break
+			}
 			c := br.getColumnByName(field)
 			stateSizeIncrease += svp.updateStatsForRowColumn(c, br, rowIdx)
 		}
@@ -166,7 +181,22 @@ func (svp *statsValuesProcessor) mergeState(sfp statsProcessor) {
 	}
 
 	src := sfp.(*statsValuesProcessor)
-	svp.values = append(svp.values, src.values...)
+	values := src.values
+	values = values[:svp.limitRowsCount(len(values))]
+	svp.values = append(svp.values, values...)
+}
+
+func (svp *statsValuesProcessor) exportState(dst []byte) []byte {
+	return marshalStateStrings(dst, svp.values)
+}
+
+func (svp *statsValuesProcessor) importState(src []byte) error {
+	values, src, err := unmarshalStateStrings(nil, src)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal values: %w", err)
+	}
+	svp.values = values
+	return checkStateTail(src)
 }
 
 func (svp *statsValuesProcessor) finalizeStats() string {
@@ -187,6 +217,22 @@ func (svp *statsValuesProcessor) limitReached() bool {
 	return limit > 0 && uint64(len(svp.values)) >= limit
 }
 
+// limitRowsCount returns the number of values out of rowsCount, which can be added to svp without exceeding the limit.
+func (svp *statsValuesProcessor) limitRowsCount(rowsCount int) int {
+	limit := svp.sv.limit
+	if limit == 0 {
+		return rowsCount
+	}
+	n := uint64(len(svp.values))
+	if n >= limit {
+		return 0
+	}
+	if remaining := limit - n; uint64(rowsCount) > remaining {
+		return int(remaining)
+	}
+	return rowsCount
+}
+
 func parseStatsValues(lex *lexer) (*statsValues, error) {
 	fields, err := parseStatsFuncFields(lex, "values")
 	if err != nil {
@@ -199,7 +245,7 @@ func parseStatsValues(lex *lexer) (*statsValues, error) {
 		lex.nextToken()
 		n, ok := tryParseUint64(lex.token)
 		if !ok {
-			return nil, fmt.Errorf("cannot parse 'limit %s' for 'values': %w", lex.token, err)
+			return nil, fmt.Errorf("cannot parse 'limit %s' for 'values'", lex.token)
 		}
 		lex.nextToken()
 		sv.limit = n
@@ -1,6 +1,7 @@
 package logstorage
 
 import (
+	"fmt"
 	"testing"
 )
 
@@ -28,3 +29,64 @@ func TestParseStatsValuesFailure(t *testing.T) {
 	f(`values(a, b) limit`)
 	f(`values(a, b) limit foo`)
 }
+
+func TestStatsValuesLimitStopsEarly(t *testing.T) {
+	f := func(funcStr string, rows [][]Field, valuesExpected int) {
+		t.Helper()
+
+		lex := newLexer(funcStr)
+		sf, err := parseStatsFunc(lex)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", funcStr, err)
+		}
+		sfp, _ := sf.newStatsProcessor()
+		svp := sfp.(*statsValuesProcessor)
+
+		br := newTestStatsBlockResult(rows)
+		svp.updateStatsForAllRows(br)
+		if n := len(svp.values); n != valuesExpected {
+			t.Fatalf("unexpected number of values after updateStatsForAllRows; got %d; want %d", n, valuesExpected)
+		}
+
+		src, _ := sf.newStatsProcessor()
+		src.updateStatsForAllRows(br)
+		svp.values = svp.values[:0]
+		svp.mergeState(src)
+		if n := len(svp.values); n != valuesExpected {
+			t.Fatalf("unexpected number of values after mergeState; got %d; want %d", n, valuesExpected)
+		}
+	}
+
+	var rows [][]Field
+	for i := 0; i < 100; i++ {
+		rows = append(rows, []Field{
+			{"a", fmt.Sprintf("a_%d", i)},
+			{"b", fmt.Sprintf("b_%d", i)},
+			{"c", "const"},
+		})
+	}
+
+	f("values(*) limit 3", rows, 3)
+	f("values(a) limit 5", rows, 5)
+	f("values(a, b) limit 150", rows, 150)
+	f("values(c) limit 7", rows, 7)
+	f("values(a) limit 1000", rows, 100)
+}
+
+// newTestStatsBlockResult returns blockResult with the given rows.
+//
+// All the rows must contain the same fields in the same order.
+func newTestStatsBlockResult(rows [][]Field) *blockResult {
+	var rcs []resultColumn
+	for _, f := range rows[0] {
+		rcs = appendResultColumnWithName(rcs, f.Name)
+	}
+	for _, row := range rows {
+		for i, f := range row {
+			rcs[i].addValue(f.Value)
+		}
+	}
+	var br blockResult
+	br.setResultColumns(rcs, len(rows))
+	return &br
+}
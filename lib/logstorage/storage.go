@@ -4,15 +4,19 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/backup/backupnames"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/backup/common"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/memory"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timeutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/workingsetcache"
+	"github.com/VictoriaMetrics/fastcache"
 )
 
 // StorageStats represents stats for the storage. It may be obtained by calling Storage.UpdateStats().
@@ -23,9 +27,72 @@ type StorageStats struct {
 	// RowsDroppedTooSmallTimestamp is the number of rows dropped during data ingestion because their timestamp is bigger than the maximum allowed
 	RowsDroppedTooSmallTimestamp uint64
 
+	// RowsDroppedTenantLimits is the number of rows dropped during data ingestion because their tenants exceed TenantLimits
+	RowsDroppedTenantLimits uint64
+
 	// PartitionsCount is the number of partitions in the storage
 	PartitionsCount uint64
 
+	// DeleteTasksCount is the number of active delete tasks in the storage
+	DeleteTasksCount uint64
+
+	// QueryResultsCacheRequests is the number of requests to the query results cache
+	QueryResultsCacheRequests uint64
+
+	// QueryResultsCacheMisses is the number of misses at the query results cache
+	QueryResultsCacheMisses uint64
+
+	// QueryResultsCacheEntries is the number of entries at the query results cache
+	QueryResultsCacheEntries uint64
+
+	// QueryResultsCacheSizeBytes is the size of the query results cache in bytes
+	QueryResultsCacheSizeBytes uint64
+
+	// QueryResultsCacheMaxSizeBytes is the maximum size of the query results cache in bytes
+	QueryResultsCacheMaxSizeBytes uint64
+
+	// ObjectStorageUploadedBytes is the number of bytes uploaded to object storage
+	ObjectStorageUploadedBytes uint64
+
+	// ObjectStorageDownloadedBytes is the number of bytes downloaded from object storage
+	ObjectStorageDownloadedBytes uint64
+
+	// ObjectStorageCacheRequests is the number of requests to the local cache for object storage data
+	ObjectStorageCacheRequests uint64
+
+	// ObjectStorageCacheMisses is the number of misses at the local cache for object storage data
+	ObjectStorageCacheMisses uint64
+
+	// ObjectStorageCacheEntries is the number of blocks at the local cache for object storage data
+	ObjectStorageCacheEntries uint64
+
+	// ObjectStorageCacheSizeBytes is the size of the local cache for object storage data in bytes
+	ObjectStorageCacheSizeBytes uint64
+
+	// ObjectStorageCacheMaxSizeBytes is the maximum size of the local cache for object storage data in bytes
+	ObjectStorageCacheMaxSizeBytes uint64
+
+	// ActiveQueries is the number of currently executed queries, including subqueries
+	ActiveQueries uint64
+
+	// QueriesAbortedScanLimits is the number of queries stopped because they exceeded the limits on the scanned data
+	QueriesAbortedScanLimits uint64
+
+	// QueriesAbortedMemoryLimit is the number of queries stopped because their pipes exceeded the memory limit
+	QueriesAbortedMemoryLimit uint64
+
+	// QueriesAbortedTimeout is the number of queries stopped because they exceeded the timeout
+	QueriesAbortedTimeout uint64
+
+	// QueriesAbortedCanceled is the number of queries stopped via Storage.CancelQuery
+	QueriesAbortedCanceled uint64
+
+	// StatsPipeGroups is the number of groups returned by stats pipes
+	StatsPipeGroups uint64
+
+	// SlowQueries is the number of queries, which were executed for more than StorageConfig.LogSlowQueryDuration
+	SlowQueries uint64
+
 	// IsReadOnly indicates whether the storage is read-only.
 	IsReadOnly bool
 
@@ -45,6 +112,12 @@ type StorageConfig struct {
 	// Older data is automatically deleted.
 	Retention time.Duration
 
+	// RetentionFilters is an optional list of retention overrides for log streams.
+	//
+	// The retention from the first matching filter is applied to the log stream. Retention is applied to log streams without matching filters.
+	// Log entries outside the retention for their log stream are deleted during background merges.
+	RetentionFilters []*RetentionFilter
+
 	// MaxDiskSpaceUsageBytes is an optional maximum disk space logs can use.
 	//
 	// The oldest per-day partitions are automatically dropped if the total disk space usage exceeds this limit.
@@ -72,12 +145,71 @@ type StorageConfig struct {
 	//
 	// This can be useful for debugging of data ingestion.
 	LogIngestedRows bool
+
+	// LogSlowQueryDuration is the minimum query execution duration for logging the query as slow.
+	//
+	// This can be useful for finding slow queries. Slow queries aren't logged if LogSlowQueryDuration is zero.
+	LogSlowQueryDuration time.Duration
+
+	// TenantLimits contains the default ingestion limits for every tenant.
+	//
+	// Log entries exceeding the limits are dropped during data ingestion.
+	TenantLimits TenantLimits
+
+	// TenantLimitsOverrides contains optional overrides for TenantLimits per each tenant.
+	TenantLimitsOverrides []*TenantLimitsOverride
+
+	// UseZSTDDicts enables training per-partition ZSTD dictionaries for compressing string values.
+	//
+	// The trained dictionaries are used for string values in the partition even if UseZSTDDicts is disabled later.
+	UseZSTDDicts bool
+
+	// DisableQueryResultsCache disables caching of query results.
+	//
+	// See https://docs.victoriametrics.com/victorialogs/querying/#query-results-cache
+	DisableQueryResultsCache bool
+
+	// QueryResultsCacheSizeBytes is the maximum size of the query results cache.
+	//
+	// 5% of the allowed memory is used if it isn't set.
+	QueryResultsCacheSizeBytes int64
+
+	// QueryResultsCacheTimestampOffset is the offset from the current time for the data, which isn't put into the query results cache,
+	// since it may change because of delayed data ingestion.
+	QueryResultsCacheTimestampOffset time.Duration
+
+	// PersistQueryResultsCache indicates whether to save the query results cache to disk on MustClose and to load it on MustOpenStorage.
+	PersistQueryResultsCache bool
+
+	// ObjectStorage is an optional object storage such as S3 for data of partitions older than ObjectStorageMinAge.
+	//
+	// Data files for such partitions are moved to ObjectStorage and are read on demand via the local cache.
+	// The caller must stop ObjectStorage after calling Storage.MustClose.
+	ObjectStorage common.RemoteFS
+
+	// ObjectStorageMinAge is the minimum age for partitions, which are moved to ObjectStorage.
+	ObjectStorageMinAge time.Duration
+
+	// ObjectStorageCacheSizeBytes is the maximum size of the local cache for data read from ObjectStorage.
+	ObjectStorageCacheSizeBytes int64
 }
 
 // Storage is the storage for log entries.
 type Storage struct {
 	rowsDroppedTooBigTimestamp   atomic.Uint64
 	rowsDroppedTooSmallTimestamp atomic.Uint64
+	rowsDroppedTenantLimits      atomic.Uint64
+
+	queriesAbortedScanLimits  atomic.Uint64
+	queriesAbortedMemoryLimit atomic.Uint64
+	queriesAbortedTimeout     atomic.Uint64
+	queriesAbortedCanceled    atomic.Uint64
+
+	// statsPipeGroups is the number of groups returned by stats pipes.
+	statsPipeGroups atomic.Uint64
+
+	// slowQueries is the number of queries logged as slow because of LogSlowQueryDuration.
+	slowQueries atomic.Uint64
 
 	// path is the path to the Storage directory
 	path string
@@ -87,6 +219,19 @@ type Storage struct {
 	// older data is automatically deleted
 	retention time.Duration
 
+	// retentionFilters contains retention overrides for log streams.
+	retentionFilters []*RetentionFilter
+
+	// maxRetention is the maximum retention across retention and retentionFilters.
+	//
+	// Partitions outside maxRetention are automatically deleted.
+	maxRetention time.Duration
+
+	// retentionsForForceMerge contains unique retentions smaller than maxRetention.
+	//
+	// Partitions going outside these retentions are force merged in order to delete log entries outside the retention for their log streams.
+	retentionsForForceMerge []time.Duration
+
 	// maxDiskSpaceUsageBytes is an optional maximum disk space logs can use.
 	//
 	// The oldest per-day partitions are automatically dropped if the total disk space usage exceeds this limit.
@@ -107,6 +252,12 @@ type Storage struct {
 	// logIngestedRows instructs to log all the ingested log entries if it is set to true
 	logIngestedRows bool
 
+	// logSlowQueryDuration is the minimum duration for the queries, which must be logged as slow. Slow queries aren't logged if it is zero.
+	logSlowQueryDuration time.Duration
+
+	// useZSTDDicts instructs to train ZSTD dictionaries for new partitions if it is set to true
+	useZSTDDicts bool
+
 	// flockF is a file, which makes sure that the Storage is opened by a single process
 	flockF *os.File
 
@@ -120,15 +271,29 @@ type Storage struct {
 	// It must be accessed under partitionsLock.
 	ptwHot *partitionWrapper
 
-	// partitionsLock protects partitions and ptwHot.
+	// detachingPartitions contains channels for partitions, which are detached at the moment, keyed by partition day.
+	//
+	// The channel is closed when the partition is detached. It must be accessed under partitionsLock.
+	detachingPartitions map[int64]chan struct{}
+
+	// partitionsLock protects partitions, ptwHot and detachingPartitions.
 	partitionsLock sync.Mutex
 
+	// attachLock prevents from concurrent attaching and detaching of partitions.
+	attachLock sync.Mutex
+
+	// snapshotLock prevents from concurrent creation of snapshots.
+	snapshotLock sync.Mutex
+
 	// stopCh is closed when the Storage must be stopped.
 	stopCh chan struct{}
 
 	// wg is used for waiting for background workers at MustClose().
 	wg sync.WaitGroup
 
+	// forceMergeWG is used for waiting for forced merges at MustClose().
+	forceMergeWG sync.WaitGroup
+
 	// streamIDCache caches (partition, streamIDs) seen during data ingestion.
 	//
 	// It reduces the load on persistent storage during data ingestion by skipping
@@ -148,6 +313,82 @@ type Storage struct {
 	//
 	// It reduces the load on persistent storage during querying by _stream:{...} filter.
 	filterStreamCache *workingsetcache.Cache
+
+	// queryResultsCache caches query results and per-partition stats states for the data, which isn't expected to change.
+	//
+	// It is nil if the cache is disabled. See query_results_cache.go for details.
+	queryResultsCache *workingsetcache.Cache
+
+	// queryResultsCacheTimestampOffset is the offset in nanoseconds from the current time for the data, which isn't put into queryResultsCache.
+	queryResultsCacheTimestampOffset int64
+
+	// persistQueryResultsCache indicates whether queryResultsCache must be saved to disk on MustClose.
+	persistQueryResultsCache bool
+
+	queryResultsCacheRequests atomic.Uint64
+	queryResultsCacheMisses   atomic.Uint64
+
+	// objectStorage is the object storage for data of old partitions.
+	//
+	// It is nil if object storage isn't configured. See object_storage.go for details.
+	objectStorage *objectStorage
+
+	// deleteTasks contains active delete tasks sorted by TaskID.
+	//
+	// It must be accessed under deleteTasksLock. The slice mustn't be modified in place, since it may be in use by getDeleteTasks() callers.
+	deleteTasks []*deleteTask
+
+	// deleteTasksLatestSeq is the TaskID of the latest created delete task.
+	//
+	// It is updated under deleteTasksLock.
+	deleteTasksLatestSeq atomic.Uint64
+
+	// deleteTasksLock protects deleteTasks.
+	deleteTasksLock sync.Mutex
+
+	// ingestedTenantStats contains per-tenant ingestion stats since the Storage start.
+	//
+	// It must be accessed under ingestedTenantStatsLock.
+	ingestedTenantStats map[TenantID]*ingestedTenantStats
+
+	// ingestedTenantStatsLock protects ingestedTenantStats.
+	ingestedTenantStatsLock sync.Mutex
+
+	// pipeMetrics contains execution stats per every pipe name since the Storage start.
+	//
+	// It must be accessed under pipeMetricsLock.
+	pipeMetrics map[string]*pipeMetrics
+
+	// pipeMetricsLock protects pipeMetrics.
+	pipeMetricsLock sync.Mutex
+
+	// activeQueries contains queries executed by runQuery at the moment.
+	//
+	// It must be accessed under activeQueriesLock.
+	activeQueries map[uint64]*activeQuery
+
+	// activeQueriesLock protects activeQueries.
+	activeQueriesLock sync.Mutex
+
+	// activeQueriesLatestID is the id of the latest registered active query.
+	activeQueriesLatestID atomic.Uint64
+
+	// tenantLimits contains the default limits for tenants without tenantLimitsOverrides.
+	tenantLimits TenantLimits
+
+	// tenantLimitsOverrides contains per-tenant overrides for tenantLimits.
+	tenantLimitsOverrides map[TenantID]*TenantLimits
+
+	// hasTenantLimits is set to true if at least a single tenant limit is configured.
+	hasTenantLimits bool
+
+	// hasTenantRetainedBytesLimits is set to true if at least a single retained bytes limit is configured.
+	hasTenantRetainedBytesLimits bool
+
+	// tenantRetainedBytes contains the compressed size of log entries per each tenant.
+	//
+	// It is updated periodically if hasTenantRetainedBytesLimits is set.
+	tenantRetainedBytes atomic.Pointer[map[TenantID]uint64]
 }
 
 type partitionWrapper struct {
@@ -161,8 +402,19 @@ type partitionWrapper struct {
 	// day is the day for the partition in the unix timestamp divided by the number of seconds in the day.
 	day int64
 
+	// expiredRetentionsCount is the number of Storage.retentionsForForceMerge, which are outside the day.
+	//
+	// It is used for detecting when the partition must be force merged in order to apply retention filters.
+	// It is accessed only by the retention watcher.
+	expiredRetentionsCount int
+
 	// pt is the wrapped partition.
 	pt *partition
+
+	// closedCh is closed when pt is closed after refCount reaches zero.
+	//
+	// It is non-nil only for partitions, which are detached at the moment. See Storage.DetachPartition.
+	closedCh chan struct{}
 }
 
 func newPartitionWrapper(pt *partition, day int64) *partitionWrapper {
@@ -185,8 +437,10 @@ func (ptw *partitionWrapper) decRef() {
 	}
 
 	deletePath := ""
+	var obs *objectStorage
 	if ptw.mustDrop.Load() {
 		deletePath = ptw.pt.path
+		obs = ptw.pt.s.objectStorage
 	}
 
 	// Close pw.pt, since nobody refers to it.
@@ -195,7 +449,11 @@ func (ptw *partitionWrapper) decRef() {
 
 	// Delete partition if needed.
 	if deletePath != "" {
-		mustDeletePartition(deletePath)
+		mustDeletePartition(deletePath, obs)
+	}
+
+	if ptw.closedCh != nil {
+		close(ptw.closedCh)
 	}
 }
 
@@ -232,6 +490,18 @@ func MustOpenStorage(path string, cfg *StorageConfig) *Storage {
 		retention = 24 * time.Hour
 	}
 
+	var retentionFilters []*RetentionFilter
+	maxRetention := retention
+	for _, rf := range cfg.RetentionFilters {
+		if rf.Retention < 24*time.Hour {
+			logger.Panicf("BUG: retention for the filter %s cannot be smaller than a day", rf)
+		}
+		retentionFilters = append(retentionFilters, rf)
+		if rf.Retention > maxRetention {
+			maxRetention = rf.Retention
+		}
+	}
+
 	futureRetention := cfg.FutureRetention
 	if futureRetention < 24*time.Hour {
 		futureRetention = 24 * time.Hour
@@ -248,6 +518,18 @@ func MustOpenStorage(path string, cfg *StorageConfig) *Storage {
 
 	flockF := fs.MustCreateFlockFile(path)
 
+	// Check whether the restore process finished successfully
+	restoreLockF := filepath.Join(path, backupnames.RestoreInProgressFilename)
+	if fs.IsPathExist(restoreLockF) {
+		logger.Panicf("FATAL: incomplete vmrestore run; run vmrestore again or remove lock file %q", restoreLockF)
+	}
+	mustVerifyRestoredChecksums(path)
+
+	// Pre-create snapshots directory if it is missing.
+	snapshotsPath := filepath.Join(path, snapshotsDirname)
+	fs.MustMkdirIfNotExist(snapshotsPath)
+	fs.MustRemoveTemporaryDirs(snapshotsPath)
+
 	// Load caches
 	mem := memory.Allowed()
 	streamIDCachePath := filepath.Join(path, cacheDirname, streamIDCacheFilename)
@@ -257,23 +539,70 @@ func MustOpenStorage(path string, cfg *StorageConfig) *Storage {
 
 	filterStreamCache := workingsetcache.New(mem / 10)
 
+	var queryResultsCache *workingsetcache.Cache
+	if !cfg.DisableQueryResultsCache {
+		queryResultsCacheSize := int(cfg.QueryResultsCacheSizeBytes)
+		if queryResultsCacheSize <= 0 {
+			queryResultsCacheSize = mem / 20
+		}
+		if cfg.PersistQueryResultsCache {
+			queryResultsCachePath := filepath.Join(path, cacheDirname, queryResultsCacheFilename)
+			queryResultsCache = workingsetcache.Load(queryResultsCachePath, queryResultsCacheSize)
+		} else {
+			queryResultsCache = workingsetcache.New(queryResultsCacheSize)
+		}
+	}
+
 	s := &Storage{
 		path:                   path,
 		retention:              retention,
+		retentionFilters:       retentionFilters,
+		maxRetention:           maxRetention,
 		maxDiskSpaceUsageBytes: cfg.MaxDiskSpaceUsageBytes,
 		flushInterval:          flushInterval,
 		futureRetention:        futureRetention,
 		minFreeDiskSpaceBytes:  minFreeDiskSpaceBytes,
 		logNewStreams:          cfg.LogNewStreams,
 		logIngestedRows:        cfg.LogIngestedRows,
+		logSlowQueryDuration:   cfg.LogSlowQueryDuration,
+		useZSTDDicts:           cfg.UseZSTDDicts,
 		flockF:                 flockF,
 		stopCh:                 make(chan struct{}),
 
 		streamIDCache:     streamIDCache,
 		streamTagsCache:   streamTagsCache,
 		filterStreamCache: filterStreamCache,
+
+		queryResultsCache:                queryResultsCache,
+		queryResultsCacheTimestampOffset: cfg.QueryResultsCacheTimestampOffset.Nanoseconds(),
+		persistQueryResultsCache:         cfg.PersistQueryResultsCache,
+
+		detachingPartitions: make(map[int64]chan struct{}),
+
+		ingestedTenantStats: make(map[TenantID]*ingestedTenantStats),
+		pipeMetrics:         make(map[string]*pipeMetrics),
+		activeQueries:       make(map[uint64]*activeQuery),
+	}
+
+	if cfg.ObjectStorage != nil {
+		// Open object storage before opening partitions, since their parts may be stored at object storage.
+		cachePath := filepath.Join(path, cacheDirname, objectStorageCacheDirname)
+		cacheMaxSize := uint64(0)
+		if cfg.ObjectStorageCacheSizeBytes > 0 {
+			cacheMaxSize = uint64(cfg.ObjectStorageCacheSizeBytes)
+		}
+		s.objectStorage = mustOpenObjectStorage(cfg.ObjectStorage, cfg.ObjectStorageMinAge, cachePath, cacheMaxSize)
 	}
 
+	s.retentionsForForceMerge = s.getRetentionsForForceMerge()
+	s.initTenantLimits(&cfg.TenantLimits, cfg.TenantLimitsOverrides)
+
+	// Load delete tasks before opening partitions, since they must be applied to background merges in partitions.
+	deleteTasksPath := filepath.Join(path, deleteTasksFilename)
+	dts, deleteTasksLatestSeq := mustLoadDeleteTasks(deleteTasksPath)
+	s.deleteTasks = dts
+	s.deleteTasksLatestSeq.Store(deleteTasksLatestSeq)
+
 	partitionsPath := filepath.Join(path, partitionsDirname)
 	fs.MustMkdirIfNotExist(partitionsPath)
 	des := fs.MustReadDir(partitionsPath)
@@ -291,6 +620,9 @@ func MustOpenStorage(path string, cfg *StorageConfig) *Storage {
 		partitionPath := filepath.Join(partitionsPath, fname)
 		pt := mustOpenPartition(s, partitionPath)
 		ptws[i] = newPartitionWrapper(pt, day)
+
+		// Assume the retention filters have been already applied to the partition before the restart.
+		ptws[i].expiredRetentionsCount = s.getExpiredRetentionsCount(day)
 	}
 	sort.Slice(ptws, func(i, j int) bool {
 		return ptws[i].day < ptws[j].day
@@ -318,6 +650,9 @@ func MustOpenStorage(path string, cfg *StorageConfig) *Storage {
 	s.partitions = ptws
 	s.runRetentionWatcher()
 	s.runMaxDiskSpaceUsageWatcher()
+	s.runDeleteTasksWatcher()
+	s.runTenantRetainedBytesWatcher()
+	s.runObjectStorageWatcher()
 	return s
 }
 
@@ -371,11 +706,13 @@ func (s *Storage) watchRetention() {
 		s.partitionsLock.Unlock()
 
 		for _, ptw := range ptwsToDelete {
-			logger.Infof("the partition %s is scheduled to be deleted because it is outside the -retentionPeriod=%dd", ptw.pt.path, durationToDays(s.retention))
+			logger.Infof("the partition %s is scheduled to be deleted because it is outside the %s", ptw.pt.path, s.getRetentionDescription())
 			ptw.mustDrop.Store(true)
 			ptw.decRef()
 		}
 
+		s.forceMergePartitionsForRetentionFilters()
+
 		select {
 		case <-s.stopCh:
 			return
@@ -384,6 +721,68 @@ func (s *Storage) watchRetention() {
 	}
 }
 
+// forceMergePartitionsForRetentionFilters starts force merge for partitions, which went outside some of s.retentionsForForceMerge.
+//
+// This guarantees that log entries outside the retention for their log streams are eventually deleted
+// even if the partition has no other reasons for background merges.
+func (s *Storage) forceMergePartitionsForRetentionFilters() {
+	if len(s.retentionsForForceMerge) == 0 {
+		return
+	}
+
+	var ptwsToMerge []*partitionWrapper
+	s.partitionsLock.Lock()
+	for _, ptw := range s.partitions {
+		n := s.getExpiredRetentionsCount(ptw.day)
+		if n > ptw.expiredRetentionsCount {
+			ptw.expiredRetentionsCount = n
+			ptw.incRef()
+			ptwsToMerge = append(ptwsToMerge, ptw)
+		}
+	}
+	s.partitionsLock.Unlock()
+
+	for _, ptw := range ptwsToMerge {
+		logger.Infof("starting force merge for the partition %s in order to apply -retention.filter", ptw.pt.path)
+		ptw.pt.ddb.startForceMerge()
+		ptw.decRef()
+	}
+}
+
+// ForceMergePartitions merges all the parts for partitions with names starting with the given partitionNamePrefix.
+//
+// Partition names are in the form YYYYMMDD, so the prefix YYYYMM selects all the partitions for the given month.
+// All the partitions are merged if partitionNamePrefix is empty. The parts are merged into the minimum number of parts
+// with sizes up to the maximum part size (see SetMaxPartSize). This may improve query performance after ingesting big amounts
+// of historical logs.
+//
+// The function returns after the merge is finished or after the storage is closed.
+func (s *Storage) ForceMergePartitions(partitionNamePrefix string) {
+	s.forceMergeWG.Add(1)
+	defer s.forceMergeWG.Done()
+
+	var ptws []*partitionWrapper
+	s.partitionsLock.Lock()
+	for _, ptw := range s.partitions {
+		name := time.Unix(0, ptw.day*nsecPerDay).UTC().Format(partitionNameFormat)
+		if strings.HasPrefix(name, partitionNamePrefix) {
+			ptw.incRef()
+			ptws = append(ptws, ptw)
+		}
+	}
+	s.partitionsLock.Unlock()
+
+	for _, ptw := range ptws {
+		if !needStop(s.stopCh) {
+			logger.Infof("starting forced merge for the partition %s", ptw.pt.path)
+			startTime := time.Now()
+			ptw.pt.ddb.mustForceMerge(s.stopCh)
+			logger.Infof("forced merge for the partition %s has been finished in %.3f seconds", ptw.pt.path, time.Since(startTime).Seconds())
+		}
+		ptw.decRef()
+	}
+}
+
 func (s *Storage) watchMaxDiskSpaceUsage() {
 	d := timeutil.AddJitterToDuration(10 * time.Second)
 	ticker := time.NewTicker(d)
@@ -397,7 +796,8 @@ func (s *Storage) watchMaxDiskSpaceUsage() {
 			ptw := ptws[i]
 			var ps PartitionStats
 			ptw.pt.updateStats(&ps)
-			n += ps.IndexdbSizeBytes + ps.CompressedSmallPartSize + ps.CompressedBigPartSize
+			// Data stored at object storage doesn't occupy local disk space.
+			n += ps.IndexdbSizeBytes + ps.CompressedSmallPartSize + ps.CompressedBigPartSize - ps.CompressedObjectStoragePartSize
 			if n <= uint64(s.maxDiskSpaceUsageBytes) {
 				continue
 			}
@@ -441,7 +841,7 @@ func (s *Storage) watchMaxDiskSpaceUsage() {
 }
 
 func (s *Storage) getMinAllowedDay() int64 {
-	return time.Now().UTC().Add(-s.retention).UnixNano() / nsecPerDay
+	return time.Now().UTC().Add(-s.maxRetention).UnixNano() / nsecPerDay
 }
 
 func (s *Storage) getMaxAllowedDay() int64 {
@@ -455,6 +855,7 @@ func (s *Storage) MustClose() {
 	// Stop background workers
 	close(s.stopCh)
 	s.wg.Wait()
+	s.forceMergeWG.Wait()
 
 	// Close partitions
 	for _, pw := range s.partitions {
@@ -480,6 +881,17 @@ func (s *Storage) MustClose() {
 	s.filterStreamCache.Stop()
 	s.filterStreamCache = nil
 
+	if s.queryResultsCache != nil {
+		if s.persistQueryResultsCache {
+			queryResultsCachePath := filepath.Join(s.path, cacheDirname, queryResultsCacheFilename)
+			if err := s.queryResultsCache.Save(queryResultsCachePath); err != nil {
+				logger.Panicf("FATAL: cannot save query results cache to %q: %s", queryResultsCachePath, err)
+			}
+		}
+		s.queryResultsCache.Stop()
+		s.queryResultsCache = nil
+	}
+
 	// release lock file
 	fs.MustClose(s.flockF)
 	s.flockF = nil
@@ -491,7 +903,21 @@ func (s *Storage) MustClose() {
 //
 // It is recommended checking whether the s is in read-only mode by calling IsReadOnly()
 // before calling MustAddRows.
+//
+// Log entries for tenants exceeding TenantLimits are dropped without reporting this to the caller.
+// The dropped log entries are counted in StorageStats.RowsDroppedTenantLimits. It is recommended checking the limits
+// by calling CheckTenantLimits before calling MustAddRows.
 func (s *Storage) MustAddRows(lr *LogRows) {
+	lrLimited := s.applyTenantLimits(lr)
+	if lrLimited != lr {
+		s.mustAddRowsInternal(lrLimited)
+		PutLogRows(lrLimited)
+		return
+	}
+	s.mustAddRowsInternal(lr)
+}
+
+func (s *Storage) mustAddRowsInternal(lr *LogRows) {
 	// Fast path - try adding all the rows to the hot partition
 	s.partitionsLock.Lock()
 	ptwHot := s.ptwHot
@@ -520,8 +946,8 @@ func (s *Storage) MustAddRows(lr *LogRows) {
 			tsf := TimeFormatter(ts)
 			minAllowedTsf := TimeFormatter(minAllowedDay * nsecPerDay)
 			tooSmallTimestampLogger.Warnf("skipping log entry with too small timestamp=%s; it must be bigger than %s according "+
-				"to the configured -retentionPeriod=%dd. See https://docs.victoriametrics.com/victorialogs/#retention ; "+
-				"log entry: %s", &tsf, &minAllowedTsf, durationToDays(s.retention), &rf)
+				"to the configured %s. See https://docs.victoriametrics.com/victorialogs/#retention ; "+
+				"log entry: %s", &tsf, &minAllowedTsf, s.getRetentionDescription(), &rf)
 			s.rowsDroppedTooSmallTimestamp.Add(1)
 			continue
 		}
@@ -568,6 +994,17 @@ func (tf *TimeFormatter) String() string {
 func (s *Storage) getPartitionForDay(day int64) *partitionWrapper {
 	s.partitionsLock.Lock()
 
+	for {
+		ch := s.detachingPartitions[day]
+		if ch == nil {
+			break
+		}
+		// Wait until the partition for the given day is detached, since the new partition is created at the same path.
+		s.partitionsLock.Unlock()
+		<-ch
+		s.partitionsLock.Lock()
+	}
+
 	// Search for the partition using binary search
 	ptws := s.partitions
 	n := sort.Search(len(ptws), func(i int) bool {
@@ -609,6 +1046,15 @@ func (s *Storage) getPartitionForDay(day int64) *partitionWrapper {
 func (s *Storage) UpdateStats(ss *StorageStats) {
 	ss.RowsDroppedTooBigTimestamp += s.rowsDroppedTooBigTimestamp.Load()
 	ss.RowsDroppedTooSmallTimestamp += s.rowsDroppedTooSmallTimestamp.Load()
+	ss.RowsDroppedTenantLimits += s.rowsDroppedTenantLimits.Load()
+
+	ss.ActiveQueries += uint64(s.getActiveQueriesCount())
+	ss.QueriesAbortedScanLimits += s.queriesAbortedScanLimits.Load()
+	ss.QueriesAbortedMemoryLimit += s.queriesAbortedMemoryLimit.Load()
+	ss.QueriesAbortedTimeout += s.queriesAbortedTimeout.Load()
+	ss.QueriesAbortedCanceled += s.queriesAbortedCanceled.Load()
+	ss.StatsPipeGroups += s.statsPipeGroups.Load()
+	ss.SlowQueries += s.slowQueries.Load()
 
 	s.partitionsLock.Lock()
 	ss.PartitionsCount += uint64(len(s.partitions))
@@ -617,6 +1063,24 @@ func (s *Storage) UpdateStats(ss *StorageStats) {
 	}
 	s.partitionsLock.Unlock()
 
+	s.deleteTasksLock.Lock()
+	ss.DeleteTasksCount += uint64(len(s.deleteTasks))
+	s.deleteTasksLock.Unlock()
+
+	if s.queryResultsCache != nil {
+		var cs fastcache.Stats
+		s.queryResultsCache.UpdateStats(&cs)
+		ss.QueryResultsCacheRequests += s.queryResultsCacheRequests.Load()
+		ss.QueryResultsCacheMisses += s.queryResultsCacheMisses.Load()
+		ss.QueryResultsCacheEntries += cs.EntriesCount
+		ss.QueryResultsCacheSizeBytes += cs.BytesSize
+		ss.QueryResultsCacheMaxSizeBytes += cs.MaxBytesSize
+	}
+
+	if s.objectStorage != nil {
+		s.objectStorage.updateStats(ss)
+	}
+
 	ss.IsReadOnly = s.IsReadOnly()
 }
 
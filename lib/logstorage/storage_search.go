@@ -2,15 +2,19 @@ package logstorage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"time"
+	"unsafe"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/cgroup"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/querytracer"
 )
 
 // genericSearchOptions contain options used for search.
@@ -34,13 +38,49 @@ type genericSearchOptions struct {
 	// neededColumnNames contains names of columns to return in the result
 	neededColumnNames []string
 
-	// unneededColumnNames contains names of columns, which mustn't be returned in the result.
+	// unneededColumns contains columns, which mustn't be returned in the result.
 	//
-	// This list is consulted if needAllColumns=true
-	unneededColumnNames []string
+	// This set is consulted if needAllColumns=true
+	unneededColumns *fieldsSet
 
-	// needAllColumns is set to true when all the columns except of unneededColumnNames must be returned in the result
+	// needAllColumns is set to true when all the columns except of unneededColumns must be returned in the result
 	needAllColumns bool
+
+	// qs is an optional stats for the query execution
+	qs *queryStats
+
+	// fieldsIndexProcessor is an optional processor for the fields index of the parts fully covered by the search time range.
+	//
+	// If it is set, then the results for such parts are obtained from their fields index instead of searching their blocks.
+	fieldsIndexProcessor partFieldsIndexProcessor
+}
+
+// withTimeRange returns a copy of so, which selects only rows on the given time range.
+func (so *genericSearchOptions) withTimeRange(minTimestamp, maxTimestamp int64) *genericSearchOptions {
+	startStr := marshalTimestampRFC3339NanoString(nil, minTimestamp)
+	endStr := marshalTimestampRFC3339NanoString(nil, maxTimestamp)
+	ft := &filterTime{
+		minTimestamp: minTimestamp,
+		maxTimestamp: maxTimestamp,
+		stringRepr:   fmt.Sprintf("[%s, %s]", startStr, endStr),
+	}
+
+	soCopy := *so
+	soCopy.minTimestamp = minTimestamp
+	soCopy.maxTimestamp = maxTimestamp
+	if fa, ok := so.filter.(*filterAnd); ok {
+		filters := make([]filter, len(fa.filters)+1)
+		filters[0] = ft
+		copy(filters[1:], fa.filters)
+		soCopy.filter = &filterAnd{
+			filters: filters,
+		}
+	} else {
+		soCopy.filter = &filterAnd{
+			filters: []filter{ft, so.filter},
+		}
+	}
+	return &soCopy
 }
 
 type searchOptions struct {
@@ -64,13 +104,21 @@ type searchOptions struct {
 	// neededColumnNames contains names of columns to return in the result
 	neededColumnNames []string
 
-	// unneededColumnNames contains names of columns, which mustn't be returned in the result.
+	// unneededColumns contains columns, which mustn't be returned in the result.
 	//
-	// This list is consulted when needAllColumns=true.
-	unneededColumnNames []string
+	// This set is consulted when needAllColumns=true.
+	unneededColumns *fieldsSet
 
-	// needAllColumns is set to true when all the columns except of unneededColumnNames must be returned in the result
+	// needAllColumns is set to true when all the columns except of unneededColumns must be returned in the result
 	needAllColumns bool
+
+	// qs is an optional stats for the query execution
+	qs *queryStats
+
+	// fieldsIndexProcessor is an optional processor for the fields index of the parts fully covered by the search time range.
+	//
+	// If it is set, then the results for such parts are obtained from their fields index instead of searching their blocks.
+	fieldsIndexProcessor partFieldsIndexProcessor
 }
 
 // WriteBlockFunc must write a block with the given timestamps and columns.
@@ -79,11 +127,32 @@ type searchOptions struct {
 type WriteBlockFunc func(workerID uint, timestamps []int64, columns []BlockColumn)
 
 // RunQuery runs the given q and calls writeBlock for results.
+//
+// The results for queries over the data, which isn't expected to change, are cached.
+// See https://docs.victoriametrics.com/victorialogs/querying/#query-results-cache
+//
+// The query execution is traced if the tracer is set via q.SetTracer.
 func (s *Storage) RunQuery(ctx context.Context, tenantIDs []TenantID, q *Query, writeBlock WriteBlockFunc) error {
+	qt := q.qt.NewChild("run query [%s]", q)
+	defer qt.Done()
+
+	var qrr *queryResultsRecorder
+	cacheKey := s.getQueryResultsCacheKey(tenantIDs, q)
+	if cacheKey != nil {
+		if s.writeCachedQueryResults(cacheKey, writeBlock) {
+			qt.Printf("the results are obtained from the query results cache")
+			return nil
+		}
+		qrr = &queryResultsRecorder{}
+	}
+
 	qNew, err := s.initFilterInValues(ctx, tenantIDs, q)
 	if err != nil {
 		return err
 	}
+	if qNew != q {
+		qt.Printf("execute subqueries for in(...) filters")
+	}
 
 	writeBlockResult := func(workerID uint, br *blockResult) {
 		if len(br.timestamps) == 0 {
@@ -101,16 +170,55 @@ func (s *Storage) RunQuery(ctx context.Context, tenantIDs []TenantID, q *Query,
 				Values: values,
 			})
 		}
+		if qrr != nil {
+			qrr.writeBlock(br.timestamps, csDst)
+		}
 		writeBlock(workerID, br.timestamps, csDst)
 
 		brs.cs = csDst
 		putBlockRows(brs)
 	}
 
-	return s.runQuery(ctx, tenantIDs, qNew, writeBlockResult)
+	if err := s.runQuery(ctx, qt, tenantIDs, qNew, writeBlockResult); err != nil {
+		return err
+	}
+	if qrr != nil && ctx.Err() == nil {
+		// Cache only complete results.
+		s.putQueryResultsToCache(cacheKey, qrr)
+	}
+	return nil
 }
 
-func (s *Storage) runQuery(ctx context.Context, tenantIDs []TenantID, q *Query, writeBlockResultFunc func(workerID uint, br *blockResult)) error {
+// runQuery runs q and calls writeBlockResultFunc for results.
+//
+// The duration and the number of processed rows for the storage search and for every pipe at q are added to qt.
+// The per-pipe execution stats are also registered at s, so they can be obtained via s.GetPipeMetrics().
+// The query is logged if its execution time exceeds StorageConfig.LogSlowQueryDuration.
+// The query is registered as active query while it is executed, so it can be canceled via s.CancelQuery().
+func (s *Storage) runQuery(ctx context.Context, qt *querytracer.Tracer, tenantIDs []TenantID, q *Query, writeBlockResultFunc func(workerID uint, br *blockResult)) error {
+	startTime := time.Now()
+
+	ctxCancelable, cancelQuery := context.WithCancel(ctx)
+	defer cancelQuery()
+	ctx = ctxCancelable
+
+	qs := newQueryStats()
+	if qt.Enabled() {
+		// Collect the detailed execution stats only for traced queries, since they aren't needed otherwise.
+		qs.enableExecStats()
+	}
+	aq := s.registerActiveQuery(tenantIDs, q, qs, cancelQuery)
+	defer s.unregisterActiveQuery(aq)
+
+	qNew, err := s.initJoinMaps(ctx, tenantIDs, q)
+	if err != nil {
+		return err
+	}
+	if qNew != q {
+		qt.Printf("execute subqueries for join pipes")
+	}
+	q = qNew
+
 	streamIDs := q.getStreamIDs()
 	sort.Slice(streamIDs, func(i, j int) bool {
 		return streamIDs[i].less(&streamIDs[j])
@@ -118,67 +226,166 @@ func (s *Storage) runQuery(ctx context.Context, tenantIDs []TenantID, q *Query,
 
 	minTimestamp, maxTimestamp := q.GetFilterTimeRange()
 
-	neededColumnNames, unneededColumnNames := q.getNeededColumns()
+	if q.maxScannedRows > 0 || q.maxScannedBytes > 0 {
+		// Stop the query when it exceeds the limits on the scanned data.
+		ctxLimited, cancel := context.WithCancel(ctx)
+		defer cancel()
+		ctx = ctxLimited
+		qs.setScanLimits(q.maxScannedRows, q.maxScannedBytes, cancel)
+	}
+
+	neededFields, unneededFields := getNeededFieldsForPipes(q.pipes)
+	neededColumnNames := neededFields.getAll()
 	so := &genericSearchOptions{
-		tenantIDs:           tenantIDs,
-		streamIDs:           streamIDs,
-		minTimestamp:        minTimestamp,
-		maxTimestamp:        maxTimestamp,
-		filter:              q.f,
-		neededColumnNames:   neededColumnNames,
-		unneededColumnNames: unneededColumnNames,
-		needAllColumns:      slices.Contains(neededColumnNames, "*"),
+		tenantIDs:         tenantIDs,
+		streamIDs:         streamIDs,
+		minTimestamp:      minTimestamp,
+		maxTimestamp:      maxTimestamp,
+		filter:            q.f,
+		neededColumnNames: neededColumnNames,
+		unneededColumns:   unneededFields,
+		needAllColumns:    slices.Contains(neededColumnNames, "*"),
+		qs:                qs,
 	}
 
 	workersCount := cgroup.AvailableCPUs()
 
-	ppMain := newDefaultPipeProcessor(writeBlockResultFunc)
+	var ppMain pipeProcessor = newDefaultPipeProcessor(writeBlockResultFunc)
+
+	// ptpMain and ptps count blocks and rows passed to ppMain and to every pipe.
+	ptpMain := newPipeTracerProcessor(ppMain, qt.Enabled())
+	ppMain = ptpMain
+	ptps := make([]*pipeTracerProcessor, len(q.pipes))
+
 	pp := ppMain
-	stopCh := ctx.Done()
+	ppFirst := pp
+	mb := newMemoryBudget(q.maxMemory)
 	cancels := make([]func(), len(q.pipes))
 	pps := make([]pipeProcessor, len(q.pipes))
 
+	// Every pipe gets a context canceled by the next pipe, while ctx remains the query context.
+	ctxPipe := ctx
+	stopCh := ctxPipe.Done()
 	var errPipe error
 	for i := len(q.pipes) - 1; i >= 0; i-- {
 		p := q.pipes[i]
-		ctxChild, cancel := context.WithCancel(ctx)
-		pp = p.newPipeProcessor(workersCount, stopCh, cancel, pp)
+		ctxChild, cancel := context.WithCancel(ctxPipe)
+		pp = p.newPipeProcessor(workersCount, stopCh, cancel, pp, mb)
+		ppFirst = pp
 
 		pcp, ok := pp.(*pipeStreamContextProcessor)
 		if ok {
-			pcp.init(ctx, s, minTimestamp, maxTimestamp)
+			pcp.init(ctxPipe, s, minTimestamp, maxTimestamp)
 			if i > 0 {
 				errPipe = fmt.Errorf("[%s] pipe must go after [%s] filter; now it goes after the [%s] pipe", p, q.f, q.pipes[i-1])
 			}
 		}
+		if pup, ok := pp.(*pipeUnionProcessor); ok {
+			pup.init(ctxPipe, s, tenantIDs)
+		}
 
 		stopCh = ctxChild.Done()
-		ctx = ctxChild
+		ctxPipe = ctxChild
 
 		cancels[i] = cancel
+		ptps[i] = newPipeTracerProcessor(pp, qt.Enabled())
+		pp = ptps[i]
 		pps[i] = pp
 	}
 
 	if errPipe == nil {
-		s.search(workersCount, so, stopCh, pp.writeBlock)
+		qtSearch := qt.NewChild("search for logs matching [%s]", q.f)
+		so.fieldsIndexProcessor = getPartFieldsIndexProcessor(q, ppFirst)
+		psp, ok := ppFirst.(*pipeStatsProcessor)
+		if !ok {
+			s.search(workersCount, so, stopCh, pp.writeBlock)
+		} else {
+			// Search only the time ranges, which aren't covered by the stats states from the query results cache.
+			trs := s.importCachedStatsStates(ctx, workersCount, tenantIDs, q, so, psp)
+			if len(trs) == 0 {
+				s.search(workersCount, so, stopCh, pp.writeBlock)
+			} else {
+				qtSearch.Printf("use stats states for %d time ranges instead of passing the matching rows to the stats pipe; see https://docs.victoriametrics.com/victorialogs/querying/#query-results-cache", len(trs))
+				for _, tr := range getUncoveredTimeRanges(so.minTimestamp, so.maxTimestamp, trs) {
+					s.search(workersCount, so.withTimeRange(tr.minTimestamp, tr.maxTimestamp), stopCh, pp.writeBlock)
+				}
+			}
+		}
+		qse := qs.getExecStats()
+		if n := qse.partsFromFieldsIndex.Load(); n > 0 {
+			qtSearch.Printf("obtained the results for %d parts from their fields index without reading their blocks", n)
+		}
+		qtSearch.Donef("scanned %d rows in %d blocks, skipped %d blocks by the index, read %d compressed bytes, decompressed %d bytes; "+
+			"%d rows in %d blocks matched the filter", qs.rowsScanned.Load(), qse.blocksScanned.Load(), qse.blocksSkipped.Load(),
+			qs.bytesRead.Load(), qse.bytesDecompressed.Load(), qs.rowsMatched.Load(), qse.blocksMatched.Load())
 	}
 
 	var errFlush error
 	for i, pp := range pps {
+		qtPipe := qt.NewChild("pipe [%s]", q.pipes[i])
+		inputRows := ptps[i].rowsCount.Load()
+		qtPipe.Printf("got %d rows in %d blocks", inputRows, ptps[i].blocksCount.Load())
+
+		startTime := time.Now()
 		if err := pp.flush(); err != nil && errFlush == nil {
 			errFlush = err
 		}
+		d := time.Since(startTime)
 		cancel := cancels[i]
 		cancel()
+
+		// All the rows from the pipe have been passed to the next pipe after the flush.
+		ptpNext := ptpMain
+		if i+1 < len(ptps) {
+			ptpNext = ptps[i+1]
+		}
+		outputRows := ptpNext.rowsCount.Load()
+		if qtPipe.Enabled() {
+			// The time spent in the pipe is the time spent in its writeBlock and flush calls
+			// minus the time spent in the next pipes, which receive blocks from it.
+			dPipe := time.Duration(ptps[i].writeBlockDuration.Load()-ptpNext.writeBlockDuration.Load()) + d
+			qtPipe.Printf("processing took %.3f seconds summed across workers", dPipe.Seconds())
+		}
+		qtPipe.Donef("flush in %.3f seconds; returned %d rows in %d blocks", d.Seconds(), outputRows, ptpNext.blocksCount.Load())
+
+		s.getPipeMetrics(q.pipes[i]).updateFlushStats(inputRows, outputRows, d)
+		if _, ok := q.pipes[i].(*pipeStats); ok {
+			s.statsPipeGroups.Add(outputRows)
+		}
 	}
 	if err := ppMain.flush(); err != nil && errFlush == nil {
 		errFlush = err
 	}
+	qt.Printf("the query returned %d rows in %d blocks", ptpMain.rowsCount.Load(), ptpMain.blocksCount.Load())
+	s.logSlowQueryIfNeeded(startTime, tenantIDs, q, qs, ptpMain.rowsCount.Load())
 
 	if errPipe != nil {
 		return errPipe
 	}
 
+	if aq.canceled.Load() {
+		// The query has been canceled via s.CancelQuery(), so the results are incomplete.
+		s.queriesAbortedCanceled.Add(1)
+		return fmt.Errorf("the query has been canceled")
+	}
+
+	if err := qs.getLimitErr(); err != nil {
+		// The query has been interrupted because it exceeded the limits on the scanned data, so the results are incomplete.
+		s.queriesAbortedScanLimits.Add(1)
+		return err
+	}
+
+	if err := ctx.Err(); errors.Is(err, context.DeadlineExceeded) {
+		// The query has been interrupted because of the timeout, so the results are incomplete.
+		s.queriesAbortedTimeout.Add(1)
+		return fmt.Errorf("query exceeded timeout: %w", err)
+	}
+
+	if errFlush != nil && mb.isExceeded() {
+		// The query has been interrupted because its pipes exceeded the memory limit.
+		s.queriesAbortedMemoryLimit.Add(1)
+	}
+
 	return errFlush
 }
 
@@ -200,10 +407,7 @@ func (s *Storage) GetFieldNames(ctx context.Context, tenantIDs []TenantID, q *Qu
 
 	pipes = append(pipes, pf)
 
-	q = &Query{
-		f:     q.f,
-		pipes: pipes,
-	}
+	q = q.cloneWithPipes(pipes)
 
 	return s.runValuesWithHitsQuery(ctx, tenantIDs, q)
 }
@@ -225,10 +429,7 @@ func (s *Storage) getFieldValuesNoHits(ctx context.Context, tenantIDs []TenantID
 
 	pipes = append(pipes, pu)
 
-	q = &Query{
-		f:     q.f,
-		pipes: pipes,
-	}
+	q = q.cloneWithPipes(pipes)
 
 	var values []string
 	var valuesLock sync.Mutex
@@ -254,7 +455,7 @@ func (s *Storage) getFieldValuesNoHits(ctx context.Context, tenantIDs []TenantID
 		valuesLock.Unlock()
 	}
 
-	if err := s.runQuery(ctx, tenantIDs, q, writeBlockResult); err != nil {
+	if err := s.runQuery(ctx, nil, tenantIDs, q, writeBlockResult); err != nil {
 		return nil, err
 	}
 
@@ -281,10 +482,7 @@ func (s *Storage) GetFieldValues(ctx context.Context, tenantIDs []TenantID, q *Q
 
 	pipes = append(pipes, pu)
 
-	q = &Query{
-		f:     q.f,
-		pipes: pipes,
-	}
+	q = q.cloneWithPipes(pipes)
 
 	return s.runValuesWithHitsQuery(ctx, tenantIDs, q)
 }
@@ -421,7 +619,7 @@ func (s *Storage) runValuesWithHitsQuery(ctx context.Context, tenantIDs []Tenant
 		resultsLock.Unlock()
 	}
 
-	err := s.runQuery(ctx, tenantIDs, q, writeBlockResult)
+	err := s.runQuery(ctx, nil, tenantIDs, q, writeBlockResult)
 	if err != nil {
 		return nil, err
 	}
@@ -447,13 +645,140 @@ func (s *Storage) initFilterInValues(ctx context.Context, tenantIDs []TenantID,
 	if err != nil {
 		return nil, err
 	}
-	qNew := &Query{
-		f:     fNew,
-		pipes: pipesNew,
+	qNew := q.cloneWithPipes(pipesNew)
+	qNew.f = fNew
+	return qNew, nil
+}
+
+// initJoinMaps returns new query with the initialized join maps for 'join' pipes at q.
+func (s *Storage) initJoinMaps(ctx context.Context, tenantIDs []TenantID, q *Query) (*Query, error) {
+	if !hasJoinPipes(q.pipes) {
+		return q, nil
+	}
+
+	maxSize := q.maxMemory
+	if maxSize <= 0 {
+		maxSize = GetDefaultMaxQueryMemory()
+	}
+	getJoinMap := func(q *Query, byFields []string) (map[string][][]Field, error) {
+		return s.getJoinMap(ctx, tenantIDs, q, byFields, maxSize)
+	}
+
+	pipesNew := make([]pipe, len(q.pipes))
+	for i, p := range q.pipes {
+		if pj, ok := p.(*pipeJoin); ok {
+			pjNew, err := pj.initJoinMap(getJoinMap)
+			if err != nil {
+				return nil, err
+			}
+			p = pjNew
+		}
+		pipesNew[i] = p
 	}
+	qNew := q.cloneWithPipes(pipesNew)
 	return qNew, nil
 }
 
+func hasJoinPipes(pipes []pipe) bool {
+	for _, p := range pipes {
+		if _, ok := p.(*pipeJoin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// getJoinMap returns logs from q grouped by byFields values.
+//
+// An error is returned if the size of the returned map exceeds maxSize bytes.
+func (s *Storage) getJoinMap(ctx context.Context, tenantIDs []TenantID, q *Query, byFields []string, maxSize int64) (map[string][][]Field, error) {
+	q, err := s.initFilterInValues(ctx, tenantIDs, q)
+	if err != nil {
+		return nil, err
+	}
+
+	ctxJoin, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	m := make(map[string][][]Field)
+	size := int64(0)
+	var errJoin error
+	var mLock sync.Mutex
+
+	writeBlockResult := func(_ uint, br *blockResult) {
+		if len(br.timestamps) == 0 {
+			return
+		}
+
+		byValues := make([][]string, len(byFields))
+		for i, f := range byFields {
+			c := br.getColumnByName(f)
+			byValues[i] = c.getValues(br)
+		}
+
+		var cs []*blockResultColumn
+		for _, c := range br.getColumns() {
+			if !slices.Contains(byFields, c.name) {
+				cs = append(cs, c)
+			}
+		}
+
+		mLock.Lock()
+		defer mLock.Unlock()
+
+		if errJoin != nil {
+			return
+		}
+
+		var keyBuf []byte
+		values := make([]string, len(byFields))
+		for rowIdx := range br.timestamps {
+			for i := range byValues {
+				values[i] = byValues[i][rowIdx]
+			}
+			if areEmptyJoinValues(values) {
+				continue
+			}
+			keyBuf = marshalJoinKey(keyBuf[:0], values)
+
+			var fields []Field
+			for _, c := range cs {
+				v := c.getValueAtRow(br, rowIdx)
+				if v == "" {
+					continue
+				}
+				fields = append(fields, Field{
+					Name:  strings.Clone(c.name),
+					Value: strings.Clone(v),
+				})
+				size += int64(len(c.name) + len(v) + int(unsafe.Sizeof(Field{})))
+			}
+
+			rows, ok := m[string(keyBuf)]
+			if !ok {
+				size += int64(len(keyBuf))
+			}
+			m[string(keyBuf)] = append(rows, fields)
+			size += int64(unsafe.Sizeof(fields))
+		}
+
+		if size > maxSize {
+			errJoin = fmt.Errorf("the size of the join results exceeds %d bytes; "+
+				"reduce the number of logs returned from the query with additional filters or reduce the number of returned fields with 'fields' pipe", maxSize)
+			cancel()
+		}
+	}
+
+	if err := s.runQuery(ctxJoin, nil, tenantIDs, q, writeBlockResult); err != nil {
+		return nil, err
+	}
+	if errJoin != nil {
+		return nil, errJoin
+	}
+
+	return m, nil
+}
+
 func (iff *ifFilter) hasFilterInWithQuery() bool {
 	if iff == nil {
 		return false
@@ -671,26 +996,16 @@ func (s *Storage) search(workersCount int, so *genericSearchOptions, stopCh <-ch
 	}
 
 	// Select partitions according to the selected time range
-	s.partitionsLock.Lock()
-	ptws := s.partitions
-	minDay := so.minTimestamp / nsecPerDay
-	n := sort.Search(len(ptws), func(i int) bool {
-		return ptws[i].day >= minDay
-	})
-	ptws = ptws[n:]
-	maxDay := so.maxTimestamp / nsecPerDay
-	n = sort.Search(len(ptws), func(i int) bool {
-		return ptws[i].day > maxDay
-	})
-	ptws = ptws[:n]
-	for _, ptw := range ptws {
-		ptw.incRef()
-	}
-	s.partitionsLock.Unlock()
+	ptws := s.getPartitionsForTimeRange(so.minTimestamp, so.maxTimestamp)
 
 	// Obtain common filterStream from f
 	sf, f := getCommonStreamFilter(so.filter)
 
+	// Allow filtering by fields inside JSON objects in the form `obj.nested.key`
+	if hasJSONPathFilters(f) {
+		f = initJSONPathFilters(f)
+	}
+
 	// Schedule concurrent search across matching partitions.
 	psfs := make([]partitionSearchFinalizer, len(ptws))
 	var wgSearchers sync.WaitGroup
@@ -720,6 +1035,30 @@ func (s *Storage) search(workersCount int, so *genericSearchOptions, stopCh <-ch
 	}
 }
 
+// getPartitionsForTimeRange returns partitions for the given time range.
+//
+// decRef() must be called on the returned partitions when they are no longer needed.
+func (s *Storage) getPartitionsForTimeRange(minTimestamp, maxTimestamp int64) []*partitionWrapper {
+	s.partitionsLock.Lock()
+	ptws := s.partitions
+	minDay := minTimestamp / nsecPerDay
+	n := sort.Search(len(ptws), func(i int) bool {
+		return ptws[i].day >= minDay
+	})
+	ptws = ptws[n:]
+	maxDay := maxTimestamp / nsecPerDay
+	n = sort.Search(len(ptws), func(i int) bool {
+		return ptws[i].day > maxDay
+	})
+	ptws = append([]*partitionWrapper{}, ptws[:n]...)
+	for _, ptw := range ptws {
+		ptw.incRef()
+	}
+	s.partitionsLock.Unlock()
+
+	return ptws
+}
+
 // partitionSearchConcurrencyLimitCh limits the number of concurrent searches in partition.
 //
 // This is needed for limiting memory usage under high load.
@@ -749,14 +1088,17 @@ func (pt *partition) search(sf *StreamFilter, f filter, so *genericSearchOptions
 		f = initStreamFilters(tenantIDs, pt.idb, f)
 	}
 	soInternal := &searchOptions{
-		tenantIDs:           tenantIDs,
-		streamIDs:           streamIDs,
-		minTimestamp:        so.minTimestamp,
-		maxTimestamp:        so.maxTimestamp,
-		filter:              f,
-		neededColumnNames:   so.neededColumnNames,
-		unneededColumnNames: so.unneededColumnNames,
-		needAllColumns:      so.needAllColumns,
+		tenantIDs:         tenantIDs,
+		streamIDs:         streamIDs,
+		minTimestamp:      so.minTimestamp,
+		maxTimestamp:      so.maxTimestamp,
+		filter:            f,
+		neededColumnNames: so.neededColumnNames,
+		unneededColumns:   so.unneededColumns,
+		needAllColumns:    so.needAllColumns,
+		qs:                so.qs,
+
+		fieldsIndexProcessor: so.fieldsIndexProcessor,
 	}
 	return pt.ddb.search(soInternal, workCh, stopCh)
 }
@@ -834,9 +1176,32 @@ func (ddb *datadb) search(so *searchOptions, workCh chan<- *blockSearchWorkBatch
 	}
 	ddb.partsLock.Unlock()
 
-	// Apply search to matching parts
+	// Apply search to matching parts.
+	// Skip log entries matching delete tasks, which weren't applied to parts yet.
+	dts, deleteTaskSeq := ddb.pt.s.getDeleteTasks()
+	soByDeleteTaskSeq := make(map[uint64]*searchOptions)
 	for _, pw := range pws {
-		pw.p.search(so, workCh, stopCh)
+		soPart := so
+		if seq := pw.p.ph.DeleteTaskSeq; seq < deleteTaskSeq {
+			soPart = soByDeleteTaskSeq[seq]
+			if soPart == nil {
+				soPart = so
+				if fd := newFilterDeleteTasks(ddb.pt, dts, seq); fd != nil {
+					soCopy := *so
+					soCopy.filter = &filterAnd{
+						filters: []filter{so.filter, &filterNot{f: fd}},
+					}
+					soPart = &soCopy
+				}
+				soByDeleteTaskSeq[seq] = soPart
+			}
+		}
+		if soPart == so && !needStop(stopCh) && pw.p.searchFieldsIndex(so) {
+			// The results for the part have been obtained from its fields index.
+			// The parts with delete tasks, which weren't applied yet, are searched, since the deleted logs must be skipped.
+			continue
+		}
+		pw.p.search(soPart, workCh, stopCh)
 	}
 
 	return func() {
@@ -848,12 +1213,18 @@ func (ddb *datadb) search(so *searchOptions, workCh chan<- *blockSearchWorkBatch
 
 func (p *part) search(so *searchOptions, workCh chan<- *blockSearchWorkBatch, stopCh <-chan struct{}) {
 	bhss := getBlockHeaders()
+	var blocksScheduled uint64
 	if len(so.tenantIDs) > 0 {
-		p.searchByTenantIDs(so, bhss, workCh, stopCh)
+		blocksScheduled = p.searchByTenantIDs(so, bhss, workCh, stopCh)
 	} else {
-		p.searchByStreamIDs(so, bhss, workCh, stopCh)
+		blocksScheduled = p.searchByStreamIDs(so, bhss, workCh, stopCh)
 	}
 	putBlockHeaders(bhss)
+
+	if !needStop(stopCh) {
+		// All the blocks, which weren't scheduled for the search, were skipped by the index.
+		so.qs.addBlocksSkipped(p.ph.BlocksCount - blocksScheduled)
+	}
 }
 
 func getBlockHeaders() *blockHeaders {
@@ -883,12 +1254,17 @@ func (bhss *blockHeaders) reset() {
 	bhss.bhs = bhs[:0]
 }
 
-func (p *part) searchByTenantIDs(so *searchOptions, bhss *blockHeaders, workCh chan<- *blockSearchWorkBatch, stopCh <-chan struct{}) {
+// searchByTenantIDs schedules the search for the blocks with the logs for so.tenantIDs at p.
+//
+// It returns the number of scheduled blocks.
+func (p *part) searchByTenantIDs(so *searchOptions, bhss *blockHeaders, workCh chan<- *blockSearchWorkBatch, stopCh <-chan struct{}) uint64 {
 	// it is assumed that tenantIDs are sorted
 	tenantIDs := so.tenantIDs
 
 	bswb := getBlockSearchWorkBatch()
+	var blocksScheduled uint64
 	scheduleBlockSearch := func(bh *blockHeader) bool {
+		blocksScheduled++
 		if bswb.appendBlockSearchWork(p, so, bh) {
 			return true
 		}
@@ -905,7 +1281,7 @@ func (p *part) searchByTenantIDs(so *searchOptions, bhss *blockHeaders, workCh c
 	ibhs := p.indexBlockHeaders
 	for len(ibhs) > 0 && len(tenantIDs) > 0 {
 		if needStop(stopCh) {
-			return
+			return blocksScheduled
 		}
 
 		// locate tenantID equal or bigger than the tenantID in ibhs[0]
@@ -957,7 +1333,7 @@ func (p *part) searchByTenantIDs(so *searchOptions, bhss *blockHeaders, workCh c
 					continue
 				}
 				if !scheduleBlockSearch(bh) {
-					return
+					return blocksScheduled
 				}
 			}
 			if len(bhs) == 0 {
@@ -983,14 +1359,20 @@ func (p *part) searchByTenantIDs(so *searchOptions, bhss *blockHeaders, workCh c
 	case <-stopCh:
 	case workCh <- bswb:
 	}
+	return blocksScheduled
 }
 
-func (p *part) searchByStreamIDs(so *searchOptions, bhss *blockHeaders, workCh chan<- *blockSearchWorkBatch, stopCh <-chan struct{}) {
+// searchByStreamIDs schedules the search for the blocks with the logs for so.streamIDs at p.
+//
+// It returns the number of scheduled blocks.
+func (p *part) searchByStreamIDs(so *searchOptions, bhss *blockHeaders, workCh chan<- *blockSearchWorkBatch, stopCh <-chan struct{}) uint64 {
 	// it is assumed that streamIDs are sorted
 	streamIDs := so.streamIDs
 
 	bswb := getBlockSearchWorkBatch()
+	var blocksScheduled uint64
 	scheduleBlockSearch := func(bh *blockHeader) bool {
+		blocksScheduled++
 		if bswb.appendBlockSearchWork(p, so, bh) {
 			return true
 		}
@@ -1008,7 +1390,7 @@ func (p *part) searchByStreamIDs(so *searchOptions, bhss *blockHeaders, workCh c
 
 	for len(ibhs) > 0 && len(streamIDs) > 0 {
 		if needStop(stopCh) {
-			return
+			return blocksScheduled
 		}
 
 		// locate streamID equal or bigger than the streamID in ibhs[0]
@@ -1060,7 +1442,7 @@ func (p *part) searchByStreamIDs(so *searchOptions, bhss *blockHeaders, workCh c
 					continue
 				}
 				if !scheduleBlockSearch(bh) {
-					return
+					return blocksScheduled
 				}
 			}
 			if len(bhs) == 0 {
@@ -1086,6 +1468,7 @@ func (p *part) searchByStreamIDs(so *searchOptions, bhss *blockHeaders, workCh c
 	case <-stopCh:
 	case workCh <- bswb:
 	}
+	return blocksScheduled
 }
 
 func appendPartsInTimeRange(dst, src []*partWrapper, minTimestamp, maxTimestamp int64) []*partWrapper {
@@ -2,9 +2,11 @@ package logstorage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"reflect"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -13,6 +15,7 @@ import (
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/querytracer"
 )
 
 func TestStorageRunQuery(t *testing.T) {
@@ -183,6 +186,109 @@ func TestStorageRunQuery(t *testing.T) {
 			t.Fatalf("unexpected number of matching rows; got %d; want %d", n, expectedRowsCount)
 		}
 	})
+	t.Run("query-trace", func(t *testing.T) {
+		q := mustParseQuery(`"log message" | fields stream-id | stats by (stream-id) count() rows`)
+		qt := querytracer.New(true, "test")
+		q.SetTracer(qt)
+		var rowsCountTotal atomic.Uint32
+		writeBlock := func(_ uint, timestamps []int64, _ []BlockColumn) {
+			rowsCountTotal.Add(uint32(len(timestamps)))
+		}
+		mustRunQuery(t, allTenantIDs, q, writeBlock)
+		qt.Done()
+
+		if n := rowsCountTotal.Load(); n != streamsPerTenant {
+			t.Fatalf("unexpected number of rows; got %d; want %d", n, streamsPerTenant)
+		}
+
+		rowsCount := tenantsCount * streamsPerTenant * blocksPerStream * rowsPerBlock
+		trace := qt.String()
+		for _, substr := range []string{
+			fmt.Sprintf("run query [%s]", q),
+			fmt.Sprintf("search for logs matching [%s]", q.f),
+			fmt.Sprintf("scanned %d rows in", rowsCount),
+			fmt.Sprintf("pipe [%s]: flush in", q.pipes[0]),
+			fmt.Sprintf("got %d rows in", rowsCount),
+			fmt.Sprintf("the query returned %d rows", streamsPerTenant),
+		} {
+			if !strings.Contains(trace, substr) {
+				t.Fatalf("missing %q in the query trace\n%s", substr, trace)
+			}
+		}
+		for _, re := range []string{
+			`skipped 0 blocks by the index, read [1-9][0-9]* compressed bytes, decompressed [1-9][0-9]* bytes; `,
+			fmt.Sprintf(`%d rows in [1-9][0-9]* blocks matched the filter`, rowsCount),
+			fmt.Sprintf(`pipe \[fields stream-id\]: flush in [0-9.]+ seconds; returned %d rows in [1-9][0-9]* blocks`, rowsCount),
+			`processing took [0-9.]+ seconds summed across workers`,
+			fmt.Sprintf(`\[stats by \(stream-id\) count\(\*\) as rows\]: flush in [0-9.]+ seconds; returned %d rows in`, streamsPerTenant),
+		} {
+			if !regexp.MustCompile(re).MatchString(trace) {
+				t.Fatalf("missing %q in the query trace\n%s", re, trace)
+			}
+		}
+	})
+	t.Run("query-trace-skipped-blocks", func(t *testing.T) {
+		q := mustParseQuery(`"log message"`)
+		qt := querytracer.New(true, "test")
+		q.SetTracer(qt)
+		writeBlock := func(_ uint, _ []int64, _ []BlockColumn) {}
+		mustRunQuery(t, allTenantIDs[:1], q, writeBlock)
+		qt.Done()
+
+		// The blocks for other tenants must be skipped by the index.
+		rowsCount := streamsPerTenant * blocksPerStream * rowsPerBlock
+		trace := qt.String()
+		re := fmt.Sprintf(`scanned %d rows in [1-9][0-9]* blocks, skipped [1-9][0-9]* blocks by the index`, rowsCount)
+		if !regexp.MustCompile(re).MatchString(trace) {
+			t.Fatalf("missing %q in the query trace\n%s", re, trace)
+		}
+	})
+	t.Run("pipe-metrics", func(t *testing.T) {
+		getPipeMetrics := func(name string) PipeMetrics {
+			for _, pm := range s.GetPipeMetrics() {
+				if pm.Name == name {
+					return pm
+				}
+			}
+			return PipeMetrics{}
+		}
+		var ssPrev StorageStats
+		s.UpdateStats(&ssPrev)
+		pmFieldsPrev := getPipeMetrics("fields")
+		pmStatsPrev := getPipeMetrics("stats")
+
+		q := mustParseQuery(`"log message" | fields stream-id | stats by (stream-id) count() rows`)
+		writeBlock := func(_ uint, _ []int64, _ []BlockColumn) {}
+		mustRunQuery(t, allTenantIDs, q, writeBlock)
+
+		rowsCount := uint64(tenantsCount * streamsPerTenant * blocksPerStream * rowsPerBlock)
+		pmFields := getPipeMetrics("fields")
+		if n := pmFields.InputRows - pmFieldsPrev.InputRows; n != rowsCount {
+			t.Fatalf("unexpected number of input rows for fields pipe; got %d; want %d", n, rowsCount)
+		}
+		if n := pmFields.OutputRows - pmFieldsPrev.OutputRows; n != rowsCount {
+			t.Fatalf("unexpected number of output rows for fields pipe; got %d; want %d", n, rowsCount)
+		}
+		if n := pmFields.Flushes - pmFieldsPrev.Flushes; n != 1 {
+			t.Fatalf("unexpected number of flushes for fields pipe; got %d; want 1", n)
+		}
+		pmStats := getPipeMetrics("stats")
+		if n := pmStats.OutputRows - pmStatsPrev.OutputRows; n != streamsPerTenant {
+			t.Fatalf("unexpected number of output rows for stats pipe; got %d; want %d", n, streamsPerTenant)
+		}
+		if pmStats.FlushDurationSeconds < pmStatsPrev.FlushDurationSeconds {
+			t.Fatalf("flush duration for stats pipe mustn't decrease; got %v; previous %v", pmStats.FlushDurationSeconds, pmStatsPrev.FlushDurationSeconds)
+		}
+
+		var ss StorageStats
+		s.UpdateStats(&ss)
+		if n := ss.StatsPipeGroups - ssPrev.StatsPipeGroups; n != streamsPerTenant {
+			t.Fatalf("unexpected number of stats pipe groups; got %d; want %d", n, streamsPerTenant)
+		}
+		if ss.ActiveQueries != 0 {
+			t.Fatalf("unexpected number of active queries; got %d; want 0", ss.ActiveQueries)
+		}
+	})
 	t.Run("stream-filter-mismatch", func(t *testing.T) {
 		q := mustParseQuery(`_stream:{job="foobar",instance=~"host-.+:2345"} log`)
 		writeBlock := func(_ uint, timestamps []int64, _ []BlockColumn) {
@@ -230,6 +336,24 @@ func TestStorageRunQuery(t *testing.T) {
 			}
 		}
 	})
+	t.Run("matching-stream-filter-without-prefix", func(t *testing.T) {
+		q := mustParseQuery(`{job="foobar",instance=~"host-[^:]+:234"} log`)
+		tenantID := TenantID{
+			AccountID: 1,
+			ProjectID: 11,
+		}
+		var rowsCountTotal atomic.Uint32
+		writeBlock := func(_ uint, timestamps []int64, _ []BlockColumn) {
+			rowsCountTotal.Add(uint32(len(timestamps)))
+		}
+		tenantIDs := []TenantID{tenantID}
+		mustRunQuery(t, tenantIDs, q, writeBlock)
+
+		expectedRowsCount := streamsPerTenant * blocksPerStream * rowsPerBlock
+		if n := rowsCountTotal.Load(); n != uint32(expectedRowsCount) {
+			t.Fatalf("unexpected number of rows; got %d; want %d", n, expectedRowsCount)
+		}
+	})
 	t.Run("matching-multiple-stream-ids-with-re-filter", func(t *testing.T) {
 		q := mustParseQuery(`_msg:log _stream:{job="foobar",instance=~"host-[^:]+:234"} and re("message [02] at")`)
 		tenantID := TenantID{
@@ -316,6 +440,46 @@ func TestStorageRunQuery(t *testing.T) {
 		tenantIDs := []TenantID{tenantID}
 		mustRunQuery(t, tenantIDs, q, writeBlock)
 	})
+	t.Run("query-timeout", func(t *testing.T) {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+
+		f := func(qStr string) {
+			t.Helper()
+
+			q := mustParseQuery(qStr)
+			writeBlock := func(_ uint, _ []int64, _ []BlockColumn) {}
+			err := s.RunQuery(ctx, allTenantIDs, q, writeBlock)
+			if err == nil {
+				t.Fatalf("expecting non-nil error for the query [%s] with expired deadline", q)
+			}
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("unexpected error for the query [%s]; got %q; want context.DeadlineExceeded", q, err)
+			}
+		}
+
+		f("*")
+		f("* | stats count() rows")
+		f("* | sort by (_time) | uniq by (instance)")
+		f("_msg:in(* | fields _msg)")
+
+		// GetFieldNames must return the error too
+		q := mustParseQuery("*")
+		if _, err := s.GetFieldNames(ctx, allTenantIDs, q); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("unexpected error from GetFieldNames; got %v; want context.DeadlineExceeded", err)
+		}
+	})
+	t.Run("query-canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// Canceled queries must return without errors, since they are canceled on purpose.
+		q := mustParseQuery("* | stats count() rows")
+		writeBlock := func(_ uint, _ []int64, _ []BlockColumn) {}
+		if err := s.RunQuery(ctx, allTenantIDs, q, writeBlock); err != nil {
+			t.Fatalf("unexpected error for canceled query: %s", err)
+		}
+	})
 	t.Run("field_names-all", func(t *testing.T) {
 		q := mustParseQuery("*")
 		results, err := s.GetFieldNames(context.Background(), allTenantIDs, q)
@@ -565,6 +729,16 @@ func TestStorageRunQuery(t *testing.T) {
 			},
 		})
 	})
+	t.Run("per-field-stats", func(t *testing.T) {
+		f(t, `* | stats max(s*) as max_*, min(s*) as min_*`, [][]Field{
+			{
+				{"max_source-file", "/foo/bar/baz"},
+				{"max_stream-id", "stream_id=2"},
+				{"min_source-file", "/foo/bar/baz"},
+				{"min_stream-id", "stream_id=0"},
+			},
+		})
+	})
 	t.Run("in-filter-with-subquery-in-conditional-stats-mismatch", func(t *testing.T) {
 		f(t, `* | stats
 			count() rows_total,
@@ -729,6 +903,442 @@ func TestStorageRunQuery(t *testing.T) {
 			},
 		})
 	})
+	// The number of blocks depends on background merges, so verify only the results, which do not depend on it.
+	t.Run("blocks_count-mismatch", func(t *testing.T) {
+		f(t, `"log message 3" foobar | blocks_count as blocks`, [][]Field{
+			{
+				{"blocks", "0"},
+			},
+		})
+	})
+	t.Run("join-left", func(t *testing.T) {
+		f(t, `tenant.id:2 "log message 3 at block 1"
+			| fields stream-id
+			| join by (stream-id) (tenant.id:3 "log message 5 at block 2" !stream-id:="stream_id=2" | fields stream-id, _msg)`, [][]Field{
+			{
+				{"stream-id", "stream_id=0"},
+				{"_msg", "log message 5 at block 2"},
+			},
+			{
+				{"stream-id", "stream_id=1"},
+				{"_msg", "log message 5 at block 2"},
+			},
+			{
+				{"stream-id", "stream_id=2"},
+			},
+		})
+	})
+	t.Run("join-inner", func(t *testing.T) {
+		f(t, `tenant.id:2 "log message 3 at block 1"
+			| fields stream-id
+			| join by (stream-id) (tenant.id:3 "log message 5 at block 2" !stream-id:="stream_id=2" | fields stream-id, _msg) inner`, [][]Field{
+			{
+				{"stream-id", "stream_id=0"},
+				{"_msg", "log message 5 at block 2"},
+			},
+			{
+				{"stream-id", "stream_id=1"},
+				{"_msg", "log message 5 at block 2"},
+			},
+		})
+	})
+	t.Run("join-max-memory", func(t *testing.T) {
+		q := mustParseQuery(`tenant.id:2 | join by (stream-id) (*)`)
+		q.SetMaxMemory(1000)
+		err := s.RunQuery(context.Background(), allTenantIDs, q, func(_ uint, _ []int64, _ []BlockColumn) {})
+		if err == nil {
+			t.Fatalf("expecting non-nil error when the join results exceed the memory limit")
+		}
+		if !strings.Contains(err.Error(), "exceeds 1000 bytes") {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+	t.Run("max-scanned-rows", func(t *testing.T) {
+		var ssPrev StorageStats
+		s.UpdateStats(&ssPrev)
+
+		q := mustParseQuery(`*`)
+		q.SetMaxScannedRows(100)
+		err := s.RunQuery(context.Background(), allTenantIDs, q, func(_ uint, _ []int64, _ []BlockColumn) {})
+		if err == nil {
+			t.Fatalf("expecting non-nil error when the query exceeds the limit on scanned rows")
+		}
+		if !strings.Contains(err.Error(), "scans more than 100 rows") {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		var ss StorageStats
+		s.UpdateStats(&ss)
+		if n := ss.QueriesAbortedScanLimits - ssPrev.QueriesAbortedScanLimits; n != 1 {
+			t.Fatalf("unexpected number of queries aborted because of scan limits; got %d; want 1", n)
+		}
+
+		// The query must succeed if it doesn't exceed the limit
+		q = mustParseQuery(`* | count() rows`)
+		q.SetMaxScannedRows(10000)
+		var rows []string
+		var rowsLock sync.Mutex
+		mustRunQuery(t, allTenantIDs, q, func(_ uint, _ []int64, columns []BlockColumn) {
+			rowsLock.Lock()
+			rows = append(rows, columns[0].Values...)
+			rowsLock.Unlock()
+		})
+		if !reflect.DeepEqual(rows, []string{"1155"}) {
+			t.Fatalf("unexpected rows; got %q; want %q", rows, []string{"1155"})
+		}
+	})
+	t.Run("max-scanned-bytes", func(t *testing.T) {
+		q := mustParseQuery(`* | fields _msg`)
+		q.SetMaxScannedBytes(100)
+		err := s.RunQuery(context.Background(), allTenantIDs, q, func(_ uint, _ []int64, _ []BlockColumn) {})
+		if err == nil {
+			t.Fatalf("expecting non-nil error when the query exceeds the limit on read bytes")
+		}
+		if !strings.Contains(err.Error(), "reads more than 100 bytes") {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+	t.Run("union", func(t *testing.T) {
+		f(t, `tenant.id:2 "log message 3 at block 1" stream-id:="stream_id=0"
+			| fields _msg, tenant.id
+			| union (tenant.id:3 "log message 5 at block 2" stream-id:="stream_id=1" | fields _msg, stream-id)`, [][]Field{
+			{
+				{"_msg", "log message 3 at block 1"},
+				{"tenant.id", "{accountID=2,projectID=21}"},
+			},
+			{
+				{"_msg", "log message 5 at block 2"},
+				{"stream-id", "stream_id=1"},
+			},
+		})
+	})
+	t.Run("union-stats", func(t *testing.T) {
+		f(t, `tenant.id:2 | stats count() rows
+			| union (tenant.id:3 "log message 5" | stats count() rows)
+			| union (tenant.id:4 | stats count() rows)
+			| stats sum(rows) rows`, [][]Field{
+			{
+				{"rows", "225"},
+			},
+		})
+	})
+	t.Run("blocks_count-non-zero", func(t *testing.T) {
+		f(t, `"log message 3" | blocks_count | filter blocks_count:>0 | stats count() rows`, [][]Field{
+			{
+				{"rows", "1"},
+			},
+		})
+	})
+	t.Run("block_stats-non-zero", func(t *testing.T) {
+		f(t, `"log message 3"
+			| block_stats
+			| stats count() blocks, count() if (matching_rows:>0 rows:>0 columns:>0 uncompressed_size_bytes:>0 compressed_size_bytes:>0) nonzero_blocks
+			| filter blocks:>0
+			| math blocks - nonzero_blocks as zero_blocks
+			| fields zero_blocks`, [][]Field{
+			{
+				{"zero_blocks", "0"},
+			},
+		})
+	})
+	t.Run("block_stats", func(t *testing.T) {
+		f(t, `"log message 3"
+			| block_stats
+			| stats sum(rows) rows, sum(matching_rows) matching_rows`, [][]Field{
+			{
+				{"rows", "1155"},
+				{"matching_rows", "165"},
+			},
+		})
+	})
+
+	// Close the storage and delete its data
+	s.MustClose()
+	fs.MustRemoveAll(path)
+}
+
+func TestStorageRunQueryDictValues(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	sc := &StorageConfig{
+		Retention: 24 * time.Hour,
+	}
+	s := MustOpenStorage(path, sc)
+
+	// Fill the storage with data, which is stored in dict-encoded columns
+	tenantID := TenantID{
+		AccountID: 1,
+		ProjectID: 2,
+	}
+	tenantIDs := []TenantID{tenantID}
+	baseTimestamp := time.Now().UnixNano() - 3600*1e9
+	lr := GetLogRows(nil, nil)
+	for i := 0; i < 100; i++ {
+		level := "info"
+		code := "200"
+		if i%10 == 0 {
+			level = "error"
+			code = "500"
+		}
+		fields := []Field{
+			{
+				Name:  "_msg",
+				Value: fmt.Sprintf("log message %d", i),
+			},
+			{
+				Name:  "level",
+				Value: level,
+			},
+			{
+				Name:  "host",
+				Value: fmt.Sprintf("host-%d", i%4),
+			},
+			{
+				Name:  "code",
+				Value: code,
+			},
+		}
+		lr.MustAdd(tenantID, baseTimestamp+int64(i)*1e9, fields)
+	}
+	s.MustAddRows(lr)
+	PutLogRows(lr)
+	s.debugFlush()
+
+	t.Run("field_values-filtered", func(t *testing.T) {
+		// The filter selects only a part of rows in the block, so dict values from the remaining rows mustn't be returned.
+		q := mustParseQuery("level:error")
+		results, err := s.GetFieldValues(context.Background(), tenantIDs, q, "level", 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		resultsExpected := []ValueWithHits{
+			{"error", 10},
+		}
+		if !reflect.DeepEqual(results, resultsExpected) {
+			t.Fatalf("unexpected result; got\n%v\nwant\n%v", results, resultsExpected)
+		}
+
+		results, err = s.GetFieldValues(context.Background(), tenantIDs, q, "host", 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		resultsExpected = []ValueWithHits{
+			{"host-0", 5},
+			{"host-2", 5},
+		}
+		if !reflect.DeepEqual(results, resultsExpected) {
+			t.Fatalf("unexpected result; got\n%v\nwant\n%v", results, resultsExpected)
+		}
+	})
+	t.Run("uniq-filtered", func(t *testing.T) {
+		q := mustParseQuery("level:error | uniq by (host)")
+		var values []string
+		var valuesLock sync.Mutex
+		writeBlock := func(_ uint, _ []int64, columns []BlockColumn) {
+			valuesLock.Lock()
+			values = append(values, columns[0].Values...)
+			valuesLock.Unlock()
+		}
+		if err := s.RunQuery(context.Background(), tenantIDs, q, writeBlock); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		sort.Strings(values)
+		valuesExpected := []string{"host-0", "host-2"}
+		if !reflect.DeepEqual(values, valuesExpected) {
+			t.Fatalf("unexpected values; got\n%q\nwant\n%q", values, valuesExpected)
+		}
+	})
+	t.Run("top-filtered", func(t *testing.T) {
+		q := mustParseQuery("level:error | top by (host)")
+		var rows []string
+		var rowsLock sync.Mutex
+		writeBlock := func(_ uint, timestamps []int64, columns []BlockColumn) {
+			rowsLock.Lock()
+			for i := range timestamps {
+				rows = append(rows, columns[0].Values[i]+":"+columns[1].Values[i])
+			}
+			rowsLock.Unlock()
+		}
+		if err := s.RunQuery(context.Background(), tenantIDs, q, writeBlock); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		sort.Strings(rows)
+		rowsExpected := []string{"host-0:5", "host-2:5"}
+		if !reflect.DeepEqual(rows, rowsExpected) {
+			t.Fatalf("unexpected rows; got\n%q\nwant\n%q", rows, rowsExpected)
+		}
+	})
+
+	// f verifies that the query returns rowsExpected in the form "field1=value1,...,fieldN=valueN" in any order.
+	f := func(t *testing.T, qStr string, rowsExpected []string) {
+		t.Helper()
+		q := mustParseQuery(qStr)
+		var rows []string
+		var rowsLock sync.Mutex
+		writeBlock := func(_ uint, timestamps []int64, columns []BlockColumn) {
+			rowsLock.Lock()
+			for i := range timestamps {
+				var fields []string
+				for _, c := range columns {
+					fields = append(fields, c.Name+"="+c.Values[i])
+				}
+				rows = append(rows, strings.Join(fields, ","))
+			}
+			rowsLock.Unlock()
+		}
+		if err := s.RunQuery(context.Background(), tenantIDs, q, writeBlock); err != nil {
+			t.Fatalf("unexpected error in the query [%s]: %s", q, err)
+		}
+		sort.Strings(rows)
+		if !reflect.DeepEqual(rows, rowsExpected) {
+			t.Fatalf("unexpected rows for the query [%s]; got\n%q\nwant\n%q", q, rows, rowsExpected)
+		}
+	}
+
+	t.Run("uniq_approx-filtered", func(t *testing.T) {
+		f(t, "level:error | stats uniq_approx(host) x", []string{"x=2"})
+		f(t, "level:error | stats count_uniq(host) x", []string{"x=2"})
+	})
+	t.Run("count_uniq_hash-filtered", func(t *testing.T) {
+		f(t, "level:error | stats count_uniq_hash(host) x", []string{"x=2"})
+		f(t, "level:error | stats by (level) count_uniq_hash(host) x", []string{"level=error,x=2"})
+	})
+	t.Run("stats-by-dict-column", func(t *testing.T) {
+		// Every group must see only the dict values of the host column from its own rows.
+		f(t, `* | stats by (level) count_uniq(host) cu, count_uniq_hash(host) cuh, uniq_approx(host) ua, min(host) mn, max(host) mx,
+			row_min(host, host) rmn, row_max(host, host) rmx, uniq_values(host) uv`, []string{
+			`level=error,cu=2,cuh=2,ua=2,mn=host-0,mx=host-2,rmn={"host":"host-0"},rmx={"host":"host-2"},uv=["host-0","host-2"]`,
+			`level=info,cu=4,cuh=4,ua=4,mn=host-0,mx=host-3,rmn={"host":"host-0"},rmx={"host":"host-3"},uv=["host-0","host-1","host-2","host-3"]`,
+		})
+	})
+	t.Run("sort-without-time-field", func(t *testing.T) {
+		// Rows with equal 'by(...)' fields must be ordered by _time even if the next pipes drop it.
+		fOrdered := func(qStr string, rowsExpected []string) {
+			t.Helper()
+			q := mustParseQuery(qStr)
+			var rows []string
+			var rowsLock sync.Mutex
+			writeBlock := func(_ uint, timestamps []int64, columns []BlockColumn) {
+				rowsLock.Lock()
+				for i := range timestamps {
+					var fields []string
+					for _, c := range columns {
+						fields = append(fields, c.Name+"="+c.Values[i])
+					}
+					rows = append(rows, strings.Join(fields, ","))
+				}
+				rowsLock.Unlock()
+			}
+			if err := s.RunQuery(context.Background(), tenantIDs, q, writeBlock); err != nil {
+				t.Fatalf("unexpected error in the query [%s]: %s", q, err)
+			}
+			if !reflect.DeepEqual(rows, rowsExpected) {
+				t.Fatalf("unexpected rows for the query [%s]; got\n%q\nwant\n%q", q, rows, rowsExpected)
+			}
+		}
+		rowsExpected := []string{
+			"host=host-0",
+			"host=host-2",
+			"host=host-0",
+		}
+		fOrdered("level:error | sort by (level) offset 2 limit 3 | fields host", rowsExpected)
+		fOrdered("level:error | sort by (level) | offset 2 | limit 3 | fields host", rowsExpected)
+		fOrdered("level:error | sort by (level) offset 2 | fields host", []string{
+			"host=host-0",
+			"host=host-2",
+			"host=host-0",
+			"host=host-2",
+			"host=host-0",
+			"host=host-2",
+			"host=host-0",
+			"host=host-2",
+		})
+		fOrdered("level:error | sort by (level) desc offset 2 limit 3 | fields host", []string{
+			"host=host-2",
+			"host=host-0",
+			"host=host-2",
+		})
+	})
+	t.Run("stats-by-uint-column", func(t *testing.T) {
+		f(t, `* | stats by (code) count_uniq(host) cu, min(host) mn, max(host) mx, uniq_values(host) uv`, []string{
+			`code=200,cu=4,mn=host-0,mx=host-3,uv=["host-0","host-1","host-2","host-3"]`,
+			`code=500,cu=2,mn=host-0,mx=host-2,uv=["host-0","host-2"]`,
+		})
+	})
+
+	// Close the storage and delete its data
+	s.MustClose()
+	fs.MustRemoveAll(path)
+}
+
+func TestStorageRunQueryIPv4Values(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	sc := &StorageConfig{
+		Retention: 24 * time.Hour,
+	}
+	s := MustOpenStorage(path, sc)
+
+	// Fill the storage with data, which is stored in ipv4-encoded columns
+	tenantID := TenantID{
+		AccountID: 1,
+		ProjectID: 2,
+	}
+	tenantIDs := []TenantID{tenantID}
+	baseTimestamp := time.Now().UnixNano() - 3600*1e9
+	lr := GetLogRows(nil, nil)
+	for i := 0; i < 100; i++ {
+		fields := []Field{
+			{
+				Name:  "_msg",
+				Value: fmt.Sprintf("log message %d", i),
+			},
+			{
+				Name:  "ip",
+				Value: fmt.Sprintf("10.0.%d.%d", i/10, i%10),
+			},
+		}
+		lr.MustAdd(tenantID, baseTimestamp+int64(i)*1e9, fields)
+	}
+	s.MustAddRows(lr)
+	PutLogRows(lr)
+	s.debugFlush()
+
+	f := func(qStr, resultExpected string) {
+		t.Helper()
+
+		// The 'limit' pipe prevents from pushing down the 'filter' pipe to the storage, so the filter is applied to blockResult.
+		q := mustParseQuery(qStr)
+		var results []string
+		var resultsLock sync.Mutex
+		writeBlock := func(_ uint, _ []int64, columns []BlockColumn) {
+			resultsLock.Lock()
+			results = append(results, columns[0].Values...)
+			resultsLock.Unlock()
+		}
+		if err := s.RunQuery(context.Background(), tenantIDs, q, writeBlock); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		resultsExpected := []string{resultExpected}
+		if !reflect.DeepEqual(results, resultsExpected) {
+			t.Fatalf("unexpected results for [%s]; got\n%q\nwant\n%q", qStr, results, resultsExpected)
+		}
+	}
+
+	// all the values match
+	f("* | limit 1000 | filter ip:ipv4_range(10.0.0.0/16) | count()", "100")
+	f("* | limit 1000 | filter ip:ipv4_range(10.0.0.0, 10.0.9.9) | count()", "100")
+
+	// a part of values match
+	f("* | limit 1000 | filter ip:ipv4_range(10.0.3.0/24) | count()", "10")
+	f("* | limit 1000 | filter ip:ipv4_range(10.0.9.9, 10.0.10.0) | count()", "1")
+
+	// no values match
+	f("* | limit 1000 | filter ip:ipv4_range(10.1.0.0/16) | count()", "0")
 
 	// Close the storage and delete its data
 	s.MustClose()
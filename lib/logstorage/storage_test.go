@@ -104,3 +104,92 @@ func TestStorageMustAddRows(t *testing.T) {
 
 	fs.MustRemoveAll(path)
 }
+
+func TestStorageForceMergePartitions(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	cfg := &StorageConfig{
+		Retention: 30 * 24 * time.Hour,
+	}
+	s := MustOpenStorage(path, cfg)
+
+	// Create multiple parts for every partition.
+	day1 := time.Now().UnixNano()/nsecPerDay - 3
+	day2 := day1 + 1
+	totalRowsCount := uint64(0)
+	for i := 0; i < 5; i++ {
+		for _, day := range []int64{day1, day2} {
+			lr := newTestLogRows(3, 10, int64(i))
+			for j := range lr.timestamps {
+				lr.timestamps[j] = day*nsecPerDay + int64(j)
+			}
+			totalRowsCount += uint64(len(lr.timestamps))
+			s.MustAddRows(lr)
+		}
+		s.debugFlush()
+	}
+
+	getPartsCounts := func() map[string]uint64 {
+		m := make(map[string]uint64)
+		s.partitionsLock.Lock()
+		for _, ptw := range s.partitions {
+			var ds DatadbStats
+			ptw.pt.ddb.updateStats(&ds)
+			m[getTestPartitionName(ptw.day)] = ds.InmemoryParts + ds.SmallParts + ds.BigParts
+		}
+		s.partitionsLock.Unlock()
+		return m
+	}
+	checkRowsCount := func() {
+		t.Helper()
+
+		var ss StorageStats
+		s.UpdateStats(&ss)
+		if n := ss.RowsCount(); n != totalRowsCount {
+			t.Fatalf("unexpected number of rows; got %d; want %d", n, totalRowsCount)
+		}
+	}
+
+	name1 := getTestPartitionName(day1)
+	name2 := getTestPartitionName(day2)
+
+	// Force merge the partition for day1 only
+	s.ForceMergePartitions(name1)
+	checkRowsCount()
+	partsCounts := getPartsCounts()
+	if n := partsCounts[name1]; n != 1 {
+		t.Fatalf("unexpected number of parts for the partition %s after the forced merge; got %d; want 1", name1, n)
+	}
+	if n := partsCounts[name2]; n <= 1 {
+		t.Fatalf("unexpected number of parts for the partition %s, which mustn't be merged; got %d; want more than 1", name2, n)
+	}
+
+	// Force merge all the partitions
+	s.ForceMergePartitions("")
+	checkRowsCount()
+	partsCounts = getPartsCounts()
+	for _, name := range []string{name1, name2} {
+		if n := partsCounts[name]; n != 1 {
+			t.Fatalf("unexpected number of parts for the partition %s after the forced merge; got %d; want 1", name, n)
+		}
+	}
+
+	// Force merge for missing partitions is no-op
+	s.ForceMergePartitions("1999")
+	checkRowsCount()
+
+	s.MustClose()
+
+	// The merged data must be available after re-opening the storage
+	s = MustOpenStorage(path, cfg)
+	checkRowsCount()
+	s.MustClose()
+
+	fs.MustRemoveAll(path)
+}
+
+func getTestPartitionName(day int64) string {
+	return time.Unix(0, day*nsecPerDay).UTC().Format(partitionNameFormat)
+}
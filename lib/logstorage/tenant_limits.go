@@ -0,0 +1,400 @@
+package logstorage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timeutil"
+)
+
+// TenantLimits contains ingestion limits for a single tenant.
+//
+// Zero limits mean no limits.
+//
+// See https://docs.victoriametrics.com/victorialogs/#tenant-limits
+type TenantLimits struct {
+	// MaxRowsPerSecond is the maximum number of log entries the tenant can ingest per second.
+	MaxRowsPerSecond uint64
+
+	// MaxBytesPerSecond is the maximum uncompressed size of log entries the tenant can ingest per second.
+	MaxBytesPerSecond uint64
+
+	// MaxRetainedBytes is the maximum compressed size of log entries, which can be stored for the tenant.
+	//
+	// The compressed size is estimated in the same way as TenantStats.CompressedSizeBytes.
+	MaxRetainedBytes uint64
+}
+
+func (tl *TenantLimits) isZero() bool {
+	return tl.MaxRowsPerSecond == 0 && tl.MaxBytesPerSecond == 0 && tl.MaxRetainedBytes == 0
+}
+
+// String returns string representation for tl.
+func (tl *TenantLimits) String() string {
+	return fmt.Sprintf("rows_per_second=%d,bytes_per_second=%d,retained_bytes=%d", tl.MaxRowsPerSecond, tl.MaxBytesPerSecond, tl.MaxRetainedBytes)
+}
+
+// TenantLimitsOverride overrides the default TenantLimits for the given TenantID.
+type TenantLimitsOverride struct {
+	// TenantID is the tenant to apply Limits to.
+	TenantID TenantID
+
+	// Limits are the limits for the TenantID.
+	Limits TenantLimits
+}
+
+// ParseTenantLimitsOverride parses tenant limits override from s.
+//
+// s must be in the form `accountID:projectID:{name=value,...,name=value}`, where the name can be rows_per_second, bytes_per_second or retained_bytes.
+// Limits missing in s are obtained from defaultLimits.
+func ParseTenantLimitsOverride(s string, defaultLimits *TenantLimits) (*TenantLimitsOverride, error) {
+	n := strings.IndexByte(s, ':')
+	if n < 0 {
+		return nil, fmt.Errorf("missing tenant in %q; it must be in the form `accountID:projectID:{name=value,...,name=value}`", s)
+	}
+	m := strings.IndexByte(s[n+1:], ':')
+	if m < 0 {
+		return nil, fmt.Errorf("missing limits in %q; it must be in the form `accountID:projectID:{name=value,...,name=value}`", s)
+	}
+	tenantStr := s[:n+1+m]
+	limitsStr := s[n+1+m+1:]
+
+	tenantID, err := ParseTenantID(tenantStr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse tenant in %q: %w", s, err)
+	}
+	if !strings.HasPrefix(limitsStr, "{") || !strings.HasSuffix(limitsStr, "}") {
+		return nil, fmt.Errorf("limits in %q must be enclosed in {}", s)
+	}
+	limitsStr = limitsStr[1 : len(limitsStr)-1]
+
+	tlo := &TenantLimitsOverride{
+		TenantID: tenantID,
+		Limits:   *defaultLimits,
+	}
+	for _, kv := range strings.Split(limitsStr, ",") {
+		name, valueStr, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("missing '=' in %q at %q", kv, s)
+		}
+		switch name {
+		case "rows_per_second":
+			v, err := strconv.ParseUint(valueStr, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse rows_per_second in %q: %w", s, err)
+			}
+			tlo.Limits.MaxRowsPerSecond = v
+		case "bytes_per_second":
+			v, ok := tryParseBytes(valueStr)
+			if !ok || v < 0 {
+				return nil, fmt.Errorf("cannot parse bytes_per_second=%q in %q", valueStr, s)
+			}
+			tlo.Limits.MaxBytesPerSecond = uint64(v)
+		case "retained_bytes":
+			v, ok := tryParseBytes(valueStr)
+			if !ok || v < 0 {
+				return nil, fmt.Errorf("cannot parse retained_bytes=%q in %q", valueStr, s)
+			}
+			tlo.Limits.MaxRetainedBytes = uint64(v)
+		default:
+			return nil, fmt.Errorf("unsupported limit %q in %q; supported limits: rows_per_second, bytes_per_second, retained_bytes", name, s)
+		}
+	}
+	return tlo, nil
+}
+
+// String returns string representation for tlo.
+func (tlo *TenantLimitsOverride) String() string {
+	return fmt.Sprintf("%d:%d:{%s}", tlo.TenantID.AccountID, tlo.TenantID.ProjectID, &tlo.Limits)
+}
+
+// tenantLimitReason is the reason for rejecting log entries because of TenantLimits.
+type tenantLimitReason int
+
+const (
+	tenantLimitReasonRowsPerSecond tenantLimitReason = iota
+	tenantLimitReasonBytesPerSecond
+	tenantLimitReasonRetainedBytes
+
+	tenantLimitReasonsCount
+)
+
+var tenantLimitReasonNames = [tenantLimitReasonsCount]string{
+	tenantLimitReasonRowsPerSecond:  "rows_per_second",
+	tenantLimitReasonBytesPerSecond: "bytes_per_second",
+	tenantLimitReasonRetainedBytes:  "retained_bytes",
+}
+
+func (reason tenantLimitReason) String() string {
+	return tenantLimitReasonNames[reason]
+}
+
+// TenantLimitError is returned when the tenant exceeds its TenantLimits.
+type TenantLimitError struct {
+	// TenantID is the tenant, which exceeds the limit.
+	TenantID TenantID
+
+	// Reason is the exceeded limit: rows_per_second, bytes_per_second or retained_bytes.
+	Reason string
+
+	// Limit is the value of the exceeded limit.
+	Limit uint64
+}
+
+// Error implements error interface.
+func (e *TenantLimitError) Error() string {
+	return fmt.Sprintf("reason=%s; the tenant %s exceeds the %s limit=%d; see https://docs.victoriametrics.com/victorialogs/#tenant-limits",
+		e.Reason, &e.TenantID, e.Reason, e.Limit)
+}
+
+// rejectedTenantStats contains stats for the ingestion rejected because of a single tenant limit.
+type rejectedTenantStats struct {
+	requestsCount uint64
+	rowsCount     uint64
+	sizeBytes     uint64
+}
+
+// TenantRejectedStats contains stats for the ingestion rejected because of the tenant limit with the given Reason.
+type TenantRejectedStats struct {
+	// Reason is the exceeded limit: rows_per_second, bytes_per_second or retained_bytes.
+	Reason string
+
+	// Requests is the number of ingestion requests rejected via Storage.CheckTenantLimits.
+	Requests uint64
+
+	// Rows is the number of log entries dropped during data ingestion.
+	Rows uint64
+
+	// Bytes is the uncompressed size of log entries dropped during data ingestion.
+	Bytes uint64
+}
+
+func (s *Storage) initTenantLimits(tl *TenantLimits, tlos []*TenantLimitsOverride) {
+	s.tenantLimits = *tl
+	s.tenantLimitsOverrides = make(map[TenantID]*TenantLimits, len(tlos))
+	for _, tlo := range tlos {
+		limits := tlo.Limits
+		s.tenantLimitsOverrides[tlo.TenantID] = &limits
+	}
+
+	tls := []*TenantLimits{&s.tenantLimits}
+	for _, tl := range s.tenantLimitsOverrides {
+		tls = append(tls, tl)
+	}
+	for _, tl := range tls {
+		if !tl.isZero() {
+			s.hasTenantLimits = true
+		}
+		if tl.MaxRetainedBytes > 0 {
+			s.hasTenantRetainedBytesLimits = true
+		}
+	}
+}
+
+// getTenantLimits returns limits for the given tenantID.
+func (s *Storage) getTenantLimits(tenantID *TenantID) *TenantLimits {
+	if tl := s.tenantLimitsOverrides[*tenantID]; tl != nil {
+		return tl
+	}
+	return &s.tenantLimits
+}
+
+// CheckTenantLimits returns TenantLimitError if the given tenantID already exceeds its limits.
+//
+// It is advised to call CheckTenantLimits before ingesting log entries for the tenantID.
+// Log entries exceeding the limits are dropped by MustAddRows. Such drops aren't reported to the caller,
+// so the next CheckTenantLimits call for the tenantID returns TenantLimitError.
+func (s *Storage) CheckTenantLimits(tenantID TenantID) error {
+	if !s.hasTenantLimits {
+		return nil
+	}
+	tl := s.getTenantLimits(&tenantID)
+	if tl.isZero() {
+		return nil
+	}
+
+	retainedBytes := uint64(0)
+	if tl.MaxRetainedBytes > 0 {
+		retainedBytes = s.getTenantRetainedBytes(tenantID)
+	}
+	currentSecond := fasttime.UnixTimestamp()
+
+	s.ingestedTenantStatsLock.Lock()
+	defer s.ingestedTenantStatsLock.Unlock()
+
+	its := s.getIngestedTenantStatsLocked(tenantID)
+	its.resetCurrentSecondIfNeeded(currentSecond)
+
+	var reason tenantLimitReason
+	var limit uint64
+	switch {
+	case tl.MaxRetainedBytes > 0 && retainedBytes >= tl.MaxRetainedBytes:
+		reason = tenantLimitReasonRetainedBytes
+		limit = tl.MaxRetainedBytes
+	case tl.MaxRowsPerSecond > 0 && its.currentRowsCount >= tl.MaxRowsPerSecond:
+		reason = tenantLimitReasonRowsPerSecond
+		limit = tl.MaxRowsPerSecond
+	case tl.MaxBytesPerSecond > 0 && its.currentSizeBytes >= tl.MaxBytesPerSecond:
+		reason = tenantLimitReasonBytesPerSecond
+		limit = tl.MaxBytesPerSecond
+	default:
+		return nil
+	}
+	its.rejected[reason].requestsCount++
+
+	return &TenantLimitError{
+		TenantID: tenantID,
+		Reason:   reason.String(),
+		Limit:    limit,
+	}
+}
+
+// applyTenantLimits drops log entries from lr for tenants, which exceed their limits.
+//
+// It returns lr if all the log entries are within the limits. Otherwise it returns new LogRows,
+// which must be returned to the pool via PutLogRows() when no longer needed.
+func (s *Storage) applyTenantLimits(lr *LogRows) *LogRows {
+	if !s.hasTenantLimits {
+		return lr
+	}
+
+	currentSecond := fasttime.UnixTimestamp()
+	var lrResult *LogRows
+	rowsDropped := uint64(0)
+
+	s.ingestedTenantStatsLock.Lock()
+	var its *ingestedTenantStats
+	var tl *TenantLimits
+	var retainedBytes uint64
+	var tenantIDLast TenantID
+	for i := range lr.rows {
+		// Log entries are usually grouped by tenant, so cache the limits for the last seen tenant.
+		tenantID := &lr.streamIDs[i].tenantID
+		if its == nil || !tenantID.equal(&tenantIDLast) {
+			its = s.getIngestedTenantStatsLocked(*tenantID)
+			tl = s.getTenantLimits(tenantID)
+			if tl.MaxRetainedBytes > 0 {
+				retainedBytes = s.getTenantRetainedBytes(*tenantID)
+			}
+			tenantIDLast = *tenantID
+		}
+		its.resetCurrentSecondIfNeeded(currentSecond)
+
+		rowSize := uncompressedRowSizeBytes(lr.rows[i])
+		reason := tenantLimitReason(-1)
+		switch {
+		case tl.MaxRetainedBytes > 0 && retainedBytes >= tl.MaxRetainedBytes:
+			reason = tenantLimitReasonRetainedBytes
+		case tl.MaxRowsPerSecond > 0 && its.currentRowsCount+1 > tl.MaxRowsPerSecond:
+			reason = tenantLimitReasonRowsPerSecond
+		case tl.MaxBytesPerSecond > 0 && its.currentSizeBytes+rowSize > tl.MaxBytesPerSecond:
+			reason = tenantLimitReasonBytesPerSecond
+		}
+
+		if reason < 0 {
+			its.currentRowsCount++
+			its.currentSizeBytes += rowSize
+			if lrResult != nil {
+				lrResult.mustAddInternal(lr.streamIDs[i], lr.timestamps[i], lr.rows[i], lr.streamTagsCanonicals[i])
+			}
+			continue
+		}
+
+		rts := &its.rejected[reason]
+		rts.rowsCount++
+		rts.sizeBytes += rowSize
+		rowsDropped++
+		tenantLimitsLogger.Warnf("dropping log entry for the tenant %s because it exceeds the %s limit; see https://docs.victoriametrics.com/victorialogs/#tenant-limits",
+			tenantID, reason)
+
+		if lrResult == nil {
+			lrResult = GetLogRows(nil, nil)
+			for j := 0; j < i; j++ {
+				lrResult.mustAddInternal(lr.streamIDs[j], lr.timestamps[j], lr.rows[j], lr.streamTagsCanonicals[j])
+			}
+		}
+	}
+	s.ingestedTenantStatsLock.Unlock()
+
+	if lrResult == nil {
+		return lr
+	}
+	s.rowsDroppedTenantLimits.Add(rowsDropped)
+	return lrResult
+}
+
+var tenantLimitsLogger = logger.WithThrottler("tenant_limits", 5*time.Second)
+
+// getIngestedTenantStatsLocked returns ingestion stats for the given tenantID.
+//
+// It must be called under ingestedTenantStatsLock.
+func (s *Storage) getIngestedTenantStatsLocked(tenantID TenantID) *ingestedTenantStats {
+	its := s.ingestedTenantStats[tenantID]
+	if its == nil {
+		its = &ingestedTenantStats{}
+		s.ingestedTenantStats[tenantID] = its
+	}
+	return its
+}
+
+// resetCurrentSecondIfNeeded resets per-second ingestion stats at its if they were collected before the currentSecond.
+func (its *ingestedTenantStats) resetCurrentSecondIfNeeded(currentSecond uint64) {
+	if its.currentSecond == currentSecond {
+		return
+	}
+	its.currentSecond = currentSecond
+	its.currentRowsCount = 0
+	its.currentSizeBytes = 0
+}
+
+// getTenantRetainedBytes returns the compressed size of log entries stored for the given tenantID.
+//
+// The returned value is updated periodically by runTenantRetainedBytesWatcher.
+func (s *Storage) getTenantRetainedBytes(tenantID TenantID) uint64 {
+	m := s.tenantRetainedBytes.Load()
+	if m == nil {
+		return 0
+	}
+	return (*m)[tenantID]
+}
+
+func (s *Storage) updateTenantRetainedBytes() {
+	tss := s.GetTenantStats()
+	m := make(map[TenantID]uint64, len(tss))
+	for _, ts := range tss {
+		m[ts.TenantID] = ts.CompressedSizeBytes
+	}
+	s.tenantRetainedBytes.Store(&m)
+}
+
+func (s *Storage) runTenantRetainedBytesWatcher() {
+	if !s.hasTenantRetainedBytesLimits {
+		return
+	}
+	s.updateTenantRetainedBytes()
+
+	s.wg.Add(1)
+	go func() {
+		s.watchTenantRetainedBytes()
+		s.wg.Done()
+	}()
+}
+
+func (s *Storage) watchTenantRetainedBytes() {
+	d := timeutil.AddJitterToDuration(10 * time.Second)
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		s.updateTenantRetainedBytes()
+	}
+}
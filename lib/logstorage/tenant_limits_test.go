@@ -0,0 +1,218 @@
+package logstorage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestParseTenantLimitsOverrideSuccess(t *testing.T) {
+	defaultLimits := &TenantLimits{
+		MaxRowsPerSecond:  10,
+		MaxBytesPerSecond: 20,
+		MaxRetainedBytes:  30,
+	}
+
+	f := func(s, resultExpected string) {
+		t.Helper()
+
+		tlo, err := ParseTenantLimitsOverride(s, defaultLimits)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		result := tlo.String()
+		if result != resultExpected {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	f(`1:2:{rows_per_second=100}`, `1:2:{rows_per_second=100,bytes_per_second=20,retained_bytes=30}`)
+	f(`0:0:{bytes_per_second=1KiB}`, `0:0:{rows_per_second=10,bytes_per_second=1024,retained_bytes=30}`)
+	f(`12:0:{retained_bytes=10GB}`, `12:0:{rows_per_second=10,bytes_per_second=20,retained_bytes=10000000000}`)
+	f(`3:4:{rows_per_second=0,bytes_per_second=1MB,retained_bytes=1GiB}`, `3:4:{rows_per_second=0,bytes_per_second=1000000,retained_bytes=1073741824}`)
+}
+
+func TestParseTenantLimitsOverrideFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+
+		tlo, err := ParseTenantLimitsOverride(s, &TenantLimits{})
+		if err == nil {
+			t.Fatalf("expecting non-nil error; got %s", tlo)
+		}
+	}
+
+	f(``)
+	f(`{rows_per_second=10}`)
+
+	// missing projectID
+	f(`1:{rows_per_second=10}`)
+
+	// invalid tenant
+	f(`foo:0:{rows_per_second=10}`)
+	f(`0:-1:{rows_per_second=10}`)
+
+	// missing limits
+	f(`1:2:`)
+	f(`1:2:{}`)
+	f(`1:2:{rows_per_second}`)
+
+	// missing braces
+	f(`1:2:rows_per_second=10`)
+	f(`1:2:{rows_per_second=10`)
+
+	// invalid limits
+	f(`1:2:{rows_per_second=foo}`)
+	f(`1:2:{rows_per_second=1KB}`)
+	f(`1:2:{bytes_per_second=-1}`)
+	f(`1:2:{retained_bytes=bar}`)
+
+	// unknown limit
+	f(`1:2:{foo=10}`)
+}
+
+func TestStorageTenantLimits(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	tenantIDLimited := TenantID{
+		AccountID: 1,
+	}
+	tenantIDOverride := TenantID{
+		AccountID: 2,
+	}
+	tenantIDRetained := TenantID{
+		AccountID: 3,
+	}
+	sc := &StorageConfig{
+		Retention: 24 * time.Hour,
+		TenantLimits: TenantLimits{
+			MaxRowsPerSecond: 100,
+		},
+		TenantLimitsOverrides: []*TenantLimitsOverride{
+			{
+				TenantID: tenantIDOverride,
+				Limits: TenantLimits{
+					MaxRowsPerSecond: 1e6,
+				},
+			},
+			{
+				TenantID: tenantIDRetained,
+				Limits: TenantLimits{
+					MaxRetainedBytes: 1,
+				},
+			},
+		},
+	}
+	s := MustOpenStorage(path, sc)
+
+	baseTimestamp := time.Now().UnixNano() - 3600*1e9
+	addRows := func(tenantID TenantID, rowsCount int) {
+		t.Helper()
+
+		lr := GetLogRows(nil, nil)
+		for i := 0; i < rowsCount; i++ {
+			fields := []Field{
+				{
+					Name:  "_msg",
+					Value: fmt.Sprintf("message #%d", i),
+				},
+			}
+			lr.MustAdd(tenantID, baseTimestamp+int64(i)*1e6, fields)
+		}
+		s.MustAddRows(lr)
+		PutLogRows(lr)
+	}
+	getTenantStats := func(tenantID TenantID) *TenantStats {
+		t.Helper()
+
+		for _, ts := range s.GetTenantStats() {
+			if ts.TenantID == tenantID {
+				return &ts
+			}
+		}
+		t.Fatalf("missing stats for tenant %s", &tenantID)
+		return nil
+	}
+
+	if err := s.CheckTenantLimits(tenantIDLimited); err != nil {
+		t.Fatalf("unexpected error for the tenant without ingested logs: %s", err)
+	}
+
+	// Log entries exceeding rows_per_second limit must be dropped.
+	// The limit may be applied to two adjacent seconds, so up to 2*limit rows may be accepted.
+	const rowsCount = 1000
+	addRows(tenantIDLimited, rowsCount)
+	ts := getTenantStats(tenantIDLimited)
+	if ts.IngestedRows == 0 || ts.IngestedRows > 2*sc.TenantLimits.MaxRowsPerSecond {
+		t.Fatalf("unexpected number of ingested rows; got %d; want up to %d", ts.IngestedRows, 2*sc.TenantLimits.MaxRowsPerSecond)
+	}
+	if len(ts.Rejected) != 1 {
+		t.Fatalf("unexpected number of rejected stats; got %d; want 1", len(ts.Rejected))
+	}
+	rts := ts.Rejected[0]
+	if rts.Reason != "rows_per_second" {
+		t.Fatalf("unexpected reason; got %q; want %q", rts.Reason, "rows_per_second")
+	}
+	if rts.Rows+ts.IngestedRows != rowsCount {
+		t.Fatalf("unexpected number of rejected rows; got %d; want %d", rts.Rows, rowsCount-ts.IngestedRows)
+	}
+	if rts.Bytes == 0 {
+		t.Fatalf("unexpected zero size of rejected rows")
+	}
+	var ss StorageStats
+	s.UpdateStats(&ss)
+	if ss.RowsDroppedTenantLimits != rts.Rows {
+		t.Fatalf("unexpected number of dropped rows; got %d; want %d", ss.RowsDroppedTenantLimits, rts.Rows)
+	}
+
+	// Other tenants must remain unaffected
+	addRows(tenantIDOverride, rowsCount)
+	ts = getTenantStats(tenantIDOverride)
+	if ts.IngestedRows != rowsCount {
+		t.Fatalf("unexpected number of ingested rows for the tenant with the overridden limits; got %d; want %d", ts.IngestedRows, rowsCount)
+	}
+	if len(ts.Rejected) != 0 {
+		t.Fatalf("unexpected rejected stats for the tenant with the overridden limits: %v", ts.Rejected)
+	}
+	if err := s.CheckTenantLimits(tenantIDOverride); err != nil {
+		t.Fatalf("unexpected error for the tenant with the overridden limits: %s", err)
+	}
+
+	// Log entries must be rejected after the retained size exceeds the limit.
+	addRows(tenantIDRetained, 10)
+	if err := s.CheckTenantLimits(tenantIDRetained); err != nil {
+		t.Fatalf("unexpected error before updating retained bytes: %s", err)
+	}
+	s.updateTenantRetainedBytes()
+	err := s.CheckTenantLimits(tenantIDRetained)
+	var tle *TenantLimitError
+	if !errors.As(err, &tle) {
+		t.Fatalf("expecting TenantLimitError; got %v", err)
+	}
+	if tle.Reason != "retained_bytes" {
+		t.Fatalf("unexpected reason; got %q; want %q", tle.Reason, "retained_bytes")
+	}
+	if tle.TenantID != tenantIDRetained {
+		t.Fatalf("unexpected tenant; got %s; want %s", &tle.TenantID, &tenantIDRetained)
+	}
+	addRows(tenantIDRetained, 10)
+	ts = getTenantStats(tenantIDRetained)
+	if ts.IngestedRows != 10 {
+		t.Fatalf("unexpected number of ingested rows after exceeding retained bytes; got %d; want 10", ts.IngestedRows)
+	}
+	if len(ts.Rejected) != 1 {
+		t.Fatalf("unexpected number of rejected stats; got %d; want 1", len(ts.Rejected))
+	}
+	rts = ts.Rejected[0]
+	if rts.Reason != "retained_bytes" || rts.Requests != 1 || rts.Rows != 10 {
+		t.Fatalf("unexpected rejected stats; got %+v; want reason=retained_bytes, requests=1, rows=10", rts)
+	}
+	s.MustClose()
+
+	fs.MustRemoveAll(path)
+}
@@ -0,0 +1,192 @@
+package logstorage
+
+import (
+	"sort"
+)
+
+// TenantStats contains storage usage stats for a single tenant.
+//
+// TenantStats may be obtained via Storage.GetTenantStats().
+type TenantStats struct {
+	// TenantID is the tenant for the stats.
+	TenantID TenantID
+
+	// IngestedRows is the number of log entries ingested for the tenant since the storage start.
+	IngestedRows uint64
+
+	// IngestedBytes is the uncompressed size of log entries ingested for the tenant since the storage start.
+	IngestedBytes uint64
+
+	// RowsCount is the number of log entries stored for the tenant.
+	RowsCount uint64
+
+	// UncompressedSizeBytes is the uncompressed size of log entries stored for the tenant.
+	UncompressedSizeBytes uint64
+
+	// CompressedSizeBytes is the estimated compressed size of log entries stored for the tenant.
+	//
+	// The compressed size of every part is split among tenants proportionally to the uncompressed size of their log entries in the part.
+	CompressedSizeBytes uint64
+
+	// Rejected contains stats for the ingestion rejected because of TenantLimits.
+	//
+	// Only limits with rejections are present in Rejected.
+	Rejected []TenantRejectedStats
+}
+
+// ingestedTenantStats contains ingestion stats for a single tenant.
+type ingestedTenantStats struct {
+	rowsCount uint64
+	sizeBytes uint64
+
+	// currentSecond is the unix timestamp in seconds for currentRowsCount and currentSizeBytes.
+	//
+	// These stats are used for applying TenantLimits.
+	currentSecond    uint64
+	currentRowsCount uint64
+	currentSizeBytes uint64
+
+	// rejected contains stats for the ingestion rejected because of TenantLimits.
+	rejected [tenantLimitReasonsCount]rejectedTenantStats
+}
+
+// partTenantStats contains stats for log entries of a single tenant in a part.
+type partTenantStats struct {
+	tenantID              TenantID
+	rowsCount             uint64
+	uncompressedSizeBytes uint64
+}
+
+// GetTenantStats returns storage usage stats per each tenant seen at s.
+//
+// The returned stats are sorted by TenantID.
+func (s *Storage) GetTenantStats() []TenantStats {
+	m := make(map[TenantID]*TenantStats)
+	getTenantStats := func(tenantID TenantID) *TenantStats {
+		ts := m[tenantID]
+		if ts == nil {
+			ts = &TenantStats{
+				TenantID: tenantID,
+			}
+			m[tenantID] = ts
+		}
+		return ts
+	}
+
+	s.partitionsLock.Lock()
+	ptws := append([]*partitionWrapper{}, s.partitions...)
+	for _, ptw := range ptws {
+		ptw.incRef()
+	}
+	s.partitionsLock.Unlock()
+
+	for _, ptw := range ptws {
+		ptw.pt.ddb.updateTenantStats(getTenantStats)
+		ptw.decRef()
+	}
+
+	s.ingestedTenantStatsLock.Lock()
+	for tenantID, its := range s.ingestedTenantStats {
+		ts := getTenantStats(tenantID)
+		ts.IngestedRows += its.rowsCount
+		ts.IngestedBytes += its.sizeBytes
+		for reason, rts := range its.rejected {
+			if rts == (rejectedTenantStats{}) {
+				continue
+			}
+			ts.Rejected = append(ts.Rejected, TenantRejectedStats{
+				Reason:   tenantLimitReason(reason).String(),
+				Requests: rts.requestsCount,
+				Rows:     rts.rowsCount,
+				Bytes:    rts.sizeBytes,
+			})
+		}
+	}
+	s.ingestedTenantStatsLock.Unlock()
+
+	result := make([]TenantStats, 0, len(m))
+	for _, ts := range m {
+		result = append(result, *ts)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].TenantID.less(&result[j].TenantID)
+	})
+	return result
+}
+
+// updateIngestedTenantStats registers log entries from lr in per-tenant ingestion stats at s.
+func (s *Storage) updateIngestedTenantStats(lr *LogRows) {
+	s.ingestedTenantStatsLock.Lock()
+	defer s.ingestedTenantStatsLock.Unlock()
+
+	var its *ingestedTenantStats
+	var tenantIDLast TenantID
+	for i := range lr.rows {
+		// Log entries are usually grouped by tenant, so cache the stats for the last seen tenant.
+		tenantID := &lr.streamIDs[i].tenantID
+		if its == nil || !tenantID.equal(&tenantIDLast) {
+			its = s.getIngestedTenantStatsLocked(*tenantID)
+			tenantIDLast = *tenantID
+		}
+		its.rowsCount++
+		its.sizeBytes += uncompressedRowSizeBytes(lr.rows[i])
+	}
+}
+
+// updateTenantStats updates per-tenant stats for the data stored at ddb.
+//
+// getTenantStats must return stats for the given tenantID.
+func (ddb *datadb) updateTenantStats(getTenantStats func(tenantID TenantID) *TenantStats) {
+	ddb.partsLock.Lock()
+	var pws []*partWrapper
+	for _, pwsSrc := range [][]*partWrapper{ddb.inmemoryParts, ddb.smallParts, ddb.bigParts} {
+		pws = append(pws, pwsSrc...)
+	}
+	for _, pw := range pws {
+		pw.incRef()
+	}
+	ddb.partsLock.Unlock()
+
+	for _, pw := range pws {
+		p := pw.p
+		for _, pts := range p.getTenantStats() {
+			ts := getTenantStats(pts.tenantID)
+			ts.RowsCount += pts.rowsCount
+			ts.UncompressedSizeBytes += pts.uncompressedSizeBytes
+			if p.ph.UncompressedSizeBytes > 0 {
+				ts.CompressedSizeBytes += uint64(float64(p.ph.CompressedSizeBytes) * float64(pts.uncompressedSizeBytes) / float64(p.ph.UncompressedSizeBytes))
+			}
+		}
+		pw.decRef()
+	}
+}
+
+// getTenantStats returns per-tenant stats for log entries stored in p.
+//
+// The stats are calculated on the first call and then are cached, since parts are immutable.
+func (p *part) getTenantStats() []partTenantStats {
+	p.tenantStatsOnce.Do(p.initTenantStats)
+	return p.tenantStats
+}
+
+func (p *part) initTenantStats() {
+	var result []partTenantStats
+	bhss := getBlockHeaders()
+	for i := range p.indexBlockHeaders {
+		bhss.bhs = p.indexBlockHeaders[i].mustReadBlockHeaders(bhss.bhs[:0], p)
+		for j := range bhss.bhs {
+			// Blocks are sorted by streamID, so the blocks for the same tenant are located next to each other.
+			bh := &bhss.bhs[j]
+			if len(result) == 0 || !result[len(result)-1].tenantID.equal(&bh.streamID.tenantID) {
+				result = append(result, partTenantStats{
+					tenantID: bh.streamID.tenantID,
+				})
+			}
+			pts := &result[len(result)-1]
+			pts.rowsCount += bh.rowsCount
+			pts.uncompressedSizeBytes += bh.uncompressedSizeBytes
+		}
+	}
+	putBlockHeaders(bhss)
+	p.tenantStats = result
+}
@@ -0,0 +1,115 @@
+package logstorage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestStorageGetTenantStats(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	sc := &StorageConfig{
+		Retention: 24 * time.Hour,
+	}
+	s := MustOpenStorage(path, sc)
+
+	if tss := s.GetTenantStats(); len(tss) != 0 {
+		t.Fatalf("unexpected non-empty tenant stats for empty storage: %v", tss)
+	}
+
+	tenantIDs := []TenantID{
+		{
+			AccountID: 1,
+			ProjectID: 2,
+		},
+		{
+			AccountID: 3,
+		},
+	}
+	rowsCounts := []int{100, 300}
+	sizesExpected := make([]uint64, len(tenantIDs))
+	baseTimestamp := time.Now().UnixNano() - 3600*1e9
+
+	lr := GetLogRows([]string{"host"}, nil)
+	for i, tenantID := range tenantIDs {
+		for j := 0; j < rowsCounts[i]; j++ {
+			fields := []Field{
+				{
+					Name:  "host",
+					Value: fmt.Sprintf("host-%d", j%5),
+				},
+				{
+					Name:  "_msg",
+					Value: fmt.Sprintf("message #%d for tenant %d", j, i),
+				},
+			}
+			lr.MustAdd(tenantID, baseTimestamp+int64(j)*1e6, fields)
+			sizesExpected[i] += uncompressedRowSizeBytes(fields)
+		}
+	}
+	s.MustAddRows(lr)
+	PutLogRows(lr)
+
+	verifyStoredStats := func(tss []TenantStats) {
+		t.Helper()
+
+		if len(tss) != len(tenantIDs) {
+			t.Fatalf("unexpected number of tenant stats; got %d; want %d", len(tss), len(tenantIDs))
+		}
+		var ss StorageStats
+		s.UpdateStats(&ss)
+		compressedSizeMax := ss.CompressedInmemorySize + ss.CompressedSmallPartSize + ss.CompressedBigPartSize
+		compressedSizeTotal := uint64(0)
+		for i, ts := range tss {
+			if ts.TenantID != tenantIDs[i] {
+				t.Fatalf("unexpected tenant #%d; got %s; want %s", i, &ts.TenantID, &tenantIDs[i])
+			}
+			if ts.RowsCount != uint64(rowsCounts[i]) {
+				t.Fatalf("unexpected number of stored rows for tenant %s; got %d; want %d", &ts.TenantID, ts.RowsCount, rowsCounts[i])
+			}
+			if ts.UncompressedSizeBytes != sizesExpected[i] {
+				t.Fatalf("unexpected uncompressed size for tenant %s; got %d; want %d", &ts.TenantID, ts.UncompressedSizeBytes, sizesExpected[i])
+			}
+			if ts.CompressedSizeBytes == 0 {
+				t.Fatalf("unexpected zero compressed size for tenant %s", &ts.TenantID)
+			}
+			compressedSizeTotal += ts.CompressedSizeBytes
+		}
+		if compressedSizeTotal > compressedSizeMax {
+			t.Fatalf("the total compressed size for tenants cannot exceed the storage size; got %d; want up to %d", compressedSizeTotal, compressedSizeMax)
+		}
+		if tss[0].CompressedSizeBytes >= tss[1].CompressedSizeBytes {
+			t.Fatalf("the compressed size for the tenant with less data must be smaller; got %d vs %d", tss[0].CompressedSizeBytes, tss[1].CompressedSizeBytes)
+		}
+	}
+
+	tss := s.GetTenantStats()
+	verifyStoredStats(tss)
+	for i, ts := range tss {
+		if ts.IngestedRows != uint64(rowsCounts[i]) {
+			t.Fatalf("unexpected number of ingested rows for tenant %s; got %d; want %d", &ts.TenantID, ts.IngestedRows, rowsCounts[i])
+		}
+		if ts.IngestedBytes != sizesExpected[i] {
+			t.Fatalf("unexpected ingested bytes for tenant %s; got %d; want %d", &ts.TenantID, ts.IngestedBytes, sizesExpected[i])
+		}
+	}
+
+	// Ingestion stats are reset after the restart, while stored stats must remain the same
+	s.MustClose()
+	s = MustOpenStorage(path, sc)
+	tss = s.GetTenantStats()
+	verifyStoredStats(tss)
+	for _, ts := range tss {
+		if ts.IngestedRows != 0 || ts.IngestedBytes != 0 {
+			t.Fatalf("unexpected non-zero ingestion stats for tenant %s after the restart; rows=%d, bytes=%d", &ts.TenantID, ts.IngestedRows, ts.IngestedBytes)
+		}
+	}
+	s.MustClose()
+
+	fs.MustRemoveAll(path)
+}
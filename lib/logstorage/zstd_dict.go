@@ -0,0 +1,197 @@
+package logstorage
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+const (
+	// minZSTDDictTrainingDataSize is the minimum size of string values needed for training ZSTD dictionary.
+	minZSTDDictTrainingDataSize = 256 * 1024
+
+	// maxZSTDDictContentSize is the maximum size of the content for ZSTD dictionary.
+	maxZSTDDictContentSize = 64 * 1024
+
+	// maxZSTDDictSampleSize is the maximum size of a single sample used for training ZSTD dictionary.
+	maxZSTDDictSampleSize = 16 * 1024
+
+	// maxZSTDDictTrainingValues is the maximum number of unique values tracked during ZSTD dictionary training.
+	maxZSTDDictTrainingValues = 100_000
+)
+
+// getZSTDDict returns ZSTD dictionary for pt.
+//
+// nil is returned if pt has no ZSTD dictionary.
+func (pt *partition) getZSTDDict() *zstd.Dict {
+	if pt == nil {
+		return nil
+	}
+	return pt.zstdDict.Load()
+}
+
+// mustGetZSTDDictForPart returns ZSTD dictionary for the part with the given ph located at the given path.
+//
+// nil is returned if the part doesn't use ZSTD dictionary.
+func (pt *partition) mustGetZSTDDictForPart(ph *partHeader, path string) *zstd.Dict {
+	if ph.ZSTDDictID == 0 {
+		return nil
+	}
+	zd := pt.getZSTDDict()
+	mustCheckZSTDDict(ph, zd, path)
+	return zd
+}
+
+// mustCheckZSTDDict verifies whether zd can be used for reading the part with the given ph located at the given path.
+func mustCheckZSTDDict(ph *partHeader, zd *zstd.Dict, path string) {
+	if ph.ZSTDDictID == 0 {
+		return
+	}
+	if zd == nil {
+		logger.Panicf("FATAL: %s: missing ZSTD dictionary with id=%d; make sure %q file exists in the partition directory", path, ph.ZSTDDictID, zstdDictFilename)
+	}
+	if zd.ID() != ph.ZSTDDictID {
+		logger.Panicf("FATAL: %s: unexpected ZSTD dictionary id=%d; want %d", path, zd.ID(), ph.ZSTDDictID)
+	}
+}
+
+// mustLoadZSTDDict loads ZSTD dictionary for pt from disk if it exists.
+func (pt *partition) mustLoadZSTDDict() {
+	path := filepath.Join(pt.path, zstdDictFilename)
+	if !fs.IsPathExist(path) {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger.Panicf("FATAL: cannot read ZSTD dictionary: %s", err)
+	}
+	zd, err := zstd.NewDict(data)
+	if err != nil {
+		logger.Panicf("FATAL: cannot load ZSTD dictionary from %q: %s", path, err)
+	}
+	pt.zstdDict.Store(zd)
+}
+
+// mustTrainZSTDDictIfNeeded trains ZSTD dictionary for pt on string values from lr if pt has no ZSTD dictionary yet.
+//
+// The dictionary is trained only once per partition, since all the parts in the partition must use the same dictionary.
+// This allows merging parts without the need to re-compress string values.
+func (pt *partition) mustTrainZSTDDictIfNeeded(lr *LogRows) {
+	if pt.zstdDict.Load() != nil || pt.zstdDictTrainingFailed.Load() {
+		return
+	}
+	if !pt.zstdDictLock.TryLock() {
+		// Another goroutine is training the dictionary. Do not wait for it in order to avoid slowing down data ingestion.
+		return
+	}
+	defer pt.zstdDictLock.Unlock()
+
+	if pt.zstdDict.Load() != nil {
+		return
+	}
+
+	content, samples := getZSTDDictTrainingData(lr)
+	if len(content) == 0 {
+		// There is no enough data for training the dictionary. Try again on the next rows.
+		return
+	}
+
+	// Generate dictionary id from the private range [2^15 .. 2^31) according to https://github.com/facebook/zstd/blob/dev/doc/zstd_compression_format.md#dictionary_id
+	h := xxhash.Sum64(content)
+	id := uint32(h%(1<<31-1<<15)) + 1<<15
+
+	zd, err := zstd.BuildDict(id, content, samples)
+	if err != nil {
+		logger.Warnf("cannot train ZSTD dictionary for partition %q: %s; string values in this partition will be compressed without the dictionary", pt.path, err)
+		pt.zstdDictTrainingFailed.Store(true)
+		return
+	}
+
+	// Persist the dictionary before making it visible to writers, since the created parts cannot be read without the dictionary.
+	path := filepath.Join(pt.path, zstdDictFilename)
+	fs.MustWriteAtomic(path, zd.Data(), false)
+	pt.zstdDict.Store(zd)
+
+	logger.Infof("trained ZSTD dictionary id=%d with the size %d bytes for partition %q", zd.ID(), len(zd.Data()), pt.path)
+}
+
+// getZSTDDictTrainingData returns content and samples for training ZSTD dictionary on string values from lr.
+//
+// Empty content is returned if lr contains no enough data for training.
+func getZSTDDictTrainingData(lr *LogRows) ([]byte, [][]byte) {
+	// Collect per-field samples, since values for every field are compressed individually.
+	samplesByField := make(map[string][]byte)
+	var samples [][]byte
+	valueHits := make(map[string]int)
+	dataSize := 0
+	for _, fields := range lr.rows {
+		for _, f := range fields {
+			v := f.Value
+			if v == "" {
+				continue
+			}
+			dataSize += len(v)
+
+			sample := append(samplesByField[f.Name], v...)
+			if len(sample) >= maxZSTDDictSampleSize {
+				samples = append(samples, sample)
+				sample = nil
+			}
+			samplesByField[f.Name] = sample
+
+			if _, ok := valueHits[v]; ok || len(valueHits) < maxZSTDDictTrainingValues {
+				valueHits[v]++
+			}
+		}
+	}
+	if dataSize < minZSTDDictTrainingDataSize {
+		return nil, nil
+	}
+	for _, sample := range samplesByField {
+		if len(sample) > 0 {
+			samples = append(samples, sample)
+		}
+	}
+
+	// Put the most frequently seen values into the dictionary content.
+	values := make([]string, 0, len(valueHits))
+	for v, hits := range valueHits {
+		if hits > 1 {
+			values = append(values, v)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		a, b := values[i], values[j]
+		scoreA, scoreB := valueHits[a]*len(a), valueHits[b]*len(b)
+		if scoreA != scoreB {
+			return scoreA > scoreB
+		}
+		return a < b
+	})
+	contentSize := 0
+	selectedValues := values[:0]
+	for _, v := range values {
+		if contentSize+len(v) > maxZSTDDictContentSize {
+			continue
+		}
+		contentSize += len(v)
+		selectedValues = append(selectedValues, v)
+	}
+	values = selectedValues
+
+	// The most frequently seen values must be put at the end of the content, since they are referred by the smallest offsets.
+	content := make([]byte, 0, contentSize)
+	for i := len(values) - 1; i >= 0; i-- {
+		content = append(content, values[i]...)
+	}
+	if len(content) < 8 {
+		return nil, nil
+	}
+	return content, samples
+}
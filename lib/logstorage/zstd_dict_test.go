@@ -0,0 +1,239 @@
+package logstorage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestGetZSTDDictTrainingData(t *testing.T) {
+	newLogRows := func(rowsCount int) *LogRows {
+		lr := GetLogRows(nil, nil)
+		for i := 0; i < rowsCount; i++ {
+			fields := []Field{
+				{
+					Name:  "_msg",
+					Value: fmt.Sprintf("GET /api/v1/users/%d HTTP/1.1", i),
+				},
+				{
+					Name:  "user_agent",
+					Value: fmt.Sprintf("Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%d.0.0.0 Safari/537.36", i%3),
+				},
+			}
+			lr.MustAdd(TenantID{}, int64(i), fields)
+		}
+		return lr
+	}
+
+	// Too small amounts of data
+	lr := newLogRows(10)
+	content, samples := getZSTDDictTrainingData(lr)
+	PutLogRows(lr)
+	if len(content) > 0 || len(samples) > 0 {
+		t.Fatalf("unexpected non-empty training data for small amounts of data; content=%q, samples=%d", content, len(samples))
+	}
+
+	// Enough data for training
+	lr = newLogRows(5000)
+	content, samples = getZSTDDictTrainingData(lr)
+	PutLogRows(lr)
+	if len(content) > maxZSTDDictContentSize {
+		t.Fatalf("too big content: %d bytes; mustn't exceed %d bytes", len(content), maxZSTDDictContentSize)
+	}
+	if len(samples) == 0 {
+		t.Fatalf("expecting non-empty samples")
+	}
+	for _, sample := range samples {
+		if len(sample) > maxZSTDDictSampleSize+1024 {
+			t.Fatalf("too big sample: %d bytes", len(sample))
+		}
+	}
+
+	// The content must contain only repeated values. The most frequently seen values must be located at the end of the content.
+	contentExpected := ""
+	for i := 2; i >= 0; i-- {
+		contentExpected += fmt.Sprintf("Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%d.0.0.0 Safari/537.36", i)
+	}
+	if string(content) != contentExpected {
+		t.Fatalf("unexpected content\ngot\n%q\nwant\n%q", content, contentExpected)
+	}
+}
+
+func TestStorageZSTDDicts(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	tenantID := TenantID{
+		AccountID: 1,
+		ProjectID: 2,
+	}
+	tenantIDs := []TenantID{tenantID}
+	baseTimestamp := time.Now().UnixNano() - 3600*1e9
+
+	addRows := func(s *Storage, offset, rowsCount int) {
+		lr := GetLogRows([]string{"host"}, nil)
+		for i := offset; i < offset+rowsCount; i++ {
+			fields := []Field{
+				{
+					Name:  "_msg",
+					Value: fmt.Sprintf("GET /api/v1/users/%d HTTP/1.1", i),
+				},
+				{
+					Name:  "host",
+					Value: fmt.Sprintf("host-%d", i%100),
+				},
+				{
+					Name:  "user_agent",
+					Value: fmt.Sprintf("Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%d.0.0.0 Safari/537.36", i%3),
+				},
+			}
+			lr.MustAdd(tenantID, baseTimestamp+int64(i)*1e6, fields)
+		}
+		s.MustAddRows(lr)
+		PutLogRows(lr)
+		s.debugFlush()
+	}
+
+	f := func(s *Storage, qStr string, resultsExpected []string) {
+		t.Helper()
+
+		q := mustParseQuery(qStr)
+		var results []string
+		var resultsLock sync.Mutex
+		writeBlock := func(_ uint, _ []int64, columns []BlockColumn) {
+			resultsLock.Lock()
+			results = append(results, columns[0].Values...)
+			resultsLock.Unlock()
+		}
+		if err := s.RunQuery(context.Background(), tenantIDs, q, writeBlock); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(results, resultsExpected) {
+			t.Fatalf("unexpected results for [%s]; got\n%q\nwant\n%q", qStr, results, resultsExpected)
+		}
+	}
+
+	verifyData := func(s *Storage, rowsCount int) {
+		t.Helper()
+
+		f(s, "* | count()", []string{fmt.Sprintf("%d", rowsCount)})
+		f(s, `user_agent:"Chrome/1.0.0.0" | count()`, []string{fmt.Sprintf("%d", (rowsCount+1)/3)})
+		f(s, `"users/7 " | fields _msg`, []string{"GET /api/v1/users/7 HTTP/1.1"})
+		f(s, `user_agent:"Chrome/2.0.0.0" | uniq by (user_agent)`, []string{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/2.0.0.0 Safari/537.36"})
+	}
+
+	sc := &StorageConfig{
+		Retention:    24 * time.Hour,
+		UseZSTDDicts: true,
+	}
+	s := MustOpenStorage(path, sc)
+
+	// Too small amounts of data for training the dictionary
+	addRows(s, 0, 10)
+	verifyData(s, 10)
+	dictPaths, err := filepath.Glob(filepath.Join(path, partitionsDirname, "*", zstdDictFilename))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(dictPaths) != 0 {
+		t.Fatalf("unexpected ZSTD dictionaries created: %q", dictPaths)
+	}
+
+	// Enough data for training the dictionary
+	addRows(s, 10, 5000)
+	addRows(s, 5010, 1000)
+	verifyData(s, 6010)
+	dictPaths, err = filepath.Glob(filepath.Join(path, partitionsDirname, "*", zstdDictFilename))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(dictPaths) != 1 {
+		t.Fatalf("expecting a single ZSTD dictionary; got %q", dictPaths)
+	}
+
+	// Make sure the parts created after the dictionary training use the dictionary
+	var dictParts, noDictParts int
+	s.partitionsLock.Lock()
+	for _, ptw := range s.partitions {
+		ddb := ptw.pt.ddb
+		ddb.partsLock.Lock()
+		for _, pws := range [][]*partWrapper{ddb.inmemoryParts, ddb.smallParts, ddb.bigParts} {
+			for _, pw := range pws {
+				if pw.p.ph.ZSTDDictID != 0 {
+					dictParts++
+				} else {
+					noDictParts++
+				}
+				if pw.p.ph.FormatVersion != partFormatLatestVersion {
+					t.Fatalf("unexpected part format version; got %d; want %d", pw.p.ph.FormatVersion, partFormatLatestVersion)
+				}
+			}
+		}
+		ddb.partsLock.Unlock()
+	}
+	s.partitionsLock.Unlock()
+	if dictParts == 0 {
+		t.Fatalf("expecting at least a single part with ZSTD dictionary; got %d parts without dictionary", noDictParts)
+	}
+
+	s.MustClose()
+
+	// Re-open the storage with disabled dictionaries. The previously trained dictionary must be used for reading and writing the data
+	sc.UseZSTDDicts = false
+	s = MustOpenStorage(path, sc)
+	verifyData(s, 6010)
+	addRows(s, 6010, 100)
+	verifyData(s, 6110)
+	s.MustClose()
+
+	// Re-open the storage and verify the data again
+	s = MustOpenStorage(path, sc)
+	verifyData(s, 6110)
+	s.MustClose()
+
+	fs.MustRemoveAll(path)
+}
+
+func TestStorageZSTDDictsDisabled(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	sc := &StorageConfig{
+		Retention: 24 * time.Hour,
+	}
+	s := MustOpenStorage(path, sc)
+
+	lr := GetLogRows(nil, nil)
+	for i := 0; i < 5000; i++ {
+		fields := []Field{
+			{
+				Name:  "_msg",
+				Value: strings.Repeat("foo bar ", 10) + fmt.Sprintf("%d", i),
+			},
+		}
+		lr.MustAdd(TenantID{}, time.Now().UnixNano(), fields)
+	}
+	s.MustAddRows(lr)
+	PutLogRows(lr)
+	s.debugFlush()
+
+	dictPaths, err := filepath.Glob(filepath.Join(path, partitionsDirname, "*", zstdDictFilename))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(dictPaths) != 0 {
+		t.Fatalf("unexpected ZSTD dictionaries created: %q", dictPaths)
+	}
+
+	s.MustClose()
+	fs.MustRemoveAll(path)
+}
@@ -97,27 +97,29 @@ func (r *Regex) MatchString(s string) bool {
 // GetLiterals returns literals for r.
 func (r *Regex) GetLiterals() []string {
 	sre := mustParseRegexp(r.exprStr)
-	for sre.Op == syntax.OpCapture {
-		sre = sre.Sub[0]
-	}
+	return appendLiterals(nil, sre)
+}
 
+// appendLiterals appends literals, which must be contained in every string matching sre, to dst and returns the result.
+func appendLiterals(dst []string, sre *syntax.Regexp) []string {
 	v, ok := getLiteral(sre)
 	if ok {
-		return []string{v}
+		return append(dst, v)
 	}
 
-	if sre.Op != syntax.OpConcat {
-		return nil
-	}
-
-	var a []string
-	for _, sub := range sre.Sub {
-		v, ok := getLiteral(sub)
-		if ok {
-			a = append(a, v)
+	switch sre.Op {
+	case syntax.OpCapture, syntax.OpPlus:
+		return appendLiterals(dst, sre.Sub[0])
+	case syntax.OpRepeat:
+		if sre.Min > 0 {
+			return appendLiterals(dst, sre.Sub[0])
+		}
+	case syntax.OpConcat:
+		for _, sub := range sre.Sub {
+			dst = appendLiterals(dst, sub)
 		}
 	}
-	return a
+	return dst
 }
 
 // String returns string represetnation for r
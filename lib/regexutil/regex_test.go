@@ -170,4 +170,13 @@ func TestGetLiterals(t *testing.T) {
 	f("((foo|bar)baz xxx(?:yzabc))", []string{"baz xxxyzabc"})
 	f("((foo|bar)baz xxx(?:yzabc)*)", []string{"baz xxx"})
 	f("((foo|bar)baz? xxx(?:yzabc)*)", []string{"ba", " xxx"})
+
+	// literals inside nested groups and repetitions
+	f("(error).*timeout", []string{"error", "timeout"})
+	f("x(foo.*bar)y", []string{"x", "foo", "bar", "y"})
+	f("a=(foo[0-9]+)+", []string{"a=", "foo"})
+	f("(foo bar){2,3}", []string{"foo bar"})
+	f("(foo bar){0,3}baz", []string{"baz"})
+	f("(foo bar)*baz", []string{"baz"})
+	f("(?:foo(?i)bar)+", []string{"foo"})
 }